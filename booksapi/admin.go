@@ -0,0 +1,252 @@
+package booksapi
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"time"
+)
+
+// verifyReport is the response body of GET /admin/verify.
+type verifyReport struct {
+	Books      int      `json:"books"`
+	Violations []string `json:"violations"`
+	DurationMS float64  `json:"duration_ms"`
+}
+
+// reindexReport is the response body of POST /admin/reindex.
+type reindexReport struct {
+	Books      int     `json:"books"`
+	Removed    int     `json:"removed"`
+	DurationMS float64 `json:"duration_ms"`
+}
+
+// adminVerifyHandler checks the invariants linking books and the data kept
+// about them, and reports every violation without fixing it
+// (GET /admin/verify).
+func adminVerifyHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeError(w, r, http.StatusMethodNotAllowed, "method_not_allowed")
+		return
+	}
+	if _, ok := checkQuery(w, r); !ok {
+		return
+	}
+
+	start := time.Now()
+	mu.Lock()
+	defer mu.Unlock()
+
+	report := verifyReport{Books: store.Count(), Violations: verifyCatalog()}
+	report.DurationMS = millisecondsSince(start)
+
+	writeJSON(w, http.StatusOK, report)
+}
+
+// adminReindexHandler rebuilds the data derived from the books, dropping
+// entries about books that no longer exist (POST /admin/reindex).
+func adminReindexHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeError(w, r, http.StatusMethodNotAllowed, "method_not_allowed")
+		return
+	}
+	if _, ok := checkQuery(w, r); !ok {
+		return
+	}
+
+	start := time.Now()
+	mu.Lock()
+	defer mu.Unlock()
+
+	report := reindexReport{Books: store.Count()}
+	report.Removed += reindexFavorites()
+	report.Removed += reindexShelves()
+	report.Removed += reindexCovers()
+	report.DurationMS = millisecondsSince(start)
+
+	writeJSON(w, http.StatusOK, report)
+}
+
+// VerifiableStore is implemented by stores that can check the invariants
+// of their own data, such as the indexes they keep. GET /admin/verify
+// reports the violations they find.
+type VerifiableStore interface {
+	// Verify returns a description of every violation found.
+	Verify() []string
+}
+
+// verifyCatalog returns every violation of the invariants linking books
+// and the data kept about them. Callers must hold mu.
+func verifyCatalog() []string {
+	list := store.List(ListOptions{})
+	violations := []string{}
+	if verifiable, ok := findStore[VerifiableStore](store); ok {
+		v := verifiable.Verify()
+		sort.Strings(v)
+		violations = append(violations, v...)
+	}
+	violations = append(violations, verifyBooks(list)...)
+	violations = append(violations, verifyFavorites()...)
+	violations = append(violations, verifyShelves()...)
+	violations = append(violations, verifyCovers()...)
+	return violations
+}
+
+// verifyBooks checks the store's book count and ID counter, and each
+// book's price, references, and series volume. Callers must hold mu.
+func verifyBooks(list []Book) []string {
+	var violations []string
+	if count := store.Count(); count != len(list) {
+		violations = append(violations, fmt.Sprintf("store counts %d books, lists %d", count, len(list)))
+	}
+	if n := len(list); n > 0 && store.NextID() <= list[n-1].ID {
+		violations = append(violations, fmt.Sprintf("next ID %d does not exceed highest book ID %d", store.NextID(), list[n-1].ID))
+	}
+
+	type volume struct{ series, index int }
+	claimed := make(map[volume]int)
+	for _, book := range list {
+		if book.Price < 0 {
+			violations = append(violations, fmt.Sprintf("book %d has negative price %v", book.ID, book.Price))
+		}
+		if book.PublisherID != nil && !publisherExists(*book.PublisherID) {
+			violations = append(violations, fmt.Sprintf("book %d refers to missing publisher %d", book.ID, *book.PublisherID))
+		}
+		if book.SeriesID == nil {
+			continue
+		}
+		if !seriesExists(*book.SeriesID) {
+			violations = append(violations, fmt.Sprintf("book %d refers to missing series %d", book.ID, *book.SeriesID))
+		}
+		v := volume{*book.SeriesID, book.SeriesIndex}
+		if other, taken := claimed[v]; taken {
+			violations = append(violations, fmt.Sprintf("books %d and %d are both volume %d of series %d", other, book.ID, v.index, v.series))
+		}
+		claimed[v] = book.ID
+	}
+	return violations
+}
+
+// verifyFavorites checks that favorites refer to existing books and that
+// the favorite counts match them. Callers must hold mu.
+func verifyFavorites() []string {
+	favoritesMu.Lock()
+	defer favoritesMu.Unlock()
+
+	var violations []string
+	counts := make(map[int]int)
+	for user, set := range favorites {
+		for bookID := range set {
+			counts[bookID]++
+			if _, found := store.Get(bookID); !found {
+				violations = append(violations, fmt.Sprintf("user %q favorites missing book %d", user, bookID))
+			}
+		}
+	}
+	for bookID, count := range favoriteCounts {
+		if counts[bookID] != count {
+			violations = append(violations, fmt.Sprintf("book %d has favorite count %d, want %d", bookID, count, counts[bookID]))
+		}
+	}
+	for bookID, count := range counts {
+		if _, found := favoriteCounts[bookID]; !found {
+			violations = append(violations, fmt.Sprintf("book %d has favorite count 0, want %d", bookID, count))
+		}
+	}
+	sort.Strings(violations)
+	return violations
+}
+
+// verifyShelves checks that shelves hold only existing books. Callers must
+// hold mu.
+func verifyShelves() []string {
+	shelvesMu.Lock()
+	defer shelvesMu.Unlock()
+
+	var violations []string
+	for shelfID, members := range shelfBooks {
+		for bookID := range members {
+			if _, found := store.Get(bookID); !found {
+				violations = append(violations, fmt.Sprintf("shelf %d holds missing book %d", shelfID, bookID))
+			}
+		}
+	}
+	sort.Strings(violations)
+	return violations
+}
+
+// verifyCovers checks that covers belong to existing books. Callers must
+// hold mu.
+func verifyCovers() []string {
+	coversMu.Lock()
+	defer coversMu.Unlock()
+
+	var violations []string
+	for bookID := range covers {
+		if _, found := store.Get(bookID); !found {
+			violations = append(violations, fmt.Sprintf("cover stored for missing book %d", bookID))
+		}
+	}
+	sort.Strings(violations)
+	return violations
+}
+
+// reindexFavorites drops favorites of missing books and recounts the rest,
+// returning the number of favorites dropped. Callers must hold mu.
+func reindexFavorites() int {
+	favoritesMu.Lock()
+	defer favoritesMu.Unlock()
+
+	removed := 0
+	favoriteCounts = make(map[int]int)
+	for _, set := range favorites {
+		for bookID := range set {
+			if _, found := store.Get(bookID); !found {
+				delete(set, bookID)
+				removed++
+				continue
+			}
+			favoriteCounts[bookID]++
+		}
+	}
+	return removed
+}
+
+// reindexShelves takes missing books off every shelf, returning the number
+// of entries removed. Callers must hold mu.
+func reindexShelves() int {
+	shelvesMu.Lock()
+	defer shelvesMu.Unlock()
+
+	removed := 0
+	for _, members := range shelfBooks {
+		for bookID := range members {
+			if _, found := store.Get(bookID); !found {
+				delete(members, bookID)
+				removed++
+			}
+		}
+	}
+	return removed
+}
+
+// reindexCovers drops the covers of missing books, returning the number
+// removed. Callers must hold mu.
+func reindexCovers() int {
+	coversMu.Lock()
+	defer coversMu.Unlock()
+
+	removed := 0
+	for bookID := range covers {
+		if _, found := store.Get(bookID); !found {
+			delete(covers, bookID)
+			removed++
+		}
+	}
+	return removed
+}
+
+// millisecondsSince returns the time elapsed since start in milliseconds.
+func millisecondsSince(start time.Time) float64 {
+	return float64(time.Since(start).Microseconds()) / 1000
+}
@@ -0,0 +1,102 @@
+package booksapi
+
+import (
+	"net/http"
+	"slices"
+	"testing"
+)
+
+// corruptIndexes leaves the data kept about books pointing at books that
+// do not exist, and the favorite counts out of step with the favorites, as
+// a bug or a crash in between writes might.
+func corruptIndexes(t *testing.T) {
+	t.Helper()
+	favoritesMu.Lock()
+	favorites["ann"] = map[int]struct{}{1: {}, 99: {}}
+	favoriteCounts[1], favoriteCounts[99], favoriteCounts[2] = 1, 1, 5
+	favoritesMu.Unlock()
+	shelvesMu.Lock()
+	shelfBooks[1] = map[int]struct{}{3: {}, 98: {}}
+	shelvesMu.Unlock()
+	coversMu.Lock()
+	covers[97] = coverInfo{}
+	coversMu.Unlock()
+}
+
+// TestAdminVerify corrupts the indexes and references of a catalog, and
+// checks that GET /admin/verify reports each violation, and that POST
+// /admin/reindex repairs the ones derived from the books.
+func TestAdminVerify(t *testing.T) {
+	h := newTestServer(t)
+	for _, title := range []string{"Dune", "Emma", "Jazz"} {
+		mustCreateBook(t, h, map[string]any{"title": title})
+	}
+	rec := serve(t, h, http.MethodGet, "/admin/verify", nil)
+	wantCode(t, rec, http.StatusOK)
+	if got := decode[verifyReport](t, rec); got.Books != 3 || len(got.Violations) != 0 {
+		t.Fatalf("report of a sound catalog %+v", got)
+	}
+
+	publisher, series := 7, 8
+	for _, book := range []Book{
+		{ID: 4, Title: "Beloved", PublisherID: &publisher, Price: -1},
+		{ID: 5, Title: "Hyperion", SeriesID: &series, SeriesIndex: 1},
+		{ID: 6, Title: "Endymion", SeriesID: &series, SeriesIndex: 1},
+	} {
+		if err := store.Put(book); err != nil {
+			t.Fatal(err)
+		}
+	}
+	corruptIndexes(t)
+	references := []string{
+		"book 4 has negative price -1",
+		"book 4 refers to missing publisher 7",
+		"book 5 refers to missing series 8",
+		"book 6 refers to missing series 8",
+		"books 5 and 6 are both volume 1 of series 8",
+	}
+	want := slices.Concat(references, []string{
+		"book 2 has favorite count 5, want 0",
+		`user "ann" favorites missing book 99`,
+		"shelf 1 holds missing book 98",
+		"cover stored for missing book 97",
+	})
+	rec = serve(t, h, http.MethodGet, "/admin/verify", nil)
+	wantCode(t, rec, http.StatusOK)
+	if got := decode[verifyReport](t, rec); got.Books != 6 || !slices.Equal(got.Violations, want) {
+		t.Errorf("violations\n%q\nwant\n%q", got.Violations, want)
+	}
+
+	rec = serve(t, h, http.MethodPost, "/admin/reindex", nil)
+	wantCode(t, rec, http.StatusOK)
+	if got := decode[reindexReport](t, rec); got.Books != 6 || got.Removed != 3 {
+		t.Errorf("reindex report %+v, want 3 entries removed", got)
+	}
+	rec = serve(t, h, http.MethodGet, "/admin/verify", nil)
+	if got := decode[verifyReport](t, rec).Violations; !slices.Equal(got, references) {
+		t.Errorf("violations after reindexing\n%q\nwant those of the books alone\n%q", got, references)
+	}
+	if got := favoriteCount(1); got != 1 {
+		t.Errorf("book 1 has favorite count %d after reindexing, want 1", got)
+	}
+
+	wantCode(t, serve(t, h, http.MethodPost, "/admin/verify", nil), http.StatusMethodNotAllowed)
+	wantCode(t, serve(t, h, http.MethodGet, "/admin/reindex", nil), http.StatusMethodNotAllowed)
+}
+
+// TestAdminNeedsAdmin checks that only admin tokens may verify and
+// reindex.
+func TestAdminNeedsAdmin(t *testing.T) {
+	h := newTestServer(t)
+	withTokensFile(t)
+	for _, req := range []struct{ method, path string }{
+		{http.MethodGet, "/admin/verify"},
+		{http.MethodPost, "/admin/reindex"},
+	} {
+		for token, want := range map[string]int{"r1": http.StatusForbidden, "w1": http.StatusForbidden, "a1": http.StatusOK} {
+			if got := serve(t, h, req.method, req.path, nil, "Authorization", "Bearer "+token).Code; got != want {
+				t.Errorf("%s %s with token %s: status %d, want %d", req.method, req.path, token, got, want)
+			}
+		}
+	}
+}
@@ -0,0 +1,451 @@
+package booksapi
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// maxImportBytes caps the decompressed size of an import, so a small
+// gzipped body cannot expand into an unbounded amount of memory. It is
+// configured by a flag in Main.
+var maxImportBytes int64 = 64 << 20
+
+// gzipMagic are the leading bytes of a gzip stream.
+var gzipMagic = []byte{0x1f, 0x8b}
+
+// Import strategies, chosen with ?strategy=, deciding what happens to a
+// book of an import that matches a book already in the catalog by ISBN or
+// by title and author.
+const (
+	importDuplicate = "duplicate" // import it as a book of its own
+	importSkip      = "skip"      // leave it out
+	importOverwrite = "overwrite" // replace the matching book, keeping its ID
+	importFail      = "fail"      // reject the whole import
+)
+
+// importParams declares the query parameters of POST /books/import.
+var importParams = []queryParam{
+	{name: "strategy", kind: enumParam, values: []string{importDuplicate, importSkip, importOverwrite, importFail}},
+}
+
+// importResult is the response body of POST /books/import. Imported counts
+// the created and updated books.
+type importResult struct {
+	Imported int           `json:"imported"`
+	Created  importOutcome `json:"created"`
+	Updated  importOutcome `json:"updated"`
+	Skipped  importOutcome `json:"skipped"` // IDs of the matching books left alone
+}
+
+// importOutcome lists the books given one outcome by an import.
+type importOutcome struct {
+	Count int   `json:"count"`
+	IDs   []int `json:"ids"`
+}
+
+// add records the book with the given ID.
+func (o *importOutcome) add(id int) {
+	o.Count++
+	o.IDs = append(o.IDs, id)
+}
+
+// exportParams declares the query parameters of GET /books/export.
+var exportParams = []queryParam{
+	{name: "canonical", kind: boolParam},
+}
+
+// exportBooks streams every book as a backupDocument (GET /books/export),
+// gzip-compressed when the client accepts it. The checksum is also sent in
+// the X-Content-SHA256 header. With ?canonical=true the document is in
+// canonical form, byte for byte the same for the same books.
+func exportBooks(w http.ResponseWriter, r *http.Request) {
+	q, ok := checkQuery(w, r, exportParams...)
+	if !ok {
+		return
+	}
+
+	list := store.List(ListOptions{})
+	caller := readerToken(r)
+	for i := range list {
+		list[i] = redactBook(caller, list[i])
+	}
+	canonical := q.Bool("canonical")
+	if canonical {
+		list = canonicalBooks(list)
+	}
+	sum, err := checksumBooks(list)
+	if err != nil {
+		writeAPIError(w, r, err)
+		return
+	}
+	w.Header().Set(checksumHeader, sum)
+	w.Header().Set("Content-Type", "application/json")
+	var out io.Writer = w
+	if acceptsGzip(r) {
+		w.Header().Set("Content-Encoding", "gzip")
+		zw := gzip.NewWriter(w)
+		defer zw.Close()
+		out = zw
+	}
+
+	if canonical {
+		writeCanonicalBackup(out, list, sum)
+		return
+	}
+	bw := bufio.NewWriter(out)
+	defer bw.Flush()
+	enc := json.NewEncoder(bw)
+	bw.WriteString(`{"books":[`)
+	for i, book := range list {
+		if i > 0 {
+			bw.WriteString(",")
+		}
+		enc.Encode(book)
+	}
+	fmt.Fprintf(bw, "],\"count\":%d,\"sha256\":%q}\n", len(list), sum)
+}
+
+// importBooks stores the books of a backup such as the one produced by
+// exportBooks, or of a bare JSON array of books (POST /books/import). The
+// count and checksum of a backup are verified before anything is stored. Books keep their IDs, replacing any book
+// with the same ID; books without an ID get a new one. Every book is checked
+// before any is stored, so an invalid archive changes nothing. The body may
+// be gzip-compressed, which is detected from its leading bytes when the
+// Content-Encoding header is missing.
+func importBooks(w http.ResponseWriter, r *http.Request) {
+	q, ok := checkQuery(w, r, importParams...)
+	if !ok {
+		return
+	}
+	strategy, err := singleValue(q, importParams, "strategy")
+	if err != nil {
+		writeAPIError(w, r, err)
+		return
+	}
+	if strategy == "" {
+		strategy = importDuplicate
+	}
+
+	data, err := readImportBody(w, r)
+	if err != nil {
+		writeAPIError(w, r, err)
+		return
+	}
+	list, err := decodeBackup(r, data)
+	if err != nil {
+		writeAPIError(w, r, err)
+		return
+	}
+	caller := callerToken(r)
+	for i := range list {
+		err := checkFieldWrites(caller, Book{}, list[i])
+		if err == nil {
+			err = validateImportBook(&list[i])
+		}
+		if err != nil {
+			writeAPIError(w, r, withIndex(err, i))
+			return
+		}
+	}
+
+	answerDeadline(r)
+	mu.Lock()
+	defer mu.Unlock()
+
+	result, err := applyImport(r.Context(), list, strategy)
+	if err != nil {
+		writeAPIError(w, r, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, result)
+}
+
+// validateImportBook validates a book of an import: as a new book if it has
+// no ID, and as a replacement otherwise.
+func validateImportBook(book *Book) error {
+	mode := ValidateUpdate
+	if book.ID < 1 {
+		mode = ValidateCreate
+	}
+	return validateBook(book, mode)
+}
+
+// applyImport stores the validated books of an import, resolving matches
+// with the catalog by strategy. The whole import is checked against the
+// catalog before any book is stored, and the books are stored in a single
+// transaction, so a failing write leaves the catalog as it was, as does
+// running out of time: ctx is checked before each book. Callers must hold
+// mu.
+func applyImport(ctx context.Context, list []Book, strategy string) (importResult, error) {
+	skipped, err := applyImportStrategy(list, strategy)
+	if err != nil {
+		return importResult{}, err
+	}
+	if err := checkImport(list, skipped); err != nil {
+		return importResult{}, err
+	}
+	var result importResult
+	err = store.Transact(ctx, func(tx BookStore) error {
+		result = importResult{Created: importOutcome{IDs: []int{}}, Updated: importOutcome{IDs: []int{}}, Skipped: importOutcome{IDs: []int{}}}
+		for i, book := range list {
+			if ctx.Err() != nil {
+				return batchRolledBack(ctx, i, len(list))
+			}
+			if skipped[i] {
+				result.Skipped.add(book.ID)
+				continue
+			}
+			if book.ID < 1 {
+				book, err := tx.Create(book)
+				if err != nil {
+					return err
+				}
+				result.Created.add(book.ID)
+				continue
+			}
+			_, existed := tx.Get(book.ID)
+			if err := tx.Put(book); err != nil {
+				return err
+			}
+			if err := tx.Reserve(book.ID); err != nil {
+				return err
+			}
+			if existed {
+				result.Updated.add(book.ID)
+			} else {
+				result.Created.add(book.ID)
+			}
+		}
+		return nil
+	})
+	if err != nil && ctx.Err() != nil && errors.Is(err, ctx.Err()) {
+		err = batchRolledBack(ctx, len(list), len(list))
+	}
+	if err != nil {
+		return importResult{}, err
+	}
+	result.Imported = result.Created.Count + result.Updated.Count
+	return result, nil
+}
+
+// applyImportStrategy applies an import strategy to the books of an import
+// matching books in the catalog, as it was before the import, by ISBN or
+// by title and author. It reports which books are to be skipped, setting
+// their IDs to those of the books they match, and gives books to overwrite
+// the IDs of the books they replace. Under importFail, the first match is
+// reported as a 409 error instead. Callers must hold mu.
+func applyImportStrategy(list []Book, strategy string) ([]bool, error) {
+	skipped := make([]bool, len(list))
+	if strategy == importDuplicate {
+		return skipped, nil
+	}
+
+	byISBN := make(map[string]Book)
+	byTitleAuthor := make(map[string]Book)
+	for _, book := range store.List(ListOptions{}) {
+		if book.ISBN != "" {
+			byISBN[book.ISBN] = book
+		}
+		byTitleAuthor[titleAuthorKey(book)] = book
+	}
+
+	for i := range list {
+		match, found := byISBN[list[i].ISBN]
+		if !found || list[i].ISBN == "" {
+			match, found = byTitleAuthor[titleAuthorKey(list[i])]
+		}
+		if !found {
+			continue
+		}
+		switch strategy {
+		case importSkip:
+			skipped[i] = true
+			list[i].ID = match.ID
+		case importOverwrite:
+			list[i].ID = match.ID
+			touchBook(&list[i], match.CreatedAt)
+		case importFail:
+			return nil, withIndex(newAPIError(http.StatusConflict, "import_conflict", "id", match.ID), i)
+		}
+	}
+	return skipped, nil
+}
+
+// titleAuthorKey returns the key under which imports match books by title
+// and author, ignoring case and surrounding space.
+func titleAuthorKey(book Book) string {
+	return strings.ToLower(strings.TrimSpace(book.Title)) + "\x00" + strings.ToLower(strings.TrimSpace(book.Author))
+}
+
+// checkImport verifies that the books of an import fit within the quota,
+// refer to existing resources, and claim distinct series volumes and
+// edition ISBNs, taking the books they replace into account. Books marked
+// as skipped are not checked. Callers must hold mu.
+func checkImport(list []Book, skipped []bool) error {
+	replaced := make(map[int]bool)
+	added := 0
+	for i, book := range list {
+		if skipped[i] {
+			continue
+		}
+		if book.ID < 1 {
+			added++
+		} else if _, found := store.Get(book.ID); !found && !replaced[book.ID] {
+			added++
+		}
+		if book.ID > 0 {
+			replaced[book.ID] = true
+		}
+	}
+	if err := checkQuota(added); err != nil {
+		return err
+	}
+
+	owners := editionISBNOwners(func(id int) bool { return !replaced[id] })
+	type volume struct{ series, index int }
+	claimed := make(map[volume]bool)
+	for _, book := range store.List(ListOptions{}) {
+		if book.SeriesID != nil && !replaced[book.ID] {
+			claimed[volume{*book.SeriesID, book.SeriesIndex}] = true
+		}
+	}
+	for i, book := range list {
+		if skipped[i] {
+			continue
+		}
+		if err := checkReferences(book); err != nil {
+			return withIndex(err, i)
+		}
+		if err := claimEditionISBNs(owners, book); err != nil {
+			return withIndex(err, i)
+		}
+		if book.SeriesID == nil {
+			continue
+		}
+		v := volume{*book.SeriesID, book.SeriesIndex}
+		if claimed[v] {
+			return withIndex(newAPIError(http.StatusConflict, "series_index_taken", "series", v.series, "index", v.index), i)
+		}
+		claimed[v] = true
+	}
+	return nil
+}
+
+// readImportBody reads and, if needed, decompresses an import body,
+// enforcing maxImportBytes, or the limit of the route policy, on both the
+// transferred and decompressed size, and returns it as UTF-8.
+func readImportBody(w http.ResponseWriter, r *http.Request) ([]byte, error) {
+	src, err := openImportBody(w, r)
+	if err != nil {
+		return nil, err
+	}
+	data, err := io.ReadAll(src)
+	if err != nil {
+		return nil, importReadError(err, bodyLimit(r, maxImportBytes))
+	}
+	return bodyUTF8(r, data)
+}
+
+// openImportBody returns a reader of the decompressed import body, for
+// callers streaming it. Reads past the size limit fail with
+// import_too_large; other read errors are mapped by importReadError.
+func openImportBody(w http.ResponseWriter, r *http.Request) (io.Reader, error) {
+	limit := bodyLimit(r, maxImportBytes)
+	body := bufio.NewReader(http.MaxBytesReader(w, r.Body, limit))
+
+	gzipped := false
+	switch encoding := strings.ToLower(strings.TrimSpace(r.Header.Get("Content-Encoding"))); encoding {
+	case "gzip", "x-gzip":
+		gzipped = true
+	case "", "identity":
+		magic, _ := body.Peek(len(gzipMagic))
+		gzipped = bytes.Equal(magic, gzipMagic)
+	default:
+		return nil, newAPIError(http.StatusUnsupportedMediaType, "unsupported_content_encoding", "encoding", encoding)
+	}
+
+	var src io.Reader = body
+	if gzipped {
+		zr, err := gzip.NewReader(body)
+		if err != nil {
+			return nil, importReadError(err, limit)
+		}
+		src = zr
+	}
+	return &importLimitReader{r: src, left: limit, limit: limit}, nil
+}
+
+// importLimitReader fails reads of more than limit bytes with
+// import_too_large, so a small gzipped body cannot expand without bound.
+type importLimitReader struct {
+	r           io.Reader
+	left, limit int64
+}
+
+func (l *importLimitReader) Read(p []byte) (int, error) {
+	// One byte more than is left is read, to tell a body of exactly the
+	// limit from a longer one.
+	if int64(len(p)) > l.left+1 {
+		p = p[:l.left+1]
+	}
+	n, err := l.r.Read(p)
+	l.left -= int64(n)
+	if l.left < 0 {
+		return n + int(l.left), newAPIError(http.StatusRequestEntityTooLarge, "import_too_large", "max", l.limit)
+	}
+	return n, err
+}
+
+// importReadError maps an error reading an import body limited to limit
+// bytes to an apiError.
+func importReadError(err error, limit int64) error {
+	var e *apiError
+	if errors.As(err, &e) {
+		return e
+	}
+	var tooLarge *http.MaxBytesError
+	if errors.As(err, &tooLarge) {
+		return newAPIError(http.StatusRequestEntityTooLarge, "import_too_large", "max", limit)
+	}
+	return newAPIError(http.StatusBadRequest, "invalid_request")
+}
+
+// withIndex adds the position of the offending book in an import to err.
+func withIndex(err error, index int) error {
+	e := asAPIError(err)
+	if e.Status == http.StatusInternalServerError {
+		return err
+	}
+	params := map[string]string{"index": strconv.Itoa(index)}
+	for k, v := range e.Params {
+		params[k] = v
+	}
+	return &apiError{Status: e.Status, Code: e.Code, Params: params, Fields: e.Fields}
+}
+
+// acceptsGzip reports whether the request's Accept-Encoding header allows a
+// gzip-compressed response.
+func acceptsGzip(r *http.Request) bool {
+	for _, part := range strings.Split(r.Header.Get("Accept-Encoding"), ",") {
+		coding, params, _ := strings.Cut(strings.TrimSpace(part), ";")
+		if !strings.EqualFold(strings.TrimSpace(coding), "gzip") {
+			continue
+		}
+		if q, ok := strings.CutPrefix(strings.TrimSpace(params), "q="); ok {
+			if v, err := strconv.ParseFloat(q, 64); err == nil && v == 0 {
+				return false
+			}
+		}
+		return true
+	}
+	return false
+}
@@ -0,0 +1,181 @@
+package booksapi
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io"
+	"net/http"
+	"testing"
+)
+
+// TestImportStrategies imports the same file, overlapping the catalog by
+// ISBN and by title and author, under each strategy against the same
+// catalog, and pins the result and the catalog after it.
+func TestImportStrategies(t *testing.T) {
+	file := []map[string]any{
+		{"title": "DUNE", "author": "F. Herbert", "isbn": "978-0441172719", "price": 12},
+		{"title": " emma", "author": "jane austen ", "price": 6},
+		{"title": "Jazz", "author": "Toni Morrison", "price": 8},
+	}
+	for _, strategy := range []string{importDuplicate, importSkip, importOverwrite, importFail} {
+		t.Run(strategy, func(t *testing.T) {
+			h := newTestServer(t)
+			mustCreateBook(t, h, map[string]any{"title": "Dune", "author": "Frank Herbert", "isbn": "9780441172719", "price": 10})
+			mustCreateBook(t, h, map[string]any{"title": "Emma", "author": "Jane Austen", "price": 5})
+			mustCreateBook(t, h, map[string]any{"title": "Beloved", "author": "Toni Morrison", "price": 7})
+			before := catalogJSON(t, store)
+			dune, _ := store.Get(1)
+			created := *dune.CreatedAt
+
+			rec := serve(t, h, http.MethodPost, "/books/import?strategy="+strategy, file)
+			if strategy == importFail {
+				wantCode(t, rec, http.StatusConflict)
+				got := decode[errorBody](t, rec).Error
+				if got.Code != "import_conflict" || got.Params["index"] != "0" || got.Params["id"] != "1" {
+					t.Errorf("error %s %v, want import_conflict of row 0 with book 1", got.Code, got.Params)
+				}
+				if after := catalogJSON(t, store); after != before {
+					t.Errorf("failed import changed the catalog to %s", after)
+				}
+				return
+			}
+			wantCode(t, rec, http.StatusOK)
+			if dune, _ := store.Get(1); strategy == importOverwrite && !dune.CreatedAt.Equal(created) {
+				t.Errorf("overwritten book created at %v, want %v", dune.CreatedAt, created)
+			}
+			checkGolden(t, "import/"+strategy+"_result.json", rec.Body.Bytes())
+			checkGolden(t, "import/"+strategy+"_catalog.json", serve(t, h, http.MethodGet, "/books", nil).Body.Bytes())
+		})
+	}
+
+	t.Run("default", func(t *testing.T) {
+		h := newTestServer(t)
+		mustCreateBook(t, h, map[string]any{"title": "Emma", "author": "Jane Austen", "price": 5})
+		rec := serve(t, h, http.MethodPost, "/books/import", file[1:2])
+		wantCode(t, rec, http.StatusOK)
+		if got := decode[importResult](t, rec); got.Created.Count != 1 || got.Skipped.Count != 0 || got.Updated.Count != 0 {
+			t.Errorf("result %+v, want the match duplicated", got)
+		}
+	})
+	t.Run("unknown", func(t *testing.T) {
+		h := newTestServer(t)
+		rec := serve(t, h, http.MethodPost, "/books/import?strategy=merge", file)
+		wantCode(t, rec, http.StatusBadRequest)
+		if got := errorCode(t, rec); got != "invalid_query_parameter" {
+			t.Errorf("error code %q, want invalid_query_parameter", got)
+		}
+	})
+}
+
+// gzipBytes returns data gzip-compressed.
+func gzipBytes(t *testing.T, data []byte) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	zw := gzip.NewWriter(&buf)
+	if _, err := zw.Write(data); err != nil {
+		t.Fatal(err)
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+// TestGzipExport checks that the export is compressed for clients
+// accepting gzip, and that the compressed export imports into an empty
+// catalog as it was, with or without its Content-Encoding.
+func TestGzipExport(t *testing.T) {
+	h := newTestServer(t)
+	mustCreateBook(t, h, map[string]any{"title": "Dune", "author": "Frank Herbert", "price": 10})
+	mustCreateBook(t, h, map[string]any{"title": "Emma", "author": "Jane Austen", "price": 5})
+	want := catalogJSON(t, store)
+	plain := serve(t, h, http.MethodGet, "/books/export", nil).Body.Bytes()
+
+	rec := serve(t, h, http.MethodGet, "/books/export", nil, "Accept-Encoding", "gzip")
+	wantCode(t, rec, http.StatusOK)
+	if got := rec.Header().Get("Content-Encoding"); got != "gzip" {
+		t.Fatalf("Content-Encoding %q, want gzip", got)
+	}
+	zipped := rec.Body.Bytes()
+	zr, err := gzip.NewReader(bytes.NewReader(zipped))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, err := io.ReadAll(zr); err != nil || !bytes.Equal(got, plain) {
+		t.Errorf("decompressed export %q (%v), want %q", got, err, plain)
+	}
+	if got := serve(t, h, http.MethodGet, "/books/export", nil, "Accept-Encoding", "gzip;q=0").Header().Get("Content-Encoding"); got != "" {
+		t.Errorf("Content-Encoding %q for a client refusing gzip", got)
+	}
+
+	for _, header := range [][]string{{"Content-Encoding", "gzip"}, nil} {
+		h := newTestServer(t)
+		rec := serve(t, h, http.MethodPost, "/books/import", zipped, header...)
+		wantCode(t, rec, http.StatusOK)
+		if got := catalogJSON(t, store); got != want {
+			t.Errorf("import with header %q: catalog %s, want %s", header, got, want)
+		}
+	}
+}
+
+// TestImportLimits checks that an import larger than -max-import-bytes is
+// refused, whether sent as it is or compressed into a small body that
+// expands past the limit, and that nothing is stored.
+func TestImportLimits(t *testing.T) {
+	h := newTestServer(t)
+	setForTest(t, &maxImportBytes, 1000)
+	mustCreateBook(t, h, map[string]any{"title": "Dune"})
+	before := catalogJSON(t, store)
+
+	books := []byte(`[{"title": "Emma"}]`)
+	bomb := append([]byte(`[{"title": "Emma"}`), bytes.Repeat([]byte(" "), 10<<20)...)
+	bomb = append(bomb, ']')
+	tests := []struct {
+		name   string
+		body   []byte
+		header []string
+		status int
+		code   string
+	}{
+		{"at the limit", append(books, bytes.Repeat([]byte(" "), 1000-len(books))...), nil, http.StatusOK, ""},
+		{"over the limit", append(books, bytes.Repeat([]byte(" "), 1001-len(books))...), nil, http.StatusRequestEntityTooLarge, "import_too_large"},
+		{"compressed bomb", gzipBytes(t, bomb), []string{"Content-Encoding", "gzip"}, http.StatusRequestEntityTooLarge, "import_too_large"},
+		{"sniffed bomb", gzipBytes(t, bomb), nil, http.StatusRequestEntityTooLarge, "import_too_large"},
+		{"corrupt gzip", gzipBytes(t, books)[:10], []string{"Content-Encoding", "gzip"}, http.StatusBadRequest, "invalid_request"},
+		{"other encoding", books, []string{"Content-Encoding", "br"}, http.StatusUnsupportedMediaType, "unsupported_content_encoding"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := serve(t, h, http.MethodPost, "/books/import", tt.body, tt.header...)
+			wantCode(t, rec, tt.status)
+			if tt.code == "" {
+				wantCode(t, serve(t, h, http.MethodDelete, "/books/2", nil), http.StatusNoContent)
+				return
+			}
+			if got := decode[errorBody](t, rec).Error; got.Code != tt.code || tt.code == "import_too_large" && got.Params["max"] != "1000" {
+				t.Errorf("error %s %v, want %s", got.Code, got.Params, tt.code)
+			}
+			if after := catalogJSON(t, store); after != before {
+				t.Errorf("refused import changed the catalog to %s", after)
+			}
+		})
+	}
+}
+
+// TestImportInvalidBook checks that an import with an invalid book stores
+// none of them, naming the offending book's index.
+func TestImportInvalidBook(t *testing.T) {
+	h := newTestServer(t)
+	rec := serve(t, h, http.MethodPost, "/books/import", []map[string]any{
+		{"title": "Dune"},
+		{"title": "Emma", "price": -5},
+		{"title": "Jazz"},
+	})
+	wantCode(t, rec, http.StatusUnprocessableEntity)
+	if got := decode[errorBody](t, rec).Error; got.Params["index"] != "1" {
+		t.Errorf("error %s %v, want one of book 1", got.Code, got.Params)
+	}
+	if got := store.Count(); got != 0 {
+		t.Errorf("%d books stored from an invalid import", got)
+	}
+}
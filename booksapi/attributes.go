@@ -0,0 +1,206 @@
+package booksapi
+
+import (
+	"fmt"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// defaultAttributeLength is the maximum number of characters of a custom
+// attribute declared without its own limit.
+const defaultAttributeLength = 200
+
+// attributeNamePattern matches the names of custom attributes.
+var attributeNamePattern = regexp.MustCompile(`^[a-z][a-z0-9_]{0,63}$`)
+
+// customAttributes maps the name of each custom attribute books may carry
+// to its maximum length in characters. It is configured by -attributes in
+// main; without it books carry no attributes.
+var customAttributes = map[string]int{}
+
+// requiredOnCreate lists the fields new books must have, on top of the
+// title, as configured by -required-fields in Main. Custom attributes are
+// named "attributes.{name}".
+var requiredOnCreate []string
+
+// requirableFields are the built-in book fields -required-fields may name.
+var requirableFields = []string{"author", "isbn", "description", "language", "currency", "cost_price", "publisher_id", "series_id"}
+
+// Schema settings, read from flags in Main and applied by
+// configureAttributes and configureRequiredFields.
+var attributeSpec, requiredFieldsSpec string
+
+// attributeParamPrefix starts the names of the GET /books query parameters
+// filtering by a custom attribute, such as ?attr.shelf_location=A3.
+const attributeParamPrefix = "attr."
+
+// configureAttributes declares the custom attributes from spec, a
+// comma-separated list of names, each optionally followed by a colon and
+// its maximum length, as in "shelf_location:16,edition", and adds their
+// filters to the query parameters of GET /books.
+func configureAttributes(spec string) error {
+	attrs := make(map[string]int)
+	for _, item := range strings.Split(spec, ",") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+		name, limit, hasLimit := strings.Cut(item, ":")
+		if !attributeNamePattern.MatchString(name) {
+			return fmt.Errorf("invalid attribute name %q: use lower-case letters, digits, and underscores", name)
+		}
+		if _, dup := attrs[name]; dup {
+			return fmt.Errorf("attribute %q declared twice", name)
+		}
+		attrs[name] = defaultAttributeLength
+		if hasLimit {
+			n, err := strconv.Atoi(limit)
+			if err != nil || n < 1 {
+				return fmt.Errorf("attribute %q: invalid maximum length %q", name, limit)
+			}
+			attrs[name] = n
+		}
+	}
+	customAttributes = attrs
+
+	names := make([]string, 0, len(attrs))
+	for name := range attrs {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+	for _, name := range names {
+		booksParams = append(booksParams, queryParam{name: attributeParamPrefix + name, kind: stringParam, repeatable: true})
+	}
+	return nil
+}
+
+// configureRequiredFields sets requiredOnCreate from spec, a
+// comma-separated list of built-in fields and custom attributes. Custom
+// attributes must be declared by configureAttributes first.
+func configureRequiredFields(spec string) error {
+	var fields []string
+	for _, field := range strings.Split(spec, ",") {
+		field = strings.TrimSpace(field)
+		switch {
+		case field == "", field == "title":
+			continue
+		case slices.Contains(requirableFields, field):
+		case strings.HasPrefix(field, "attributes."):
+			if _, ok := customAttributes[strings.TrimPrefix(field, "attributes.")]; !ok {
+				return fmt.Errorf("%s is not a declared attribute", field)
+			}
+		default:
+			return fmt.Errorf("unknown field %q: use one of %s, or attributes.{name}", field, strings.Join(requirableFields, ", "))
+		}
+		if !slices.Contains(fields, field) {
+			fields = append(fields, field)
+		}
+	}
+	requiredOnCreate = fields
+	return nil
+}
+
+// checkRequiredFields requires new books to have the fields of
+// requiredOnCreate. Blank strings count as missing.
+func checkRequiredFields(b Book, mode ValidationMode) []FieldError {
+	if mode != ValidateCreate {
+		return nil
+	}
+	var errs []FieldError
+	for _, field := range requiredOnCreate {
+		if !hasField(b, field) {
+			errs = append(errs, newFieldError(field, "required"))
+		}
+	}
+	return errs
+}
+
+// hasField reports whether b has the field of requiredOnCreate named.
+func hasField(b Book, field string) bool {
+	switch field {
+	case "author":
+		return strings.TrimSpace(b.Author) != ""
+	case "isbn":
+		return b.ISBN != ""
+	case "description":
+		return strings.TrimSpace(b.Description) != ""
+	case "language":
+		return b.Language != ""
+	case "currency":
+		return b.Currency != ""
+	case "cost_price":
+		return b.CostPrice != nil
+	case "publisher_id":
+		return b.PublisherID != nil
+	case "series_id":
+		return b.SeriesID != nil
+	}
+	name := strings.TrimPrefix(field, "attributes.")
+	return strings.TrimSpace(b.Attributes[name]) != ""
+}
+
+// checkAttributes allows only the declared custom attributes, each within
+// its maximum length. Errors are reported in the order of the names.
+func checkAttributes(b Book, _ ValidationMode) []FieldError {
+	names := make([]string, 0, len(b.Attributes))
+	for name := range b.Attributes {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+
+	var errs []FieldError
+	for _, name := range names {
+		field := "attributes." + name
+		limit, ok := customAttributes[name]
+		switch {
+		case !ok:
+			errs = append(errs, newFieldError(field, "attribute"))
+		case utf8.RuneCountInString(b.Attributes[name]) > limit:
+			errs = append(errs, newFieldError(field, "max_length", "max", limit))
+		}
+	}
+	return errs
+}
+
+// attributeFilters returns the custom attribute filters of a GET /books
+// query, the values given for each attribute keyed by its name, or nil if
+// there are none.
+func attributeFilters(q queryValues) map[string][]string {
+	var filters map[string][]string
+	for name := range customAttributes {
+		if q.Has(attributeParamPrefix + name) {
+			if filters == nil {
+				filters = make(map[string][]string)
+			}
+			filters[name] = q.Strings(attributeParamPrefix + name)
+		}
+	}
+	return filters
+}
+
+// filterByAttributes keeps only the books whose custom attributes satisfy
+// the filters of every attribute, any of one attribute's filters being
+// enough, in the given matching mode.
+func filterByAttributes(list []Book, mode string, filters map[string][]string) []Book {
+	matchers := make(map[string]textMatchers, len(filters))
+	for name, values := range filters {
+		matchers[name] = newTextMatchers(mode, values)
+	}
+	filtered := list[:0]
+	for _, book := range list {
+		keep := true
+		for name, m := range matchers {
+			if !m.match(book.Attributes[name]) {
+				keep = false
+				break
+			}
+		}
+		if keep {
+			filtered = append(filtered, book)
+		}
+	}
+	return filtered
+}
@@ -0,0 +1,154 @@
+package booksapi
+
+import (
+	"maps"
+	"net/http"
+	"reflect"
+	"slices"
+	"strings"
+	"testing"
+)
+
+// withSchema declares the custom attributes of attributes and requires
+// the fields of required, as -attributes and -required-fields do, until
+// the end of the test.
+func withSchema(t *testing.T, attributes, required string) {
+	t.Helper()
+	setForTest(t, &customAttributes, customAttributes)
+	setForTest(t, &requiredOnCreate, requiredOnCreate)
+	setForTest(t, &booksParams, slices.Clone(booksParams))
+	if err := configureAttributes(attributes); err != nil {
+		t.Fatal(err)
+	}
+	if err := configureRequiredFields(required); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// TestConfigureSchema checks the parsing of -attributes and
+// -required-fields.
+func TestConfigureSchema(t *testing.T) {
+	withSchema(t, " shelf_location:16, edition ,", "isbn, attributes.shelf_location, title, isbn")
+	if want := map[string]int{"shelf_location": 16, "edition": defaultAttributeLength}; !maps.Equal(customAttributes, want) {
+		t.Errorf("attributes %v, want %v", customAttributes, want)
+	}
+	if want := []string{"isbn", "attributes.shelf_location"}; !slices.Equal(requiredOnCreate, want) {
+		t.Errorf("required fields %q, want %q", requiredOnCreate, want)
+	}
+
+	for _, spec := range []string{"Shelf", "shelf-location", "shelf:0", "shelf:x", "shelf,shelf", strings.Repeat("a", 65)} {
+		if err := configureAttributes(spec); err == nil {
+			t.Errorf("-attributes %q: no error", spec)
+		}
+	}
+	for _, spec := range []string{"price", "attributes.colour", "isbn,nothing"} {
+		if err := configureRequiredFields(spec); err == nil {
+			t.Errorf("-required-fields %q: no error", spec)
+		}
+	}
+}
+
+// TestRequiredFields checks that a deployment requiring an ISBN and an
+// attribute rejects new books lacking them at every entry point, while
+// the default configuration accepts them, and that updates are not held
+// to the requirement.
+func TestRequiredFields(t *testing.T) {
+	book := map[string]any{"title": "Dune", "author": "Frank Herbert"}
+	h := newTestServer(t)
+	withSchema(t, "shelf_location", "")
+	for _, req := range bookEntryPoints(book) {
+		if req.method == http.MethodPost {
+			rec := serve(t, h, req.method, req.path, req.body, req.header...)
+			if rec.Code != http.StatusCreated && rec.Code != http.StatusOK {
+				t.Errorf("default configuration: %s: status %d: %s", req.name, rec.Code, rec.Body)
+			}
+		}
+	}
+
+	h = newTestServer(t)
+	withSchema(t, "shelf_location", "isbn,attributes.shelf_location")
+	mustCreateBook(t, h, map[string]any{"title": "Emma", "isbn": "9780141439587", "attributes": map[string]string{"shelf_location": "B1"}})
+	want := []FieldError{
+		{Field: "isbn", Rule: "required", Message: "isbn is required", Params: map[string]string{"field": "isbn"}},
+		{Field: "attributes.shelf_location", Rule: "required", Message: "attributes.shelf_location is required", Params: map[string]string{"field": "attributes.shelf_location"}},
+	}
+	for _, req := range bookEntryPoints(map[string]any{"title": "Dune", "attributes": map[string]string{"shelf_location": " "}}) {
+		rec := serve(t, h, req.method, req.path, req.body, req.header...)
+		if req.method != http.MethodPost {
+			// Books are only held to the requirement when created.
+			wantCode(t, rec, http.StatusOK)
+			continue
+		}
+		wantCode(t, rec, http.StatusUnprocessableEntity)
+		got := decode[errorBody](t, rec).Error
+		if got.Code != "field_required" || !reflect.DeepEqual(got.Errors, want) {
+			t.Errorf("%s: error %s %+v, want %+v", req.name, got.Code, got.Errors, want)
+		}
+	}
+}
+
+// TestCustomAttributes checks that only declared attributes within their
+// length are taken, that books can be filtered by them, and that they
+// survive an export and import.
+func TestCustomAttributes(t *testing.T) {
+	h := newTestServer(t)
+	withSchema(t, "shelf_location:4,edition", "")
+	for _, b := range []struct {
+		title string
+		attrs map[string]string
+	}{
+		{"Dune", map[string]string{"shelf_location": "A3", "edition": "first"}},
+		{"Emma", map[string]string{"shelf_location": "a3"}},
+		{"Hyperion", map[string]string{"shelf_location": "A30", "edition": "first"}},
+		{"Jazz", nil},
+	} {
+		mustCreateBook(t, h, map[string]any{"title": b.title, "attributes": b.attrs})
+	}
+
+	for _, tt := range []struct {
+		attrs map[string]string
+		field string
+		code  string
+	}{
+		{map[string]string{"colour": "red"}, "attributes.colour", "unknown_field"},
+		{map[string]string{"shelf_location": "A3000"}, "attributes.shelf_location", "field_too_long"},
+	} {
+		rec := serve(t, h, http.MethodPost, "/books", map[string]any{"title": "Beloved", "attributes": tt.attrs})
+		wantCode(t, rec, http.StatusUnprocessableEntity)
+		if got := decode[errorBody](t, rec).Error; got.Code != tt.code || got.Params["field"] != tt.field {
+			t.Errorf("attributes %v: error %s %v, want %s for %s", tt.attrs, got.Code, got.Params, tt.code, tt.field)
+		}
+	}
+
+	titles := func(query string) []string {
+		t.Helper()
+		rec := serve(t, h, http.MethodGet, "/books?"+query, nil)
+		wantCode(t, rec, http.StatusOK)
+		var titles []string
+		for _, book := range decode[[]Book](t, rec) {
+			titles = append(titles, book.Title)
+		}
+		return titles
+	}
+	for query, want := range map[string][]string{
+		"attr.shelf_location=A3":                                 {"Dune", "Emma"},
+		"attr.shelf_location=A3&match=prefix":                    {"Dune", "Emma", "Hyperion"},
+		"attr.shelf_location=A30&attr.shelf_location=a3":         {"Dune", "Emma", "Hyperion"},
+		"attr.shelf_location=A3&match=prefix&attr.edition=first": {"Dune", "Hyperion"},
+		"attr.edition=second":                                    nil,
+	} {
+		if got := titles(query); !slices.Equal(got, want) {
+			t.Errorf("%s: %q, want %q", query, got, want)
+		}
+	}
+
+	export := serve(t, h, http.MethodGet, "/books/export", nil).Body.Bytes()
+	h = newTestServer(t)
+	wantCode(t, serve(t, h, http.MethodPost, "/books/import", export), http.StatusOK)
+	if got := decode[Book](t, serve(t, h, http.MethodGet, "/books/1", nil)).Attributes; !maps.Equal(got, map[string]string{"shelf_location": "A3", "edition": "first"}) {
+		t.Errorf("imported attributes %v", got)
+	}
+	if got := titles("attr.shelf_location=A3"); !slices.Equal(got, []string{"Dune", "Emma"}) {
+		t.Errorf("after the import: %q", got)
+	}
+}
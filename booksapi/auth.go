@@ -0,0 +1,201 @@
+package booksapi
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"os"
+	"slices"
+	"strings"
+)
+
+// Token scopes, from least to most privileged. Each scope grants the ones
+// before it, so a write token can also read.
+const (
+	scopeRead  = "read"
+	scopeWrite = "write"
+	scopeAdmin = "admin"
+)
+
+var scopeOrder = []string{scopeRead, scopeWrite, scopeAdmin}
+
+// tokensFile names a file of API tokens and their scopes. It is configured
+// by a flag in Main.
+var tokensFile string
+
+// requireTokens requires a token on every request. Main sets it when there
+// is a tokens file, and New when it is given tokens; otherwise only the
+// admin routes need a token.
+var requireTokens bool
+
+// apiToken is a bearer token and the scopes it was configured with. user
+// names the user it authenticates, for per-user data such as favorites.
+type apiToken struct {
+	secret string
+	scopes []string
+	user   string
+}
+
+// apiTokens are the tokens accepted by the server at startup: those of the
+// tokens file and the admin token. A reload may replace them in the runtime
+// configuration.
+var apiTokens []apiToken
+
+// tokenInfo is the response body of GET /me/token.
+type tokenInfo struct {
+	Scopes []string `json:"scopes"`
+}
+
+// loadTokens reads a tokens file. Each line holds a token followed by a
+// comma-separated list of scopes and optionally the user it authenticates,
+// e.g. "s3cret read,write user=alice"; blank lines and lines starting with # are
+// ignored.
+func loadTokens(path string) ([]apiToken, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var tokens []apiToken
+	scanner := bufio.NewScanner(f)
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+		fields := strings.Fields(text)
+		if len(fields) != 2 && len(fields) != 3 {
+			return nil, fmt.Errorf("%s:%d: want a token, its scopes, and optionally its user", path, line)
+		}
+		var scopes []string
+		for _, scope := range strings.Split(fields[1], ",") {
+			if !slices.Contains(scopeOrder, scope) {
+				return nil, fmt.Errorf("%s:%d: unknown scope %q", path, line, scope)
+			}
+			scopes = append(scopes, scope)
+		}
+		token := apiToken{secret: fields[0], scopes: scopes}
+		if len(fields) == 3 {
+			user, ok := strings.CutPrefix(fields[2], "user=")
+			if !ok || user == "" {
+				return nil, fmt.Errorf("%s:%d: want user=name after the scopes", path, line)
+			}
+			token.user = user
+		}
+		tokens = append(tokens, token)
+	}
+	return tokens, scanner.Err()
+}
+
+// userName returns the user the token authenticates: the one it was
+// configured with, or else one standing for the token itself, derived from
+// its secret without revealing it.
+func (t apiToken) userName() string {
+	if t.user != "" {
+		return t.user
+	}
+	sum := sha256.Sum256([]byte(t.secret))
+	return "token-" + hex.EncodeToString(sum[:6])
+}
+
+// grants reports whether the token's scopes include scope, directly or
+// through a more privileged scope.
+func (t apiToken) grants(scope string) bool {
+	need := slices.Index(scopeOrder, scope)
+	for _, s := range t.scopes {
+		if slices.Index(scopeOrder, s) >= need {
+			return true
+		}
+	}
+	return false
+}
+
+// effectiveScopes returns every scope the token grants, least privileged
+// first.
+func (t apiToken) effectiveScopes() []string {
+	scopes := []string{}
+	for _, scope := range scopeOrder {
+		if t.grants(scope) {
+			scopes = append(scopes, scope)
+		}
+	}
+	return scopes
+}
+
+// lookupToken returns the configured token sent as the request's bearer
+// token. Every token is compared in constant time.
+func lookupToken(r *http.Request) (apiToken, bool) {
+	secret, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if !ok {
+		return apiToken{}, false
+	}
+	var found apiToken
+	ok = false
+	for _, token := range currentConfig().tokens {
+		if subtle.ConstantTimeCompare([]byte(secret), []byte(token.secret)) == 1 {
+			found, ok = token, true
+		}
+	}
+	return found, ok
+}
+
+// requiredScope returns the scope needed to serve a request: admin for the
+// /admin and /debug endpoints, read for safe methods, and write otherwise.
+// GET /me/token only needs a valid token, which is reported as "".
+func requiredScope(r *http.Request) string {
+	switch {
+	case r.URL.Path == "/me/token":
+		return ""
+	case strings.HasPrefix(r.URL.Path, "/admin/"), strings.HasPrefix(r.URL.Path, "/debug/"):
+		return scopeAdmin
+	case r.Method == http.MethodGet, r.Method == http.MethodHead, r.Method == http.MethodOptions:
+		return scopeRead
+	default:
+		return scopeWrite
+	}
+}
+
+// requireScope lets a request through to next only if it carries a bearer
+// token granting the scope its route needs. Unless requireTokens is set,
+// only the admin routes are guarded, by the -admin-token token if one is
+// set.
+func requireScope(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		scope := requiredScope(r)
+		if len(currentConfig().tokens) == 0 || (!requireTokens && scope != scopeAdmin) {
+			next.ServeHTTP(w, r)
+			return
+		}
+		token, ok := lookupToken(r)
+		if !ok {
+			w.Header().Set("WWW-Authenticate", "Bearer")
+			writeError(w, r, http.StatusUnauthorized, "authentication_required")
+			return
+		}
+		if scope != "" && !token.grants(scope) {
+			w.Header().Set("WWW-Authenticate", fmt.Sprintf("Bearer error=\"insufficient_scope\", scope=%q", scope))
+			writeError(w, r, http.StatusForbidden, "insufficient_scope", "scope", scope)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// tokenHandler reports the scopes granted to the caller's token
+// (GET /me/token). Unless requireTokens is set, callers without a token
+// can read and write, and also administer when no admin token is set.
+func tokenHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeError(w, r, http.StatusMethodNotAllowed, "method_not_allowed")
+		return
+	}
+	if _, ok := checkQuery(w, r); !ok {
+		return
+	}
+
+	writeJSON(w, http.StatusOK, tokenInfo{Scopes: callerToken(r).effectiveScopes()})
+}
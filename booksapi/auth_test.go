@@ -0,0 +1,241 @@
+package booksapi
+
+import (
+	"net/http"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// writeTokensFile writes a tokens file holding content and returns its path.
+func writeTokensFile(t *testing.T, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "tokens")
+	if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+// TestLoadTokens checks the tokens file format.
+func TestLoadTokens(t *testing.T) {
+	tokens, err := loadTokens(writeTokensFile(t, "# scopes\nr1 read\n\n  w1   read,write  \na1 admin\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []apiToken{
+		{secret: "r1", scopes: []string{scopeRead}},
+		{secret: "w1", scopes: []string{scopeRead, scopeWrite}},
+		{secret: "a1", scopes: []string{scopeAdmin}},
+	}
+	if !reflect.DeepEqual(tokens, want) {
+		t.Errorf("loadTokens = %+v, want %+v", tokens, want)
+	}
+
+	for _, bad := range []string{"r1\n", "r1 read write\n", "r1 read,owner\n", "r1 read,\n"} {
+		if _, err := loadTokens(writeTokensFile(t, bad)); err == nil {
+			t.Errorf("loadTokens accepted %q", bad)
+		}
+	}
+	if _, err := loadTokens(filepath.Join(t.TempDir(), "missing")); err == nil {
+		t.Error("loadTokens accepted a missing file")
+	}
+
+	tokens, err = loadTokens(writeTokensFile(t, "r1 read user=ann\nw1 write\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := []string{tokens[0].userName(), tokens[1].userName()}; got[0] != "ann" || !strings.HasPrefix(got[1], "token-") || strings.Contains(got[1], "w1") {
+		t.Errorf("token users %q, want ann and one derived from the second token", got)
+	}
+	for _, bad := range []string{"r1 read ann\n", "r1 read user=\n", "r1 read user=ann extra\n"} {
+		if _, err := loadTokens(writeTokensFile(t, bad)); err == nil {
+			t.Errorf("loadTokens accepted %q", bad)
+		}
+	}
+}
+
+// TestFavoritesUser checks that once tokens are configured, favorites
+// belong to the user of the caller's token, whatever X-User it sends.
+func TestFavoritesUser(t *testing.T) {
+	h := newTestServer(t)
+	mustCreateBook(t, h, map[string]any{"title": "Dune"})
+	mustCreateBook(t, h, map[string]any{"title": "Emma"})
+	// Without tokens, X-User names the user.
+	wantCode(t, serve(t, h, http.MethodPut, "/me/favorites/1", nil, "X-User", "alice"), http.StatusNoContent)
+
+	path := writeTokensFile(t, "r1 read user=bob\nw1 write user=bob\nw2 write\n")
+	tokens, err := loadTokens(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	setForTest(t, &tokensFile, path)
+	setForTest(t, &requireTokens, true)
+	setForTest(t, &apiTokens, tokens)
+	liveConfig.Store(flagConfig())
+	favoriteTitles := func(token string) []string {
+		t.Helper()
+		rec := serve(t, h, http.MethodGet, "/me/favorites", nil, "Authorization", "Bearer "+token, "X-User", "alice")
+		wantCode(t, rec, http.StatusOK)
+		var titles []string
+		for _, book := range decode[[]Book](t, rec) {
+			titles = append(titles, book.Title)
+		}
+		return titles
+	}
+
+	if got := favoriteTitles("r1"); len(got) != 0 {
+		t.Errorf("bob's favorites %q with X-User alice, want none", got)
+	}
+	wantCode(t, serve(t, h, http.MethodPut, "/me/favorites/2", nil, "Authorization", "Bearer w1", "X-User", "alice"), http.StatusNoContent)
+	if got := favoriteTitles("r1"); !reflect.DeepEqual(got, []string{"Emma"}) {
+		t.Errorf("bob's favorites %q, want Emma", got)
+	}
+	if got := favoriteTitles("w2"); len(got) != 0 {
+		t.Errorf("favorites of a token without a user %q, want none", got)
+	}
+	favoritesMu.Lock()
+	alice := len(favorites["alice"])
+	favoritesMu.Unlock()
+	if alice != 1 {
+		t.Errorf("alice has %d favorites, want the one she set before tokens", alice)
+	}
+	wantCode(t, serve(t, h, http.MethodGet, "/me/favorites", nil, "X-User", "alice"), http.StatusUnauthorized)
+}
+
+// TestTokenScopes sends requests needing each scope with tokens of each
+// scope from a tokens file, and pins the status of each.
+func TestTokenScopes(t *testing.T) {
+	h := newTestServer(t)
+	mustCreateBook(t, h, map[string]any{"title": "Dune"})
+	path := writeTokensFile(t, "r1 read\nw1 write\na1 admin\n")
+	tokens, err := loadTokens(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	setForTest(t, &tokensFile, path)
+	setForTest(t, &requireTokens, true)
+	setForTest(t, &apiTokens, tokens)
+	liveConfig.Store(flagConfig())
+
+	requests := []struct {
+		method, path string
+		body         any
+		scope        string
+		status       int // with a token granting the scope
+	}{
+		{http.MethodGet, "/admin/data-quality", nil, scopeAdmin, http.StatusOK},
+		{http.MethodGet, "/debug/vars", nil, scopeAdmin, http.StatusOK},
+		{http.MethodGet, "/books", nil, scopeRead, http.StatusOK},
+		{http.MethodHead, "/books/1", nil, scopeRead, http.StatusOK},
+		{http.MethodPost, "/books", map[string]any{"title": "Emma"}, scopeWrite, http.StatusCreated},
+		{http.MethodDelete, "/books/999", nil, scopeWrite, http.StatusNotFound},
+	}
+	callers := []struct {
+		name   string
+		header []string
+		scope  string // the most privileged scope granted; none for no valid token
+	}{
+		{"no token", nil, ""},
+		{"unknown token", []string{"Authorization", "Bearer x1"}, ""},
+		{"not a bearer token", []string{"Authorization", "Basic a1"}, ""},
+		{"read", []string{"Authorization", "Bearer r1"}, scopeRead},
+		{"write", []string{"Authorization", "Bearer w1"}, scopeWrite},
+		{"admin", []string{"Authorization", "Bearer a1"}, scopeAdmin},
+	}
+	rank := map[string]int{"": 0, scopeRead: 1, scopeWrite: 2, scopeAdmin: 3}
+	for _, req := range requests {
+		for _, caller := range callers {
+			t.Run(req.method+" "+req.path+"/"+caller.name, func(t *testing.T) {
+				rec := serve(t, h, req.method, req.path, req.body, caller.header...)
+				switch {
+				case caller.scope == "":
+					wantCode(t, rec, http.StatusUnauthorized)
+					if got := rec.Header().Get("WWW-Authenticate"); got != "Bearer" {
+						t.Errorf("WWW-Authenticate %q, want Bearer", got)
+					}
+				case rank[caller.scope] < rank[req.scope]:
+					wantCode(t, rec, http.StatusForbidden)
+					if req.method == http.MethodHead {
+						break
+					}
+					if got := decode[errorBody](t, rec).Error; got.Code != "insufficient_scope" || got.Params["scope"] != req.scope {
+						t.Errorf("error %+v, want insufficient_scope naming %s", got, req.scope)
+					}
+					if got := rec.Header().Get("WWW-Authenticate"); !strings.Contains(got, `scope="`+req.scope+`"`) {
+						t.Errorf("WWW-Authenticate %q lacks the scope %s", got, req.scope)
+					}
+				default:
+					wantCode(t, rec, req.status)
+				}
+			})
+		}
+	}
+}
+
+// TestMeToken checks the scopes GET /me/token reports with a tokens file,
+// with only an admin token, and with no tokens at all.
+func TestMeToken(t *testing.T) {
+	all := []string{scopeRead, scopeWrite, scopeAdmin}
+	tests := []struct {
+		name   string
+		file   string // tokens file; none if empty
+		admin  string // admin token
+		header []string
+		status int
+		want   []string
+	}{
+		{name: "file, read", file: "r1 read\n", header: []string{"Authorization", "Bearer r1"}, status: http.StatusOK, want: []string{scopeRead}},
+		{name: "file, read and write", file: "w1 write,read\n", header: []string{"Authorization", "Bearer w1"}, status: http.StatusOK, want: []string{scopeRead, scopeWrite}},
+		{name: "file, admin", file: "a1 admin\n", header: []string{"Authorization", "Bearer a1"}, status: http.StatusOK, want: all},
+		{name: "file, no token", file: "a1 admin\n", status: http.StatusUnauthorized},
+		{name: "admin token, no token", admin: "a1", status: http.StatusOK, want: []string{scopeRead, scopeWrite}},
+		{name: "admin token, sent", admin: "a1", header: []string{"Authorization", "Bearer a1"}, status: http.StatusOK, want: all},
+		{name: "no tokens", status: http.StatusOK, want: all},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := newTestServer(t)
+			var tokens []apiToken
+			if tt.file != "" {
+				path := writeTokensFile(t, tt.file)
+				var err error
+				if tokens, err = loadTokens(path); err != nil {
+					t.Fatal(err)
+				}
+				setForTest(t, &tokensFile, path)
+				setForTest(t, &requireTokens, true)
+			}
+			if tt.admin != "" {
+				tokens = append(tokens, apiToken{secret: tt.admin, scopes: []string{scopeAdmin}})
+			}
+			setForTest(t, &apiTokens, tokens)
+			liveConfig.Store(flagConfig())
+
+			rec := serve(t, h, http.MethodGet, "/me/token", nil, tt.header...)
+			wantCode(t, rec, tt.status)
+			if tt.status != http.StatusOK {
+				return
+			}
+			if got := decode[tokenInfo](t, rec).Scopes; !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("scopes %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+// TestAdminTokenOnly checks that an admin token without a tokens file
+// guards the admin routes and leaves the others open.
+func TestAdminTokenOnly(t *testing.T) {
+	h := newTestServer(t)
+	setForTest(t, &apiTokens, []apiToken{{secret: "a1", scopes: []string{scopeAdmin}}})
+	liveConfig.Store(flagConfig())
+
+	mustCreateBook(t, h, map[string]any{"title": "Dune"})
+	wantCode(t, serve(t, h, http.MethodGet, "/books/1", nil), http.StatusOK)
+	wantCode(t, serve(t, h, http.MethodGet, "/admin/data-quality", nil), http.StatusUnauthorized)
+	wantCode(t, serve(t, h, http.MethodGet, "/admin/data-quality", nil, "Authorization", "Bearer nope"), http.StatusUnauthorized)
+	wantCode(t, serve(t, h, http.MethodGet, "/admin/data-quality", nil, "Authorization", "Bearer a1"), http.StatusOK)
+}
@@ -0,0 +1,156 @@
+package booksapi
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"testing"
+	"time"
+)
+
+// slowWriteStore takes delay over every write, within transactions too, as
+// a store on a slow disk would.
+type slowWriteStore struct {
+	BookStore
+	delay time.Duration
+}
+
+func (s slowWriteStore) Create(book Book) (Book, error) {
+	time.Sleep(s.delay)
+	return s.BookStore.Create(book)
+}
+
+func (s slowWriteStore) Put(book Book) error {
+	time.Sleep(s.delay)
+	return s.BookStore.Put(book)
+}
+
+func (s slowWriteStore) Transact(ctx context.Context, fn func(tx BookStore) error) error {
+	return s.BookStore.Transact(ctx, func(tx BookStore) error {
+		return fn(slowWriteStore{tx, s.delay})
+	})
+}
+
+// slowBatch is a transaction of n creates, each but the first followed by
+// an update of the book the create before it made, by ref.
+func slowBatch(n int) map[string]any {
+	var ops []map[string]any
+	previous := 0
+	for i := range n {
+		ops = append(ops, map[string]any{"op": "create", "book": map[string]any{"title": fmt.Sprint("Book ", i)}})
+		if i > 0 {
+			ops = append(ops, map[string]any{"op": "update", "ref": previous, "book": map[string]any{"title": fmt.Sprint("Book ", i-1, ", revised")}})
+			previous = len(ops) - 2
+		}
+	}
+	return map[string]any{"operations": ops}
+}
+
+// TestBatchDeadline runs a transaction against a slow store under a short
+// deadline, atomically and not, and checks the three outcomes: completed,
+// rolled back, and partial, and that a partial one resumes where it
+// stopped.
+func TestBatchDeadline(t *testing.T) {
+	h := newTestServer(t, WithStore(slowWriteStore{newMemoryStore(newSequentialIDs()), 10 * time.Millisecond}))
+	batch := slowBatch(10)
+	total := len(batch["operations"].([]map[string]any))
+
+	// Completed: the deadline leaves time for every operation.
+	setRoutePolicies(t, "POST /books/transactions timeout=10s")
+	rec := serve(t, h, http.MethodPost, "/books/transactions", slowBatch(2))
+	wantCode(t, rec, http.StatusOK)
+	if got := decode[transactionResult](t, rec); got.Outcome != txCompleted || len(got.Results) != 3 || got.StoppedAt != nil || got.Continue != "" {
+		t.Errorf("completed transaction %+v", got)
+	}
+	before := catalogJSON(t, store)
+
+	// Rolled back: an atomic transaction running out of time leaves
+	// nothing behind.
+	setRoutePolicies(t, "POST /books/transactions timeout=60ms")
+	rec = serve(t, h, http.MethodPost, "/books/transactions", batch)
+	wantCode(t, rec, http.StatusGatewayTimeout)
+	got := decode[errorBody](t, rec).Error
+	completed, _ := strconv.Atoi(got.Params["completed"])
+	if got.Code != "batch_timeout" || completed == 0 || completed >= total || got.Params["total"] != strconv.Itoa(total) {
+		t.Errorf("timed out transaction: error %s %v", got.Code, got.Params)
+	}
+	if after := catalogJSON(t, store); after != before {
+		t.Errorf("timed out transaction left %s, want %s", after, before)
+	}
+
+	// Partial: without atomicity, the operations done by the deadline are
+	// kept, and the token resumes after them.
+	rec = serve(t, h, http.MethodPost, "/books/transactions?atomic=false", batch)
+	wantCode(t, rec, http.StatusMultiStatus)
+	partial := decode[transactionResult](t, rec)
+	if partial.Outcome != txPartial || partial.Reason != txStoppedTimeout || partial.StoppedAt == nil || *partial.StoppedAt != len(partial.Results) || *partial.StoppedAt == 0 || *partial.StoppedAt >= total || partial.Continue == "" {
+		t.Fatalf("partial transaction %+v", partial)
+	}
+	for _, result := range partial.Results {
+		if _, found := store.Get(result.ID); !found {
+			t.Errorf("book %d of a kept operation is gone", result.ID)
+		}
+	}
+
+	setRoutePolicies(t, "POST /books/transactions timeout=10s")
+	rec = serve(t, h, http.MethodPost, "/books/transactions?atomic=false&continue="+url.QueryEscape(partial.Continue), batch)
+	wantCode(t, rec, http.StatusOK)
+	resumed := decode[transactionResult](t, rec)
+	if resumed.Outcome != txCompleted || resumed.ResumedAt == nil || *resumed.ResumedAt != *partial.StoppedAt || len(partial.Results)+len(resumed.Results) != total {
+		t.Fatalf("resumed transaction %+v after %+v", resumed, partial)
+	}
+	// Every book was created once, and revised by the update referring to
+	// it, across the two requests.
+	if got, want := store.Count(), 2+10; got != want {
+		t.Errorf("%d books, want %d", got, want)
+	}
+	var created []int
+	for _, result := range append(partial.Results, resumed.Results...) {
+		if result.Op == "create" {
+			created = append(created, result.ID)
+		}
+	}
+	if len(created) != 10 {
+		t.Fatalf("%d creates, want 10", len(created))
+	}
+	for i, id := range created[:9] {
+		book, found := store.Get(id)
+		if want := fmt.Sprint("Book ", i, ", revised"); !found || book.Title != want {
+			t.Errorf("book %d: %q, want %q", id, book.Title, want)
+		}
+	}
+}
+
+// TestBatchStoppedByFailure checks that a non-atomic transaction stopping
+// at a failing operation keeps the ones before it, reports the error, and
+// only resumes for the same operations.
+func TestBatchStoppedByFailure(t *testing.T) {
+	h := newTestServer(t)
+	ops := []map[string]any{
+		{"op": "create", "book": map[string]any{"title": "Dune"}},
+		{"op": "update", "id": 99, "book": map[string]any{"title": "Emma"}},
+		{"op": "update", "ref": 0, "book": map[string]any{"title": "Dune Messiah"}},
+	}
+	rec := serve(t, h, http.MethodPost, "/books/transactions?atomic=false", map[string]any{"operations": ops})
+	wantCode(t, rec, http.StatusMultiStatus)
+	partial := decode[transactionResult](t, rec)
+	if partial.Reason != txStoppedFailed || partial.Error == nil || partial.Error.Code != "book_not_found" || partial.Error.Params["index"] != "1" || *partial.StoppedAt != 1 {
+		t.Fatalf("partial transaction %+v", partial)
+	}
+
+	ops[1] = map[string]any{"op": "create", "book": map[string]any{"title": "Emma"}}
+	rec = serve(t, h, http.MethodPost, "/books/transactions?atomic=false&continue="+url.QueryEscape(partial.Continue), map[string]any{"operations": ops})
+	wantCode(t, rec, http.StatusBadRequest)
+	if got := errorCode(t, rec); got != "invalid_continuation" {
+		t.Errorf("continuation of other operations: error code %q", got)
+	}
+	for _, token := range []string{"!!", "e30"} {
+		rec := serve(t, h, http.MethodPost, "/books/transactions?continue="+token, map[string]any{"operations": ops})
+		wantCode(t, rec, http.StatusBadRequest)
+	}
+	if got := store.Count(); got != 1 {
+		t.Errorf("%d books, want the one created before the failure", got)
+	}
+}
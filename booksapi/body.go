@@ -0,0 +1,326 @@
+package booksapi
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"expvar"
+	"fmt"
+	"io"
+	"net/http"
+	"reflect"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// maxBodyBytes caps the size of JSON request bodies. It is configured by a
+// flag in Main.
+var maxBodyBytes int64 = 1 << 20
+
+// JSON handling modes. Strict decoding rejects unknown fields, data after
+// the JSON value, and values of the wrong type; lenient decoding ignores the
+// first two and converts strings holding numbers or booleans, such as a
+// price sent as "12.50".
+const (
+	handlingStrict  = "strict"
+	handlingLenient = "lenient"
+)
+
+// defaultHandling is the JSON handling mode used when a request does not
+// ask for one with Prefer: handling=strict or handling=lenient. It is
+// configured by a flag in Main.
+var defaultHandling = handlingLenient
+
+// decodeFailures counts the request bodies that could not be decoded, by
+// the code of the error reporting why, published under /debug/vars. Each of
+// decodeFailureCodes is a class of failure of its own.
+var decodeFailures = expvar.NewMap("decode_failures")
+
+// decodeFailureCodes are the codes decodeError classifies failures into,
+// and the other decoding errors decodeFailure counts.
+var decodeFailureCodes = []string{
+	"empty_body",         // nothing but whitespace
+	"invalid_json",       // a syntax error or truncated body, at offset
+	"trailing_data",      // data after the JSON value in strict mode, at offset
+	"invalid_field_type", // a value of the wrong type for field
+	"unknown_field",      // a field the type lacks, in strict mode
+	"invalid_body_type",  // a top-level value of the wrong kind
+	"request_too_large",  // more than max bytes
+	"import_too_large",   // more than max bytes once decompressed
+	"invalid_request",    // any other failure, such as a broken connection
+}
+
+// trailingDataError reports data after the JSON value of a body decoded
+// strictly.
+type trailingDataError struct {
+	offset int64 // of the data
+}
+
+func (e *trailingDataError) Error() string {
+	return fmt.Sprintf("data after the JSON value at offset %d", e.offset)
+}
+
+// decodeError returns the apiError reporting err, a failure to read or
+// decode a JSON request body, in one of decodeFailureCodes, with the byte
+// offset or the field the encoding/json error gives. An apiError is
+// returned as it is.
+func decodeError(err error) *apiError {
+	var e *apiError
+	var tooLarge *http.MaxBytesError
+	var syntaxErr *json.SyntaxError
+	var typeErr *json.UnmarshalTypeError
+	var trailing *trailingDataError
+	switch {
+	case errors.As(err, &e):
+		return e
+	case errors.As(err, &tooLarge):
+		return newAPIError(http.StatusRequestEntityTooLarge, "request_too_large", "max", tooLarge.Limit)
+	case errors.Is(err, io.EOF):
+		return newAPIError(http.StatusBadRequest, "empty_body")
+	case errors.As(err, &syntaxErr):
+		return newAPIError(http.StatusBadRequest, "invalid_json", "offset", syntaxErr.Offset)
+	case errors.As(err, &typeErr):
+		return newAPIError(http.StatusBadRequest, "invalid_field_type", "field", typeErr.Field, "type", jsonTypeName(typeErr.Type))
+	case errors.As(err, &trailing):
+		return newAPIError(http.StatusBadRequest, "trailing_data", "offset", trailing.offset)
+	}
+	if field, ok := strings.CutPrefix(err.Error(), "json: unknown field "); ok {
+		return newAPIError(http.StatusBadRequest, "unknown_field", "field", strings.Trim(field, `"`))
+	}
+	return newAPIError(http.StatusBadRequest, "invalid_request")
+}
+
+// decodeFailure is decodeError for a body of r, data, or for a streamed
+// body if data is nil. The failure is counted in decodeFailures and, with
+// -debug, logged with a fingerprint of the body rather than the body
+// itself, which may hold personal data. A truncated body, which the
+// decoder reports as an unexpected EOF, is a syntax error at its end.
+func decodeFailure(r *http.Request, data []byte, err error) error {
+	e := decodeError(err)
+	if errors.Is(err, io.ErrUnexpectedEOF) && data != nil {
+		e = newAPIError(http.StatusBadRequest, "invalid_json", "offset", len(data))
+	}
+	if r == nil || !slices.Contains(decodeFailureCodes, e.Code) {
+		return e
+	}
+	decodeFailures.Add(e.Code, 1)
+	if currentConfig().debug {
+		fingerprint := "streamed"
+		if data != nil {
+			fingerprint = bodyFingerprint(data)
+		}
+		logger.Printf("DEBUG decode failure: request=%s %s %s code=%s params=%v body=%s", requestID(r), r.Method, r.URL.Path, e.Code, e.Params, fingerprint)
+	}
+	return e
+}
+
+// bodyFingerprint identifies a body in logs without revealing it: its
+// length, its first bytes in hex, and a prefix of its SHA-256 hash, which
+// tells repeats of the same body apart from others.
+func bodyFingerprint(data []byte) string {
+	const headBytes = 8
+	head := data[:min(len(data), headBytes)]
+	sum := sha256.Sum256(data)
+	return fmt.Sprintf("len=%d head=%x sha256=%x", len(data), head, sum[:8])
+}
+
+// decodeJSON decodes the JSON request body into v using the request's
+// handling mode, which is echoed in the Preference-Applied header. Bodies
+// that are too large or cannot be decoded are rejected with an apiError.
+// Numbers decoded into interface values are kept as json.Number, so they
+// survive a round trip unchanged. Renamed fields may be given under either
+// name; see resolveAliases.
+func decodeJSON(w http.ResponseWriter, r *http.Request, v any) error {
+	mode := requestHandling(r)
+	w.Header().Set("Preference-Applied", "handling="+mode)
+
+	limit := bodyLimit(r, maxBodyBytes)
+	data, err := io.ReadAll(http.MaxBytesReader(w, r.Body, limit))
+	if err != nil {
+		return decodeFailure(r, nil, err)
+	}
+	if data, err = bodyUTF8(r, data); err != nil {
+		return err
+	}
+	return decodeBody(r, data, v, mode == handlingStrict)
+}
+
+// decodeBody decodes data, the body of r, into v, strictly or leniently.
+// The top-level JSON value must be of the kind v decodes from, so an array
+// sent for a single book is rejected rather than partly decoded. The old
+// names of renamed fields are reported in warnings to r. Failures are
+// reported by decodeFailure.
+func decodeBody(r *http.Request, data []byte, v any, strict bool) error {
+	if err := checkJSONKind(data, jsonTypeName(reflect.TypeOf(v))); err != nil {
+		return decodeFailure(r, data, err)
+	}
+	// Failures are fingerprinted by the body as sent, not as the aliases
+	// and conversions rewrite it. A body is only rewritten once it is known
+	// to hold a single valid JSON value, so the offsets hold for body too.
+	body := data
+	data, err := resolveBodyAliases(r, data, reflect.TypeOf(v))
+	if err != nil {
+		return err
+	}
+	// Each retry converts one more mismatched value, so a body cannot cause
+	// more retries than it has fields.
+	for {
+		dec := json.NewDecoder(bytes.NewReader(data))
+		dec.UseNumber()
+		if strict {
+			dec.DisallowUnknownFields()
+		}
+		err := dec.Decode(v)
+		if err == nil {
+			if strict {
+				offset := dec.InputOffset()
+				if err := dec.Decode(&json.RawMessage{}); err != io.EOF {
+					return decodeFailure(r, body, &trailingDataError{offset})
+				}
+			}
+			return nil
+		}
+
+		var typeErr *json.UnmarshalTypeError
+		if errors.As(err, &typeErr) && !strict {
+			if converted, ok := convertField(data, typeErr); ok {
+				data = converted
+				continue
+			}
+		}
+		return decodeFailure(r, body, err)
+	}
+}
+
+// convertField rewrites the string value a type error complains about into
+// the number or boolean it holds, returning false if it holds neither.
+func convertField(data []byte, typeErr *json.UnmarshalTypeError) ([]byte, bool) {
+	if typeErr.Value != "string" || typeErr.Field == "" {
+		return nil, false
+	}
+	var doc any
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	if err := dec.Decode(&doc); err != nil {
+		return nil, false
+	}
+
+	path := strings.Split(typeErr.Field, ".")
+	obj, ok := doc.(map[string]any)
+	for _, name := range path[:len(path)-1] {
+		if !ok {
+			return nil, false
+		}
+		obj, ok = obj[name].(map[string]any)
+	}
+	if !ok {
+		return nil, false
+	}
+	name := path[len(path)-1]
+	s, ok := obj[name].(string)
+	if !ok {
+		return nil, false
+	}
+
+	s = strings.TrimSpace(s)
+	switch jsonTypeName(typeErr.Type) {
+	case "number":
+		if _, err := strconv.ParseFloat(s, 64); err != nil {
+			return nil, false
+		}
+		obj[name] = json.Number(s)
+	case "boolean":
+		b, err := strconv.ParseBool(s)
+		if err != nil {
+			return nil, false
+		}
+		obj[name] = b
+	default:
+		return nil, false
+	}
+
+	converted, err := json.Marshal(doc)
+	return converted, err == nil
+}
+
+// checkJSONKind returns an error unless data holds a JSON value of the
+// expected kind, as named by jsonTypeName. Any kind is accepted by "any",
+// but not an empty body.
+func checkJSONKind(data []byte, expected string) error {
+	got := jsonValueKind(data)
+	if got == expected || expected == "any" {
+		return nil
+	}
+	if got == "" {
+		return newAPIError(http.StatusBadRequest, "empty_body")
+	}
+	return newAPIError(http.StatusBadRequest, "invalid_body_type", "expected", expected, "got", got)
+}
+
+// jsonValueKind returns the kind of the JSON value data starts with, telling
+// it from its first token: object, array, string, number, boolean, or null.
+// It returns "" for an empty body.
+func jsonValueKind(data []byte) string {
+	data = bytes.TrimLeft(data, " \t\r\n")
+	if len(data) == 0 {
+		return ""
+	}
+	switch c := data[0]; {
+	case c == '{':
+		return "object"
+	case c == '[':
+		return "array"
+	case c == '"':
+		return "string"
+	case c == 't', c == 'f':
+		return "boolean"
+	case c == 'n':
+		return "null"
+	default:
+		return "number"
+	}
+}
+
+// jsonTypeName returns the JSON name of the values a Go type decodes from.
+func jsonTypeName(t reflect.Type) string {
+	for t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+	switch t.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64,
+		reflect.Float32, reflect.Float64:
+		return "number"
+	case reflect.Bool:
+		return "boolean"
+	case reflect.String:
+		return "string"
+	case reflect.Slice, reflect.Array:
+		return "array"
+	case reflect.Interface:
+		return "any"
+	default:
+		return "object"
+	}
+}
+
+// requestHandling returns the JSON handling mode requested with the Prefer
+// header, or defaultHandling.
+func requestHandling(r *http.Request) string {
+	for _, header := range r.Header.Values("Prefer") {
+		for _, pref := range strings.Split(header, ",") {
+			pref, _, _ = strings.Cut(pref, ";")
+			name, value, _ := strings.Cut(strings.TrimSpace(pref), "=")
+			if !strings.EqualFold(strings.TrimSpace(name), "handling") {
+				continue
+			}
+			switch value = strings.ToLower(strings.Trim(strings.TrimSpace(value), `"`)); value {
+			case handlingStrict, handlingLenient:
+				return value
+			}
+		}
+	}
+	return defaultHandling
+}
@@ -0,0 +1,271 @@
+package booksapi
+
+import (
+	"bytes"
+	"expvar"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// TestHandlingModes sends the same payloads in strict and lenient mode, as
+// asked for with Prefer or set by -json-handling, and checks each mode's
+// outcome and that it is echoed in Preference-Applied.
+func TestHandlingModes(t *testing.T) {
+	tests := []struct {
+		name     string
+		body     string
+		strict   string // error code in strict mode
+		lenient  string // error code in lenient mode, if any
+		wantBook Book   // stored in lenient mode
+	}{
+		{
+			name: "unknown field", body: `{"title": "Dune", "colour": "red"}`,
+			strict: "unknown_field", wantBook: Book{Title: "Dune"},
+		},
+		{
+			name: "trailing data", body: `{"title": "Dune"} {"title": "Emma"}`,
+			strict: "trailing_data", wantBook: Book{Title: "Dune"},
+		},
+		{
+			name: "number as a string", body: `{"title": "Dune", "price": " 12.5 "}`,
+			strict: "invalid_field_type", wantBook: Book{Title: "Dune", Price: 12.5},
+		},
+		{
+			name: "several numbers as strings", body: `{"title": "Dune", "price": "12.5", "cost_price": "4"}`,
+			strict: "invalid_field_type", wantBook: Book{Title: "Dune", Price: 12.5, CostPrice: &[]Price{4}[0]},
+		},
+		{
+			name: "string that is no number", body: `{"title": "Dune", "price": "cheap"}`,
+			strict: "invalid_field_type", lenient: "invalid_field_type",
+		},
+		{
+			name: "number for a string", body: `{"title": 12}`,
+			strict: "invalid_field_type", lenient: "invalid_field_type",
+		},
+	}
+	modes := []struct {
+		name     string
+		header   []string
+		fallback string // defaultHandling
+		mode     string
+	}{
+		{"Prefer strict", []string{"Prefer", "handling=strict"}, handlingLenient, handlingStrict},
+		{"Prefer lenient", []string{"Prefer", "handling=lenient"}, handlingStrict, handlingLenient},
+		{"default strict", nil, handlingStrict, handlingStrict},
+		{"default lenient", nil, handlingLenient, handlingLenient},
+	}
+	for _, tt := range tests {
+		for _, m := range modes {
+			t.Run(tt.name+"/"+m.name, func(t *testing.T) {
+				h := newTestServer(t)
+				setForTest(t, &defaultHandling, m.fallback)
+				rec := serve(t, h, http.MethodPost, "/books", tt.body, m.header...)
+				if got := rec.Header().Get("Preference-Applied"); got != "handling="+m.mode {
+					t.Errorf("Preference-Applied %q, want handling=%s", got, m.mode)
+				}
+
+				code := tt.lenient
+				if m.mode == handlingStrict {
+					code = tt.strict
+				}
+				if code != "" {
+					wantCode(t, rec, http.StatusBadRequest)
+					if got := errorCode(t, rec); got != code {
+						t.Errorf("error code %q, want %q", got, code)
+					}
+					if n := store.Count(); n != 0 {
+						t.Errorf("%d books stored", n)
+					}
+					return
+				}
+				wantCode(t, rec, http.StatusCreated)
+				got, _ := store.Get(decode[Book](t, rec).ID)
+				if got.Title != tt.wantBook.Title || got.Price != tt.wantBook.Price ||
+					(got.CostPrice == nil) != (tt.wantBook.CostPrice == nil) ||
+					(got.CostPrice != nil && *got.CostPrice != *tt.wantBook.CostPrice) {
+					t.Errorf("stored %+v, want %+v", got, tt.wantBook)
+				}
+			})
+		}
+	}
+}
+
+// TestRequestHandling checks how the handling mode is read from Prefer.
+func TestRequestHandling(t *testing.T) {
+	setForTest(t, &defaultHandling, handlingLenient)
+	tests := []struct {
+		prefer []string
+		want   string
+	}{
+		{nil, handlingLenient},
+		{[]string{"handling=strict"}, handlingStrict},
+		{[]string{`Handling = "STRICT"`}, handlingStrict},
+		{[]string{"respond-async, handling=strict; foo=bar"}, handlingStrict},
+		{[]string{"return=minimal", "handling=strict"}, handlingStrict},
+		{[]string{"handling=pedantic"}, handlingLenient},
+		{[]string{"handling=pedantic, handling=strict"}, handlingStrict},
+	}
+	for _, tt := range tests {
+		r := httptest.NewRequest(http.MethodPost, "/books", nil)
+		for _, v := range tt.prefer {
+			r.Header.Add("Prefer", v)
+		}
+		if got := requestHandling(r); got != tt.want {
+			t.Errorf("Prefer %q: %s, want %s", tt.prefer, got, tt.want)
+		}
+	}
+}
+
+// TestBodyKinds sends top-level values of each kind to endpoints that take
+// an object and to the import, which takes an array or a backup document,
+// and pins the error messages.
+func TestBodyKinds(t *testing.T) {
+	h := newTestServer(t)
+	mustCreateBook(t, h, map[string]any{"title": "Dune"})
+	bodies := []struct {
+		name string
+		body string
+	}{
+		{"object", `{"title": "Emma"}`},
+		{"array", `[{"title": "Emma"}]`},
+		{"number", `5`},
+		{"string", ` "Emma"`},
+		{"boolean", `true`},
+		{"null", `null`},
+		{"empty", ``},
+	}
+	endpoints := []struct {
+		method, path string
+		status       int               // for an accepted body
+		messages     map[string]string // body name -> error message
+	}{
+		{http.MethodPost, "/books", http.StatusCreated, map[string]string{
+			"array":   "Expected JSON object, got array",
+			"number":  "Expected JSON object, got number",
+			"string":  "Expected JSON object, got string",
+			"boolean": "Expected JSON object, got boolean",
+			"null":    "Expected JSON object, got null",
+			"empty":   "Request body is empty",
+		}},
+		{http.MethodPut, "/books/1", http.StatusOK, map[string]string{
+			"array":   "Expected JSON object, got array",
+			"number":  "Expected JSON object, got number",
+			"string":  "Expected JSON object, got string",
+			"boolean": "Expected JSON object, got boolean",
+			"null":    "Expected JSON object, got null",
+			"empty":   "Request body is empty",
+		}},
+		{http.MethodPost, "/books/import", http.StatusOK, map[string]string{
+			"object":  "Backup document lacks its count",
+			"number":  "Expected JSON object, got number",
+			"string":  "Expected JSON object, got string",
+			"boolean": "Expected JSON object, got boolean",
+			"null":    "Expected JSON object, got null",
+			"empty":   "Request body is empty",
+		}},
+	}
+	for _, ep := range endpoints {
+		for _, b := range bodies {
+			t.Run(ep.method+" "+ep.path+" "+b.name, func(t *testing.T) {
+				rec := serve(t, h, ep.method, ep.path, b.body, "Content-Type", "application/json")
+				want, fails := ep.messages[b.name]
+				if !fails {
+					wantCode(t, rec, ep.status)
+					return
+				}
+				if rec.Code < 400 {
+					t.Fatalf("status %d, want an error", rec.Code)
+				}
+				if got := decode[errorBody](t, rec).Error.Message; got != want {
+					t.Errorf("message %q, want %q", got, want)
+				}
+			})
+		}
+	}
+}
+
+// decodeFailureCount returns the count of code in decodeFailures.
+func decodeFailureCount(code string) int64 {
+	if v, ok := decodeFailures.Get(code).(*expvar.Int); ok {
+		return v.Value()
+	}
+	return 0
+}
+
+// TestDecodeFailures sends one body per class of decoding failure and pins
+// its error code, the offset or field reported, the counter incremented,
+// and the fingerprint logged with -debug in place of the body.
+func TestDecodeFailures(t *testing.T) {
+	var logs bytes.Buffer
+	setForTest(t, &debugLog, true)
+	setForTest(t, &maxBodyBytes, 64)
+	h := newTestServer(t, WithLogger(log.New(&logs, "", 0)))
+	strict := []string{"Prefer", "handling=strict"}
+	tests := []struct {
+		name   string
+		body   string
+		header []string
+		status int
+		code   string
+		params map[string]string
+	}{
+		{"empty", " \n", nil, http.StatusBadRequest, "empty_body", nil},
+		{"syntax", `{"title": "Dune",}`, nil, http.StatusBadRequest, "invalid_json", map[string]string{"offset": "18"}},
+		{"truncated", `{"title": "Du`, nil, http.StatusBadRequest, "invalid_json", map[string]string{"offset": "13"}},
+		{"trailing", `{"title": "Dune"} {}`, strict, http.StatusBadRequest, "trailing_data", map[string]string{"offset": "17"}},
+		{"type", `{"title": 5}`, nil, http.StatusBadRequest, "invalid_field_type", map[string]string{"field": "title", "type": "string"}},
+		{"unknown", `{"title": "Dune", "colour": "red"}`, strict, http.StatusBadRequest, "unknown_field", map[string]string{"field": "colour"}},
+		{"kind", `["Dune"]`, nil, http.StatusBadRequest, "invalid_body_type", map[string]string{"expected": "object", "got": "array"}},
+		{"large", `{"title": "` + strings.Repeat("a", 100) + `"}`, nil, http.StatusRequestEntityTooLarge, "request_too_large", map[string]string{"max": "64"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			logs.Reset()
+			before := decodeFailureCount(tt.code)
+			rec := serve(t, h, http.MethodPost, "/books", tt.body, tt.header...)
+			wantCode(t, rec, tt.status)
+			got := decode[errorBody](t, rec).Error
+			if got.Code != tt.code {
+				t.Errorf("error code %q, want %q", got.Code, tt.code)
+			}
+			for name, want := range tt.params {
+				if got.Params[name] != want {
+					t.Errorf("param %s %q, want %q", name, got.Params[name], want)
+				}
+			}
+			if n := decodeFailureCount(tt.code) - before; n != 1 {
+				t.Errorf("%s counted %d times, want once", tt.code, n)
+			}
+
+			line := logs.String()
+			if !strings.Contains(line, "DEBUG decode failure:") || !strings.Contains(line, "code="+tt.code) {
+				t.Fatalf("failure not logged: %q", line)
+			}
+			if strings.Contains(line, "Dune") || strings.Contains(line, `"red"`) {
+				t.Errorf("log reveals the body: %q", line)
+			}
+			if tt.code != "request_too_large" && !strings.Contains(line, "body=len="+strconv.Itoa(len(tt.body))+" ") {
+				t.Errorf("log lacks the fingerprint of the body: %q", line)
+			}
+		})
+	}
+}
+
+// TestBodyFingerprint checks that a fingerprint gives a body's length and
+// first bytes, and tells different bodies of the same length apart.
+func TestBodyFingerprint(t *testing.T) {
+	a := bodyFingerprint([]byte(`{"title": "Dune"}`))
+	if !strings.HasPrefix(a, "len=17 head=7b227469746c6522 sha256=") || len(a) != len("len=17 head=7b227469746c6522 sha256=")+16 {
+		t.Errorf("fingerprint %q", a)
+	}
+	if b := bodyFingerprint([]byte(`{"title": "Emma"}`)); b[:36] != a[:36] || b == a {
+		t.Errorf("fingerprints %q and %q", a, b)
+	}
+	if got := bodyFingerprint([]byte("{}")); !strings.HasPrefix(got, "len=2 head=7b7d sha256=") {
+		t.Errorf("fingerprint of a short body %q", got)
+	}
+}
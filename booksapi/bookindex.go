@@ -0,0 +1,118 @@
+package booksapi
+
+import (
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"golang.org/x/text/unicode/norm"
+)
+
+// indexFields lists the fields an alphabetical index can group books by.
+var indexFields = []string{"title", "author"}
+
+// maxIndexEntries caps ?entries= of GET /books/index.
+const maxIndexEntries = 20
+
+// bookIndexParams declares the query parameters of GET /books/index.
+var bookIndexParams = slices.Concat(bookViewParams, []queryParam{
+	{name: "by", kind: enumParam, values: indexFields},
+	{name: "entries", kind: intParam},
+})
+
+// bookIndex is the response body of GET /books/index.
+type bookIndex struct {
+	By     string           `json:"by"`
+	Total  int              `json:"total"`
+	Groups []bookIndexGroup `json:"groups"`
+}
+
+// bookIndexGroup is one group of an alphabetical index, with its first
+// books when ?entries= asks for them.
+type bookIndexGroup struct {
+	Key     string         `json:"key"`
+	Count   int            `json:"count"`
+	Entries []bookResponse `json:"entries,omitempty"`
+}
+
+// getBookIndex handles GET /books/index, the alphabetical index of the
+// catalog for browse-by-letter views: the groups indexGroup puts books in
+// by ?by= (title by default), in collation order with "#" last, and how
+// many books each holds. ?entries=N adds the first N books of each group,
+// ordered by the same field. GET /books?starts_with={key}&by= lists a
+// group's books. Archived books are left out, as they are from listings.
+func getBookIndex(w http.ResponseWriter, r *http.Request) {
+	q, ok := checkQuery(w, r, bookIndexParams...)
+	if !ok {
+		return
+	}
+	by, err := singleValue(q, bookIndexParams, "by")
+	if err != nil {
+		writeAPIError(w, r, err)
+		return
+	}
+	if by == "" {
+		by = "title"
+	}
+	entries := q.Int("entries")
+	if entries > maxIndexEntries {
+		writeError(w, r, http.StatusBadRequest, "invalid_query_parameter", "name", "entries", "expected", "integer up to "+strconv.Itoa(maxIndexEntries))
+		return
+	}
+
+	list := store.List(ListOptions{Sort: []string{by}})
+	books := make(map[string][]Book)
+	var keys []string
+	for _, book := range list {
+		key := indexGroup(indexField(book, by))
+		if _, found := books[key]; !found {
+			keys = append(keys, key)
+		}
+		books[key] = append(books[key], book)
+	}
+	sortIndexGroups(keys)
+
+	index := bookIndex{By: by, Total: len(list), Groups: make([]bookIndexGroup, 0, len(keys))}
+	for _, key := range keys {
+		group := bookIndexGroup{Key: key, Count: len(books[key])}
+		if entries > 0 {
+			group.Entries = renderBooks(r, paginate(books[key], entries, 0))
+		}
+		index.Groups = append(index.Groups, group)
+	}
+	writeJSON(w, http.StatusOK, index)
+}
+
+// indexField returns the field of book named by, "title" or "author", an
+// empty by meaning "title".
+func indexField(book Book, by string) string {
+	if by == "author" {
+		return book.Author
+	}
+	return book.Title
+}
+
+// parseIndexGroup returns the index group ?starts_with= selects: a single
+// character, grouped as indexGroup groups titles, so "é" selects "E", or
+// "#" for titles not starting with a letter.
+func parseIndexGroup(value string) (string, error) {
+	value = norm.NFC.String(strings.TrimSpace(value))
+	if utf8.RuneCountInString(value) != 1 {
+		return "", newAPIError(http.StatusBadRequest, "invalid_query_parameter", "name", "starts_with", "expected", "single character")
+	}
+	return indexGroup(value), nil
+}
+
+// filterByIndexGroup keeps only the books whose field named by is listed
+// under group in an alphabetical index.
+func filterByIndexGroup(list []Book, by, group string) []Book {
+	filtered := list[:0]
+	for _, book := range list {
+		if indexGroup(indexField(book, by)) == group {
+			filtered = append(filtered, book)
+		}
+	}
+	return filtered
+}
@@ -0,0 +1,120 @@
+package booksapi
+
+import (
+	"net/http"
+	"net/url"
+	"slices"
+	"testing"
+)
+
+// indexTitles are titles in several scripts, with accents, leading
+// punctuation, and digits.
+var indexTitles = []struct{ title, author string }{
+	{"Émile", "Jean-Jacques Rousseau"},
+	{"emma", "Jane Austen"},
+	{"Ender's Game", "Orson Scott Card"},
+	{"Über allen Gipfeln", "Johann Wolfgang von Goethe"},
+	{"ulysses", "James Joyce"},
+	{"Łódź stories", "Anonymous"},
+	{"Æsop's Fables", "Aesop"},
+	{"'Salem's Lot", "Stephen King"},
+	{"¡Hola!", "Anonymous"},
+	{"1984", "George Orwell"},
+	{"...", "Anonymous"},
+	{"Война и мир", "Лев Толстой"},
+	{"東京物語", "小津安二郎"},
+	{"한국 소설", "김영하"},
+	{"がっこう", "ひらがな"},
+}
+
+// TestIndexGroup pins the group of a title of each kind.
+func TestIndexGroup(t *testing.T) {
+	want := []string{"E", "E", "E", "U", "U", "Ł", "Æ", "S", "H", "#", "#", "В", "東", "ᄒ", "か"}
+	for i, tt := range indexTitles {
+		if got := indexGroup(tt.title); got != want[i] {
+			t.Errorf("indexGroup(%q) = %q, want %q", tt.title, got, want[i])
+		}
+	}
+}
+
+// TestBookIndex pins the index of a catalog with titles in several
+// scripts, by title and by author, and checks that each group's books are
+// listed by ?starts_with=.
+func TestBookIndex(t *testing.T) {
+	h := newTestServer(t)
+	for _, tt := range indexTitles {
+		mustCreateBook(t, h, map[string]any{"title": tt.title, "author": tt.author})
+	}
+
+	rec := serve(t, h, http.MethodGet, "/books/index", nil)
+	wantCode(t, rec, http.StatusOK)
+	checkGolden(t, "index/title.json", rec.Body.Bytes())
+	rec = serve(t, h, http.MethodGet, "/books/index?by=author&entries=1", nil)
+	wantCode(t, rec, http.StatusOK)
+	checkGolden(t, "index/author.json", rec.Body.Bytes())
+
+	index := decode[bookIndex](t, serve(t, h, http.MethodGet, "/books/index", nil))
+	total := 0
+	for _, group := range index.Groups {
+		total += group.Count
+		// The group's key selects its books, in the order of the field.
+		rec := serve(t, h, http.MethodGet, "/books?by=title&sort=title&starts_with="+url.QueryEscape(group.Key), nil)
+		wantCode(t, rec, http.StatusOK)
+		books := decode[[]Book](t, rec)
+		if len(books) != group.Count {
+			t.Errorf("group %q: %d books listed, want %d", group.Key, len(books), group.Count)
+		}
+		for _, book := range books {
+			if indexGroup(book.Title) != group.Key {
+				t.Errorf("group %q lists %q", group.Key, book.Title)
+			}
+		}
+	}
+	if total != len(indexTitles) || index.Total != total {
+		t.Errorf("groups hold %d books, total %d; want %d", total, index.Total, len(indexTitles))
+	}
+}
+
+// TestStartsWith checks that ?starts_with= takes any letter of a group and
+// pages through it, and rejects values that are not one character.
+func TestStartsWith(t *testing.T) {
+	h := newTestServer(t)
+	for _, tt := range indexTitles {
+		mustCreateBook(t, h, map[string]any{"title": tt.title, "author": tt.author})
+	}
+
+	titles := func(path string) []string {
+		t.Helper()
+		rec := serve(t, h, http.MethodGet, path, nil)
+		wantCode(t, rec, http.StatusOK)
+		var titles []string
+		for _, book := range decode[[]Book](t, rec) {
+			titles = append(titles, book.Title)
+		}
+		return titles
+	}
+	all := []string{"Émile", "emma", "Ender's Game"}
+	for _, letter := range []string{"E", "e", "é", "É", url.QueryEscape("é")} {
+		if got := titles("/books?sort=title&starts_with=" + letter); !slices.Equal(got, all) {
+			t.Errorf("starts_with=%s: %q, want %q", letter, got, all)
+		}
+	}
+	first, second := titles("/books?sort=title&starts_with=E&limit=2"), titles("/books?sort=title&starts_with=E&limit=2&offset=2")
+	if got := append(first, second...); !slices.Equal(got, all) {
+		t.Errorf("pages %q, want %q", got, all)
+	}
+	if got := titles("/books?by=author&sort=author&starts_with=J"); !slices.Equal(got, []string{"ulysses", "emma", "Émile", "Über allen Gipfeln"}) {
+		t.Errorf("authors under J: %q", got)
+	}
+	if got := titles("/books?sort=title&starts_with=%23"); !slices.Equal(got, []string{"...", "1984"}) {
+		t.Errorf("titles under #: %q", got)
+	}
+
+	for _, value := range []string{"", "ab", "%20"} {
+		rec := serve(t, h, http.MethodGet, "/books?starts_with="+value, nil)
+		wantCode(t, rec, http.StatusBadRequest)
+		if got := decode[errorBody](t, rec).Error; got.Code != "invalid_query_parameter" || got.Params["name"] != "starts_with" {
+			t.Errorf("starts_with=%q: error %s %v", value, got.Code, got.Params)
+		}
+	}
+}
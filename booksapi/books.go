@@ -0,0 +1,767 @@
+package booksapi
+
+import (
+	"encoding/json"
+	"expvar"
+	"fmt"
+	"maps"
+	"math"
+	"net/http"
+	"reflect"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Book represents a book item with an ID, title, author, and price.
+type Book struct {
+	ID     int    `json:"id"`
+	Title  string `json:"title"`
+	Author string `json:"author"`
+	Price  Price  `json:"price"` // the price of the cheapest edition, if the book has editions
+
+	CostPrice *Price `json:"cost_price,omitempty"` // wholesale price, hidden from callers without write scope
+
+	Currency string `json:"currency,omitempty"` // ISO 4217 code, e.g. "EUR"; empty means -currency
+	ISBN     string `json:"isbn,omitempty"`     // ISBN-10 or ISBN-13, without hyphens
+
+	Editions []Edition `json:"editions,omitempty"` // the formats the book is sold in, each once
+
+	Description string `json:"description,omitempty"`
+	Language    string `json:"language,omitempty"` // BCP 47 tag, e.g. "en" or "pt-BR"
+	PublisherID *int   `json:"publisher_id,omitempty"`
+	SeriesID    *int   `json:"series_id,omitempty"`
+	SeriesIndex int    `json:"series_index,omitempty"`
+
+	Attributes map[string]string `json:"attributes,omitempty"` // custom attributes declared by -attributes
+
+	// Set by the server; nil for books stored before timestamps existed.
+	CreatedAt *time.Time `json:"created_at,omitempty"`
+	UpdatedAt *time.Time `json:"updated_at,omitempty"`
+}
+
+// maxDescriptionLength is the maximum number of characters in a description.
+const maxDescriptionLength = 5000
+
+// mu guards the catalog for changes that must see a consistent view of it.
+// Changes spanning the catalog, such as transactions, merges, and imports,
+// hold it exclusively. Writes to a single book hold it shared, together
+// with the lock of that book, so writes to different books run concurrently
+// as far as the store allows; they take crossBookMu only for the checks
+// that span several books. Plain reads go straight to the store without it.
+var mu sync.RWMutex
+
+// crossBookMu serializes, among writers holding mu shared, the checks that
+// span several books (the quota, reserved IDs, series volume numbers,
+// edition ISBNs) with the write they allow.
+var crossBookMu sync.Mutex
+
+// bookMus serialize the writes to the same book among writers holding mu
+// shared. A book uses the lock of its ID modulo their count.
+var bookMus [64]sync.Mutex
+
+// lockBook locks the catalog for a write to the book with the given ID,
+// taking mu shared and the lock of the book, and returns the function
+// releasing them.
+func lockBook(id int) (unlock func()) {
+	mu.RLock()
+	m := &bookMus[uint(id)%uint(len(bookMus))]
+	m.Lock()
+	return func() {
+		m.Unlock()
+		mu.RUnlock()
+	}
+}
+
+// lockCrossBook takes crossBookMu if needed is set, and returns the
+// function releasing it. Callers must hold mu.
+func lockCrossBook(needed bool) (unlock func()) {
+	if !needed {
+		return func() {}
+	}
+	crossBookMu.Lock()
+	return crossBookMu.Unlock
+}
+
+// crossBookChecks reports whether storing book needs checks against the
+// other books of the catalog: it claims a series volume number or edition
+// ISBNs.
+func crossBookChecks(book Book) bool {
+	return book.SeriesID != nil || slices.ContainsFunc(book.Editions, func(e Edition) bool { return e.ISBN != "" })
+}
+
+// NewServer returns the HTTP handler serving the whole API, wrapped in the
+// middleware enabled by the flags. Every handler shares the package's
+// catalog state, so servers built by separate calls see the same books.
+// The store is instrumented with metrics, whichever backend it is.
+func NewServer() http.Handler {
+	store = instrumentStore(store)
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/books", booksHandler)
+	mux.HandleFunc("/books/", bookHandler) // For specific book actions (get, update, delete)
+	mux.HandleFunc("/shelves", shelvesHandler)
+	mux.HandleFunc("/shelves/", shelfHandler) // For specific shelf actions and shelf membership
+	mux.HandleFunc("/publishers", publishersHandler)
+	mux.HandleFunc("/publishers/", publisherHandler) // For specific publisher actions and publisher books
+	mux.HandleFunc("/series", seriesCollectionHandler)
+	mux.HandleFunc("/series/", seriesHandler) // For specific series actions and series volumes
+	mux.HandleFunc("/filters", filtersHandler)
+	mux.HandleFunc("/filters/", filterHandler) // For specific saved filter actions and the books they select
+	mux.HandleFunc("/imports", importsHandler)
+	mux.HandleFunc("/imports/", importHandler) // For import session chunks, commits, and progress
+	mux.HandleFunc("/feeds/price-drops.atom", priceDropFeedHandler)
+	mux.HandleFunc("/me/favorites", favoritesHandler)
+	mux.HandleFunc("/me/favorites/", favoritesHandler)
+	mux.HandleFunc("/me/token", tokenHandler)
+	mux.HandleFunc("/admin/snapshot", adminSnapshotHandler)
+	mux.HandleFunc("/admin/verify", adminVerifyHandler)
+	mux.HandleFunc("/admin/data-quality", adminDataQualityHandler)
+	mux.HandleFunc("/admin/reload", adminReloadHandler)
+	mux.HandleFunc("/admin/reindex", adminReindexHandler)
+	mux.HandleFunc("/admin/export", adminExportHandler)
+	mux.HandleFunc("/admin/merge", adminMergeHandler)
+	mux.HandleFunc("/admin/shadow/report", adminShadowReportHandler)
+	mux.HandleFunc("/healthz", healthzHandler)
+	mux.HandleFunc("/readyz", readyzHandler)
+	mux.HandleFunc("/version", versionHandler)
+	mux.HandleFunc("/", notFoundHandler)
+	mux.Handle("/debug/vars", expvar.Handler())
+
+	var handler http.Handler = requireScope(cacheResponses(collectWarnings(warnWhenDegraded(mux))))
+	if replica != nil {
+		handler = refuseReplicaWrites(handler)
+	}
+	if allowMethodOverride {
+		handler = methodOverride(handler)
+	}
+	handler = applyRoutePolicies(handler)
+	return stripBasePath(serverHeader(assignRequestID(setCacheHeaders(compressResponses(recordExchanges(recoverPanics(limitConcurrency(handler))))))))
+}
+
+// booksHandler handles general book collection operations (GET, HEAD, POST).
+func booksHandler(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet, http.MethodHead:
+		getBooks(w, r)
+	case http.MethodPost:
+		createBook(w, r)
+	default:
+		writeError(w, r, http.StatusMethodNotAllowed, "method_not_allowed")
+	}
+}
+
+// bookHandler handles operations on a specific book (GET, HEAD, PUT, PATCH,
+// DELETE) and its cover, full description, and edit lock.
+func bookHandler(w http.ResponseWriter, r *http.Request) {
+	if handler, method, ok := bookCollectionAction(r.URL.Path); ok {
+		if r.Method != method && !(method == http.MethodGet && r.Method == http.MethodHead) {
+			writeError(w, r, http.StatusMethodNotAllowed, "method_not_allowed")
+			return
+		}
+		handler(w, r)
+		return
+	}
+
+	id, err := parseID(r.URL.Path)
+	if err != nil {
+		writeAPIError(w, r, err)
+		return
+	}
+
+	if segments := pathSegments(r.URL.Path); len(segments) > 2 {
+		if len(segments) == 3 && segments[2] == "cover" {
+			coverHandler(w, r, id)
+			return
+		}
+		if len(segments) == 3 && segments[2] == "description" {
+			descriptionHandler(w, r, id)
+			return
+		}
+		if len(segments) == 3 && segments[2] == "lock" {
+			bookLockHandler(w, r, id)
+			return
+		}
+		if len(segments) == 3 && (segments[2] == "archive" || segments[2] == "unarchive") {
+			bookArchiveHandler(w, r, id, segments[2])
+			return
+		}
+		writeError(w, r, http.StatusNotFound, "not_found")
+		return
+	}
+
+	params := bookViewParams
+	if r.Method == http.MethodGet || r.Method == http.MethodHead {
+		params = bookReadParams
+	}
+	q, ok := checkQuery(w, r, params...)
+	if !ok {
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet, http.MethodHead:
+		if q.Has("as_of") {
+			t, err := parseAsOf(q.String("as_of"))
+			if err != nil {
+				writeAPIError(w, r, err)
+				return
+			}
+			getBookAsOf(w, r, id, t)
+			return
+		}
+		getBook(w, r, id)
+	case http.MethodPut:
+		updateBook(w, r, id)
+	case http.MethodPatch:
+		patchBook(w, r, id)
+	case http.MethodDelete:
+		deleteBook(w, r, id)
+	default:
+		writeError(w, r, http.StatusMethodNotAllowed, "method_not_allowed")
+	}
+}
+
+// bookCollectionAction returns the handler and method of the named actions
+// under /books/, such as /books/search, which are not book IDs.
+func bookCollectionAction(path string) (http.HandlerFunc, string, bool) {
+	switch path {
+	case "/books/search":
+		return searchBooks, http.MethodGet, true
+	case "/books/stats":
+		return getStats, http.MethodGet, true
+	case "/books/index":
+		return getBookIndex, http.MethodGet, true
+	case "/books/ids/reserve":
+		return reserveIDs, http.MethodPost, true
+	case "/books/export":
+		return exportBooks, http.MethodGet, true
+	case "/books/import":
+		return importBooks, http.MethodPost, true
+	case "/books/diff":
+		return diffBooks, http.MethodPost, true
+	case "/books/transactions":
+		return applyTransaction, http.MethodPost, true
+	case "/books/lookup":
+		return lookupBook, http.MethodPost, true
+	case "/books/changes":
+		return getChanges, http.MethodGet, true
+	case "/books/feed.atom":
+		return getAtomFeed, http.MethodGet, true
+	case "/books/feed.rss":
+		return getRSSFeed, http.MethodGet, true
+	}
+	return nil, "", false
+}
+
+// getBooks retrieves the list of all books, ordered by ID unless ?sort=
+// lists the fields to order by, and paginated. Books can be filtered by
+// language, author, title, and price; ?match= selects whether the author and
+// title filters match exactly, by prefix, or anywhere in the field.
+// ?starts_with= keeps the books of one group of GET /books/index, by the
+// field ?by= names. The options are carried out by the store.
+func getBooks(w http.ResponseWriter, r *http.Request) {
+	opts, err := ParseListOptions(r)
+	if err != nil {
+		writeAPIError(w, r, err)
+		return
+	}
+	serveBookList(w, r, opts)
+}
+
+// serveBookList writes the page of books selected by opts, with page links
+// and a cursor for the next page; GET /books and saved filters share it.
+func serveBookList(w http.ResponseWriter, r *http.Request, opts ListOptions) {
+	// Every matching book is fetched, since the page links need their number.
+	limit, offset := opts.Limit, opts.Offset
+	opts.Limit, opts.Offset = 0, 0
+	bookList, err := listBooks(w, r, opts)
+	if err != nil {
+		writeAPIError(w, r, err)
+		return
+	}
+	reordered := false
+	if preferAcceptLanguage && opts.After == nil && len(opts.Languages) == 0 && len(opts.Sort) == 0 && !opts.Descending {
+		// The preferred languages are moved ahead before pagination. Cursors
+		// cannot follow this order, so none are handed out for it.
+		orderByAcceptLanguage(r, bookList)
+		reordered = true
+	}
+	total := len(bookList)
+	bookList = paginate(bookList, limit, offset)
+	var next string
+	if !reordered && limit > 0 && offset+len(bookList) < total {
+		next = newBookCursor(bookList[len(bookList)-1], opts).encode()
+		w.Header().Set(nextCursorHeader, next)
+	}
+	if opts.After != nil {
+		setCursorLinks(w, r, next)
+	} else {
+		setPageLinks(w, r, total, limit, offset)
+	}
+	setPageLimit(w, limit)
+	warnUnpaginated(r, PagingPolicy{}, limit, len(bookList))
+
+	writeJSONTagged(w, r, renderBooks(r, bookList))
+}
+
+// listBooks returns the books selected by opts, from the store, with
+// ?include_archived=true from cold storage as well, with ?as_of= from the
+// catalog as the journal recorded it then, or, with ?snapshot=,
+// from a listing snapshot. ?snapshot=true starts a snapshot,
+// whose token is returned in the Snapshot-Token header and is passed as
+// ?snapshot= to read the following pages from it.
+func listBooks(w http.ResponseWriter, r *http.Request, opts ListOptions) ([]Book, error) {
+	token := r.URL.Query().Get("snapshot")
+	if opts.AsOf != nil {
+		switch {
+		case token != "":
+			return nil, newAPIError(http.StatusBadRequest, "conflicting_query_parameters", "first", "as_of", "second", "snapshot")
+		case opts.IncludeArchived:
+			return nil, newAPIError(http.StatusBadRequest, "conflicting_query_parameters", "first", "as_of", "second", "include_archived")
+		}
+		return listAsOf(*opts.AsOf, opts)
+	}
+	if opts.IncludeArchived {
+		if token != "" {
+			return nil, newAPIError(http.StatusBadRequest, "conflicting_query_parameters", "first", "include_archived", "second", "snapshot")
+		}
+		return listWithArchived(opts)
+	}
+	switch token {
+	case "":
+		return store.List(opts), nil
+	case "true":
+		var err error
+		if token, err = startListSnapshot(); err != nil {
+			return nil, err
+		}
+		// Page links continue in the snapshot.
+		query := r.URL.Query()
+		query.Set("snapshot", token)
+		r.URL.RawQuery = query.Encode()
+	}
+	list, err := listFromSnapshot(token, opts)
+	if err != nil {
+		return nil, err
+	}
+	w.Header().Set(snapshotTokenHeader, token)
+	return list, nil
+}
+
+// createParams declares the query parameters of POST /books.
+var createParams = slices.Concat(bookViewParams, []queryParam{{name: "enrich", kind: boolParam}})
+
+// createBook creates a new book and adds it to the collection. The store
+// assigns its ID, unless the body gives one of the caller's IDs reserved
+// with POST /books/ids/reserve. With ?enrich=true, a book with an ISBN gets
+// its empty title, author, and description filled in from Open Library
+// first.
+func createBook(w http.ResponseWriter, r *http.Request) {
+	q, ok := checkQuery(w, r, createParams...)
+	if !ok {
+		return
+	}
+
+	var req struct {
+		Book
+		PublisherName string `json:"publisher_name"`
+	}
+	if err := decodeJSON(w, r, &req); err != nil {
+		writeAPIError(w, r, err)
+		return
+	}
+	book := req.Book
+	if err := checkFieldWrites(callerToken(r), Book{}, book); err != nil {
+		writeAPIError(w, r, err)
+		return
+	}
+	reservedID := book.ID
+	book.ID = 0 // assigned by the store
+	now := time.Now().UTC()
+	book.CreatedAt, book.UpdatedAt = &now, &now
+	if q.Bool("enrich") && book.ISBN != "" {
+		isbn, err := normalizeISBN(book.ISBN)
+		if err != nil {
+			writeAPIError(w, r, err)
+			return
+		}
+		book.ISBN = isbn
+		if err := enrichBook(r.Context(), &book); err != nil {
+			writeAPIError(w, r, err)
+			return
+		}
+	}
+	if err := validateBook(&book, ValidateCreate); err != nil {
+		writeAPIError(w, r, err)
+		return
+	}
+	if name := strings.TrimSpace(req.PublisherName); name != "" && book.PublisherID == nil {
+		publisherID := findOrCreatePublisher(name)
+		book.PublisherID = &publisherID
+	}
+
+	mu.RLock()
+	unlockChecks := lockCrossBook(maxBooks > 0 || reservedID != 0 || crossBookChecks(book))
+	unlock := func() {
+		unlockChecks()
+		mu.RUnlock()
+	}
+	if err := checkQuota(1); err != nil {
+		unlock()
+		writeAPIError(w, r, err)
+		return
+	}
+	if err := checkReferences(book); err != nil {
+		unlock()
+		writeAPIError(w, r, err)
+		return
+	}
+	if err := checkSeriesIndex(book); err != nil {
+		unlock()
+		writeAPIError(w, r, err)
+		return
+	}
+	if err := checkEditionISBNs(book); err != nil {
+		unlock()
+		writeAPIError(w, r, err)
+		return
+	}
+	var err error
+	if reservedID != 0 {
+		if err = checkReservedID(r, reservedID); err == nil {
+			book.ID = reservedID
+			err = store.Put(book)
+		}
+	} else {
+		book, err = store.Create(book)
+	}
+	unlock()
+	if err != nil {
+		writeAPIError(w, r, err)
+		return
+	}
+
+	setLocation(w, "/books/"+strconv.Itoa(book.ID))
+	writeJSON(w, http.StatusCreated, renderBook(r, book))
+}
+
+// getBook retrieves a specific book by its ID. Archived books are read
+// from cold storage.
+func getBook(w http.ResponseWriter, r *http.Request, id int) {
+	book, found := store.Get(id)
+	if !found {
+		var err error
+		if book, err = readArchivedBook(id); err != nil {
+			writeAPIError(w, r, err)
+			return
+		}
+	}
+
+	etag := bookETag(book)
+	w.Header().Set("ETag", etag)
+	if inm := r.Header.Get("If-None-Match"); inm != "" && etagListMatches(inm, etag, true) {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, renderBook(r, book))
+}
+
+// updateBook updates an existing book's details. Fields absent from the
+// request body are left unchanged. An If-Match header makes the update
+// conditional on the book's current version.
+func updateBook(w http.ResponseWriter, r *http.Request, id int) {
+	defer lockBook(id)()
+
+	book, err := findBook(id)
+	if err != nil {
+		writeAPIError(w, r, err)
+		return
+	}
+	if !checkIfMatch(w, r, book) {
+		return
+	}
+	if err := checkBookLock(r, id); err != nil {
+		writeAPIError(w, r, err)
+		return
+	}
+
+	prev := book
+	book = cloneBook(prev)
+	if err := decodeJSON(w, r, &book); err != nil {
+		writeAPIError(w, r, err)
+		return
+	}
+	if err := checkFieldWrites(callerToken(r), prev, book); err != nil {
+		writeAPIError(w, r, err)
+		return
+	}
+	book.ID = id
+	touchBook(&book, prev.CreatedAt)
+	if err := validateBook(&book, ValidateUpdate); err != nil {
+		writeAPIError(w, r, err)
+		return
+	}
+	if err := checkReferences(book); err != nil {
+		writeAPIError(w, r, err)
+		return
+	}
+	defer lockCrossBook(crossBookChecks(book))()
+	if err := checkSeriesIndex(book); err != nil {
+		writeAPIError(w, r, err)
+		return
+	}
+	if err := checkEditionISBNs(book); err != nil {
+		writeAPIError(w, r, err)
+		return
+	}
+
+	if err := store.Put(book); err != nil {
+		writeAPIError(w, r, err)
+		return
+	}
+	w.Header().Set("ETag", bookETag(book))
+	writeJSON(w, http.StatusOK, renderBook(r, book))
+}
+
+// deleteBook removes a book from the collection. An If-Match header makes
+// the delete conditional on the book's current version.
+func deleteBook(w http.ResponseWriter, r *http.Request, id int) {
+	defer lockBook(id)()
+
+	book, err := findBook(id)
+	if err != nil {
+		writeAPIError(w, r, err)
+		return
+	}
+	if !checkIfMatch(w, r, book) {
+		return
+	}
+	if err := checkBookLock(r, id); err != nil {
+		writeAPIError(w, r, err)
+		return
+	}
+
+	if err := store.Delete(id); err != nil {
+		writeAPIError(w, r, err)
+		return
+	}
+	removeBookFromShelves(id)
+	removeBookFromFavorites(id)
+	removeCover(id)
+	removeDescription(id)
+	removeBookLock(id)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// touchBook records that book was changed now. createdAt is taken from the
+// stored version, so clients cannot alter the creation time.
+func touchBook(book *Book, createdAt *time.Time) {
+	now := time.Now().UTC()
+	book.CreatedAt = createdAt
+	book.UpdatedAt = &now
+}
+
+// cloneBook returns a copy of book that shares no memory with it. Decoding
+// JSON into a book reuses the pointers, maps, and slices it holds, so a
+// request is decoded into a clone of the stored book; otherwise a rejected
+// write would still change the stored one.
+func cloneBook(book Book) Book {
+	if book.CostPrice != nil {
+		price := *book.CostPrice
+		book.CostPrice = &price
+	}
+	if book.PublisherID != nil {
+		id := *book.PublisherID
+		book.PublisherID = &id
+	}
+	if book.SeriesID != nil {
+		id := *book.SeriesID
+		book.SeriesID = &id
+	}
+	if book.CreatedAt != nil {
+		created := *book.CreatedAt
+		book.CreatedAt = &created
+	}
+	if book.UpdatedAt != nil {
+		updated := *book.UpdatedAt
+		book.UpdatedAt = &updated
+	}
+	book.Editions = slices.Clone(book.Editions)
+	book.Attributes = maps.Clone(book.Attributes)
+	return book
+}
+
+// checkReferences verifies that the resources a book refers to exist.
+// Callers must hold mu.
+func checkReferences(book Book) error {
+	if book.PublisherID != nil && !publisherExists(*book.PublisherID) {
+		return newAPIError(http.StatusUnprocessableEntity, "publisher_missing", "id", *book.PublisherID)
+	}
+	if book.SeriesID != nil {
+		if !seriesExists(*book.SeriesID) {
+			return newAPIError(http.StatusUnprocessableEntity, "series_missing", "id", *book.SeriesID)
+		}
+		if book.SeriesIndex < 1 {
+			return newAPIError(http.StatusUnprocessableEntity, "series_index_invalid")
+		}
+	}
+	return nil
+}
+
+// sortBooksByID orders books by ascending ID so listings are stable.
+func sortBooksByID(list []Book) {
+	sort.Slice(list, func(i, j int) bool { return list[i].ID < list[j].ID })
+}
+
+// bookViewParams declares the query parameters that shape book responses.
+var bookViewParams = []queryParam{
+	{name: "include", kind: enumParam, values: []string{"description"}, repeatable: true},
+	{name: "embed", kind: enumParam, values: []string{"shelves", "publisher", "series"}, repeatable: true},
+	{name: "format_price", kind: boolParam},
+}
+
+// bookListParams declares the query parameters of paginated book listings.
+var bookListParams = slices.Concat(paginationParams, bookViewParams)
+
+// bookResponse is the JSON representation of a book, including any
+// related data requested through the embed query parameter.
+type bookResponse struct {
+	Book
+	DisplayPrice   string `json:"display_price,omitempty"`
+	FavoritesCount int    `json:"favorites_count"`
+	HasCover       bool   `json:"has_cover"`
+	CoverURL       string `json:"cover_url,omitempty"`
+
+	// Books with a full description carry its preview instead of their
+	// description.
+	DescriptionPreview string `json:"description_preview,omitempty"`
+	HasFullDescription bool   `json:"has_full_description"`
+	DescriptionURL     string `json:"description_url,omitempty"`
+
+	Shelves       []string `json:"shelves,omitempty"`
+	PublisherName string   `json:"publisher_name,omitempty"`
+	SeriesName    string   `json:"series_name,omitempty"`
+	Archived      bool     `json:"archived,omitempty"`
+}
+
+// MarshalJSON encodes the response with the renamed fields of Book under
+// their new names; see withAliases.
+func (resp bookResponse) MarshalJSON() ([]byte, error) {
+	type plain bookResponse
+	data, err := json.Marshal(plain(resp))
+	if err != nil {
+		return nil, err
+	}
+	return withAliases(data, reflect.TypeFor[bookResponse]())
+}
+
+// renderBook builds the response representation of a book for r.
+func renderBook(r *http.Request, book Book) bookResponse {
+	book = redactBook(readerToken(r), book)
+	resp := bookResponse{Book: book, FavoritesCount: favoriteCount(book.ID), Archived: isArchived(book.ID)}
+	if wantsDisplayPrice(r) {
+		resp.DisplayPrice = displayPrice(r, book)
+	}
+	if hasCover(book.ID) {
+		resp.HasCover = true
+		resp.CoverURL = coverURL(book.ID)
+	}
+	if info, ok := fullDescription(book.ID); ok {
+		resp.Description = ""
+		resp.DescriptionPreview = info.Preview
+		resp.HasFullDescription = true
+		resp.DescriptionURL = descriptionURL(book.ID)
+	}
+	if wantsEmbed(r, "shelves") {
+		resp.Shelves = shelfNamesForBook(book.ID)
+	}
+	if wantsEmbed(r, "publisher") && book.PublisherID != nil {
+		resp.PublisherName = publisherName(*book.PublisherID)
+	}
+	if wantsEmbed(r, "series") && book.SeriesID != nil {
+		resp.SeriesName = seriesName(*book.SeriesID)
+	}
+	return resp
+}
+
+// renderBooks builds the response representation of each book in list.
+// Descriptions are left out of listings unless ?include=description is given.
+func renderBooks(r *http.Request, list []Book) []bookResponse {
+	withDescription := queryListContains(r, "include", "description")
+	out := make([]bookResponse, 0, len(list))
+	for _, book := range list {
+		if !withDescription {
+			book.Description = ""
+		}
+		out = append(out, renderBook(r, book))
+	}
+	return out
+}
+
+// wantsEmbed reports whether the embed query parameter requests name.
+func wantsEmbed(r *http.Request, name string) bool {
+	return queryListContains(r, "embed", name)
+}
+
+// queryListContains reports whether the comma-separated values of the query
+// parameter key include name.
+func queryListContains(r *http.Request, key, name string) bool {
+	for _, value := range r.URL.Query()[key] {
+		for _, part := range strings.Split(value, ",") {
+			if strings.TrimSpace(part) == name {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// parseID extracts the book ID from the URL path.
+func parseID(path string) (int, error) {
+	segment := ""
+	if parts := strings.Split(path, "/"); len(parts) >= 3 {
+		segment = parts[2]
+	}
+	return parseResourceID(segment, "invalid_book_id")
+}
+
+// parseResourceID parses a resource ID taken from a URL path or query. IDs
+// are positive integers in the range of int, written without a sign or
+// leading zeros, so each has one spelling; anything else fails with a 400
+// error with the given code, saying why. IDs of resources that do not exist
+// are left to the caller to report with 404.
+func parseResourceID(s, code string) (int, error) {
+	var reason string
+	switch {
+	case s == "":
+		reason = "must not be empty"
+	case strings.ContainsFunc(s, func(c rune) bool { return c < '0' || c > '9' }), strings.TrimLeft(s, "0") == "":
+		reason = "must be a positive integer"
+	case s[0] == '0':
+		reason = "must not have leading zeros"
+	}
+	if reason == "" {
+		id, err := strconv.Atoi(s)
+		if err == nil {
+			return id, nil
+		}
+		reason = fmt.Sprintf("must be at most %d", math.MaxInt)
+	}
+	return 0, newAPIError(http.StatusBadRequest, code, "id", s, "reason", reason)
+}
+
+// pathSegments splits a URL path into its non-empty segments.
+func pathSegments(path string) []string {
+	var segments []string
+	for _, part := range strings.Split(path, "/") {
+		if part != "" {
+			segments = append(segments, part)
+		}
+	}
+	return segments
+}
@@ -0,0 +1,231 @@
+package booksapi
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"testing"
+)
+
+// handlerStores are the stores the handlers are run against by the tests
+// and benchmarks of concurrent writes.
+var handlerStores = map[string]func() BookStore{
+	"memory":         func() BookStore { return newMemoryStore(newSequentialIDs()) },
+	"memory-sharded": func() BookStore { return newShardedStore(16, newSequentialIDs()) },
+}
+
+// TestConcurrentWrites runs writes to different books, and competing
+// claims to what only one book may have, at the same time, and checks that
+// the checks spanning the catalog held. Run with -race.
+func TestConcurrentWrites(t *testing.T) {
+	for name, newStore := range handlerStores {
+		t.Run(name, func(t *testing.T) {
+			h := newTestServer(t, WithStore(newStore()))
+			wantCode(t, serve(t, h, http.MethodPost, "/series", map[string]any{"name": "Dune"}), http.StatusCreated)
+			const workers = 8
+			ids := make([]int, workers)
+			for i := range ids {
+				ids[i] = mustCreateBook(t, h, map[string]any{"title": fmt.Sprint("Book ", i)}).ID
+			}
+
+			// Every worker claims the same volume numbers and edition ISBNs,
+			// in the same order; one book may get each.
+			const claims = 20
+			var volumes, isbns [claims]atomic.Int32
+			var wg sync.WaitGroup
+			for i, id := range ids {
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+					path := "/books/" + strconv.Itoa(id)
+					for n := range claims {
+						rec := serve(t, h, http.MethodPut, path, map[string]any{"price": n})
+						if rec.Code != http.StatusOK {
+							t.Errorf("PUT %s: status %d: %s", path, rec.Code, rec.Body.String())
+						}
+						rec = serve(t, h, http.MethodPatch, path, map[string]any{"author": fmt.Sprint("Author ", n)})
+						if rec.Code != http.StatusOK {
+							t.Errorf("PATCH %s: status %d: %s", path, rec.Code, rec.Body.String())
+						}
+						rec = serve(t, h, http.MethodPost, "/books", map[string]any{
+							"title": fmt.Sprintf("Volume %d-%d", i, n), "series_id": 1, "series_index": n + 1,
+						})
+						countClaim(t, rec, &volumes[n])
+						rec = serve(t, h, http.MethodPost, "/books", map[string]any{
+							"title":    fmt.Sprintf("Edition %d-%d", i, n),
+							"editions": []map[string]any{{"format": "ebook", "price": 1, "isbn": testISBN(n)}},
+						})
+						countClaim(t, rec, &isbns[n])
+					}
+				}()
+			}
+			wg.Wait()
+
+			for n := range claims {
+				if got := volumes[n].Load(); got != 1 {
+					t.Errorf("%d books got volume %d of the series, want 1", got, n+1)
+				}
+				if got := isbns[n].Load(); got != 1 {
+					t.Errorf("%d books got ISBN %s, want 1", got, testISBN(n))
+				}
+			}
+			for i, id := range ids {
+				book, _ := store.Get(id)
+				if book.Title != fmt.Sprint("Book ", i) || book.Price != claims-1 || book.Author != fmt.Sprint("Author ", claims-1) {
+					t.Errorf("book %d ended as %+v", id, book)
+				}
+			}
+			if violations := verifyCatalog(); len(violations) > 0 {
+				t.Errorf("verify: %v", violations)
+			}
+		})
+	}
+}
+
+// countClaim counts a create claiming what only one book may have in n if
+// it succeeded. It fails the test unless it succeeded or was refused with
+// 409.
+func countClaim(t *testing.T, rec *httptest.ResponseRecorder, n *atomic.Int32) {
+	t.Helper()
+	switch rec.Code {
+	case http.StatusCreated:
+		n.Add(1)
+	case http.StatusConflict:
+	default:
+		t.Errorf("status %d: %s", rec.Code, rec.Body.String())
+	}
+}
+
+// testISBN returns a valid ISBN-13 of its own for each n.
+func testISBN(n int) string {
+	digits := fmt.Sprintf("978000000%03d", n)
+	sum := 0
+	for i, c := range digits {
+		d := int(c - '0')
+		if i%2 == 1 {
+			d *= 3
+		}
+		sum += d
+	}
+	return digits + strconv.Itoa((10-sum%10)%10)
+}
+
+// TestConcurrentCreatesQuota creates books at the same time past the quota
+// and checks it was not exceeded.
+func TestConcurrentCreatesQuota(t *testing.T) {
+	for name, newStore := range handlerStores {
+		t.Run(name, func(t *testing.T) {
+			h := newTestServer(t, WithStore(newStore()))
+			setForTest(t, &maxBooks, 10)
+
+			var wg sync.WaitGroup
+			for i := range 30 {
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+					rec := serve(t, h, http.MethodPost, "/books", map[string]any{"title": fmt.Sprint("Book ", i)})
+					if rec.Code != http.StatusCreated && rec.Code != http.StatusInsufficientStorage {
+						t.Errorf("status %d: %s", rec.Code, rec.Body.String())
+					}
+				}()
+			}
+			wg.Wait()
+			if n := store.Count(); n != 10 {
+				t.Errorf("%d books stored, want the quota of 10", n)
+			}
+		})
+	}
+}
+
+// BenchmarkParallelUpdates updates different books from parallel
+// goroutines, to compare how the stores let writes run at the same time:
+//
+//	go test ./booksapi -run '^$' -bench ParallelUpdates -cpu 1,4,8
+func BenchmarkParallelUpdates(b *testing.B) {
+	for _, name := range []string{"memory", "memory-sharded"} {
+		b.Run(name, func(b *testing.B) {
+			h := newTestServer(b, WithStore(handlerStores[name]()))
+			const books = 256
+			for i := range books {
+				mustCreateBook(b, h, map[string]any{"title": fmt.Sprint("Book ", i)})
+			}
+			body := []byte(`{"price": 12.5}`)
+			var next atomic.Int64
+			b.ResetTimer()
+			b.RunParallel(func(pb *testing.PB) {
+				for pb.Next() {
+					path := "/books/" + strconv.Itoa(int(next.Add(1)%books)+1)
+					if rec := serve(b, h, http.MethodPut, path, body); rec.Code != http.StatusOK {
+						b.Fatalf("PUT %s: status %d", path, rec.Code)
+					}
+				}
+			})
+		})
+	}
+}
+
+// TestResourceIDs checks that IDs in paths that are not positive integers
+// in their one spelling get 400 saying why, on every route taking an ID,
+// and that valid IDs of missing resources get 404.
+func TestResourceIDs(t *testing.T) {
+	h := newTestServer(t)
+	mustCreateBook(t, h, map[string]any{"title": "Dune"})
+	wantCode(t, serve(t, h, http.MethodPost, "/shelves", map[string]any{"name": "Sci-fi"}), http.StatusCreated)
+
+	ids := []struct {
+		id     string
+		status int
+		reason string
+	}{
+		{"-1", http.StatusBadRequest, "must be a positive integer"},
+		{"+7", http.StatusBadRequest, "must be a positive integer"},
+		{"0", http.StatusBadRequest, "must be a positive integer"},
+		{"000", http.StatusBadRequest, "must be a positive integer"},
+		{"007", http.StatusBadRequest, "must not have leading zeros"},
+		{"9223372036854775808", http.StatusBadRequest, "must be at most 9223372036854775807"},
+		{"999999999999999999999", http.StatusBadRequest, "must be at most 9223372036854775807"},
+		{"abc", http.StatusBadRequest, "must be a positive integer"},
+		{"7.0", http.StatusBadRequest, "must be a positive integer"},
+		{"%20", http.StatusBadRequest, "must be a positive integer"},
+		{"7", http.StatusNotFound, ""},
+		{"9223372036854775807", http.StatusNotFound, ""},
+	}
+	routes := []struct {
+		method, pattern, code string
+	}{
+		{http.MethodGet, "/books/%s", "invalid_book_id"},
+		{http.MethodGet, "/books/%s/cover", "invalid_book_id"},
+		{http.MethodGet, "/shelves/%s", "invalid_shelf_id"},
+		{http.MethodPut, "/shelves/1/books/%s", "invalid_book_id"},
+		{http.MethodGet, "/publishers/%s", "invalid_publisher_id"},
+		{http.MethodGet, "/series/%s", "invalid_series_id"},
+		{http.MethodGet, "/filters/%s", "invalid_filter_id"},
+	}
+	for _, route := range routes {
+		for _, tt := range ids {
+			path := fmt.Sprintf(route.pattern, tt.id)
+			rec := serve(t, h, route.method, path, nil)
+			if rec.Code != tt.status {
+				t.Errorf("%s %s: status %d, want %d: %s", route.method, path, rec.Code, tt.status, rec.Body)
+				continue
+			}
+			if tt.status != http.StatusBadRequest {
+				continue
+			}
+			if got := decode[errorBody](t, rec).Error; got.Code != route.code || got.Params["reason"] != tt.reason {
+				t.Errorf("%s %s: error %s %v, want %s: %s", route.method, path, got.Code, got.Params, route.code, tt.reason)
+			}
+		}
+	}
+
+	// An empty ID is not an ID at all.
+	rec := serve(t, h, http.MethodGet, "/books/", nil)
+	wantCode(t, rec, http.StatusBadRequest)
+	if got := decode[errorBody](t, rec).Error; got.Code != "invalid_book_id" || got.Params["reason"] != "must not be empty" {
+		t.Errorf("GET /books/: error %s %v, want invalid_book_id: must not be empty", got.Code, got.Params)
+	}
+	wantCode(t, serve(t, h, http.MethodGet, "/books/1", nil), http.StatusOK)
+}
@@ -0,0 +1,261 @@
+package booksapi
+
+import (
+	"context"
+	"errors"
+	"expvar"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Circuit breaker settings, configured by flags in Main. A breakerFailures
+// of zero disables the breaker.
+var (
+	breakerFailures = 5
+	breakerCooldown = 30 * time.Second
+)
+
+// Circuit breaker states.
+const (
+	breakerClosed   = "closed"    // writes reach the store
+	breakerOpen     = "open"      // writes fail fast until the cooldown is over
+	breakerHalfOpen = "half_open" // one trial write is let through
+)
+
+// Breaker state transitions, published under /debug/vars as store_breaker.
+var breakerTransitions = expvar.NewMap("store_breaker")
+
+// storeBreaker is the breaker of the store, or nil when it is disabled.
+var storeBreaker *breakerStore
+
+func init() {
+	expvar.Publish("store_breaker_state", expvar.Func(func() any {
+		if storeBreaker == nil {
+			return "disabled"
+		}
+		return storeBreaker.State()
+	}))
+}
+
+// breakerStore stops sending writes to the store it wraps after
+// breakerFailures consecutive writes failed with ErrUnavailable. Once
+// breakerCooldown has passed, one trial write is let through: if it
+// succeeds the breaker closes, otherwise it opens again. Reads are always
+// passed through, so the catalog stays readable while writes are refused.
+type breakerStore struct {
+	BookStore
+
+	mu       sync.Mutex
+	state    string
+	failures int       // consecutive failed writes while closed
+	openedAt time.Time // when the breaker last opened
+	trial    bool      // a half-open trial write is in progress
+}
+
+// newBreakerStore wraps inner with a closed circuit breaker.
+func newBreakerStore(inner BookStore) *breakerStore {
+	return &breakerStore{BookStore: inner, state: breakerClosed}
+}
+
+// Unwrap returns the wrapped store.
+func (b *breakerStore) Unwrap() BookStore { return b.BookStore }
+
+// State returns the breaker's state, moving it from open to half-open once
+// the cooldown is over.
+func (b *breakerStore) State() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.cooledLocked()
+	return b.state
+}
+
+// RetryAfter returns the whole seconds until the breaker lets a write
+// through again, at least one.
+func (b *breakerStore) RetryAfter() int {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	left := time.Until(b.openedAt.Add(breakerCooldown))
+	return max(1, int(math.Ceil(left.Seconds())))
+}
+
+// cooledLocked half-opens an open breaker whose cooldown is over. Callers
+// must hold b.mu.
+func (b *breakerStore) cooledLocked() {
+	if b.state == breakerOpen && time.Since(b.openedAt) >= breakerCooldown {
+		b.setStateLocked(breakerHalfOpen)
+	}
+}
+
+// setStateLocked moves the breaker to state, counting the transition.
+// Callers must hold b.mu.
+func (b *breakerStore) setStateLocked(state string) {
+	if b.state == state {
+		return
+	}
+	b.state = state
+	breakerTransitions.Add(state, 1)
+	if state == breakerOpen {
+		b.openedAt = time.Now()
+	}
+}
+
+// allow reports whether a write may go to the store, failing with
+// ErrUnavailable while the breaker is open or a trial write is running.
+func (b *breakerStore) allow() error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.cooledLocked()
+	switch {
+	case b.state == breakerOpen, b.state == breakerHalfOpen && b.trial:
+		return fmt.Errorf("%w: circuit breaker %s", ErrUnavailable, b.state)
+	case b.state == breakerHalfOpen:
+		b.trial = true
+	}
+	return nil
+}
+
+// record updates the breaker with the outcome of a write it allowed. Only
+// ErrUnavailable errors count as failures; others are the caller's fault.
+func (b *breakerStore) record(err error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	failed := errors.Is(err, ErrUnavailable)
+	if b.state == breakerHalfOpen {
+		b.trial = false
+		if failed {
+			b.setStateLocked(breakerOpen)
+		} else {
+			b.failures = 0
+			b.setStateLocked(breakerClosed)
+		}
+		return
+	}
+	if !failed {
+		b.failures = 0
+		return
+	}
+	b.failures++
+	if b.failures >= breakerFailures {
+		b.failures = 0
+		b.setStateLocked(breakerOpen)
+	}
+}
+
+func (b *breakerStore) Create(book Book) (Book, error) {
+	if err := b.allow(); err != nil {
+		return book, err
+	}
+	book, err := b.BookStore.Create(book)
+	b.record(err)
+	return book, err
+}
+
+func (b *breakerStore) Put(book Book) error {
+	if err := b.allow(); err != nil {
+		return err
+	}
+	err := b.BookStore.Put(book)
+	b.record(err)
+	return err
+}
+
+func (b *breakerStore) Delete(id int) error {
+	if err := b.allow(); err != nil {
+		return err
+	}
+	err := b.BookStore.Delete(id)
+	b.record(err)
+	return err
+}
+
+func (b *breakerStore) Reserve(id int) error {
+	if err := b.allow(); err != nil {
+		return err
+	}
+	err := b.BookStore.Reserve(id)
+	b.record(err)
+	return err
+}
+
+// Transact lets a transaction through as a single write. The writes made
+// within it go straight to the wrapped store's transaction.
+func (b *breakerStore) Transact(ctx context.Context, fn func(tx BookStore) error) error {
+	if err := b.allow(); err != nil {
+		return err
+	}
+	err := b.BookStore.Transact(ctx, fn)
+	b.record(err)
+	return err
+}
+
+// Close closes the wrapped store if it needs closing.
+func (b *breakerStore) Close() error {
+	if closer, ok := b.BookStore.(io.Closer); ok {
+		return closer.Close()
+	}
+	return nil
+}
+
+// storeRetryAfter returns the Retry-After value, in seconds, of a request
+// failing with ErrUnavailable.
+func storeRetryAfter() string {
+	if storeBreaker == nil {
+		return busyRetryAfter
+	}
+	return strconv.Itoa(storeBreaker.RetryAfter())
+}
+
+// warnWhenDegraded adds a warning to reads made while the breaker is not
+// closed, as the catalog they see may not accept changes.
+func warnWhenDegraded(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if storeBreaker != nil && (r.Method == http.MethodGet || r.Method == http.MethodHead) && storeBreaker.State() != breakerClosed {
+			addWarning(r, "store_degraded", "book store unavailable; the catalog is read-only")
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// readiness is the response body of GET /readyz.
+type readiness struct {
+	Status  string         `json:"status"`
+	Breaker string         `json:"breaker,omitempty"`
+	Replica *replicaStatus `json:"replica,omitempty"`
+}
+
+// readyzHandler reports whether the server can take writes: 503 while the
+// store's circuit breaker is open (GET /readyz). A replica, which takes no
+// writes, is ready to serve reads once it has copied its primary's
+// catalog, and gets 503 before.
+func readyzHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		writeError(w, r, http.StatusMethodNotAllowed, "method_not_allowed")
+		return
+	}
+
+	report := readiness{Status: "ready"}
+	if replica != nil {
+		status := replica.Status()
+		report.Replica = &status
+		if !replica.synced() {
+			report.Status = "syncing"
+			writeJSON(w, http.StatusServiceUnavailable, report)
+			return
+		}
+	}
+	if storeBreaker != nil {
+		report.Breaker = storeBreaker.State()
+		if report.Breaker == breakerOpen {
+			report.Status = "unavailable"
+			w.Header().Set("Retry-After", storeRetryAfter())
+			writeJSON(w, http.StatusServiceUnavailable, report)
+			return
+		}
+	}
+	writeJSON(w, http.StatusOK, report)
+}
@@ -0,0 +1,184 @@
+package booksapi
+
+import (
+	"context"
+	"expvar"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// downStore fails every write with ErrUnavailable while down is set, as a
+// store whose backend cannot be reached does, and counts the writes that
+// reach it.
+type downStore struct {
+	BookStore
+	down   atomic.Bool
+	writes atomic.Int64
+}
+
+func (s *downStore) write() error {
+	s.writes.Add(1)
+	if s.down.Load() {
+		return fmt.Errorf("dial backend: %w", ErrUnavailable)
+	}
+	return nil
+}
+
+func (s *downStore) Create(book Book) (Book, error) {
+	if err := s.write(); err != nil {
+		return book, err
+	}
+	return s.BookStore.Create(book)
+}
+
+func (s *downStore) Put(book Book) error {
+	if err := s.write(); err != nil {
+		return err
+	}
+	return s.BookStore.Put(book)
+}
+
+func (s *downStore) Delete(id int) error {
+	if err := s.write(); err != nil {
+		return err
+	}
+	return s.BookStore.Delete(id)
+}
+
+func (s *downStore) Transact(ctx context.Context, fn func(tx BookStore) error) error {
+	if err := s.write(); err != nil {
+		return err
+	}
+	return s.BookStore.Transact(ctx, fn)
+}
+
+// breakerTransitionCount returns how many times the breaker has moved to
+// state.
+func breakerTransitionCount(state string) int64 {
+	if v, ok := breakerTransitions.Get(state).(*expvar.Int); ok {
+		return v.Value()
+	}
+	return 0
+}
+
+// TestBreaker walks the breaker from closed to open, half-open, open again,
+// and back to closed, checking what writes, reads, and readiness probes
+// get in each state.
+func TestBreaker(t *testing.T) {
+	setForTest(t, &breakerFailures, 3)
+	setForTest(t, &breakerCooldown, 200*time.Millisecond)
+	backend := &downStore{BookStore: newMemoryStore(newSequentialIDs())}
+	breaker := newBreakerStore(backend)
+	h := newTestServer(t, WithStore(breaker))
+	setForTest(t, &storeBreaker, breaker)
+	mustCreateBook(t, h, map[string]any{"title": "Dune"})
+	opened, halfOpened, closed := breakerTransitionCount(breakerOpen), breakerTransitionCount(breakerHalfOpen), breakerTransitionCount(breakerClosed)
+
+	ready := func(status int, breakerState string) {
+		t.Helper()
+		rec := serve(t, h, http.MethodGet, "/readyz", nil)
+		wantCode(t, rec, status)
+		if got := decode[readiness](t, rec).Breaker; got != breakerState {
+			t.Errorf("readyz breaker %q, want %q", got, breakerState)
+		}
+	}
+	unavailable := func(rec *httptest.ResponseRecorder) {
+		t.Helper()
+		wantCode(t, rec, http.StatusServiceUnavailable)
+		if got := rec.Header().Get("Retry-After"); got == "" {
+			t.Error("503 without Retry-After")
+		}
+	}
+	readable := func(degraded bool) {
+		t.Helper()
+		invalidateResponseCache()
+		rec := serve(t, h, http.MethodGet, "/books/1", nil)
+		wantCode(t, rec, http.StatusOK)
+		if got := strings.Contains(rec.Header().Get("Warning"), "read-only"); got != degraded {
+			t.Errorf("Warning %q, want a degradation warning: %v", rec.Header().Get("Warning"), degraded)
+		}
+	}
+
+	// Closed: failing writes get 503 and count towards opening the breaker.
+	backend.down.Store(true)
+	for i := range breakerFailures {
+		rec := serve(t, h, http.MethodPost, "/books", map[string]any{"title": fmt.Sprint("Emma ", i)})
+		unavailable(rec)
+		if got := errorCode(t, rec); got != "store_unavailable" {
+			t.Errorf("error code %q, want store_unavailable", got)
+		}
+	}
+	if got := breaker.State(); got != breakerOpen {
+		t.Fatalf("breaker %s after %d failures, want open", got, breakerFailures)
+	}
+
+	// Open: writes fail fast without reaching the store; reads still work.
+	writes := backend.writes.Load()
+	for _, req := range []struct{ method, path string }{{http.MethodPost, "/books"}, {http.MethodPut, "/books/1"}, {http.MethodDelete, "/books/1"}} {
+		rec := serve(t, h, req.method, req.path, map[string]any{"title": "Emma"})
+		unavailable(rec)
+		if got := rec.Header().Get("Retry-After"); got != "1" {
+			t.Errorf("%s %s: Retry-After %q, want 1", req.method, req.path, got)
+		}
+	}
+	if n := backend.writes.Load() - writes; n != 0 {
+		t.Errorf("%d writes reached the store while the breaker was open", n)
+	}
+	readable(true)
+	ready(http.StatusServiceUnavailable, breakerOpen)
+
+	// Half-open: one trial write is let through; its failure opens the
+	// breaker again.
+	time.Sleep(breakerCooldown)
+	ready(http.StatusOK, breakerHalfOpen)
+	readable(true)
+	rec := serve(t, h, http.MethodPost, "/books", map[string]any{"title": "Emma"})
+	unavailable(rec)
+	if n := backend.writes.Load() - writes; n != 1 {
+		t.Errorf("%d trial writes reached the store, want 1", n)
+	}
+	ready(http.StatusServiceUnavailable, breakerOpen)
+
+	// A trial write succeeding closes the breaker.
+	time.Sleep(breakerCooldown)
+	backend.down.Store(false)
+	mustCreateBook(t, h, map[string]any{"title": "Emma"})
+	ready(http.StatusOK, breakerClosed)
+	readable(false)
+	wantCode(t, serve(t, h, http.MethodPut, "/books/1", map[string]any{"title": "Dune", "price": 9.99}), http.StatusOK)
+
+	for _, tt := range []struct {
+		state        string
+		before, want int64
+	}{
+		{breakerOpen, opened, 2},
+		{breakerHalfOpen, halfOpened, 2},
+		{breakerClosed, closed, 1},
+	} {
+		if got := breakerTransitionCount(tt.state) - tt.before; got != tt.want {
+			t.Errorf("%d transitions to %s, want %d", got, tt.state, tt.want)
+		}
+	}
+}
+
+// TestBreakerIgnoresCallerErrors checks that writes failing for reasons
+// other than the store being unavailable do not open the breaker.
+func TestBreakerIgnoresCallerErrors(t *testing.T) {
+	setForTest(t, &breakerFailures, 2)
+	breaker := newBreakerStore(newMemoryStore(newSequentialIDs()))
+	h := newTestServer(t, WithStore(breaker))
+	setForTest(t, &storeBreaker, breaker)
+	for range 3 {
+		wantCode(t, serve(t, h, http.MethodDelete, "/books/99", nil), http.StatusNotFound)
+	}
+	if got := breaker.State(); got != breakerClosed {
+		t.Errorf("breaker %s, want closed", got)
+	}
+	rec := serve(t, h, http.MethodGet, "/readyz", nil)
+	wantCode(t, rec, http.StatusOK)
+}
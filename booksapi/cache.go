@@ -0,0 +1,218 @@
+package booksapi
+
+import (
+	"bytes"
+	"expvar"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Response cache settings, configured by flags in Main. A maximum of zero
+// entries disables the cache.
+var (
+	cacheMaxEntries = 1024
+	cacheTTL        = 30 * time.Second
+)
+
+// Cache counters published under /debug/vars.
+var (
+	cacheHits   = expvar.NewInt("cache_hits")
+	cacheMisses = expvar.NewInt("cache_misses")
+)
+
+// cacheEntry is an encoded response stored in the cache.
+type cacheEntry struct {
+	generation uint64
+	expires    time.Time
+	header     http.Header
+	body       []byte
+}
+
+// responseCache holds encoded responses of the book read endpoints. Every
+// mutation advances the generation, which invalidates all entries at once.
+type responseCache struct {
+	generation atomic.Uint64
+
+	mu      sync.Mutex
+	entries map[string]cacheEntry
+}
+
+// bookCache is the cache used by cacheResponses.
+var bookCache = &responseCache{entries: make(map[string]cacheEntry)}
+
+// invalidateResponseCache drops every cached response. Request handlers do
+// not need to call it; cacheResponses invalidates on every mutating request.
+func invalidateResponseCache() {
+	bookCache.generation.Add(1)
+}
+
+// get returns the cached entry for key if it is current.
+func (c *responseCache) get(key string) (cacheEntry, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	entry, ok := c.entries[key]
+	if !ok {
+		return cacheEntry{}, false
+	}
+	if entry.generation != c.generation.Load() || time.Now().After(entry.expires) {
+		delete(c.entries, key)
+		return cacheEntry{}, false
+	}
+	return entry, true
+}
+
+// put stores an entry, evicting another one if the cache is full.
+func (c *responseCache) put(key string, entry cacheEntry) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if len(c.entries) >= cacheMaxEntries {
+		for k := range c.entries {
+			delete(c.entries, k)
+			break
+		}
+	}
+	c.entries[key] = entry
+}
+
+// cacheResponses serves GET /books and GET /books/{id} from the response
+// cache when possible, and invalidates the cache whenever any other request
+// may have changed data. The invalidation happens before the mutating
+// response is written, so a client never reads stale data after a write.
+// In TTL mode, responses are cached no longer than until the next book
+// expires, as a listing's pages and counts depend on every book.
+func cacheResponses(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if cacheMaxEntries <= 0 {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		if r.Method != http.MethodGet && r.Method != http.MethodHead {
+			next.ServeHTTP(&invalidatingWriter{ResponseWriter: w}, r)
+			invalidateResponseCache()
+			return
+		}
+		// Snapshot reads are not cached, as each either starts a snapshot or
+		// must fail once its snapshot has expired.
+		if r.Method != http.MethodGet || !isCacheablePath(r.URL.Path) || r.URL.Query().Has("snapshot") {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		// Page links hold the host, so responses are cached per host, and
+		// per set of book fields hidden from the caller.
+		key := r.URL.Path + "?" + r.URL.Query().Encode() + "|" + r.Header.Get("Accept-Language") + "|" + r.Host + "|" + hiddenFieldNames(r)
+		if trustProxy {
+			key += "|" + r.Header.Get("X-Forwarded-Proto") + "|" + r.Header.Get("X-Forwarded-Host")
+		}
+		if entry, ok := bookCache.get(key); ok {
+			cacheHits.Add(1)
+			for name, values := range entry.header {
+				w.Header()[name] = values
+			}
+			w.Header().Set("X-Cache", "HIT")
+			if inm := r.Header.Get("If-None-Match"); inm != "" && etagListMatches(inm, entry.header.Get("ETag"), true) {
+				w.WriteHeader(http.StatusNotModified)
+				return
+			}
+			w.Write(entry.body)
+			return
+		}
+
+		cacheMisses.Add(1)
+		generation := bookCache.generation.Load()
+		w.Header().Set("X-Cache", "MISS")
+		rec := &cacheRecorder{ResponseWriter: w, status: http.StatusOK}
+		next.ServeHTTP(rec, r)
+
+		ttl := cacheTTL
+		if expiring, ok := findStore[*ttlStore](store); ok {
+			if d, ok := expiring.untilNextExpiry(); ok {
+				ttl = min(ttl, d)
+			}
+		}
+		if rec.status == http.StatusOK && rec.header != nil && ttl > 0 {
+			header := rec.header
+			header.Del("X-Cache")
+			header.Del("X-Request-ID")
+			header.Del("Cache-Control") // set per request by setCacheHeaders
+			header.Del("Vary")
+			bookCache.put(key, cacheEntry{
+				generation: generation,
+				expires:    time.Now().Add(ttl),
+				header:     header,
+				body:       rec.body.Bytes(),
+			})
+		}
+	})
+}
+
+// isCacheablePath reports whether path is /books or /books/{id}.
+func isCacheablePath(path string) bool {
+	if path == "/books" {
+		return true
+	}
+	segments := pathSegments(path)
+	if len(segments) != 2 || segments[0] != "books" {
+		return false
+	}
+	_, err := parseResourceID(segments[1], "invalid_book_id")
+	return err == nil
+}
+
+// cacheRecorder writes a response through while keeping a copy of it. The
+// headers are copied as the handler set them, before writers further down,
+// such as compressResponses, adjust them for the encoding of this response.
+type cacheRecorder struct {
+	http.ResponseWriter
+	status int
+	header http.Header
+	body   bytes.Buffer
+}
+
+func (rec *cacheRecorder) WriteHeader(status int) {
+	rec.recordHeader()
+	rec.status = status
+	rec.ResponseWriter.WriteHeader(status)
+}
+
+func (rec *cacheRecorder) Write(p []byte) (int, error) {
+	rec.recordHeader()
+	rec.body.Write(p)
+	return rec.ResponseWriter.Write(p)
+}
+
+// recordHeader copies the headers the first time the response is written.
+func (rec *cacheRecorder) recordHeader() {
+	if rec.header == nil {
+		rec.header = rec.Header().Clone()
+	}
+}
+
+// invalidatingWriter invalidates the response cache as soon as a mutating
+// handler starts writing its response.
+type invalidatingWriter struct {
+	http.ResponseWriter
+	written bool
+}
+
+func (w *invalidatingWriter) WriteHeader(status int) {
+	w.invalidate()
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *invalidatingWriter) Write(p []byte) (int, error) {
+	w.invalidate()
+	return w.ResponseWriter.Write(p)
+}
+
+func (w *invalidatingWriter) invalidate() {
+	if !w.written {
+		w.written = true
+		invalidateResponseCache()
+	}
+}
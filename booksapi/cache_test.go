@@ -0,0 +1,138 @@
+package booksapi
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// readCountingStore counts the reads made through it.
+type readCountingStore struct {
+	BookStore
+	reads atomic.Int64
+}
+
+func (s *readCountingStore) Unwrap() BookStore { return s.BookStore }
+
+func (s *readCountingStore) Get(id int) (Book, bool) {
+	s.reads.Add(1)
+	return s.BookStore.Get(id)
+}
+
+func (s *readCountingStore) List(opts ListOptions) []Book {
+	s.reads.Add(1)
+	return s.BookStore.List(opts)
+}
+
+func (s *readCountingStore) Count() int {
+	s.reads.Add(1)
+	return s.BookStore.Count()
+}
+
+// TestResponseCacheHit checks that a repeated read is served from the cache
+// without reading the store.
+func TestResponseCacheHit(t *testing.T) {
+	counting := &readCountingStore{BookStore: newMemoryStore(newSequentialIDs())}
+	h := newTestServer(t, WithStore(counting))
+	mustCreateBook(t, h, map[string]any{"title": "Dune"})
+
+	for _, path := range []string{"/books", "/books/1", "/books?sort=title&limit=5"} {
+		miss := serve(t, h, http.MethodGet, path, nil)
+		if got := miss.Header().Get("X-Cache"); got != "MISS" {
+			t.Errorf("first GET %s: X-Cache %q, want MISS", path, got)
+		}
+		reads := counting.reads.Load()
+		hits := cacheHits.Value()
+		hit := serve(t, h, http.MethodGet, path, nil)
+		if got := hit.Header().Get("X-Cache"); got != "HIT" {
+			t.Errorf("second GET %s: X-Cache %q, want HIT", path, got)
+		}
+		if hit.Body.String() != miss.Body.String() {
+			t.Errorf("GET %s: cached body %s, want %s", path, hit.Body, miss.Body)
+		}
+		if n := counting.reads.Load() - reads; n != 0 {
+			t.Errorf("GET %s: cache hit read the store %d times", path, n)
+		}
+		if n := cacheHits.Value() - hits; n != 1 {
+			t.Errorf("GET %s: cache_hits went up by %d, want 1", path, n)
+		}
+	}
+}
+
+// TestResponseCacheWriteMisses checks that a read after a write misses the
+// cache and sees the write.
+func TestResponseCacheWriteMisses(t *testing.T) {
+	h := newTestServer(t)
+	mustCreateBook(t, h, map[string]any{"title": "Dune"})
+
+	writes := []struct {
+		method string
+		path   string
+		body   any
+		title  string // of book 1 after the write, or "" once it is gone
+	}{
+		{http.MethodPut, "/books/1", map[string]any{"title": "Dune Messiah"}, "Dune Messiah"},
+		{http.MethodPatch, "/books/1", map[string]any{"title": "Children of Dune"}, "Children of Dune"},
+		{http.MethodPost, "/books", map[string]any{"title": "Emma"}, "Children of Dune"},
+		{http.MethodDelete, "/books/1", nil, ""},
+	}
+	for _, write := range writes {
+		for _, path := range []string{"/books", "/books/1"} {
+			serve(t, h, http.MethodGet, path, nil)
+			if got := serve(t, h, http.MethodGet, path, nil).Header().Get("X-Cache"); got != "HIT" {
+				t.Fatalf("GET %s before %s %s: X-Cache %q, want HIT", path, write.method, write.path, got)
+			}
+		}
+		if rec := serve(t, h, write.method, write.path, write.body); rec.Code >= 300 {
+			t.Fatalf("%s %s: status %d: %s", write.method, write.path, rec.Code, rec.Body.String())
+		}
+
+		list := serve(t, h, http.MethodGet, "/books", nil)
+		if got := list.Header().Get("X-Cache"); got != "MISS" {
+			t.Errorf("GET /books after %s %s: X-Cache %q, want MISS", write.method, write.path, got)
+		}
+		item := serve(t, h, http.MethodGet, "/books/1", nil)
+		if got := item.Header().Get("X-Cache"); got != "MISS" {
+			t.Errorf("GET /books/1 after %s %s: X-Cache %q, want MISS", write.method, write.path, got)
+		}
+		if write.title == "" {
+			wantCode(t, item, http.StatusNotFound)
+		} else if got := decode[Book](t, item).Title; got != write.title {
+			t.Errorf("GET /books/1 after %s %s: title %q, want %q", write.method, write.path, got, write.title)
+		}
+	}
+}
+
+// TestResponseCacheTTL checks that entries expire after -cache-ttl.
+func TestResponseCacheTTL(t *testing.T) {
+	h := newTestServer(t)
+	setForTest(t, &cacheTTL, time.Millisecond)
+	mustCreateBook(t, h, map[string]any{"title": "Dune"})
+
+	serve(t, h, http.MethodGet, "/books/1", nil)
+	time.Sleep(5 * time.Millisecond)
+	if got := serve(t, h, http.MethodGet, "/books/1", nil).Header().Get("X-Cache"); got != "MISS" {
+		t.Errorf("GET after the TTL: X-Cache %q, want MISS", got)
+	}
+}
+
+// BenchmarkResponseCacheHit measures a cache hit of GET /books/1 through
+// the whole handler; -benchmem shows the allocations of the hit path.
+func BenchmarkResponseCacheHit(b *testing.B) {
+	h := newTestServer(b)
+	mustCreateBook(b, h, map[string]any{"title": "Dune", "author": "Frank Herbert", "price": 9.99})
+	req := httptest.NewRequest(http.MethodGet, "/books/1", nil)
+	serve(b, h, http.MethodGet, "/books/1", nil)
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for range b.N {
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		if rec.Header().Get("X-Cache") != "HIT" {
+			b.Fatal("cache miss")
+		}
+	}
+}
@@ -0,0 +1,92 @@
+package booksapi
+
+import (
+	"net/http"
+	"strings"
+)
+
+// Cache-Control values of the public read endpoints, per route group,
+// configured by flags in Main. An empty value sends no Cache-Control.
+var (
+	cacheControlList    = "public, max-age=30, stale-while-revalidate=300"
+	cacheControlBook    = "public, max-age=300, stale-while-revalidate=3600"
+	cacheControlDefault = "no-cache"
+)
+
+// noStore is the Cache-Control of responses shared caches must not keep.
+const noStore = "no-store"
+
+// cacheControlFor returns the Cache-Control of the response to r. Writes,
+// requests with credentials, listing snapshots, and the admin, debug,
+// import, token, and probe endpoints are never stored. Single books and
+// their covers, the book collection and its read actions, and the other
+// GET endpoints each use their group's value.
+func cacheControlFor(r *http.Request) string {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		return noStore
+	}
+	if r.Header.Get("Authorization") != "" || r.URL.Query().Has("snapshot") {
+		return noStore
+	}
+	segments := pathSegments(r.URL.Path)
+	if len(segments) == 0 {
+		return cacheControlDefault
+	}
+	switch segments[0] {
+	case "admin", "debug", "imports", "me", "healthz", "readyz":
+		return noStore
+	case "books":
+		if len(segments) >= 2 && len(segments) <= 3 {
+			if _, err := parseResourceID(segments[1], "invalid_book_id"); err == nil {
+				if len(segments) == 2 || segments[2] == "cover" {
+					return cacheControlBook
+				}
+				return noStore
+			}
+		}
+		return cacheControlList
+	}
+	return cacheControlDefault
+}
+
+// setCacheHeaders sets the Cache-Control chosen by cacheControlFor, and
+// the Vary headers needed for shared caches to keep the representations
+// of a URL apart. Responses are negotiated on Accept, Accept-Encoding, and
+// Accept-Language, and on Authorization once tokens decide which fields
+// are shown. Server errors are never stored. The headers are set before
+// the handler runs, so 304 responses carry them too.
+func setCacheHeaders(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if value := cacheControlFor(r); value != "" {
+			w.Header().Set("Cache-Control", value)
+		}
+		vary := []string{"Accept", "Accept-Encoding", "Accept-Language"}
+		if len(currentConfig().tokens) > 0 {
+			vary = append(vary, "Authorization")
+		}
+		w.Header().Set("Vary", strings.Join(vary, ", "))
+		next.ServeHTTP(&cacheHeaderWriter{ResponseWriter: w}, r)
+	})
+}
+
+// cacheHeaderWriter marks server error responses as not to be stored.
+type cacheHeaderWriter struct {
+	http.ResponseWriter
+	wroteHeader bool
+}
+
+func (w *cacheHeaderWriter) WriteHeader(status int) {
+	if !w.wroteHeader && status >= http.StatusInternalServerError {
+		w.Header().Set("Cache-Control", noStore)
+	}
+	w.wroteHeader = true
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *cacheHeaderWriter) Write(p []byte) (int, error) {
+	w.wroteHeader = true
+	return w.ResponseWriter.Write(p)
+}
+
+// Unwrap returns the wrapped writer, for http.ResponseController.
+func (w *cacheHeaderWriter) Unwrap() http.ResponseWriter { return w.ResponseWriter }
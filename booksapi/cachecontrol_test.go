@@ -0,0 +1,99 @@
+package booksapi
+
+import (
+	"net/http"
+	"testing"
+)
+
+// TestCacheHeaders pins the Cache-Control and Vary headers of public reads,
+// their 304 responses, writes, and requests with credentials.
+func TestCacheHeaders(t *testing.T) {
+	h := newTestServer(t)
+	mustCreateBook(t, h, map[string]any{"title": "Dune", "price": 9.99})
+	etag := serve(t, h, http.MethodGet, "/books/1", nil).Header().Get("ETag")
+	listETag := serve(t, h, http.MethodGet, "/books", nil).Header().Get("ETag")
+	if etag == "" || listETag == "" {
+		t.Fatal("no ETag to revalidate with")
+	}
+
+	const vary = "Accept, Accept-Encoding, Accept-Language"
+	tests := []struct {
+		name, method, path string
+		header             []string
+		status             int
+		cacheControl       string
+	}{
+		{"list", http.MethodGet, "/books?sort=title", nil, http.StatusOK, cacheControlList},
+		{"list revalidated", http.MethodGet, "/books", []string{"If-None-Match", listETag}, http.StatusNotModified, cacheControlList},
+		{"search", http.MethodGet, "/books/search?q=dune", nil, http.StatusOK, cacheControlList},
+		{"book", http.MethodGet, "/books/1", nil, http.StatusOK, cacheControlBook},
+		{"book revalidated", http.MethodGet, "/books/1", []string{"If-None-Match", etag}, http.StatusNotModified, cacheControlBook},
+		{"book head", http.MethodHead, "/books/1", nil, http.StatusOK, cacheControlBook},
+		{"missing book", http.MethodGet, "/books/99", nil, http.StatusNotFound, cacheControlBook},
+		{"book lock", http.MethodGet, "/books/1/lock", nil, http.StatusMethodNotAllowed, noStore},
+		{"version", http.MethodGet, "/version", nil, http.StatusOK, cacheControlDefault},
+		{"snapshot", http.MethodGet, "/books?snapshot=true", nil, http.StatusOK, noStore},
+		{"probe", http.MethodGet, "/healthz", nil, http.StatusOK, noStore},
+		{"authenticated", http.MethodGet, "/books/1", []string{"Authorization", "Bearer t"}, http.StatusOK, noStore},
+		{"create", http.MethodPost, "/books", nil, http.StatusBadRequest, noStore},
+		{"update", http.MethodPut, "/books/1", nil, http.StatusBadRequest, noStore},
+		{"delete", http.MethodDelete, "/books/99", nil, http.StatusNotFound, noStore},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := serve(t, h, tt.method, tt.path, nil, tt.header...)
+			wantCode(t, rec, tt.status)
+			if got := rec.Header().Get("Cache-Control"); got != tt.cacheControl {
+				t.Errorf("Cache-Control %q, want %q", got, tt.cacheControl)
+			}
+			if got := rec.Header().Get("Vary"); got != vary {
+				t.Errorf("Vary %q, want %q", got, vary)
+			}
+		})
+	}
+}
+
+// TestCacheHeadersWithTokens checks that responses vary on Authorization
+// once tokens decide which fields are shown, and that authenticated reads
+// are never stored.
+func TestCacheHeadersWithTokens(t *testing.T) {
+	h := newTestServer(t)
+	mustCreateBook(t, h, map[string]any{"title": "Dune", "price": 9.99, "cost_price": 4.5})
+	withTokensFile(t)
+
+	for _, token := range []string{"r1", "a1"} {
+		rec := serve(t, h, http.MethodGet, "/books/1", nil, "Authorization", "Bearer "+token)
+		wantCode(t, rec, http.StatusOK)
+		if got := rec.Header().Get("Cache-Control"); got != noStore {
+			t.Errorf("%s: Cache-Control %q, want no-store", token, got)
+		}
+		if got := rec.Header().Get("Vary"); got != "Accept, Accept-Encoding, Accept-Language, Authorization" {
+			t.Errorf("%s: Vary %q, want Authorization among the headers", token, got)
+		}
+	}
+}
+
+// TestCacheControlFlags checks that each route group's Cache-Control can be
+// changed, or left out, on its own.
+func TestCacheControlFlags(t *testing.T) {
+	h := newTestServer(t)
+	mustCreateBook(t, h, map[string]any{"title": "Dune"})
+	setForTest(t, &cacheControlList, "public, max-age=5")
+	setForTest(t, &cacheControlBook, "")
+	setForTest(t, &cacheControlDefault, "private, max-age=60")
+
+	for path, want := range map[string]string{
+		"/books":   "public, max-age=5",
+		"/books/1": "",
+		"/version": "private, max-age=60",
+	} {
+		rec := serve(t, h, http.MethodGet, path, nil)
+		wantCode(t, rec, http.StatusOK)
+		if got := rec.Header().Get("Cache-Control"); got != want {
+			t.Errorf("%s: Cache-Control %q, want %q", path, got, want)
+		}
+	}
+	if got := serve(t, h, http.MethodPost, "/books", map[string]any{"title": "Emma"}).Header().Get("Cache-Control"); got != noStore {
+		t.Errorf("create: Cache-Control %q, want no-store whatever the flags", got)
+	}
+}
@@ -0,0 +1,109 @@
+package booksapi
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+	"time"
+)
+
+// The canonical form of a backup, written by GET /books/export?canonical=true
+// and by scheduled exports under -canonical-exports, is the same for the
+// same books, byte for byte, so exports can be compared with cmp or diff:
+//
+//   - The document is {"books":[...],"count":N,"sha256":"..."} followed by
+//     a newline, without any other whitespace.
+//   - Books are in ascending ID order.
+//   - The keys of every object are in ascending byte order, as
+//     json.Marshal orders those of a map.
+//   - Prices, those of editions included, are written with exactly
+//     priceDecimals decimal places, so 10 is 10.00.
+//   - Timestamps are in UTC and RFC 3339, to the second, such as
+//     2024-05-01T12:00:00Z.
+//
+// The checksum is that of the books as the canonical form holds them, so
+// importing a canonical export and exporting the catalog again gives the
+// same document.
+
+// canonicalBook returns book as the canonical form holds it.
+func canonicalBook(book Book) Book {
+	book.CreatedAt = canonicalTime(book.CreatedAt)
+	book.UpdatedAt = canonicalTime(book.UpdatedAt)
+	return book
+}
+
+// canonicalTime returns t in UTC, truncated to the second.
+func canonicalTime(t *time.Time) *time.Time {
+	if t == nil {
+		return nil
+	}
+	utc := t.UTC().Truncate(time.Second)
+	return &utc
+}
+
+// canonicalBooks returns list as the canonical form holds it, reusing list.
+func canonicalBooks(list []Book) []Book {
+	sortBooksByID(list)
+	for i := range list {
+		list[i] = canonicalBook(list[i])
+	}
+	return list
+}
+
+// marshalCanonicalBook encodes a book, as canonicalBook returns it, in
+// canonical form.
+func marshalCanonicalBook(book Book) ([]byte, error) {
+	data, err := json.Marshal(book)
+	if err != nil {
+		return nil, err
+	}
+	// Decoded into a map, the book's fields are encoded again in key order.
+	var fields map[string]any
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	if err := dec.Decode(&fields); err != nil {
+		return nil, err
+	}
+	fields["price"] = canonicalPrice(book.Price)
+	if book.CostPrice != nil {
+		fields["cost_price"] = canonicalPrice(*book.CostPrice)
+	}
+	if editions, ok := fields["editions"].([]any); ok {
+		for i, e := range editions {
+			if edition, ok := e.(map[string]any); ok && i < len(book.Editions) {
+				edition["price"] = canonicalPrice(book.Editions[i].Price)
+			}
+		}
+	}
+	return json.Marshal(fields)
+}
+
+// canonicalPrice returns a price with exactly priceDecimals decimal places,
+// rounded as Price.MarshalJSON rounds it.
+func canonicalPrice(p Price) json.Number {
+	data, _ := p.MarshalJSON()
+	f, _ := strconv.ParseFloat(string(data), 64)
+	return json.Number(strconv.FormatFloat(f, 'f', priceDecimals, 64))
+}
+
+// writeCanonicalBackup writes the backup document of list, which
+// canonicalBooks returned, in canonical form; sum is the checksum of list.
+func writeCanonicalBackup(w io.Writer, list []Book, sum string) error {
+	bw := bufio.NewWriter(w)
+	bw.WriteString(`{"books":[`)
+	for i, book := range list {
+		data, err := marshalCanonicalBook(book)
+		if err != nil {
+			return err
+		}
+		if i > 0 {
+			bw.WriteByte(',')
+		}
+		bw.Write(data)
+	}
+	fmt.Fprintf(bw, "],\"count\":%d,\"sha256\":%q}\n", len(list), sum)
+	return bw.Flush()
+}
@@ -0,0 +1,256 @@
+package booksapi
+
+import (
+	"context"
+	"net/http"
+	"slices"
+	"sync"
+	"time"
+)
+
+// tombstoneRetention is how long deletions are remembered for
+// GET /books/changes. It is configured by a flag in Main.
+var tombstoneRetention = 24 * time.Hour
+
+// changes records the change sequence of the books in store.
+var changes = newChangeLog()
+
+// changeLog numbers every change to the catalog with a sequence number,
+// remembering the last change of each book and the deletions made within
+// tombstoneRetention.
+type changeLog struct {
+	mu         sync.Mutex
+	seq        int64
+	floor      int64 // changes up to floor may have been forgotten
+	bookSeq    map[int]int64
+	tombstones []tombstone // ordered by seq
+}
+
+// tombstone records the deletion of a book.
+type tombstone struct {
+	id  int
+	seq int64
+	at  time.Time
+}
+
+// newChangeLog returns an empty change log. Sequence numbers start at the
+// current time in microseconds, so those handed out after a restart are
+// larger than any a client kept from before it; such clients are then told
+// to resync, since the deletions they missed are not known.
+func newChangeLog() *changeLog {
+	seq := time.Now().UnixMicro()
+	return &changeLog{seq: seq, floor: seq, bookSeq: make(map[int]int64)}
+}
+
+// record assigns the next sequence number to a change of the book with the
+// given ID.
+func (l *changeLog) record(id int, deleted bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.seq++
+	l.tombstones = slices.DeleteFunc(l.tombstones, func(t tombstone) bool { return t.id == id })
+	if deleted {
+		delete(l.bookSeq, id)
+		l.tombstones = append(l.tombstones, tombstone{id: id, seq: l.seq, at: time.Now()})
+	} else {
+		l.bookSeq[id] = l.seq
+	}
+	l.prune()
+}
+
+// prune forgets the deletions older than tombstoneRetention. Callers must
+// hold l.mu.
+func (l *changeLog) prune() {
+	cutoff := time.Now().Add(-tombstoneRetention)
+	n := 0
+	for n < len(l.tombstones) && l.tombstones[n].at.Before(cutoff) {
+		l.floor = l.tombstones[n].seq
+		n++
+	}
+	l.tombstones = l.tombstones[n:]
+}
+
+// since returns the IDs of the books changed and deleted after sequence
+// number since, and the current sequence number. It returns false if the
+// changes since then are no longer, or were never, known.
+func (l *changeLog) since(since int64) (changed map[int]bool, deleted []int, seq int64, ok bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.prune()
+	if since < l.floor || since > l.seq {
+		return nil, nil, l.seq, false
+	}
+	changed = make(map[int]bool)
+	for id, s := range l.bookSeq {
+		if s > since {
+			changed[id] = true
+		}
+	}
+	deleted = []int{}
+	for _, t := range l.tombstones {
+		if t.seq > since {
+			deleted = append(deleted, t.id)
+		}
+	}
+	return changed, deleted, l.seq, true
+}
+
+// current returns the current sequence number.
+func (l *changeLog) current() int64 {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.seq
+}
+
+// changeStore records the changes made through it in changes.
+type changeStore struct {
+	BookStore
+}
+
+// trackChanges wraps inner so that its changes are recorded in changes.
+func trackChanges(inner BookStore) BookStore {
+	return changeStore{inner}
+}
+
+// Unwrap returns the wrapped store.
+func (s changeStore) Unwrap() BookStore { return s.BookStore }
+
+func (s changeStore) Create(book Book) (Book, error) {
+	book, err := s.BookStore.Create(book)
+	if err == nil {
+		changes.record(book.ID, false)
+	}
+	return book, err
+}
+
+func (s changeStore) Put(book Book) error {
+	err := s.BookStore.Put(book)
+	if err == nil {
+		changes.record(book.ID, false)
+	}
+	return err
+}
+
+func (s changeStore) Delete(id int) error {
+	_, found := s.BookStore.Get(id)
+	err := s.BookStore.Delete(id)
+	if err == nil && found {
+		changes.record(id, true)
+	}
+	return err
+}
+
+// Transact runs fn as a transaction of the wrapped store, recording its
+// changes once it has succeeded, so an undone transaction leaves no trace
+// in the change feed.
+func (s changeStore) Transact(ctx context.Context, fn func(tx BookStore) error) error {
+	var changed []trackedChange
+	err := s.BookStore.Transact(ctx, func(tx BookStore) error {
+		return fn(changeTx{tx, &changed})
+	})
+	if err == nil {
+		for _, c := range changed {
+			changes.record(c.id, c.deleted)
+		}
+	}
+	return err
+}
+
+// trackedChange is a change made within a transaction, to be recorded in
+// changes once it succeeds.
+type trackedChange struct {
+	id      int
+	deleted bool
+}
+
+// changeTx is the view of a transaction that changeStore hands to fn,
+// collecting its changes.
+type changeTx struct {
+	BookStore
+	changed *[]trackedChange
+}
+
+// Unwrap returns the wrapped transaction.
+func (tx changeTx) Unwrap() BookStore { return tx.BookStore }
+
+func (tx changeTx) Create(book Book) (Book, error) {
+	book, err := tx.BookStore.Create(book)
+	if err == nil {
+		*tx.changed = append(*tx.changed, trackedChange{book.ID, false})
+	}
+	return book, err
+}
+
+func (tx changeTx) Put(book Book) error {
+	err := tx.BookStore.Put(book)
+	if err == nil {
+		*tx.changed = append(*tx.changed, trackedChange{book.ID, false})
+	}
+	return err
+}
+
+func (tx changeTx) Delete(id int) error {
+	_, found := tx.BookStore.Get(id)
+	err := tx.BookStore.Delete(id)
+	if err == nil && found {
+		*tx.changed = append(*tx.changed, trackedChange{id, true})
+	}
+	return err
+}
+
+// Transact runs a nested transaction, whose changes are only kept if it
+// succeeds.
+func (tx changeTx) Transact(ctx context.Context, fn func(tx BookStore) error) error {
+	mark := len(*tx.changed)
+	err := tx.BookStore.Transact(ctx, func(inner BookStore) error {
+		return fn(changeTx{inner, tx.changed})
+	})
+	if err != nil {
+		*tx.changed = (*tx.changed)[:mark]
+	}
+	return err
+}
+
+// changesParams declares the query parameters of GET /books/changes.
+var changesParams = slices.Concat(bookViewParams, []queryParam{{name: "since", kind: intParam}})
+
+// changesResult is the response body of GET /books/changes.
+type changesResult struct {
+	Changed []bookResponse `json:"changed"`
+	Deleted []int          `json:"deleted"`
+	Seq     int64          `json:"seq"`
+}
+
+// getChanges returns the books created or changed and the IDs of the books
+// deleted after the sequence number given as ?since=, together with the
+// sequence number to pass next time (GET /books/changes). Without since,
+// every book is returned. A since older than the remembered deletions gets
+// 410, telling the client to fetch the whole catalog again.
+func getChanges(w http.ResponseWriter, r *http.Request) {
+	q, ok := checkQuery(w, r, changesParams...)
+	if !ok {
+		return
+	}
+
+	if !q.Has("since") || q.Int("since") == 0 {
+		seq := changes.current()
+		writeJSON(w, http.StatusOK, changesResult{Changed: renderBooks(r, store.List(ListOptions{})), Deleted: []int{}, Seq: seq})
+		return
+	}
+
+	since := int64(q.Int("since"))
+	changed, deleted, seq, ok := changes.since(since)
+	if !ok {
+		writeError(w, r, http.StatusGone, "changes_expired", "since", since)
+		return
+	}
+	var list []Book
+	for _, book := range store.List(ListOptions{}) {
+		if changed[book.ID] {
+			list = append(list, book)
+		}
+	}
+	writeJSON(w, http.StatusOK, changesResult{Changed: renderBooks(r, list), Deleted: deleted, Seq: seq})
+}
@@ -0,0 +1,109 @@
+package booksapi
+
+import (
+	"fmt"
+	"net/http"
+	"slices"
+	"testing"
+	"time"
+)
+
+// changedIDs returns the IDs of the changed books of a changes response.
+func changedIDs(result changesResult) []int {
+	var ids []int
+	for _, book := range result.Changed {
+		ids = append(ids, book.ID)
+	}
+	slices.Sort(ids)
+	return ids
+}
+
+// getChangesSince fetches the changes after since, failing the test unless
+// they are answered with 200.
+func getChangesSince(t *testing.T, h http.Handler, since int64) changesResult {
+	t.Helper()
+	rec := serve(t, h, http.MethodGet, fmt.Sprint("/books/changes?since=", since), nil)
+	wantCode(t, rec, http.StatusOK)
+	return decode[changesResult](t, rec)
+}
+
+// TestChanges syncs a client incrementally through creates, updates, and
+// deletes.
+func TestChanges(t *testing.T) {
+	h := newTestServer(t)
+	mustCreateBook(t, h, map[string]any{"title": "Dune"})
+	mustCreateBook(t, h, map[string]any{"title": "Emma"})
+	mustCreateBook(t, h, map[string]any{"title": "Beloved"})
+
+	rec := serve(t, h, http.MethodGet, "/books/changes", nil)
+	wantCode(t, rec, http.StatusOK)
+	full := decode[changesResult](t, rec)
+	if ids := changedIDs(full); !slices.Equal(ids, []int{1, 2, 3}) || len(full.Deleted) != 0 || full.Seq == 0 {
+		t.Fatalf("full sync: changed %v, deleted %v, seq %d", ids, full.Deleted, full.Seq)
+	}
+
+	if got := getChangesSince(t, h, full.Seq); len(got.Changed) != 0 || len(got.Deleted) != 0 || got.Seq != full.Seq {
+		t.Errorf("changes with none made: %+v", got)
+	}
+
+	wantCode(t, serve(t, h, http.MethodPut, "/books/1", map[string]any{"title": "Dune Messiah"}), http.StatusOK)
+	wantCode(t, serve(t, h, http.MethodDelete, "/books/2", nil), http.StatusNoContent)
+	mustCreateBook(t, h, map[string]any{"title": "Persuasion"})
+	first := getChangesSince(t, h, full.Seq)
+	if ids := changedIDs(first); !slices.Equal(ids, []int{1, 4}) || !slices.Equal(first.Deleted, []int{2}) || first.Seq <= full.Seq {
+		t.Errorf("first sync: changed %v, deleted %v, seq %d after %d; want [1 4], [2]", ids, first.Deleted, first.Seq, full.Seq)
+	}
+	if first.Changed[0].Title != "Dune Messiah" {
+		t.Errorf("changed book %+v, want its new title", first.Changed[0])
+	}
+
+	// A book changed and then deleted is only reported deleted.
+	wantCode(t, serve(t, h, http.MethodPut, "/books/3", map[string]any{"title": "Jazz"}), http.StatusOK)
+	wantCode(t, serve(t, h, http.MethodDelete, "/books/3", nil), http.StatusNoContent)
+	second := getChangesSince(t, h, first.Seq)
+	if ids := changedIDs(second); len(ids) != 0 || !slices.Equal(second.Deleted, []int{3}) {
+		t.Errorf("second sync: changed %v, deleted %v; want only 3 deleted", ids, second.Deleted)
+	}
+
+	// A transaction that fails leaves nothing to sync.
+	rec = serve(t, h, http.MethodPost, "/books/transactions", map[string]any{"operations": []map[string]any{
+		{"op": "update", "id": 1, "book": map[string]any{"price": 2}},
+		{"op": "delete", "id": 99},
+	}})
+	wantCode(t, rec, http.StatusNotFound)
+	if got := getChangesSince(t, h, second.Seq); len(got.Changed) != 0 || len(got.Deleted) != 0 || got.Seq != second.Seq {
+		t.Errorf("changes after a failed transaction: %+v", got)
+	}
+}
+
+// TestChangesExpired checks that a since older than the deletions still
+// remembered, or newer than any sequence number handed out, gets 410.
+func TestChangesExpired(t *testing.T) {
+	h := newTestServer(t)
+	mustCreateBook(t, h, map[string]any{"title": "Dune"})
+	mustCreateBook(t, h, map[string]any{"title": "Emma"})
+	start := changes.current()
+
+	wantCode(t, serve(t, h, http.MethodDelete, "/books/1", nil), http.StatusNoContent)
+	afterDelete := changes.current()
+	wantCode(t, serve(t, h, http.MethodDelete, "/books/2", nil), http.StatusNoContent)
+	if got := getChangesSince(t, h, start); !slices.Equal(got.Deleted, []int{1, 2}) {
+		t.Fatalf("deleted %v, want [1 2]", got.Deleted)
+	}
+
+	// The first deletion falls out of the retention period.
+	changes.mu.Lock()
+	changes.tombstones[0].at = time.Now().Add(-tombstoneRetention - time.Minute)
+	changes.mu.Unlock()
+
+	for _, since := range []int64{start, changes.current() + 1} {
+		rec := serve(t, h, http.MethodGet, fmt.Sprint("/books/changes?since=", since), nil)
+		wantCode(t, rec, http.StatusGone)
+		if got := errorCode(t, rec); got != "changes_expired" {
+			t.Errorf("since %d: error code %q, want changes_expired", since, got)
+		}
+	}
+	if got := getChangesSince(t, h, afterDelete); !slices.Equal(got.Deleted, []int{2}) {
+		t.Errorf("deleted since the forgotten deletion: %v, want [2]", got.Deleted)
+	}
+}
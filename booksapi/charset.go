@@ -0,0 +1,151 @@
+package booksapi
+
+import (
+	"bytes"
+	"encoding/json"
+	"mime"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"golang.org/x/text/encoding/charmap"
+)
+
+// latin1Charsets are the Content-Type charset names of ISO 8859-1 bodies,
+// which are transcoded to UTF-8 before decoding.
+var latin1Charsets = []string{"iso-8859-1", "iso8859-1", "iso_8859-1", "latin1", "latin-1", "l1"}
+
+// bodyUTF8 returns a request body as UTF-8, transcoding it from the charset
+// named by the Content-Type header. Only UTF-8, the default, and Latin-1 are
+// accepted. Bytes that are not valid UTF-8 are rejected with 422 naming the
+// field holding them, so stored books are always valid UTF-8; the JSON
+// decoder would otherwise turn them into replacement characters.
+func bodyUTF8(r *http.Request, data []byte) ([]byte, error) {
+	latin1, err := isLatin1Body(r)
+	if err != nil {
+		return nil, err
+	}
+	if latin1 {
+		if data, err = charmap.ISO8859_1.NewDecoder().Bytes(data); err != nil {
+			return nil, newAPIError(http.StatusBadRequest, "invalid_request")
+		}
+	}
+	if err := checkUTF8(data); err != nil {
+		return nil, err
+	}
+	return data, nil
+}
+
+// isLatin1Body reports whether the Content-Type header declares a Latin-1
+// body. Charsets other than UTF-8 and Latin-1 get 415.
+func isLatin1Body(r *http.Request) (bool, error) {
+	charset := ""
+	if _, params, err := mime.ParseMediaType(r.Header.Get("Content-Type")); err == nil {
+		charset = strings.ToLower(strings.TrimSpace(params["charset"]))
+	}
+	switch {
+	case charset == "" || charset == "utf-8" || charset == "utf8":
+		return false, nil
+	case slices.Contains(latin1Charsets, charset):
+		return true, nil
+	default:
+		return false, newAPIError(http.StatusUnsupportedMediaType, "unsupported_charset", "charset", charset)
+	}
+}
+
+// checkUTF8 rejects JSON data that is not valid UTF-8 with 422 naming the
+// field holding the first invalid byte.
+func checkUTF8(data []byte) error {
+	if utf8.Valid(data) {
+		return nil
+	}
+	field, index := invalidUTF8Field(data)
+	if index >= 0 {
+		return newAPIError(http.StatusUnprocessableEntity, "invalid_utf8", "field", field, "index", strconv.Itoa(index))
+	}
+	return newAPIError(http.StatusUnprocessableEntity, "invalid_utf8", "field", field)
+}
+
+// invalidUTF8Field returns the dotted name of the field whose key or value
+// holds the first byte of data that is not valid UTF-8, and the index of the
+// element holding it when data is an array, or -1.
+func invalidUTF8Field(data []byte) (string, int) {
+	bad := 0
+	for bad < len(data) {
+		r, size := utf8.DecodeRune(data[bad:])
+		if r == utf8.RuneError && size == 1 {
+			break
+		}
+		bad += size
+	}
+
+	// frame is an object or array being decoded.
+	type frame struct {
+		object  bool
+		wantKey bool   // the next string in the object is a key
+		key     string // key of the value being decoded, in an object
+		index   int    // index of the value being decoded, in an array
+	}
+	var stack []*frame
+	path := func() (string, int) {
+		var names []string
+		for _, f := range stack {
+			if f.object && f.key != "" {
+				names = append(names, f.key)
+			}
+		}
+		index := -1
+		if len(stack) > 0 && !stack[0].object {
+			index = stack[0].index
+		}
+		return strings.Join(names, "."), index
+	}
+	valueDone := func() {
+		if len(stack) == 0 {
+			return
+		}
+		if top := stack[len(stack)-1]; top.object {
+			top.wantKey = true
+		} else {
+			top.index++
+		}
+	}
+
+	dec := json.NewDecoder(bytes.NewReader(data))
+	for {
+		tok, err := dec.Token()
+		if err != nil {
+			return path()
+		}
+		var top *frame
+		if len(stack) > 0 {
+			top = stack[len(stack)-1]
+		}
+		switch tok := tok.(type) {
+		case json.Delim:
+			switch tok {
+			case '{', '[':
+				stack = append(stack, &frame{object: tok == '{', wantKey: tok == '{'})
+			default:
+				stack = stack[:len(stack)-1]
+				valueDone()
+			}
+		case string:
+			if top != nil && top.object && top.wantKey {
+				top.key, top.wantKey = tok, false
+				if dec.InputOffset() > int64(bad) {
+					return path()
+				}
+				continue
+			}
+			if dec.InputOffset() > int64(bad) {
+				return path()
+			}
+			valueDone()
+		default:
+			valueDone()
+		}
+	}
+}
@@ -0,0 +1,79 @@
+package booksapi
+
+import (
+	"net/http"
+	"testing"
+)
+
+// TestCharsets sends raw bodies in UTF-8 and Latin-1 and checks the names
+// stored and returned.
+func TestCharsets(t *testing.T) {
+	tests := []struct {
+		name        string
+		contentType string
+		body        string
+		status      int
+		want        string // the stored author
+	}{
+		{"UTF-8", "application/json", "{\"title\": \"Kafka\", \"author\": \"M\xc3\xbcller\"}", http.StatusCreated, "Müller"},
+		{"UTF-8 declared", "application/json; charset=UTF-8", "{\"title\": \"Kafka\", \"author\": \"M\xc3\xbcller\"}", http.StatusCreated, "Müller"},
+		{"Latin-1", "application/json; charset=iso-8859-1", "{\"title\": \"Kafka\", \"author\": \"M\xfcller\"}", http.StatusCreated, "Müller"},
+		{"Latin-1 alias", "application/json; charset=Latin1", "{\"title\": \"Kafka\", \"author\": \"Fran\xe7ois \xc9mile\"}", http.StatusCreated, "François Émile"},
+		// UTF-8 bytes read as Latin-1 are two characters each.
+		{"UTF-8 declared Latin-1", "application/json; charset=iso-8859-1", "{\"title\": \"Kafka\", \"author\": \"M\xc3\xbcller\"}", http.StatusCreated, "MÃ¼ller"},
+		{"Latin-1 undeclared", "application/json", "{\"title\": \"Kafka\", \"author\": \"M\xfcller\"}", http.StatusUnprocessableEntity, ""},
+		{"other charset", "application/json; charset=windows-1252", "{\"title\": \"Kafka\"}", http.StatusUnsupportedMediaType, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := newTestServer(t)
+			rec := serve(t, h, http.MethodPost, "/books", tt.body, "Content-Type", tt.contentType)
+			wantCode(t, rec, tt.status)
+			if tt.status != http.StatusCreated {
+				return
+			}
+			if got := decode[Book](t, rec).Author; got != tt.want {
+				t.Errorf("returned author %q, want %q", got, tt.want)
+			}
+			if stored, _ := store.Get(1); stored.Author != tt.want {
+				t.Errorf("stored author %q, want %q", stored.Author, tt.want)
+			}
+			rec = serve(t, h, http.MethodGet, "/books/1", nil)
+			if got := decode[Book](t, rec).Author; got != tt.want {
+				t.Errorf("read back author %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+// TestInvalidUTF8 checks that invalid bytes are reported with the field,
+// and for imports the element, holding them.
+func TestInvalidUTF8(t *testing.T) {
+	h := newTestServer(t)
+	tests := []struct {
+		name, path, body string
+		params           map[string]string
+	}{
+		{"value", "/books", "{\"title\": \"Kafka\", \"author\": \"M\xfcller\"}", map[string]string{"field": "author"}},
+		{"nested", "/books", "{\"title\": \"Kafka\", \"attributes\": {\"shelf\": \"\xff\"}}", map[string]string{"field": "attributes.shelf"}},
+		{"import", "/books/import", "[{\"title\": \"Kafka\"}, {\"title\": \"Emma\"}, {\"title\": \"Fran\xe7ois\"}]", map[string]string{"field": "title", "index": "2"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := serve(t, h, http.MethodPost, tt.path, tt.body)
+			wantCode(t, rec, http.StatusUnprocessableEntity)
+			got := decode[errorBody](t, rec).Error
+			if got.Code != "invalid_utf8" {
+				t.Errorf("error code %q, want invalid_utf8", got.Code)
+			}
+			for name, value := range tt.params {
+				if got.Params[name] != value {
+					t.Errorf("error params %q, want %s=%q", got.Params, name, value)
+				}
+			}
+		})
+	}
+	if n := store.Count(); n != 0 {
+		t.Errorf("%d books stored", n)
+	}
+}
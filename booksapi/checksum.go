@@ -0,0 +1,190 @@
+package booksapi
+
+import (
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"hash"
+	"io"
+	"net/http"
+	"os"
+	"reflect"
+)
+
+// checksumHeader carries the checksum of the books of an export.
+const checksumHeader = "X-Content-SHA256"
+
+// backupDocument is the format of GET /books/export and of scheduled
+// exports: the books, followed by their number and checksum, so a copy
+// that lost its end is told from a complete one.
+type backupDocument struct {
+	Books  []Book `json:"books"`
+	Count  *int   `json:"count"`
+	SHA256 string `json:"sha256"`
+}
+
+// bookChecksum computes the checksum of a list of books: the SHA-256 of
+// their canonical form, the json.Marshal encoding of each book followed by
+// a newline. Decoding and re-encoding a book gives back its canonical form,
+// so the checksum does not depend on how a backup was formatted.
+type bookChecksum struct {
+	h     hash.Hash
+	count int
+}
+
+func newBookChecksum() *bookChecksum {
+	return &bookChecksum{h: sha256.New()}
+}
+
+// add adds a book to the checksum.
+func (c *bookChecksum) add(book Book) error {
+	data, err := json.Marshal(book)
+	if err != nil {
+		return err
+	}
+	c.h.Write(data)
+	c.h.Write([]byte("\n"))
+	c.count++
+	return nil
+}
+
+// sum returns the checksum in hex.
+func (c *bookChecksum) sum() string {
+	return hex.EncodeToString(c.h.Sum(nil))
+}
+
+// checksumBooks returns the checksum of list.
+func checksumBooks(list []Book) (string, error) {
+	c := newBookChecksum()
+	for _, book := range list {
+		if err := c.add(book); err != nil {
+			return "", err
+		}
+	}
+	return c.sum(), nil
+}
+
+// newBackupDocument returns the backup document holding list.
+func newBackupDocument(list []Book) (backupDocument, error) {
+	sum, err := checksumBooks(list)
+	if err != nil {
+		return backupDocument{}, err
+	}
+	count := len(list)
+	return backupDocument{Books: list, Count: &count, SHA256: sum}, nil
+}
+
+// verifyChecksum checks the declared count and checksum of a backup
+// against the books it holds, c being their checksum, failing with 422 if
+// either is missing or does not match.
+func verifyChecksum(c *bookChecksum, count *int, sum string) error {
+	switch {
+	case count == nil:
+		return newAPIError(http.StatusUnprocessableEntity, "backup_incomplete", "field", "count")
+	case sum == "":
+		return newAPIError(http.StatusUnprocessableEntity, "backup_incomplete", "field", "sha256")
+	case *count != c.count:
+		return newAPIError(http.StatusUnprocessableEntity, "backup_count_mismatch", "expected", *count, "actual", c.count)
+	case sum != c.sum():
+		return newAPIError(http.StatusUnprocessableEntity, "backup_checksum_mismatch", "expected", sum, "actual", c.sum())
+	}
+	return nil
+}
+
+// decodeBackup decodes the books of a backup: a backupDocument, whose
+// count and checksum are verified, or a bare JSON array of books as
+// exported before backups carried checksums, or sent by a client, whose
+// renamed fields are resolved as in other request bodies. Failures to
+// decode data, the body of r, are reported by decodeFailure.
+func decodeBackup(r *http.Request, data []byte) ([]Book, error) {
+	switch jsonValueKind(data) {
+	case "array":
+		resolved, err := resolveBodyAliases(r, data, reflect.TypeFor[[]Book]())
+		if err != nil {
+			return nil, err
+		}
+		var list []Book
+		if err := json.Unmarshal(resolved, &list); err != nil {
+			return nil, decodeFailure(r, data, err)
+		}
+		return list, nil
+	case "object":
+		var doc backupDocument
+		if err := json.Unmarshal(data, &doc); err != nil {
+			return nil, decodeFailure(r, data, err)
+		}
+		c := newBookChecksum()
+		for _, book := range doc.Books {
+			if err := c.add(book); err != nil {
+				return nil, err
+			}
+		}
+		if err := verifyChecksum(c, doc.Count, doc.SHA256); err != nil {
+			return nil, err
+		}
+		if doc.Books == nil {
+			doc.Books = []Book{}
+		}
+		return doc.Books, nil
+	}
+	return nil, decodeFailure(r, data, checkJSONKind(data, "object"))
+}
+
+// isBackupDocument reports whether data is a JSON object with books, as a
+// backupDocument is.
+func isBackupDocument(data []byte) bool {
+	var probe struct {
+		Books json.RawMessage `json:"books"`
+	}
+	return jsonValueKind(data) == "object" && json.Unmarshal(data, &probe) == nil && probe.Books != nil
+}
+
+// runCheck implements the check subcommand, which verifies backup files
+// offline: "week05_Assignment check FILE...". Gzip-compressed files are
+// decompressed first. It exits with status 1 if any file fails.
+func runCheck(paths []string) int {
+	if len(paths) == 0 {
+		fmt.Fprintln(os.Stderr, "usage: check FILE...")
+		return 2
+	}
+	status := 0
+	for _, path := range paths {
+		n, err := checkBackupFile(path)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%s: %v\n", path, err)
+			status = 1
+			continue
+		}
+		fmt.Printf("%s: ok, %d books\n", path, n)
+	}
+	return status
+}
+
+// checkBackupFile verifies a backup file, returning its number of books.
+// Bare arrays carry no checksum and fail.
+func checkBackupFile(path string) (int, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return 0, err
+	}
+	if bytes.HasPrefix(data, gzipMagic) {
+		zr, err := gzip.NewReader(bytes.NewReader(data))
+		if err != nil {
+			return 0, err
+		}
+		if data, err = io.ReadAll(zr); err != nil {
+			return 0, err
+		}
+	}
+	if jsonValueKind(data) != "object" {
+		return 0, fmt.Errorf("not a backup document with a checksum")
+	}
+	list, err := decodeBackup(nil, data)
+	if err != nil {
+		return 0, err
+	}
+	return len(list), nil
+}
@@ -0,0 +1,153 @@
+package booksapi
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// exportCatalog creates a few books and returns the backup document
+// GET /books/export gives of them, checking its checksum header.
+func exportCatalog(t *testing.T) []byte {
+	t.Helper()
+	h := newTestServer(t)
+	mustCreateBook(t, h, map[string]any{"title": "Dune", "author": "Frank Herbert", "price": 9.99})
+	mustCreateBook(t, h, map[string]any{"title": "Emma", "author": "Jane Austen", "price": 7.99, "language": "en"})
+	mustCreateBook(t, h, map[string]any{"title": "Ébène", "author": "Ryszard Kapuściński", "price": 11})
+
+	rec := serve(t, h, http.MethodGet, "/books/export", nil)
+	wantCode(t, rec, http.StatusOK)
+	var doc backupDocument
+	if err := json.Unmarshal(rec.Body.Bytes(), &doc); err != nil {
+		t.Fatal(err)
+	}
+	if doc.Count == nil || *doc.Count != 3 || len(doc.Books) != 3 {
+		t.Fatalf("export of %d books declares %v", len(doc.Books), doc.Count)
+	}
+	if got := rec.Header().Get(checksumHeader); got != doc.SHA256 || len(got) != 64 {
+		t.Errorf("%s %q, want the document's checksum %q", checksumHeader, got, doc.SHA256)
+	}
+	if sum, _ := checksumBooks(doc.Books); sum != doc.SHA256 {
+		t.Errorf("checksum %s, want %s", doc.SHA256, sum)
+	}
+	return rec.Body.Bytes()
+}
+
+// writeBackupFile writes data to a file of the test and returns its path.
+func writeBackupFile(t *testing.T, name string, data []byte) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(path, data, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+// TestBackupRoundTrip checks that an export, reformatted or compressed,
+// imports into an empty catalog as it was and passes the offline check.
+func TestBackupRoundTrip(t *testing.T) {
+	backup := exportCatalog(t)
+	var indented bytes.Buffer
+	if err := json.Indent(&indented, backup, "", "\t"); err != nil {
+		t.Fatal(err)
+	}
+	var zipped bytes.Buffer
+	zw := gzip.NewWriter(&zipped)
+	zw.Write(backup)
+	zw.Close()
+
+	for name, data := range map[string][]byte{"backup.json": backup, "indented.json": indented.Bytes(), "backup.json.gz": zipped.Bytes()} {
+		t.Run(name, func(t *testing.T) {
+			if n, err := checkBackupFile(writeBackupFile(t, name, data)); err != nil || n != 3 {
+				t.Errorf("check: %d books, %v; want 3 and no error", n, err)
+			}
+
+			h := newTestServer(t)
+			header := []string{}
+			if strings.HasSuffix(name, ".gz") {
+				header = []string{"Content-Encoding", "gzip"}
+			}
+			rec := serve(t, h, http.MethodPost, "/books/import", data, header...)
+			wantCode(t, rec, http.StatusOK)
+			if got := decode[importResult](t, rec).Imported; got != 3 {
+				t.Errorf("imported %d books, want 3", got)
+			}
+			if got := serve(t, h, http.MethodGet, "/books/export", nil).Body.Bytes(); !bytes.Equal(got, backup) {
+				t.Errorf("export after the import\n%s\nwant\n%s", got, backup)
+			}
+		})
+	}
+}
+
+// TestBackupDamaged checks that a backup with a changed byte, one cut
+// short, and one that lost a book are rejected before anything is
+// imported, saying what was expected and what was found.
+func TestBackupDamaged(t *testing.T) {
+	backup := exportCatalog(t)
+	var doc backupDocument
+	if err := json.Unmarshal(backup, &doc); err != nil {
+		t.Fatal(err)
+	}
+	flipped := bytes.Replace(backup, []byte(`"price":11`), []byte(`"price":12`), 1)
+	var flippedDoc backupDocument
+	if err := json.Unmarshal(flipped, &flippedDoc); err != nil {
+		t.Fatal(err)
+	}
+	actual, _ := checksumBooks(flippedDoc.Books)
+	lost := doc
+	lost.Books = doc.Books[:2]
+	lostBackup, err := json.Marshal(lost)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name   string
+		data   []byte
+		status int
+		code   string
+		params map[string]string
+	}{
+		{"byte flipped", flipped, http.StatusUnprocessableEntity, "backup_checksum_mismatch", map[string]string{"expected": doc.SHA256, "actual": actual}},
+		{"truncated", backup[:len(backup)/2], http.StatusBadRequest, "invalid_json", nil},
+		{"lost a book", lostBackup, http.StatusUnprocessableEntity, "backup_count_mismatch", map[string]string{"expected": "3", "actual": "2"}},
+		{"no checksum", bytes.Replace(backup, []byte(doc.SHA256), nil, 1), http.StatusUnprocessableEntity, "backup_incomplete", map[string]string{"field": "sha256"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if bytes.Equal(tt.data, backup) {
+				t.Fatal("backup not damaged")
+			}
+			if _, err := checkBackupFile(writeBackupFile(t, "backup.json", tt.data)); err == nil {
+				t.Error("check passed")
+			}
+
+			h := newTestServer(t)
+			for _, path := range []string{"/books/import", "/books/diff", "/admin/merge"} {
+				rec := serve(t, h, http.MethodPost, path, tt.data)
+				wantCode(t, rec, tt.status)
+				got := decode[errorBody](t, rec).Error
+				if got.Code != tt.code {
+					t.Errorf("%s: error %s %v, want %s", path, got.Code, got.Params, tt.code)
+				}
+				for key, want := range tt.params {
+					if got.Params[key] != want {
+						t.Errorf("%s: %s %q, want %q", path, key, got.Params[key], want)
+					}
+				}
+			}
+			if n := store.Count(); n != 0 {
+				t.Errorf("%d books imported from a damaged backup", n)
+			}
+		})
+	}
+
+	if _, err := checkBackupFile(writeBackupFile(t, "books.json", []byte(`[{"title": "Dune"}]`))); err == nil {
+		t.Error("check passed a bare array, which has no checksum")
+	}
+}
@@ -0,0 +1,271 @@
+package booksapi
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// coldStorageDir is the directory archived books are moved to, one JSON
+// file per book, configured by a flag in Main.
+var coldStorageDir = "archived"
+
+// Global variables to track archived books. Their contents are only read
+// from disk when asked for. When both locks are needed, mu must be acquired
+// before archivedMu.
+var (
+	archivedIDs = make(map[int]struct{})
+	archivedMu  sync.Mutex
+)
+
+// openColdStorage loads the IDs of the books archived in coldStorageDir,
+// reserving them in the store so they are never handed out again. Books
+// found in the store as well, as left by an archive interrupted between
+// writing the file and removing the book, stay hot.
+func openColdStorage() error {
+	entries, err := os.ReadDir(coldStorageDir)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	for _, entry := range entries {
+		name, ok := strings.CutSuffix(entry.Name(), ".json")
+		id, err := strconv.Atoi(name)
+		if !ok || err != nil || id < 1 {
+			continue
+		}
+		if _, found := store.Get(id); found {
+			os.Remove(archivedBookPath(id))
+			continue
+		}
+		if err := store.Reserve(id); err != nil {
+			return err
+		}
+		archivedIDs[id] = struct{}{}
+	}
+	return nil
+}
+
+// bookArchiveHandler handles POST /books/{id}/archive and
+// /books/{id}/unarchive.
+func bookArchiveHandler(w http.ResponseWriter, r *http.Request, id int, action string) {
+	if _, ok := checkQuery(w, r, bookViewParams...); !ok {
+		return
+	}
+	if r.Method != http.MethodPost {
+		writeError(w, r, http.StatusMethodNotAllowed, "method_not_allowed")
+		return
+	}
+
+	if action == "archive" {
+		archiveBook(w, r, id)
+	} else {
+		unarchiveBook(w, r, id)
+	}
+}
+
+// archiveBook moves a book from the store to cold storage. It keeps its ID,
+// can still be read with GET /books/{id}, and is left out of listings
+// unless they ask for ?include_archived=true. To the change feed the book
+// is deleted. Its shelves, favorites, and cover are kept.
+func archiveBook(w http.ResponseWriter, r *http.Request, id int) {
+	mu.Lock()
+	defer mu.Unlock()
+
+	book, err := findBook(id)
+	if err != nil {
+		writeAPIError(w, r, err)
+		return
+	}
+	if err := checkBookLock(r, id); err != nil {
+		writeAPIError(w, r, err)
+		return
+	}
+
+	if err := moveToColdStorage(book); err != nil {
+		writeAPIError(w, r, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, renderBook(r, book))
+}
+
+// moveToColdStorage writes book to cold storage and removes it from the
+// store. Callers must hold mu.
+func moveToColdStorage(book Book) error {
+	archivedMu.Lock()
+	defer archivedMu.Unlock()
+
+	if err := writeArchivedBook(book); err != nil {
+		return err
+	}
+	if err := store.Delete(book.ID); err != nil {
+		os.Remove(archivedBookPath(book.ID))
+		return err
+	}
+	archivedIDs[book.ID] = struct{}{}
+	return nil
+}
+
+// unarchiveBook moves an archived book back into the store, where it can be
+// changed again.
+func unarchiveBook(w http.ResponseWriter, r *http.Request, id int) {
+	mu.Lock()
+	defer mu.Unlock()
+
+	if _, found := store.Get(id); found {
+		writeError(w, r, http.StatusConflict, "book_not_archived", "id", id)
+		return
+	}
+	if err := checkQuota(1); err != nil {
+		writeAPIError(w, r, err)
+		return
+	}
+	book, err := moveFromColdStorage(id)
+	if err != nil {
+		writeAPIError(w, r, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, renderBook(r, book))
+}
+
+// moveFromColdStorage puts the archived book with the given ID back into
+// the store and removes it from cold storage. It fails with 409 if another
+// book has taken one of its edition ISBNs while it was archived. Callers
+// must hold mu.
+func moveFromColdStorage(id int) (Book, error) {
+	archivedMu.Lock()
+	defer archivedMu.Unlock()
+
+	book, err := readArchivedBookLocked(id)
+	if err != nil {
+		return Book{}, err
+	}
+	if err := checkEditionISBNs(book); err != nil {
+		return Book{}, err
+	}
+	if err := store.Put(book); err != nil {
+		return Book{}, err
+	}
+	os.Remove(archivedBookPath(id))
+	delete(archivedIDs, id)
+	return book, nil
+}
+
+// isArchived reports whether the book with the given ID is archived.
+func isArchived(id int) bool {
+	archivedMu.Lock()
+	defer archivedMu.Unlock()
+
+	_, found := archivedIDs[id]
+	return found
+}
+
+// archivedError is the error of a change to an archived book, which must be
+// unarchived first.
+func archivedError(id int) error {
+	return newAPIError(http.StatusConflict, "book_archived", "id", id)
+}
+
+// archivedCount returns the number of archived books.
+func archivedCount() int {
+	archivedMu.Lock()
+	defer archivedMu.Unlock()
+
+	return len(archivedIDs)
+}
+
+// readArchivedBook reads an archived book from disk, failing with
+// ErrNotFound if it is not archived.
+func readArchivedBook(id int) (Book, error) {
+	archivedMu.Lock()
+	defer archivedMu.Unlock()
+
+	return readArchivedBookLocked(id)
+}
+
+// readArchivedBookLocked is readArchivedBook for callers holding archivedMu.
+func readArchivedBookLocked(id int) (Book, error) {
+	if _, found := archivedIDs[id]; !found {
+		return Book{}, fmt.Errorf("book %d: %w", id, ErrNotFound)
+	}
+	path := archivedBookPath(id)
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return Book{}, fmt.Errorf("%w: archived book %s: %w", ErrUnavailable, path, err)
+	}
+	var book Book
+	if err := json.Unmarshal(data, &book); err != nil {
+		return Book{}, fmt.Errorf("archived book %s: %w", path, err)
+	}
+	return book, nil
+}
+
+// archivedBooks reads every archived book from disk, for listings with
+// ?include_archived=true.
+func archivedBooks() ([]Book, error) {
+	archivedMu.Lock()
+	defer archivedMu.Unlock()
+
+	list := make([]Book, 0, len(archivedIDs))
+	for id := range archivedIDs {
+		book, err := readArchivedBookLocked(id)
+		if err != nil {
+			return nil, err
+		}
+		list = append(list, book)
+	}
+	return list, nil
+}
+
+// listWithArchived returns the books selected by opts among the stored and
+// the archived books.
+func listWithArchived(opts ListOptions) ([]Book, error) {
+	archived, err := archivedBooks()
+	if err != nil {
+		return nil, err
+	}
+	list := append(store.List(ListOptions{}), archived...)
+	sortBooksByID(list)
+	return opts.apply(list), nil
+}
+
+// archivedBookPath returns the file an archived book is stored in.
+func archivedBookPath(id int) string {
+	return filepath.Join(coldStorageDir, strconv.Itoa(id)+".json")
+}
+
+// writeArchivedBook atomically writes a book to cold storage. Callers must
+// hold archivedMu.
+func writeArchivedBook(book Book) error {
+	data, err := json.Marshal(book)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(coldStorageDir, 0o755); err != nil {
+		return fmt.Errorf("%w: %w", ErrUnavailable, err)
+	}
+	tmp, err := os.CreateTemp(coldStorageDir, "archive-*")
+	if err != nil {
+		return fmt.Errorf("%w: %w", ErrUnavailable, err)
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return fmt.Errorf("%w: %w", ErrUnavailable, err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("%w: %w", ErrUnavailable, err)
+	}
+	if err := os.Rename(tmp.Name(), archivedBookPath(book.ID)); err != nil {
+		return fmt.Errorf("%w: %w", ErrUnavailable, err)
+	}
+	return nil
+}
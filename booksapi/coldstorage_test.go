@@ -0,0 +1,151 @@
+package booksapi
+
+import (
+	"net/http"
+	"os"
+	"slices"
+	"testing"
+)
+
+// listedIDs returns the IDs of the books GET path lists, and whether each
+// is marked archived.
+func listedIDs(t *testing.T, h http.Handler, path string) ([]int, map[int]bool) {
+	t.Helper()
+	invalidateResponseCache()
+	rec := serve(t, h, http.MethodGet, path, nil)
+	wantCode(t, rec, http.StatusOK)
+	var ids []int
+	archived := make(map[int]bool)
+	for _, book := range decode[[]bookResponse](t, rec) {
+		ids = append(ids, book.ID)
+		archived[book.ID] = book.Archived
+	}
+	return ids, archived
+}
+
+// TestArchiveBook archives a book, checks that it is left out of listings
+// but can still be read and not changed, and unarchives it.
+func TestArchiveBook(t *testing.T) {
+	h := newTestServer(t)
+	for _, title := range []string{"Dune", "Emma", "Hyperion"} {
+		mustCreateBook(t, h, map[string]any{"title": title, "price": 9.99})
+	}
+	want := decode[Book](t, serve(t, h, http.MethodGet, "/books/2", nil))
+
+	rec := serve(t, h, http.MethodPost, "/books/2/archive", nil)
+	wantCode(t, rec, http.StatusOK)
+	if got := decode[bookResponse](t, rec); !got.Archived || got.Title != "Emma" {
+		t.Errorf("archived %+v, want Emma marked archived", got)
+	}
+	if _, err := os.Stat(archivedBookPath(2)); err != nil {
+		t.Errorf("no cold storage file: %v", err)
+	}
+	if _, found := store.Get(2); found {
+		t.Error("archived book still in the store")
+	}
+
+	if ids, _ := listedIDs(t, h, "/books"); !slices.Equal(ids, []int{1, 3}) {
+		t.Errorf("listed %v, want the hot books 1 and 3", ids)
+	}
+	ids, archived := listedIDs(t, h, "/books?include_archived=true")
+	if !slices.Equal(ids, []int{1, 2, 3}) || !archived[2] || archived[1] {
+		t.Errorf("listed %v, archived %v; want 1, 2, 3 with 2 archived", ids, archived)
+	}
+
+	rec = serve(t, h, http.MethodGet, "/books/2", nil)
+	wantCode(t, rec, http.StatusOK)
+	if got := decode[bookResponse](t, rec); !got.Archived || got.Book.Title != want.Title || got.Book.Price != want.Price || !got.Book.CreatedAt.Equal(*want.CreatedAt) {
+		t.Errorf("read %+v from cold storage, want %+v marked archived", got, want)
+	}
+	stats := decode[bookStats](t, serve(t, h, http.MethodGet, "/books/stats", nil))
+	if stats.Count != 2 || stats.Archived != 1 {
+		t.Errorf("stats count %d, archived %d; want 2 and 1", stats.Count, stats.Archived)
+	}
+
+	for _, req := range []struct {
+		method, path string
+		body         any
+		header       []string
+	}{
+		{http.MethodPut, "/books/2", map[string]any{"title": "Emma", "price": 5}, nil},
+		{http.MethodPatch, "/books/2", map[string]any{"price": 5}, []string{"Content-Type", mergePatchType}},
+		{http.MethodDelete, "/books/2", nil, nil},
+		{http.MethodPost, "/books/2/archive", nil, nil},
+	} {
+		rec := serve(t, h, req.method, req.path, req.body, req.header...)
+		wantCode(t, rec, http.StatusConflict)
+		if got := decode[errorBody](t, rec).Error; got.Code != "book_archived" || got.Params["id"] != "2" {
+			t.Errorf("%s %s: error %s %v, want book_archived", req.method, req.path, got.Code, got.Params)
+		}
+	}
+
+	rec = serve(t, h, http.MethodPost, "/books/2/unarchive", nil)
+	wantCode(t, rec, http.StatusOK)
+	if got := decode[bookResponse](t, rec); got.Archived {
+		t.Error("unarchived book still marked archived")
+	}
+	if _, err := os.Stat(archivedBookPath(2)); !os.IsNotExist(err) {
+		t.Errorf("cold storage file kept: %v", err)
+	}
+	if ids, _ := listedIDs(t, h, "/books"); !slices.Equal(ids, []int{1, 2, 3}) {
+		t.Errorf("listed %v, want 1, 2, 3", ids)
+	}
+	stats = decode[bookStats](t, serve(t, h, http.MethodGet, "/books/stats", nil))
+	if stats.Count != 3 || stats.Archived != 0 {
+		t.Errorf("stats count %d, archived %d; want 3 and 0", stats.Count, stats.Archived)
+	}
+	wantCode(t, serve(t, h, http.MethodPut, "/books/2", map[string]any{"title": "Emma", "price": 5}), http.StatusOK)
+
+	rec = serve(t, h, http.MethodPost, "/books/2/unarchive", nil)
+	wantCode(t, rec, http.StatusConflict)
+	if got := errorCode(t, rec); got != "book_not_archived" {
+		t.Errorf("error code %q, want book_not_archived", got)
+	}
+	wantCode(t, serve(t, h, http.MethodPost, "/books/99/archive", nil), http.StatusNotFound)
+	wantCode(t, serve(t, h, http.MethodPost, "/books/99/unarchive", nil), http.StatusNotFound)
+	wantCode(t, serve(t, h, http.MethodGet, "/books/1/archive", nil), http.StatusMethodNotAllowed)
+}
+
+// TestOpenColdStorage checks that the books archived before a restart are
+// found again, keep their IDs, and that a book left in both places by an
+// interrupted archive stays hot.
+func TestOpenColdStorage(t *testing.T) {
+	h := newTestServer(t)
+	for _, title := range []string{"Dune", "Emma", "Hyperion"} {
+		mustCreateBook(t, h, map[string]any{"title": title})
+	}
+	wantCode(t, serve(t, h, http.MethodPost, "/books/2/archive", nil), http.StatusOK)
+	dune, _ := store.Get(1)
+	hyperion, _ := store.Get(3)
+	archivedMu.Lock()
+	if err := writeArchivedBook(hyperion); err != nil {
+		t.Fatal(err)
+	}
+	archivedMu.Unlock()
+
+	// Restart with the store as a journal would restore it.
+	restarted := newMemoryStore(newSequentialIDs())
+	for _, book := range []Book{dune, hyperion} {
+		if err := restarted.Put(book); err != nil {
+			t.Fatal(err)
+		}
+	}
+	store = restarted
+	archivedIDs = make(map[int]struct{})
+	if err := openColdStorage(); err != nil {
+		t.Fatal(err)
+	}
+
+	if !isArchived(2) || isArchived(3) {
+		t.Errorf("archived %v, want only book 2", archivedIDs)
+	}
+	if _, err := os.Stat(archivedBookPath(3)); !os.IsNotExist(err) {
+		t.Errorf("cold storage copy of a hot book kept: %v", err)
+	}
+	if got := decode[Book](t, serve(t, h, http.MethodGet, "/books/2", nil)); got.Title != "Emma" {
+		t.Errorf("read %+v, want Emma", got)
+	}
+	if got := mustCreateBook(t, h, map[string]any{"title": "Persuasion"}); got.ID != 4 {
+		t.Errorf("new book got ID %d, want 4, past the archived one", got.ID)
+	}
+}
@@ -0,0 +1,246 @@
+package booksapi
+
+import (
+	"bytes"
+	"cmp"
+	"sort"
+	"strings"
+	"sync"
+	"unicode"
+
+	"golang.org/x/text/cases"
+	"golang.org/x/text/collate"
+	"golang.org/x/text/language"
+	"golang.org/x/text/unicode/norm"
+)
+
+// collationLocale is the BCP 47 tag whose collation rules order titles and
+// authors. It is configured by a flag in Main; "und" uses the root
+// collation of the Unicode Collation Algorithm.
+var collationLocale = "und"
+
+// collator orders titles and authors ignoring case, so "the hobbit" sorts
+// with "The Hobbit", while accented letters sort next to their base letter.
+// A Collator is not safe for concurrent use, so it is guarded by collatorMu.
+var (
+	collatorMu sync.Mutex
+	collator   = collate.New(language.Und, collate.IgnoreCase)
+)
+
+// setCollationLocale replaces the collator with one for the given tag.
+func setCollationLocale(tag string) error {
+	parsed, err := language.Parse(tag)
+	if err != nil {
+		return err
+	}
+	collatorMu.Lock()
+	collator = collate.New(parsed, collate.IgnoreCase)
+	collatorMu.Unlock()
+	return nil
+}
+
+// sortBooks stably orders books by the given sort keys, each of which is
+// "id", "title", "author", or "price", ascending, or any of them prefixed
+// with "-", descending. Ties left by every key are broken by ascending ID,
+// so the order is total.
+func sortBooks(list []Book, fields []string) {
+	if len(fields) == 0 {
+		return
+	}
+
+	// Sort keys are computed once per book rather than on every comparison.
+	keys := make([][][]byte, len(list))
+	var buf collate.Buffer
+	collatorMu.Lock()
+	for i, book := range list {
+		keys[i] = make([][]byte, len(fields))
+		for j, field := range fields {
+			switch name, _ := sortField(field); name {
+			case "title":
+				keys[i][j] = collator.KeyFromString(&buf, book.Title)
+			case "author":
+				keys[i][j] = collator.KeyFromString(&buf, book.Author)
+			}
+		}
+	}
+	collatorMu.Unlock()
+
+	sort.Sort(booksByKeys{list, keys, fields})
+}
+
+// sortField splits a sort key into the field it orders by and whether the
+// order is descending.
+func sortField(key string) (field string, desc bool) {
+	return strings.CutPrefix(key, "-")
+}
+
+// compareField compares a and b by a field that is not collated: "id" or
+// "price". Others compare equal.
+func compareField(a, b Book, field string) int {
+	switch field {
+	case "id":
+		return cmp.Compare(a.ID, b.ID)
+	case "price":
+		return cmp.Compare(a.Price, b.Price)
+	}
+	return 0
+}
+
+// booksByKeys sorts books by precomputed collation keys.
+type booksByKeys struct {
+	list   []Book
+	keys   [][][]byte
+	fields []string
+}
+
+func (s booksByKeys) Len() int { return len(s.list) }
+
+func (s booksByKeys) Swap(i, j int) {
+	s.list[i], s.list[j] = s.list[j], s.list[i]
+	s.keys[i], s.keys[j] = s.keys[j], s.keys[i]
+}
+
+func (s booksByKeys) Less(i, j int) bool {
+	for k, key := range s.fields {
+		field, desc := sortField(key)
+		c := compareField(s.list[i], s.list[j], field)
+		if field == "title" || field == "author" {
+			c = bytes.Compare(s.keys[i][k], s.keys[j][k])
+		}
+		if c != 0 {
+			return c < 0 != desc
+		}
+	}
+	return s.list[i].ID < s.list[j].ID
+}
+
+// otherIndexGroup is the index group of titles and authors that do not
+// start with a letter.
+const otherIndexGroup = "#"
+
+// indexGroup returns the group s is listed under in an alphabetical index:
+// its first letter, in upper case and without accents, so "Émile" is under
+// "E", or otherIndexGroup if its first letter or digit is a digit or it has
+// neither. Leading spaces and punctuation are skipped. Letters are taken
+// from the canonical decomposition of s, so Hangul syllables are grouped
+// by their initial consonant and kana with their voiceless form.
+func indexGroup(s string) string {
+	for _, r := range norm.NFD.String(s) {
+		switch {
+		case unicode.IsLetter(r):
+			return string(unicode.ToUpper(r))
+		case unicode.IsDigit(r):
+			return otherIndexGroup
+		}
+	}
+	return otherIndexGroup
+}
+
+// sortIndexGroups orders index groups as the collator orders their
+// letters, with otherIndexGroup last.
+func sortIndexGroups(groups []string) {
+	collatorMu.Lock()
+	defer collatorMu.Unlock()
+	sort.SliceStable(groups, func(i, j int) bool {
+		if groups[i] == otherIndexGroup || groups[j] == otherIndexGroup {
+			return groups[j] == otherIndexGroup && groups[i] != otherIndexGroup
+		}
+		return collator.CompareString(groups[i], groups[j]) < 0
+	})
+}
+
+// Matching modes of the string filters, chosen with ?match=.
+const (
+	matchExact    = "exact"
+	matchPrefix   = "prefix"
+	matchContains = "contains"
+)
+
+// matchModes lists the values of the match query parameter.
+var matchModes = []string{matchExact, matchPrefix, matchContains}
+
+// textMatcher compares field values against a filter value in one of the
+// matching modes. Both sides are compared after Unicode case folding, so
+// "STRASSE" matches "Straße".
+type textMatcher struct {
+	mode  string
+	fold  cases.Caser
+	value string
+}
+
+// newTextMatcher returns a matcher for the filter value in the given mode,
+// or nil if value is empty, so that the filter keeps every book.
+func newTextMatcher(mode, value string) *textMatcher {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return nil
+	}
+	m := &textMatcher{mode: mode, fold: cases.Fold()}
+	m.value = m.fold.String(value)
+	return m
+}
+
+// match reports whether s satisfies the filter. A nil matcher matches
+// everything.
+func (m *textMatcher) match(s string) bool {
+	if m == nil {
+		return true
+	}
+	s = m.fold.String(s)
+	switch m.mode {
+	case matchPrefix:
+		return strings.HasPrefix(s, m.value)
+	case matchContains:
+		return strings.Contains(s, m.value)
+	default:
+		return s == m.value
+	}
+}
+
+// textMatchers are the alternative filters of a field: a value satisfies
+// them if it satisfies any. No filters match everything.
+type textMatchers []*textMatcher
+
+// newTextMatchers returns the matchers of the filter values in the given
+// mode, leaving out empty ones.
+func newTextMatchers(mode string, values []string) textMatchers {
+	var ms textMatchers
+	for _, value := range values {
+		if m := newTextMatcher(mode, value); m != nil {
+			ms = append(ms, m)
+		}
+	}
+	return ms
+}
+
+// match reports whether s satisfies any of the filters.
+func (ms textMatchers) match(s string) bool {
+	if len(ms) == 0 {
+		return true
+	}
+	for _, m := range ms {
+		if m.match(s) {
+			return true
+		}
+	}
+	return false
+}
+
+// filterByText keeps only the books whose author satisfies any of the
+// author filters and whose title satisfies any of the title filters, in
+// the given matching mode. No filters keep every book.
+func filterByText(list []Book, mode string, authors, titles []string) []Book {
+	authorMatch := newTextMatchers(mode, authors)
+	titleMatch := newTextMatchers(mode, titles)
+	if len(authorMatch) == 0 && len(titleMatch) == 0 {
+		return list
+	}
+
+	filtered := list[:0]
+	for _, book := range list {
+		if authorMatch.match(book.Author) && titleMatch.match(book.Title) {
+			filtered = append(filtered, book)
+		}
+	}
+	return filtered
+}
@@ -0,0 +1,177 @@
+package booksapi
+
+import (
+	"net/http"
+	"slices"
+	"testing"
+)
+
+// titles returns the titles of books, in order.
+func titles(books []Book) []string {
+	var list []string
+	for _, book := range books {
+		list = append(list, book.Title)
+	}
+	return list
+}
+
+// booksTitled returns books with the given titles, numbered from 1.
+func booksTitled(titles ...string) []Book {
+	var books []Book
+	for i, title := range titles {
+		books = append(books, Book{ID: i + 1, Title: title})
+	}
+	return books
+}
+
+// TestSortBooksCollation checks the order of accented, mixed-case, and
+// non-Latin titles under the root collation and a locale's own.
+func TestSortBooksCollation(t *testing.T) {
+	t.Cleanup(func() { setCollationLocale(collationLocale) })
+	input := []string{"Zorro", "the hobbit", "Émile", "Ødipus", "Война и мир", "The Hobbit", "emma", "Ärger", "Σίσυφος", "東京物語", "Oliver", "Ábel", "abel"}
+	tests := []struct {
+		locale string
+		fields []string
+		want   []string
+	}{
+		{
+			locale: "und", fields: []string{"title"},
+			want: []string{"abel", "Ábel", "Ärger", "Émile", "emma", "Ødipus", "Oliver", "the hobbit", "The Hobbit", "Zorro", "Σίσυφος", "Война и мир", "東京物語"},
+		},
+		{
+			locale: "und", fields: []string{"-title"},
+			want: []string{"東京物語", "Война и мир", "Σίσυφος", "Zorro", "the hobbit", "The Hobbit", "Oliver", "Ødipus", "emma", "Émile", "Ärger", "Ábel", "abel"},
+		},
+		{
+			// Swedish sorts Ä and Ø after Z.
+			locale: "sv", fields: []string{"title"},
+			want: []string{"abel", "Ábel", "Émile", "emma", "Oliver", "the hobbit", "The Hobbit", "Zorro", "Ärger", "Ødipus", "Σίσυφος", "Война и мир", "東京物語"},
+		},
+	}
+	for _, tt := range tests {
+		if err := setCollationLocale(tt.locale); err != nil {
+			t.Fatal(err)
+		}
+		books := booksTitled(input...)
+		sortBooks(books, tt.fields)
+		if got := titles(books); !slices.Equal(got, tt.want) {
+			t.Errorf("%s %v:\n got %q\nwant %q", tt.locale, tt.fields, got, tt.want)
+		}
+	}
+	if err := setCollationLocale("not a tag"); err == nil {
+		t.Error("setCollationLocale accepted an invalid tag")
+	}
+}
+
+// TestSortBooksTies checks that books equal by every sort key are ordered
+// by ID, and that later keys break ties of earlier ones.
+func TestSortBooksTies(t *testing.T) {
+	books := []Book{
+		{ID: 4, Title: "Emma", Author: "Jane Austen", Price: 5},
+		{ID: 2, Title: "EMMA", Author: "Jane Austen", Price: 3},
+		{ID: 3, Title: "Dune", Author: "Frank Herbert", Price: 5},
+		{ID: 1, Title: "emma", Author: "jane austen", Price: 5},
+	}
+	sortBooks(books, []string{"author", "-price"})
+	var ids []int
+	for _, book := range books {
+		ids = append(ids, book.ID)
+	}
+	if want := []int{3, 1, 4, 2}; !slices.Equal(ids, want) {
+		t.Errorf("sorted by author, -price: %v, want %v", ids, want)
+	}
+}
+
+// TestFilterByText checks the matching modes of the author and title
+// filters, with Unicode case folding.
+func TestFilterByText(t *testing.T) {
+	books := []Book{
+		{ID: 1, Title: "Die Straße", Author: "Ernst Weiß"},
+		{ID: 2, Title: "Σίσυφος", Author: "Νίκος Καζαντζάκης"},
+		{ID: 3, Title: "The Hobbit", Author: "J. R. R. Tolkien"},
+		{ID: 4, Title: "The Hobbit Companion", Author: "David Day"},
+	}
+	tests := []struct {
+		name    string
+		mode    string
+		authors []string
+		titles  []string
+		want    []int
+	}{
+		{"none", matchExact, nil, nil, []int{1, 2, 3, 4}},
+		{"blank", matchExact, []string{" "}, []string{""}, []int{1, 2, 3, 4}},
+		{"exact, case folded", matchExact, nil, []string{"the hobbit"}, []int{3}},
+		{"exact, full folding", matchExact, nil, []string{"DIE STRASSE"}, []int{1}},
+		{"exact, final sigma", matchExact, nil, []string{"ΣΊΣΥΦΟΣ"}, []int{2}},
+		{"exact, trimmed", matchExact, []string{"  ernst weiss "}, nil, []int{1}},
+		{"prefix", matchPrefix, nil, []string{"THE HOB"}, []int{3, 4}},
+		{"contains", matchContains, nil, []string{"companion"}, []int{4}},
+		{"prefix, not at the start", matchPrefix, nil, []string{"companion"}, nil},
+		{"any of several", matchContains, []string{"tolkien", "καζαντζάκης"}, nil, []int{2, 3}},
+		{"author and title", matchContains, []string{"day"}, []string{"hobbit"}, []int{4}},
+		{"accents are not folded", matchExact, nil, []string{"Σισυφος"}, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var ids []int
+			for _, book := range filterByText(slices.Clone(books), tt.mode, tt.authors, tt.titles) {
+				ids = append(ids, book.ID)
+			}
+			if !slices.Equal(ids, tt.want) {
+				t.Errorf("filterByText(%s, %q, %q) = %v, want %v", tt.mode, tt.authors, tt.titles, ids, tt.want)
+			}
+		})
+	}
+}
+
+// TestMatchModes checks the match modes of the author and title filters
+// of GET /books, and the modes it refuses.
+func TestMatchModes(t *testing.T) {
+	h := newTestServer(t)
+	for _, book := range []map[string]any{
+		{"title": "It", "author": "Stephen King"},
+		{"title": "The Stand", "author": "STEPHEN KING"},
+		{"title": "Kingdom Come", "author": "Mark Waid"},
+		{"title": "Die Straße", "author": "Ernst Weiß"},
+		{"title": "Émile", "author": "Jean-Jacques Rousseau"},
+	} {
+		mustCreateBook(t, h, book)
+	}
+
+	tests := []struct {
+		query string
+		want  []int
+	}{
+		{"author=stephen%20king", []int{1, 2}},
+		{"author=king", nil},
+		{"author=king&match=exact", nil},
+		{"author=king&match=contains", []int{1, 2}},
+		{"author=STEPHEN&match=prefix", []int{1, 2}},
+		{"title=king&match=prefix", []int{3}},
+		{"title=king&match=contains", []int{3}},
+		{"title=the&match=contains&author=king", []int{2}},
+		{"title=STRASSE&match=contains", []int{4}},
+		{"title=%C3%A9mile", []int{5}},
+		{"title=emile", nil},
+		{"match=prefix", []int{1, 2, 3, 4, 5}},
+	}
+	for _, tt := range tests {
+		rec := serve(t, h, http.MethodGet, "/books?"+tt.query, nil)
+		wantCode(t, rec, http.StatusOK)
+		var ids []int
+		for _, book := range decode[[]Book](t, rec) {
+			ids = append(ids, book.ID)
+		}
+		if !slices.Equal(ids, tt.want) {
+			t.Errorf("GET /books?%s: %v, want %v", tt.query, ids, tt.want)
+		}
+	}
+
+	for _, query := range []string{"match=fuzzy", "match=prefix&match=contains", "match=prefix,contains"} {
+		rec := serve(t, h, http.MethodGet, "/books?author=king&"+query, nil)
+		wantCode(t, rec, http.StatusBadRequest)
+		if got := decode[errorBody](t, rec).Error; got.Params["name"] != "match" {
+			t.Errorf("%s: error %+v, want one naming match", query, got)
+		}
+	}
+}
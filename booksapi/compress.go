@@ -0,0 +1,180 @@
+package booksapi
+
+import (
+	"compress/gzip"
+	"maps"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// minCompressBytes is the smallest body compressResponses compresses when
+// its length is known; smaller bodies would barely shrink, or even grow,
+// for the cost of compressing them.
+const minCompressBytes = 1024
+
+// gzipWriterPool recycles the gzip writers of compressed responses.
+var gzipWriterPool = sync.Pool{
+	New: func() any { return gzip.NewWriter(nil) },
+}
+
+// compressResponses gzip-compresses the responses of clients accepting it,
+// through a compressWriter. Handlers write as they would without it:
+// writeJSON sets the Content-Length of the body it built, and stays in
+// charge of it unless the body is compressed.
+func compressResponses(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		cw := &compressWriter{ResponseWriter: w, r: r, header: w.Header().Clone()}
+		next.ServeHTTP(cw, r)
+		cw.finish()
+	})
+}
+
+// compressWriter is the response writer every handler writes through. It
+// holds the handler's headers until the status is written, then decides
+// how the body is sent:
+//
+//   - Responses with 204 or 304 send no body, no Content-Encoding, and no
+//     Content-Length.
+//   - Bodies the client accepts gzip for, of a compressible type and not
+//     known to be small, are compressed. Their length is then only known
+//     once compressed, so the Content-Length the handler set is dropped
+//     rather than sent wrong.
+//   - Responses to HEAD send the headers the same GET would, including
+//     its Content-Encoding, and discard the body the handler writes.
+//   - Other bodies, including those the handler encoded itself, such as
+//     GET /books/export, pass through with the handler's headers.
+//
+// Vary always names Accept-Encoding, since the encoding depends on it.
+type compressWriter struct {
+	http.ResponseWriter
+	r           *http.Request
+	header      http.Header
+	wroteHeader bool
+	noBody      bool
+	zw          *gzip.Writer
+}
+
+func (cw *compressWriter) Header() http.Header { return cw.header }
+
+// Unwrap returns the wrapped writer, for http.ResponseController.
+func (cw *compressWriter) Unwrap() http.ResponseWriter { return cw.ResponseWriter }
+
+func (cw *compressWriter) WriteHeader(status int) {
+	cw.writeHeader(status, true)
+}
+
+// writeHeader sends the status and the headers, adjusted for the way the
+// body is sent; hasBody is false when the handler wrote no body at all.
+func (cw *compressWriter) writeHeader(status int, hasBody bool) {
+	if cw.wroteHeader {
+		return
+	}
+	h := cw.header
+	if status < http.StatusOK {
+		// Informational responses come before the final one.
+		cw.sendHeader(status)
+		return
+	}
+	cw.wroteHeader = true
+	addVary(h, "Accept-Encoding")
+
+	switch {
+	case status == http.StatusNoContent || status == http.StatusNotModified:
+		cw.noBody = true
+		h.Del("Content-Length")
+		h.Del("Content-Encoding")
+	case hasBody && cw.compressible():
+		h.Del("Content-Length")
+		h.Set("Content-Encoding", "gzip")
+		if cw.r.Method == http.MethodHead {
+			cw.noBody = true
+			break
+		}
+		cw.zw = gzipWriterPool.Get().(*gzip.Writer)
+		cw.zw.Reset(cw.ResponseWriter)
+	case cw.r.Method == http.MethodHead:
+		cw.noBody = true
+	}
+	cw.sendHeader(status)
+}
+
+// sendHeader replaces the headers of the wrapped writer with the
+// handler's and writes the status.
+func (cw *compressWriter) sendHeader(status int) {
+	dst := cw.ResponseWriter.Header()
+	clear(dst)
+	maps.Copy(dst, cw.header)
+	cw.ResponseWriter.WriteHeader(status)
+}
+
+// compressible reports whether the body is to be compressed.
+func (cw *compressWriter) compressible() bool {
+	h := cw.header
+	if h.Get("Content-Encoding") != "" || !acceptsGzip(cw.r) {
+		return false
+	}
+	if n, err := strconv.Atoi(h.Get("Content-Length")); err == nil && n < minCompressBytes {
+		return false
+	}
+	mediaType, _, _ := mime.ParseMediaType(h.Get("Content-Type"))
+	return strings.HasPrefix(mediaType, "text/") || mediaType == "application/json" ||
+		strings.HasSuffix(mediaType, "+json") || strings.HasSuffix(mediaType, "+xml")
+}
+
+func (cw *compressWriter) Write(p []byte) (int, error) {
+	if !cw.wroteHeader {
+		if cw.header.Get("Content-Type") == "" {
+			cw.header.Set("Content-Type", http.DetectContentType(p))
+		}
+		cw.writeHeader(http.StatusOK, len(p) > 0)
+	}
+	switch {
+	case cw.noBody:
+		return len(p), nil
+	case cw.zw != nil:
+		return cw.zw.Write(p)
+	}
+	return cw.ResponseWriter.Write(p)
+}
+
+// Flush sends what has been written so far, compressed if the body is.
+func (cw *compressWriter) Flush() {
+	if !cw.wroteHeader {
+		cw.writeHeader(http.StatusOK, true)
+	}
+	if cw.zw != nil {
+		cw.zw.Flush()
+	}
+	if f, ok := cw.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// finish sends the headers of a handler that wrote nothing, and ends the
+// compressed body.
+func (cw *compressWriter) finish() {
+	if !cw.wroteHeader {
+		cw.writeHeader(http.StatusOK, false)
+	}
+	if cw.zw != nil {
+		cw.zw.Close()
+		cw.zw.Reset(nil)
+		gzipWriterPool.Put(cw.zw)
+		cw.zw = nil
+	}
+}
+
+// addVary adds name to the Vary header unless it lists it already.
+func addVary(h http.Header, name string) {
+	for _, value := range h.Values("Vary") {
+		for _, field := range strings.Split(value, ",") {
+			if field = strings.TrimSpace(field); field == "*" || strings.EqualFold(field, name) {
+				return
+			}
+		}
+	}
+	h.Add("Vary", name)
+}
@@ -0,0 +1,183 @@
+package booksapi
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/http/httputil"
+	"net/textproto"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// strictResponse is a response read by strictGet.
+type strictResponse struct {
+	status int
+	header http.Header
+	body   []byte // decompressed
+}
+
+// strictGet sends a request over a connection of its own and reads the
+// raw response, failing the test on any framing error a lenient client
+// would paper over: a Content-Length other than the length of the body, a
+// Content-Length with chunked encoding, a body where none may be, or a
+// Content-Encoding the body is not in.
+func strictGet(t *testing.T, addr, method, path string, header ...string) strictResponse {
+	t.Helper()
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	req := fmt.Sprintf("%s %s HTTP/1.1\r\nHost: %s\r\nConnection: close\r\n", method, path, addr)
+	for i := 0; i+1 < len(header); i += 2 {
+		req += header[i] + ": " + header[i+1] + "\r\n"
+	}
+	if _, err := io.WriteString(conn, req+"\r\n"); err != nil {
+		t.Fatal(err)
+	}
+	raw, err := io.ReadAll(conn)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	end := bytes.Index(raw, []byte("\r\n\r\n"))
+	if end < 0 {
+		t.Fatalf("%s %s: no end of headers in %q", method, path, raw)
+	}
+	tp := textproto.NewReader(bufio.NewReader(bytes.NewReader(raw[:end+4])))
+	statusLine, err := tp.ReadLine()
+	if err != nil {
+		t.Fatal(err)
+	}
+	mime, err := tp.ReadMIMEHeader()
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp := strictResponse{header: http.Header(mime)}
+	if _, err := fmt.Sscanf(statusLine, "HTTP/1.1 %d", &resp.status); err != nil {
+		t.Fatalf("%s %s: status line %q", method, path, statusLine)
+	}
+	body := raw[end+4:]
+
+	length, chunked := resp.header.Get("Content-Length"), resp.header.Get("Transfer-Encoding") == "chunked"
+	switch {
+	case length != "" && chunked:
+		t.Errorf("%s %s: Content-Length %s with chunked encoding", method, path, length)
+	case method == http.MethodHead || resp.status == http.StatusNotModified:
+		if len(body) > 0 {
+			t.Errorf("%s %s: %d: %d bytes of body", method, path, resp.status, len(body))
+		}
+		return resp
+	case chunked:
+		if body, err = io.ReadAll(httputil.NewChunkedReader(bytes.NewReader(body))); err != nil {
+			t.Fatalf("%s %s: chunked body: %v", method, path, err)
+		}
+	case length != "":
+		if n, err := strconv.Atoi(length); err != nil || n != len(body) {
+			t.Errorf("%s %s: Content-Length %s for a body of %d bytes", method, path, length, len(body))
+		}
+	}
+	if resp.header.Get("Content-Encoding") == "gzip" {
+		zr, err := gzip.NewReader(bytes.NewReader(body))
+		if err != nil {
+			t.Fatalf("%s %s: gzip body: %v", method, path, err)
+		}
+		if body, err = io.ReadAll(zr); err != nil {
+			t.Fatalf("%s %s: gzip body: %v", method, path, err)
+		}
+	}
+	resp.body = body
+	return resp
+}
+
+// TestContentLength checks the framing of the list and item endpoints
+// across compression, GET and HEAD, and 200, 304, and 404 responses.
+func TestContentLength(t *testing.T) {
+	h := newTestServer(t)
+	mustCreateBook(t, h, map[string]any{"title": "Dune", "description": strings.Repeat("Sand. ", 300)})
+	for i := range 9 {
+		mustCreateBook(t, h, map[string]any{"title": fmt.Sprint("Book ", i)})
+	}
+	server := httptest.NewServer(h)
+	defer server.Close()
+	addr := server.Listener.Addr().String()
+
+	endpoints := []struct {
+		name          string
+		path, missing string
+	}{
+		{"list", "/books", "/filters/999/books"},
+		{"item", "/books/1", "/books/999"},
+	}
+	for _, ep := range endpoints {
+		for _, gzipOn := range []bool{false, true} {
+			var accept []string
+			if gzipOn {
+				accept = []string{"Accept-Encoding", "gzip"}
+			}
+			etag := strictGet(t, addr, http.MethodGet, ep.path, accept...).header.Get("ETag")
+			if etag == "" {
+				t.Fatalf("GET %s: no ETag", ep.path)
+			}
+			statuses := []struct {
+				status int
+				path   string
+				header []string
+			}{
+				{http.StatusOK, ep.path, accept},
+				{http.StatusNotModified, ep.path, append([]string{"If-None-Match", etag}, accept...)},
+				{http.StatusNotFound, ep.missing, accept},
+			}
+			for _, st := range statuses {
+				t.Run(fmt.Sprintf("%s/gzip=%v/%d", ep.name, gzipOn, st.status), func(t *testing.T) {
+					get := strictGet(t, addr, http.MethodGet, st.path, st.header...)
+					head := strictGet(t, addr, http.MethodHead, st.path, st.header...)
+					for _, resp := range []strictResponse{get, head} {
+						if resp.status != st.status {
+							t.Fatalf("status %d, want %d", resp.status, st.status)
+						}
+						if !strings.Contains(strings.Join(resp.header.Values("Vary"), ","), "Accept-Encoding") {
+							t.Errorf("Vary %q lacks Accept-Encoding", resp.header.Values("Vary"))
+						}
+					}
+					for _, name := range []string{"Content-Encoding", "Content-Type"} {
+						if g, h := get.header.Get(name), head.header.Get(name); g != h {
+							t.Errorf("%s %q for GET, %q for HEAD", name, g, h)
+						}
+					}
+					// The length of a compressed body is only known once
+					// compressed, which HEAD is not.
+					if g, h := get.header.Get("Content-Length"), head.header.Get("Content-Length"); h != "" && g != h {
+						t.Errorf("Content-Length %q for GET, %q for HEAD", g, h)
+					}
+
+					encoding := get.header.Get("Content-Encoding")
+					switch {
+					case st.status == http.StatusNotModified:
+						if encoding != "" || get.header.Get("Content-Length") != "" {
+							t.Errorf("304 with Content-Encoding %q and Content-Length %q", encoding, get.header.Get("Content-Length"))
+						}
+						return
+					case st.status == http.StatusOK && gzipOn:
+						if encoding != "gzip" {
+							t.Errorf("Content-Encoding %q, want gzip", encoding)
+						}
+					case encoding != "":
+						t.Errorf("Content-Encoding %q of a body that is not compressed", encoding)
+					}
+					if !json.Valid(get.body) {
+						t.Errorf("GET body is not JSON: %q", get.body)
+					}
+				})
+			}
+		}
+	}
+}
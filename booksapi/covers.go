@@ -0,0 +1,204 @@
+package booksapi
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Cover storage settings, configured by flags in Main.
+var (
+	coverDir            = "covers"
+	maxCoverBytes int64 = 2 << 20
+)
+
+// coverInfo describes a stored cover image.
+type coverInfo struct {
+	ContentType string
+	ETag        string
+	ModTime     time.Time
+}
+
+// Global variables to track stored covers. When both locks are needed, mu
+// must be acquired before coversMu.
+var (
+	covers   = make(map[int]coverInfo) // book ID -> cover
+	coversMu sync.Mutex
+)
+
+// coverHandler handles a book's cover image (GET, PUT, DELETE).
+func coverHandler(w http.ResponseWriter, r *http.Request, id int) {
+	if _, ok := checkQuery(w, r); !ok {
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		getCover(w, r, id)
+	case http.MethodPut:
+		putCover(w, r, id)
+	case http.MethodDelete:
+		deleteCover(w, r, id)
+	default:
+		writeError(w, r, http.StatusMethodNotAllowed, "method_not_allowed")
+	}
+}
+
+// getCover serves a book's cover image.
+func getCover(w http.ResponseWriter, r *http.Request, id int) {
+	coversMu.Lock()
+	info, found := covers[id]
+	coversMu.Unlock()
+	if !found {
+		writeError(w, r, http.StatusNotFound, "cover_not_found")
+		return
+	}
+
+	f, err := os.Open(coverPath(id))
+	if err != nil {
+		writeError(w, r, http.StatusNotFound, "cover_not_found")
+		return
+	}
+	defer f.Close()
+
+	w.Header().Set("Content-Type", info.ContentType)
+	w.Header().Set("ETag", info.ETag)
+	http.ServeContent(w, r, "", info.ModTime, f)
+}
+
+// putCover stores a PNG or JPEG cover image for a book. The image type is
+// detected from its leading bytes rather than the request's Content-Type.
+func putCover(w http.ResponseWriter, r *http.Request, id int) {
+	limit := bodyLimit(r, maxCoverBytes)
+	data, err := io.ReadAll(http.MaxBytesReader(w, r.Body, limit))
+	if err != nil {
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			writeError(w, r, http.StatusRequestEntityTooLarge, "cover_too_large", "max", limit)
+			return
+		}
+		writeError(w, r, http.StatusBadRequest, "invalid_request")
+		return
+	}
+
+	contentType := detectImageType(data)
+	if contentType == "" {
+		writeError(w, r, http.StatusUnsupportedMediaType, "cover_unsupported_type")
+		return
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	if _, err := findBook(id); err != nil {
+		writeAPIError(w, r, err)
+		return
+	}
+
+	if err := writeCoverFile(id, data); err != nil {
+		writeError(w, r, http.StatusInternalServerError, "cover_store_failed")
+		return
+	}
+
+	sum := sha256.Sum256(data)
+	coversMu.Lock()
+	covers[id] = coverInfo{
+		ContentType: contentType,
+		ETag:        `"` + hex.EncodeToString(sum[:]) + `"`,
+		ModTime:     time.Now(),
+	}
+	coversMu.Unlock()
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// deleteCover removes a book's cover image.
+func deleteCover(w http.ResponseWriter, r *http.Request, id int) {
+	coversMu.Lock()
+	defer coversMu.Unlock()
+
+	if _, found := covers[id]; !found {
+		writeError(w, r, http.StatusNotFound, "cover_not_found")
+		return
+	}
+
+	os.Remove(coverPath(id))
+	delete(covers, id)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// removeCover deletes the cover of a deleted book, if it has one.
+func removeCover(id int) {
+	coversMu.Lock()
+	defer coversMu.Unlock()
+
+	if _, found := covers[id]; found {
+		os.Remove(coverPath(id))
+		delete(covers, id)
+	}
+}
+
+// hasCover reports whether a book has a cover image.
+func hasCover(id int) bool {
+	coversMu.Lock()
+	defer coversMu.Unlock()
+
+	_, found := covers[id]
+	return found
+}
+
+// coverURL returns the URL a book's cover is served from.
+func coverURL(id int) string {
+	return apiPath("/books/" + strconv.Itoa(id) + "/cover")
+}
+
+// coverPath returns the file a book's cover is stored in.
+func coverPath(id int) string {
+	return filepath.Join(coverDir, strconv.Itoa(id))
+}
+
+// writeCoverFile atomically replaces the stored cover of a book.
+func writeCoverFile(id int, data []byte) error {
+	if err := os.MkdirAll(coverDir, 0o755); err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(coverDir, "upload-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), coverPath(id))
+}
+
+// Leading bytes identifying supported image formats.
+var (
+	pngMagic  = []byte("\x89PNG\r\n\x1a\n")
+	jpegMagic = []byte{0xFF, 0xD8, 0xFF}
+)
+
+// detectImageType returns the MIME type of a PNG or JPEG image, or "" if
+// data is neither.
+func detectImageType(data []byte) string {
+	switch {
+	case bytes.HasPrefix(data, pngMagic):
+		return "image/png"
+	case bytes.HasPrefix(data, jpegMagic):
+		return "image/jpeg"
+	}
+	return ""
+}
@@ -0,0 +1,116 @@
+package booksapi
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"slices"
+	"sort"
+	"strconv"
+)
+
+// nextCursorHeader carries the cursor of the next page of a listing.
+const nextCursorHeader = "Next-Cursor"
+
+// bookCursor marks a position in a listing of books: just after the book
+// with the given ID and sort keys, in the order the cursor was created for.
+// Unlike an offset it stays put when books before it are added or removed.
+type bookCursor struct {
+	Sort []string `json:"s,omitempty"`
+	Desc bool     `json:"d,omitempty"`
+	ID   int      `json:"i"`
+	Keys []string `json:"k,omitempty"` // the book's value of each sort field
+}
+
+// newBookCursor returns the cursor just after book in the order of opts.
+func newBookCursor(book Book, opts ListOptions) *bookCursor {
+	c := &bookCursor{Sort: opts.Sort, Desc: opts.Descending, ID: book.ID}
+	for _, field := range opts.Sort {
+		c.Keys = append(c.Keys, sortKeyValue(book, field))
+	}
+	return c
+}
+
+// encode returns the opaque form of the cursor sent to clients.
+func (c *bookCursor) encode() string {
+	data, _ := json.Marshal(c)
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+// decodeBookCursor parses a cursor sent by a client, which must have been
+// created for the order of opts.
+func decodeBookCursor(s string, opts ListOptions) (*bookCursor, error) {
+	data, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return nil, newAPIError(http.StatusBadRequest, "invalid_cursor")
+	}
+	var c bookCursor
+	if err := json.Unmarshal(data, &c); err != nil || len(c.Keys) != len(c.Sort) {
+		return nil, newAPIError(http.StatusBadRequest, "invalid_cursor")
+	}
+	if !slices.Equal(c.Sort, opts.Sort) || c.Desc != opts.Descending {
+		return nil, newAPIError(http.StatusBadRequest, "cursor_mismatch")
+	}
+	return &c, nil
+}
+
+// book returns a book holding just the fields the cursor orders by.
+func (c *bookCursor) book() Book {
+	book := Book{ID: c.ID}
+	for i, key := range c.Sort {
+		switch field, _ := sortField(key); field {
+		case "title":
+			book.Title = c.Keys[i]
+		case "author":
+			book.Author = c.Keys[i]
+		case "price":
+			price, _ := strconv.ParseFloat(c.Keys[i], 64)
+			book.Price = Price(price)
+		}
+	}
+	return book
+}
+
+// seek returns the books of list, which is in the cursor's order, that come
+// after the cursor.
+func (c *bookCursor) seek(list []Book) []Book {
+	at := c.book()
+	collatorMu.Lock()
+	defer collatorMu.Unlock()
+	i := sort.Search(len(list), func(i int) bool {
+		if c.Desc {
+			return lessBook(list[i], at, c.Sort)
+		}
+		return lessBook(at, list[i], c.Sort)
+	})
+	return list[i:]
+}
+
+// sortKeyValue returns the value of book that a sort key orders by.
+func sortKeyValue(book Book, key string) string {
+	switch field, _ := sortField(key); field {
+	case "title":
+		return book.Title
+	case "author":
+		return book.Author
+	case "price":
+		return strconv.FormatFloat(float64(book.Price), 'g', -1, 64)
+	}
+	return ""
+}
+
+// lessBook reports whether a sorts before b by the given sort keys, as
+// sortBooks orders them. Callers must hold collatorMu.
+func lessBook(a, b Book, fields []string) bool {
+	for _, key := range fields {
+		field, desc := sortField(key)
+		c := compareField(a, b, field)
+		if field == "title" || field == "author" {
+			c = collator.CompareString(sortKeyValue(a, field), sortKeyValue(b, field))
+		}
+		if c != 0 {
+			return c < 0 != desc
+		}
+	}
+	return a.ID < b.ID
+}
@@ -0,0 +1,127 @@
+package booksapi
+
+import (
+	"encoding/base64"
+	"net/http"
+	"net/url"
+	"strconv"
+	"testing"
+)
+
+// nextCursorPage fetches the page of GET /books with the given query and
+// cursor, returning its books and the cursor of the next page.
+func nextCursorPage(t *testing.T, h http.Handler, query url.Values, cursor string) ([]Book, string) {
+	t.Helper()
+	q := url.Values{}
+	for name, values := range query {
+		q[name] = values
+	}
+	if cursor != "" {
+		q.Set("cursor", cursor)
+	}
+	rec := serve(t, h, http.MethodGet, "/books?"+q.Encode(), nil)
+	wantCode(t, rec, http.StatusOK)
+	return decode[[]Book](t, rec), rec.Header().Get(nextCursorHeader)
+}
+
+// TestCursorPaging pages through the books while others are added and
+// removed between pages, and checks that each book there throughout is
+// seen exactly once, in order.
+func TestCursorPaging(t *testing.T) {
+	// Books are titled by letter, so books can be added on either side of
+	// the cursor in title order too.
+	title := func(i int) string { return string(rune('A' + i)) }
+	for _, tt := range []struct {
+		name  string
+		query url.Values
+	}{
+		{"by ID", url.Values{"limit": {"3"}}},
+		{"by title descending", url.Values{"limit": {"3"}, "sort": {"title"}, "order": {"desc"}}},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			h := newTestServer(t)
+			for i := range 10 {
+				mustCreateBook(t, h, map[string]any{"title": title(2 * i)})
+			}
+
+			seen := make(map[int]int)
+			var order []Book
+			books, cursor := nextCursorPage(t, h, tt.query, "")
+			for page := 1; ; page++ {
+				for _, book := range books {
+					seen[book.ID]++
+					order = append(order, book)
+				}
+				if cursor == "" {
+					break
+				}
+				// Books are added before and after the cursor, and one
+				// already seen is removed.
+				mustCreateBook(t, h, map[string]any{"title": title(2*page - 1)})
+				mustCreateBook(t, h, map[string]any{"title": title(21 + page)})
+				wantCode(t, serve(t, h, http.MethodDelete, "/books/"+strconv.Itoa(order[page-1].ID), nil), http.StatusNoContent)
+				books, cursor = nextCursorPage(t, h, tt.query, cursor)
+			}
+
+			for id, n := range seen {
+				if n != 1 {
+					t.Errorf("book %d seen %d times", id, n)
+				}
+			}
+			// No book created at the start was skipped.
+			for id := 1; id <= 10; id++ {
+				if seen[id] == 0 {
+					t.Errorf("book %d was skipped", id)
+				}
+			}
+			for i := 1; i < len(order); i++ {
+				ordered := order[i-1].ID < order[i].ID
+				if tt.query.Has("sort") {
+					ordered = order[i-1].Title > order[i].Title
+				}
+				if !ordered {
+					t.Errorf("%+v listed before %+v", order[i-1], order[i])
+				}
+			}
+		})
+	}
+}
+
+// TestCursorErrors checks the cursors refused.
+func TestCursorErrors(t *testing.T) {
+	encode := func(s string) string { return base64.RawURLEncoding.EncodeToString([]byte(s)) }
+	h := newTestServer(t)
+	for _, title := range []string{"Dune", "Emma", "Jazz"} {
+		mustCreateBook(t, h, map[string]any{"title": title})
+	}
+	_, byTitle := nextCursorPage(t, h, url.Values{"limit": {"1"}, "sort": {"title"}}, "")
+	if byTitle == "" {
+		t.Fatal("no cursor for the first page")
+	}
+	_, lastPage := nextCursorPage(t, h, url.Values{"limit": {"3"}}, "")
+	if lastPage != "" {
+		t.Errorf("cursor %q after the last page", lastPage)
+	}
+
+	tests := []struct {
+		name  string
+		query string
+		code  string
+	}{
+		{"not base64", "limit=1&cursor=%21%21", "invalid_cursor"},
+		{"not a cursor", "limit=1&cursor=" + encode(`[1]`), "invalid_cursor"},
+		{"keys missing", "limit=1&cursor=" + encode(`{"s":["title"],"i":1}`), "invalid_cursor"},
+		{"other sort", "limit=1&sort=price&cursor=" + byTitle, "cursor_mismatch"},
+		{"other order", "limit=1&sort=title&order=desc&cursor=" + byTitle, "cursor_mismatch"},
+		{"with offset", "limit=1&sort=title&offset=1&cursor=" + byTitle, "conflicting_query_parameters"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := serve(t, h, http.MethodGet, "/books?"+tt.query, nil)
+			wantCode(t, rec, http.StatusBadRequest)
+			if got := errorCode(t, rec); got != tt.code {
+				t.Errorf("error code %q, want %q", got, tt.code)
+			}
+		})
+	}
+}
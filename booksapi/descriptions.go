@@ -0,0 +1,295 @@
+package booksapi
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"hash"
+	"io"
+	"mime"
+	"net/http"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	"golang.org/x/text/encoding/charmap"
+)
+
+// Full description storage settings, configured by flags in Main. Full
+// descriptions are kept in files of their own rather than in the store, so
+// they may be far larger than the JSON body limit allows.
+var (
+	descriptionDir            = "descriptions"
+	maxDescriptionBytes int64 = 4 << 20
+)
+
+// descriptionPreviewLength is the number of characters of a full
+// description that book responses carry as description_preview.
+const descriptionPreviewLength = 500
+
+// descriptionInfo describes a stored full description.
+type descriptionInfo struct {
+	ETag    string
+	ModTime time.Time
+	Size    int64
+	Preview string
+}
+
+// Global variables to track stored full descriptions. When both locks are
+// needed, mu must be acquired before descriptionsMu.
+var (
+	fullDescriptions = make(map[int]descriptionInfo) // book ID -> description
+	descriptionsMu   sync.Mutex
+)
+
+// descriptionHandler handles a book's full description (GET, HEAD, PUT,
+// DELETE).
+func descriptionHandler(w http.ResponseWriter, r *http.Request, id int) {
+	if _, ok := checkQuery(w, r); !ok {
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet, http.MethodHead:
+		getDescription(w, r, id)
+	case http.MethodPut:
+		putDescription(w, r, id)
+	case http.MethodDelete:
+		deleteDescription(w, r, id)
+	default:
+		writeError(w, r, http.StatusMethodNotAllowed, "method_not_allowed")
+	}
+}
+
+// getDescription serves a book's full description as plain text.
+func getDescription(w http.ResponseWriter, r *http.Request, id int) {
+	info, found := fullDescription(id)
+	if !found {
+		writeError(w, r, http.StatusNotFound, "description_not_found")
+		return
+	}
+
+	f, err := os.Open(descriptionPath(id))
+	if err != nil {
+		writeError(w, r, http.StatusNotFound, "description_not_found")
+		return
+	}
+	defer f.Close()
+
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.Header().Set("ETag", info.ETag)
+	http.ServeContent(w, r, "", info.ModTime, f)
+}
+
+// putDescription stores the text/plain body as a book's full description.
+// The body is streamed to a file as it arrives, checked to be UTF-8, or
+// transcoded from Latin-1 if its charset says so, and never held in memory
+// as a whole.
+func putDescription(w http.ResponseWriter, r *http.Request, id int) {
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if mediaType != "text/plain" {
+		writeError(w, r, http.StatusUnsupportedMediaType, "description_unsupported_type")
+		return
+	}
+	latin1, err := isLatin1Body(r)
+	if err != nil {
+		writeAPIError(w, r, err)
+		return
+	}
+	// The book is looked up before the upload too, so a missing book fails
+	// before its description is read.
+	if _, err := findBook(id); err != nil {
+		writeAPIError(w, r, err)
+		return
+	}
+
+	limit := bodyLimit(r, maxDescriptionBytes)
+	var body io.Reader = http.MaxBytesReader(w, r.Body, limit)
+	if latin1 {
+		body = charmap.ISO8859_1.NewDecoder().Reader(body)
+	}
+	tmp, info, err := writeDescriptionUpload(body)
+	if tmp != "" {
+		defer os.Remove(tmp)
+	}
+	if err != nil {
+		var tooLarge *http.MaxBytesError
+		switch {
+		case errors.As(err, &tooLarge):
+			writeError(w, r, http.StatusRequestEntityTooLarge, "description_too_large", "max", limit)
+		case errors.Is(err, errInvalidUTF8):
+			writeError(w, r, http.StatusUnprocessableEntity, "invalid_utf8", "field", "description")
+		case errors.Is(err, errUploadRead):
+			writeError(w, r, http.StatusBadRequest, "invalid_request")
+		default:
+			writeError(w, r, http.StatusInternalServerError, "description_store_failed")
+		}
+		return
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	if _, err := findBook(id); err != nil {
+		writeAPIError(w, r, err)
+		return
+	}
+	if err := os.Rename(tmp, descriptionPath(id)); err != nil {
+		writeError(w, r, http.StatusInternalServerError, "description_store_failed")
+		return
+	}
+
+	descriptionsMu.Lock()
+	fullDescriptions[id] = info
+	descriptionsMu.Unlock()
+
+	w.Header().Set("ETag", info.ETag)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// Errors of writeDescriptionUpload other than those of the store.
+var (
+	errInvalidUTF8 = errors.New("description is not valid UTF-8")
+	errUploadRead  = errors.New("reading the upload failed")
+)
+
+// writeDescriptionUpload copies an uploaded description into a temporary
+// file of descriptionDir, returning its name, which the caller removes or
+// renames, and a description of its contents. Errors reading body wrap
+// errUploadRead, or are the *http.MaxBytesError of a body over its limit.
+func writeDescriptionUpload(body io.Reader) (string, descriptionInfo, error) {
+	if err := os.MkdirAll(descriptionDir, 0o755); err != nil {
+		return "", descriptionInfo{}, err
+	}
+	tmp, err := os.CreateTemp(descriptionDir, "upload-*")
+	if err != nil {
+		return "", descriptionInfo{}, err
+	}
+	sink := &descriptionSink{file: tmp, sum: sha256.New()}
+	size, err := io.Copy(sink, readErrors{body})
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil && len(sink.pending) > 0 {
+		err = errInvalidUTF8
+	}
+	if err != nil {
+		return tmp.Name(), descriptionInfo{}, err
+	}
+	return tmp.Name(), descriptionInfo{
+		ETag:    `"` + hex.EncodeToString(sink.sum.Sum(nil)) + `"`,
+		ModTime: time.Now(),
+		Size:    size,
+		Preview: descriptionPreview(sink.head),
+	}, nil
+}
+
+// readErrors marks the errors of a reader as errUploadRead, so they are
+// told apart from those of writing the file. The *http.MaxBytesError of a
+// body over its limit is kept as it is.
+type readErrors struct{ io.Reader }
+
+func (r readErrors) Read(p []byte) (int, error) {
+	n, err := r.Reader.Read(p)
+	var tooLarge *http.MaxBytesError
+	if err != nil && err != io.EOF && !errors.As(err, &tooLarge) {
+		err = errors.Join(errUploadRead, err)
+	}
+	return n, err
+}
+
+// descriptionSink writes an uploaded description to its file, hashing it,
+// checking that it is valid UTF-8, and keeping the bytes its preview is
+// taken from.
+type descriptionSink struct {
+	file    *os.File
+	sum     hash.Hash
+	head    []byte // the leading bytes, enough for the preview
+	pending []byte // the start of a character that continues in the next write
+}
+
+func (s *descriptionSink) Write(p []byte) (int, error) {
+	data := append(s.pending, p...)
+	// A character split between writes is checked once it is complete.
+	complete := len(data)
+	for i := len(data) - 1; i >= 0 && i >= len(data)-utf8.UTFMax; i-- {
+		if utf8.RuneStart(data[i]) {
+			if !utf8.FullRune(data[i:]) {
+				complete = i
+			}
+			break
+		}
+	}
+	if !utf8.Valid(data[:complete]) {
+		return 0, errInvalidUTF8
+	}
+	s.pending = slices.Clone(data[complete:])
+
+	if room := descriptionPreviewLength*utf8.UTFMax - len(s.head); room > 0 {
+		s.head = append(s.head, p[:min(room, len(p))]...)
+	}
+	s.sum.Write(p)
+	return s.file.Write(p)
+}
+
+// descriptionPreview returns the first descriptionPreviewLength characters
+// of the description starting with head, cut at a character boundary.
+func descriptionPreview(head []byte) string {
+	n := 0
+	for i := 0; i < descriptionPreviewLength && n < len(head); i++ {
+		_, size := utf8.DecodeRune(head[n:])
+		n += size
+	}
+	return strings.ToValidUTF8(string(head[:n]), "")
+}
+
+// deleteDescription removes a book's full description.
+func deleteDescription(w http.ResponseWriter, r *http.Request, id int) {
+	descriptionsMu.Lock()
+	defer descriptionsMu.Unlock()
+
+	if _, found := fullDescriptions[id]; !found {
+		writeError(w, r, http.StatusNotFound, "description_not_found")
+		return
+	}
+
+	os.Remove(descriptionPath(id))
+	delete(fullDescriptions, id)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// removeDescription deletes the full description of a deleted book, if it
+// has one.
+func removeDescription(id int) {
+	descriptionsMu.Lock()
+	defer descriptionsMu.Unlock()
+
+	if _, found := fullDescriptions[id]; found {
+		os.Remove(descriptionPath(id))
+		delete(fullDescriptions, id)
+	}
+}
+
+// fullDescription returns the stored full description of a book, if it
+// has one.
+func fullDescription(id int) (descriptionInfo, bool) {
+	descriptionsMu.Lock()
+	defer descriptionsMu.Unlock()
+
+	info, found := fullDescriptions[id]
+	return info, found
+}
+
+// descriptionURL returns the URL a book's full description is served from.
+func descriptionURL(id int) string {
+	return apiPath("/books/" + strconv.Itoa(id) + "/description")
+}
+
+// descriptionPath returns the file a book's full description is stored in.
+func descriptionPath(id int) string {
+	return filepath.Join(descriptionDir, strconv.Itoa(id))
+}
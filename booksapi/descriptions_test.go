@@ -0,0 +1,144 @@
+package booksapi
+
+import (
+	"errors"
+	"io/fs"
+	"net/http"
+	"os"
+	"strings"
+	"testing"
+)
+
+// putDescriptionText uploads text as the full description of book id.
+func putDescriptionText(t *testing.T, h http.Handler, id, text string, header ...string) *http.Response {
+	t.Helper()
+	rec := serve(t, h, http.MethodPut, "/books/"+id+"/description", text, append([]string{"Content-Type", "text/plain"}, header...)...)
+	return rec.Result()
+}
+
+// TestDescriptionUpload uploads descriptions just under and just over the
+// limit, which is apart from the JSON body limit, and checks the one
+// stored is served back byte for byte with its ETag.
+func TestDescriptionUpload(t *testing.T) {
+	h := newTestServer(t)
+	setForTest(t, &maxBodyBytes, 100)
+	setForTest(t, &maxDescriptionBytes, 1000)
+	mustCreateBook(t, h, map[string]any{"title": "Dune", "description": "Spice"})
+
+	text := strings.Repeat("Arrakis ", 125)
+	resp := putDescriptionText(t, h, "1", text)
+	if resp.StatusCode != http.StatusNoContent {
+		t.Fatalf("upload of %d bytes: status %d", len(text), resp.StatusCode)
+	}
+	etag := resp.Header.Get("ETag")
+
+	rec := serve(t, h, http.MethodGet, "/books/1/description", nil)
+	wantCode(t, rec, http.StatusOK)
+	if rec.Body.String() != text || rec.Header().Get("Content-Type") != "text/plain; charset=utf-8" || rec.Header().Get("ETag") != etag || etag == "" {
+		t.Errorf("served %d bytes as %q with ETag %q, want %d bytes with %q", rec.Body.Len(), rec.Header().Get("Content-Type"), rec.Header().Get("ETag"), len(text), etag)
+	}
+	wantCode(t, serve(t, h, http.MethodGet, "/books/1/description", nil, "If-None-Match", etag), http.StatusNotModified)
+
+	rec = serve(t, h, http.MethodPut, "/books/1/description", text+"!", "Content-Type", "text/plain")
+	wantCode(t, rec, http.StatusRequestEntityTooLarge)
+	if got := decode[errorBody](t, rec).Error; got.Code != "description_too_large" || got.Params["max"] != "1000" {
+		t.Errorf("upload over the limit: error %s %v", got.Code, got.Params)
+	}
+	if got := serve(t, h, http.MethodGet, "/books/1/description", nil).Body.String(); got != text {
+		t.Errorf("upload over the limit replaced the description with %d bytes", len(got))
+	}
+	entries, err := os.ReadDir(descriptionDir)
+	if err != nil || len(entries) != 1 {
+		t.Errorf("description directory holds %v (%v), want only book 1's", entries, err)
+	}
+
+	for _, tt := range []struct {
+		name        string
+		path        string
+		body        string
+		contentType string
+		status      int
+		code        string
+	}{
+		{"json", "/books/1/description", `"Spice"`, "application/json", http.StatusUnsupportedMediaType, "description_unsupported_type"},
+		{"invalid UTF-8", "/books/1/description", "Spice \xff", "text/plain", http.StatusUnprocessableEntity, "invalid_utf8"},
+		{"missing book", "/books/99/description", "Spice", "text/plain", http.StatusNotFound, "book_not_found"},
+	} {
+		rec := serve(t, h, http.MethodPut, tt.path, tt.body, "Content-Type", tt.contentType)
+		wantCode(t, rec, tt.status)
+		if got := errorCode(t, rec); got != tt.code {
+			t.Errorf("%s: error code %q, want %q", tt.name, got, tt.code)
+		}
+	}
+}
+
+// TestDescriptionPreview checks that a book with a full description
+// carries its first 500 characters, cut at a character boundary, in place
+// of its description, and that Latin-1 uploads are transcoded.
+func TestDescriptionPreview(t *testing.T) {
+	h := newTestServer(t)
+	mustCreateBook(t, h, map[string]any{"title": "Dune", "description": "Spice"})
+	mustCreateBook(t, h, map[string]any{"title": "Emma"})
+
+	text := strings.Repeat("a", 498) + "éü日本語"
+	if resp := putDescriptionText(t, h, "1", text); resp.StatusCode != http.StatusNoContent {
+		t.Fatalf("upload: status %d", resp.StatusCode)
+	}
+	book := decode[map[string]any](t, serve(t, h, http.MethodGet, "/books/1?include=description", nil))
+	if want := strings.Repeat("a", 498) + "éü"; book["description_preview"] != want {
+		t.Errorf("preview %q, want %q", book["description_preview"], want)
+	}
+	if book["has_full_description"] != true || book["description_url"] != "/books/1/description" || book["description"] != nil {
+		t.Errorf("book %v", book)
+	}
+	listed := decode[[]map[string]any](t, serve(t, h, http.MethodGet, "/books", nil))
+	if listed[0]["has_full_description"] != true || listed[1]["has_full_description"] != false || listed[1]["description_preview"] != nil {
+		t.Errorf("listing %v", listed)
+	}
+
+	if resp := putDescriptionText(t, h, "2", "Caf\xe9", "Content-Type", "text/plain; charset=iso-8859-1"); resp.StatusCode != http.StatusNoContent {
+		t.Fatalf("Latin-1 upload: status %d", resp.StatusCode)
+	}
+	if got := serve(t, h, http.MethodGet, "/books/2/description", nil).Body.String(); got != "Café" {
+		t.Errorf("Latin-1 description served as %q", got)
+	}
+
+	for _, tt := range []struct {
+		head []byte
+		want string
+	}{
+		{[]byte("ab日")[:4], "ab"},
+		{[]byte(strings.Repeat("é", 600)), strings.Repeat("é", 500)},
+		{nil, ""},
+	} {
+		if got := descriptionPreview(tt.head); got != tt.want {
+			t.Errorf("descriptionPreview(%q) = %q, want %q", tt.head, got, tt.want)
+		}
+	}
+}
+
+// TestDescriptionCleanup checks that deleting a book, or its description,
+// removes the file.
+func TestDescriptionCleanup(t *testing.T) {
+	h := newTestServer(t)
+	mustCreateBook(t, h, map[string]any{"title": "Dune"})
+	mustCreateBook(t, h, map[string]any{"title": "Emma"})
+	for _, id := range []string{"1", "2"} {
+		if resp := putDescriptionText(t, h, id, "A long description"); resp.StatusCode != http.StatusNoContent {
+			t.Fatalf("upload: status %d", resp.StatusCode)
+		}
+	}
+
+	wantCode(t, serve(t, h, http.MethodDelete, "/books/1", nil), http.StatusNoContent)
+	wantCode(t, serve(t, h, http.MethodDelete, "/books/2/description", nil), http.StatusNoContent)
+	for _, id := range []int{1, 2} {
+		if _, err := os.Stat(descriptionPath(id)); !errors.Is(err, fs.ErrNotExist) {
+			t.Errorf("description of book %d left behind: %v", id, err)
+		}
+	}
+	wantCode(t, serve(t, h, http.MethodGet, "/books/2/description", nil), http.StatusNotFound)
+	wantCode(t, serve(t, h, http.MethodDelete, "/books/2/description", nil), http.StatusNotFound)
+	if book := decode[map[string]any](t, serve(t, h, http.MethodGet, "/books/2", nil)); book["has_full_description"] != false {
+		t.Errorf("book %v after its description was deleted", book)
+	}
+}
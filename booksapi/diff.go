@@ -0,0 +1,304 @@
+package booksapi
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+
+	"golang.org/x/text/encoding/charmap"
+)
+
+// Diff match keys, chosen with ?match_by=, deciding which catalog book a
+// book of the file is compared with.
+const (
+	matchAuto        = "auto"         // ISBN, falling back to title and author, as imports match
+	matchISBN        = "isbn"         // ISBN only
+	matchTitleAuthor = "title_author" // title and author only
+)
+
+// diffParams declares the query parameters of POST /books/diff.
+var diffParams = []queryParam{
+	{name: "match_by", kind: enumParam, values: []string{matchAuto, matchISBN, matchTitleAuthor}},
+}
+
+// diffIgnoredFields are the book fields set by the server, which a diff
+// does not compare.
+var diffIgnoredFields = []string{"id", "created_at", "updated_at"}
+
+// diffReport is the response body of POST /books/diff.
+type diffReport struct {
+	Created   []Book       `json:"created"`
+	Updated   []bookUpdate `json:"updated"`
+	Deleted   []Book       `json:"deleted"` // in the catalog but not in the file
+	Unchanged int          `json:"unchanged"`
+}
+
+// bookUpdate describes the changes a book of the file would make to the
+// catalog book it matches.
+type bookUpdate struct {
+	ID      int                    `json:"id"`
+	Index   int                    `json:"index"` // position of the book in the file
+	Changes map[string]fieldChange `json:"changes"`
+}
+
+// fieldChange is the value of a field before and after an update; null
+// stands for an unset field.
+type fieldChange struct {
+	Before json.RawMessage `json:"before"`
+	After  json.RawMessage `json:"after"`
+}
+
+// diffBooks compares a file in the format of POST /books/import with the
+// catalog, reporting the books an import would create and update, and the
+// catalog books the file lacks (POST /books/diff). Nothing is changed. The
+// catalog is read with a single List call, so the report reflects one
+// consistent state, and the file is decoded one book at a time rather than
+// buffered.
+func diffBooks(w http.ResponseWriter, r *http.Request) {
+	q, ok := checkQuery(w, r, diffParams...)
+	if !ok {
+		return
+	}
+	matchBy, err := singleValue(q, diffParams, "match_by")
+	if err != nil {
+		writeAPIError(w, r, err)
+		return
+	}
+	if matchBy == "" {
+		matchBy = matchAuto
+	}
+
+	src, err := openImportBody(w, r)
+	if err != nil {
+		writeAPIError(w, r, err)
+		return
+	}
+	latin1, err := isLatin1Body(r)
+	if err != nil {
+		writeAPIError(w, r, err)
+		return
+	}
+	if latin1 {
+		src = charmap.ISO8859_1.NewDecoder().Reader(src)
+	}
+
+	caller := readerToken(r)
+	catalog := store.List(ListOptions{})
+	byISBN := make(map[string]int)
+	byTitleAuthor := make(map[string]int)
+	for i, book := range catalog {
+		if book.ISBN != "" {
+			byISBN[book.ISBN] = i
+		}
+		byTitleAuthor[titleAuthorKey(book)] = i
+	}
+	matched := make([]bool, len(catalog))
+
+	report := diffReport{Created: []Book{}, Updated: []bookUpdate{}, Deleted: []Book{}}
+	err = decodeBookStream(src, func(index int, book Book) error {
+		if err := validateImportBook(&book); err != nil {
+			return withIndex(err, index)
+		}
+		book = redactBook(caller, book)
+
+		i, found := -1, false
+		if matchBy != matchTitleAuthor && book.ISBN != "" {
+			i, found = byISBN[book.ISBN]
+		}
+		if !found && matchBy != matchISBN {
+			i, found = byTitleAuthor[titleAuthorKey(book)]
+		}
+		if !found {
+			report.Created = append(report.Created, book)
+			return nil
+		}
+
+		matched[i] = true
+		changes, err := bookChanges(redactBook(caller, catalog[i]), book)
+		if err != nil {
+			return err
+		}
+		if len(changes) == 0 {
+			report.Unchanged++
+			return nil
+		}
+		report.Updated = append(report.Updated, bookUpdate{ID: catalog[i].ID, Index: index, Changes: changes})
+		return nil
+	})
+	if err != nil {
+		writeAPIError(w, r, decodeFailure(r, nil, err))
+		return
+	}
+
+	for i, book := range catalog {
+		if !matched[i] {
+			report.Deleted = append(report.Deleted, redactBook(caller, book))
+		}
+	}
+	writeJSON(w, http.StatusOK, report)
+}
+
+// decodeBookStream decodes the books of a backup, in the format decodeBackup
+// reads, from src, calling fn with each book and its index as soon as it is
+// read. The count and checksum of a backupDocument are verified once its
+// end is reached, so fn must not make changes that cannot be dropped.
+func decodeBookStream(src io.Reader, fn func(index int, book Book) error) error {
+	dec := json.NewDecoder(src)
+	tok, err := dec.Token()
+	if err != nil {
+		return streamError(dec, err)
+	}
+	switch tok {
+	case json.Delim('['):
+		return decodeBookArray(dec, nil, fn)
+	case json.Delim('{'):
+		return decodeBackupStream(dec, fn)
+	}
+	return newAPIError(http.StatusBadRequest, "invalid_body_type", "expected", "object", "got", tokenKind(tok))
+}
+
+// decodeBackupStream decodes the rest of a backupDocument whose opening
+// brace dec has read, as decodeBookStream.
+func decodeBackupStream(dec *json.Decoder, fn func(index int, book Book) error) error {
+	c := newBookChecksum()
+	var count *int
+	var sum string
+	for dec.More() {
+		tok, err := dec.Token()
+		if err != nil {
+			return streamError(dec, err)
+		}
+		switch tok {
+		case "books":
+			tok, err := dec.Token()
+			if err != nil {
+				return streamError(dec, err)
+			}
+			if tok != json.Delim('[') {
+				return newAPIError(http.StatusBadRequest, "invalid_field_type", "field", "books", "type", "array")
+			}
+			err = decodeBookArray(dec, c, fn)
+		case "count":
+			err = dec.Decode(&count)
+		case "sha256":
+			err = dec.Decode(&sum)
+		default:
+			err = dec.Decode(&json.RawMessage{})
+		}
+		if err != nil {
+			return streamError(dec, err)
+		}
+	}
+	if _, err := dec.Token(); err != nil {
+		return streamError(dec, err)
+	}
+	return verifyChecksum(c, count, sum)
+}
+
+// decodeBookArray decodes the books of an array whose opening bracket dec
+// has read, adding them to c if it is not nil, as decodeBookStream.
+func decodeBookArray(dec *json.Decoder, c *bookChecksum, fn func(index int, book Book) error) error {
+	for index := 0; dec.More(); index++ {
+		var raw json.RawMessage
+		if err := dec.Decode(&raw); err != nil {
+			return streamError(dec, err)
+		}
+		if err := checkUTF8(raw); err != nil {
+			return withIndex(err, index)
+		}
+		var book Book
+		if err := json.Unmarshal(raw, &book); err != nil {
+			return withIndex(decodeError(err), index)
+		}
+		if c != nil {
+			if err := c.add(book); err != nil {
+				return err
+			}
+		}
+		if err := fn(index, book); err != nil {
+			return err
+		}
+	}
+	if _, err := dec.Token(); err != nil {
+		return streamError(dec, err)
+	}
+	return nil
+}
+
+// tokenKind names the kind of JSON value a token starts, as jsonValueKind
+// does.
+func tokenKind(tok json.Token) string {
+	switch tok := tok.(type) {
+	case json.Delim:
+		if tok == '{' {
+			return "object"
+		}
+		return "array"
+	case string:
+		return "string"
+	case float64, json.Number:
+		return "number"
+	case bool:
+		return "boolean"
+	}
+	return "null"
+}
+
+// streamError returns the apiError of a failed read of a streamed body
+// from dec, classified by decodeError, such as import_too_large or, for a
+// body cut short, invalid_json at the start of the value it cuts short.
+func streamError(dec *json.Decoder, err error) error {
+	if errors.Is(err, io.ErrUnexpectedEOF) {
+		return newAPIError(http.StatusBadRequest, "invalid_json", "offset", dec.InputOffset())
+	}
+	return decodeError(err)
+}
+
+// bookChanges returns the fields that differ between the JSON forms of two
+// books, ignoring the fields set by the server.
+func bookChanges(before, after Book) (map[string]fieldChange, error) {
+	old, err := bookFields(before)
+	if err != nil {
+		return nil, err
+	}
+	updated, err := bookFields(after)
+	if err != nil {
+		return nil, err
+	}
+
+	null := json.RawMessage("null")
+	changes := make(map[string]fieldChange)
+	for name, value := range updated {
+		if !bytes.Equal(old[name], value) {
+			change := fieldChange{Before: old[name], After: value}
+			if change.Before == nil {
+				change.Before = null
+			}
+			changes[name] = change
+		}
+	}
+	for name, value := range old {
+		if _, ok := updated[name]; !ok {
+			changes[name] = fieldChange{Before: value, After: null}
+		}
+	}
+	return changes, nil
+}
+
+// bookFields returns the JSON fields of book a diff compares.
+func bookFields(book Book) (map[string]json.RawMessage, error) {
+	data, err := json.Marshal(book)
+	if err != nil {
+		return nil, err
+	}
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(data, &fields); err != nil {
+		return nil, err
+	}
+	for _, name := range diffIgnoredFields {
+		delete(fields, name)
+	}
+	return fields, nil
+}
@@ -0,0 +1,75 @@
+package booksapi
+
+import (
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// diffCatalog fills the catalog with the books testdata/diff/file.json is
+// compared with: one it updates by ISBN, one it updates by title and
+// author, one it lacks, and one it holds as is.
+func diffCatalog(t *testing.T, h http.Handler) {
+	t.Helper()
+	mustCreateBook(t, h, map[string]any{"title": "Dune (first edition)", "author": "Frank Herbert", "isbn": "9780441172719", "price": 9.99, "language": "en"})
+	mustCreateBook(t, h, map[string]any{"title": "Emma", "author": "Jane Austen", "price": 7.99})
+	mustCreateBook(t, h, map[string]any{"title": "Neuromancer", "author": "William Gibson", "price": 10})
+	mustCreateBook(t, h, map[string]any{"title": "Persuasion", "author": "Jane Austen", "price": 6.5})
+}
+
+// TestDiffBooks pins the reports of a file with one book of each kind of
+// change, under each match strategy, and checks that the catalog is left
+// as it was.
+func TestDiffBooks(t *testing.T) {
+	h := newTestServer(t)
+	diffCatalog(t, h)
+	before := catalogJSON(t, store)
+	file, err := os.ReadFile(filepath.Join("testdata", "diff", "file.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, matchBy := range []string{"", matchISBN, matchTitleAuthor} {
+		name := matchBy
+		if name == "" {
+			name = matchAuto
+		}
+		t.Run(name, func(t *testing.T) {
+			rec := serve(t, h, http.MethodPost, "/books/diff?match_by="+matchBy, file)
+			wantCode(t, rec, http.StatusOK)
+			checkGolden(t, "diff/"+name+".json", rec.Body.Bytes())
+		})
+	}
+	if after := catalogJSON(t, store); after != before {
+		t.Errorf("diff changed the catalog from %s to %s", before, after)
+	}
+}
+
+// TestDiffErrors checks that files an import would reject are rejected
+// by a diff in the same way.
+func TestDiffErrors(t *testing.T) {
+	h := newTestServer(t)
+	diffCatalog(t, h)
+	tests := []struct {
+		name, path, body string
+		status           int
+		code, index      string
+	}{
+		{"invalid book", "/books/diff", `[{"title": "Emma"}, {"title": " "}]`, http.StatusUnprocessableEntity, "field_required", "1"},
+		{"wrong type", "/books/diff", `[{"title": "Emma"}, {"title": 5}]`, http.StatusBadRequest, "invalid_field_type", "1"},
+		{"cut short", "/books/diff", `[{"title": "Emma"}, {"ti`, http.StatusBadRequest, "invalid_json", ""},
+		{"not a list", "/books/diff", `"books"`, http.StatusBadRequest, "invalid_body_type", ""},
+		{"no checksum", "/books/diff", `{"books": [{"title": "Emma"}], "count": 1}`, http.StatusUnprocessableEntity, "backup_incomplete", ""},
+		{"unknown strategy", "/books/diff?match_by=id", `[]`, http.StatusBadRequest, "invalid_query_parameter", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := serve(t, h, http.MethodPost, tt.path, tt.body)
+			wantCode(t, rec, tt.status)
+			if got := decode[errorBody](t, rec).Error; got.Code != tt.code || got.Params["index"] != tt.index {
+				t.Errorf("error %s %v, want %s at index %q", got.Code, got.Params, tt.code, tt.index)
+			}
+		})
+	}
+}
@@ -0,0 +1,129 @@
+package booksapi
+
+import (
+	"cmp"
+	"fmt"
+	"net/http"
+	"slices"
+	"strings"
+)
+
+// Edition is a format a book is sold in, with its own price and ISBN.
+type Edition struct {
+	Format string `json:"format"` // one of editionFormats
+	Price  Price  `json:"price"`
+	ISBN   string `json:"isbn,omitempty"` // ISBN-10 or ISBN-13, without hyphens; unique across the catalog
+}
+
+// editionFormats are the formats an edition may have.
+var editionFormats = []string{"hardcover", "paperback", "ebook", "audiobook"}
+
+// checkEditions requires every edition to have a known format, given once
+// per book, a valid price, and a valid ISBN, if set, that no other edition
+// of the book has.
+func checkEditions(b Book, _ ValidationMode) []FieldError {
+	var errs []FieldError
+	formats := make(map[string]bool)
+	isbns := make(map[string]bool)
+	for i, e := range b.Editions {
+		field := fmt.Sprintf("editions.%d", i)
+		switch {
+		case !slices.Contains(editionFormats, e.Format):
+			errs = append(errs, newFieldError(field+".format", "edition_format", "format", e.Format, "formats", strings.Join(editionFormats, ", ")))
+		case formats[e.Format]:
+			errs = append(errs, newFieldError(field+".format", "unique", "value", e.Format))
+		}
+		formats[e.Format] = true
+		errs = append(errs, checkPriceField(field+".price", float64(e.Price))...)
+		isbn, err := normalizeISBN(e.ISBN)
+		switch {
+		case err != nil:
+			errs = append(errs, newFieldError(field+".isbn", "isbn", "isbn", e.ISBN))
+		case isbn != "" && isbns[isbn]:
+			errs = append(errs, newFieldError(field+".isbn", "unique", "value", isbn))
+		}
+		isbns[isbn] = true
+	}
+	return errs
+}
+
+// normalizeEditions normalizes the ISBNs of a valid book's editions and
+// sets its price to that of its cheapest edition, if it has any. The price
+// of a book with editions is therefore always derived from them, and the
+// one a client sends for it ignored, so ?min_price and ?max_price filter
+// on the cheapest edition.
+func normalizeEditions(book *Book) error {
+	if len(book.Editions) == 0 {
+		book.Editions = nil
+		return nil
+	}
+	for i := range book.Editions {
+		isbn, err := normalizeISBN(book.Editions[i].ISBN)
+		if err != nil {
+			return err
+		}
+		book.Editions[i].ISBN = isbn
+		if book.Editions[i].Price == 0 {
+			book.Editions[i].Price = 0 // drops the sign of negative zero
+		}
+	}
+	book.Price = slices.MinFunc(book.Editions, func(a, b Edition) int {
+		return cmp.Compare(a.Price, b.Price)
+	}).Price
+	return nil
+}
+
+// checkEditionISBNs verifies that no other book has an edition with the
+// ISBN of one of book's editions. Callers must hold mu.
+func checkEditionISBNs(book Book) error {
+	if len(book.Editions) == 0 {
+		return nil
+	}
+	owners := editionISBNOwners(func(id int) bool { return id != book.ID })
+	return claimEditionISBNs(owners, book)
+}
+
+// editionISBNOwners returns the ID of the book of each edition ISBN in the
+// catalog, looking only at the books keep returns true for. Callers must
+// hold mu.
+func editionISBNOwners(keep func(id int) bool) map[string]int {
+	owners := make(map[string]int)
+	for _, book := range store.List(ListOptions{}) {
+		if !keep(book.ID) {
+			continue
+		}
+		for _, e := range book.Editions {
+			if e.ISBN != "" {
+				owners[e.ISBN] = book.ID
+			}
+		}
+	}
+	return owners
+}
+
+// claimEditionISBNs adds the edition ISBNs of book to owners, failing with
+// 409 if another book already has one of them, in which case owners is left
+// unchanged.
+func claimEditionISBNs(owners map[string]int, book Book) error {
+	for _, e := range book.Editions {
+		if id, taken := owners[e.ISBN]; e.ISBN != "" && taken {
+			return newAPIError(http.StatusConflict, "edition_isbn_taken", "isbn", e.ISBN, "id", id)
+		}
+	}
+	for _, e := range book.Editions {
+		if e.ISBN != "" {
+			owners[e.ISBN] = book.ID
+		}
+	}
+	return nil
+}
+
+// hasEditionFormat reports whether book has an edition in any of formats.
+func hasEditionFormat(book Book, formats []string) bool {
+	for _, e := range book.Editions {
+		if slices.Contains(formats, e.Format) {
+			return true
+		}
+	}
+	return false
+}
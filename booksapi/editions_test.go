@@ -0,0 +1,165 @@
+package booksapi
+
+import (
+	"net/http"
+	"slices"
+	"testing"
+)
+
+// edition returns the JSON of an edition.
+func edition(format string, price float64, isbn string) map[string]any {
+	return map[string]any{"format": format, "price": price, "isbn": isbn}
+}
+
+func TestEditionISBNUniqueness(t *testing.T) {
+	h := newTestServer(t)
+	isbn := "9780441172719"
+	mustCreateBook(t, h, map[string]any{"title": "Dune", "editions": []any{edition("paperback", 10, isbn)}})
+	emma := mustCreateBook(t, h, map[string]any{"title": "Emma"})
+
+	tests := []struct {
+		name   string
+		method string
+		path   string
+		body   any
+	}{
+		{"create", http.MethodPost, "/books", map[string]any{"title": "Copy", "editions": []any{edition("ebook", 5, isbn)}}},
+		{"create with hyphens", http.MethodPost, "/books", map[string]any{"title": "Copy", "editions": []any{edition("ebook", 5, "978-0-441-17271-9")}}},
+		{"update", http.MethodPut, "/books/2", map[string]any{"editions": []any{edition("ebook", 5, isbn)}}},
+		{"patch", http.MethodPatch, "/books/2", map[string]any{"editions": []any{edition("hardcover", 5, isbn)}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := serve(t, h, tt.method, tt.path, tt.body)
+			wantCode(t, rec, http.StatusConflict)
+			if got := errorCode(t, rec); got != "edition_isbn_taken" {
+				t.Errorf("error code %q, want edition_isbn_taken", got)
+			}
+		})
+	}
+	if got, _ := store.Get(emma.ID); len(got.Editions) != 0 {
+		t.Errorf("refused update left editions %+v", got.Editions)
+	}
+
+	// A book keeps its own ISBNs when it is updated.
+	rec := serve(t, h, http.MethodPut, "/books/1", map[string]any{"editions": []any{edition("paperback", 11, isbn), edition("ebook", 4, "")}})
+	wantCode(t, rec, http.StatusOK)
+
+	// Once the owner gives an ISBN up, another book can have it.
+	wantCode(t, serve(t, h, http.MethodDelete, "/books/1", nil), http.StatusNoContent)
+	rec = serve(t, h, http.MethodPut, "/books/2", map[string]any{"editions": []any{edition("ebook", 5, isbn)}})
+	wantCode(t, rec, http.StatusOK)
+}
+
+func TestEditionISBNMerge(t *testing.T) {
+	h := newTestServer(t)
+	isbn, other := "9780441172719", "9780141439518"
+	mustCreateBook(t, h, map[string]any{"title": "Dune", "author": "Frank Herbert", "editions": []any{edition("paperback", 10, isbn)}})
+	mustCreateBook(t, h, map[string]any{"title": "Emma", "author": "Jane Austen", "editions": []any{edition("paperback", 6, other)}})
+
+	remote := []map[string]any{
+		// A new book with the edition ISBN of Dune.
+		{"title": "Dune (pirated)", "author": "Nobody", "editions": []any{edition("ebook", 1, isbn)}},
+		// Emma, taking the ISBN of Dune in place of its own.
+		{"title": "Emma", "author": "Jane Austen", "editions": []any{edition("ebook", 3, isbn)}},
+		// Dune, giving its ISBN up for a new one.
+		{"title": "Dune", "author": "Frank Herbert", "editions": []any{edition("hardcover", 20, "9780593099322")}},
+		// A new book, free to take the ISBN Dune gave up.
+		{"title": "Dune Messiah", "author": "Frank Herbert", "editions": []any{edition("paperback", 9, isbn)}},
+	}
+	rec := serve(t, h, http.MethodPost, "/admin/merge?strategy=keep_remote", remote)
+	wantCode(t, rec, http.StatusOK)
+	report := decode[mergeReport](t, rec)
+
+	skipped := make(map[int]string)
+	for _, e := range report.Skipped {
+		skipped[e.Index] = e.Reason
+	}
+	if skipped[0] != "edition_isbn_taken" || skipped[1] != "edition_isbn_taken" || len(skipped) != 2 {
+		t.Errorf("skipped %v, want remote books 0 and 1 for edition_isbn_taken", skipped)
+	}
+	if len(report.Updated) != 1 || report.Updated[0].Index != 2 || len(report.Created) != 1 || report.Created[0].Index != 3 {
+		t.Errorf("updated %+v and created %+v, want remote book 2 updated and 3 created", report.Updated, report.Created)
+	}
+	if emma, _ := store.Get(2); emma.Editions[0].ISBN != other {
+		t.Errorf("Emma has editions %+v after the merge", emma.Editions)
+	}
+	if violations := verifyCatalog(); len(violations) > 0 {
+		t.Errorf("verify: %v", violations)
+	}
+}
+
+func TestEditionISBNUnarchive(t *testing.T) {
+	h := newTestServer(t)
+	isbn := "9780441172719"
+	mustCreateBook(t, h, map[string]any{"title": "Dune", "editions": []any{edition("paperback", 10, isbn)}})
+	wantCode(t, serve(t, h, http.MethodPost, "/books/1/archive", nil), http.StatusOK)
+	mustCreateBook(t, h, map[string]any{"title": "Copy", "editions": []any{edition("ebook", 5, isbn)}})
+
+	rec := serve(t, h, http.MethodPost, "/books/1/unarchive", nil)
+	wantCode(t, rec, http.StatusConflict)
+	if got := errorCode(t, rec); got != "edition_isbn_taken" {
+		t.Errorf("error code %q, want edition_isbn_taken", got)
+	}
+	if !isArchived(1) {
+		t.Error("refused unarchive took the book out of cold storage")
+	}
+
+	wantCode(t, serve(t, h, http.MethodDelete, "/books/2", nil), http.StatusNoContent)
+	wantCode(t, serve(t, h, http.MethodPost, "/books/1/unarchive", nil), http.StatusOK)
+}
+
+// TestEditionDerivedPrice checks that the price of a book with editions is
+// that of its cheapest edition, whatever price is sent.
+func TestEditionDerivedPrice(t *testing.T) {
+	h := newTestServer(t)
+	book := mustCreateBook(t, h, map[string]any{"title": "Dune", "price": 99, "editions": []any{edition("hardcover", 25, ""), edition("ebook", 7.5, "")}})
+	if book.Price != 7.5 {
+		t.Errorf("price %v on create, want 7.5", book.Price)
+	}
+
+	tests := []struct {
+		method string
+		body   map[string]any
+		want   Price
+	}{
+		{http.MethodPut, map[string]any{"price": 1, "editions": []any{edition("hardcover", 25, ""), edition("paperback", 12, "")}}, 12},
+		{http.MethodPatch, map[string]any{"price": 1}, 12},
+		{http.MethodPatch, map[string]any{"editions": []any{edition("audiobook", 0, "")}}, 0},
+		{http.MethodPut, map[string]any{"price": 8, "editions": []any{}}, 8},
+	}
+	for _, tt := range tests {
+		rec := serve(t, h, tt.method, "/books/1", tt.body)
+		wantCode(t, rec, http.StatusOK)
+		if got := decode[Book](t, rec).Price; got != tt.want {
+			t.Errorf("%s %v: price %v, want %v", tt.method, tt.body, got, tt.want)
+		}
+	}
+}
+
+func TestEditionFormatFilter(t *testing.T) {
+	h := newTestServer(t)
+	mustCreateBook(t, h, map[string]any{"title": "Dune", "editions": []any{edition("hardcover", 25, ""), edition("ebook", 7, "")}})
+	mustCreateBook(t, h, map[string]any{"title": "Emma", "editions": []any{edition("paperback", 5, "")}})
+	mustCreateBook(t, h, map[string]any{"title": "Beloved", "price": 9})
+
+	tests := []struct {
+		query string
+		want  []int
+	}{
+		{"format=ebook", []int{1}},
+		{"format=paperback", []int{2}},
+		{"format=ebook,paperback", []int{1, 2}},
+		{"format=audiobook", []int{}},
+		{"format=hardcover&max_price=10", []int{1}},
+	}
+	for _, tt := range tests {
+		rec := serve(t, h, http.MethodGet, "/books?"+tt.query, nil)
+		wantCode(t, rec, http.StatusOK)
+		if got := bookIDs(decode[[]Book](t, rec)); !slices.Equal(got, tt.want) {
+			t.Errorf("?%s listed %v, want %v", tt.query, got, tt.want)
+		}
+	}
+	rec := serve(t, h, http.MethodGet, "/books?format=scroll", nil)
+	wantCode(t, rec, http.StatusBadRequest)
+}
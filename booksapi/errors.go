@@ -0,0 +1,104 @@
+package booksapi
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+)
+
+// apiError is an error reported to API clients. Code is a stable,
+// locale-independent identifier; the human-readable message is looked up in
+// the message catalog in the caller's language.
+type apiError struct {
+	Status int
+	Code   string
+	Params map[string]string
+	Fields []FieldError // every field failing validation, for 422 errors
+}
+
+// newAPIError returns an apiError. params holds alternating names and values
+// substituted into the message template.
+func newAPIError(status int, code string, params ...any) *apiError {
+	e := &apiError{Status: status, Code: code}
+	if len(params) > 0 {
+		e.Params = make(map[string]string, len(params)/2)
+		for i := 0; i+1 < len(params); i += 2 {
+			e.Params[fmt.Sprint(params[i])] = fmt.Sprint(params[i+1])
+		}
+	}
+	return e
+}
+
+// Error returns the English message of the error.
+func (e *apiError) Error() string {
+	return translate(defaultLocale, e.Code, e.Params)
+}
+
+// errorBody is the JSON envelope of an error response.
+type errorBody struct {
+	Error errorDetail `json:"error"`
+}
+
+// errorDetail describes an error in an error response.
+type errorDetail struct {
+	Code    string            `json:"code"`
+	Message string            `json:"message"`
+	Params  map[string]string `json:"params,omitempty"`
+	Errors  []FieldError      `json:"errors,omitempty"`
+}
+
+// writeError writes a structured error response. params holds alternating
+// names and values substituted into the message template.
+func writeError(w http.ResponseWriter, r *http.Request, status int, code string, params ...any) {
+	writeAPIError(w, r, newAPIError(status, code, params...))
+}
+
+// writeAPIError writes err as a structured error response, with the status
+// and code mapError gives it and the message translated for the request's
+// Accept-Language. Internal errors are logged with the request ID, which is
+// all the response tells of them.
+func writeAPIError(w http.ResponseWriter, r *http.Request, err error) {
+	e := asAPIError(err)
+	switch e.Status {
+	case http.StatusServiceUnavailable:
+		if errors.Is(err, ErrUnavailable) {
+			w.Header().Set("Retry-After", storeRetryAfter())
+		}
+	case http.StatusInternalServerError:
+		id := requestID(r)
+		if err != e {
+			logger.Printf("internal error serving request %s: %v", id, err)
+		}
+		e = newAPIError(http.StatusInternalServerError, "internal_error", "request_id", id)
+	}
+
+	locale := requestLocale(r)
+	if wantsProblem(r) {
+		writeJSONAs(w, e.Status, problemType, newProblemDetails(r, e, locale))
+		return
+	}
+	writeJSON(w, e.Status, errorBody{Error: newErrorDetail(e, locale)})
+}
+
+// newErrorDetail describes e with its messages in locale.
+func newErrorDetail(e *apiError, locale string) errorDetail {
+	return errorDetail{
+		Code:    e.Code,
+		Message: translate(locale, e.Code, e.Params),
+		Params:  e.Params,
+		Errors:  translateFieldErrors(locale, e.Fields),
+	}
+}
+
+// translateFieldErrors returns errs with their messages in locale.
+func translateFieldErrors(locale string, errs []FieldError) []FieldError {
+	if len(errs) == 0 {
+		return nil
+	}
+	translated := make([]FieldError, len(errs))
+	for i, fe := range errs {
+		fe.Message = translate(locale, ruleCodes[fe.Rule], fe.Params)
+		translated[i] = fe
+	}
+	return translated
+}
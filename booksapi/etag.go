@@ -0,0 +1,58 @@
+package booksapi
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"strings"
+)
+
+// requireIfMatch makes DELETE /books/{id} refuse requests without If-Match.
+var requireIfMatch bool
+
+// bookETag returns the entity tag of a book's current version. It is derived
+// from the book's stored fields, so any change produces a new tag.
+func bookETag(book Book) string {
+	data, _ := json.Marshal(book)
+	sum := sha256.Sum256(data)
+	return `"` + hex.EncodeToString(sum[:8]) + `"`
+}
+
+// etagListMatches reports whether etag appears in an If-Match or
+// If-None-Match header value. "*" matches any current version. Weak tags
+// only match when weak comparison is requested, as for If-None-Match.
+func etagListMatches(header, etag string, weak bool) bool {
+	if weak {
+		etag = strings.TrimPrefix(etag, "W/")
+	}
+	for _, candidate := range strings.Split(header, ",") {
+		candidate = strings.TrimSpace(candidate)
+		if weak {
+			candidate = strings.TrimPrefix(candidate, "W/")
+		}
+		if candidate == "*" || candidate == etag {
+			return true
+		}
+	}
+	return false
+}
+
+// checkIfMatch evaluates a request's If-Match header against the current
+// version of a book, for a write to it. It writes the error response and
+// returns false when the request must not proceed.
+func checkIfMatch(w http.ResponseWriter, r *http.Request, book Book) bool {
+	header := r.Header.Get("If-Match")
+	if header == "" {
+		if requireIfMatch && r.Method == http.MethodDelete {
+			writeError(w, r, http.StatusPreconditionRequired, "if_match_required")
+			return false
+		}
+		return true
+	}
+	if !etagListMatches(header, bookETag(book), false) {
+		writeError(w, r, http.StatusPreconditionFailed, "precondition_failed")
+		return false
+	}
+	return true
+}
@@ -0,0 +1,94 @@
+package booksapi
+
+import (
+	"net/http"
+	"testing"
+)
+
+// bookETagOf returns the ETag GET /books/{id} sends for the book at path.
+func bookETagOf(t *testing.T, h http.Handler, path string) string {
+	t.Helper()
+	rec := serve(t, h, http.MethodGet, path, nil)
+	wantCode(t, rec, http.StatusOK)
+	etag := rec.Header().Get("ETag")
+	if etag == "" {
+		t.Fatalf("GET %s sent no ETag", path)
+	}
+	return etag
+}
+
+// TestIfMatch checks that PUT, PATCH, and DELETE of a book with a stale
+// ETag in If-Match get 412 and leave the book alone, and that a matching
+// ETag, one of a list, or * lets them through.
+func TestIfMatch(t *testing.T) {
+	h := newTestServer(t)
+	mustCreateBook(t, h, map[string]any{"title": "Dune", "price": 7})
+	stale := bookETagOf(t, h, "/books/1")
+	wantCode(t, serve(t, h, http.MethodPatch, "/books/1", map[string]any{"price": 8}), http.StatusOK)
+	current := bookETagOf(t, h, "/books/1")
+	if current == stale {
+		t.Fatalf("ETag %s unchanged by an update", current)
+	}
+
+	for _, tt := range []struct {
+		method string
+		body   any
+	}{
+		{http.MethodPut, map[string]any{"title": "Dune Messiah"}},
+		{http.MethodPatch, map[string]any{"title": "Dune Messiah"}},
+		{http.MethodDelete, nil},
+	} {
+		for _, header := range []string{stale, "W/" + current, `"other", ` + stale} {
+			rec := serve(t, h, tt.method, "/books/1", tt.body, "If-Match", header)
+			wantCode(t, rec, http.StatusPreconditionFailed)
+			if got := errorCode(t, rec); got != "precondition_failed" {
+				t.Errorf("%s with If-Match %s: error code %q, want precondition_failed", tt.method, header, got)
+			}
+		}
+	}
+	if book, _ := store.Get(1); book.Title != "Dune" || book.Price != 8 {
+		t.Errorf("book after refused writes %+v, want it as it was", book)
+	}
+
+	rec := serve(t, h, http.MethodPut, "/books/1", map[string]any{"title": "Dune Messiah"}, "If-Match", `"other", `+current)
+	wantCode(t, rec, http.StatusOK)
+	if book, _ := store.Get(1); book.Title != "Dune Messiah" {
+		t.Errorf("title %q after the matching PUT, want Dune Messiah", book.Title)
+	}
+	if got := rec.Header().Get("ETag"); got == current || got != bookETagOf(t, h, "/books/1") {
+		t.Errorf("PUT sent ETag %s, want the new version's", got)
+	}
+	wantCode(t, serve(t, h, http.MethodPut, "/books/1", map[string]any{"title": "Dune"}, "If-Match", current), http.StatusPreconditionFailed)
+
+	wantCode(t, serve(t, h, http.MethodDelete, "/books/1", nil, "If-Match", bookETagOf(t, h, "/books/1")), http.StatusNoContent)
+	wantCode(t, serve(t, h, http.MethodGet, "/books/1", nil), http.StatusNotFound)
+}
+
+// TestIfMatchAny checks that If-Match: * writes to whatever version is
+// there, but not to a book that is not.
+func TestIfMatchAny(t *testing.T) {
+	h := newTestServer(t)
+	mustCreateBook(t, h, map[string]any{"title": "Dune"})
+	mustCreateBook(t, h, map[string]any{"title": "Emma"})
+
+	wantCode(t, serve(t, h, http.MethodPut, "/books/1", map[string]any{"title": "Dune Messiah"}, "If-Match", "*"), http.StatusOK)
+	wantCode(t, serve(t, h, http.MethodDelete, "/books/2", nil, "If-Match", "*"), http.StatusNoContent)
+	wantCode(t, serve(t, h, http.MethodDelete, "/books/2", nil, "If-Match", "*"), http.StatusNotFound)
+	wantCode(t, serve(t, h, http.MethodDelete, "/books/1", nil, "If-Match", "*"), http.StatusNoContent)
+}
+
+// TestRequireIfMatch checks that -require-if-match refuses deletes without
+// If-Match with 428, and leaves other writes unconditional.
+func TestRequireIfMatch(t *testing.T) {
+	h := newTestServer(t)
+	setForTest(t, &requireIfMatch, true)
+	mustCreateBook(t, h, map[string]any{"title": "Dune"})
+
+	rec := serve(t, h, http.MethodDelete, "/books/1", nil)
+	wantCode(t, rec, http.StatusPreconditionRequired)
+	if got := errorCode(t, rec); got != "if_match_required" {
+		t.Errorf("error code %q, want if_match_required", got)
+	}
+	wantCode(t, serve(t, h, http.MethodPut, "/books/1", map[string]any{"title": "Dune Messiah"}), http.StatusOK)
+	wantCode(t, serve(t, h, http.MethodDelete, "/books/1", nil, "If-Match", "*"), http.StatusNoContent)
+}
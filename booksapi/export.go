@@ -0,0 +1,350 @@
+package booksapi
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"expvar"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Scheduled export settings, configured by flags in Main. An empty
+// exportPath disables exports; an exportInterval of zero only exports on
+// POST /admin/export.
+var (
+	exportPath       string
+	exportInterval   time.Duration
+	exportRetention  = 7
+	exportRetries    = 3
+	canonicalExports bool // writes exports in canonical form
+)
+
+// exportBackoff is the wait before the first retry of a failed scheduled
+// export. It doubles with each further retry.
+var exportBackoff = 5 * time.Second
+
+// Export counters published under /debug/vars as exports.
+var exportMetrics = expvar.NewMap("exports")
+
+// exportPrefix and exportSuffix surround the UTC timestamp in the names of
+// export files, which therefore sort by age.
+const (
+	exportPrefix = "books-"
+	exportSuffix = ".json"
+)
+
+// exportTarget is where exports are written: a directory or an
+// S3-compatible bucket.
+type exportTarget interface {
+	// Put stores an export under name.
+	Put(ctx context.Context, name string, data []byte) error
+	// List returns the names of the stored exports.
+	List(ctx context.Context) ([]string, error)
+	// Delete removes the export stored under name.
+	Delete(ctx context.Context, name string) error
+	// String describes the target in logs and responses.
+	String() string
+}
+
+// newExportTarget returns the target for -export-path: an s3://bucket/prefix
+// URL or a directory.
+func newExportTarget(path string) (exportTarget, error) {
+	if strings.HasPrefix(path, "s3://") {
+		return newS3Target(path)
+	}
+	if err := os.MkdirAll(path, 0o755); err != nil {
+		return nil, err
+	}
+	return dirTarget(path), nil
+}
+
+// exportStatus reports the outcome of the last export, in /healthz and the
+// response of POST /admin/export.
+type exportStatus struct {
+	Target      string     `json:"target"`
+	LastAttempt *time.Time `json:"last_attempt,omitempty"`
+	LastSuccess *time.Time `json:"last_success,omitempty"`
+	LastExport  string     `json:"last_export,omitempty"` // name of the last export written
+	LastError   string     `json:"last_error,omitempty"`  // error of the last attempt, if it failed
+	Books       int        `json:"books"`
+}
+
+// exporter writes the catalog to its target every interval, keeping the
+// newest exportRetention exports. Failed scheduled exports are retried with
+// exponential backoff.
+type exporter struct {
+	target exportTarget
+
+	run sync.Mutex // serializes exports
+
+	mu     sync.Mutex
+	status exportStatus
+
+	stop chan struct{}
+	done chan struct{}
+}
+
+// exports is the running exporter, or nil when exports are disabled.
+var exports *exporter
+
+// startExporter returns an exporter writing to target every interval until
+// it is closed.
+func startExporter(target exportTarget, interval time.Duration) *exporter {
+	e := &exporter{
+		target: target,
+		status: exportStatus{Target: target.String()},
+		stop:   make(chan struct{}),
+		done:   make(chan struct{}),
+	}
+	go e.loop(interval)
+	return e
+}
+
+// loop exports every interval until the exporter is closed.
+func (e *exporter) loop(interval time.Duration) {
+	defer close(e.done)
+	if interval <= 0 {
+		<-e.stop
+		return
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			e.exportWithRetry()
+		case <-e.stop:
+			return
+		}
+	}
+}
+
+// exportWithRetry exports, retrying up to exportRetries times with a
+// doubling wait in between. It gives up early when the exporter is closed.
+func (e *exporter) exportWithRetry() {
+	wait := exportBackoff
+	for attempt := 0; ; attempt++ {
+		err := e.export(context.Background())
+		if err == nil {
+			return
+		}
+		if attempt >= exportRetries {
+			logger.Printf("ERROR export to %s failed, giving up after %d attempts: %v", e.target, attempt+1, err)
+			return
+		}
+		logger.Printf("WARN export to %s failed, retrying in %s: %v", e.target, wait, err)
+		select {
+		case <-time.After(wait):
+			wait *= 2
+		case <-e.stop:
+			return
+		}
+	}
+}
+
+// export writes every book to a new timestamped export and prunes the
+// exports beyond exportRetention, recording the outcome in the status.
+func (e *exporter) export(ctx context.Context) error {
+	e.run.Lock()
+	defer e.run.Unlock()
+
+	start := time.Now().UTC()
+	list := store.List(ListOptions{})
+	name, err := e.write(ctx, start, list)
+	if err == nil {
+		err = e.prune(ctx)
+	}
+
+	e.mu.Lock()
+	e.status.LastAttempt = &start
+	if err != nil {
+		e.status.LastError = err.Error()
+		exportMetrics.Add("failed", 1)
+	} else {
+		e.status.LastSuccess = &start
+		e.status.LastExport = name
+		e.status.LastError = ""
+		e.status.Books = len(list)
+		exportMetrics.Add("succeeded", 1)
+		exportMetrics.Set("last_success_unix", expvarInt(start.Unix()))
+	}
+	e.mu.Unlock()
+	return err
+}
+
+// write stores list as the export taken at t and returns its name.
+func (e *exporter) write(ctx context.Context, t time.Time, list []Book) (string, error) {
+	data, err := marshalExport(list)
+	if err != nil {
+		return "", err
+	}
+	name := exportPrefix + t.Format("20060102T150405.000Z") + exportSuffix
+	return name, e.target.Put(ctx, name, data)
+}
+
+// marshalExport encodes list as the backup document of an export, in
+// canonical form under -canonical-exports.
+func marshalExport(list []Book) ([]byte, error) {
+	if canonicalExports {
+		list = canonicalBooks(list)
+		sum, err := checksumBooks(list)
+		if err != nil {
+			return nil, err
+		}
+		var buf bytes.Buffer
+		if err := writeCanonicalBackup(&buf, list, sum); err != nil {
+			return nil, err
+		}
+		return buf.Bytes(), nil
+	}
+	doc, err := newBackupDocument(list)
+	if err != nil {
+		return nil, err
+	}
+	data, err := json.Marshal(doc)
+	if err != nil {
+		return nil, err
+	}
+	return append(data, '\n'), nil
+}
+
+// prune deletes every export but the newest exportRetention.
+func (e *exporter) prune(ctx context.Context) error {
+	if exportRetention <= 0 {
+		return nil
+	}
+	names, err := e.target.List(ctx)
+	if err != nil {
+		return err
+	}
+	var stored []string
+	for _, name := range names {
+		if strings.HasPrefix(name, exportPrefix) && strings.HasSuffix(name, exportSuffix) {
+			stored = append(stored, name)
+		}
+	}
+	sort.Strings(stored)
+	for len(stored) > exportRetention {
+		if err := e.target.Delete(ctx, stored[0]); err != nil {
+			return err
+		}
+		stored = stored[1:]
+	}
+	return nil
+}
+
+// Status returns the outcome of the last export.
+func (e *exporter) Status() exportStatus {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return e.status
+}
+
+// Close stops scheduled exports, waiting for a running one to finish.
+func (e *exporter) Close() error {
+	close(e.stop)
+	<-e.done
+	return nil
+}
+
+// expvarInt returns an expvar.Int holding n.
+func expvarInt(n int64) *expvar.Int {
+	v := new(expvar.Int)
+	v.Set(n)
+	return v
+}
+
+// adminExportHandler exports the catalog now, once, reporting the outcome
+// (POST /admin/export).
+func adminExportHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeError(w, r, http.StatusMethodNotAllowed, "method_not_allowed")
+		return
+	}
+	if _, ok := checkQuery(w, r); !ok {
+		return
+	}
+	if exports == nil {
+		writeError(w, r, http.StatusConflict, "exports_disabled")
+		return
+	}
+
+	if err := exports.export(r.Context()); err != nil {
+		writeError(w, r, http.StatusBadGateway, "export_failed", "reason", err.Error())
+		return
+	}
+	writeJSON(w, http.StatusOK, exports.Status())
+}
+
+// healthReport is the response body of GET /healthz.
+type healthReport struct {
+	Status  string         `json:"status"`
+	Export  *exportStatus  `json:"export,omitempty"`
+	Replica *replicaStatus `json:"replica,omitempty"`
+}
+
+// healthzHandler reports that the server is up, with the outcome of the
+// last export when exports are enabled and the lag of a replica behind its
+// primary (GET /healthz).
+func healthzHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		writeError(w, r, http.StatusMethodNotAllowed, "method_not_allowed")
+		return
+	}
+
+	report := healthReport{Status: "ok"}
+	if exports != nil {
+		status := exports.Status()
+		report.Export = &status
+	}
+	if replica != nil {
+		status := replica.Status()
+		report.Replica = &status
+	}
+	writeJSON(w, http.StatusOK, report)
+}
+
+// dirTarget stores exports as files in a directory.
+type dirTarget string
+
+func (d dirTarget) Put(_ context.Context, name string, data []byte) error {
+	// The export is written under a temporary name first, so a crash never
+	// leaves a truncated export behind.
+	tmp := filepath.Join(string(d), "."+name+".tmp")
+	if err := os.WriteFile(tmp, data, 0o644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, filepath.Join(string(d), name))
+}
+
+func (d dirTarget) List(context.Context) ([]string, error) {
+	entries, err := os.ReadDir(string(d))
+	if err != nil {
+		return nil, err
+	}
+	names := make([]string, 0, len(entries))
+	for _, entry := range entries {
+		if entry.Type().IsRegular() {
+			names = append(names, entry.Name())
+		}
+	}
+	return names, nil
+}
+
+func (d dirTarget) Delete(_ context.Context, name string) error {
+	err := os.Remove(filepath.Join(string(d), name))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	return err
+}
+
+func (d dirTarget) String() string { return string(d) }
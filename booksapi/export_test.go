@@ -0,0 +1,312 @@
+package booksapi
+
+import (
+	"bytes"
+	"context"
+	"encoding/xml"
+	"errors"
+	"expvar"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// startTestExporter starts an exporter to target as the running one, and
+// closes it at the end of the test.
+func startTestExporter(t *testing.T, target exportTarget, interval time.Duration) *exporter {
+	t.Helper()
+	e := startExporter(target, interval)
+	setForTest(t, &exports, e)
+	t.Cleanup(func() { e.Close() })
+	return e
+}
+
+// exportNames returns the names of the exports stored in dir, oldest
+// first.
+func exportNames(t *testing.T, dir string) []string {
+	t.Helper()
+	names, err := dirTarget(dir).List(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// exportCount returns the exports counted under key so far.
+func exportCount(t *testing.T, key string) int64 {
+	t.Helper()
+	v, ok := exportMetrics.Get(key).(*expvar.Int)
+	if !ok {
+		return 0
+	}
+	return v.Value()
+}
+
+// TestExportDirectory exports to a directory on demand and checks that the
+// newest exportRetention exports are kept, each a backup of the catalog of
+// its time, and that the outcome is reported in /healthz.
+func TestExportDirectory(t *testing.T) {
+	h := newTestServer(t)
+	rec := serve(t, h, http.MethodPost, "/admin/export", nil)
+	wantCode(t, rec, http.StatusConflict)
+	if got := errorCode(t, rec); got != "exports_disabled" {
+		t.Errorf("error code %q, want exports_disabled", got)
+	}
+
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "notes.txt"), nil, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	setForTest(t, &exportRetention, 2)
+	target, err := newExportTarget(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	startTestExporter(t, target, 0)
+	succeeded := exportCount(t, "succeeded")
+
+	var written []string
+	for _, title := range []string{"Dune", "Emma", "Jazz"} {
+		mustCreateBook(t, h, map[string]any{"title": title})
+		time.Sleep(2 * time.Millisecond) // export names are timestamped to the millisecond
+		rec := serve(t, h, http.MethodPost, "/admin/export", nil)
+		wantCode(t, rec, http.StatusOK)
+		status := decode[exportStatus](t, rec)
+		if status.Target != dir || status.LastSuccess == nil || status.LastError != "" || status.Books != len(written)+1 {
+			t.Errorf("export status %+v", status)
+		}
+		written = append(written, status.LastExport)
+	}
+	if got, want := exportNames(t, dir), append(slices.Clone(written[1:]), "notes.txt"); !slices.Equal(got, want) {
+		t.Errorf("exports kept %q, want %q", got, want)
+	}
+	if n, err := checkBackupFile(filepath.Join(dir, written[2])); err != nil || n != 3 {
+		t.Errorf("last export holds %d books (%v), want 3", n, err)
+	}
+	if n, err := checkBackupFile(filepath.Join(dir, written[1])); err != nil || n != 2 {
+		t.Errorf("previous export holds %d books (%v), want 2", n, err)
+	}
+	if got := exportCount(t, "succeeded") - succeeded; got != 3 {
+		t.Errorf("%d exports counted, want 3", got)
+	}
+
+	health := decode[healthReport](t, serve(t, h, http.MethodGet, "/healthz", nil))
+	if health.Export == nil || health.Export.LastExport != written[2] || health.Export.Books != 3 {
+		t.Errorf("health export status %+v", health.Export)
+	}
+}
+
+// TestExportSchedule checks that exports are written every interval until
+// the exporter is closed.
+func TestExportSchedule(t *testing.T) {
+	h := newTestServer(t)
+	mustCreateBook(t, h, map[string]any{"title": "Dune"})
+	dir := t.TempDir()
+	e := startExporter(dirTarget(dir), 5*time.Millisecond)
+
+	deadline := time.Now().Add(5 * time.Second)
+	for len(exportNames(t, dir)) < 2 && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+	if got := len(exportNames(t, dir)); got < 2 {
+		e.Close()
+		t.Fatalf("%d exports written, want at least 2", got)
+	}
+	e.Close()
+	n := len(exportNames(t, dir))
+	time.Sleep(20 * time.Millisecond)
+	if got := len(exportNames(t, dir)); got != n {
+		t.Errorf("%d exports written after closing, %d before", got, n)
+	}
+}
+
+// flakyTarget fails the first failures puts to a directory.
+type flakyTarget struct {
+	dirTarget
+	mu       sync.Mutex
+	failures int
+	attempts int
+}
+
+func (f *flakyTarget) Put(ctx context.Context, name string, data []byte) error {
+	f.mu.Lock()
+	f.attempts++
+	fail := f.attempts <= f.failures
+	f.mu.Unlock()
+	if fail {
+		return errors.New("disk full")
+	}
+	return f.dirTarget.Put(ctx, name, data)
+}
+
+// TestExportRetry checks that a failed scheduled export is retried with
+// backoff until it succeeds, or given up after exportRetries retries, and
+// that a failed export on demand gets 502.
+func TestExportRetry(t *testing.T) {
+	var logs bytes.Buffer
+	h := newTestServer(t, WithLogger(log.New(&logs, "", 0)))
+	setForTest(t, &exportBackoff, time.Millisecond)
+	setForTest(t, &exportRetries, 3)
+
+	recovering := &flakyTarget{dirTarget: dirTarget(t.TempDir()), failures: 2}
+	e := startTestExporter(t, recovering, 0)
+	e.exportWithRetry()
+	if status := e.Status(); recovering.attempts != 3 || status.LastError != "" || status.LastExport == "" {
+		t.Errorf("%d attempts, status %+v; want success at the third", recovering.attempts, status)
+	}
+	if got := strings.Count(logs.String(), "WARN export to"); got != 2 {
+		t.Errorf("%d retries logged, want 2:\n%s", got, logs.String())
+	}
+
+	broken := &flakyTarget{dirTarget: dirTarget(t.TempDir()), failures: 100}
+	e = startTestExporter(t, broken, 0)
+	e.exportWithRetry()
+	if status := e.Status(); broken.attempts != 4 || status.LastError != "disk full" || status.LastSuccess != nil {
+		t.Errorf("%d attempts, status %+v; want 4 failed attempts", broken.attempts, status)
+	}
+	if !strings.Contains(logs.String(), "giving up after 4 attempts: disk full") {
+		t.Errorf("giving up not logged:\n%s", logs.String())
+	}
+
+	rec := serve(t, h, http.MethodPost, "/admin/export", nil)
+	wantCode(t, rec, http.StatusBadGateway)
+	if got := decode[errorBody](t, rec).Error; got.Code != "export_failed" || got.Params["reason"] != "disk full" {
+		t.Errorf("error %s %v, want export_failed", got.Code, got.Params)
+	}
+	if broken.attempts != 5 {
+		t.Errorf("%d attempts after the export on demand, want 5: it is not retried", broken.attempts)
+	}
+}
+
+// fakeS3 is an S3 bucket in memory, answering the requests of s3Target and
+// listing two objects per page.
+type fakeS3 struct {
+	t       *testing.T
+	bucket  string
+	mu      sync.Mutex
+	objects map[string][]byte
+}
+
+func (s *fakeS3) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	auth := r.Header.Get("Authorization")
+	if !strings.HasPrefix(auth, "AWS4-HMAC-SHA256 Credential=AKID/") || !strings.Contains(auth, "/eu-west-1/s3/aws4_request, SignedHeaders=host;x-amz-content-sha256;x-amz-date, Signature=") {
+		http.Error(w, "bad signature", http.StatusForbidden)
+		return
+	}
+	body, _ := io.ReadAll(r.Body)
+	if r.Header.Get("X-Amz-Content-Sha256") != sha256Hex(body) {
+		http.Error(w, "bad payload hash", http.StatusBadRequest)
+		return
+	}
+	key, ok := strings.CutPrefix(r.URL.Path, "/"+s.bucket)
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+	key = strings.TrimPrefix(key, "/")
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	switch {
+	case r.Method == http.MethodPut && key != "":
+		s.objects[key] = body
+	case r.Method == http.MethodDelete && key != "":
+		delete(s.objects, key)
+		w.WriteHeader(http.StatusNoContent)
+	case r.Method == http.MethodGet && key == "" && r.URL.Query().Get("list-type") == "2":
+		var keys []string
+		for k := range s.objects {
+			if strings.HasPrefix(k, r.URL.Query().Get("prefix")) && k > r.URL.Query().Get("continuation-token") {
+				keys = append(keys, k)
+			}
+		}
+		sort.Strings(keys)
+		fmt.Fprint(w, "<ListBucketResult>")
+		for i, k := range keys {
+			if i == 2 {
+				fmt.Fprintf(w, "<IsTruncated>true</IsTruncated><NextContinuationToken>%s</NextContinuationToken>", keys[1])
+				break
+			}
+			fmt.Fprint(w, "<Contents><Key>")
+			xml.EscapeText(w, []byte(k))
+			fmt.Fprint(w, "</Key></Contents>")
+		}
+		fmt.Fprint(w, "</ListBucketResult>")
+	default:
+		http.Error(w, "unexpected request", http.StatusMethodNotAllowed)
+	}
+}
+
+// TestExportS3 exports to a fake S3 bucket, and checks that requests are
+// signed, that listings are followed across pages, and that only the
+// exports under the prefix are pruned.
+func TestExportS3(t *testing.T) {
+	h := newTestServer(t)
+	bucket := &fakeS3{t: t, bucket: "books", objects: map[string][]byte{
+		"backups/books-20000101T000000.000Z.json":        []byte("{}"),
+		"backups/books-20000102T000000.000Z.json":        []byte("{}"),
+		"backups/nested/books-20000101T000000.000Z.json": []byte("{}"),
+		"other/books-20000101T000000.000Z.json":          []byte("{}"),
+	}}
+	server := httptest.NewServer(bucket)
+	t.Cleanup(server.Close)
+	setForTest(t, &s3Client, httpDoer(server.Client()))
+	setForTest(t, &exportS3Endpoint, server.URL)
+	setForTest(t, &exportRetention, 2)
+	t.Setenv("AWS_REGION", "eu-west-1")
+	t.Setenv("AWS_ACCESS_KEY_ID", "AKID")
+	t.Setenv("AWS_SECRET_ACCESS_KEY", "secret")
+
+	target, err := newExportTarget("s3://books/backups")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := target.String(); got != "s3://books/backups/" {
+		t.Errorf("target %q", got)
+	}
+	startTestExporter(t, target, 0)
+	mustCreateBook(t, h, map[string]any{"title": "Dune"})
+	rec := serve(t, h, http.MethodPost, "/admin/export", nil)
+	wantCode(t, rec, http.StatusOK)
+	name := decode[exportStatus](t, rec).LastExport
+
+	bucket.mu.Lock()
+	var keys []string
+	for k := range bucket.objects {
+		keys = append(keys, k)
+	}
+	data := bucket.objects["backups/"+name]
+	bucket.mu.Unlock()
+	sort.Strings(keys)
+	want := []string{"backups/books-20000102T000000.000Z.json", "backups/" + name, "backups/nested/books-20000101T000000.000Z.json", "other/books-20000101T000000.000Z.json"}
+	if !slices.Equal(keys, want) {
+		t.Errorf("bucket holds %q, want %q", keys, want)
+	}
+	if !bytes.Contains(data, []byte(`"title":"Dune"`)) {
+		t.Errorf("export %s holds %s", name, data)
+	}
+
+	for _, tt := range []struct{ path, want string }{
+		{"s3://", "want s3://bucket/prefix"},
+		{"s3:///backups", "want s3://bucket/prefix"},
+	} {
+		if _, err := newExportTarget(tt.path); err == nil || !strings.Contains(err.Error(), tt.want) {
+			t.Errorf("newExportTarget(%q): %v, want %q", tt.path, err, tt.want)
+		}
+	}
+	t.Setenv("AWS_SECRET_ACCESS_KEY", "")
+	if _, err := newExportTarget("s3://books"); err == nil {
+		t.Error("S3 target made without credentials")
+	}
+}
@@ -0,0 +1,159 @@
+package booksapi
+
+import (
+	"net/http"
+	"strings"
+	"sync"
+)
+
+// userHeader identifies the calling user when no tokens are configured.
+const userHeader = "X-User"
+
+// Global variables to store each user's favorite books. The favorites have
+// their own lock so toggling them does not contend with the book map; when
+// both are needed, mu or the book's lock is taken before favoritesMu.
+var (
+	favorites      = make(map[string]map[int]struct{}) // user -> set of book IDs
+	favoriteCounts = make(map[int]int)                 // book ID -> number of users
+	favoritesMu    sync.Mutex
+)
+
+// currentUser returns the user making the request, or "" if none is known.
+// Once tokens are configured, the user is the one the caller's token
+// authenticates, and the X-User header is ignored, as anyone may send it.
+func currentUser(r *http.Request) string {
+	if len(currentConfig().tokens) > 0 {
+		token, ok := lookupToken(r)
+		if !ok {
+			return ""
+		}
+		return token.userName()
+	}
+	return strings.TrimSpace(r.Header.Get(userHeader))
+}
+
+// favoritesHandler handles the calling user's favorites (/me/favorites).
+func favoritesHandler(w http.ResponseWriter, r *http.Request) {
+	user := currentUser(r)
+	if user == "" {
+		writeError(w, r, http.StatusUnauthorized, "authentication_required")
+		return
+	}
+
+	segments := pathSegments(r.URL.Path)
+	switch len(segments) {
+	case 2:
+		if r.Method != http.MethodGet {
+			writeError(w, r, http.StatusMethodNotAllowed, "method_not_allowed")
+			return
+		}
+		getFavorites(w, r, user)
+	case 3:
+		bookID, err := parseResourceID(segments[2], "invalid_book_id")
+		if err != nil {
+			writeAPIError(w, r, err)
+			return
+		}
+		if _, ok := checkQuery(w, r); !ok {
+			return
+		}
+		switch r.Method {
+		case http.MethodPut:
+			addFavorite(w, r, user, bookID)
+		case http.MethodDelete:
+			removeFavorite(w, user, bookID)
+		default:
+			writeError(w, r, http.StatusMethodNotAllowed, "method_not_allowed")
+		}
+	default:
+		writeError(w, r, http.StatusNotFound, "not_found")
+	}
+}
+
+// getFavorites lists the user's favorite books, ordered by ID and paginated.
+func getFavorites(w http.ResponseWriter, r *http.Request, user string) {
+	q, ok := checkQuery(w, r, bookListParams...)
+	if !ok {
+		return
+	}
+
+	favoritesMu.Lock()
+	bookList := make([]Book, 0, len(favorites[user]))
+	for bookID := range favorites[user] {
+		if book, ok := store.Get(bookID); ok {
+			bookList = append(bookList, book)
+		}
+	}
+	favoritesMu.Unlock()
+
+	sortBooksByID(bookList)
+	writeJSON(w, http.StatusOK, renderBooks(r, paginateQuery(w, r, q, PagingPolicy{}, bookList)))
+}
+
+// addFavorite marks a book as a favorite of the user. Favoriting a book
+// twice succeeds without changing anything.
+func addFavorite(w http.ResponseWriter, r *http.Request, user string, bookID int) {
+	defer lockBook(bookID)()
+
+	if _, err := findBook(bookID); err != nil {
+		writeAPIError(w, r, err)
+		return
+	}
+
+	favoritesMu.Lock()
+	defer favoritesMu.Unlock()
+
+	set, ok := favorites[user]
+	if !ok {
+		set = make(map[int]struct{})
+		favorites[user] = set
+	}
+	if _, ok := set[bookID]; !ok {
+		set[bookID] = struct{}{}
+		favoriteCounts[bookID]++
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// removeFavorite unmarks a book as a favorite of the user. Removing a book
+// that is not a favorite succeeds without changing anything.
+func removeFavorite(w http.ResponseWriter, user string, bookID int) {
+	favoritesMu.Lock()
+	defer favoritesMu.Unlock()
+
+	if _, ok := favorites[user][bookID]; ok {
+		delete(favorites[user], bookID)
+		decrementFavoriteCount(bookID)
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// removeBookFromFavorites drops a deleted book from every user's favorites.
+func removeBookFromFavorites(bookID int) {
+	favoritesMu.Lock()
+	defer favoritesMu.Unlock()
+
+	for _, set := range favorites {
+		if _, ok := set[bookID]; ok {
+			delete(set, bookID)
+			decrementFavoriteCount(bookID)
+		}
+	}
+}
+
+// favoriteCount returns how many users have favorited a book.
+func favoriteCount(bookID int) int {
+	favoritesMu.Lock()
+	defer favoritesMu.Unlock()
+	return favoriteCounts[bookID]
+}
+
+// decrementFavoriteCount lowers a book's favorite count, dropping the entry
+// once it reaches zero. Callers must hold favoritesMu.
+func decrementFavoriteCount(bookID int) {
+	if favoriteCounts[bookID] <= 1 {
+		delete(favoriteCounts, bookID)
+		return
+	}
+	favoriteCounts[bookID]--
+}
@@ -0,0 +1,85 @@
+package booksapi
+
+import (
+	"net/http"
+	"slices"
+	"testing"
+)
+
+// favoriteIDs returns the IDs of user's favorite books.
+func favoriteIDs(t *testing.T, h http.Handler, user string) []int {
+	t.Helper()
+	rec := serve(t, h, http.MethodGet, "/me/favorites", nil, "X-User", user)
+	wantCode(t, rec, http.StatusOK)
+	ids := []int{}
+	for _, book := range decode[[]Book](t, rec) {
+		ids = append(ids, book.ID)
+	}
+	return ids
+}
+
+// favoritesCount returns the favorites count GET /books/{id} reports.
+func favoritesCount(t *testing.T, h http.Handler, path string) int {
+	t.Helper()
+	rec := serve(t, h, http.MethodGet, path, nil)
+	wantCode(t, rec, http.StatusOK)
+	return decode[bookResponse](t, rec).FavoritesCount
+}
+
+// TestFavoritesTwoUsers checks that two users favoriting the same book each
+// keep their own favorites, and that the book counts both.
+func TestFavoritesTwoUsers(t *testing.T) {
+	h := newTestServer(t)
+	mustCreateBook(t, h, map[string]any{"title": "Dune"})
+	mustCreateBook(t, h, map[string]any{"title": "Emma"})
+
+	for _, user := range []string{"ann", "bob", "bob"} {
+		wantCode(t, serve(t, h, http.MethodPut, "/me/favorites/1", nil, "X-User", user), http.StatusNoContent)
+	}
+	wantCode(t, serve(t, h, http.MethodPut, "/me/favorites/2", nil, "X-User", "bob"), http.StatusNoContent)
+	if got := favoritesCount(t, h, "/books/1"); got != 2 {
+		t.Errorf("Dune favorited by %d users, want 2", got)
+	}
+	if got := favoriteIDs(t, h, "ann"); !slices.Equal(got, []int{1}) {
+		t.Errorf("ann's favorites %v, want [1]", got)
+	}
+	if got := favoriteIDs(t, h, "bob"); !slices.Equal(got, []int{1, 2}) {
+		t.Errorf("bob's favorites %v, want [1 2]", got)
+	}
+
+	wantCode(t, serve(t, h, http.MethodDelete, "/me/favorites/1", nil, "X-User", "ann"), http.StatusNoContent)
+	wantCode(t, serve(t, h, http.MethodDelete, "/me/favorites/1", nil, "X-User", "ann"), http.StatusNoContent)
+	if got := favoritesCount(t, h, "/books/1"); got != 1 {
+		t.Errorf("Dune favorited by %d users after ann's removal, want 1", got)
+	}
+	if got := favoriteIDs(t, h, "bob"); !slices.Equal(got, []int{1, 2}) {
+		t.Errorf("bob's favorites %v after ann's removal, want [1 2]", got)
+	}
+	wantCode(t, serve(t, h, http.MethodPut, "/me/favorites/99", nil, "X-User", "ann"), http.StatusNotFound)
+}
+
+// TestFavoritesBookDeleted checks that deleting a book drops it from the
+// favorites of every user, and its count with it.
+func TestFavoritesBookDeleted(t *testing.T) {
+	h := newTestServer(t)
+	mustCreateBook(t, h, map[string]any{"title": "Dune"})
+	mustCreateBook(t, h, map[string]any{"title": "Emma"})
+	for _, user := range []string{"ann", "bob"} {
+		for _, path := range []string{"/me/favorites/1", "/me/favorites/2"} {
+			wantCode(t, serve(t, h, http.MethodPut, path, nil, "X-User", user), http.StatusNoContent)
+		}
+	}
+
+	wantCode(t, serve(t, h, http.MethodDelete, "/books/1", nil), http.StatusNoContent)
+	for _, user := range []string{"ann", "bob"} {
+		if got := favoriteIDs(t, h, user); !slices.Equal(got, []int{2}) {
+			t.Errorf("%s's favorites %v after the delete, want [2]", user, got)
+		}
+	}
+	if got := favoriteCount(1); got != 0 {
+		t.Errorf("deleted book counted as favorited by %d users", got)
+	}
+	if got := favoritesCount(t, h, "/books/2"); got != 2 {
+		t.Errorf("Emma favorited by %d users, want 2", got)
+	}
+}
@@ -0,0 +1,228 @@
+package booksapi
+
+import (
+	"encoding/xml"
+	"net/http"
+	"sort"
+	"strconv"
+	"time"
+)
+
+// feedSize is the number of books in the new arrivals feeds.
+const feedSize = 20
+
+// feedTitle is the title of the new arrivals feeds.
+const feedTitle = "New books"
+
+// atomFeed is an Atom feed (RFC 4287).
+type atomFeed struct {
+	XMLName xml.Name    `xml:"http://www.w3.org/2005/Atom feed"`
+	Title   string      `xml:"title"`
+	ID      string      `xml:"id"`
+	Updated string      `xml:"updated"`
+	Link    atomLink    `xml:"link"`
+	Author  atomAuthor  `xml:"author"`
+	Entries []atomEntry `xml:"entry"`
+}
+
+type atomLink struct {
+	Href string `xml:"href,attr"`
+	Rel  string `xml:"rel,attr,omitempty"`
+	Type string `xml:"type,attr,omitempty"`
+}
+
+type atomAuthor struct {
+	Name string `xml:"name"`
+}
+
+type atomEntry struct {
+	Title   string      `xml:"title"`
+	ID      string      `xml:"id"`
+	Link    atomLink    `xml:"link"`
+	Updated string      `xml:"updated"`
+	Author  *atomAuthor `xml:"author,omitempty"`
+	Summary string      `xml:"summary,omitempty"`
+}
+
+// rssFeed is an RSS 2.0 feed. Authors are given as dc:creator, since the
+// RSS author element must hold an email address.
+type rssFeed struct {
+	XMLName xml.Name   `xml:"rss"`
+	Version string     `xml:"version,attr"`
+	AtomNS  string     `xml:"xmlns:atom,attr"`
+	DCNS    string     `xml:"xmlns:dc,attr"`
+	Channel rssChannel `xml:"channel"`
+}
+
+type rssChannel struct {
+	Title       string    `xml:"title"`
+	Link        string    `xml:"link"`
+	Description string    `xml:"description"`
+	Self        atomLink  `xml:"atom:link"`
+	Items       []rssItem `xml:"item"`
+}
+
+type rssItem struct {
+	Title   string  `xml:"title"`
+	Link    string  `xml:"link"`
+	GUID    rssGUID `xml:"guid"`
+	Creator string  `xml:"dc:creator,omitempty"`
+	PubDate string  `xml:"pubDate,omitempty"`
+}
+
+type rssGUID struct {
+	Value       string `xml:",chardata"`
+	IsPermaLink bool   `xml:"isPermaLink,attr"`
+}
+
+// getAtomFeed serves the most recently added books as an Atom feed
+// (GET /books/feed.atom). Atom dates every entry, so books without
+// timestamps are dated as the feed, and a feed of no such books at the
+// time it is served, as the price drop feed is.
+func getAtomFeed(w http.ResponseWriter, r *http.Request) {
+	list, modified, ok := prepareFeed(w, r)
+	if !ok {
+		return
+	}
+	if modified.IsZero() {
+		modified = time.Now().UTC()
+	}
+
+	feed := atomFeed{
+		Title:   feedTitle,
+		ID:      absoluteURL(r, "/books"),
+		Updated: modified.Format(time.RFC3339),
+		Link:    atomLink{Href: absoluteURL(r, r.URL.Path), Rel: "self", Type: "application/atom+xml"},
+		Author:  atomAuthor{Name: "Books API"},
+	}
+	for _, book := range list {
+		url := absoluteURL(r, "/books/"+strconv.Itoa(book.ID))
+		updated := bookModified(book)
+		if updated.IsZero() {
+			updated = modified
+		}
+		entry := atomEntry{
+			Title:   book.Title,
+			ID:      url,
+			Link:    atomLink{Href: url},
+			Updated: updated.Format(time.RFC3339),
+		}
+		if book.Author != "" {
+			entry.Author = &atomAuthor{Name: book.Author}
+		}
+		feed.Entries = append(feed.Entries, entry)
+	}
+	writeXML(w, "application/atom+xml; charset=utf-8", feed)
+}
+
+// getRSSFeed serves the most recently added books as an RSS feed
+// (GET /books/feed.rss).
+func getRSSFeed(w http.ResponseWriter, r *http.Request) {
+	list, _, ok := prepareFeed(w, r)
+	if !ok {
+		return
+	}
+
+	feed := rssFeed{
+		Version: "2.0",
+		AtomNS:  "http://www.w3.org/2005/Atom",
+		DCNS:    "http://purl.org/dc/elements/1.1/",
+		Channel: rssChannel{
+			Title:       feedTitle,
+			Link:        absoluteURL(r, "/books"),
+			Description: "The most recently added books",
+			Self:        atomLink{Href: absoluteURL(r, r.URL.Path), Rel: "self", Type: "application/rss+xml"},
+		},
+	}
+	for _, book := range list {
+		url := absoluteURL(r, "/books/"+strconv.Itoa(book.ID))
+		item := rssItem{
+			Title:   book.Title,
+			Link:    url,
+			GUID:    rssGUID{Value: url, IsPermaLink: true},
+			Creator: book.Author,
+		}
+		if book.CreatedAt != nil {
+			item.PubDate = book.CreatedAt.Format(time.RFC1123Z)
+		}
+		feed.Channel.Items = append(feed.Channel.Items, item)
+	}
+	writeXML(w, "application/rss+xml; charset=utf-8", feed)
+}
+
+// prepareFeed selects the books of a feed, newest first, and handles
+// If-Modified-Since. It returns false if the response has been written.
+func prepareFeed(w http.ResponseWriter, r *http.Request) ([]Book, time.Time, bool) {
+	if _, ok := checkQuery(w, r); !ok {
+		return nil, time.Time{}, false
+	}
+
+	list := recentBooks(store.List(ListOptions{}), feedSize)
+	var modified time.Time
+	for _, book := range list {
+		if t := bookModified(book); t.After(modified) {
+			modified = t
+		}
+	}
+	modified = modified.Truncate(time.Second)
+
+	if !modified.IsZero() {
+		w.Header().Set("Last-Modified", modified.Format(http.TimeFormat))
+		if since, err := http.ParseTime(r.Header.Get("If-Modified-Since")); err == nil && !modified.After(since) {
+			w.WriteHeader(http.StatusNotModified)
+			return nil, time.Time{}, false
+		}
+	}
+	return list, modified, true
+}
+
+// recentBooks returns the n most recently created books of list, newest
+// first. Books without a creation time sort last, newest ID first.
+func recentBooks(list []Book, n int) []Book {
+	sort.SliceStable(list, func(i, j int) bool {
+		a, b := list[i].CreatedAt, list[j].CreatedAt
+		switch {
+		case a != nil && b != nil && !a.Equal(*b):
+			return a.After(*b)
+		case (a == nil) != (b == nil):
+			return a != nil
+		default:
+			return list[i].ID > list[j].ID
+		}
+	})
+	return paginate(list, n, 0)
+}
+
+// bookModified returns when a book last changed, or the zero time for
+// books without timestamps.
+func bookModified(book Book) time.Time {
+	switch {
+	case book.UpdatedAt != nil:
+		return *book.UpdatedAt
+	case book.CreatedAt != nil:
+		return *book.CreatedAt
+	}
+	return time.Time{}
+}
+
+// absoluteURL returns the absolute URL of the API path on the host the
+// request was sent to.
+func absoluteURL(r *http.Request, path string) string {
+	scheme := "http"
+	if r.TLS != nil {
+		scheme = "https"
+	}
+	return scheme + "://" + r.Host + apiPath(path)
+}
+
+// writeXML writes v as an XML document with the given media type.
+func writeXML(w http.ResponseWriter, contentType string, v any) {
+	data, err := xml.MarshalIndent(v, "", "  ")
+	if err != nil {
+		writeAPIError(w, nil, err)
+		return
+	}
+	w.Header().Set("Content-Type", contentType)
+	w.Write([]byte(xml.Header))
+	w.Write(data)
+}
@@ -0,0 +1,118 @@
+package booksapi
+
+import (
+	"encoding/xml"
+	"net/http"
+	"slices"
+	"strconv"
+	"testing"
+	"time"
+)
+
+// putFeedBooks stores books created an hour apart from feedEpoch in the
+// given order, the first one without timestamps, as books stored before
+// they were recorded.
+func putFeedBooks(t *testing.T, titles ...string) {
+	t.Helper()
+	for i, title := range titles {
+		book := Book{ID: i + 1, Title: title, Author: "Author " + strconv.Itoa(i+1)}
+		if i > 0 {
+			created := feedEpoch.Add(time.Duration(i) * time.Hour)
+			book.CreatedAt = &created
+		}
+		if err := store.Put(book); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+// feedEpoch is the creation time of the first book of putFeedBooks.
+var feedEpoch = time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+
+// TestFeeds pins the Atom and RSS feeds of a small catalog: newest book
+// first, a book updated since it was created dated by its update, and the
+// book without timestamps last, dated as the feed in Atom.
+func TestFeeds(t *testing.T) {
+	h := newTestServer(t)
+	putFeedBooks(t, "Old Book", "Dune", "Emma", "Jazz")
+	updated := feedEpoch.Add(24 * time.Hour)
+	emma, _ := store.Get(3)
+	emma.UpdatedAt, emma.Author = &updated, ""
+	if err := store.Put(emma); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tt := range []struct {
+		path, contentType, golden string
+	}{
+		{"/books/feed.atom", "application/atom+xml; charset=utf-8", "feed/books.atom"},
+		{"/books/feed.rss", "application/rss+xml; charset=utf-8", "feed/books.rss"},
+	} {
+		rec := serve(t, h, http.MethodGet, tt.path, nil)
+		wantCode(t, rec, http.StatusOK)
+		if got := rec.Header().Get("Content-Type"); got != tt.contentType {
+			t.Errorf("GET %s: Content-Type %q, want %q", tt.path, got, tt.contentType)
+		}
+		if got, want := rec.Header().Get("Last-Modified"), updated.Format(http.TimeFormat); got != want {
+			t.Errorf("GET %s: Last-Modified %q, want %q", tt.path, got, want)
+		}
+		checkGoldenBytes(t, tt.golden, rec.Body.Bytes())
+
+		rec = serve(t, h, http.MethodGet, tt.path, nil, "If-Modified-Since", updated.Format(http.TimeFormat))
+		wantCode(t, rec, http.StatusNotModified)
+		rec = serve(t, h, http.MethodGet, tt.path, nil, "If-Modified-Since", updated.Add(-time.Second).Format(http.TimeFormat))
+		wantCode(t, rec, http.StatusOK)
+		wantCode(t, serve(t, h, http.MethodHead, tt.path, nil), http.StatusOK)
+	}
+}
+
+// TestFeedSize checks that the feeds hold the feedSize newest books.
+func TestFeedSize(t *testing.T) {
+	h := newTestServer(t)
+	var titles []string
+	for i := range feedSize + 5 {
+		titles = append(titles, "Book "+strconv.Itoa(i+1))
+	}
+	putFeedBooks(t, titles...)
+
+	var want []string
+	for i := len(titles); i > len(titles)-feedSize; i-- {
+		want = append(want, "Book "+strconv.Itoa(i))
+	}
+	var atom atomFeed
+	if err := xml.Unmarshal(serve(t, h, http.MethodGet, "/books/feed.atom", nil).Body.Bytes(), &atom); err != nil {
+		t.Fatal(err)
+	}
+	if got := entryTitles(atom); !slices.Equal(got, want) {
+		t.Errorf("Atom entries %q, want %q", got, want)
+	}
+	var rss rssFeed
+	if err := xml.Unmarshal(serve(t, h, http.MethodGet, "/books/feed.rss", nil).Body.Bytes(), &rss); err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for _, item := range rss.Channel.Items {
+		got = append(got, item.Title)
+	}
+	if !slices.Equal(got, want) {
+		t.Errorf("RSS items %q, want %q", got, want)
+	}
+}
+
+// TestFeedsEmpty checks that the feed of an empty catalog is valid, dated
+// when it is served, and carries no Last-Modified.
+func TestFeedsEmpty(t *testing.T) {
+	h := newTestServer(t)
+	rec := serve(t, h, http.MethodGet, "/books/feed.atom", nil)
+	wantCode(t, rec, http.StatusOK)
+	var feed atomFeed
+	if err := xml.Unmarshal(rec.Body.Bytes(), &feed); err != nil || len(feed.Entries) != 0 || feed.Title != feedTitle {
+		t.Errorf("empty feed %+v (%v)", feed, err)
+	}
+	if updated, err := time.Parse(time.RFC3339, feed.Updated); err != nil || time.Since(updated) > time.Minute {
+		t.Errorf("empty feed updated %q, want now", feed.Updated)
+	}
+	if got := rec.Header().Get("Last-Modified"); got != "" {
+		t.Errorf("Last-Modified %q of an empty feed", got)
+	}
+}
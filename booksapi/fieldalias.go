@@ -0,0 +1,287 @@
+package booksapi
+
+import (
+	"bytes"
+	"encoding/json"
+	"expvar"
+	"fmt"
+	"io"
+	"net/http"
+	"reflect"
+	"slices"
+	"strings"
+	"sync"
+)
+
+// fieldAlias is the new name of a renamed JSON field. The Go field keeps the
+// old name in its tag, which the store, journal, and backups go on using;
+// at the API, request bodies may give either name and responses carry the
+// new one, and during the deprecation window the old one as well.
+type fieldAlias struct {
+	old, new string
+}
+
+// fieldAliases lists the renamed fields of each type. A type embedding one
+// of these, such as bookResponse, has its renamed fields too.
+var fieldAliases = map[reflect.Type][]fieldAlias{
+	reflect.TypeFor[Book](): {{old: "title", new: "name"}},
+}
+
+// sendDeprecatedFields keeps the old names of renamed fields in responses,
+// next to the new ones, for clients that have not moved yet. It is
+// configured by a flag in Main, and can be turned off once
+// deprecatedFieldUses shows clients no longer send the old names.
+var sendDeprecatedFields = true
+
+// deprecatedFieldUses counts the old names of renamed fields given in
+// request bodies, keyed by type and name such as "book.title", published
+// under /debug/vars.
+var deprecatedFieldUses = expvar.NewMap("deprecated_fields")
+
+// aliasedTypes caches whether each type decoded from a request body holds
+// a renamed field anywhere, so bodies of the others are decoded as sent.
+var aliasedTypes sync.Map // reflect.Type -> bool
+
+// hasAliases reports whether values of t hold a type with renamed fields.
+func hasAliases(t reflect.Type) bool {
+	if cached, ok := aliasedTypes.Load(t); ok {
+		return cached.(bool)
+	}
+	found := findAliases(t, make(map[reflect.Type]bool))
+	aliasedTypes.Store(t, found)
+	return found
+}
+
+func findAliases(t reflect.Type, seen map[reflect.Type]bool) bool {
+	if seen[t] {
+		return false
+	}
+	seen[t] = true
+	switch t.Kind() {
+	case reflect.Pointer, reflect.Slice, reflect.Array, reflect.Map:
+		return findAliases(t.Elem(), seen)
+	case reflect.Struct:
+		if len(fieldAliases[t]) > 0 {
+			return true
+		}
+		for i := range t.NumField() {
+			if findAliases(t.Field(i).Type, seen) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// resolveBodyAliases rewrites the renamed fields of data, a request body
+// decoding into a value of type t, to the old names t decodes; see
+// resolveAliases. Bodies that are not valid JSON, or hold more than one
+// value, are returned unchanged for decodeBody to reject.
+func resolveBodyAliases(r *http.Request, data []byte, t reflect.Type) ([]byte, error) {
+	if !hasAliases(t) {
+		return data, nil
+	}
+	var doc any
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	if dec.Decode(&doc) != nil || dec.Decode(&json.RawMessage{}) != io.EOF {
+		return data, nil
+	}
+	if err := resolveAliases(r, doc, t); err != nil {
+		return nil, err
+	}
+	return json.Marshal(doc)
+}
+
+// resolveAliases renames the renamed fields of doc, a decoded JSON value
+// of type t, to their old names in place. Giving both names with different
+// values fails with 400. Each use of an old name is counted in
+// deprecatedFieldUses and, for r not nil, reported in a warning.
+func resolveAliases(r *http.Request, doc any, t reflect.Type) error {
+	for t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+	switch t.Kind() {
+	case reflect.Slice, reflect.Array:
+		list, _ := doc.([]any)
+		for _, item := range list {
+			if err := resolveAliases(r, item, t.Elem()); err != nil {
+				return err
+			}
+		}
+		return nil
+	case reflect.Map:
+		obj, _ := doc.(map[string]any)
+		for _, value := range obj {
+			if err := resolveAliases(r, value, t.Elem()); err != nil {
+				return err
+			}
+		}
+		return nil
+	case reflect.Struct:
+	default:
+		return nil
+	}
+
+	obj, ok := doc.(map[string]any)
+	if !ok {
+		return nil
+	}
+	for _, alias := range fieldAliases[t] {
+		newValue, hasNew := obj[alias.new]
+		oldValue, hasOld := obj[alias.old]
+		if hasOld && hasNew && !jsonEqual(oldValue, newValue) {
+			return newAPIError(http.StatusBadRequest, "conflicting_fields", "first", alias.old, "second", alias.new)
+		}
+		if hasOld {
+			warnDeprecatedField(r, t, alias)
+		}
+		if hasNew {
+			obj[alias.old] = newValue
+			delete(obj, alias.new)
+		}
+	}
+	for i := range t.NumField() {
+		field := t.Field(i)
+		name, embedded := jsonFieldName(field)
+		var err error
+		switch {
+		case embedded:
+			err = resolveAliases(r, obj, field.Type)
+		case name != "":
+			if value, found := obj[name]; found {
+				err = resolveAliases(r, value, field.Type)
+			}
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// resolvePathAlias returns the JSON Pointer path into a value of type t
+// with a renamed field it starts with given its old name, warning about
+// the old name as resolveAliases does.
+func resolvePathAlias(r *http.Request, path string, t reflect.Type) string {
+	rest, ok := strings.CutPrefix(path, "/")
+	if !ok {
+		return path
+	}
+	first, _, _ := strings.Cut(rest, "/")
+	for _, alias := range fieldAliases[t] {
+		switch first {
+		case alias.old:
+			warnDeprecatedField(r, t, alias)
+		case alias.new:
+			return "/" + alias.old + strings.TrimPrefix(rest, alias.new)
+		}
+	}
+	return path
+}
+
+// warnDeprecatedField records a request's use of the old name of a renamed
+// field of t.
+func warnDeprecatedField(r *http.Request, t reflect.Type, alias fieldAlias) {
+	deprecatedFieldUses.Add(strings.ToLower(t.Name())+"."+alias.old, 1)
+	if r != nil {
+		addWarning(r, "deprecated_field", fmt.Sprintf("field %q is deprecated; use %q", alias.old, alias.new))
+	}
+}
+
+// jsonFieldName returns the name a struct field is encoded under in JSON,
+// or "" for fields left out of it. Embedded structs without a name of their
+// own, whose fields are encoded in the enclosing object, are reported as
+// embedded.
+func jsonFieldName(field reflect.StructField) (name string, embedded bool) {
+	tag, hasTag := field.Tag.Lookup("json")
+	name, _, _ = strings.Cut(tag, ",")
+	if name == "-" {
+		return "", false
+	}
+	if name == "" && field.Anonymous {
+		t := field.Type
+		if t.Kind() == reflect.Pointer {
+			t = t.Elem()
+		}
+		if t.Kind() == reflect.Struct {
+			return "", true
+		}
+	}
+	if !field.IsExported() {
+		return "", false
+	}
+	if name == "" || !hasTag {
+		name = field.Name
+	}
+	return name, false
+}
+
+// objectAliases returns the renamed fields of the JSON object encoding a
+// value of type t, including those of the structs it embeds.
+func objectAliases(t reflect.Type) []fieldAlias {
+	aliases := slices.Clone(fieldAliases[t])
+	for i := range t.NumField() {
+		field := t.Field(i)
+		if _, embedded := jsonFieldName(field); embedded {
+			ft := field.Type
+			if ft.Kind() == reflect.Pointer {
+				ft = ft.Elem()
+			}
+			aliases = append(aliases, objectAliases(ft)...)
+		}
+	}
+	return aliases
+}
+
+// withAliases rewrites data, the JSON object encoding a value of type t,
+// for a response: each renamed field is sent under its new name, right
+// after its old one while sendDeprecatedFields holds and in its place
+// otherwise. The other keys keep their order. Types with renamed fields
+// call it from their MarshalJSON.
+func withAliases(data []byte, t reflect.Type) ([]byte, error) {
+	aliases := objectAliases(t)
+	if len(aliases) == 0 {
+		return data, nil
+	}
+	dec := json.NewDecoder(bytes.NewReader(data))
+	if tok, err := dec.Token(); err != nil || tok != json.Delim('{') {
+		return nil, fmt.Errorf("encoding %s: not a JSON object", t)
+	}
+	var buf bytes.Buffer
+	buf.WriteByte('{')
+	write := func(key string, value json.RawMessage) {
+		if buf.Len() > 1 {
+			buf.WriteByte(',')
+		}
+		name, _ := json.Marshal(key)
+		buf.Write(name)
+		buf.WriteByte(':')
+		buf.Write(value)
+	}
+	for dec.More() {
+		tok, err := dec.Token()
+		if err != nil {
+			return nil, err
+		}
+		key, _ := tok.(string)
+		var value json.RawMessage
+		if err := dec.Decode(&value); err != nil {
+			return nil, err
+		}
+		renamed := ""
+		for _, alias := range aliases {
+			if alias.old == key {
+				renamed = alias.new
+			}
+		}
+		if renamed == "" || sendDeprecatedFields {
+			write(key, value)
+		}
+		if renamed != "" {
+			write(renamed, value)
+		}
+	}
+	buf.WriteByte('}')
+	return buf.Bytes(), nil
+}
@@ -0,0 +1,135 @@
+package booksapi
+
+import (
+	"expvar"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+// deprecatedUses returns the number of uses of the old name of a renamed
+// field counted so far, keyed as in deprecatedFieldUses.
+func deprecatedUses(key string) int64 {
+	if v, ok := deprecatedFieldUses.Get(key).(*expvar.Int); ok {
+		return v.Value()
+	}
+	return 0
+}
+
+// TestFieldAliases checks that request bodies may give a book's title
+// under its old name, its new one, or both when they agree, and that the
+// old name is warned about and counted.
+func TestFieldAliases(t *testing.T) {
+	const warning = `299 - "field \"title\" is deprecated; use \"name\""`
+	tests := []struct {
+		name       string
+		body       string
+		status     int
+		title      string
+		deprecated bool
+	}{
+		{"old name", `{"title": "Dune"}`, http.StatusCreated, "Dune", true},
+		{"new name", `{"name": "Dune"}`, http.StatusCreated, "Dune", false},
+		{"both agree", `{"title": "Dune", "name": "Dune"}`, http.StatusCreated, "Dune", true},
+		{"both differ", `{"title": "Dune", "name": "Emma"}`, http.StatusBadRequest, "", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := newTestServer(t)
+			uses := deprecatedUses("book.title")
+			rec := serve(t, h, http.MethodPost, "/books", tt.body)
+			wantCode(t, rec, tt.status)
+			if tt.status == http.StatusCreated {
+				if got := decode[Book](t, rec).Title; got != tt.title {
+					t.Errorf("title %q, want %q", got, tt.title)
+				}
+			} else if got := decode[errorBody](t, rec).Error; got.Code != "conflicting_fields" || got.Params["first"] != "title" || got.Params["second"] != "name" {
+				t.Errorf("error %s %v, want conflicting_fields of title and name", got.Code, got.Params)
+			}
+
+			if got := rec.Header().Values("Warning"); tt.deprecated != (len(got) == 1 && got[0] == warning) || (!tt.deprecated && len(got) != 0) {
+				t.Errorf("Warning %q, want the deprecation warning: %v", got, tt.deprecated)
+			}
+			if got := deprecatedUses("book.title") - uses; tt.deprecated != (got == 1) || got > 1 {
+				t.Errorf("old name counted %d times, want it counted: %v", got, tt.deprecated)
+			}
+		})
+	}
+}
+
+// TestFieldAliasesEverywhere checks that the new name is taken by every
+// request body holding books, and by JSON Patch paths, and that a
+// transaction using the old name in several operations is warned once.
+func TestFieldAliasesEverywhere(t *testing.T) {
+	h := newTestServer(t)
+	mustCreateBook(t, h, map[string]any{"name": "Dune"})
+	mustCreateBook(t, h, map[string]any{"name": "Emma"})
+
+	requests := []struct {
+		method, path string
+		body         string
+		header       []string
+		id           string
+		title        string
+	}{
+		{http.MethodPut, "/books/1", `{"name": "Dune Messiah"}`, nil, "1", "Dune Messiah"},
+		{http.MethodPatch, "/books/1", `{"name": "Children of Dune"}`, []string{"Content-Type", mergePatchType}, "1", "Children of Dune"},
+		{http.MethodPatch, "/books/1", `[{"op": "replace", "path": "/name", "value": "God Emperor"}]`, []string{"Content-Type", jsonPatchType}, "1", "God Emperor"},
+		{http.MethodPost, "/books/import", `[{"name": "Hyperion"}]`, nil, "3", "Hyperion"},
+		{http.MethodPost, "/books/transactions", `{"operations": [{"op": "update", "id": 2, "book": {"name": "Persuasion"}}]}`, nil, "2", "Persuasion"},
+	}
+	for _, req := range requests {
+		rec := serve(t, h, req.method, req.path, req.body, req.header...)
+		if rec.Code >= 300 {
+			t.Fatalf("%s %s: status %d: %s", req.method, req.path, rec.Code, rec.Body)
+		}
+		if got := rec.Header().Values("Warning"); len(got) != 0 {
+			t.Errorf("%s %s: Warning %q for the new name", req.method, req.path, got)
+		}
+		if got := decode[Book](t, serve(t, h, http.MethodGet, "/books/"+req.id, nil)).Title; got != req.title {
+			t.Errorf("%s %s: title %q, want %q", req.method, req.path, got, req.title)
+		}
+	}
+
+	uses := deprecatedUses("book.title")
+	rec := serve(t, h, http.MethodPost, "/books/transactions", `{"operations": [
+		{"op": "update", "id": 1, "book": {"title": "Dune"}},
+		{"op": "update", "id": 2, "book": {"title": "Emma"}}
+	]}`)
+	wantCode(t, rec, http.StatusOK)
+	if got := rec.Header().Values("Warning"); len(got) != 1 {
+		t.Errorf("Warning %q, want a single deprecation warning", got)
+	}
+	if got := deprecatedUses("book.title") - uses; got != 2 {
+		t.Errorf("old name counted %d times, want 2", got)
+	}
+
+	rec = serve(t, h, http.MethodPatch, "/books/1", `[{"op": "replace", "path": "/title", "value": "Dune"}]`, "Content-Type", jsonPatchType)
+	wantCode(t, rec, http.StatusOK)
+	if got := rec.Header().Values("Warning"); len(got) != 1 {
+		t.Errorf("JSON Patch of /title: Warning %q, want the deprecation warning", got)
+	}
+}
+
+// TestFieldAliasResponses checks that responses carry the new name in the
+// old one's place, with the old name right before it during the
+// deprecation window.
+func TestFieldAliasResponses(t *testing.T) {
+	h := newTestServer(t)
+	mustCreateBook(t, h, map[string]any{"name": "Dune", "author": "Frank Herbert"})
+
+	for _, path := range []string{"/books/1", "/books"} {
+		invalidateResponseCache()
+		body := serve(t, h, http.MethodGet, path, nil).Body.String()
+		if !strings.Contains(body, `"title":"Dune","name":"Dune","author":"Frank Herbert"`) {
+			t.Errorf("%s: %s, want both names in the title's place", path, body)
+		}
+	}
+
+	setForTest(t, &sendDeprecatedFields, false)
+	invalidateResponseCache()
+	body := serve(t, h, http.MethodGet, "/books/1", nil).Body.String()
+	if !strings.Contains(body, `"id":1,"name":"Dune","author":"Frank Herbert"`) || strings.Contains(body, `"title"`) {
+		t.Errorf("after the deprecation window: %s, want only the new name", body)
+	}
+}
@@ -0,0 +1,295 @@
+package booksapi
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/url"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// SavedFilter is a named book query, evaluated live by
+// GET /filters/{id}/books. Filter holds GET /books query parameters, e.g.
+// {"lang": "en", "max_price": 10, "sort": ["title"]}.
+type SavedFilter struct {
+	ID     int            `json:"id"`
+	Name   string         `json:"name"`
+	Filter map[string]any `json:"filter"`
+}
+
+// filterFields are the GET /books query parameters a saved filter may set;
+// paging and presentation are left to the request evaluating it.
+var filterFields = []string{"lang", "author", "title", "match", "min_price", "max_price", "format", "sort", "order"}
+
+// filterBooksParams declares the query parameters of GET /filters/{id}/books.
+var filterBooksParams = slices.Concat(bookListParams, []queryParam{
+	{name: "cursor", kind: stringParam},
+	{name: "snapshot", kind: stringParam},
+})
+
+// Global variables to store saved filters.
+var (
+	savedFilters = make(map[int]SavedFilter)
+	nextFilterID = 1
+	filtersMu    sync.Mutex
+)
+
+// filtersHandler handles general saved filter collection operations (GET, POST).
+func filtersHandler(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		getFilters(w, r)
+	case http.MethodPost:
+		createFilter(w, r)
+	default:
+		writeError(w, r, http.StatusMethodNotAllowed, "method_not_allowed")
+	}
+}
+
+// filterHandler handles operations on a specific saved filter and the books
+// it selects.
+func filterHandler(w http.ResponseWriter, r *http.Request) {
+	segments := pathSegments(r.URL.Path)
+	if len(segments) < 2 || len(segments) > 3 || (len(segments) == 3 && segments[2] != "books") {
+		writeError(w, r, http.StatusNotFound, "not_found")
+		return
+	}
+
+	id, err := parseResourceID(segments[1], "invalid_filter_id")
+	if err != nil {
+		writeAPIError(w, r, err)
+		return
+	}
+
+	if len(segments) == 3 {
+		if r.Method != http.MethodGet && r.Method != http.MethodHead {
+			writeError(w, r, http.StatusMethodNotAllowed, "method_not_allowed")
+			return
+		}
+		getFilterBooks(w, r, id)
+		return
+	}
+
+	if _, ok := checkQuery(w, r); !ok {
+		return
+	}
+	switch r.Method {
+	case http.MethodGet:
+		getFilter(w, r, id)
+	case http.MethodPut:
+		updateFilter(w, r, id)
+	case http.MethodDelete:
+		deleteFilter(w, r, id)
+	default:
+		writeError(w, r, http.StatusMethodNotAllowed, "method_not_allowed")
+	}
+}
+
+// getFilters retrieves the list of all saved filters, ordered by ID and paginated.
+func getFilters(w http.ResponseWriter, r *http.Request) {
+	q, ok := checkQuery(w, r, paginationParams...)
+	if !ok {
+		return
+	}
+
+	filtersMu.Lock()
+	defer filtersMu.Unlock()
+
+	filterList := make([]SavedFilter, 0, len(savedFilters))
+	for _, filter := range savedFilters {
+		filterList = append(filterList, filter)
+	}
+	sort.Slice(filterList, func(i, j int) bool { return filterList[i].ID < filterList[j].ID })
+
+	writeJSON(w, http.StatusOK, paginateQuery(w, r, q, PagingPolicy{}, filterList))
+}
+
+// createFilter creates a new saved filter.
+func createFilter(w http.ResponseWriter, r *http.Request) {
+	if _, ok := checkQuery(w, r); !ok {
+		return
+	}
+
+	var filter SavedFilter
+	if err := decodeJSON(w, r, &filter); err != nil {
+		writeAPIError(w, r, err)
+		return
+	}
+	if err := validateFilter(r, &filter); err != nil {
+		writeAPIError(w, r, err)
+		return
+	}
+
+	filtersMu.Lock()
+	filter.ID = nextFilterID
+	nextFilterID++
+	savedFilters[filter.ID] = filter
+	filtersMu.Unlock()
+
+	setLocation(w, "/filters/"+strconv.Itoa(filter.ID))
+	writeJSON(w, http.StatusCreated, filter)
+}
+
+// getFilter retrieves a specific saved filter by its ID.
+func getFilter(w http.ResponseWriter, r *http.Request, id int) {
+	filtersMu.Lock()
+	defer filtersMu.Unlock()
+
+	filter, found := savedFilters[id]
+	if !found {
+		writeError(w, r, http.StatusNotFound, "filter_not_found")
+		return
+	}
+
+	writeJSON(w, http.StatusOK, filter)
+}
+
+// updateFilter replaces a saved filter's name and filter. The change applies
+// to the next evaluation.
+func updateFilter(w http.ResponseWriter, r *http.Request, id int) {
+	filtersMu.Lock()
+	defer filtersMu.Unlock()
+
+	filter, found := savedFilters[id]
+	if !found {
+		writeError(w, r, http.StatusNotFound, "filter_not_found")
+		return
+	}
+
+	// The filter is decoded afresh rather than merged into the old one, and
+	// kept if the body leaves it out, so a rename need only send the name.
+	prev := filter.Filter
+	filter.Filter = nil
+	if err := decodeJSON(w, r, &filter); err != nil {
+		writeAPIError(w, r, err)
+		return
+	}
+	if filter.Filter == nil {
+		filter.Filter = prev
+	}
+	if err := validateFilter(r, &filter); err != nil {
+		writeAPIError(w, r, err)
+		return
+	}
+
+	filter.ID = id
+	savedFilters[id] = filter
+	writeJSON(w, http.StatusOK, filter)
+}
+
+// deleteFilter removes a saved filter.
+func deleteFilter(w http.ResponseWriter, r *http.Request, id int) {
+	filtersMu.Lock()
+	defer filtersMu.Unlock()
+
+	if _, found := savedFilters[id]; !found {
+		writeError(w, r, http.StatusNotFound, "filter_not_found")
+		return
+	}
+	delete(savedFilters, id)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// getFilterBooks lists the books a saved filter selects now, paginated
+// (GET /filters/{id}/books). The filter goes through the same parsing and
+// store options as GET /books query parameters, and the page through the
+// same listing code.
+func getFilterBooks(w http.ResponseWriter, r *http.Request, id int) {
+	q, ok := checkQuery(w, r, filterBooksParams...)
+	if !ok {
+		return
+	}
+
+	filtersMu.Lock()
+	filter, found := savedFilters[id]
+	filtersMu.Unlock()
+	if !found {
+		writeError(w, r, http.StatusNotFound, "filter_not_found")
+		return
+	}
+
+	raw, err := filterValues(filter.Filter)
+	if err != nil {
+		writeAPIError(w, r, err)
+		return
+	}
+	for name, values := range r.URL.Query() {
+		if q.Has(name) {
+			raw[name] = values
+		}
+	}
+	opts, err := parseListOptions(r, raw)
+	if err != nil {
+		writeAPIError(w, r, err)
+		return
+	}
+	serveBookList(w, r, opts)
+}
+
+// validateFilter checks a saved filter's name and that its filter is one
+// GET /books would accept.
+func validateFilter(r *http.Request, filter *SavedFilter) error {
+	filter.Name = strings.TrimSpace(filter.Name)
+	if filter.Name == "" {
+		return newAPIError(http.StatusUnprocessableEntity, "field_required", "field", "name")
+	}
+	if filter.Filter == nil {
+		filter.Filter = map[string]any{}
+	}
+	raw, err := filterValues(filter.Filter)
+	if err != nil {
+		return err
+	}
+	if _, err := parseListOptions(r, raw); err != nil {
+		var e *apiError
+		if errors.As(err, &e) {
+			return newAPIError(http.StatusUnprocessableEntity, "invalid_filter", "name", e.Params["name"], "expected", e.Params["expected"])
+		}
+		return err
+	}
+	return nil
+}
+
+// filterValues converts a saved filter to query parameters. Strings and
+// numbers become single values. Lists of strings become the values of a
+// repeatable parameter, such as author, and comma-separated ones
+// otherwise.
+func filterValues(filter map[string]any) (url.Values, error) {
+	raw := make(url.Values, len(filter))
+	for name, value := range filter {
+		if !slices.Contains(filterFields, name) {
+			return nil, newAPIError(http.StatusUnprocessableEntity, "unknown_filter_field", "field", name)
+		}
+		invalid := newAPIError(http.StatusUnprocessableEntity, "invalid_filter", "name", name, "expected", "string, number, or list of strings")
+		switch v := value.(type) {
+		case string:
+			raw.Set(name, v)
+		case json.Number:
+			raw.Set(name, v.String())
+		case float64:
+			raw.Set(name, strconv.FormatFloat(v, 'f', -1, 64))
+		case []any:
+			parts := make([]string, 0, len(v))
+			for _, part := range v {
+				s, ok := part.(string)
+				if !ok {
+					return nil, invalid
+				}
+				parts = append(parts, s)
+			}
+			i := slices.IndexFunc(booksParams, func(p queryParam) bool { return p.name == name })
+			if i >= 0 && booksParams[i].repeatable {
+				raw[name] = parts
+			} else {
+				raw.Set(name, strings.Join(parts, ","))
+			}
+		default:
+			return nil, invalid
+		}
+	}
+	return raw, nil
+}
@@ -0,0 +1,123 @@
+package booksapi
+
+import (
+	"net/http"
+	"net/url"
+	"slices"
+	"testing"
+)
+
+// filterBookIDs returns the IDs of the books GET path lists, in order.
+func filterBookIDs(t *testing.T, h http.Handler, path string) []int {
+	t.Helper()
+	rec := serve(t, h, http.MethodGet, path, nil)
+	wantCode(t, rec, http.StatusOK)
+	return bookIDs(decode[[]Book](t, rec))
+}
+
+// TestSavedFilter checks that a saved filter selects the books the same
+// query parameters would, and that its books follow changes to the catalog
+// and to the filter as they are made.
+func TestSavedFilter(t *testing.T) {
+	h := newTestServer(t)
+	mustCreateBook(t, h, map[string]any{"title": "Emma", "author": "Jane Austen", "price": 5})
+	mustCreateBook(t, h, map[string]any{"title": "Persuasion", "author": "Jane Austen", "price": 9})
+	mustCreateBook(t, h, map[string]any{"title": "Sanditon", "author": "Jane Austen", "price": 15})
+	mustCreateBook(t, h, map[string]any{"title": "Dune", "author": "Frank Herbert", "price": 8})
+
+	rec := serve(t, h, http.MethodPost, "/filters", map[string]any{
+		"name":   " Cheap Austen ",
+		"filter": map[string]any{"author": "jane austen", "max_price": 10, "sort": []string{"-price"}},
+	})
+	wantCode(t, rec, http.StatusCreated)
+	filter := decode[SavedFilter](t, rec)
+	if filter.ID != 1 || filter.Name != "Cheap Austen" || rec.Header().Get("Location") != "/filters/1" {
+		t.Errorf("created %+v at %q", filter, rec.Header().Get("Location"))
+	}
+
+	adHoc := "/books?" + url.Values{"author": {"jane austen"}, "max_price": {"10"}, "sort": {"-price"}}.Encode()
+	same := func(want ...int) {
+		t.Helper()
+		got := filterBookIDs(t, h, "/filters/1/books")
+		if !slices.Equal(got, want) {
+			t.Errorf("filter selects %v, want %v", got, want)
+		}
+		if direct := filterBookIDs(t, h, adHoc); !slices.Equal(got, direct) {
+			t.Errorf("filter selects %v, the same query %v", got, direct)
+		}
+	}
+	same(2, 1)
+
+	mustCreateBook(t, h, map[string]any{"title": "Lady Susan", "author": "Jane Austen", "price": 7})
+	same(2, 5, 1)
+	wantCode(t, serve(t, h, http.MethodPut, "/books/2", map[string]any{"title": "Persuasion", "author": "Jane Austen", "price": 12}), http.StatusOK)
+	same(5, 1)
+	wantCode(t, serve(t, h, http.MethodDelete, "/books/1", nil), http.StatusNoContent)
+	same(5)
+
+	// The request pages the filter's books.
+	mustCreateBook(t, h, map[string]any{"title": "Juvenilia", "author": "Jane Austen", "price": 3})
+	if got := filterBookIDs(t, h, "/filters/1/books?limit=1&offset=1"); !slices.Equal(got, []int{6}) {
+		t.Errorf("second page of the filter: %v, want [6]", got)
+	}
+
+	// Renaming keeps the filter; editing it applies at once.
+	rec = serve(t, h, http.MethodPut, "/filters/1", map[string]any{"name": "Austen"})
+	wantCode(t, rec, http.StatusOK)
+	if got := decode[SavedFilter](t, rec); got.Name != "Austen" || got.Filter["author"] != "jane austen" {
+		t.Errorf("renamed filter %+v, want its filter kept", got)
+	}
+	if got := filterBookIDs(t, h, "/filters/1/books"); !slices.Equal(got, []int{5, 6}) {
+		t.Errorf("renamed filter selects %v, want [5 6]", got)
+	}
+	wantCode(t, serve(t, h, http.MethodPut, "/filters/1", map[string]any{"name": "Austen", "filter": map[string]any{"author": "Jane Austen", "sort": "title"}}), http.StatusOK)
+	if got := filterBookIDs(t, h, "/filters/1/books"); !slices.Equal(got, []int{6, 5, 2, 3}) {
+		t.Errorf("edited filter selects %v, want [6 5 2 3]", got)
+	}
+
+	wantCode(t, serve(t, h, http.MethodDelete, "/filters/1", nil), http.StatusNoContent)
+	wantCode(t, serve(t, h, http.MethodGet, "/filters/1/books", nil), http.StatusNotFound)
+}
+
+// TestSavedFilterErrors checks the filters refused on save.
+func TestSavedFilterErrors(t *testing.T) {
+	h := newTestServer(t)
+	tests := []struct {
+		name   string
+		filter map[string]any
+		code   string
+		params map[string]string
+	}{
+		{"unknown field", map[string]any{"genre": "fantasy"}, "unknown_filter_field", map[string]string{"field": "genre"}},
+		{"paging field", map[string]any{"limit": 10}, "unknown_filter_field", map[string]string{"field": "limit"}},
+		{"bad price", map[string]any{"max_price": "cheap"}, "invalid_filter", map[string]string{"name": "max_price"}},
+		{"list of numbers", map[string]any{"lang": []any{1, 2}}, "invalid_filter", map[string]string{"name": "lang"}},
+		{"object", map[string]any{"author": map[string]any{"name": "Austen"}}, "invalid_filter", map[string]string{"name": "author"}},
+		{"bad sort", map[string]any{"sort": "isbn"}, "invalid_filter", map[string]string{"name": "sort"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := serve(t, h, http.MethodPost, "/filters", map[string]any{"name": "Mine", "filter": tt.filter})
+			wantCode(t, rec, http.StatusUnprocessableEntity)
+			got := decode[errorBody](t, rec).Error
+			if got.Code != tt.code {
+				t.Errorf("error code %q, want %q", got.Code, tt.code)
+			}
+			for name, value := range tt.params {
+				if got.Params[name] != value {
+					t.Errorf("error params %v, want %s=%s", got.Params, name, value)
+				}
+			}
+		})
+	}
+	rec := serve(t, h, http.MethodPost, "/filters", map[string]any{"name": " "})
+	wantCode(t, rec, http.StatusUnprocessableEntity)
+	if got := errorCode(t, rec); got != "field_required" {
+		t.Errorf("blank name: error code %q, want field_required", got)
+	}
+	if got := len(decode[[]SavedFilter](t, serve(t, h, http.MethodGet, "/filters", nil))); got != 0 {
+		t.Errorf("%d filters saved", got)
+	}
+	wantCode(t, serve(t, h, http.MethodGet, "/filters/9", nil), http.StatusNotFound)
+	wantCode(t, serve(t, h, http.MethodPost, "/filters/9/books", nil), http.StatusMethodNotAllowed)
+}
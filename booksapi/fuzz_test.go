@@ -0,0 +1,95 @@
+package booksapi
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// FuzzParseID checks that any path segment either parses as the one
+// spelling of a positive ID or is refused with a 400, never a panic or a
+// 5xx.
+func FuzzParseID(f *testing.F) {
+	for _, seed := range []string{
+		"1", "42", "0", "-1", "+1", "01", "1/", "1/cover", "", "/",
+		"9223372036854775807", "9223372036854775808", "99999999999999999999999",
+		"1e3", "0x10", " 1", "١", "\xff",
+	} {
+		f.Add(seed)
+	}
+	h := newTestServer(f)
+	mustCreateBook(f, h, map[string]any{"title": "Dune"})
+
+	f.Fuzz(func(t *testing.T, segment string) {
+		path := "/books/" + segment
+		id, err := parseID(path)
+		if err == nil {
+			first, _, _ := strings.Cut(segment, "/")
+			if id < 1 || strconv.Itoa(id) != first {
+				t.Errorf("parseID(%q) = %d", path, id)
+			}
+		} else if e := decodeError(err); e.Status != http.StatusBadRequest {
+			t.Errorf("parseID(%q) failed with status %d", path, e.Status)
+		}
+
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		req.URL.Path = path
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		if rec.Code >= 500 {
+			t.Errorf("GET %q: status %d: %s", path, rec.Code, rec.Body.String())
+		}
+	})
+}
+
+// FuzzCreateBody checks that POST /books answers any body without a panic
+// or a 5xx, and that a body it refuses leaves the catalog unchanged.
+func FuzzCreateBody(f *testing.F) {
+	for _, seed := range []string{
+		`{"title": "Dune", "author": "Frank Herbert", "price": 9.99}`,
+		`{"title": "Dune", "price": "12.50"}`,
+		`{"title": "Dune", "price": 1e400}`,
+		`{"title": "Dune", "price": 123456789012345678901234567890}`,
+		`{"title": "Dune", "publisher_id": 9223372036854775808}`,
+		`{"title": "Dune", "editions": [{"format": "ebook", "price": 1}]}`,
+		`{"title": "Dune", "attributes": {"a": {"b": {"c": [[[[[[[[[[1]]]]]]]]]]}}}`,
+		strings.Repeat("[", 10000) + strings.Repeat("]", 10000),
+		strings.Repeat(`{"a":`, 5000) + "1" + strings.Repeat("}", 5000),
+		"{\"title\": \"\xff\xfe\"}",
+		"{\"title\": \"Dune\"}\xff",
+		`{"title": "Dune"} {"title": "Emma"}`,
+		`{"title": "Dune"`,
+		`null`,
+		``,
+	} {
+		f.Add([]byte(seed))
+	}
+	h := newTestServer(f)
+	mustCreateBook(f, h, map[string]any{"title": "Dune"})
+
+	f.Fuzz(func(t *testing.T, body []byte) {
+		before := store.List(ListOptions{})
+		rec := serve(t, h, http.MethodPost, "/books", body)
+		switch {
+		case rec.Code >= 500:
+			t.Fatalf("status %d for %q: %s", rec.Code, body, rec.Body.String())
+		case rec.Code >= 400:
+			after := store.List(ListOptions{})
+			if len(after) != len(before) || after[len(after)-1].ID != before[len(before)-1].ID {
+				t.Fatalf("refused body %q changed the catalog from %d to %d books", body, len(before), len(after))
+			}
+		case rec.Code == http.StatusCreated:
+			id := decode[Book](t, rec).ID
+			if _, ok := store.Get(id); !ok {
+				t.Fatalf("created book %d is not in the store", id)
+			}
+			// The catalog is kept small, so each input runs as fast as the
+			// first.
+			store.Delete(id)
+		default:
+			t.Fatalf("unexpected status %d for %q", rec.Code, body)
+		}
+	})
+}
@@ -0,0 +1,191 @@
+package booksapi
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+// update rewrites the golden files of the tests with the responses they
+// get: go test ./booksapi -run TestName -update.
+var update = flag.Bool("update", false, "rewrite golden files")
+
+// setForTest sets *p to v until the end of the test.
+func setForTest[T any](t testing.TB, p *T, v T) {
+	t.Helper()
+	old := *p
+	*p = v
+	t.Cleanup(func() { *p = old })
+}
+
+// resetState gives the test an empty catalog, default settings, and
+// directories of its own for the files the API writes. The settings New
+// and the tests change are put back at the end of the test.
+func resetState(t testing.TB) {
+	t.Helper()
+	dir := t.TempDir()
+
+	setForTest(t, &store, BookStore(trackPriceDrops(trackChanges(newMemoryStore(newSequentialIDs())))))
+	setForTest(t, &built, false)
+	setForTest(t, &logger, log.New(io.Discard, "", 0))
+	setForTest(t, &basePath, "")
+	setForTest(t, &apiTokens, nil)
+	setForTest(t, &fixedTokens, nil)
+	setForTest(t, &requireTokens, false)
+	setForTest(t, &allowMethodOverride, false)
+	setForTest(t, &cacheMaxEntries, cacheMaxEntries)
+	setForTest(t, &maxConcurrent, 0)
+	setForTest(t, &requestTimeout, 0)
+	setForTest(t, &routePolicies, nil)
+	setForTest(t, &defaultPaging, defaultPaging)
+	setForTest(t, &maxBooks, 0)
+	setForTest(t, &coverDir, filepath.Join(dir, "covers"))
+	setForTest(t, &descriptionDir, filepath.Join(dir, "descriptions"))
+	setForTest(t, &coldStorageDir, filepath.Join(dir, "archived"))
+	setForTest(t, &reservationsPath, "")
+	setForTest(t, &recordDir, "")
+	setForTest(t, &replica, nil)
+	setForTest(t, &storeBreaker, nil)
+	setForTest(t, &storeShadow, nil)
+	liveConfig.Store(flagConfig())
+	t.Cleanup(func() { liveConfig.Store(nil) })
+
+	changes = newChangeLog()
+	priceDrops = newPriceDropIndex()
+	bookCache = &responseCache{entries: make(map[string]cacheEntry)}
+	favorites = make(map[string]map[int]struct{})
+	favoriteCounts = make(map[int]int)
+	shelves, shelfBooks, nextShelfID = make(map[int]Shelf), make(map[int]map[int]struct{}), 1
+	publishers, nextPublisherID = make(map[int]Publisher), 1
+	seriesList, nextSeriesID = make(map[int]Series), 1
+	savedFilters, nextFilterID = make(map[int]SavedFilter), 1
+	covers = make(map[int]coverInfo)
+	fullDescriptions = make(map[int]descriptionInfo)
+	archivedIDs = make(map[int]struct{})
+	bookLocks = make(map[int]bookLock)
+	importSessions = make(map[string]*importSession)
+	listSnapshots = make(map[string]*listSnapshot)
+	idReservations = nil
+}
+
+// newTestServer resets the package state, as resetState does, and returns
+// the handler New builds with opts.
+func newTestServer(t testing.TB, opts ...Option) http.Handler {
+	t.Helper()
+	resetState(t)
+	return New(opts...)
+}
+
+// serve sends h a request with the given body, which is sent as is if it
+// is a string or []byte and as JSON otherwise, and headers, given as
+// alternating names and values.
+func serve(t testing.TB, h http.Handler, method, path string, body any, header ...string) *httptest.ResponseRecorder {
+	t.Helper()
+	var reader io.Reader
+	switch b := body.(type) {
+	case nil:
+	case string:
+		reader = strings.NewReader(b)
+	case []byte:
+		reader = bytes.NewReader(b)
+	default:
+		data, err := json.Marshal(b)
+		if err != nil {
+			t.Fatal(err)
+		}
+		reader = bytes.NewReader(data)
+	}
+	req := httptest.NewRequest(method, path, reader)
+	if reader != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	for i := 0; i+1 < len(header); i += 2 {
+		req.Header.Set(header[i], header[i+1])
+	}
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	return rec
+}
+
+// decode decodes the JSON body of rec into a T.
+func decode[T any](t testing.TB, rec *httptest.ResponseRecorder) T {
+	t.Helper()
+	var v T
+	if err := json.Unmarshal(rec.Body.Bytes(), &v); err != nil {
+		t.Fatalf("decoding %q: %v", rec.Body.String(), err)
+	}
+	return v
+}
+
+// wantCode fails the test unless rec has the status code want.
+func wantCode(t testing.TB, rec *httptest.ResponseRecorder, want int) {
+	t.Helper()
+	if rec.Code != want {
+		t.Fatalf("status %d, want %d; body: %s", rec.Code, want, rec.Body.String())
+	}
+}
+
+// errorCode returns the code of the error response rec holds.
+func errorCode(t testing.TB, rec *httptest.ResponseRecorder) string {
+	t.Helper()
+	return decode[errorBody](t, rec).Error.Code
+}
+
+// mustCreateBook creates a book with the given fields through h and returns
+// it as the response describes it.
+func mustCreateBook(t testing.TB, h http.Handler, fields map[string]any) Book {
+	t.Helper()
+	rec := serve(t, h, http.MethodPost, "/books", fields)
+	wantCode(t, rec, http.StatusCreated)
+	return decode[Book](t, rec)
+}
+
+// volatileJSON matches the values of the fields that differ between runs,
+// such as timestamps.
+var volatileJSON = regexp.MustCompile(`"(created_at|updated_at|time|taken_at|exported_at)": ?"[^"]*"`)
+
+// checkGolden compares got, a JSON document, indented, with the golden file
+// testdata/name, after masking the values volatileJSON matches. With
+// -update it writes the file instead.
+func checkGolden(t testing.TB, name string, got []byte) {
+	t.Helper()
+	var indented bytes.Buffer
+	if err := json.Indent(&indented, got, "", "  "); err != nil {
+		t.Fatalf("%s: invalid JSON %q: %v", name, got, err)
+	}
+	indented.WriteByte('\n')
+	masked := volatileJSON.ReplaceAll(indented.Bytes(), []byte(`"$1": "<volatile>"`))
+	checkGoldenBytes(t, name, masked)
+}
+
+// checkGoldenBytes compares got with the golden file testdata/name, byte
+// for byte. With -update it writes the file instead.
+func checkGoldenBytes(t testing.TB, name string, got []byte) {
+	t.Helper()
+	path := filepath.Join("testdata", name)
+	if *update {
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, got, 0o644); err != nil {
+			t.Fatal(err)
+		}
+		return
+	}
+	want, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("%v (run with -update to create it)", err)
+	}
+	if !bytes.Equal(got, want) {
+		t.Errorf("%s differs from the golden file:\n got: %s\nwant: %s", name, got, want)
+	}
+}
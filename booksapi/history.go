@@ -0,0 +1,162 @@
+package booksapi
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"maps"
+	"net/http"
+	"os"
+	"slices"
+	"time"
+)
+
+// bookReadParams declares the query parameters of GET /books/{id}.
+var bookReadParams = slices.Concat(bookViewParams, []queryParam{{name: "as_of", kind: stringParam}})
+
+// catalogAt is the catalog as it was at some instant.
+type catalogAt struct {
+	books   map[int]Book
+	deleted map[int]bool // books deleted by then and not stored again since
+}
+
+// parseAsOf parses the value of ?as_of=, an RFC 3339 time.
+func parseAsOf(value string) (time.Time, error) {
+	t, err := time.Parse(time.RFC3339Nano, value)
+	if err != nil {
+		return time.Time{}, newAPIError(http.StatusBadRequest, "invalid_query_parameter", "name", "as_of", "expected", "RFC 3339 time")
+	}
+	return t, nil
+}
+
+// catalogAsOf reconstructs the catalog at t from the journal, the history
+// of the store. It fails with 422 without a journal, and for times before
+// the journal was last compacted, since the records before then are gone.
+// Archived books count as deleted from when they were archived.
+func catalogAsOf(t time.Time) (catalogAt, error) {
+	journal, ok := findStore[*journalStore](store)
+	if !ok {
+		return catalogAt{}, newAPIError(http.StatusUnprocessableEntity, "history_unavailable")
+	}
+	return journal.catalogAt(t)
+}
+
+// listAsOf returns the books selected by opts among those of the catalog
+// at t.
+func listAsOf(t time.Time, opts ListOptions) ([]Book, error) {
+	at, err := catalogAsOf(t)
+	if err != nil {
+		return nil, err
+	}
+	list := slices.Collect(maps.Values(at.books))
+	sortBooksByID(list)
+	return opts.apply(list), nil
+}
+
+// getBookAsOf writes the book with the given ID as it was at t: 404 if it
+// did not exist yet, 410 if it had been deleted by then.
+func getBookAsOf(w http.ResponseWriter, r *http.Request, id int, t time.Time) {
+	at, err := catalogAsOf(t)
+	if err != nil {
+		writeAPIError(w, r, err)
+		return
+	}
+	book, found := at.books[id]
+	if !found {
+		if at.deleted[id] {
+			writeError(w, r, http.StatusGone, "book_deleted", "id", id, "as_of", t.Format(time.RFC3339Nano))
+			return
+		}
+		writeError(w, r, http.StatusNotFound, "book_not_found")
+		return
+	}
+	writeJSON(w, http.StatusOK, renderBook(r, book))
+}
+
+// catalogAt replays the snapshot of the last compaction, if any, and the
+// records written up to and including t. History starts with the
+// snapshot, or without one with the first record. The files are read
+// without holding s.mu, so writes go on meanwhile: the journal only up to
+// its length at the start, and again should a compaction replace both.
+func (s *journalStore) catalogAt(t time.Time) (catalogAt, error) {
+	for {
+		s.mu.Lock()
+		size, compactions := s.size, s.compactions
+		s.mu.Unlock()
+
+		at, err := s.readCatalogAt(t, size)
+
+		s.mu.Lock()
+		compacted := s.compactions != compactions
+		s.mu.Unlock()
+		if !compacted {
+			return at, err
+		}
+	}
+}
+
+// readCatalogAt is catalogAt reading the first size bytes of the journal.
+func (s *journalStore) readCatalogAt(t time.Time, size int64) (catalogAt, error) {
+	at := catalogAt{books: make(map[int]Book), deleted: make(map[int]bool)}
+	path := s.snapshotPath()
+	data, err := os.ReadFile(path)
+	switch {
+	case err == nil:
+		var snap storeSnapshot
+		if err := json.Unmarshal(data, &snap); err != nil {
+			return catalogAt{}, fmt.Errorf("snapshot %s: %w", path, err)
+		}
+		start := snap.TakenAt
+		if start.IsZero() {
+			// Snapshots from before TakenAt existed were written when their
+			// file was.
+			info, err := os.Stat(path)
+			if err != nil {
+				return catalogAt{}, fmt.Errorf("%w: snapshot %s: %w", ErrUnavailable, path, err)
+			}
+			start = info.ModTime()
+		}
+		if t.Before(start) {
+			return catalogAt{}, newAPIError(http.StatusUnprocessableEntity, "as_of_too_old", "earliest", start.UTC().Format(time.RFC3339Nano))
+		}
+		for _, book := range snap.Books {
+			at.books[book.ID] = book
+		}
+	case !errors.Is(err, os.ErrNotExist):
+		return catalogAt{}, fmt.Errorf("%w: snapshot %s: %w", ErrUnavailable, path, err)
+	}
+
+	file, err := os.Open(s.path)
+	if err != nil {
+		return catalogAt{}, fmt.Errorf("%w: journal %s: %w", ErrUnavailable, s.path, err)
+	}
+	defer file.Close()
+	data, err = io.ReadAll(io.NewSectionReader(file, 0, size))
+	if err != nil {
+		return catalogAt{}, fmt.Errorf("%w: journal %s: %w", ErrUnavailable, s.path, err)
+	}
+	for _, line := range bytes.Split(data, []byte("\n")) {
+		var rec journalRecord
+		if len(line) == 0 || json.Unmarshal(line, &rec) != nil {
+			continue // the torn final record of a crash
+		}
+		if rec.Time.After(t) {
+			break // records are appended in time order
+		}
+		switch rec.Op {
+		case opCreate, opUpdate:
+			if rec.Book != nil {
+				at.books[rec.ID] = *rec.Book
+				delete(at.deleted, rec.ID)
+			}
+		case opDelete:
+			if _, found := at.books[rec.ID]; found {
+				delete(at.books, rec.ID)
+				at.deleted[rec.ID] = true
+			}
+		}
+	}
+	return at, nil
+}
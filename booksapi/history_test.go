@@ -0,0 +1,239 @@
+package booksapi
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"testing"
+	"time"
+)
+
+// journalTimes returns the times of the journal's records of the given op
+// and book, in the order they were written.
+func journalTimes(t *testing.T, j *journalStore, op string, id int) []time.Time {
+	t.Helper()
+	data, err := os.ReadFile(j.path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var times []time.Time
+	for _, line := range bytes.Split(bytes.TrimSpace(data), []byte("\n")) {
+		var rec journalRecord
+		if err := json.Unmarshal(line, &rec); err != nil {
+			t.Fatal(err)
+		}
+		if rec.Op == op && rec.ID == id {
+			times = append(times, rec.Time)
+		}
+	}
+	return times
+}
+
+// TestAsOf builds a timeline of creates, updates and deletes through the
+// API and pins the book and the catalog as of instants around each change,
+// including the instant of the change itself, which already shows it.
+func TestAsOf(t *testing.T) {
+	j, err := openJournal(newMemoryStore(newSequentialIDs()), filepath.Join(t.TempDir(), "journal"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { j.Close() })
+	h := newTestServer(t, WithStore(j))
+
+	// Each change is a moment apart, so that every instant has one state.
+	step := func() { time.Sleep(2 * time.Millisecond) }
+	mustCreateBook(t, h, map[string]any{"title": "Dune", "price": 9.99})
+	step()
+	mustCreateBook(t, h, map[string]any{"title": "Emma", "price": 7.99})
+	step()
+	wantCode(t, serve(t, h, http.MethodPut, "/books/1", map[string]any{"title": "Dune", "price": 12.5}), http.StatusOK)
+	step()
+	wantCode(t, serve(t, h, http.MethodDelete, "/books/2", nil), http.StatusNoContent)
+	step()
+	mustCreateBook(t, h, map[string]any{"title": "Hyperion"})
+
+	created, updated, deleted := journalTimes(t, j, opCreate, 1), journalTimes(t, j, opUpdate, 1), journalTimes(t, j, opDelete, 2)
+	if len(created) != 1 || len(updated) != 1 || len(deleted) != 1 {
+		t.Fatalf("journal records: created %v, updated %v, deleted %v", created, updated, deleted)
+	}
+	before := func(t time.Time) time.Time { return t.Add(-time.Nanosecond) }
+
+	type state struct {
+		status int
+		price  Price
+		code   string
+	}
+	tests := []struct {
+		name   string
+		at     time.Time
+		dune   state
+		emma   state
+		titles []string
+	}{
+		{"before the first book", before(created[0]), state{status: http.StatusNotFound, code: "book_not_found"}, state{status: http.StatusNotFound, code: "book_not_found"}, nil},
+		{"at the first book", created[0], state{status: http.StatusOK, price: 9.99}, state{status: http.StatusNotFound, code: "book_not_found"}, []string{"Dune"}},
+		{"before the update", before(updated[0]), state{status: http.StatusOK, price: 9.99}, state{status: http.StatusOK, price: 7.99}, []string{"Dune", "Emma"}},
+		{"at the update", updated[0], state{status: http.StatusOK, price: 12.5}, state{status: http.StatusOK, price: 7.99}, []string{"Dune", "Emma"}},
+		{"before the delete", before(deleted[0]), state{status: http.StatusOK, price: 12.5}, state{status: http.StatusOK, price: 7.99}, []string{"Dune", "Emma"}},
+		{"at the delete", deleted[0], state{status: http.StatusOK, price: 12.5}, state{status: http.StatusGone, code: "book_deleted"}, []string{"Dune"}},
+		{"now", time.Now().UTC(), state{status: http.StatusOK, price: 12.5}, state{status: http.StatusGone, code: "book_deleted"}, []string{"Dune", "Hyperion"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			asOf := tt.at.Format(time.RFC3339Nano)
+			for id, want := range map[int]state{1: tt.dune, 2: tt.emma} {
+				rec := serve(t, h, http.MethodGet, "/books/"+strconv.Itoa(id)+"?as_of="+asOf, nil)
+				wantCode(t, rec, want.status)
+				if want.status != http.StatusOK {
+					if got := decode[errorBody](t, rec).Error; got.Code != want.code || (want.code == "book_deleted" && got.Params["as_of"] != asOf) {
+						t.Errorf("book %d: error %s %v, want %s", id, got.Code, got.Params, want.code)
+					}
+					continue
+				}
+				if got := decode[Book](t, rec).Price; got != want.price {
+					t.Errorf("book %d: price %v, want %v", id, got, want.price)
+				}
+			}
+
+			rec := serve(t, h, http.MethodGet, "/books?as_of="+asOf, nil)
+			wantCode(t, rec, http.StatusOK)
+			var titles []string
+			for _, book := range decode[[]Book](t, rec) {
+				titles = append(titles, book.Title)
+			}
+			if !slices.Equal(titles, tt.titles) {
+				t.Errorf("catalog %q, want %q", titles, tt.titles)
+			}
+		})
+	}
+
+	// Reading the past writes nothing to it.
+	if got := len(journalTimes(t, j, opCreate, 3)); got != 1 {
+		t.Errorf("%d records of book 3, want 1", got)
+	}
+}
+
+// TestAsOfRetention checks that history before the journal was last
+// compacted is refused, and that the catalog from then on is still read
+// from the snapshot the compaction left.
+func TestAsOfRetention(t *testing.T) {
+	j, err := openJournal(newMemoryStore(newSequentialIDs()), filepath.Join(t.TempDir(), "journal"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { j.Close() })
+	h := newTestServer(t, WithStore(j))
+
+	mustCreateBook(t, h, map[string]any{"title": "Dune", "price": 9.99})
+	old := time.Now().UTC()
+	time.Sleep(2 * time.Millisecond)
+	j.mu.Lock()
+	err = j.compact()
+	j.mu.Unlock()
+	if err != nil {
+		t.Fatal(err)
+	}
+	compacted := time.Now().UTC()
+	time.Sleep(2 * time.Millisecond)
+	wantCode(t, serve(t, h, http.MethodPut, "/books/1", map[string]any{"title": "Dune", "price": 12.5}), http.StatusOK)
+
+	for _, path := range []string{"/books/1", "/books"} {
+		rec := serve(t, h, http.MethodGet, path+"?as_of="+old.Format(time.RFC3339Nano), nil)
+		wantCode(t, rec, http.StatusUnprocessableEntity)
+		got := decode[errorBody](t, rec).Error
+		earliest, err := time.Parse(time.RFC3339Nano, got.Params["earliest"])
+		if got.Code != "as_of_too_old" || err != nil || earliest.Before(old) || earliest.After(compacted) {
+			t.Errorf("%s: error %s %v, want as_of_too_old naming the compaction", path, got.Code, got.Params)
+		}
+	}
+
+	rec := serve(t, h, http.MethodGet, "/books/1?as_of="+compacted.Format(time.RFC3339Nano), nil)
+	wantCode(t, rec, http.StatusOK)
+	if got := decode[Book](t, rec).Price; got != 9.99 {
+		t.Errorf("price at the compaction %v, want 9.99", got)
+	}
+}
+
+// TestAsOfErrors checks the requests for history that cannot be served.
+func TestAsOfErrors(t *testing.T) {
+	h := newTestServer(t)
+	mustCreateBook(t, h, map[string]any{"title": "Dune"})
+	now := time.Now().UTC().Format(time.RFC3339Nano)
+
+	tests := []struct {
+		path   string
+		status int
+		code   string
+	}{
+		{"/books/1?as_of=" + now, http.StatusUnprocessableEntity, "history_unavailable"},
+		{"/books?as_of=" + now, http.StatusUnprocessableEntity, "history_unavailable"},
+		{"/books/1?as_of=yesterday", http.StatusBadRequest, "invalid_query_parameter"},
+		{"/books?as_of=2024-03-01", http.StatusBadRequest, "invalid_query_parameter"},
+		{"/books?as_of=" + now + "&snapshot=true", http.StatusBadRequest, "conflicting_query_parameters"},
+		{"/books?as_of=" + now + "&include_archived=true", http.StatusBadRequest, "conflicting_query_parameters"},
+	}
+	for _, tt := range tests {
+		rec := serve(t, h, http.MethodGet, tt.path, nil)
+		wantCode(t, rec, tt.status)
+		if got := errorCode(t, rec); got != tt.code {
+			t.Errorf("%s: error code %q, want %q", tt.path, got, tt.code)
+		}
+	}
+}
+
+// TestAsOfDuringWrites reads the catalog as of now while books are created
+// and updated, and the journal compacted, and checks that every read sees
+// a catalog that was: the books created so far, each updated but the last.
+func TestAsOfDuringWrites(t *testing.T) {
+	j, err := openJournal(newMemoryStore(newSequentialIDs()), filepath.Join(t.TempDir(), "journal"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { j.Close() })
+	j.compactAt = 2048
+
+	done := make(chan struct{})
+	defer func() { <-done }()
+	go func() {
+		defer close(done)
+		for range 200 {
+			book, err := j.Create(Book{Title: "Dune", Price: 1})
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			book.Price = 2
+			if err := j.Put(book); err != nil {
+				t.Error(err)
+				return
+			}
+		}
+	}()
+
+	for reads := 0; ; reads++ {
+		select {
+		case <-done:
+			j.mu.Lock()
+			defer j.mu.Unlock()
+			if j.compactions == 0 {
+				t.Error("the journal was never compacted")
+			}
+			return
+		default:
+		}
+		at, err := j.catalogAt(time.Now().Add(time.Hour))
+		if err != nil {
+			t.Fatal(err)
+		}
+		for id := 1; id <= len(at.books); id++ {
+			book, found := at.books[id]
+			if !found || (book.Price != 2 && id != len(at.books)) {
+				t.Fatalf("read %d: catalog of %d books has book %d as %+v", reads, len(at.books), id, book)
+			}
+		}
+	}
+}
@@ -0,0 +1,48 @@
+package booksapi
+
+import (
+	"context"
+	"sync/atomic"
+)
+
+// IDGenerator hands out the IDs of the books a store creates. Stores call
+// it for every new book and tell it about every ID already in use, so the
+// IDs of books loaded from a journal, a snapshot, or an import are never
+// handed out again. Implementations are safe for concurrent use.
+type IDGenerator interface {
+	// Next returns a positive ID that neither Next nor Observe has seen.
+	Next(ctx context.Context) (int, error)
+	// Observe records that id is in use.
+	Observe(id int)
+	// Peek returns the lowest ID Next may return, without taking it.
+	Peek() int
+}
+
+// sequentialIDs hands out IDs counting up from 1, above the highest ID
+// observed. The counter is atomic, so concurrent creates never share an ID
+// and need no lock. Its high-water mark is the store's NextID, which the
+// journal and snapshot stores persist and Reserve restores.
+type sequentialIDs struct {
+	last atomic.Int64
+}
+
+func newSequentialIDs() *sequentialIDs {
+	return &sequentialIDs{}
+}
+
+func (g *sequentialIDs) Next(context.Context) (int, error) {
+	return int(g.last.Add(1)), nil
+}
+
+func (g *sequentialIDs) Observe(id int) {
+	for {
+		last := g.last.Load()
+		if int64(id) <= last || g.last.CompareAndSwap(last, int64(id)) {
+			return
+		}
+	}
+}
+
+func (g *sequentialIDs) Peek() int {
+	return int(g.last.Load()) + 1
+}
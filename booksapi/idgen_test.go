@@ -0,0 +1,165 @@
+package booksapi
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"path/filepath"
+	"slices"
+	"sync"
+	"testing"
+)
+
+// TestSequentialIDsNext calls Next from many goroutines at once and checks
+// every ID was handed out once. Run with -race.
+func TestSequentialIDsNext(t *testing.T) {
+	g := newSequentialIDs()
+	const workers, perWorker = 16, 500
+	ids := make([][]int, workers)
+	var wg sync.WaitGroup
+	for w := range workers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for range perWorker {
+				id, err := g.Next(context.Background())
+				if err != nil {
+					t.Error(err)
+					return
+				}
+				ids[w] = append(ids[w], id)
+			}
+		}()
+	}
+	wg.Wait()
+
+	all := slices.Concat(ids...)
+	slices.Sort(all)
+	for i, id := range all {
+		if id != i+1 {
+			t.Fatalf("IDs handed out %v..., want 1 to %d once each", all[:i+1], workers*perWorker)
+		}
+	}
+	if got := g.Peek(); got != workers*perWorker+1 {
+		t.Errorf("Peek() = %d, want %d", got, workers*perWorker+1)
+	}
+}
+
+// TestSequentialIDsObserve checks that Next never hands out an ID at or
+// below one observed before, with Observe and Next called at the same time.
+func TestSequentialIDsObserve(t *testing.T) {
+	g := newSequentialIDs()
+	var wg sync.WaitGroup
+	for w := range 8 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range 200 {
+				observed := 10*i + w
+				g.Observe(observed)
+				id, _ := g.Next(context.Background())
+				if id <= observed {
+					t.Errorf("Next() = %d after Observe(%d)", id, observed)
+					return
+				}
+			}
+		}()
+	}
+	wg.Wait()
+
+	// Observing a lower ID than the ones handed out changes nothing.
+	next := g.Peek()
+	g.Observe(1)
+	if got := g.Peek(); got != next {
+		t.Errorf("Peek() = %d after observing a low ID, want %d", got, next)
+	}
+}
+
+// TestImportObservesIDs imports books with high IDs and checks the books
+// created afterwards, or without an ID in the import, do not collide with
+// them.
+func TestImportObservesIDs(t *testing.T) {
+	for name, newStore := range storeFactories {
+		t.Run(name, func(t *testing.T) {
+			h := newTestServer(t, WithStore(newStore(t)))
+			mustCreateBook(t, h, map[string]any{"title": "Dune"})
+
+			rec := serve(t, h, http.MethodPost, "/books/import", []map[string]any{
+				{"id": 500, "title": "Emma"},
+				{"title": "Beloved"},
+				{"id": 1000, "title": "Persuasion"},
+			})
+			wantCode(t, rec, http.StatusOK)
+			created := decode[importResult](t, rec).Created.IDs
+			if len(created) != 3 || !slices.Contains(created, 500) || !slices.Contains(created, 1000) {
+				t.Fatalf("import created %v, want 500, 1000, and one more", created)
+			}
+			for _, id := range created {
+				if id != 500 && id != 1000 && (id <= 1 || id > 1000) {
+					t.Errorf("book without an ID got %d", id)
+				}
+			}
+
+			for range 3 {
+				if book := mustCreateBook(t, h, map[string]any{"title": "After the import"}); book.ID <= 1000 {
+					t.Errorf("created book %d after importing book 1000", book.ID)
+				}
+			}
+			if n := store.Count(); n != 7 {
+				t.Errorf("%d books stored, want 7", n)
+			}
+		})
+	}
+}
+
+// persistentStores open the stores that keep the catalog in a file, over
+// an empty memory store, at path.
+var persistentStores = map[string]func(path string) (BookStore, error){
+	"journal": func(path string) (BookStore, error) {
+		return openJournal(newMemoryStore(newSequentialIDs()), path)
+	},
+	"snapshot": func(path string) (BookStore, error) {
+		return openSnapshotStore(newMemoryStore(newSequentialIDs()), path, 0)
+	},
+	"sqlite": func(path string) (BookStore, error) {
+		return openSQLiteStore(newMemoryStore(newSequentialIDs()), path)
+	},
+}
+
+// TestIDHighWaterMarkRestart checks that the IDs handed out, those of
+// deleted books and reserved ones included, are not handed out again after
+// a restart.
+func TestIDHighWaterMarkRestart(t *testing.T) {
+	for name, open := range persistentStores {
+		t.Run(name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "books")
+			s, err := open(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for range 3 {
+				if _, err := s.Create(Book{Title: "Dune"}); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if err := s.Delete(3); err != nil {
+				t.Fatal(err)
+			}
+			if err := s.Reserve(10); err != nil {
+				t.Fatal(err)
+			}
+			if err := s.(io.Closer).Close(); err != nil {
+				t.Fatal(err)
+			}
+
+			s, err = open(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer s.(io.Closer).Close()
+			if book, err := s.Create(Book{Title: "Emma"}); err != nil || book.ID != 11 {
+				t.Errorf("Create after a restart = %d, %v; want ID 11", book.ID, err)
+			}
+		})
+	}
+}
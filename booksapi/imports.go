@@ -0,0 +1,403 @@
+package booksapi
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"net/http"
+	"slices"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// importSessionTTL is how long an import session is kept after it was last
+// used. It is configured by a flag in Main.
+var importSessionTTL = time.Hour
+
+// maxReportedRowErrors caps the row errors listed by GET /imports/{id}; the
+// number of invalid rows is always reported in full.
+const maxReportedRowErrors = 100
+
+// Import session states.
+const (
+	importOpen       = "open"       // accepting chunks
+	importCommitting = "committing" // being applied to the catalog
+	importCommitted  = "committed"  // applied; Result holds the outcome
+	importFailed     = "failed"     // the commit was rejected; nothing was applied
+)
+
+// importCommitParams declares the query parameters of
+// POST /imports/{id}/commit. skip_invalid applies the valid rows of an
+// import some rows of which failed validation.
+var importCommitParams = slices.Concat(importParams, []queryParam{{name: "skip_invalid", kind: boolParam}})
+
+// importRow is a book uploaded in a chunk of an import session, or the
+// error that kept the row from being read as one.
+type importRow struct {
+	line int // 1-based line of the row in its chunk
+	book Book
+	err  *apiError
+}
+
+// importSession collects the chunks of a large import, uploaded in any
+// order over several requests, until it is committed in one go.
+type importSession struct {
+	id      string
+	state   string
+	chunks  map[int][]importRow // chunk number -> rows
+	expires time.Time
+	result  *importResult // outcome of the commit
+	err     *apiError     // why the commit failed
+}
+
+// importRowError reports a row of an import session that failed validation
+// or could not be applied.
+type importRowError struct {
+	Chunk int         `json:"chunk"`
+	Line  int         `json:"line"`
+	Error errorDetail `json:"error"`
+}
+
+// importSessionStatus is the response body of the import session endpoints.
+type importSessionStatus struct {
+	ID          string           `json:"id"`
+	State       string           `json:"state"`
+	Chunks      []int            `json:"chunks"`
+	Rows        int              `json:"rows"`
+	InvalidRows int              `json:"invalid_rows"`
+	Errors      []importRowError `json:"errors"` // the first maxReportedRowErrors
+	ExpiresAt   time.Time        `json:"expires_at"`
+	Result      *importResult    `json:"result,omitempty"`
+	Error       *errorDetail     `json:"error,omitempty"`
+}
+
+// Global variables to track import sessions, which are dropped once they
+// expire.
+var (
+	importSessions   = make(map[string]*importSession)
+	importSessionsMu sync.Mutex
+)
+
+// importsHandler creates import sessions (POST /imports).
+func importsHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeError(w, r, http.StatusMethodNotAllowed, "method_not_allowed")
+		return
+	}
+	if _, ok := checkQuery(w, r); !ok {
+		return
+	}
+
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		writeAPIError(w, r, err)
+		return
+	}
+	session := &importSession{
+		id:      hex.EncodeToString(b),
+		state:   importOpen,
+		chunks:  make(map[int][]importRow),
+		expires: time.Now().Add(importSessionTTL),
+	}
+
+	importSessionsMu.Lock()
+	defer importSessionsMu.Unlock()
+	sweepImportSessions()
+	importSessions[session.id] = session
+	setLocation(w, "/imports/"+session.id)
+	writeJSON(w, http.StatusCreated, session.status(requestLocale(r)))
+}
+
+// importHandler handles the operations on an import session:
+// GET /imports/{id}, DELETE /imports/{id}, PUT /imports/{id}/chunks/{n},
+// and POST /imports/{id}/commit.
+func importHandler(w http.ResponseWriter, r *http.Request) {
+	segments := pathSegments(r.URL.Path)
+	switch {
+	case len(segments) == 2:
+		switch r.Method {
+		case http.MethodGet, http.MethodHead:
+			getImportSession(w, r, segments[1])
+		case http.MethodDelete:
+			deleteImportSession(w, r, segments[1])
+		default:
+			writeError(w, r, http.StatusMethodNotAllowed, "method_not_allowed")
+		}
+	case len(segments) == 4 && segments[2] == "chunks":
+		if r.Method != http.MethodPut {
+			writeError(w, r, http.StatusMethodNotAllowed, "method_not_allowed")
+			return
+		}
+		n, err := strconv.Atoi(segments[3])
+		if err != nil || n < 0 {
+			writeError(w, r, http.StatusBadRequest, "invalid_chunk_number")
+			return
+		}
+		putImportChunk(w, r, segments[1], n)
+	case len(segments) == 3 && segments[2] == "commit":
+		if r.Method != http.MethodPost {
+			writeError(w, r, http.StatusMethodNotAllowed, "method_not_allowed")
+			return
+		}
+		commitImportSession(w, r, segments[1])
+	default:
+		writeError(w, r, http.StatusNotFound, "not_found")
+	}
+}
+
+// getImportSession reports the chunks, rows, and row errors of an import
+// session, and the outcome of its commit.
+func getImportSession(w http.ResponseWriter, r *http.Request, id string) {
+	if _, ok := checkQuery(w, r); !ok {
+		return
+	}
+
+	importSessionsMu.Lock()
+	defer importSessionsMu.Unlock()
+	session, err := lookupImportSession(id)
+	if err != nil {
+		writeAPIError(w, r, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, session.status(requestLocale(r)))
+}
+
+// deleteImportSession abandons an import session and its chunks.
+func deleteImportSession(w http.ResponseWriter, r *http.Request, id string) {
+	if _, ok := checkQuery(w, r); !ok {
+		return
+	}
+
+	importSessionsMu.Lock()
+	defer importSessionsMu.Unlock()
+	session, err := lookupImportSession(id)
+	if err != nil {
+		writeAPIError(w, r, err)
+		return
+	}
+	if session.state == importCommitting {
+		writeError(w, r, http.StatusConflict, "import_not_open", "state", session.state)
+		return
+	}
+	delete(importSessions, id)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// putImportChunk stores chunk n of an import session: newline-delimited JSON
+// books, which may be gzip-compressed like the body of POST /books/import.
+// Each row is validated on its own; rows that fail are reported by the
+// session rather than rejecting the chunk. Every chunk number is accepted
+// only once.
+func putImportChunk(w http.ResponseWriter, r *http.Request, id string, n int) {
+	if _, ok := checkQuery(w, r); !ok {
+		return
+	}
+
+	// The session is checked before the body is read, so a chunk for an
+	// expired session is not parsed in vain.
+	importSessionsMu.Lock()
+	_, err := lookupImportSession(id)
+	importSessionsMu.Unlock()
+	if err != nil {
+		writeAPIError(w, r, err)
+		return
+	}
+
+	data, err := readImportBody(w, r)
+	if err != nil {
+		writeAPIError(w, r, err)
+		return
+	}
+	mode := requestHandling(r)
+	w.Header().Set("Preference-Applied", "handling="+mode)
+	rows := parseImportChunk(r, data, mode == handlingStrict)
+
+	importSessionsMu.Lock()
+	defer importSessionsMu.Unlock()
+	session, err := lookupImportSession(id)
+	if err != nil {
+		writeAPIError(w, r, err)
+		return
+	}
+	if session.state != importOpen {
+		writeError(w, r, http.StatusConflict, "import_not_open", "state", session.state)
+		return
+	}
+	if _, taken := session.chunks[n]; taken {
+		writeError(w, r, http.StatusConflict, "chunk_already_uploaded", "chunk", n)
+		return
+	}
+	session.chunks[n] = rows
+	session.expires = time.Now().Add(importSessionTTL)
+	writeJSON(w, http.StatusCreated, session.status(requestLocale(r)))
+}
+
+// parseImportChunk decodes and validates the rows of a chunk uploaded by
+// the caller of r. Blank lines are skipped.
+func parseImportChunk(r *http.Request, data []byte, strict bool) []importRow {
+	caller := callerToken(r)
+	var rows []importRow
+	for i, line := range bytes.Split(data, []byte("\n")) {
+		line = bytes.TrimSpace(line)
+		if len(line) == 0 {
+			continue
+		}
+		row := importRow{line: i + 1}
+		err := decodeBody(r, line, &row.book, strict)
+		if err == nil {
+			err = checkFieldWrites(caller, Book{}, row.book)
+		}
+		if err == nil {
+			err = validateImportBook(&row.book)
+		}
+		if err != nil {
+			row.err = asAPIError(err)
+		}
+		rows = append(rows, row)
+	}
+	return rows
+}
+
+// commitImportSession applies the rows of every chunk of an import session,
+// in chunk and then line order, as POST /books/import would apply them in
+// one request. An import with invalid rows is rejected unless
+// ?skip_invalid=true is given; either way nothing is stored unless the
+// whole import can be. A commit running out of time leaves the session
+// open, to be committed again.
+func commitImportSession(w http.ResponseWriter, r *http.Request, id string) {
+	q, ok := checkQuery(w, r, importCommitParams...)
+	if !ok {
+		return
+	}
+	strategy, err := singleValue(q, importCommitParams, "strategy")
+	if err != nil {
+		writeAPIError(w, r, err)
+		return
+	}
+	if strategy == "" {
+		strategy = importDuplicate
+	}
+	skipInvalid := q.Bool("skip_invalid")
+
+	importSessionsMu.Lock()
+	session, err := lookupImportSession(id)
+	if err == nil && session.state != importOpen {
+		err = newAPIError(http.StatusConflict, "import_not_open", "state", session.state)
+	}
+	if err != nil {
+		importSessionsMu.Unlock()
+		writeAPIError(w, r, err)
+		return
+	}
+	list, invalid := session.books()
+	if invalid > 0 && !skipInvalid {
+		importSessionsMu.Unlock()
+		writeError(w, r, http.StatusUnprocessableEntity, "import_has_invalid_rows", "count", invalid)
+		return
+	}
+	session.state = importCommitting
+	importSessionsMu.Unlock()
+
+	answerDeadline(r)
+	mu.Lock()
+	result, err := applyImport(r.Context(), list, strategy)
+	mu.Unlock()
+
+	importSessionsMu.Lock()
+	defer importSessionsMu.Unlock()
+	session.expires = time.Now().Add(importSessionTTL)
+	if err != nil {
+		session.state, session.err = importFailed, asAPIError(err)
+		if r.Context().Err() != nil {
+			// Nothing was stored, so the commit can be tried again.
+			session.state, session.err = importOpen, nil
+		}
+		writeAPIError(w, r, err)
+		return
+	}
+	session.state, session.result = importCommitted, &result
+	writeJSON(w, http.StatusOK, session.status(requestLocale(r)))
+}
+
+// lookupImportSession returns the import session with the given ID.
+// Unknown and expired sessions get 410. Callers must hold importSessionsMu.
+func lookupImportSession(id string) (*importSession, error) {
+	session, found := importSessions[id]
+	if found && session.state != importCommitting && !time.Now().Before(session.expires) {
+		delete(importSessions, id)
+		found = false
+	}
+	if !found {
+		return nil, newAPIError(http.StatusGone, "import_expired")
+	}
+	return session, nil
+}
+
+// sweepImportSessions drops the expired import sessions. Callers must hold
+// importSessionsMu.
+func sweepImportSessions() {
+	now := time.Now()
+	for id, session := range importSessions {
+		if session.state != importCommitting && !now.Before(session.expires) {
+			delete(importSessions, id)
+		}
+	}
+}
+
+// chunkNumbers returns the numbers of the uploaded chunks in order.
+func (s *importSession) chunkNumbers() []int {
+	numbers := make([]int, 0, len(s.chunks))
+	for n := range s.chunks {
+		numbers = append(numbers, n)
+	}
+	sort.Ints(numbers)
+	return numbers
+}
+
+// books returns the valid rows of every chunk, in chunk and line order, and
+// the number of invalid rows left out.
+func (s *importSession) books() ([]Book, int) {
+	var list []Book
+	invalid := 0
+	for _, n := range s.chunkNumbers() {
+		for _, row := range s.chunks[n] {
+			if row.err != nil {
+				invalid++
+				continue
+			}
+			list = append(list, row.book)
+		}
+	}
+	return list, invalid
+}
+
+// status describes the session with its messages in locale.
+func (s *importSession) status(locale string) importSessionStatus {
+	status := importSessionStatus{
+		ID:        s.id,
+		State:     s.state,
+		Chunks:    s.chunkNumbers(),
+		Errors:    []importRowError{},
+		ExpiresAt: s.expires.UTC(),
+		Result:    s.result,
+	}
+	for _, n := range status.Chunks {
+		for _, row := range s.chunks[n] {
+			status.Rows++
+			if row.err == nil {
+				continue
+			}
+			status.InvalidRows++
+			if len(status.Errors) < maxReportedRowErrors {
+				status.Errors = append(status.Errors, importRowError{Chunk: n, Line: row.line, Error: newErrorDetail(row.err, locale)})
+			}
+		}
+	}
+	if s.err != nil {
+		detail := newErrorDetail(s.err, locale)
+		status.Error = &detail
+	}
+	return status
+}
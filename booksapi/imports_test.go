@@ -0,0 +1,152 @@
+package booksapi
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+// openImportSession opens an import session, returning its ID.
+func openImportSession(t *testing.T, h http.Handler) string {
+	t.Helper()
+	rec := serve(t, h, http.MethodPost, "/imports", nil)
+	wantCode(t, rec, http.StatusCreated)
+	status := decode[importSessionStatus](t, rec)
+	if status.State != importOpen || rec.Header().Get("Location") != "/imports/"+status.ID {
+		t.Fatalf("session %+v at %q", status, rec.Header().Get("Location"))
+	}
+	return status.ID
+}
+
+// putChunk uploads chunk n of an import session, a line per row.
+func putChunk(t *testing.T, h http.Handler, id string, n int, rows ...string) *httptest.ResponseRecorder {
+	t.Helper()
+	return serve(t, h, http.MethodPut, fmt.Sprintf("/imports/%s/chunks/%d", id, n), strings.Join(rows, "\n")+"\n")
+}
+
+// catalogTitles returns the titles of the books in the catalog, by ID.
+func catalogTitles() []string {
+	var titles []string
+	for _, book := range store.List(ListOptions{}) {
+		titles = append(titles, book.Title)
+	}
+	return titles
+}
+
+// TestImportSession uploads chunks out of order, one with an invalid row,
+// and commits them.
+func TestImportSession(t *testing.T) {
+	h := newTestServer(t)
+	id := openImportSession(t, h)
+
+	wantCode(t, putChunk(t, h, id, 2, `{"title": "Jazz"}`, `{"title": "Kokoro"}`), http.StatusCreated)
+	wantCode(t, putChunk(t, h, id, 0, `{"title": "Dune"}`, ``, `{"title": "Emma"}`), http.StatusCreated)
+	rec := putChunk(t, h, id, 1, `{"title": "Beloved"}`, `{"title": " ", "price": 5}`)
+	wantCode(t, rec, http.StatusCreated)
+	status := decode[importSessionStatus](t, rec)
+	if !slices.Equal(status.Chunks, []int{0, 1, 2}) || status.Rows != 6 || status.InvalidRows != 1 {
+		t.Errorf("session %+v, want chunks 0 to 2 with 6 rows, 1 invalid", status)
+	}
+	if len(status.Errors) != 1 || status.Errors[0].Chunk != 1 || status.Errors[0].Line != 2 || status.Errors[0].Error.Code != "field_required" {
+		t.Errorf("row errors %+v, want field_required on line 2 of chunk 1", status.Errors)
+	}
+
+	// Each chunk number is taken once.
+	rec = putChunk(t, h, id, 1, `{"title": "Beloved"}`)
+	wantCode(t, rec, http.StatusConflict)
+	if got := errorCode(t, rec); got != "chunk_already_uploaded" {
+		t.Errorf("error code %q, want chunk_already_uploaded", got)
+	}
+
+	// The invalid row holds back the commit until it is skipped.
+	rec = serve(t, h, http.MethodPost, "/imports/"+id+"/commit", nil)
+	wantCode(t, rec, http.StatusUnprocessableEntity)
+	if got := errorCode(t, rec); got != "import_has_invalid_rows" {
+		t.Errorf("error code %q, want import_has_invalid_rows", got)
+	}
+	if n := store.Count(); n != 0 {
+		t.Fatalf("%d books stored by a refused commit", n)
+	}
+	rec = serve(t, h, http.MethodPost, "/imports/"+id+"/commit?skip_invalid=true", nil)
+	wantCode(t, rec, http.StatusOK)
+	status = decode[importSessionStatus](t, rec)
+	if status.State != importCommitted || status.Result == nil || status.Result.Imported != 5 {
+		t.Errorf("committed session %+v, want 5 books imported", status)
+	}
+	if got, want := catalogTitles(), []string{"Dune", "Emma", "Beloved", "Jazz", "Kokoro"}; !slices.Equal(got, want) {
+		t.Errorf("catalog %q, want %q", got, want)
+	}
+
+	// A committed session takes nothing more.
+	rec = serve(t, h, http.MethodGet, "/imports/"+id, nil)
+	wantCode(t, rec, http.StatusOK)
+	if got := decode[importSessionStatus](t, rec); got.State != importCommitted || got.Result.Created.Count != 5 {
+		t.Errorf("session %+v after the commit", got)
+	}
+	wantCode(t, putChunk(t, h, id, 3, `{"title": "Persuasion"}`), http.StatusConflict)
+	wantCode(t, serve(t, h, http.MethodPost, "/imports/"+id+"/commit", nil), http.StatusConflict)
+	if n := store.Count(); n != 5 {
+		t.Errorf("%d books after committing twice, want 5", n)
+	}
+}
+
+// TestImportSessionConflict commits a session under the fail strategy
+// with a row matching the catalog, and checks that nothing is stored and
+// the session reports why.
+func TestImportSessionConflict(t *testing.T) {
+	h := newTestServer(t)
+	mustCreateBook(t, h, map[string]any{"title": "Emma", "author": "Jane Austen"})
+	id := openImportSession(t, h)
+	wantCode(t, putChunk(t, h, id, 0, `{"title": "Dune"}`, `{"title": "emma", "author": "jane austen"}`), http.StatusCreated)
+
+	rec := serve(t, h, http.MethodPost, "/imports/"+id+"/commit?strategy=fail", nil)
+	wantCode(t, rec, http.StatusConflict)
+	if got := errorCode(t, rec); got != "import_conflict" {
+		t.Errorf("error code %q, want import_conflict", got)
+	}
+	if got := catalogTitles(); !slices.Equal(got, []string{"Emma"}) {
+		t.Errorf("catalog %q after a failed commit", got)
+	}
+	status := decode[importSessionStatus](t, serve(t, h, http.MethodGet, "/imports/"+id, nil))
+	if status.State != importFailed || status.Error == nil || status.Error.Code != "import_conflict" {
+		t.Errorf("session %+v, want it failed with import_conflict", status)
+	}
+}
+
+// TestImportSessionExpired checks that expired, abandoned, and unknown
+// sessions get 410.
+func TestImportSessionExpired(t *testing.T) {
+	h := newTestServer(t)
+	expired := openImportSession(t, h)
+	wantCode(t, putChunk(t, h, expired, 0, `{"title": "Dune"}`), http.StatusCreated)
+	importSessionsMu.Lock()
+	importSessions[expired].expires = time.Now().Add(-time.Second)
+	importSessionsMu.Unlock()
+
+	abandoned := openImportSession(t, h)
+	wantCode(t, serve(t, h, http.MethodDelete, "/imports/"+abandoned, nil), http.StatusNoContent)
+
+	for _, id := range []string{expired, abandoned, "unknown"} {
+		for _, rec := range []*httptest.ResponseRecorder{
+			serve(t, h, http.MethodGet, "/imports/"+id, nil),
+			putChunk(t, h, id, 1, `{"title": "Emma"}`),
+			serve(t, h, http.MethodPost, "/imports/"+id+"/commit", nil),
+		} {
+			wantCode(t, rec, http.StatusGone)
+			if got := errorCode(t, rec); got != "import_expired" {
+				t.Errorf("session %s: error code %q, want import_expired", id, got)
+			}
+		}
+	}
+	if n := store.Count(); n != 0 {
+		t.Errorf("%d books stored from expired sessions", n)
+	}
+
+	id := openImportSession(t, h)
+	wantCode(t, serve(t, h, http.MethodPut, "/imports/"+id+"/chunks/-1", "{}"), http.StatusBadRequest)
+	wantCode(t, serve(t, h, http.MethodPost, "/imports/"+id+"/chunks/1", "{}"), http.StatusMethodNotAllowed)
+}
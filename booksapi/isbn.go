@@ -0,0 +1,53 @@
+package booksapi
+
+import (
+	"net/http"
+	"strings"
+)
+
+// normalizeISBN validates an ISBN-10 or ISBN-13, including its check digit,
+// and returns it without hyphens or spaces. An empty string is allowed and
+// means no ISBN.
+func normalizeISBN(isbn string) (string, error) {
+	digits := strings.ToUpper(strings.NewReplacer("-", "", " ", "").Replace(isbn))
+	if digits == "" {
+		return "", nil
+	}
+	if !validISBN(digits) {
+		return "", newAPIError(http.StatusUnprocessableEntity, "invalid_isbn", "isbn", isbn)
+	}
+	return digits, nil
+}
+
+// validISBN reports whether digits, already stripped of separators, is an
+// ISBN-10 or ISBN-13 with a correct check digit.
+func validISBN(digits string) bool {
+	switch len(digits) {
+	case 10:
+		sum := 0
+		for i, c := range digits {
+			value := int(c - '0')
+			if c == 'X' && i == 9 {
+				value = 10
+			} else if c < '0' || c > '9' {
+				return false
+			}
+			sum += (10 - i) * value
+		}
+		return sum%11 == 0
+	case 13:
+		sum := 0
+		for i, c := range digits {
+			if c < '0' || c > '9' {
+				return false
+			}
+			weight := 1
+			if i%2 == 1 {
+				weight = 3
+			}
+			sum += weight * int(c-'0')
+		}
+		return sum%10 == 0
+	}
+	return false
+}
@@ -0,0 +1,408 @@
+package booksapi
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"time"
+)
+
+// Journal settings, configured by flags in Main. An empty path disables the
+// journal, and a compaction threshold of zero never compacts it.
+var (
+	journalPath         string
+	journalFsync              = true
+	journalCompactBytes int64 = 4 << 20
+)
+
+// Journal operations.
+const (
+	opCreate  = "create"
+	opUpdate  = "update"
+	opDelete  = "delete"
+	opReserve = "reserve"
+)
+
+// journalRecord is one line of the journal.
+type journalRecord struct {
+	SchemaVersion int       `json:"schema_version"` // see schemaVersion
+	Op            string    `json:"op"`
+	ID            int       `json:"id"`
+	Book          *Book     `json:"book,omitempty"`
+	Time          time.Time `json:"time"`
+}
+
+// journalStore records every change to an underlying store in an
+// append-only journal, so the catalog survives a restart. Records hold the
+// complete new state of a book, which makes replaying them idempotent.
+type journalStore struct {
+	BookStore
+
+	mu        sync.Mutex
+	path      string
+	file      *os.File
+	size      int64
+	lastID    int
+	fsync     bool
+	compactAt int64
+
+	// compactions counts the compactions, which replace the snapshot and
+	// empty the journal, for readers of the files not holding mu.
+	compactions int
+
+	// oldestVersion is the oldest schema version of the snapshot and the
+	// records loaded when the journal was opened.
+	oldestVersion int
+}
+
+// openJournal rebuilds inner from the snapshot and journal at path and
+// returns a store that journals further changes. A torn final record, left
+// behind by a crash in the middle of a write, is dropped. A snapshot or
+// records of an older schema version are migrated, and then rewritten by a
+// compaction, keeping the original files as .bak files.
+func openJournal(inner BookStore, path string) (*journalStore, error) {
+	s := &journalStore{BookStore: inner, path: path, fsync: journalFsync, compactAt: journalCompactBytes, oldestVersion: schemaVersion}
+	if err := s.loadSnapshot(); err != nil {
+		return nil, err
+	}
+	if err := s.replay(); err != nil {
+		return nil, err
+	}
+	if s.oldestVersion < schemaVersion {
+		if err := s.migrate(); err != nil {
+			s.file.Close()
+			return nil, err
+		}
+	}
+	return s, nil
+}
+
+// migrate rewrites a journal that was loaded from files of an older schema
+// version, after backing them up.
+func (s *journalStore) migrate() error {
+	for _, path := range []string{s.snapshotPath(), s.path} {
+		if err := backupOnce(path); err != nil {
+			return err
+		}
+	}
+	if err := s.compact(); err != nil {
+		return err
+	}
+	logMigration(s.path, s.oldestVersion)
+	return nil
+}
+
+// snapshotPath returns the path of the journal's snapshot file.
+func (s *journalStore) snapshotPath() string {
+	return s.path + ".snapshot"
+}
+
+// loadSnapshot restores the books of the last compaction, if there was one.
+func (s *journalStore) loadSnapshot() error {
+	lastID, version, err := loadSnapshot(s.BookStore, s.snapshotPath())
+	if err != nil {
+		return err
+	}
+	s.oldestVersion = min(s.oldestVersion, version)
+	return s.reserve(lastID)
+}
+
+// replay applies the journal's records and opens it for appending.
+func (s *journalStore) replay() error {
+	file, err := os.OpenFile(s.path, os.O_RDWR|os.O_CREATE, 0o644)
+	if err != nil {
+		return err
+	}
+
+	var offset int64
+	reader := bufio.NewReader(file)
+	for {
+		line, err := reader.ReadBytes('\n')
+		if errors.Is(err, io.EOF) {
+			// A final line without a newline was cut short by a crash.
+			break
+		}
+		if err != nil {
+			file.Close()
+			return err
+		}
+
+		var rec journalRecord
+		version, err := decodeVersioned(line, &rec)
+		if errors.Is(err, errSchemaTooNew) {
+			file.Close()
+			return fmt.Errorf("journal %s: record at offset %d: %w", s.path, offset, err)
+		}
+		if err != nil {
+			if _, peekErr := reader.Peek(1); errors.Is(peekErr, io.EOF) {
+				break
+			}
+			file.Close()
+			return fmt.Errorf("journal %s: corrupt record at offset %d: %w", s.path, offset, err)
+		}
+		s.oldestVersion = min(s.oldestVersion, version)
+		if err := s.apply(rec); err != nil {
+			file.Close()
+			return err
+		}
+		offset += int64(len(line))
+	}
+
+	// Drop the torn record so new records do not get appended to it.
+	if err := file.Truncate(offset); err != nil {
+		file.Close()
+		return err
+	}
+	if _, err := file.Seek(offset, io.SeekStart); err != nil {
+		file.Close()
+		return err
+	}
+	s.file = file
+	s.size = offset
+	return nil
+}
+
+// apply replays one journal record onto the underlying store.
+func (s *journalStore) apply(rec journalRecord) error {
+	switch rec.Op {
+	case opCreate, opUpdate:
+		if rec.Book == nil {
+			return fmt.Errorf("journal %s: %s record for book %d has no book", s.path, rec.Op, rec.ID)
+		}
+		if err := s.BookStore.Put(*rec.Book); err != nil {
+			return err
+		}
+		return s.reserve(rec.Book.ID)
+	case opDelete:
+		return s.BookStore.Delete(rec.ID)
+	case opReserve:
+		return s.reserve(rec.ID)
+	default:
+		return fmt.Errorf("journal %s: unknown op %q", s.path, rec.Op)
+	}
+}
+
+// reserve records that id has been handed out.
+func (s *journalStore) reserve(id int) error {
+	if id > s.lastID {
+		s.lastID = id
+	}
+	return s.BookStore.Reserve(id)
+}
+
+// Unwrap returns the wrapped store.
+func (s *journalStore) Unwrap() BookStore { return s.BookStore }
+
+func (s *journalStore) Create(book Book) (Book, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	tx := &undoStore{BookStore: s.BookStore}
+	book, err := tx.Create(book)
+	if err != nil {
+		return book, err
+	}
+	if err := s.reserve(book.ID); err != nil {
+		return book, err
+	}
+	return book, s.commit(tx, journalRecord{Op: opCreate, ID: book.ID, Book: &book})
+}
+
+func (s *journalStore) Put(book Book) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	tx := &undoStore{BookStore: s.BookStore}
+	if err := tx.Put(book); err != nil {
+		return err
+	}
+	return s.commit(tx, journalRecord{Op: opUpdate, ID: book.ID, Book: &book})
+}
+
+func (s *journalStore) Delete(id int) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	tx := &undoStore{BookStore: s.BookStore}
+	if err := tx.Delete(id); err != nil {
+		return err
+	}
+	return s.commit(tx, journalRecord{Op: opDelete, ID: id})
+}
+
+// commit journals rec, the record of the write made through tx, undoing
+// the write if the record cannot be written: the store never holds a
+// change a restart would lose. Callers must hold s.mu.
+func (s *journalStore) commit(tx *undoStore, rec journalRecord) error {
+	if err := s.write(rec); err != nil {
+		if undoErr := tx.rollback(); undoErr != nil {
+			return fmt.Errorf("undoing a write the journal lost (%v): %w", err, undoErr)
+		}
+		return err
+	}
+	return s.compactIfDue()
+}
+
+// Transact runs fn as a transaction of the wrapped store and journals its
+// writes once it has succeeded, so an undone transaction is never replayed.
+// The IDs it handed out are journaled either way, so they are not reused
+// after a restart.
+func (s *journalStore) Transact(ctx context.Context, fn func(tx BookStore) error) error {
+	var records []journalRecord
+	err := s.BookStore.Transact(ctx, func(tx BookStore) error {
+		return fn(journalTx{tx, &records})
+	})
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err != nil {
+		records = nil
+	}
+	for _, rec := range records {
+		if rec.Op == opCreate || rec.Op == opReserve {
+			s.lastID = max(s.lastID, rec.ID)
+		}
+	}
+	if last := s.BookStore.NextID() - 1; last > s.lastID {
+		s.lastID = last
+		records = append(records, journalRecord{Op: opReserve, ID: last})
+	}
+	for _, rec := range records {
+		if appendErr := s.append(rec); appendErr != nil {
+			return appendErr
+		}
+	}
+	return err
+}
+
+// journalTx is the view of a transaction that journalStore hands to fn,
+// collecting the records of its writes.
+type journalTx struct {
+	BookStore
+	records *[]journalRecord
+}
+
+// Unwrap returns the wrapped transaction.
+func (tx journalTx) Unwrap() BookStore { return tx.BookStore }
+
+func (tx journalTx) Create(book Book) (Book, error) {
+	book, err := tx.BookStore.Create(book)
+	if err == nil {
+		*tx.records = append(*tx.records, journalRecord{Op: opCreate, ID: book.ID, Book: &book})
+	}
+	return book, err
+}
+
+func (tx journalTx) Put(book Book) error {
+	err := tx.BookStore.Put(book)
+	if err == nil {
+		*tx.records = append(*tx.records, journalRecord{Op: opUpdate, ID: book.ID, Book: &book})
+	}
+	return err
+}
+
+func (tx journalTx) Delete(id int) error {
+	err := tx.BookStore.Delete(id)
+	if err == nil {
+		*tx.records = append(*tx.records, journalRecord{Op: opDelete, ID: id})
+	}
+	return err
+}
+
+func (tx journalTx) Reserve(id int) error {
+	err := tx.BookStore.Reserve(id)
+	if err == nil {
+		*tx.records = append(*tx.records, journalRecord{Op: opReserve, ID: id})
+	}
+	return err
+}
+
+// Transact runs a nested transaction, whose writes and their undoing are
+// both journaled.
+func (tx journalTx) Transact(ctx context.Context, fn func(tx BookStore) error) error {
+	return transactWithUndo(ctx, tx, fn)
+}
+
+func (s *journalStore) Reserve(id int) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.reserve(id); err != nil {
+		return err
+	}
+	return s.append(journalRecord{Op: opReserve, ID: id})
+}
+
+// append writes a record to the journal, compacting the journal once it
+// outgrows its threshold. Callers must hold s.mu.
+func (s *journalStore) append(rec journalRecord) error {
+	if err := s.write(rec); err != nil {
+		return err
+	}
+	return s.compactIfDue()
+}
+
+// write writes a record to the journal. A record that cannot be written
+// whole is cut off again, so that it is not replayed and the next record
+// does not follow a torn one. Callers must hold s.mu.
+func (s *journalStore) write(rec journalRecord) error {
+	rec.SchemaVersion = schemaVersion
+	rec.Time = time.Now().UTC()
+	line, err := json.Marshal(rec)
+	if err != nil {
+		return err
+	}
+	line = append(line, '\n')
+
+	_, err = s.file.Write(line)
+	if err == nil && s.fsync {
+		err = s.file.Sync()
+	}
+	if err != nil {
+		s.file.Truncate(s.size)
+		s.file.Seek(s.size, io.SeekStart)
+		return fmt.Errorf("%w: journal %s: %w", ErrUnavailable, s.path, err)
+	}
+	s.size += int64(len(line))
+	return nil
+}
+
+// compactIfDue compacts the journal once it outgrows its threshold.
+// Callers must hold s.mu.
+func (s *journalStore) compactIfDue() error {
+	if s.compactAt > 0 && s.size > s.compactAt {
+		return s.compact()
+	}
+	return nil
+}
+
+// compact writes the current books to the snapshot file and starts a fresh
+// journal. The snapshot is in place before the journal is emptied, so a
+// crash in between only replays records the snapshot already contains.
+// Callers must hold s.mu.
+func (s *journalStore) compact() error {
+	if _, err := saveSnapshot(s.BookStore, s.lastID, s.snapshotPath()); err != nil {
+		return err
+	}
+
+	if err := s.file.Truncate(0); err != nil {
+		return fmt.Errorf("journal %s: %w", s.path, err)
+	}
+	if _, err := s.file.Seek(0, io.SeekStart); err != nil {
+		return fmt.Errorf("journal %s: %w", s.path, err)
+	}
+	s.size = 0
+	s.compactions++
+	return s.file.Sync()
+}
+
+// Close closes the journal file.
+func (s *journalStore) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.file.Close()
+}
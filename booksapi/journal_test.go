@@ -0,0 +1,199 @@
+package booksapi
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// openTestJournal opens the journal at path over a new memory store,
+// closing it at the end of the test. Journals opened again on the same path
+// without closing the last one stand for a process killed and restarted.
+func openTestJournal(t *testing.T, path string) *journalStore {
+	t.Helper()
+	j, err := openJournal(newMemoryStore(newSequentialIDs()), path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { j.Close() })
+	return j
+}
+
+// TestJournalReplay makes every kind of change, then replays the journal
+// into a new store as a restart after a kill would, and checks that the
+// catalog and the next ID come back as they were.
+func TestJournalReplay(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "journal")
+	j := openTestJournal(t, path)
+	for _, title := range []string{"Dune", "Emma", "Jazz", "Kokoro"} {
+		if _, err := j.Create(Book{Title: title}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := j.Put(Book{ID: 2, Title: "Emma", Author: "Jane Austen"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := j.Delete(4); err != nil {
+		t.Fatal(err)
+	}
+	err := j.Transact(context.Background(), func(tx BookStore) error {
+		if _, err := tx.Create(Book{Title: "Beloved"}); err != nil {
+			return err
+		}
+		return tx.Delete(3)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = j.Transact(context.Background(), func(tx BookStore) error {
+		if _, err := tx.Create(Book{Title: "Abandoned"}); err != nil {
+			return err
+		}
+		return errors.New("abandoned")
+	})
+	if err == nil {
+		t.Fatal("transaction succeeded")
+	}
+	want, next := catalogJSON(t, j), j.NextID()
+
+	restarted := openTestJournal(t, path)
+	if got := catalogJSON(t, restarted); got != want {
+		t.Errorf("replayed catalog %s, want %s", got, want)
+	}
+	// IDs handed out before the restart, even to books deleted since or to
+	// an undone transaction, are not handed out again.
+	if got := restarted.NextID(); got != next || got != 7 {
+		t.Errorf("next ID %d after the restart, want %d", got, next)
+	}
+}
+
+// TestJournalTornRecord cuts the last record short, as a crash in the
+// middle of a write does, and checks that a restart drops it and appends
+// after the last whole record.
+func TestJournalTornRecord(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "journal")
+	j := openTestJournal(t, path)
+	for _, title := range []string{"Dune", "Emma"} {
+		if _, err := j.Create(Book{Title: title}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	want := catalogJSON(t, j)
+	whole, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_WRONLY, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.WriteString(`{"schema_version":1,"op":"create","id":3,"book":{"id":3,"ti`)
+	f.Close()
+
+	restarted := openTestJournal(t, path)
+	if got := catalogJSON(t, restarted); got != want {
+		t.Errorf("catalog %s after a torn record, want %s", got, want)
+	}
+	if data, err := os.ReadFile(path); err != nil || string(data) != string(whole) {
+		t.Errorf("journal not cut back to its whole records: %q (%v)", data, err)
+	}
+	if _, err := restarted.Create(Book{Title: "Jazz"}); err != nil {
+		t.Fatal(err)
+	}
+	if got := openTestJournal(t, path).Count(); got != 3 {
+		t.Errorf("%d books after writing past the torn record, want 3", got)
+	}
+}
+
+// TestJournalCorruptRecord checks that a damaged record before the last
+// one is not skipped silently, since the records after it depend on it.
+func TestJournalCorruptRecord(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "journal")
+	j := openTestJournal(t, path)
+	for _, title := range []string{"Dune", "Emma"} {
+		if _, err := j.Create(Book{Title: title}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	first, _, _ := strings.Cut(string(data), "\n")
+	if err := os.WriteFile(path, []byte(strings.Replace(string(data), `"op"`, `"op`, 1)), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	_, err = openJournal(newMemoryStore(newSequentialIDs()), path)
+	if err == nil || !strings.Contains(err.Error(), "corrupt record at offset 0") {
+		t.Errorf("opening a journal whose record %q is damaged: %v", first, err)
+	}
+}
+
+// TestJournalCompaction checks that a journal outgrowing its threshold is
+// folded into its snapshot, and that a restart restores the catalog from
+// the snapshot and the records written after it.
+func TestJournalCompaction(t *testing.T) {
+	setForTest(t, &journalCompactBytes, 1000)
+	path := filepath.Join(t.TempDir(), "journal")
+	j := openTestJournal(t, path)
+	for i := range 20 {
+		book, err := j.Create(Book{Title: strings.Repeat("x", i)})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if i%3 == 0 {
+			if err := j.Delete(book.ID); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Size() > 1000 {
+		t.Errorf("journal of %d bytes was not compacted", info.Size())
+	}
+	if _, err := os.Stat(j.snapshotPath()); err != nil {
+		t.Errorf("no snapshot after compacting: %v", err)
+	}
+
+	want := catalogJSON(t, j)
+	restarted := openTestJournal(t, path)
+	if got := catalogJSON(t, restarted); got != want {
+		t.Errorf("catalog %s after a restart, want %s", got, want)
+	}
+	if got := restarted.NextID(); got != 21 {
+		t.Errorf("next ID %d after a restart, want 21", got)
+	}
+}
+
+// TestJournalWriteFails checks that a write whose record cannot be
+// journaled fails as unavailable and leaves the store as it was.
+func TestJournalWriteFails(t *testing.T) {
+	j := openTestJournal(t, filepath.Join(t.TempDir(), "journal"))
+	if _, err := j.Create(Book{Title: "Dune"}); err != nil {
+		t.Fatal(err)
+	}
+	want := catalogJSON(t, j)
+	j.file.Close()
+
+	if _, err := j.Create(Book{Title: "Emma"}); !errors.Is(err, ErrUnavailable) {
+		t.Errorf("Create: %v, want ErrUnavailable", err)
+	}
+	if err := j.Put(Book{ID: 1, Title: "Dune Messiah"}); !errors.Is(err, ErrUnavailable) {
+		t.Errorf("Put: %v, want ErrUnavailable", err)
+	}
+	if err := j.Put(Book{ID: 7, Title: "Jazz"}); !errors.Is(err, ErrUnavailable) {
+		t.Errorf("Put of a new book: %v, want ErrUnavailable", err)
+	}
+	if err := j.Delete(1); !errors.Is(err, ErrUnavailable) {
+		t.Errorf("Delete: %v, want ErrUnavailable", err)
+	}
+	if got := catalogJSON(t, j); got != want {
+		t.Errorf("catalog after failed writes:\n%s\nwant:\n%s", got, want)
+	}
+}
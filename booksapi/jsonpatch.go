@@ -0,0 +1,257 @@
+package booksapi
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+// patchOperation is a single operation of an RFC 6902 JSON Patch document.
+type patchOperation struct {
+	Op    string          `json:"op"`
+	Path  string          `json:"path"`
+	Value json.RawMessage `json:"value"`
+}
+
+// Errors reported while resolving a JSON Pointer.
+var (
+	errInvalidPointer  = errors.New("invalid path")
+	errPathNotFound    = errors.New("path does not exist")
+	errIndexOutOfRange = errors.New("array index out of bounds")
+)
+
+// applyJSONPatch applies an RFC 6902 JSON Patch document to book. The
+// operations are applied in order to a copy of the book's JSON
+// representation; if any of them fails, book is left unchanged and the error
+// names the index of the failing operation.
+func applyJSONPatch(book *Book, ops []patchOperation) error {
+	current, err := toJSONObject(*book)
+	if err != nil {
+		return err
+	}
+	var doc any = current
+
+	for i, op := range ops {
+		tokens, err := parsePointer(op.Path)
+		if err != nil {
+			return patchOpError(i, op.Path, err)
+		}
+		if len(tokens) > 0 && tokens[0] == "id" && op.Op != "test" {
+			return newAPIError(http.StatusUnprocessableEntity, "patch_id_read_only", "index", i)
+		}
+
+		var value any
+		if op.Op == "add" || op.Op == "replace" || op.Op == "test" {
+			if op.Value == nil {
+				return newAPIError(http.StatusUnprocessableEntity, "patch_missing_value", "index", i)
+			}
+			if err := json.Unmarshal(op.Value, &value); err != nil {
+				return newAPIError(http.StatusUnprocessableEntity, "patch_missing_value", "index", i)
+			}
+		}
+
+		switch op.Op {
+		case "add", "replace", "remove":
+			doc, err = patchAt(doc, tokens, op.Op, value)
+			if err != nil {
+				return patchOpError(i, op.Path, err)
+			}
+		case "test":
+			current, err := valueAt(doc, tokens)
+			if err != nil {
+				return patchOpError(i, op.Path, err)
+			}
+			if !jsonEqual(current, value) {
+				return newAPIError(http.StatusConflict, "patch_test_failed", "index", i, "path", op.Path)
+			}
+		default:
+			return newAPIError(http.StatusUnprocessableEntity, "patch_unsupported_op", "index", i, "op", op.Op)
+		}
+	}
+
+	obj, ok := doc.(map[string]any)
+	if !ok {
+		return newAPIError(http.StatusUnprocessableEntity, "invalid_patch")
+	}
+	return fromJSONObject(obj, book)
+}
+
+// patchOpError reports a pointer resolution failure of operation index.
+func patchOpError(index int, path string, err error) error {
+	code := "patch_path_not_found"
+	switch err {
+	case errInvalidPointer:
+		code = "patch_invalid_path"
+	case errIndexOutOfRange:
+		code = "patch_index_out_of_range"
+	}
+	return newAPIError(http.StatusUnprocessableEntity, code, "index", index, "path", path)
+}
+
+// parsePointer splits an RFC 6901 JSON Pointer into its unescaped tokens.
+// The empty pointer, naming the whole document, is not accepted.
+func parsePointer(path string) ([]string, error) {
+	if !strings.HasPrefix(path, "/") {
+		return nil, errInvalidPointer
+	}
+	tokens := strings.Split(path[1:], "/")
+	for i, token := range tokens {
+		tokens[i] = strings.ReplaceAll(strings.ReplaceAll(token, "~1", "/"), "~0", "~")
+	}
+	return tokens, nil
+}
+
+// valueAt returns the value doc holds at the location named by tokens.
+func valueAt(doc any, tokens []string) (any, error) {
+	for _, token := range tokens {
+		switch node := doc.(type) {
+		case map[string]any:
+			value, ok := node[token]
+			if !ok {
+				return nil, errPathNotFound
+			}
+			doc = value
+		case []any:
+			index, err := arrayIndex(token, len(node)-1)
+			if err != nil {
+				return nil, err
+			}
+			doc = node[index]
+		default:
+			return nil, errPathNotFound
+		}
+	}
+	return doc, nil
+}
+
+// patchAt performs an add, replace, or remove operation at the location
+// named by tokens and returns the updated document. Containers are copied
+// rather than modified so the original document is never changed.
+func patchAt(doc any, tokens []string, op string, value any) (any, error) {
+	token, rest := tokens[0], tokens[1:]
+
+	switch node := doc.(type) {
+	case map[string]any:
+		out := make(map[string]any, len(node)+1)
+		for k, v := range node {
+			out[k] = v
+		}
+		child, exists := node[token]
+		if len(rest) > 0 {
+			if !exists {
+				return nil, errPathNotFound
+			}
+			updated, err := patchAt(child, rest, op, value)
+			if err != nil {
+				return nil, err
+			}
+			out[token] = updated
+			return out, nil
+		}
+		switch op {
+		case "add":
+			out[token] = value
+		case "replace":
+			if !exists {
+				return nil, errPathNotFound
+			}
+			out[token] = value
+		case "remove":
+			if !exists {
+				return nil, errPathNotFound
+			}
+			delete(out, token)
+		}
+		return out, nil
+
+	case []any:
+		if len(rest) > 0 {
+			index, err := arrayIndex(token, len(node)-1)
+			if err != nil {
+				return nil, err
+			}
+			updated, err := patchAt(node[index], rest, op, value)
+			if err != nil {
+				return nil, err
+			}
+			out := append([]any(nil), node...)
+			out[index] = updated
+			return out, nil
+		}
+		switch op {
+		case "add":
+			index := len(node)
+			if token != "-" {
+				var err error
+				if index, err = arrayIndex(token, len(node)); err != nil {
+					return nil, err
+				}
+			}
+			out := make([]any, 0, len(node)+1)
+			out = append(out, node[:index]...)
+			out = append(out, value)
+			return append(out, node[index:]...), nil
+		case "replace":
+			index, err := arrayIndex(token, len(node)-1)
+			if err != nil {
+				return nil, err
+			}
+			out := append([]any(nil), node...)
+			out[index] = value
+			return out, nil
+		default: // remove
+			index, err := arrayIndex(token, len(node)-1)
+			if err != nil {
+				return nil, err
+			}
+			out := make([]any, 0, len(node)-1)
+			out = append(out, node[:index]...)
+			return append(out, node[index+1:]...), nil
+		}
+	}
+	return nil, errPathNotFound
+}
+
+// arrayIndex parses an array index token, which must be at most max.
+func arrayIndex(token string, max int) (int, error) {
+	index, err := strconv.Atoi(token)
+	if err != nil || index < 0 || (len(token) > 1 && token[0] == '0') {
+		return 0, errInvalidPointer
+	}
+	if index > max {
+		return 0, errIndexOutOfRange
+	}
+	return index, nil
+}
+
+// jsonEqual reports whether two JSON values are equal, comparing numbers by
+// value rather than by their textual form.
+func jsonEqual(a, b any) bool {
+	return reflect.DeepEqual(normalizeJSON(a), normalizeJSON(b))
+}
+
+// normalizeJSON converts json.Number values into float64 so that values
+// decoded with and without UseNumber compare equal.
+func normalizeJSON(v any) any {
+	switch v := v.(type) {
+	case json.Number:
+		f, _ := v.Float64()
+		return f
+	case map[string]any:
+		out := make(map[string]any, len(v))
+		for k, child := range v {
+			out[k] = normalizeJSON(child)
+		}
+		return out
+	case []any:
+		out := make([]any, len(v))
+		for i, child := range v {
+			out[i] = normalizeJSON(child)
+		}
+		return out
+	}
+	return v
+}
@@ -0,0 +1,88 @@
+package booksapi
+
+import (
+	"net/http"
+	"slices"
+	"sort"
+
+	"golang.org/x/text/language"
+)
+
+// preferAcceptLanguage enables ordering unfiltered book listings so books in
+// the languages named by the request's Accept-Language header come first.
+var preferAcceptLanguage bool
+
+// normalizeLanguage validates a BCP 47 language tag and returns its
+// canonical form. An empty tag is left empty.
+func normalizeLanguage(tag string) (string, error) {
+	if tag == "" {
+		return "", nil
+	}
+	parsed, err := language.Parse(tag)
+	if err != nil {
+		return "", newAPIError(http.StatusUnprocessableEntity, "invalid_language", "tag", tag)
+	}
+	return parsed.String(), nil
+}
+
+// languageMatches reports whether a book's language satisfies a filter tag.
+// A filter without a region matches every region of its base language, so
+// "pt" matches "pt-BR", while "pt-BR" only matches "pt-BR".
+func languageMatches(bookTag string, filter language.Tag) bool {
+	if bookTag == "" {
+		return false
+	}
+	tag, err := language.Parse(bookTag)
+	if err != nil {
+		return false
+	}
+	bookBase, _ := tag.Base()
+	filterBase, _ := filter.Base()
+	if bookBase != filterBase {
+		return false
+	}
+	if filterRegion, conf := filter.Region(); conf == language.Exact {
+		bookRegion, bookConf := tag.Region()
+		return bookConf == language.Exact && bookRegion == filterRegion
+	}
+	return true
+}
+
+// filterByLanguage keeps only the books whose language matches any of the
+// filters.
+func filterByLanguage(list []Book, filters []language.Tag) []Book {
+	filtered := list[:0]
+	for _, book := range list {
+		if slices.ContainsFunc(filters, func(filter language.Tag) bool { return languageMatches(book.Language, filter) }) {
+			filtered = append(filtered, book)
+		}
+	}
+	return filtered
+}
+
+// orderByAcceptLanguage stably moves books whose language matches the
+// request's Accept-Language preferences ahead of the rest, in order of
+// preference.
+func orderByAcceptLanguage(r *http.Request, list []Book) {
+	preferred, _, err := language.ParseAcceptLanguage(r.Header.Get("Accept-Language"))
+	if err != nil || len(preferred) == 0 {
+		return
+	}
+
+	// Preferences match on the base language so "pt-BR" also favors "pt-PT".
+	bases := make([]language.Tag, len(preferred))
+	for i, tag := range preferred {
+		base, _ := tag.Base()
+		bases[i] = language.Make(base.String())
+	}
+
+	rank := func(book Book) int {
+		for i, tag := range bases {
+			if languageMatches(book.Language, tag) {
+				return i
+			}
+		}
+		return len(bases)
+	}
+	sort.SliceStable(list, func(i, j int) bool { return rank(list[i]) < rank(list[j]) })
+}
@@ -0,0 +1,135 @@
+package booksapi
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// shutdownTimeout bounds how long the components of a lifecycle may take
+// to stop, e.g. for a listener to finish its in-flight requests.
+const shutdownTimeout = 10 * time.Second
+
+// Component is a part of the server with a lifetime of its own, such as a
+// listener or a background worker, run by a lifecycle.
+type Component interface {
+	// Start runs the component until it is shut down or fails, returning
+	// nil after a clean stop. A component with nothing to serve may wait
+	// for ctx, which is done once the lifecycle stops, instead.
+	Start(ctx context.Context) error
+	// Shutdown stops the component, giving its work until ctx is done to
+	// finish.
+	Shutdown(ctx context.Context) error
+}
+
+// lifecycle starts components together and stops them together, in the
+// manner of an errgroup: the first component to fail, or the end of the
+// context Run is given, shuts every one of them down.
+type lifecycle struct {
+	components []namedComponent
+}
+
+// namedComponent is a component with the name its start and stop are
+// logged under, such as "api on :8080".
+type namedComponent struct {
+	name string
+	Component
+}
+
+// Add registers c, logged as name. Components are shut down in the reverse
+// of the order they are added in, so a listener added after the backends
+// it serves stops taking requests before they close.
+func (l *lifecycle) Add(name string, c Component) {
+	l.components = append(l.components, namedComponent{name, c})
+}
+
+// Run starts every component and waits until all have stopped, shutting
+// them down once ctx is done or any of them fails. It returns the error of
+// the first component to fail, or else of the first failed shutdown.
+func (l *lifecycle) Run(ctx context.Context) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	var (
+		once     sync.Once
+		firstErr error
+		wg       sync.WaitGroup
+	)
+	fail := func(err error) {
+		once.Do(func() { firstErr = err })
+		cancel()
+	}
+	for _, c := range l.components {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			logger.Printf("starting %s", c.name)
+			if err := c.Start(ctx); err != nil {
+				logger.Printf("%s failed: %v", c.name, err)
+				fail(fmt.Errorf("%s: %w", c.name, err))
+				return
+			}
+			logger.Printf("%s stopped", c.name)
+		}()
+	}
+
+	<-ctx.Done()
+	shutdownCtx, cancelShutdown := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancelShutdown()
+	for i := len(l.components) - 1; i >= 0; i-- {
+		c := l.components[i]
+		if err := c.Shutdown(shutdownCtx); err != nil {
+			logger.Printf("shutting down %s: %v", c.name, err)
+			fail(fmt.Errorf("shutting down %s: %w", c.name, err))
+		}
+	}
+	wg.Wait()
+	return firstErr
+}
+
+// httpListener is a Component serving handler on addr.
+type httpListener struct {
+	server *http.Server
+}
+
+func newHTTPListener(addr string, handler http.Handler) *httpListener {
+	return &httpListener{server: &http.Server{Addr: addr, Handler: handler}}
+}
+
+// Start binds the listener's address and serves on it. Failing to bind,
+// e.g. because the port is in use, is an error like any other.
+func (l *httpListener) Start(ctx context.Context) error {
+	ln, err := net.Listen("tcp", l.server.Addr)
+	if err != nil {
+		return err
+	}
+	logger.Printf("listening on %s", ln.Addr())
+	if err := l.server.Serve(ln); !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+	return nil
+}
+
+// Shutdown stops accepting connections and waits for the requests in
+// flight, as http.Server.Shutdown does. Shutting down before Start makes
+// Start return at once.
+func (l *httpListener) Shutdown(ctx context.Context) error {
+	return l.server.Shutdown(ctx)
+}
+
+// backends is the Component releasing the exporter and the store, e.g.
+// writing the final snapshot, once the listeners have stopped.
+type backends struct{}
+
+func (backends) Start(ctx context.Context) error {
+	<-ctx.Done()
+	return nil
+}
+
+func (backends) Shutdown(context.Context) error {
+	return closeBackends()
+}
@@ -0,0 +1,102 @@
+package booksapi
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"os"
+	"os/exec"
+	"strings"
+	"sync/atomic"
+	"syscall"
+	"testing"
+	"time"
+)
+
+// fakeComponent runs until its context is done, recording its shutdown.
+type fakeComponent struct {
+	stopped  atomic.Bool
+	shutdown atomic.Bool
+}
+
+func (c *fakeComponent) Start(ctx context.Context) error {
+	<-ctx.Done()
+	c.stopped.Store(true)
+	return nil
+}
+
+func (c *fakeComponent) Shutdown(context.Context) error {
+	c.shutdown.Store(true)
+	return nil
+}
+
+// busyAddr returns the address of a listener held until the end of the
+// test, so that binding it again fails.
+func busyAddr(t *testing.T) string {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ln.Close() })
+	return ln.Addr().String()
+}
+
+// TestLifecyclePortInUse checks that a listener failing to bind its port
+// shuts every other component down and fails the run.
+func TestLifecyclePortInUse(t *testing.T) {
+	resetState(t)
+	worker := &fakeComponent{}
+	api := newHTTPListener("127.0.0.1:0", http.NotFoundHandler())
+	var lc lifecycle
+	lc.Add("worker", worker)
+	lc.Add("api", api)
+	lc.Add("debug", newHTTPListener(busyAddr(t), http.NotFoundHandler()))
+
+	done := make(chan error, 1)
+	go func() { done <- lc.Run(context.Background()) }()
+	var err error
+	select {
+	case err = <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Run still running 5s after a listener failed")
+	}
+	if !errors.Is(err, syscall.EADDRINUSE) || !strings.HasPrefix(err.Error(), "debug: ") {
+		t.Errorf("Run returned %v, want the debug listener's address in use", err)
+	}
+	if !worker.shutdown.Load() || !worker.stopped.Load() {
+		t.Error("the worker was not shut down")
+	}
+	if err := api.Start(context.Background()); err != nil {
+		t.Errorf("Start after the run: %v, want the listener shut down", err)
+	}
+}
+
+// TestMainPortInUse runs Main with its debug port in use and checks that
+// it shuts down and exits non-zero.
+func TestMainPortInUse(t *testing.T) {
+	if addr := os.Getenv("BOOKS_TEST_MAIN_DEBUG_ADDR"); addr != "" {
+		os.Args = []string{"booksapi", "-debug-addr", addr}
+		Main()
+		os.Exit(0)
+	}
+	if testing.Short() {
+		t.Skip("runs the server in a child process")
+	}
+
+	cmd := exec.Command(os.Args[0], "-test.run=^TestMainPortInUse$")
+	cmd.Dir = t.TempDir()
+	cmd.Env = append(os.Environ(), "BOOKS_TEST_MAIN_DEBUG_ADDR="+busyAddr(t))
+	cmd.WaitDelay = 10 * time.Second
+	out, err := cmd.CombinedOutput()
+	var exitErr *exec.ExitError
+	if !errors.As(err, &exitErr) || exitErr.ExitCode() == 0 {
+		t.Fatalf("Main exited with %v, want a non-zero status; output:\n%s", err, out)
+	}
+	for _, want := range []string{"address already in use", "backends stopped", "reloader stopped"} {
+		if !strings.Contains(string(out), want) {
+			t.Errorf("output lacks %q:\n%s", want, out)
+		}
+	}
+}
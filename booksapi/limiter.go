@@ -0,0 +1,155 @@
+package booksapi
+
+import (
+	"context"
+	"expvar"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Concurrency limiter settings, configured by flags in Main. A maximum of
+// zero disables the limiter.
+var (
+	maxConcurrent   int
+	concurrencyWait time.Duration
+)
+
+// busyRetryAfter is the Retry-After value, in seconds, sent with 503
+// responses when the server is at capacity.
+const busyRetryAfter = "1"
+
+// Concurrency figures published under /debug/vars.
+var (
+	requestsInFlight = expvar.NewInt("requests_in_flight")
+	requestsRejected = expvar.NewInt("requests_rejected")
+)
+
+func init() {
+	expvar.Publish("requests_max_concurrent", expvar.Func(func() any { return maxConcurrent }))
+}
+
+// limitConcurrency caps the number of requests handled at once. A request
+// arriving at capacity waits up to concurrencyWait for a slot and is then
+// turned away with 503 and Retry-After. Probes and metrics are exempt, so
+// they keep answering while the server is saturated. A slot is held until
+// the handler returns, even one still running past the 504 of a timeout.
+func limitConcurrency(next http.Handler) http.Handler {
+	if maxConcurrent <= 0 {
+		return next
+	}
+	slots := make(chan struct{}, maxConcurrent)
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if isExemptFromLimits(r.URL.Path) {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		select {
+		case slots <- struct{}{}:
+		default:
+			if !waitForSlot(r, slots) {
+				requestsRejected.Add(1)
+				w.Header().Set("Retry-After", busyRetryAfter)
+				writeError(w, r, http.StatusServiceUnavailable, "server_busy")
+				return
+			}
+		}
+		requestsInFlight.Add(1)
+		r, lease := leaseSlots(r)
+		defer lease.release(func() {
+			requestsInFlight.Add(-1)
+			<-slots
+		})
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// waitForSlot waits up to concurrencyWait for a free slot and reports
+// whether it got one.
+func waitForSlot(r *http.Request, slots chan struct{}) bool {
+	if concurrencyWait <= 0 {
+		return false
+	}
+	timer := time.NewTimer(concurrencyWait)
+	defer timer.Stop()
+
+	select {
+	case slots <- struct{}{}:
+		return true
+	case <-timer.C:
+		return false
+	case <-r.Context().Done():
+		return false
+	}
+}
+
+// isExemptFromLimits reports whether path is a health or readiness probe or
+// the metrics endpoint.
+func isExemptFromLimits(path string) bool {
+	return path == "/healthz" || path == "/readyz" || strings.HasPrefix(path, "/debug/")
+}
+
+// slotLeaseKey is the context key of the slotLease of a request.
+type slotLeaseKey struct{}
+
+// slotLease ties the release of the concurrency slots a request holds to
+// the return of its handler. serveWithTimeout answers a request at its
+// timeout while the handler may run on; it detaches the lease then, and
+// the slots are released once the handler returns rather than when the
+// response is sent.
+type slotLease struct {
+	mu       sync.Mutex
+	detached bool     // the response was sent with the handler still running
+	returned bool     // the detached handler has returned since
+	pending  []func() // releases waiting for the handler to return
+}
+
+// leaseSlots returns r with a slotLease, the one it has if any, and the
+// lease.
+func leaseSlots(r *http.Request) (*http.Request, *slotLease) {
+	if lease, ok := r.Context().Value(slotLeaseKey{}).(*slotLease); ok {
+		return r, lease
+	}
+	lease := new(slotLease)
+	return r.WithContext(context.WithValue(r.Context(), slotLeaseKey{}, lease)), lease
+}
+
+// release calls f, which releases a slot, now, or once the handler
+// returns if the lease is detached from it.
+func (l *slotLease) release(f func()) {
+	l.mu.Lock()
+	if l.detached && !l.returned {
+		l.pending = append(l.pending, f)
+		l.mu.Unlock()
+		return
+	}
+	l.mu.Unlock()
+	f()
+}
+
+// detachSlots defers the releases of the lease of r, if it has one, until
+// returned is closed by the handler returning.
+func detachSlots(r *http.Request, returned <-chan struct{}) {
+	l, ok := r.Context().Value(slotLeaseKey{}).(*slotLease)
+	if !ok {
+		return
+	}
+	l.mu.Lock()
+	l.detached = true
+	l.mu.Unlock()
+	go func() {
+		<-returned
+		l.mu.Lock()
+		l.returned = true
+		pending := l.pending
+		l.pending = nil
+		l.mu.Unlock()
+		for _, f := range pending {
+			f()
+		}
+	}()
+}
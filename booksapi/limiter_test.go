@@ -0,0 +1,119 @@
+package booksapi
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// blockingHandler holds every request but probes until release is closed,
+// announcing each on started.
+func blockingHandler(started chan<- struct{}, release <-chan struct{}) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !isExemptFromLimits(r.URL.Path) {
+			started <- struct{}{}
+			<-release
+		}
+		w.WriteHeader(http.StatusNoContent)
+	})
+}
+
+// serveInBackground serves a GET of path with h in a goroutine and sends
+// the status to the channel it returns.
+func serveInBackground(ctx context.Context, h http.Handler, path string) <-chan int {
+	done := make(chan int, 1)
+	go func() {
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, path, nil).WithContext(ctx))
+		done <- rec.Code
+	}()
+	return done
+}
+
+// TestConcurrencyLimit saturates the server and checks that requests
+// beyond -max-concurrent get 503 with Retry-After, that probes still
+// answer, and that the figures published count them.
+func TestConcurrencyLimit(t *testing.T) {
+	resetState(t)
+	setForTest(t, &maxConcurrent, 2)
+	started, release := make(chan struct{}), make(chan struct{})
+	h := limitConcurrency(blockingHandler(started, release))
+
+	inFlight, rejected := requestsInFlight.Value(), requestsRejected.Value()
+	var running []<-chan int
+	for range 2 {
+		running = append(running, serveInBackground(context.Background(), h, "/books"))
+		<-started
+	}
+	if got := requestsInFlight.Value() - inFlight; got != 2 {
+		t.Errorf("%d requests in flight, want 2", got)
+	}
+
+	rec := serve(t, h, http.MethodGet, "/books", nil)
+	wantCode(t, rec, http.StatusServiceUnavailable)
+	if got := errorCode(t, rec); got != "server_busy" || rec.Header().Get("Retry-After") != busyRetryAfter {
+		t.Errorf("error code %q with Retry-After %q, want server_busy with %s", got, rec.Header().Get("Retry-After"), busyRetryAfter)
+	}
+	if got := requestsRejected.Value() - rejected; got != 1 {
+		t.Errorf("%d requests rejected, want 1", got)
+	}
+	for _, path := range []string{"/healthz", "/readyz", "/debug/vars"} {
+		wantCode(t, serve(t, h, http.MethodGet, path, nil), http.StatusNoContent)
+	}
+
+	close(release)
+	for _, done := range running {
+		if code := <-done; code != http.StatusNoContent {
+			t.Errorf("saturating request: status %d", code)
+		}
+	}
+	if got := requestsInFlight.Value() - inFlight; got != 0 {
+		t.Errorf("%d requests in flight once they finished, want 0", got)
+	}
+	go func() { <-started }()
+	wantCode(t, serve(t, h, http.MethodGet, "/books", nil), http.StatusNoContent)
+}
+
+// TestConcurrencyWait checks that a request arriving at capacity gets the
+// slot freed within -concurrency-wait, and 503 if its client gives up
+// first.
+func TestConcurrencyWait(t *testing.T) {
+	resetState(t)
+	setForTest(t, &maxConcurrent, 1)
+	setForTest(t, &concurrencyWait, 5*time.Second)
+	started, release := make(chan struct{}), make(chan struct{}, 2)
+	h := limitConcurrency(blockingHandler(started, release))
+
+	first := serveInBackground(context.Background(), h, "/books")
+	<-started
+
+	ctx, cancel := context.WithCancel(context.Background())
+	canceled := serveInBackground(ctx, h, "/books")
+	waiting := serveInBackground(context.Background(), h, "/books")
+	time.Sleep(10 * time.Millisecond)
+	cancel()
+	if code := <-canceled; code != http.StatusServiceUnavailable {
+		t.Errorf("request canceled while waiting: status %d, want 503", code)
+	}
+
+	release <- struct{}{}
+	<-started
+	release <- struct{}{}
+	for _, done := range []<-chan int{first, waiting} {
+		if code := <-done; code != http.StatusNoContent {
+			t.Errorf("status %d, want 204", code)
+		}
+	}
+}
+
+// TestConcurrencyUnlimited checks that the limiter is left out without a
+// maximum.
+func TestConcurrencyUnlimited(t *testing.T) {
+	resetState(t)
+	next := http.NotFoundHandler()
+	if h := limitConcurrency(next); h == nil || serve(t, h, http.MethodGet, "/books", nil).Code != http.StatusNotFound {
+		t.Error("limitConcurrency changed the handler without a maximum")
+	}
+}
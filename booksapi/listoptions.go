@@ -0,0 +1,264 @@
+package booksapi
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+	"time"
+
+	"golang.org/x/text/language"
+)
+
+// ListOptions selects, orders, and pages the books returned by
+// BookStore.List. The zero value lists every book ordered by ID.
+type ListOptions struct {
+	Limit  int // zero means no limit
+	Offset int
+	After  *bookCursor // if set, only books after it are listed; Offset should then be zero
+
+	Sort       []string // "id", "title", "author", or "price", "-" prefixed for descending, in order of precedence; ties are broken by ID
+	Descending bool     // reverses the order
+
+	// The filters of a field keep the books matching any of them; every
+	// field with filters must match.
+	Authors   []string       // author filters, matched according to Match
+	Titles    []string       // title filters, matched according to Match
+	Match     string         // matchExact, matchPrefix, or matchContains; empty means matchExact
+	Languages []language.Tag // language filters; none matches every book
+	MinPrice  *float64
+	MaxPrice  *float64
+	Formats   []string // edition formats; keeps the books with an edition in any of them
+
+	Attributes map[string][]string // custom attribute filters by name, matched according to Match
+
+	StartsWith string // index group filter, as indexGroup returns; empty keeps every book
+	By         string // "title" or "author", the field StartsWith applies to; empty means "title"
+
+	IncludeArchived bool       // lists archived books too; stores ignore it, listBooks adds them
+	AsOf            *time.Time // lists the catalog as it was then; stores ignore it, listBooks reconstructs it
+}
+
+// sortFields are the fields ?sort= orders by, and sortKeys the values it
+// accepts: each field, ascending, or prefixed with "-", descending.
+// maxSortKeys is the number of keys one listing may sort by.
+var (
+	sortFields = []string{"id", "title", "author", "price"}
+	sortKeys   = slices.Concat(sortFields, prefixed("-", sortFields))
+)
+
+const maxSortKeys = 3
+
+// prefixed returns values with prefix added to each.
+func prefixed(prefix string, values []string) []string {
+	out := make([]string, len(values))
+	for i, value := range values {
+		out[i] = prefix + value
+	}
+	return out
+}
+
+// booksParams declares the query parameters of GET /books.
+var booksParams = slices.Concat(bookListParams, []queryParam{
+	{name: "lang", kind: stringParam, repeatable: true},
+	{name: "author", kind: stringParam, repeatable: true},
+	{name: "title", kind: stringParam, repeatable: true},
+	{name: "match", kind: enumParam, values: matchModes},
+	{name: "min_price", kind: floatParam},
+	{name: "max_price", kind: floatParam},
+	{name: "format", kind: enumParam, values: editionFormats},
+	{name: "sort", kind: enumParam, values: sortKeys},
+	{name: "order", kind: enumParam, values: []string{"asc", "desc"}},
+	{name: "cursor", kind: stringParam},
+	{name: "snapshot", kind: stringParam}, // "true", or the token of a listing snapshot
+	{name: "include_archived", kind: boolParam},
+	{name: "as_of", kind: stringParam},
+	{name: "starts_with", kind: stringParam},
+	{name: "by", kind: enumParam, values: indexFields},
+})
+
+// ParseListOptions validates the query parameters of GET /books and returns
+// the ListOptions they describe. The limit follows the global paging policy: without
+// one the default page size is used, and one above the maximum page size
+// is reduced to it with a warning. A cursor may not be combined with an offset, nor used
+// with another order than the one it was created for.
+//
+// The filter parameters author, title, lang, and attr.{name} may be
+// repeated: the values of one parameter are alternatives, so
+// ?author=King&author=Rowling lists the books by either, while different
+// parameters must all match, so adding &lang=en keeps only those in
+// English. Empty values are ignored. Every other parameter, such as limit
+// or sort, may be given once; repeating it fails with 400.
+//
+// The sort parameter lists up to maxSortKeys fields in order of
+// precedence, each descending if prefixed with "-":
+// ?sort=author,-price,title orders by author, then by price from the
+// highest, then by title. Ties left by every field are broken by ID, and
+// ?order=desc reverses the whole order.
+func ParseListOptions(r *http.Request) (ListOptions, error) {
+	return parseListOptions(r, r.URL.Query())
+}
+
+// parseListOptions is ParseListOptions for the GET /books query parameters
+// in raw, which saved filters evaluate through.
+func parseListOptions(r *http.Request, raw url.Values) (ListOptions, error) {
+	q, err := parseValues(raw, booksParams...)
+	if err != nil {
+		return ListOptions{}, err
+	}
+
+	opts := ListOptions{
+		Limit:   PagingPolicy{}.limit(r, q),
+		Offset:  q.Int("offset"),
+		Sort:    q.List("sort"),
+		Authors: q.Strings("author"),
+		Titles:  q.Strings("title"),
+		Formats: q.List("format"),
+		Match:   matchExact,
+
+		Attributes:      attributeFilters(q),
+		IncludeArchived: q.Bool("include_archived"),
+	}
+	if err := checkSortKeys(opts.Sort); err != nil {
+		return ListOptions{}, err
+	}
+	mode, err := singleValue(q, booksParams, "match")
+	if err != nil {
+		return ListOptions{}, err
+	}
+	if mode != "" {
+		opts.Match = mode
+	}
+	order, err := singleValue(q, booksParams, "order")
+	if err != nil {
+		return ListOptions{}, err
+	}
+	opts.Descending = order == "desc"
+	for _, value := range q.Strings("lang") {
+		if value == "" {
+			continue
+		}
+		tag, err := language.Parse(value)
+		if err != nil {
+			return ListOptions{}, newAPIError(http.StatusBadRequest, "invalid_query_parameter", "name", "lang", "expected", "language tag")
+		}
+		opts.Languages = append(opts.Languages, tag)
+	}
+	if q.Has("min_price") {
+		price := q.Float("min_price")
+		opts.MinPrice = &price
+	}
+	if q.Has("max_price") {
+		price := q.Float("max_price")
+		opts.MaxPrice = &price
+	}
+	if opts.MinPrice != nil && opts.MaxPrice != nil && *opts.MaxPrice < *opts.MinPrice {
+		return ListOptions{}, newAPIError(http.StatusBadRequest, "invalid_query_parameter", "name", "max_price", "expected", "number not below min_price")
+	}
+	if q.Has("as_of") {
+		t, err := parseAsOf(q.String("as_of"))
+		if err != nil {
+			return ListOptions{}, err
+		}
+		opts.AsOf = &t
+	}
+	if q.Has("starts_with") {
+		if opts.StartsWith, err = parseIndexGroup(q.String("starts_with")); err != nil {
+			return ListOptions{}, err
+		}
+		if opts.By, err = singleValue(q, booksParams, "by"); err != nil {
+			return ListOptions{}, err
+		}
+	}
+	if q.Has("cursor") {
+		if q.Has("offset") {
+			return ListOptions{}, newAPIError(http.StatusBadRequest, "conflicting_query_parameters", "first", "cursor", "second", "offset")
+		}
+		if opts.After, err = decodeBookCursor(q.String("cursor"), opts); err != nil {
+			return ListOptions{}, err
+		}
+	}
+	return opts, nil
+}
+
+// singleValue returns the value of an enumParam, declared among params,
+// that may only be given one value, or "" if it is absent.
+func singleValue(q queryValues, params []queryParam, name string) (string, error) {
+	values := q.List(name)
+	switch len(values) {
+	case 0:
+		return "", nil
+	case 1:
+		return values[0], nil
+	}
+	var allowed []string
+	for _, p := range params {
+		if p.name == name {
+			allowed = p.values
+		}
+	}
+	return "", newAPIError(http.StatusBadRequest, "invalid_query_parameter", "name", name, "expected", "one of "+strings.Join(allowed, ", "))
+}
+
+// checkSortKeys rejects a sort with more than maxSortKeys keys, or with a
+// field given twice, such as ?sort=title,-title.
+func checkSortKeys(keys []string) error {
+	seen := make(map[string]bool)
+	for _, key := range keys {
+		field, _ := sortField(key)
+		if seen[field] || len(keys) > maxSortKeys {
+			return newAPIError(http.StatusBadRequest, "invalid_query_parameter", "name", "sort", "expected", fmt.Sprintf("at most %d distinct fields of %s", maxSortKeys, strings.Join(sortFields, ", ")))
+		}
+		seen[field] = true
+	}
+	return nil
+}
+
+// Apply filters, orders, and pages list, which must be ordered by ID, as
+// the options ask, reusing list. Stores given to New that carry the options
+// out in memory, as the package's own do, can implement List with it.
+func (o ListOptions) Apply(list []Book) []Book {
+	return o.apply(list)
+}
+
+// apply filters, orders, and pages list, which must be ordered by ID, as
+// the options ask. It is used by the in-memory stores and reuses list.
+func (o ListOptions) apply(list []Book) []Book {
+	if len(o.Languages) > 0 {
+		list = filterByLanguage(list, o.Languages)
+	}
+	list = filterByText(list, o.Match, o.Authors, o.Titles)
+	if len(o.Attributes) > 0 {
+		list = filterByAttributes(list, o.Match, o.Attributes)
+	}
+	if o.StartsWith != "" {
+		list = filterByIndexGroup(list, o.By, o.StartsWith)
+	}
+	if len(o.Formats) > 0 {
+		filtered := list[:0]
+		for _, book := range list {
+			if hasEditionFormat(book, o.Formats) {
+				filtered = append(filtered, book)
+			}
+		}
+		list = filtered
+	}
+	if o.MinPrice != nil || o.MaxPrice != nil {
+		filtered := list[:0]
+		for _, book := range list {
+			if (o.MinPrice == nil || float64(book.Price) >= *o.MinPrice) && (o.MaxPrice == nil || float64(book.Price) <= *o.MaxPrice) {
+				filtered = append(filtered, book)
+			}
+		}
+		list = filtered
+	}
+	sortBooks(list, o.Sort)
+	if o.Descending {
+		slices.Reverse(list)
+	}
+	if o.After != nil {
+		list = o.After.seek(list)
+	}
+	return paginate(list, o.Limit, o.Offset)
+}
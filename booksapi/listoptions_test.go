@@ -0,0 +1,225 @@
+package booksapi
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"golang.org/x/text/language"
+)
+
+func TestParseListOptions(t *testing.T) {
+	resetState(t)
+	setForTest(t, &defaultPaging, PagingPolicy{Default: 20, Max: 100})
+	setForTest(t, &customAttributes, customAttributes)
+	setForTest(t, &booksParams, booksParams)
+	setForTest(t, &strictQuery, true)
+	if err := configureAttributes("shelf"); err != nil {
+		t.Fatal(err)
+	}
+	liveConfig.Store(flagConfig())
+
+	price := func(p float64) *float64 { return &p }
+	asOf := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	after := newBookCursor(Book{ID: 3, Title: "Emma"}, ListOptions{Sort: []string{"title"}})
+
+	tests := []struct {
+		query string
+		want  ListOptions
+	}{
+		{"", ListOptions{Limit: 20, Match: matchExact}},
+		{"limit=5&offset=10", ListOptions{Limit: 5, Offset: 10, Match: matchExact}},
+		{"limit=0", ListOptions{Match: matchExact}},
+		{"limit=500", ListOptions{Limit: 100, Match: matchExact}},
+		{"sort=author,-price&order=desc", ListOptions{Limit: 20, Sort: []string{"author", "-price"}, Descending: true, Match: matchExact}},
+		{"order=asc", ListOptions{Limit: 20, Match: matchExact}},
+		{"author=King&author=Rowling", ListOptions{Limit: 20, Authors: []string{"King", "Rowling"}, Match: matchExact}},
+		{"title=dune&match=prefix", ListOptions{Limit: 20, Titles: []string{"dune"}, Match: matchPrefix}},
+		{"lang=en&lang=pt-BR", ListOptions{Limit: 20, Languages: []language.Tag{language.English, language.BrazilianPortuguese}, Match: matchExact}},
+		{"lang=", ListOptions{Limit: 20, Match: matchExact}},
+		{"min_price=5&max_price=10.5", ListOptions{Limit: 20, MinPrice: price(5), MaxPrice: price(10.5), Match: matchExact}},
+		{"min_price=5&max_price=5", ListOptions{Limit: 20, MinPrice: price(5), MaxPrice: price(5), Match: matchExact}},
+		{"format=ebook,paperback", ListOptions{Limit: 20, Formats: []string{"ebook", "paperback"}, Match: matchExact}},
+		{"attr.shelf=A3&match=contains", ListOptions{Limit: 20, Attributes: map[string][]string{"shelf": {"A3"}}, Match: matchContains}},
+		{"starts_with=é&by=author", ListOptions{Limit: 20, StartsWith: "E", By: "author", Match: matchExact}},
+		{"include_archived=true", ListOptions{Limit: 20, IncludeArchived: true, Match: matchExact}},
+		{"as_of=2024-01-02T03:04:05Z", ListOptions{Limit: 20, AsOf: &asOf, Match: matchExact}},
+		{"sort=title&cursor=" + after.encode(), ListOptions{Limit: 20, Sort: []string{"title"}, After: after, Match: matchExact}},
+	}
+	for _, tt := range tests {
+		got, err := ParseListOptions(httptest.NewRequest(http.MethodGet, "/books?"+tt.query, nil))
+		if err != nil {
+			t.Errorf("?%s: %v", tt.query, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("?%s: got %+v, want %+v", tt.query, got, tt.want)
+		}
+	}
+
+	errorTests := []struct {
+		query string
+		code  string
+	}{
+		{"limit=many", "invalid_query_parameter"},
+		{"limit=1&limit=2", "repeated_query_parameter"},
+		{"offset=-1", "invalid_query_parameter"},
+		{"sort=isbn", "invalid_query_parameter"},
+		{"sort=title,-title", "invalid_query_parameter"},
+		{"sort=id,title,author,price", "invalid_query_parameter"},
+		{"order=up", "invalid_query_parameter"},
+		{"match=fuzzy", "invalid_query_parameter"},
+		{"lang=!!", "invalid_query_parameter"},
+		{"min_price=cheap", "invalid_query_parameter"},
+		{"min_price=10&max_price=5", "invalid_query_parameter"},
+		{"format=scroll", "invalid_query_parameter"},
+		{"starts_with=ab", "invalid_query_parameter"},
+		{"starts_with=a&by=isbn", "invalid_query_parameter"},
+		{"as_of=yesterday", "invalid_query_parameter"},
+		{"include_archived=maybe", "invalid_query_parameter"},
+		{"cursor=%21%21", "invalid_cursor"},
+		{"sort=price&cursor=" + after.encode(), "cursor_mismatch"},
+		{"sort=title&cursor=" + after.encode() + "&offset=1", "conflicting_query_parameters"},
+		{"attr.colour=red", "unknown_query_parameter"},
+		{"genre=horror", "unknown_query_parameter"},
+	}
+	for _, tt := range errorTests {
+		_, err := ParseListOptions(httptest.NewRequest(http.MethodGet, "/books?"+tt.query, nil))
+		var apiErr *apiError
+		if !errors.As(err, &apiErr) || apiErr.Code != tt.code {
+			t.Errorf("?%s: got %v, want %s", tt.query, err, tt.code)
+		}
+	}
+}
+
+// TestRepeatedFilters checks through GET /books that the values of a
+// repeated filter parameter are alternatives, that different parameters
+// must all match, and that repeating any other parameter is refused.
+func TestRepeatedFilters(t *testing.T) {
+	h := newTestServer(t)
+	setForTest(t, &customAttributes, customAttributes)
+	setForTest(t, &booksParams, slices.Clone(booksParams))
+	if err := configureAttributes("shelf"); err != nil {
+		t.Fatal(err)
+	}
+	for _, b := range []map[string]any{
+		{"title": "Carrie", "author": "Stephen King", "language": "en", "attributes": map[string]string{"shelf": "A"}},
+		{"title": "It", "author": "Stephen King", "language": "de", "attributes": map[string]string{"shelf": "B"}},
+		{"title": "Harry Potter", "author": "J. K. Rowling", "language": "en", "attributes": map[string]string{"shelf": "B"}},
+		{"title": "Emma", "author": "Jane Austen", "language": "en", "attributes": map[string]string{"shelf": "A"}},
+	} {
+		mustCreateBook(t, h, b)
+	}
+
+	for query, want := range map[string][]int{
+		"author=Stephen+King":                                      {1, 2},
+		"author=Stephen+King&author=J.+K.+Rowling":                 {1, 2, 3},
+		"author=Stephen+King&author=J.+K.+Rowling&lang=en":         {1, 3},
+		"author=Stephen+King&author=J.+K.+Rowling&lang=en&lang=de": {1, 2, 3},
+		"author=Stephen+King&author=&lang=de":                      {2},
+		"title=c&title=h&match=prefix":                             {1, 3},
+		"attr.shelf=A&attr.shelf=B&author=Jane+Austen":             {4},
+		"author=Jane+Austen&title=It":                              nil,
+	} {
+		invalidateResponseCache()
+		if got := filterBookIDs(t, h, "/books?"+query); !slices.Equal(got, want) {
+			t.Errorf("?%s: books %v, want %v", query, got, want)
+		}
+	}
+
+	for _, query := range []string{"limit=1&limit=2", "offset=0&offset=1", "sort=title&sort=author", "match=prefix&match=exact", "order=asc&order=desc", "min_price=1&min_price=2"} {
+		rec := serve(t, h, http.MethodGet, "/books?"+query, nil)
+		wantCode(t, rec, http.StatusBadRequest)
+		if got := decode[errorBody](t, rec).Error; got.Code != "repeated_query_parameter" || !strings.HasPrefix(query, got.Params["name"]+"=") {
+			t.Errorf("?%s: error %s %v, want repeated_query_parameter naming it", query, got.Code, got.Params)
+		}
+	}
+
+	// A saved filter with several authors selects as the listing does.
+	rec := serve(t, h, http.MethodPost, "/filters", map[string]any{
+		"name":   "King or Rowling in English",
+		"filter": map[string]any{"author": []string{"Stephen King", "J. K. Rowling"}, "lang": "en"},
+	})
+	wantCode(t, rec, http.StatusCreated)
+	if got := filterBookIDs(t, h, "/filters/1/books"); !slices.Equal(got, []int{1, 3}) {
+		t.Errorf("saved filter selects %v, want [1 3]", got)
+	}
+}
+
+// TestCompositeSort lists a catalog whose earlier sort keys tie under
+// several sorts, and pins the order of each, through offsets and cursors
+// alike; an unknown, repeated, or fourth field fails anywhere in the list.
+func TestCompositeSort(t *testing.T) {
+	h := newTestServer(t)
+	for _, book := range []map[string]any{
+		{"title": "Persuasion", "author": "Jane Austen", "price": 8},          // 1
+		{"title": "Dune", "author": "Frank Herbert", "price": 10},             // 2
+		{"title": "Emma", "author": "Jane Austen", "price": 8},                // 3
+		{"title": "Children of Dune", "author": "Frank Herbert", "price": 10}, // 4
+		{"title": "Emma", "author": "jane austen", "price": 12},               // 5
+		{"title": "Dune Messiah", "author": "Frank Herbert", "price": 7},      // 6
+		{"title": "Emma", "author": "Jane Austen", "price": 8},                // 7
+	} {
+		mustCreateBook(t, h, book)
+	}
+	ids := func(books []Book) []int {
+		var ids []int
+		for _, book := range books {
+			ids = append(ids, book.ID)
+		}
+		return ids
+	}
+
+	tests := []struct {
+		sort string
+		want []int
+	}{
+		{"author,-price,title", []int{4, 2, 6, 5, 3, 7, 1}},
+		{"-price,title", []int{5, 4, 2, 3, 7, 1, 6}},
+		{"price,-author,-id", []int{6, 7, 3, 1, 4, 2, 5}},
+		{"title,author", []int{4, 2, 6, 3, 5, 7, 1}},
+		{"-title", []int{1, 3, 5, 7, 6, 2, 4}},
+	}
+	for _, tt := range tests {
+		rec := serve(t, h, http.MethodGet, "/books?sort="+tt.sort, nil)
+		wantCode(t, rec, http.StatusOK)
+		if got := ids(decode[[]Book](t, rec)); !slices.Equal(got, tt.want) {
+			t.Errorf("sort=%s: %v, want %v", tt.sort, got, tt.want)
+		}
+
+		rec = serve(t, h, http.MethodGet, "/books?order=desc&sort="+tt.sort, nil)
+		reversed := slices.Clone(tt.want)
+		slices.Reverse(reversed)
+		if got := ids(decode[[]Book](t, rec)); !slices.Equal(got, reversed) {
+			t.Errorf("sort=%s&order=desc: %v, want %v", tt.sort, got, reversed)
+		}
+
+		var paged []int
+		query := url.Values{"sort": {tt.sort}, "limit": {"2"}}
+		books, cursor := nextCursorPage(t, h, query, "")
+		for {
+			paged = append(paged, ids(books)...)
+			if cursor == "" {
+				break
+			}
+			books, cursor = nextCursorPage(t, h, query, cursor)
+		}
+		if !slices.Equal(paged, tt.want) {
+			t.Errorf("sort=%s by cursor: %v, want %v", tt.sort, paged, tt.want)
+		}
+	}
+
+	for _, sort := range []string{"color", "color,title", "author,-color", "title,author,-isbn", "title,-title", "author,price,title,id", "-"} {
+		rec := serve(t, h, http.MethodGet, "/books?sort="+url.QueryEscape(sort), nil)
+		wantCode(t, rec, http.StatusBadRequest)
+		if got := decode[errorBody](t, rec).Error; got.Code != "invalid_query_parameter" || got.Params["name"] != "sort" {
+			t.Errorf("sort=%s: error %s %v", sort, got.Code, got.Params)
+		}
+	}
+}
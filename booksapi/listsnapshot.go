@@ -0,0 +1,78 @@
+package booksapi
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"net/http"
+	"slices"
+	"sync"
+	"time"
+)
+
+// Listing snapshot settings, configured by flags in Main.
+var (
+	listSnapshotTTL  = 10 * time.Minute
+	maxListSnapshots = 16
+)
+
+// snapshotTokenHeader carries the token of the listing snapshot a page of
+// GET /books was read from.
+const snapshotTokenHeader = "Snapshot-Token"
+
+// listSnapshot is a frozen copy of the catalog that the pages of a long
+// listing are read from, so they all see it as it was when the first page
+// was read.
+type listSnapshot struct {
+	books   []Book // ordered by ID
+	expires time.Time
+}
+
+// Global variables to track listing snapshots, which are dropped once they
+// expire.
+var (
+	listSnapshots   = make(map[string]*listSnapshot) // token -> snapshot
+	listSnapshotsMu sync.Mutex
+)
+
+// startListSnapshot copies every book in store into a new snapshot and
+// returns its token. At most maxListSnapshots may be open at once.
+func startListSnapshot() (string, error) {
+	listSnapshotsMu.Lock()
+	defer listSnapshotsMu.Unlock()
+
+	now := time.Now()
+	for token, snap := range listSnapshots {
+		if !now.Before(snap.expires) {
+			delete(listSnapshots, token)
+		}
+	}
+	if len(listSnapshots) >= maxListSnapshots {
+		return "", newAPIError(http.StatusTooManyRequests, "too_many_snapshots", "max", maxListSnapshots)
+	}
+
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	token := hex.EncodeToString(b)
+	listSnapshots[token] = &listSnapshot{books: store.List(ListOptions{}), expires: now.Add(listSnapshotTTL)}
+	return token, nil
+}
+
+// listFromSnapshot returns the books of the snapshot with the given token
+// selected by opts. Unknown and expired snapshots get 410.
+func listFromSnapshot(token string, opts ListOptions) ([]Book, error) {
+	listSnapshotsMu.Lock()
+	snap, found := listSnapshots[token]
+	if found && !time.Now().Before(snap.expires) {
+		delete(listSnapshots, token)
+		found = false
+	}
+	listSnapshotsMu.Unlock()
+	if !found {
+		return nil, newAPIError(http.StatusGone, "snapshot_expired")
+	}
+
+	// apply reorders the list it is given, so the snapshot itself is copied.
+	return opts.apply(slices.Clone(snap.books)), nil
+}
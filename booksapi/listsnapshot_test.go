@@ -0,0 +1,97 @@
+package booksapi
+
+import (
+	"fmt"
+	"net/http"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// TestListSnapshot starts a snapshot, changes the catalog heavily, and
+// checks that the pages read from the snapshot show the catalog exactly as
+// it was when it started.
+func TestListSnapshot(t *testing.T) {
+	h := newTestServer(t)
+	for i := range 10 {
+		mustCreateBook(t, h, map[string]any{"title": fmt.Sprint("Book ", i), "price": i})
+	}
+	frozen := decode[[]Book](t, serve(t, h, http.MethodGet, "/books?sort=-price", nil))
+
+	rec := serve(t, h, http.MethodGet, "/books?snapshot=true&sort=-price&limit=4", nil)
+	wantCode(t, rec, http.StatusOK)
+	token := rec.Header().Get(snapshotTokenHeader)
+	if token == "" {
+		t.Fatal("no snapshot token")
+	}
+	pages := decode[[]Book](t, rec)
+	links := pageLinks(t, rec.Header().Get("Link"))
+	if next := links["next"]; next == nil || next.Query().Get("snapshot") != token {
+		t.Errorf("next link %v does not continue in the snapshot", next)
+	}
+
+	for i := 1; i <= 10; i++ {
+		switch i % 3 {
+		case 0:
+			wantCode(t, serve(t, h, http.MethodDelete, fmt.Sprint("/books/", i), nil), http.StatusNoContent)
+		case 1:
+			wantCode(t, serve(t, h, http.MethodPut, fmt.Sprint("/books/", i), map[string]any{"title": "Changed", "price": 100}), http.StatusOK)
+		}
+		mustCreateBook(t, h, map[string]any{"title": "New", "price": 50})
+	}
+
+	for offset := 4; offset < 10; offset += 4 {
+		rec := serve(t, h, http.MethodGet, fmt.Sprintf("/books?snapshot=%s&sort=-price&limit=4&offset=%d", token, offset), nil)
+		wantCode(t, rec, http.StatusOK)
+		if got := rec.Header().Get(snapshotTokenHeader); got != token {
+			t.Errorf("Snapshot-Token %q, want %q", got, token)
+		}
+		pages = append(pages, decode[[]Book](t, rec)...)
+	}
+	if !reflect.DeepEqual(pages, frozen) {
+		t.Errorf("snapshot pages %+v, want the catalog as it was: %+v", pages, frozen)
+	}
+	if now := decode[[]Book](t, serve(t, h, http.MethodGet, "/books?limit=100", nil)); len(now) != 17 {
+		t.Errorf("%d books outside the snapshot, want 17", len(now))
+	}
+}
+
+// TestListSnapshotLimits checks that snapshots expire and that no more
+// than the maximum may be open at once.
+func TestListSnapshotLimits(t *testing.T) {
+	h := newTestServer(t)
+	setForTest(t, &maxListSnapshots, 2)
+	mustCreateBook(t, h, map[string]any{"title": "Dune"})
+
+	var tokens []string
+	for range 2 {
+		rec := serve(t, h, http.MethodGet, "/books?snapshot=true", nil)
+		wantCode(t, rec, http.StatusOK)
+		tokens = append(tokens, rec.Header().Get(snapshotTokenHeader))
+	}
+	rec := serve(t, h, http.MethodGet, "/books?snapshot=true", nil)
+	wantCode(t, rec, http.StatusTooManyRequests)
+	if got := errorCode(t, rec); got != "too_many_snapshots" {
+		t.Errorf("error code %q, want too_many_snapshots", got)
+	}
+
+	// Expiring the first snapshot makes room for another.
+	listSnapshotsMu.Lock()
+	listSnapshots[tokens[0]].expires = time.Now()
+	listSnapshotsMu.Unlock()
+	for _, token := range []string{tokens[0], "unknown"} {
+		rec := serve(t, h, http.MethodGet, "/books?snapshot="+token, nil)
+		wantCode(t, rec, http.StatusGone)
+		if got := errorCode(t, rec); got != "snapshot_expired" {
+			t.Errorf("snapshot %s: error code %q, want snapshot_expired", token, got)
+		}
+	}
+	wantCode(t, serve(t, h, http.MethodGet, "/books?snapshot="+tokens[1], nil), http.StatusOK)
+	wantCode(t, serve(t, h, http.MethodGet, "/books?snapshot=true", nil), http.StatusOK)
+
+	rec = serve(t, h, http.MethodGet, "/books?snapshot="+tokens[1]+"&include_archived=true", nil)
+	wantCode(t, rec, http.StatusBadRequest)
+	if got := errorCode(t, rec); got != "conflicting_query_parameters" {
+		t.Errorf("error code %q, want conflicting_query_parameters", got)
+	}
+}
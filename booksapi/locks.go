@@ -0,0 +1,189 @@
+package booksapi
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Edit lock durations. Locks last defaultLockTTL unless the request asks
+// for another TTL, which may not exceed maxLockTTL.
+const (
+	defaultLockTTL = 5 * time.Minute
+	maxLockTTL     = time.Hour
+)
+
+// lockTokenHeader carries the token of an edit lock on requests changing or
+// releasing a locked book.
+const lockTokenHeader = "Lock-Token"
+
+// bookLock is an advisory edit lock on a book.
+type bookLock struct {
+	Holder    string    `json:"holder"`
+	Token     string    `json:"token,omitempty"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+// Global variables to track edit locks. When both locks are needed, mu must
+// be acquired before locksMu. Expired locks are only dropped when they are
+// next looked at.
+var (
+	bookLocks = make(map[int]bookLock) // book ID -> lock
+	locksMu   sync.Mutex
+)
+
+// bookLockHandler handles a book's edit lock (POST, DELETE).
+func bookLockHandler(w http.ResponseWriter, r *http.Request, id int) {
+	if _, ok := checkQuery(w, r); !ok {
+		return
+	}
+
+	switch r.Method {
+	case http.MethodPost:
+		acquireBookLock(w, r, id)
+	case http.MethodDelete:
+		releaseBookLock(w, r, id)
+	default:
+		writeError(w, r, http.StatusMethodNotAllowed, "method_not_allowed")
+	}
+}
+
+// acquireBookLock locks a book for the holder named in the request body,
+// returning the token that edits and the release must send. Sending the
+// token of the current lock renews it.
+func acquireBookLock(w http.ResponseWriter, r *http.Request, id int) {
+	var req struct {
+		Holder     string `json:"holder"`
+		TTLSeconds int    `json:"ttl_seconds"`
+	}
+	if err := decodeJSON(w, r, &req); err != nil {
+		writeAPIError(w, r, err)
+		return
+	}
+	req.Holder = strings.TrimSpace(req.Holder)
+	if req.Holder == "" {
+		writeError(w, r, http.StatusUnprocessableEntity, "field_required", "field", "holder")
+		return
+	}
+	ttl := defaultLockTTL
+	if req.TTLSeconds != 0 {
+		ttl = time.Duration(req.TTLSeconds) * time.Second
+	}
+	if ttl <= 0 || ttl > maxLockTTL {
+		writeError(w, r, http.StatusUnprocessableEntity, "invalid_lock_ttl", "max", int(maxLockTTL.Seconds()))
+		return
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+
+	if _, err := findBook(id); err != nil {
+		writeAPIError(w, r, err)
+		return
+	}
+
+	locksMu.Lock()
+	defer locksMu.Unlock()
+
+	status := http.StatusCreated
+	current, locked := activeLock(id)
+	if locked {
+		if !lockTokenMatches(r, current) {
+			writeAPIError(w, r, lockedError(current))
+			return
+		}
+		status = http.StatusOK
+	}
+
+	lock := bookLock{Holder: req.Holder, Token: current.Token, ExpiresAt: time.Now().Add(ttl).UTC()}
+	if !locked {
+		token, err := newLockToken()
+		if err != nil {
+			writeAPIError(w, r, err)
+			return
+		}
+		lock.Token = token
+	}
+	bookLocks[id] = lock
+	writeJSON(w, status, lock)
+}
+
+// releaseBookLock removes a book's edit lock. The request must send the
+// lock's token; releasing a book that is not locked succeeds.
+func releaseBookLock(w http.ResponseWriter, r *http.Request, id int) {
+	locksMu.Lock()
+	defer locksMu.Unlock()
+
+	if current, locked := activeLock(id); locked {
+		if !lockTokenMatches(r, current) {
+			writeAPIError(w, r, lockedError(current))
+			return
+		}
+		delete(bookLocks, id)
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// checkBookLock returns an error if the book is locked and the request does
+// not send the lock's token. Callers must hold mu.
+func checkBookLock(r *http.Request, id int) error {
+	return checkBookLockToken(r.Header.Get(lockTokenHeader), id)
+}
+
+// checkBookLockToken is checkBookLock for a token taken from a request.
+// Callers must hold mu.
+func checkBookLockToken(token string, id int) error {
+	locksMu.Lock()
+	defer locksMu.Unlock()
+
+	current, locked := activeLock(id)
+	if locked && subtle.ConstantTimeCompare([]byte(token), []byte(current.Token)) != 1 {
+		return lockedError(current)
+	}
+	return nil
+}
+
+// removeBookLock drops the edit lock of a deleted book. Callers must hold
+// mu.
+func removeBookLock(id int) {
+	locksMu.Lock()
+	defer locksMu.Unlock()
+	delete(bookLocks, id)
+}
+
+// activeLock returns the book's lock unless it has expired, in which case
+// it is dropped. Callers must hold locksMu.
+func activeLock(id int) (bookLock, bool) {
+	lock, found := bookLocks[id]
+	if !found {
+		return bookLock{}, false
+	}
+	if !time.Now().Before(lock.ExpiresAt) {
+		delete(bookLocks, id)
+		return bookLock{}, false
+	}
+	return lock, true
+}
+
+// lockTokenMatches reports whether the request sends the token of lock.
+func lockTokenMatches(r *http.Request, lock bookLock) bool {
+	return subtle.ConstantTimeCompare([]byte(r.Header.Get(lockTokenHeader)), []byte(lock.Token)) == 1
+}
+
+// lockedError returns the 423 error reported for a book held by lock.
+func lockedError(lock bookLock) error {
+	return newAPIError(http.StatusLocked, "book_locked", "holder", lock.Holder, "expires_at", lock.ExpiresAt.Format(time.RFC3339))
+}
+
+// newLockToken returns a random lock token.
+func newLockToken() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
@@ -0,0 +1,126 @@
+package booksapi
+
+import (
+	"net/http"
+	"testing"
+	"time"
+)
+
+// TestBookLock walks an edit lock through its life: acquired, refused to
+// others, honoured with its token, renewed, released, and expired.
+func TestBookLock(t *testing.T) {
+	h := newTestServer(t)
+	mustCreateBook(t, h, map[string]any{"title": "Dune", "price": 10})
+	mustCreateBook(t, h, map[string]any{"title": "Emma", "price": 5})
+
+	rec := serve(t, h, http.MethodPost, "/books/1/lock", map[string]any{"holder": "alice", "ttl_seconds": 60})
+	wantCode(t, rec, http.StatusCreated)
+	lock := decode[bookLock](t, rec)
+	if lock.Holder != "alice" || lock.Token == "" || time.Until(lock.ExpiresAt) > time.Minute || time.Until(lock.ExpiresAt) < 50*time.Second {
+		t.Fatalf("lock %+v, want alice's for a minute", lock)
+	}
+	withToken := []string{lockTokenHeader, lock.Token}
+	patch := []string{"Content-Type", mergePatchType}
+
+	// Edits without the token are refused, naming the holder.
+	edits := []struct {
+		method string
+		body   any
+		header []string
+	}{
+		{http.MethodPut, map[string]any{"title": "Dune Messiah"}, nil},
+		{http.MethodPatch, map[string]any{"price": 12}, patch},
+		{http.MethodDelete, nil, nil},
+		{http.MethodPut, map[string]any{"title": "Dune Messiah"}, []string{lockTokenHeader, "not the token"}},
+	}
+	for _, edit := range edits {
+		rec := serve(t, h, edit.method, "/books/1", edit.body, edit.header...)
+		wantCode(t, rec, http.StatusLocked)
+		got := decode[errorBody](t, rec).Error
+		if got.Code != "book_locked" || got.Params["holder"] != "alice" || got.Params["expires_at"] != lock.ExpiresAt.Format(time.RFC3339) {
+			t.Errorf("%s: error %+v, want book_locked naming alice and the expiry", edit.method, got)
+		}
+	}
+	rec = serve(t, h, http.MethodPost, "/books/transactions", map[string]any{"operations": []map[string]any{
+		{"op": "update", "id": 2, "book": map[string]any{"price": 6}},
+		{"op": "delete", "id": 1},
+	}})
+	wantCode(t, rec, http.StatusLocked)
+	if got := decode[errorBody](t, rec).Error.Params["index"]; got != "1" {
+		t.Errorf("transaction refused at operation %q, want 1", got)
+	}
+	rec = serve(t, h, http.MethodPost, "/books/1/lock", map[string]any{"holder": "bob"})
+	wantCode(t, rec, http.StatusLocked)
+	// Other books are not locked.
+	wantCode(t, serve(t, h, http.MethodPut, "/books/2", map[string]any{"title": "Emma"}), http.StatusOK)
+
+	// The holder edits with the token.
+	wantCode(t, serve(t, h, http.MethodPut, "/books/1", map[string]any{"title": "Dune Messiah"}, withToken...), http.StatusOK)
+	wantCode(t, serve(t, h, http.MethodPatch, "/books/1", map[string]any{"price": 12}, append(patch, withToken...)...), http.StatusOK)
+
+	// Posting with the token renews the lock, keeping the token.
+	rec = serve(t, h, http.MethodPost, "/books/1/lock", map[string]any{"holder": "alice", "ttl_seconds": 3600}, withToken...)
+	wantCode(t, rec, http.StatusOK)
+	if renewed := decode[bookLock](t, rec); renewed.Token != lock.Token || !renewed.ExpiresAt.After(lock.ExpiresAt) {
+		t.Errorf("renewed lock %+v, want the same token expiring later than %v", renewed, lock.ExpiresAt)
+	}
+
+	// Releasing needs the token.
+	wantCode(t, serve(t, h, http.MethodDelete, "/books/1/lock", nil), http.StatusLocked)
+	wantCode(t, serve(t, h, http.MethodDelete, "/books/1/lock", nil, withToken...), http.StatusNoContent)
+	wantCode(t, serve(t, h, http.MethodDelete, "/books/1/lock", nil), http.StatusNoContent)
+	wantCode(t, serve(t, h, http.MethodPut, "/books/1", map[string]any{"title": "Dune"}), http.StatusOK)
+
+	// An expired lock lets another holder in.
+	rec = serve(t, h, http.MethodPost, "/books/1/lock", map[string]any{"holder": "alice"})
+	wantCode(t, rec, http.StatusCreated)
+	locksMu.Lock()
+	expired := bookLocks[1]
+	expired.ExpiresAt = time.Now().Add(-time.Second)
+	bookLocks[1] = expired
+	locksMu.Unlock()
+	wantCode(t, serve(t, h, http.MethodPut, "/books/1", map[string]any{"title": "Dune"}), http.StatusOK)
+	rec = serve(t, h, http.MethodPost, "/books/1/lock", map[string]any{"holder": "bob"})
+	wantCode(t, rec, http.StatusCreated)
+	bob := decode[bookLock](t, rec)
+	if bob.Token == lock.Token {
+		t.Error("a new lock reused the token of an old one")
+	}
+
+	// Deleting the book drops its lock.
+	wantCode(t, serve(t, h, http.MethodDelete, "/books/1", nil, lockTokenHeader, bob.Token), http.StatusNoContent)
+	locksMu.Lock()
+	_, found := bookLocks[1]
+	locksMu.Unlock()
+	if found {
+		t.Error("the lock of a deleted book was kept")
+	}
+}
+
+// TestBookLockErrors checks the lock requests refused.
+func TestBookLockErrors(t *testing.T) {
+	h := newTestServer(t)
+	mustCreateBook(t, h, map[string]any{"title": "Dune"})
+	tests := []struct {
+		name   string
+		path   string
+		body   map[string]any
+		status int
+		code   string
+	}{
+		{"no holder", "/books/1/lock", map[string]any{"holder": " "}, http.StatusUnprocessableEntity, "field_required"},
+		{"negative TTL", "/books/1/lock", map[string]any{"holder": "alice", "ttl_seconds": -1}, http.StatusUnprocessableEntity, "invalid_lock_ttl"},
+		{"TTL too long", "/books/1/lock", map[string]any{"holder": "alice", "ttl_seconds": 3601}, http.StatusUnprocessableEntity, "invalid_lock_ttl"},
+		{"missing book", "/books/9/lock", map[string]any{"holder": "alice"}, http.StatusNotFound, "book_not_found"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := serve(t, h, http.MethodPost, tt.path, tt.body)
+			wantCode(t, rec, tt.status)
+			if got := errorCode(t, rec); got != tt.code {
+				t.Errorf("error code %q, want %q", got, tt.code)
+			}
+		})
+	}
+	wantCode(t, serve(t, h, http.MethodGet, "/books/1/lock", nil), http.StatusMethodNotAllowed)
+}
@@ -0,0 +1,137 @@
+package booksapi
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// Book metadata lookup settings, configured by flags in Main.
+var (
+	openLibraryURL = "https://openlibrary.org"
+	lookupTimeout  = 5 * time.Second
+)
+
+// httpDoer sends outbound HTTP requests. *http.Client implements it; tests
+// can replace lookupClient with a fake.
+type httpDoer interface {
+	Do(req *http.Request) (*http.Response, error)
+}
+
+// lookupClient sends the requests of book metadata lookups.
+var lookupClient httpDoer = http.DefaultClient
+
+// maxLookupBytes caps the size of an Open Library response.
+const maxLookupBytes = 1 << 20
+
+// openLibraryBook is the part of an Open Library books API entry
+// (jscmd=data) that is mapped into a Book.
+type openLibraryBook struct {
+	Title   string `json:"title"`
+	Authors []struct {
+		Name string `json:"name"`
+	} `json:"authors"`
+	Notes any `json:"notes"` // a string, or an object with a value
+}
+
+// lookupParams declares the query parameters of POST /books/lookup.
+var lookupParams = []queryParam{{name: "isbn", kind: stringParam}}
+
+// lookupBook returns a book prepared from the Open Library metadata of the
+// ISBN given as ?isbn=, without storing it (POST /books/lookup). The client
+// may edit it and create it with POST /books.
+func lookupBook(w http.ResponseWriter, r *http.Request) {
+	q, ok := checkQuery(w, r, lookupParams...)
+	if !ok {
+		return
+	}
+
+	isbn, err := normalizeISBN(q.String("isbn"))
+	if err != nil || isbn == "" {
+		writeError(w, r, http.StatusBadRequest, "invalid_query_parameter", "name", "isbn", "expected", "ISBN")
+		return
+	}
+	book := Book{ISBN: isbn}
+	if err := enrichBook(r.Context(), &book); err != nil {
+		writeAPIError(w, r, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, book)
+}
+
+// enrichBook fills in the empty title, author, and description of book from
+// the Open Library metadata of its ISBN. It gives up after lookupTimeout or
+// when ctx is done. An ISBN Open Library does not know gets 404, and a
+// failed or unusable lookup 502.
+func enrichBook(ctx context.Context, book *Book) error {
+	meta, err := fetchOpenLibraryBook(ctx, book.ISBN)
+	if err != nil {
+		return err
+	}
+	if strings.TrimSpace(book.Title) == "" {
+		book.Title = meta.Title
+	}
+	if book.Author == "" {
+		names := make([]string, 0, len(meta.Authors))
+		for _, author := range meta.Authors {
+			names = append(names, author.Name)
+		}
+		book.Author = strings.Join(names, ", ")
+	}
+	if book.Description == "" {
+		switch notes := meta.Notes.(type) {
+		case string:
+			book.Description = notes
+		case map[string]any:
+			book.Description, _ = notes["value"].(string)
+		}
+	}
+	return nil
+}
+
+// fetchOpenLibraryBook asks the Open Library books API for the metadata of
+// an ISBN.
+func fetchOpenLibraryBook(ctx context.Context, isbn string) (openLibraryBook, error) {
+	ctx, cancel := context.WithTimeout(ctx, lookupTimeout)
+	defer cancel()
+
+	key := "ISBN:" + isbn
+	query := url.Values{"bibkeys": {key}, "format": {"json"}, "jscmd": {"data"}}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, strings.TrimSuffix(openLibraryURL, "/")+"/api/books?"+query.Encode(), nil)
+	if err != nil {
+		return openLibraryBook{}, err
+	}
+	req.Header.Set("Accept", "application/json")
+	req.Header.Set("User-Agent", "books-api/"+buildVersion().Version)
+
+	resp, err := lookupClient.Do(req)
+	if err != nil {
+		if errors.Is(err, context.DeadlineExceeded) {
+			return openLibraryBook{}, newAPIError(http.StatusBadGateway, "lookup_failed", "reason", "timeout")
+		}
+		return openLibraryBook{}, newAPIError(http.StatusBadGateway, "lookup_failed", "reason", "unreachable")
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return openLibraryBook{}, newAPIError(http.StatusBadGateway, "lookup_failed", "reason", resp.Status)
+	}
+
+	data, err := io.ReadAll(io.LimitReader(resp.Body, maxLookupBytes))
+	if err != nil {
+		return openLibraryBook{}, newAPIError(http.StatusBadGateway, "lookup_failed", "reason", "unreadable response")
+	}
+	var entries map[string]openLibraryBook
+	if err := json.Unmarshal(data, &entries); err != nil {
+		return openLibraryBook{}, newAPIError(http.StatusBadGateway, "lookup_failed", "reason", "invalid response")
+	}
+	meta, found := entries[key]
+	if !found || strings.TrimSpace(meta.Title) == "" {
+		return openLibraryBook{}, newAPIError(http.StatusNotFound, "isbn_not_found", "isbn", isbn)
+	}
+	return meta, nil
+}
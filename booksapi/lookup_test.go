@@ -0,0 +1,144 @@
+package booksapi
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// Test ISBNs with special meaning to fakeOpenLibrary.
+const (
+	isbnUnknown  = "9780000000002" // answered with no entry
+	isbnFailing  = "9781111111113" // answered with 500
+	isbnGarbled  = "9782222222224" // answered with a body that is not JSON
+	isbnSlow     = "9783333333335" // answered after the request is given up
+	isbnUntitled = "9784444444446" // answered with an entry without a title
+)
+
+// fakeOpenLibrary starts an Open Library books API answering with the
+// payloads in testdata/openlibrary, and points the lookups at it.
+func fakeOpenLibrary(t *testing.T) {
+	t.Helper()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		q := r.URL.Query()
+		isbn, ok := strings.CutPrefix(q.Get("bibkeys"), "ISBN:")
+		if r.URL.Path != "/api/books" || !ok || q.Get("format") != "json" || q.Get("jscmd") != "data" {
+			t.Errorf("unexpected lookup %s", r.URL)
+			http.NotFound(w, r)
+			return
+		}
+		switch isbn {
+		case isbnUnknown:
+			w.Write([]byte(`{}`))
+		case isbnFailing:
+			http.Error(w, "down for maintenance", http.StatusInternalServerError)
+		case isbnGarbled:
+			w.Write([]byte(`<html>`))
+		case isbnSlow:
+			<-r.Context().Done()
+		case isbnUntitled:
+			w.Write([]byte(`{"ISBN:` + isbnUntitled + `": {"authors": [{"name": "Anonymous"}]}}`))
+		default:
+			data, err := os.ReadFile(filepath.Join("testdata", "openlibrary", isbn+".json"))
+			if err != nil {
+				t.Errorf("no canned payload for ISBN %s", isbn)
+				http.NotFound(w, r)
+				return
+			}
+			w.Write(data)
+		}
+	}))
+	t.Cleanup(server.Close)
+	setForTest(t, &openLibraryURL, server.URL+"/")
+	setForTest(t, &lookupClient, httpDoer(server.Client()))
+	setForTest(t, &lookupTimeout, 100*time.Millisecond)
+}
+
+// TestLookupBook checks the books prepared from Open Library metadata and
+// the errors of failed lookups.
+func TestLookupBook(t *testing.T) {
+	h := newTestServer(t)
+	fakeOpenLibrary(t)
+	tests := []struct {
+		isbn   string
+		status int
+		want   Book   // if the lookup succeeds
+		code   string // otherwise
+		reason string
+	}{
+		{isbn: "978-0-441-17271-9", status: http.StatusOK, want: Book{Title: "Dune", Author: "Frank Herbert", ISBN: "9780441172719", Description: "Set on the desert planet Arrakis."}},
+		{isbn: "9780141439587", status: http.StatusOK, want: Book{Title: "Emma", Author: "Jane Austen, Fiona Stafford", ISBN: "9780141439587", Description: "Edited with an introduction by Fiona Stafford."}},
+		{isbn: isbnUnknown, status: http.StatusNotFound, code: "isbn_not_found"},
+		{isbn: isbnUntitled, status: http.StatusNotFound, code: "isbn_not_found"},
+		{isbn: isbnFailing, status: http.StatusBadGateway, code: "lookup_failed", reason: "500 Internal Server Error"},
+		{isbn: isbnGarbled, status: http.StatusBadGateway, code: "lookup_failed", reason: "invalid response"},
+		{isbn: isbnSlow, status: http.StatusBadGateway, code: "lookup_failed", reason: "timeout"},
+		{isbn: "9780441172718", status: http.StatusBadRequest, code: "invalid_query_parameter"},
+		{isbn: "", status: http.StatusBadRequest, code: "invalid_query_parameter"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.isbn, func(t *testing.T) {
+			start := time.Now()
+			rec := serve(t, h, http.MethodPost, "/books/lookup?isbn="+tt.isbn, nil)
+			if elapsed := time.Since(start); elapsed > time.Second {
+				t.Errorf("lookup took %v", elapsed)
+			}
+			wantCode(t, rec, tt.status)
+			if tt.status == http.StatusOK {
+				if got := decode[Book](t, rec); got.Title != tt.want.Title || got.Author != tt.want.Author || got.ISBN != tt.want.ISBN || got.Description != tt.want.Description {
+					t.Errorf("book %+v, want %+v", got, tt.want)
+				}
+				return
+			}
+			got := decode[errorBody](t, rec).Error
+			if got.Code != tt.code || got.Params["reason"] != tt.reason {
+				t.Errorf("error %s %v, want %s with reason %q", got.Code, got.Params, tt.code, tt.reason)
+			}
+		})
+	}
+	if n := decode[[]Book](t, serve(t, h, http.MethodGet, "/books", nil)); len(n) != 0 {
+		t.Errorf("lookups stored %d books", len(n))
+	}
+}
+
+// TestLookupUnreachable checks that an Open Library that cannot be reached
+// gets 502.
+func TestLookupUnreachable(t *testing.T) {
+	h := newTestServer(t)
+	fakeOpenLibrary(t)
+	server := httptest.NewServer(http.NotFoundHandler())
+	server.Close()
+	setForTest(t, &openLibraryURL, server.URL)
+	rec := serve(t, h, http.MethodPost, "/books/lookup?isbn=9780441172719", nil)
+	wantCode(t, rec, http.StatusBadGateway)
+	if got := decode[errorBody](t, rec).Error.Params["reason"]; got != "unreachable" {
+		t.Errorf("reason %q, want unreachable", got)
+	}
+}
+
+// TestCreateEnriched checks that ?enrich=true fills in the empty fields of
+// a new book, keeping those given, and creates nothing if the lookup fails.
+func TestCreateEnriched(t *testing.T) {
+	h := newTestServer(t)
+	fakeOpenLibrary(t)
+
+	rec := serve(t, h, http.MethodPost, "/books?enrich=true", map[string]any{"isbn": "9780441172719", "title": "Dune (Ace edition)", "price": 9.99})
+	wantCode(t, rec, http.StatusCreated)
+	got := decode[Book](t, rec)
+	if got.Title != "Dune (Ace edition)" || got.Author != "Frank Herbert" || got.Description != "Set on the desert planet Arrakis." || got.Price != 9.99 {
+		t.Errorf("created %+v, want the given title and price with the author and description looked up", got)
+	}
+
+	// Without enrich, an ISBN alone is not a book.
+	wantCode(t, serve(t, h, http.MethodPost, "/books", map[string]any{"isbn": "9780141439587"}), http.StatusUnprocessableEntity)
+
+	rec = serve(t, h, http.MethodPost, "/books?enrich=true", map[string]any{"isbn": isbnFailing})
+	wantCode(t, rec, http.StatusBadGateway)
+	if n := len(decode[[]Book](t, serve(t, h, http.MethodGet, "/books", nil))); n != 1 {
+		t.Errorf("%d books after a failed enrichment, want 1", n)
+	}
+}
@@ -0,0 +1,283 @@
+package booksapi
+
+import (
+	"context"
+	"expvar"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+)
+
+// Main runs the books API server configured by the command-line flags,
+// listening on port 8080 until it is interrupted; it is the whole of the
+// binary's main. "check FILE..." verifies backup files offline instead,
+// and "replay URL FILE..." sends requests recorded by -record-dir again.
+func Main() {
+	if len(os.Args) > 1 && os.Args[1] == "check" {
+		os.Exit(runCheck(os.Args[2:]))
+	}
+	if len(os.Args) > 1 && os.Args[1] == "replay" {
+		os.Exit(runReplay(os.Args[2:]))
+	}
+	flag.StringVar(&coverDir, "cover-dir", coverDir, "directory cover images are stored in")
+	flag.StringVar(&descriptionDir, "description-dir", descriptionDir, "directory full book descriptions are stored in")
+	flag.StringVar(&attributeSpec, "attributes", "", "custom book attributes, comma-separated, each optionally with a maximum length: name[:length],...")
+	flag.StringVar(&requiredFieldsSpec, "required-fields", "", "fields new books must have besides the title, comma-separated; custom attributes are named attributes.{name}")
+	flag.StringVar(&coldStorageDir, "cold-storage-dir", coldStorageDir, "directory books moved out of the store by POST /books/{id}/archive are kept in")
+	flag.BoolVar(&sendDeprecatedFields, "send-deprecated-fields", sendDeprecatedFields, "send renamed response fields under their old names too, such as a book's title next to its name")
+	flag.Int64Var(&maxBodyBytes, "max-body-bytes", maxBodyBytes, "maximum size of a JSON request body in bytes")
+	flag.StringVar(&defaultHandling, "json-handling", defaultHandling, "JSON body handling when a request sends no Prefer: handling=...: strict or lenient")
+	flag.Int64Var(&maxImportBytes, "max-import-bytes", maxImportBytes, "maximum decompressed size of a book import, or of a chunk of an import session, in bytes")
+	flag.DurationVar(&importSessionTTL, "import-session-ttl", importSessionTTL, "how long an unused import session is kept")
+	flag.IntVar(&defaultPaging.Default, "default-page-size", defaultPaging.Default, "limit of paginated listings when a request gives none (0 means no limit)")
+	flag.IntVar(&defaultPaging.Max, "max-page-size", defaultPaging.Max, "largest limit accepted by paginated listings; larger limits are reduced with a warning (0 means no maximum)")
+	flag.IntVar(&maxTransactionOps, "max-transaction-ops", maxTransactionOps, "maximum number of operations in a book transaction")
+	flag.DurationVar(&idReservationTTL, "id-reservation-ttl", idReservationTTL, "how long IDs reserved with POST /books/ids/reserve can be given to new books")
+	flag.IntVar(&maxReservedIDs, "max-reserved-ids", maxReservedIDs, "maximum number of IDs a single POST /books/ids/reserve reserves")
+	flag.Int64Var(&maxCoverBytes, "max-cover-bytes", maxCoverBytes, "maximum size of an uploaded cover image in bytes")
+	flag.Int64Var(&maxDescriptionBytes, "max-description-bytes", maxDescriptionBytes, "maximum size of a full book description uploaded with PUT /books/{id}/description in bytes")
+	flag.IntVar(&cacheMaxEntries, "cache-entries", cacheMaxEntries, "maximum number of cached book responses (0 disables the cache)")
+	flag.DurationVar(&cacheTTL, "cache-ttl", cacheTTL, "how long a cached book response stays valid")
+	flag.StringVar(&cacheControlList, "cache-control-list", cacheControlList, "Cache-Control of GET /books and the other book collection reads, without credentials (empty sends none)")
+	flag.StringVar(&cacheControlBook, "cache-control-book", cacheControlBook, "Cache-Control of GET /books/{id} and its cover, without credentials (empty sends none)")
+	flag.StringVar(&cacheControlDefault, "cache-control-default", cacheControlDefault, "Cache-Control of the other GET endpoints, without credentials (empty sends none); writes and authenticated requests always get no-store")
+	flag.IntVar(&maxBooks, "max-books", 0, "maximum number of books in the catalog (0 means unlimited)")
+	flag.Int64Var(&maxStoreBytes, "max-store-bytes", 0, "maximum approximate memory held by the books of the store, in bytes; writes past it get 507 (0 means unlimited)")
+	flag.IntVar(&maxConcurrent, "max-concurrent", 0, "maximum number of requests handled at once (0 means unlimited)")
+	flag.StringVar(&routePoliciesFile, "route-policies", "", "file of per-route limits, one \"[method] /path body=100MB timeout=5m concurrent=2\" line per route; the first matching line applies")
+	flag.DurationVar(&requestTimeout, "request-timeout", 0, "timeout of requests whose route policy sets none; slower requests get 504 (0 means none)")
+	flag.StringVar(&recordDir, "record-dir", "", "write every request and its response, without credentials, to a numbered JSON file in this directory, for the replay subcommand")
+	flag.IntVar(&maxRecordings, "record-max", maxRecordings, "number of recordings kept in -record-dir; older ones are deleted")
+	flag.IntVar(&maxRecordBody, "record-body-bytes", maxRecordBody, "bytes of each request and response body recorded; longer bodies are truncated and marked so")
+	flag.StringVar(&recordIncludes, "record-include", "", "routes recorded, comma-separated \"[method] /path\" patterns as in -route-policies (empty records every route)")
+	flag.StringVar(&recordExcludes, "record-exclude", recordExcludes, "routes not recorded, comma-separated \"[method] /path\" patterns as in -route-policies")
+	flag.BoolVar(&debugLog, "debug", false, "log debugging details of every request, such as the route policy applied")
+	flag.DurationVar(&concurrencyWait, "concurrency-wait", 0, "how long a request waits for a free slot before getting 503 when -max-concurrent is reached")
+	adminToken := flag.String("admin-token", "", "bearer token with the admin scope, required by the /admin endpoints (empty leaves them open without -tokens-file)")
+	flag.StringVar(&tokensFile, "tokens-file", "", "file of bearer tokens and their scopes (read, write, admin), one \"token scopes [user=name]\" line per token; requires a token on every request, and favorites belong to the token's user")
+	flag.BoolVar(&requireIfMatch, "require-if-match", false, "reject book deletes that do not send an If-Match header")
+	flag.BoolVar(&allowMethodOverride, "allow-method-override", false, "let POST requests override their method with X-HTTP-Method-Override or _method")
+	flag.BoolVar(&problemJSON, "problem-json", false, "send every error as an RFC 7807 application/problem+json document instead of the error envelope")
+	flag.BoolVar(&strictQuery, "strict-query", false, "reject query parameters an endpoint does not accept instead of ignoring them")
+	flag.StringVar(&basePath, "base-path", "", "path the API is served under, e.g. /api/books behind a proxy forwarding that prefix; links and Location headers include it")
+	flag.BoolVar(&trustProxy, "trust-proxy", false, "build page links from the X-Forwarded-Proto and X-Forwarded-Host headers of a reverse proxy")
+	flag.BoolVar(&preferAcceptLanguage, "prefer-accept-language", false, "list books matching the Accept-Language header first when no lang filter is given")
+	flag.StringVar(&storageKind, "storage", storageKind, "book storage backend: memory or memory-sharded")
+	flag.IntVar(&storeShards, "shards", storeShards, "number of shards used by -storage=memory-sharded")
+	flag.StringVar(&shadowStorageKind, "shadow-storage", "", "also write every change to a shadow store of this backend, comparing a sample of reads with it (see GET /admin/shadow/report)")
+	flag.Float64Var(&shadowSamplePercent, "shadow-sample-percent", shadowSamplePercent, "percentage of reads re-run against the -shadow-storage store and compared")
+	flag.StringVar(&journalPath, "journal", "", "append book changes to this journal file and replay it on startup")
+	flag.BoolVar(&journalFsync, "journal-fsync", journalFsync, "fsync the journal after every record")
+	flag.Int64Var(&journalCompactBytes, "journal-compact-bytes", journalCompactBytes, "compact the journal into a snapshot once it exceeds this size (0 disables)")
+	flag.DurationVar(&tombstoneRetention, "tombstone-retention", tombstoneRetention, "how long deletions are kept for /books/changes; older since values get 410")
+	flag.IntVar(&breakerFailures, "breaker-failures", breakerFailures, "consecutive failed store writes that open the circuit breaker, refusing writes with 503 (0 disables the breaker)")
+	flag.DurationVar(&breakerCooldown, "breaker-cooldown", breakerCooldown, "how long the open circuit breaker refuses writes before letting a trial write through")
+	flag.DurationVar(&slowStoreOp, "slow-store-op", slowStoreOp, "log store operations slower than this (0 disables)")
+	flag.DurationVar(&bookTTL, "book-ttl", 0, "delete books this long after they were last written, e.g. for demo instances (0 keeps them forever)")
+	flag.DurationVar(&ttlSweepEvery, "book-ttl-sweep", ttlSweepEvery, "how often -book-ttl removes expired books; they are hidden from reads as soon as they expire")
+	flag.DurationVar(&listSnapshotTTL, "list-snapshot-ttl", listSnapshotTTL, "how long a GET /books?snapshot=true snapshot can be read from")
+	flag.IntVar(&maxListSnapshots, "max-list-snapshots", maxListSnapshots, "maximum number of GET /books listing snapshots open at once")
+	flag.StringVar(&openLibraryURL, "openlibrary-url", openLibraryURL, "base URL of the Open Library API used by /books/lookup and ?enrich=true")
+	flag.DurationVar(&lookupTimeout, "lookup-timeout", lookupTimeout, "how long a book metadata lookup may take before it fails with 502")
+	flag.DurationVar(&mergeTimeout, "merge-timeout", mergeTimeout, "how long POST /admin/merge may take to pull a remote catalog before it fails with 502")
+	flag.StringVar(&exportPath, "export-path", "", "directory, or s3://bucket/prefix URL, that catalog exports are written to (enables POST /admin/export)")
+	flag.DurationVar(&exportInterval, "export-interval", 0, "how often the catalog is exported to -export-path, e.g. 24h (0 only exports on POST /admin/export)")
+	flag.BoolVar(&canonicalExports, "canonical-exports", false, "write exports in canonical form, byte for byte the same for the same books, as GET /books/export?canonical=true does")
+	flag.IntVar(&exportRetention, "export-retention", exportRetention, "number of exports kept in -export-path; older ones are deleted (0 keeps all)")
+	flag.StringVar(&exportS3Endpoint, "export-s3-endpoint", exportS3Endpoint, "base URL of the S3-compatible service for s3:// export paths; credentials come from AWS_ACCESS_KEY_ID and AWS_SECRET_ACCESS_KEY")
+	flag.Float64Var(&minPrice, "min-price", minPrice, "lowest price a book may have")
+	flag.Float64Var(&maxPrice, "max-price", maxPrice, "highest price a book may have")
+	flag.StringVar(&replicaOf, "replica-of", "", "run as a read-only replica of the primary server at this URL, copying its catalog through GET /books/changes; writes get 421")
+	flag.StringVar(&replicaToken, "replica-token", "", "bearer token -replica-of sends to the primary; one with write scope also copies cost prices")
+	flag.DurationVar(&replicaPoll, "replica-poll", replicaPoll, "how often -replica-of asks the primary for changes")
+	flag.DurationVar(&priceDropWindow, "price-drop-window", priceDropWindow, "how long a price drop stays in GET /feeds/price-drops.atom")
+	flag.StringVar(&sqlitePath, "sqlite", "", "keep books in memory and write every change through to this SQLite database, creating it if needed, and load them from it on startup")
+	flag.StringVar(&snapshotPath, "snapshot", "", "keep books in memory and snapshot them to this file periodically and on shutdown")
+	flag.DurationVar(&snapshotInterval, "snapshot-interval", snapshotInterval, "how often -snapshot writes the catalog when it has changed (0 only snapshots on shutdown)")
+	flag.StringVar(&defaultCurrency, "currency", defaultCurrency, "ISO 4217 currency of book prices that do not name one")
+	flag.StringVar(&collationLocale, "collation", collationLocale, "BCP 47 language tag whose rules order book titles and authors")
+	debugAddr := flag.String("debug-addr", "", "also serve /debug/vars without authentication on this address, such as localhost:6060 (empty serves it only on the API port, to admins)")
+	showVersion := flag.Bool("version", false, "print the build information and exit")
+	selfCheck := flag.Bool("selfcheck", false, "serve on an ephemeral local port, run a create/read/update/delete scenario against the configured store, and exit with its status")
+	flag.StringVar(&configFile, "config", "", "file of flag settings, one \"name=value\" line per flag without its dash, for the flags the command line does not set; re-read on SIGHUP and POST /admin/reload, -debug, -default-page-size, -max-page-size, and -request-timeout taking effect without a restart")
+	flag.Parse()
+	if err := applyConfigFile(); err != nil {
+		log.Fatalf("-config: %v", err)
+	}
+
+	if *showVersion {
+		fmt.Println(buildVersion())
+		return
+	}
+
+	basePath = normalizeBasePath(basePath)
+	if defaultHandling != handlingStrict && defaultHandling != handlingLenient {
+		log.Fatalf("-json-handling must be %s or %s", handlingStrict, handlingLenient)
+	}
+	if err := setCollationLocale(collationLocale); err != nil {
+		log.Fatalf("-collation: %v", err)
+	}
+	if _, err := normalizeCurrency(defaultCurrency); err != nil {
+		log.Fatalf("-currency: invalid currency %q", defaultCurrency)
+	}
+	var err error
+	if tokensFile != "" {
+		if apiTokens, err = loadTokens(tokensFile); err != nil {
+			log.Fatal(err)
+		}
+		requireTokens = true
+	}
+	if routePoliciesFile != "" {
+		if routePolicies, err = loadRoutePolicies(routePoliciesFile); err != nil {
+			log.Fatal(err)
+		}
+	}
+	if *adminToken != "" {
+		fixedTokens = append(fixedTokens, apiToken{secret: *adminToken, scopes: []string{scopeAdmin}})
+		apiTokens = append(apiTokens, fixedTokens...)
+	}
+	if err := configureAttributes(attributeSpec); err != nil {
+		log.Fatalf("-attributes: %v", err)
+	}
+	if err := configureRequiredFields(requiredFieldsSpec); err != nil {
+		log.Fatalf("-required-fields: %v", err)
+	}
+	persistent := 0
+	for _, path := range []string{journalPath, snapshotPath, sqlitePath} {
+		if path != "" {
+			persistent++
+		}
+	}
+	if persistent > 1 {
+		log.Fatal("only one of -journal, -snapshot, and -sqlite can be given")
+	}
+	store, err = newBookStore(storageKind)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if shadowStorageKind != "" {
+		shadow, err := newBookStore(shadowStorageKind)
+		if err != nil {
+			log.Fatal(err)
+		}
+		storeShadow = newShadowStore(store, shadow)
+		store = storeShadow
+	}
+	store = trackChanges(store)
+	if journalPath != "" {
+		if store, err = openJournal(store, journalPath); err != nil {
+			log.Fatal(err)
+		}
+	}
+	if snapshotPath != "" {
+		if store, err = openSnapshotStore(store, snapshotPath, snapshotInterval); err != nil {
+			log.Fatal(err)
+		}
+	}
+	if sqlitePath != "" {
+		if store, err = openSQLiteStore(store, sqlitePath); err != nil {
+			log.Fatal(err)
+		}
+	}
+	// Outside the journal, so replaying it does not date old drops now.
+	store = trackPriceDrops(store)
+	if bookTTL > 0 {
+		store = openTTLStore(store, bookTTL, ttlSweepEvery)
+	}
+	if recordDir != "" {
+		if maxRecordings < 1 {
+			log.Fatalf("-record-max must be positive, got %d", maxRecordings)
+		}
+		if err := openRecordings(); err != nil {
+			log.Fatalf("-record-dir: %v", err)
+		}
+	}
+	if err := openColdStorage(); err != nil {
+		log.Fatal(err)
+	}
+	var reservations string // kept next to the persistent store, if any
+	switch {
+	case journalPath != "":
+		reservations = journalPath + ".reservations"
+	case snapshotPath != "":
+		reservations = snapshotPath + ".reservations"
+	case sqlitePath != "":
+		reservations = sqlitePath + ".reservations"
+	}
+	if err := openReservations(reservations); err != nil {
+		log.Fatal(err)
+	}
+	if maxStoreBytes > 0 {
+		sized, ok := findStore[SizedStore](store)
+		if !ok {
+			log.Fatalf("-max-store-bytes: storage %q does not account for memory", storageKind)
+		}
+		sized.SetMaxBytes(maxStoreBytes)
+	}
+	if breakerFailures > 0 {
+		storeBreaker = newBreakerStore(store)
+		store = storeBreaker
+	}
+
+	if replicaOf != "" {
+		if replica, err = startReplica(context.Background(), replicaOf); err != nil {
+			log.Fatalf("-replica-of: %v", err)
+		}
+	}
+
+	if exportInterval > 0 && exportPath == "" {
+		log.Fatal("-export-interval requires -export-path")
+	}
+	if exportPath != "" {
+		target, err := newExportTarget(exportPath)
+		if err != nil {
+			log.Fatal(err)
+		}
+		exports = startExporter(target, exportInterval)
+	}
+
+	liveConfig.Store(flagConfig())
+	handler := NewServer()
+	if *selfCheck {
+		status := runSelfCheck(handler)
+		if err := closeBackends(); err != nil {
+			log.Print(err)
+			status = 1
+		}
+		os.Exit(status)
+	}
+
+	var lc lifecycle
+	lc.Add("backends", backends{})
+	lc.Add("reloader", reloader{})
+	lc.Add("api on :8080", newHTTPListener(":8080", handler))
+	if *debugAddr != "" {
+		debugMux := http.NewServeMux()
+		debugMux.Handle("/debug/vars", expvar.Handler())
+		lc.Add("debug on "+*debugAddr, newHTTPListener(*debugAddr, debugMux))
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	fmt.Printf("Server %s is running on port 8080...\n", buildVersion())
+	if err := lc.Run(ctx); err != nil {
+		log.Fatal(err)
+	}
+}
+
+// closeBackends stops the exporter and flushes the store, e.g. the final
+// snapshot, before the process exits.
+func closeBackends() error {
+	if exports != nil {
+		exports.Close()
+	}
+	if closer, ok := store.(io.Closer); ok {
+		return closer.Close()
+	}
+	return nil
+}
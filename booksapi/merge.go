@@ -0,0 +1,344 @@
+package booksapi
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+	"time"
+)
+
+// Catalog merge settings, configured by flags in Main.
+var mergeTimeout = 30 * time.Second
+
+// mergeClient sends the requests pulling a remote catalog; tests can
+// replace it with a fake, as lookupClient.
+var mergeClient httpDoer = http.DefaultClient
+
+// Merge strategies, chosen with ?strategy=, deciding which version of a
+// book found in both catalogs with different fields is kept.
+const (
+	mergeKeepLocal  = "keep_local"  // the local book is left as it is
+	mergeKeepRemote = "keep_remote" // the local book takes the remote fields
+	mergeKeepNewer  = "keep_newer"  // the most recently updated version wins; ties keep the local one
+)
+
+// Merge resolutions, reported for each conflict.
+const (
+	resolvedKeptLocal  = "kept_local"
+	resolvedTookRemote = "took_remote"
+)
+
+// mergeParams declares the query parameters of POST /admin/merge.
+var mergeParams = []queryParam{
+	{name: "strategy", kind: enumParam, values: []string{mergeKeepLocal, mergeKeepRemote, mergeKeepNewer}},
+	{name: "dry_run", kind: boolParam},
+}
+
+// mergeSource is the body of POST /admin/merge naming a running instance
+// to pull the catalog from, rather than sending an export. Recordings
+// leave its API key out, as redactedFields does.
+type mergeSource struct {
+	URL    string `json:"url"`
+	APIKey string `json:"api_key"`
+}
+
+// mergeReport is the response body of POST /admin/merge.
+type mergeReport struct {
+	Strategy  string          `json:"strategy"`
+	DryRun    bool            `json:"dry_run"`
+	Created   []mergeEntry    `json:"created"`
+	Updated   []mergeEntry    `json:"updated"`
+	Conflicts []mergeConflict `json:"conflicts"`
+	Skipped   []mergeEntry    `json:"skipped"`
+}
+
+// mergeEntry reports the outcome of merging one remote book. ID is the
+// local book's, unset for books a dry run would create.
+type mergeEntry struct {
+	Index  int    `json:"index"` // position of the book in the remote catalog
+	ID     int    `json:"id,omitempty"`
+	Reason string `json:"reason,omitempty"` // why a book was skipped
+}
+
+// mergeConflict reports a remote book matching a local one with different
+// fields, and which version was kept.
+type mergeConflict struct {
+	Index      int      `json:"index"`
+	ID         int      `json:"id"`
+	Fields     []string `json:"fields"`
+	Resolution string   `json:"resolution"`
+}
+
+// adminMergeHandler merges the catalog of another instance into this one
+// (POST /admin/merge). The body is either a backup in the format of
+// GET /books/export, possibly gzip-compressed, or a mergeSource object, in
+// which case the catalog is pulled from the source's /books/export.
+//
+// Remote books are matched with local ones by ISBN, then by title and
+// author, as imports match. Unmatched books are created with new IDs;
+// matched books with different fields are resolved by ?strategy=
+// (keep_newer by default). Remote publisher and series references name
+// the other instance's resources, so they are dropped from created books
+// and updated books keep their local ones. With ?dry_run=true the report is
+// computed without changing the catalog.
+func adminMergeHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeError(w, r, http.StatusMethodNotAllowed, "method_not_allowed")
+		return
+	}
+	q, ok := checkQuery(w, r, mergeParams...)
+	if !ok {
+		return
+	}
+	strategy, err := singleValue(q, mergeParams, "strategy")
+	if err != nil {
+		writeAPIError(w, r, err)
+		return
+	}
+	if strategy == "" {
+		strategy = mergeKeepNewer
+	}
+
+	data, err := readImportBody(w, r)
+	if err != nil {
+		writeAPIError(w, r, err)
+		return
+	}
+	var remote []Book
+	switch {
+	case jsonValueKind(data) == "array", isBackupDocument(data):
+		if remote, err = decodeBackup(r, data); err != nil {
+			writeAPIError(w, r, err)
+			return
+		}
+	case jsonValueKind(data) == "object":
+		var src mergeSource
+		if err := decodeBody(r, data, &src, true); err != nil {
+			writeAPIError(w, r, err)
+			return
+		}
+		if remote, err = fetchRemoteCatalog(r.Context(), src); err != nil {
+			writeAPIError(w, r, err)
+			return
+		}
+	default:
+		writeAPIError(w, r, checkJSONKind(data, "object"))
+		return
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+
+	report, err := mergeCatalog(r.Context(), remote, strategy, q.Bool("dry_run"))
+	if err != nil {
+		writeAPIError(w, r, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, report)
+}
+
+// fetchRemoteCatalog pulls the catalog of the instance src names from its
+// GET /books/export, giving up after mergeTimeout or when ctx is done.
+// Failures get 502.
+func fetchRemoteCatalog(ctx context.Context, src mergeSource) ([]Book, error) {
+	base, err := url.Parse(src.URL)
+	if err != nil || (base.Scheme != "http" && base.Scheme != "https") || base.Host == "" {
+		return nil, newAPIError(http.StatusUnprocessableEntity, "invalid_merge_url", "url", src.URL)
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, mergeTimeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, strings.TrimSuffix(base.String(), "/")+"/books/export", nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", "application/json")
+	req.Header.Set("User-Agent", "books-api/"+buildVersion().Version)
+	if src.APIKey != "" {
+		req.Header.Set("Authorization", "Bearer "+src.APIKey)
+	}
+
+	resp, err := mergeClient.Do(req)
+	if err != nil {
+		if errors.Is(err, context.DeadlineExceeded) {
+			return nil, newAPIError(http.StatusBadGateway, "merge_source_failed", "reason", "timeout")
+		}
+		return nil, newAPIError(http.StatusBadGateway, "merge_source_failed", "reason", "unreachable")
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, newAPIError(http.StatusBadGateway, "merge_source_failed", "reason", resp.Status)
+	}
+
+	data, err := io.ReadAll(io.LimitReader(resp.Body, maxImportBytes+1))
+	if err != nil {
+		return nil, newAPIError(http.StatusBadGateway, "merge_source_failed", "reason", "unreadable response")
+	}
+	if int64(len(data)) > maxImportBytes {
+		return nil, newAPIError(http.StatusBadGateway, "merge_source_failed", "reason", "response too large")
+	}
+	list, err := decodeBackup(nil, data)
+	if err != nil {
+		return nil, newAPIError(http.StatusBadGateway, "merge_source_failed", "reason", "invalid response: "+err.Error())
+	}
+	return list, nil
+}
+
+// mergeCatalog merges the remote books into the store by strategy,
+// reporting what it did, or with dryRun what it would do. Invalid remote
+// books, books matching one created earlier in the same merge, and books
+// with an edition ISBN another book has are skipped. The changes are made in a single transaction, so a failing write
+// leaves the catalog as it was. Callers must hold mu.
+func mergeCatalog(ctx context.Context, remote []Book, strategy string, dryRun bool) (mergeReport, error) {
+	report := mergeReport{
+		Strategy:  strategy,
+		DryRun:    dryRun,
+		Created:   []mergeEntry{},
+		Updated:   []mergeEntry{},
+		Conflicts: []mergeConflict{},
+		Skipped:   []mergeEntry{},
+	}
+
+	byISBN := make(map[string]int)
+	byTitleAuthor := make(map[string]int)
+	local := make(map[int]Book)
+	isbnOwners := editionISBNOwners(func(int) bool { return true })
+	for _, book := range store.List(ListOptions{}) {
+		if book.ISBN != "" {
+			byISBN[book.ISBN] = book.ID
+		}
+		byTitleAuthor[titleAuthorKey(book)] = book.ID
+		local[book.ID] = book
+	}
+	// Keys of the books created by this merge, so remote duplicates of them
+	// are skipped rather than created twice.
+	created := make(map[string]bool)
+
+	var creates []Book
+	var createIndexes []int
+	var updates []Book
+	for index, book := range remote {
+		book.ID = 0
+		book.PublisherID, book.SeriesID, book.SeriesIndex = nil, nil, 0
+		if err := validateBook(&book, ValidateCreate); err != nil {
+			report.Skipped = append(report.Skipped, mergeEntry{Index: index, Reason: asAPIError(err).Code})
+			continue
+		}
+
+		id, found := 0, false
+		if book.ISBN != "" {
+			id, found = byISBN[book.ISBN]
+		}
+		if !found {
+			id, found = byTitleAuthor[titleAuthorKey(book)]
+		}
+		if !found {
+			if (book.ISBN != "" && created["isbn:"+book.ISBN]) || created[titleAuthorKey(book)] {
+				report.Skipped = append(report.Skipped, mergeEntry{Index: index, Reason: "duplicate"})
+				continue
+			}
+			if err := claimEditionISBNs(isbnOwners, book); err != nil {
+				report.Skipped = append(report.Skipped, mergeEntry{Index: index, Reason: asAPIError(err).Code})
+				continue
+			}
+			if book.ISBN != "" {
+				created["isbn:"+book.ISBN] = true
+			}
+			created[titleAuthorKey(book)] = true
+			creates = append(creates, book)
+			createIndexes = append(createIndexes, index)
+			continue
+		}
+
+		match := local[id]
+		merged := book
+		merged.ID, merged.CreatedAt = match.ID, match.CreatedAt
+		merged.PublisherID, merged.SeriesID, merged.SeriesIndex = match.PublisherID, match.SeriesID, match.SeriesIndex
+		if merged.CostPrice == nil {
+			merged.CostPrice = match.CostPrice // not shown to the remote caller
+		}
+		changes, err := bookChanges(match, merged)
+		if err != nil {
+			return mergeReport{}, err
+		}
+		if len(changes) == 0 {
+			report.Skipped = append(report.Skipped, mergeEntry{Index: index, ID: id, Reason: "unchanged"})
+			continue
+		}
+
+		conflict := mergeConflict{Index: index, ID: id, Resolution: resolvedKeptLocal}
+		for name := range changes {
+			conflict.Fields = append(conflict.Fields, name)
+		}
+		slices.Sort(conflict.Fields)
+		if strategy == mergeKeepRemote || (strategy == mergeKeepNewer && newerThan(book.UpdatedAt, match.UpdatedAt)) {
+			// The remote editions replace the local ones, whose ISBNs the
+			// book gives up unless it cannot have the remote ones.
+			for _, e := range match.Editions {
+				delete(isbnOwners, e.ISBN)
+			}
+			if err := claimEditionISBNs(isbnOwners, merged); err != nil {
+				claimEditionISBNs(isbnOwners, match)
+				report.Skipped = append(report.Skipped, mergeEntry{Index: index, ID: id, Reason: asAPIError(err).Code})
+				continue
+			}
+			conflict.Resolution = resolvedTookRemote
+			touchBook(&merged, match.CreatedAt)
+			updates = append(updates, merged)
+			report.Updated = append(report.Updated, mergeEntry{Index: index, ID: id})
+		}
+		report.Conflicts = append(report.Conflicts, conflict)
+	}
+
+	if err := checkQuota(len(creates)); err != nil {
+		return mergeReport{}, err
+	}
+	if dryRun {
+		for _, index := range createIndexes {
+			report.Created = append(report.Created, mergeEntry{Index: index})
+		}
+		return report, nil
+	}
+
+	now := time.Now().UTC()
+	err := store.Transact(ctx, func(tx BookStore) error {
+		for _, book := range updates {
+			if err := tx.Put(book); err != nil {
+				return err
+			}
+		}
+		for i, book := range creates {
+			if book.CreatedAt == nil {
+				book.CreatedAt = &now
+			}
+			book.UpdatedAt = &now
+			book, err := tx.Create(book)
+			if err != nil {
+				return err
+			}
+			report.Created = append(report.Created, mergeEntry{Index: createIndexes[i], ID: book.ID})
+		}
+		return nil
+	})
+	if err != nil {
+		return mergeReport{}, err
+	}
+	return report, nil
+}
+
+// newerThan reports whether a was updated after b; an unknown time is the
+// oldest.
+func newerThan(a, b *time.Time) bool {
+	switch {
+	case a == nil:
+		return false
+	case b == nil:
+		return true
+	}
+	return a.After(*b)
+}
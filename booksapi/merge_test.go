@@ -0,0 +1,153 @@
+package booksapi
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// loadMergeCatalog fills the store with the books of
+// testdata/merge/local.json, keeping their IDs and times.
+func loadMergeCatalog(t *testing.T) {
+	t.Helper()
+	data, err := os.ReadFile(filepath.Join("testdata", "merge", "local.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var books []Book
+	if err := json.Unmarshal(data, &books); err != nil {
+		t.Fatal(err)
+	}
+	for _, book := range books {
+		if err := store.Put(book); err != nil {
+			t.Fatal(err)
+		}
+		if err := store.Reserve(book.ID); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+// TestMergeStrategies merges testdata/merge/remote.json, which overlaps
+// the local catalog and conflicts with it, under each strategy, and pins
+// the reports and the catalogs they leave.
+func TestMergeStrategies(t *testing.T) {
+	remote, err := os.ReadFile(filepath.Join("testdata", "merge", "remote.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, strategy := range []string{mergeKeepLocal, mergeKeepRemote, mergeKeepNewer} {
+		t.Run(strategy, func(t *testing.T) {
+			h := newTestServer(t)
+			loadMergeCatalog(t)
+			before := catalogJSON(t, store)
+
+			rec := serve(t, h, http.MethodPost, "/admin/merge?dry_run=true&strategy="+strategy, remote)
+			wantCode(t, rec, http.StatusOK)
+			dryRun := decode[mergeReport](t, rec)
+			if after := catalogJSON(t, store); after != before {
+				t.Errorf("dry run changed the catalog to %s", after)
+			}
+
+			rec = serve(t, h, http.MethodPost, "/admin/merge?strategy="+strategy, remote)
+			wantCode(t, rec, http.StatusOK)
+			checkGolden(t, "merge/"+strategy+"_report.json", rec.Body.Bytes())
+			checkGolden(t, "merge/"+strategy+"_catalog.json", serve(t, h, http.MethodGet, "/books?include=description", nil).Body.Bytes())
+
+			// The dry run reported the same, without the IDs it would
+			// have given the new books.
+			report := decode[mergeReport](t, rec)
+			for i := range report.Created {
+				report.Created[i].ID = 0
+			}
+			report.DryRun = true
+			if got, want := jsonString(t, dryRun), jsonString(t, report); got != want {
+				t.Errorf("dry run reported %s, want %s", got, want)
+			}
+
+			dune, _ := store.Get(1)
+			if want := time.Date(2023, 6, 1, 0, 0, 0, 0, time.UTC); !dune.CreatedAt.Equal(want) {
+				t.Errorf("merged book created at %v, want the local %v", dune.CreatedAt, want)
+			}
+			hyperion, _ := store.Get(4)
+			if want := time.Date(2021, 5, 5, 0, 0, 0, 0, time.UTC); hyperion.CreatedAt == nil || !hyperion.CreatedAt.Equal(want) {
+				t.Errorf("new book created at %v, want the remote %v", hyperion.CreatedAt, want)
+			}
+		})
+	}
+
+	t.Run("default", func(t *testing.T) {
+		h := newTestServer(t)
+		loadMergeCatalog(t)
+		rec := serve(t, h, http.MethodPost, "/admin/merge", remote)
+		wantCode(t, rec, http.StatusOK)
+		if got := decode[mergeReport](t, rec).Strategy; got != mergeKeepNewer {
+			t.Errorf("strategy %q, want keep_newer", got)
+		}
+	})
+}
+
+// jsonString returns the JSON encoding of v.
+func jsonString(t *testing.T, v any) string {
+	t.Helper()
+	data, err := json.Marshal(v)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(data)
+}
+
+// TestMergeFromRemote pulls the catalog from another instance's export
+// through the merge client, and checks the failures of the pull.
+func TestMergeFromRemote(t *testing.T) {
+	h := newTestServer(t)
+	loadMergeCatalog(t)
+	remote, err := os.ReadFile(filepath.Join("testdata", "merge", "remote.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.URL.Path == "/slow/books/export":
+			<-r.Context().Done()
+		case r.URL.Path != "/books/export" || r.Method != http.MethodGet:
+			http.NotFound(w, r)
+		case r.Header.Get("Authorization") != "Bearer k1":
+			http.Error(w, "no", http.StatusUnauthorized)
+		default:
+			w.Write(remote)
+		}
+	}))
+	t.Cleanup(server.Close)
+	setForTest(t, &mergeClient, httpDoer(server.Client()))
+	setForTest(t, &mergeTimeout, 100*time.Millisecond)
+
+	rec := serve(t, h, http.MethodPost, "/admin/merge?strategy=keep_local", mergeSource{URL: server.URL + "/", APIKey: "k1"})
+	wantCode(t, rec, http.StatusOK)
+	checkGolden(t, "merge/keep_local_report.json", rec.Body.Bytes())
+
+	tests := []struct {
+		name   string
+		src    mergeSource
+		status int
+		reason string
+	}{
+		{"wrong key", mergeSource{URL: server.URL, APIKey: "k2"}, http.StatusBadGateway, "401 Unauthorized"},
+		{"no export", mergeSource{URL: server.URL + "/other", APIKey: "k1"}, http.StatusBadGateway, "404 Not Found"},
+		{"slow", mergeSource{URL: server.URL + "/slow", APIKey: "k1"}, http.StatusBadGateway, "timeout"},
+		{"not a URL", mergeSource{URL: "ftp://example.com"}, http.StatusUnprocessableEntity, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := serve(t, h, http.MethodPost, "/admin/merge", tt.src)
+			wantCode(t, rec, tt.status)
+			if got := decode[errorBody](t, rec).Error; got.Params["reason"] != tt.reason {
+				t.Errorf("error %s %v, want reason %q", got.Code, got.Params, tt.reason)
+			}
+		})
+	}
+}
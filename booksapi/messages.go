@@ -0,0 +1,304 @@
+package booksapi
+
+import (
+	"net/http"
+	"strings"
+
+	"golang.org/x/text/language"
+)
+
+// defaultLocale is the locale used when no supported locale is requested,
+// and for any message missing from another locale's catalog.
+const defaultLocale = "en"
+
+// messages holds the error message templates of each supported locale,
+// keyed by error code. Templates refer to parameters as {name}.
+var messages = map[string]map[string]string{
+	"en": {
+		"internal_error":               "Internal server error (request {request_id})",
+		"invalid_request":              "Invalid request",
+		"request_too_large":            "Request body must be at most {max} bytes",
+		"method_not_allowed":           "Method not allowed",
+		"not_found":                    "Not found",
+		"authentication_required":      "Authentication required",
+		"insufficient_scope":           "Token lacks the {scope} scope",
+		"too_many_operations":          "A transaction can hold at most {max} operations",
+		"invalid_operation":            "Unknown operation {op}",
+		"invalid_operation_ref":        "Operation reference {ref} does not name an earlier create operation",
+		"changes_expired":              "Changes since sequence {since} are no longer available; resync by requesting changes without since",
+		"book_locked":                  "Book is locked by {holder} until {expires_at}",
+		"invalid_lock_ttl":             "Lock TTL must be between 1 and {max} seconds",
+		"invalid_body_type":            "Expected JSON {expected}, got {got}",
+		"field_too_small":              "{field} must be at least {min}",
+		"invalid_cursor":               "Invalid cursor",
+		"cursor_mismatch":              "The cursor was created for a different sort order",
+		"conflicting_query_parameters": "Query parameter {first} cannot be combined with {second}",
+		"too_many_snapshots":           "Too many listing snapshots are open; at most {max} are allowed",
+		"snapshot_expired":             "The listing snapshot has expired or does not exist",
+		"invalid_isbn":                 "Invalid ISBN {isbn}",
+		"isbn_not_found":               "No book with ISBN {isbn} was found",
+		"lookup_failed":                "The book metadata lookup failed: {reason}",
+		"exports_disabled":             "Exports are not enabled",
+		"export_failed":                "The export failed: {reason}",
+		"import_conflict":              "The book matches existing book {id}",
+		"invalid_filter_id":            "Invalid filter ID {id}: {reason}",
+		"filter_not_found":             "Filter not found",
+		"unknown_filter_field":         "Unknown filter field {field}",
+		"invalid_filter":               "Invalid value for filter field {name}: expected {expected}",
+		"price_out_of_range":           "{field} must be between {min} and {max}",
+		"too_many_decimals":            "{field} may have at most {decimals} decimal places",
+		"invalid_utf8":                 "{field} is not valid UTF-8",
+		"unsupported_charset":          "Unsupported charset {charset}; use UTF-8 or ISO-8859-1",
+		"invalid_chunk_number":         "Chunk number must be a non-negative integer",
+		"chunk_already_uploaded":       "Chunk {chunk} has already been uploaded",
+		"import_expired":               "Import session not found or expired",
+		"import_not_open":              "Import session is {state} and no longer accepts this",
+		"import_has_invalid_rows":      "Import has {count} invalid rows; fix them or commit with skip_invalid=true",
+		"field_forbidden":              "Writing {field} requires the {scope} scope",
+		"route_busy":                   "Too many requests to this endpoint at once (max {max}); retry shortly",
+		"request_timeout":              "Request did not complete within {timeout}",
+		"invalid_request_timeout":      "{header} must be a positive duration, such as 2s, or a number of seconds",
+		"store_unavailable":            "The book store is unavailable; retry later",
+		"conflict":                     "Book was changed by a conflicting request",
+		"validation_failed":            "Book is invalid",
+		"book_archived":                "Book {id} is archived; unarchive it before changing it",
+		"book_not_archived":            "Book {id} is not archived",
+		"invalid_merge_url":            "Merge source URL {url} must be an absolute http or https URL",
+		"merge_source_failed":          "Could not pull the remote catalog: {reason}",
+		"shadow_disabled":              "Shadow mode is not enabled",
+		"backup_incomplete":            "Backup document lacks its {field}",
+		"backup_count_mismatch":        "Backup declares {expected} books but holds {actual}",
+		"backup_checksum_mismatch":     "Backup checksum is {actual}, expected {expected}",
+		"history_unavailable":          "Reading the catalog as of a past time needs the journal, enabled with -journal",
+		"as_of_too_old":                "History before {earliest} is no longer kept",
+		"book_deleted":                 "Book {id} had been deleted by {as_of}",
+		"id_not_reserved":              "ID {id} is not reserved for you",
+		"id_reservation_expired":       "The reservation of ID {id} expired at {expired_at}",
+		"conflicting_fields":           "Fields {first} and {second} have different values; give only one",
+		"read_only_replica":            "This server is a read-only replica; send changes to the primary at {primary}",
+		"store_full":                   "The book store is full: {used_bytes} of {limit_bytes} bytes used, {needed_bytes} more needed",
+		"batch_timeout":                "The batch ran out of time after {completed} of {total} items; none of its changes were kept",
+		"invalid_continuation":         "Invalid continuation token for these operations",
+		"empty_body":                   "Request body is empty",
+		"invalid_json":                 "Malformed JSON at byte {offset}",
+		"trailing_data":                "Unexpected data after the JSON value at byte {offset}",
+		"reload_failed":                "The configuration could not be reloaded: {reason}",
+		"invalid_edition_format":       "Invalid edition format {format}; expected one of {formats}",
+		"field_not_unique":             "{field} repeats {value}, which must be unique within the book",
+		"edition_isbn_taken":           "ISBN {isbn} is already an edition of book {id}",
+		"invalid_currency":             "Invalid currency code {code}",
+		"invalid_query_parameter":      "Invalid value for query parameter {name}: expected {expected}",
+		"unknown_query_parameter":      "Unknown query parameter {name}",
+		"repeated_query_parameter":     "Query parameter {name} may only be given once",
+		"missing_search_query":         "Missing search query",
+		"invalid_book_id":              "Invalid book ID {id}: {reason}",
+		"invalid_shelf_id":             "Invalid shelf ID {id}: {reason}",
+		"invalid_publisher_id":         "Invalid publisher ID {id}: {reason}",
+		"invalid_series_id":            "Invalid series ID {id}: {reason}",
+		"book_not_found":               "Book not found",
+		"shelf_not_found":              "Shelf not found",
+		"publisher_not_found":          "Publisher not found",
+		"series_not_found":             "Series not found",
+		"cover_not_found":              "Cover not found",
+		"book_not_on_shelf":            "Book not on shelf",
+		"field_required":               "{field} is required",
+		"field_too_long":               "{field} must be at most {max} characters",
+		"invalid_language":             "Invalid language tag {tag}",
+		"publisher_missing":            "Publisher {id} does not exist",
+		"series_missing":               "Series {id} does not exist",
+		"series_index_invalid":         "series_index must be a positive volume number",
+		"series_index_taken":           "Series {series} already has volume {index}",
+		"publisher_has_books":          "Publisher still has books",
+		"cover_too_large":              "Cover must be at most {max} bytes",
+		"cover_unsupported_type":       "Cover must be a PNG or JPEG image",
+		"cover_store_failed":           "Failed to store cover",
+		"description_not_found":        "Full description not found",
+		"description_too_large":        "Full description must be at most {max} bytes",
+		"description_unsupported_type": "Full description must be sent as text/plain",
+		"description_store_failed":     "Failed to store full description",
+		"if_match_required":            "If-Match header required",
+		"precondition_failed":          "Book has been modified",
+		"method_override_invalid":      "Method override must be PUT, PATCH, or DELETE",
+		"unsupported_patch_format":     "Unsupported patch format",
+		"invalid_patch":                "Invalid patch",
+		"id_read_only":                 "id cannot be changed",
+		"unknown_field":                "Unknown field {field}",
+		"invalid_field_type":           "{field} must be of JSON type {type}",
+		"patch_invalid_path":           "Operation {index}: invalid path {path}",
+		"patch_path_not_found":         "Operation {index}: path {path} does not exist",
+		"patch_index_out_of_range":     "Operation {index}: array index out of bounds in {path}",
+		"patch_id_read_only":           "Operation {index}: id cannot be changed",
+		"patch_missing_value":          "Operation {index}: missing value",
+		"patch_unsupported_op":         "Operation {index}: unsupported op {op}",
+		"patch_test_failed":            "Operation {index}: test failed for path {path}",
+		"quota_exceeded":               "Catalog is full: {count} of {limit} books",
+		"snapshots_disabled":           "Snapshots are not enabled",
+		"server_busy":                  "Server is busy, try again later",
+		"import_too_large":             "Import must be at most {max} bytes after decompression",
+		"unsupported_content_encoding": "Unsupported content encoding {encoding}",
+	},
+	"es": {
+		"internal_error":               "Error interno del servidor (solicitud {request_id})",
+		"invalid_request":              "Solicitud no válida",
+		"request_too_large":            "El cuerpo de la solicitud debe tener como máximo {max} bytes",
+		"method_not_allowed":           "Método no permitido",
+		"not_found":                    "No encontrado",
+		"authentication_required":      "Se requiere autenticación",
+		"insufficient_scope":           "El token no tiene el ámbito {scope}",
+		"too_many_operations":          "Una transacción puede contener como máximo {max} operaciones",
+		"invalid_operation":            "Operación desconocida {op}",
+		"invalid_operation_ref":        "La referencia de operación {ref} no corresponde a una operación create anterior",
+		"changes_expired":              "Los cambios desde la secuencia {since} ya no están disponibles; vuelva a sincronizar solicitando los cambios sin since",
+		"book_locked":                  "El libro está bloqueado por {holder} hasta {expires_at}",
+		"invalid_lock_ttl":             "La duración del bloqueo debe estar entre 1 y {max} segundos",
+		"invalid_body_type":            "Se esperaba JSON de tipo {expected}, se recibió {got}",
+		"field_too_small":              "{field} debe ser como mínimo {min}",
+		"invalid_cursor":               "Cursor no válido",
+		"cursor_mismatch":              "El cursor se creó para otro orden",
+		"conflicting_query_parameters": "El parámetro {first} no se puede combinar con {second}",
+		"too_many_snapshots":           "Hay demasiadas instantáneas de listado abiertas; se permiten como máximo {max}",
+		"snapshot_expired":             "La instantánea de listado ha caducado o no existe",
+		"invalid_isbn":                 "ISBN no válido {isbn}",
+		"isbn_not_found":               "No se encontró ningún libro con el ISBN {isbn}",
+		"lookup_failed":                "La búsqueda de datos del libro falló: {reason}",
+		"exports_disabled":             "Las exportaciones no están activadas",
+		"export_failed":                "La exportación falló: {reason}",
+		"import_conflict":              "El libro coincide con el libro existente {id}",
+		"invalid_filter_id":            "ID de filtro no válido {id}: {reason}",
+		"filter_not_found":             "Filtro no encontrado",
+		"unknown_filter_field":         "Campo de filtro desconocido {field}",
+		"invalid_filter":               "Valor no válido para el campo de filtro {name}: se esperaba {expected}",
+		"price_out_of_range":           "{field} debe estar entre {min} y {max}",
+		"too_many_decimals":            "{field} puede tener como máximo {decimals} decimales",
+		"invalid_utf8":                 "{field} no es UTF-8 válido",
+		"unsupported_charset":          "Juego de caracteres no admitido {charset}; use UTF-8 o ISO-8859-1",
+		"invalid_chunk_number":         "El número de fragmento debe ser un entero no negativo",
+		"chunk_already_uploaded":       "El fragmento {chunk} ya se ha subido",
+		"import_expired":               "Sesión de importación no encontrada o caducada",
+		"import_not_open":              "La sesión de importación está en estado {state} y ya no lo admite",
+		"import_has_invalid_rows":      "La importación tiene {count} filas no válidas; corríjalas o confirme con skip_invalid=true",
+		"field_forbidden":              "Escribir {field} requiere el ámbito {scope}",
+		"route_busy":                   "Demasiadas solicitudes simultáneas a este punto de acceso (máximo {max}); reintente en breve",
+		"request_timeout":              "La solicitud no se completó en {timeout}",
+		"invalid_request_timeout":      "{header} debe ser una duración positiva, como 2s, o un número de segundos",
+		"store_unavailable":            "El almacén de libros no está disponible; reintente más tarde",
+		"conflict":                     "El libro fue modificado por una solicitud en conflicto",
+		"validation_failed":            "El libro no es válido",
+		"book_archived":                "El libro {id} está archivado; desarchívelo antes de modificarlo",
+		"book_not_archived":            "El libro {id} no está archivado",
+		"invalid_merge_url":            "La URL de origen {url} debe ser una URL http o https absoluta",
+		"merge_source_failed":          "No se pudo obtener el catálogo remoto: {reason}",
+		"shadow_disabled":              "El modo de sombra no está activado",
+		"backup_incomplete":            "Al documento de copia de seguridad le falta {field}",
+		"backup_count_mismatch":        "La copia de seguridad declara {expected} libros pero contiene {actual}",
+		"backup_checksum_mismatch":     "La suma de comprobación de la copia es {actual}, se esperaba {expected}",
+		"history_unavailable":          "Leer el catálogo en un momento pasado requiere el diario, activado con -journal",
+		"as_of_too_old":                "El historial anterior a {earliest} ya no se conserva",
+		"book_deleted":                 "El libro {id} se había eliminado el {as_of}",
+		"id_not_reserved":              "El ID {id} no está reservado para usted",
+		"id_reservation_expired":       "La reserva del ID {id} caducó el {expired_at}",
+		"conflicting_fields":           "Los campos {first} y {second} tienen valores distintos; indique solo uno",
+		"read_only_replica":            "Este servidor es una réplica de solo lectura; envíe los cambios al servidor principal en {primary}",
+		"store_full":                   "El almacén de libros está lleno: {used_bytes} de {limit_bytes} bytes usados, faltan {needed_bytes}",
+		"batch_timeout":                "El lote se quedó sin tiempo tras {completed} de {total} elementos; no se conservó ninguno de sus cambios",
+		"invalid_continuation":         "Token de continuación no válido para estas operaciones",
+		"empty_body":                   "El cuerpo de la solicitud está vacío",
+		"invalid_json":                 "JSON mal formado en el byte {offset}",
+		"trailing_data":                "Datos inesperados después del valor JSON en el byte {offset}",
+		"reload_failed":                "No se pudo recargar la configuración: {reason}",
+		"invalid_edition_format":       "Formato de edición no válido {format}; se esperaba uno de {formats}",
+		"field_not_unique":             "{field} repite {value}, que debe ser único dentro del libro",
+		"edition_isbn_taken":           "El ISBN {isbn} ya es una edición del libro {id}",
+		"invalid_currency":             "Código de moneda no válido {code}",
+		"invalid_query_parameter":      "Valor no válido para el parámetro {name}: se esperaba {expected}",
+		"unknown_query_parameter":      "Parámetro desconocido {name}",
+		"repeated_query_parameter":     "El parámetro {name} solo puede indicarse una vez",
+		"missing_search_query":         "Falta la consulta de búsqueda",
+		"invalid_book_id":              "ID de libro no válido {id}: {reason}",
+		"invalid_shelf_id":             "ID de estantería no válido {id}: {reason}",
+		"invalid_publisher_id":         "ID de editorial no válido {id}: {reason}",
+		"invalid_series_id":            "ID de serie no válido {id}: {reason}",
+		"book_not_found":               "Libro no encontrado",
+		"shelf_not_found":              "Estantería no encontrada",
+		"publisher_not_found":          "Editorial no encontrada",
+		"series_not_found":             "Serie no encontrada",
+		"cover_not_found":              "Portada no encontrada",
+		"book_not_on_shelf":            "El libro no está en la estantería",
+		"field_required":               "{field} es obligatorio",
+		"field_too_long":               "{field} debe tener como máximo {max} caracteres",
+		"invalid_language":             "Etiqueta de idioma no válida {tag}",
+		"publisher_missing":            "La editorial {id} no existe",
+		"series_missing":               "La serie {id} no existe",
+		"series_index_invalid":         "series_index debe ser un número de volumen positivo",
+		"series_index_taken":           "La serie {series} ya tiene el volumen {index}",
+		"publisher_has_books":          "La editorial todavía tiene libros",
+		"cover_too_large":              "La portada debe tener como máximo {max} bytes",
+		"cover_unsupported_type":       "La portada debe ser una imagen PNG o JPEG",
+		"cover_store_failed":           "No se pudo guardar la portada",
+		"description_not_found":        "Descripción completa no encontrada",
+		"description_too_large":        "La descripción completa debe tener como máximo {max} bytes",
+		"description_unsupported_type": "La descripción completa debe enviarse como text/plain",
+		"description_store_failed":     "No se pudo guardar la descripción completa",
+		"if_match_required":            "Se requiere la cabecera If-Match",
+		"precondition_failed":          "El libro ha sido modificado",
+		"method_override_invalid":      "La sustitución de método debe ser PUT, PATCH o DELETE",
+		"unsupported_patch_format":     "Formato de parche no admitido",
+		"invalid_patch":                "Parche no válido",
+		"id_read_only":                 "El id no se puede cambiar",
+		"unknown_field":                "Campo desconocido {field}",
+		"invalid_field_type":           "{field} debe ser de tipo JSON {type}",
+		"patch_invalid_path":           "Operación {index}: ruta no válida {path}",
+		"patch_path_not_found":         "Operación {index}: la ruta {path} no existe",
+		"patch_index_out_of_range":     "Operación {index}: índice fuera de rango en {path}",
+		"patch_id_read_only":           "Operación {index}: el id no se puede cambiar",
+		"patch_missing_value":          "Operación {index}: falta el valor",
+		"patch_unsupported_op":         "Operación {index}: operación no admitida {op}",
+		"patch_test_failed":            "Operación {index}: la prueba falló para la ruta {path}",
+		"quota_exceeded":               "El catálogo está lleno: {count} de {limit} libros",
+		"snapshots_disabled":           "Las instantáneas no están activadas",
+		"server_busy":                  "El servidor está ocupado, inténtelo más tarde",
+		"import_too_large":             "La importación debe tener como máximo {max} bytes descomprimida",
+		"unsupported_content_encoding": "Codificación de contenido no admitida {encoding}",
+	},
+}
+
+// supportedLocales lists the locales with a message catalog. The first one
+// is the fallback.
+var supportedLocales = []language.Tag{language.English, language.Spanish}
+
+// localeMatcher picks the best supported locale for an Accept-Language header.
+var localeMatcher = language.NewMatcher(supportedLocales)
+
+// requestLocale returns the supported locale that best matches the
+// request's Accept-Language header.
+func requestLocale(r *http.Request) string {
+	if r == nil {
+		return defaultLocale
+	}
+	tags, _, err := language.ParseAcceptLanguage(r.Header.Get("Accept-Language"))
+	if err != nil || len(tags) == 0 {
+		return defaultLocale
+	}
+	_, index, confidence := localeMatcher.Match(tags...)
+	if confidence == language.No {
+		return defaultLocale
+	}
+	return supportedLocales[index].String()
+}
+
+// translate returns the message for code in locale with params substituted.
+// Messages missing from the locale fall back to English, and unknown codes
+// fall back to the code itself, so a lookup never fails.
+func translate(locale, code string, params map[string]string) string {
+	template, ok := messages[locale][code]
+	if !ok {
+		template, ok = messages[defaultLocale][code]
+	}
+	if !ok {
+		template = code
+	}
+	for name, value := range params {
+		template = strings.ReplaceAll(template, "{"+name+"}", value)
+	}
+	return template
+}
@@ -0,0 +1,45 @@
+package booksapi
+
+import (
+	"net/http"
+	"testing"
+)
+
+// TestLocalizedErrors sends the same failing requests with different
+// Accept-Language headers and checks that the message follows the header,
+// falling back to English, while the error code stays the same.
+func TestLocalizedErrors(t *testing.T) {
+	h := newTestServer(t)
+	setForTest(t, &maxBodyBytes, 64)
+
+	tests := []struct {
+		acceptLanguage string
+		notFound       string
+		tooLarge       string
+	}{
+		{"", "Book not found", "Request body must be at most 64 bytes"},
+		{"en-US", "Book not found", "Request body must be at most 64 bytes"},
+		{"es", "Libro no encontrado", "El cuerpo de la solicitud debe tener como máximo 64 bytes"},
+		{"es-MX,es;q=0.9", "Libro no encontrado", "El cuerpo de la solicitud debe tener como máximo 64 bytes"},
+		{"fr, es;q=0.5", "Libro no encontrado", "El cuerpo de la solicitud debe tener como máximo 64 bytes"},
+		{"fr", "Book not found", "Request body must be at most 64 bytes"},
+		{"de-CH, ja;q=0.5", "Book not found", "Request body must be at most 64 bytes"},
+		{"not a language tag!", "Book not found", "Request body must be at most 64 bytes"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.acceptLanguage, func(t *testing.T) {
+			rec := serve(t, h, http.MethodGet, "/books/99", nil, "Accept-Language", tt.acceptLanguage)
+			wantCode(t, rec, http.StatusNotFound)
+			if got := decode[errorBody](t, rec).Error; got.Code != "book_not_found" || got.Message != tt.notFound {
+				t.Errorf("error %s %q, want book_not_found %q", got.Code, got.Message, tt.notFound)
+			}
+
+			body := map[string]any{"title": "Dune", "description": "A very long description of the desert planet."}
+			rec = serve(t, h, http.MethodPost, "/books", body, "Accept-Language", tt.acceptLanguage)
+			wantCode(t, rec, http.StatusRequestEntityTooLarge)
+			if got := decode[errorBody](t, rec).Error; got.Code != "request_too_large" || got.Message != tt.tooLarge {
+				t.Errorf("error %s %q, want request_too_large %q", got.Code, got.Message, tt.tooLarge)
+			}
+		})
+	}
+}
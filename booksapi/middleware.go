@@ -0,0 +1,83 @@
+package booksapi
+
+import (
+	"bytes"
+	"io"
+	"mime"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// allowMethodOverride enables the methodOverride middleware. It is off by
+// default because it lets a plain form POST reach PUT, PATCH, and DELETE
+// handlers, which matters for CSRF protections that only guard non-POST
+// methods.
+var allowMethodOverride bool
+
+// overridableMethods are the methods a POST request may be rewritten to.
+var overridableMethods = map[string]bool{
+	http.MethodPut:    true,
+	http.MethodPatch:  true,
+	http.MethodDelete: true,
+}
+
+// methodOverride lets clients limited to GET and POST issue PUT, PATCH, and
+// DELETE requests. The effective method of a POST is taken from the
+// X-HTTP-Method-Override header, falling back to a _method query parameter
+// or form field. Requests using any other method are passed through as is.
+func methodOverride(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		method := r.Header.Get("X-HTTP-Method-Override")
+		if method == "" {
+			method = r.URL.Query().Get("_method")
+		}
+		if method == "" && isFormRequest(r) {
+			var err error
+			if method, err = peekFormValue(w, r, "_method"); err != nil {
+				writeAPIError(w, r, decodeError(err))
+				return
+			}
+		}
+		if method == "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		method = strings.ToUpper(strings.TrimSpace(method))
+		if !overridableMethods[method] {
+			writeError(w, r, http.StatusBadRequest, "method_override_invalid")
+			return
+		}
+		r.Method = method
+		next.ServeHTTP(w, r)
+	})
+}
+
+// isFormRequest reports whether r carries a URL-encoded form body.
+func isFormRequest(r *http.Request) bool {
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	return mediaType == "application/x-www-form-urlencoded"
+}
+
+// peekFormValue returns a field of r's URL-encoded body without consuming
+// the body, so handlers still see the original bytes; a body that is not a
+// form has no fields. A body longer than the request body limit is not read
+// past the limit, and fails with an *http.MaxBytesError.
+func peekFormValue(w http.ResponseWriter, r *http.Request, key string) (string, error) {
+	data, err := io.ReadAll(http.MaxBytesReader(w, r.Body, bodyLimit(r, maxBodyBytes)))
+	r.Body = io.NopCloser(bytes.NewReader(data))
+	if err != nil {
+		return "", err
+	}
+	values, err := url.ParseQuery(string(data))
+	if err != nil {
+		return "", nil
+	}
+	return values.Get(key), nil
+}
@@ -0,0 +1,65 @@
+package booksapi
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+)
+
+// TestMethodOverride checks that POST requests overriding their method by
+// header, query parameter, or form field update and delete books when
+// overrides are allowed, and only then.
+func TestMethodOverride(t *testing.T) {
+	h := newTestServer(t)
+	mustCreateBook(t, h, map[string]any{"title": "Dune"})
+	mustCreateBook(t, h, map[string]any{"title": "Emma"})
+	rec := serve(t, h, http.MethodPost, "/books/1", map[string]any{"title": "Dune Messiah"}, "X-HTTP-Method-Override", "PUT")
+	wantCode(t, rec, http.StatusMethodNotAllowed)
+
+	h = newTestServer(t, WithMethodOverride(true))
+	mustCreateBook(t, h, map[string]any{"title": "Dune", "price": 7})
+	mustCreateBook(t, h, map[string]any{"title": "Emma"})
+
+	rec = serve(t, h, http.MethodPost, "/books/1", map[string]any{"title": "Dune Messiah"}, "X-HTTP-Method-Override", "put")
+	wantCode(t, rec, http.StatusOK)
+	rec = serve(t, h, http.MethodPost, "/books/1?_method=PATCH", map[string]any{"price": 8})
+	wantCode(t, rec, http.StatusOK)
+	if book, _ := store.Get(1); book.Title != "Dune Messiah" || book.Price != 8 {
+		t.Errorf("book after the overridden PUT and PATCH %+v", book)
+	}
+
+	rec = serve(t, h, http.MethodPost, "/books/2", "_method=DELETE", "Content-Type", "application/x-www-form-urlencoded")
+	wantCode(t, rec, http.StatusNoContent)
+	wantCode(t, serve(t, h, http.MethodGet, "/books/2", nil), http.StatusNotFound)
+
+	for _, method := range []string{"GET", "POST", "TRACE", "CONNECT"} {
+		rec := serve(t, h, http.MethodPost, "/books/1", nil, "X-HTTP-Method-Override", method)
+		wantCode(t, rec, http.StatusBadRequest)
+		if got := errorCode(t, rec); got != "method_override_invalid" {
+			t.Errorf("override to %s: error code %q, want method_override_invalid", method, got)
+		}
+	}
+	rec = serve(t, h, http.MethodPost, "/books/1", "_method=HEAD", "Content-Type", "application/x-www-form-urlencoded")
+	wantCode(t, rec, http.StatusBadRequest)
+	if _, found := store.Get(1); !found {
+		t.Error("book 1 deleted by a refused override")
+	}
+}
+
+// TestMethodOverrideFormTooLarge checks that a form body is only read up
+// to the body limit for its _method field.
+func TestMethodOverrideFormTooLarge(t *testing.T) {
+	h := newTestServer(t, WithMethodOverride(true))
+	setForTest(t, &maxBodyBytes, 64)
+	mustCreateBook(t, h, map[string]any{"title": "Dune"})
+
+	body := "note=" + strings.Repeat("x", 100) + "&_method=DELETE"
+	rec := serve(t, h, http.MethodPost, "/books/1", body, "Content-Type", "application/x-www-form-urlencoded")
+	wantCode(t, rec, http.StatusRequestEntityTooLarge)
+	if got := decode[errorBody](t, rec).Error; got.Code != "request_too_large" || got.Params["max"] != "64" {
+		t.Errorf("error %s %v, want request_too_large at 64", got.Code, got.Params)
+	}
+	if _, found := store.Get(1); !found {
+		t.Error("book 1 deleted by an oversized form")
+	}
+}
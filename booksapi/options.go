@@ -0,0 +1,202 @@
+// Package booksapi serves the books API: a catalog of books with their
+// shelves, publishers, series, imports, and exports. Main runs it as a
+// server of its own, configured by command-line flags; New returns its
+// handler, configured by options, to mount inside another program:
+//
+//	mux.Handle("/api/books/", booksapi.New(booksapi.WithBasePath("/api/books")))
+//
+// The catalog and the settings are package state, so a program serves a
+// single books API: New may be called once.
+package booksapi
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+	"time"
+)
+
+// logger receives the log lines of the API: errors, warnings, and debugging
+// details. It is the standard logger unless New is given another.
+var logger = log.Default()
+
+// basePath is the path the API is served under, without a trailing slash,
+// or "" at the root. Requests under it are served with it stripped from
+// their path, and the links and Location headers the API sends include it.
+// It is configured by a flag in Main or an option of New.
+var basePath string
+
+// built records that New has returned its handler. The options are kept in
+// package state, which a second New would change under the first handler.
+var built bool
+
+// Option configures the handler returned by New.
+type Option func(*options)
+
+// options are the settings New applies, starting from the package's.
+type options struct {
+	store          BookStore
+	ids            IDGenerator
+	logger         *log.Logger
+	basePath       string
+	tokens         []apiToken
+	methodOverride bool
+	cacheEntries   int
+	maxConcurrent  int
+	requestTimeout time.Duration
+}
+
+// New returns the HTTP handler serving the whole API as opts configure it,
+// for a program to mount in its own server. Settings no option names keep
+// the defaults of the flags of Main. The store is wrapped to track changes
+// and price drops, as Main's is; without WithStore the books are kept in
+// memory. New panics on an invalid option, or when called a second time,
+// which are programming errors.
+func New(opts ...Option) http.Handler {
+	if built {
+		panic("booksapi: New called twice; the package serves a single API")
+	}
+	built = true
+	o := options{
+		logger:         logger,
+		basePath:       basePath,
+		methodOverride: allowMethodOverride,
+		cacheEntries:   cacheMaxEntries,
+		maxConcurrent:  maxConcurrent,
+		requestTimeout: requestTimeout,
+	}
+	for _, opt := range opts {
+		opt(&o)
+	}
+
+	switch {
+	case o.store != nil:
+		store = trackPriceDrops(trackChanges(o.store))
+	case o.ids != nil:
+		store = trackPriceDrops(trackChanges(newMemoryStore(o.ids)))
+	}
+	logger = o.logger
+	basePath = o.basePath
+	apiTokens = append(apiTokens, o.tokens...)
+	fixedTokens = append(fixedTokens, o.tokens...)
+	requireTokens = requireTokens || len(o.tokens) > 0
+	allowMethodOverride = o.methodOverride
+	cacheMaxEntries = o.cacheEntries
+	maxConcurrent = o.maxConcurrent
+	requestTimeout = o.requestTimeout
+	configBase = *flagConfig()
+	liveConfig.Store(flagConfig())
+	return NewServer()
+}
+
+// WithStore keeps the books in s rather than in memory.
+func WithStore(s BookStore) Option {
+	return func(o *options) { o.store = s }
+}
+
+// WithIDGenerator has the books kept in memory, when WithStore is not
+// given, take their IDs from ids rather than counting up from 1.
+func WithIDGenerator(ids IDGenerator) Option {
+	return func(o *options) { o.ids = ids }
+}
+
+// WithLogger sends the log lines of the API to l rather than to the
+// standard logger.
+func WithLogger(l *log.Logger) Option {
+	return func(o *options) { o.logger = l }
+}
+
+// WithBasePath serves the API under path, such as "/api/books", where the
+// enclosing mux routes its subtree: requests elsewhere get 404, and the
+// links and Location headers the API sends start with path.
+func WithBasePath(path string) Option {
+	return func(o *options) { o.basePath = normalizeBasePath(path) }
+}
+
+// WithToken accepts the bearer token secret with scopes, each of "read",
+// "write", and "admin". Once any token is given, every request must send
+// one. It panics on an unknown scope.
+func WithToken(secret string, scopes ...string) Option {
+	for _, scope := range scopes {
+		if !slices.Contains(scopeOrder, scope) {
+			panic(fmt.Sprintf("booksapi: unknown scope %q", scope))
+		}
+	}
+	return func(o *options) {
+		o.tokens = append(o.tokens, apiToken{secret: secret, scopes: slices.Clone(scopes)})
+	}
+}
+
+// WithMethodOverride lets POST requests override their method with
+// X-HTTP-Method-Override or _method, as -allow-method-override does.
+func WithMethodOverride(enabled bool) Option {
+	return func(o *options) { o.methodOverride = enabled }
+}
+
+// WithResponseCache caches up to entries book responses; zero disables the
+// cache, as -cache-entries=0 does.
+func WithResponseCache(entries int) Option {
+	return func(o *options) { o.cacheEntries = entries }
+}
+
+// WithConcurrencyLimit handles at most n requests at once, as
+// -max-concurrent does; zero means unlimited.
+func WithConcurrencyLimit(n int) Option {
+	return func(o *options) { o.maxConcurrent = n }
+}
+
+// WithRequestTimeout answers requests still running after d with 504, as
+// -request-timeout does; zero means no timeout.
+func WithRequestTimeout(d time.Duration) Option {
+	return func(o *options) { o.requestTimeout = d }
+}
+
+// normalizeBasePath returns path with one leading slash and no trailing
+// one, or "" for the root.
+func normalizeBasePath(path string) string {
+	path = strings.Trim(path, "/")
+	if path == "" {
+		return ""
+	}
+	return "/" + path
+}
+
+// stripBasePath serves the requests under basePath with it removed from
+// their path, as http.StripPrefix does, and answers the others 404.
+func stripBasePath(next http.Handler) http.Handler {
+	if basePath == "" {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		rest, ok := strings.CutPrefix(r.URL.Path, basePath)
+		if !ok || (rest != "" && rest[0] != '/') {
+			notFoundHandler(w, r)
+			return
+		}
+		if rest == "" {
+			rest = "/"
+		}
+		r2 := new(http.Request)
+		*r2 = *r
+		r2.URL = new(url.URL)
+		*r2.URL = *r.URL
+		r2.URL.Path = rest
+		r2.URL.RawPath = strings.TrimPrefix(r.URL.RawPath, basePath)
+		next.ServeHTTP(w, r2)
+	})
+}
+
+// apiPath returns the path of an API resource, such as "/books/1", as
+// clients reach it: under basePath.
+func apiPath(path string) string {
+	return basePath + path
+}
+
+// setLocation points the Location header of a response at the resource
+// with the given API path.
+func setLocation(w http.ResponseWriter, path string) {
+	w.Header().Set("Location", apiPath(path))
+}
@@ -0,0 +1,81 @@
+package booksapi
+
+import (
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+// TestMountedUnderPrefix mounts the API under a prefix of another mux, as
+// the package documentation shows, and checks that it is served there with
+// links and Location headers under the prefix.
+func TestMountedUnderPrefix(t *testing.T) {
+	api := newTestServer(t, WithBasePath("/api/books/"))
+	mux := http.NewServeMux()
+	mux.Handle("/api/books/", api)
+	mux.HandleFunc("/healthz", func(w http.ResponseWriter, r *http.Request) { io.WriteString(w, "ok") })
+
+	rec := serve(t, mux, http.MethodPost, "/api/books/books", map[string]any{"title": "Dune", "author": "Frank Herbert"})
+	wantCode(t, rec, http.StatusCreated)
+	if got := rec.Header().Get("Location"); got != "/api/books/books/1" {
+		t.Errorf("Location %q, want /api/books/books/1", got)
+	}
+	wantCode(t, serve(t, mux, http.MethodPost, "/api/books/books", map[string]any{"title": "Emma"}), http.StatusCreated)
+
+	rec = serve(t, mux, http.MethodGet, "/api/books/books/1", nil)
+	wantCode(t, rec, http.StatusOK)
+	if got := decode[Book](t, rec).Title; got != "Dune" {
+		t.Errorf("GET: title %q, want Dune", got)
+	}
+	rec = serve(t, mux, http.MethodPut, "/api/books/books/1", map[string]any{"title": "Dune Messiah"})
+	wantCode(t, rec, http.StatusOK)
+	rec = serve(t, mux, http.MethodPatch, "/api/books/books/1", map[string]any{"price": 8})
+	wantCode(t, rec, http.StatusOK)
+	if got := decode[Book](t, rec); got.Title != "Dune Messiah" || got.Price != 8 {
+		t.Errorf("after PUT and PATCH: %+v", got)
+	}
+
+	rec = serve(t, mux, http.MethodGet, "/api/books/books?limit=1", nil)
+	wantCode(t, rec, http.StatusOK)
+	if link := rec.Header().Get("Link"); !strings.Contains(link, "/api/books/books?") {
+		t.Errorf("Link %q, want links under /api/books", link)
+	}
+
+	wantCode(t, serve(t, mux, http.MethodDelete, "/api/books/books/1", nil), http.StatusNoContent)
+	wantCode(t, serve(t, mux, http.MethodGet, "/api/books/books/1", nil), http.StatusNotFound)
+
+	// The rest of the mux is left alone, and the API answers nothing
+	// outside its prefix.
+	wantCode(t, serve(t, mux, http.MethodGet, "/healthz", nil), http.StatusOK)
+	wantCode(t, serve(t, api, http.MethodGet, "/books", nil), http.StatusNotFound)
+	wantCode(t, serve(t, api, http.MethodGet, "/api/booksx/books", nil), http.StatusNotFound)
+}
+
+// TestWithToken checks that the tokens given to New are needed on every
+// request, each for the scopes it was given.
+func TestWithToken(t *testing.T) {
+	h := newTestServer(t, WithToken("r1", "read"), WithToken("w1", "write"))
+	write := []string{"Authorization", "Bearer w1"}
+	read := []string{"Authorization", "Bearer r1"}
+
+	wantCode(t, serve(t, h, http.MethodPost, "/books", map[string]any{"title": "Dune"}), http.StatusUnauthorized)
+	wantCode(t, serve(t, h, http.MethodPost, "/books", map[string]any{"title": "Dune"}, read...), http.StatusForbidden)
+	wantCode(t, serve(t, h, http.MethodPost, "/books", map[string]any{"title": "Dune"}, write...), http.StatusCreated)
+	wantCode(t, serve(t, h, http.MethodGet, "/books/1", nil), http.StatusUnauthorized)
+	wantCode(t, serve(t, h, http.MethodGet, "/books/1", nil, read...), http.StatusOK)
+	wantCode(t, serve(t, h, http.MethodGet, "/admin/data-quality", nil, write...), http.StatusForbidden)
+}
+
+// TestNewTwice checks that a second New panics rather than reconfiguring
+// the handler the first returned.
+func TestNewTwice(t *testing.T) {
+	h := newTestServer(t, WithBasePath("/api"))
+	defer func() {
+		if recover() == nil {
+			t.Error("second New did not panic")
+		}
+		wantCode(t, serve(t, h, http.MethodGet, "/api/books", nil), http.StatusOK)
+	}()
+	New(WithBasePath("/other"))
+}
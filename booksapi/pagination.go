@@ -0,0 +1,201 @@
+package booksapi
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+// pageLimitHeader carries the limit a paginated listing was served with,
+// after defaults and clamping, so clients can tell a reduced limit from the
+// one they asked for. It is left out of listings served without a limit.
+const pageLimitHeader = "Page-Limit"
+
+// PagingPolicy sets the page sizes of paginated listings: Default is the
+// limit of a request that gives none, and Max the largest limit accepted,
+// larger ones being reduced to it with a warning. Zero Default means no
+// limit and zero Max no maximum.
+//
+// defaultPaging, configured by flags in Main and changed by reloads,
+// applies to every listing. An endpoint may override it with a policy of
+// its own, whose zero fields keep the global values; its Max is still
+// capped by the global one, so lowering the flag lowers every endpoint's
+// maximum. The zero PagingPolicy follows the global one entirely.
+type PagingPolicy struct {
+	Default int
+	Max     int
+}
+
+// defaultPaging is the paging policy of every paginated listing that does
+// not override it, as the flags of Main give it; a reload may replace it
+// in the runtime configuration.
+var defaultPaging = PagingPolicy{Max: 1000}
+
+// searchPaging caps search results lower than other listings, since every
+// page is ranked anew.
+var searchPaging = PagingPolicy{Max: 100}
+
+// resolve returns p with the global policy filled in for its zero fields,
+// its Max capped by the global Max, and its Default by its Max.
+func (p PagingPolicy) resolve() PagingPolicy {
+	global := currentConfig().paging
+	if p.Default == 0 {
+		p.Default = global.Default
+	}
+	if p.Max == 0 || (global.Max > 0 && p.Max > global.Max) {
+		p.Max = global.Max
+	}
+	if p.Max > 0 && p.Default > p.Max {
+		p.Default = p.Max
+	}
+	return p
+}
+
+// limit returns the limit of a listing under p: the limit query parameter,
+// reduced to Max with a warning, or Default without one. An explicit
+// limit=0 lists every item, as warnUnpaginated reports.
+func (p PagingPolicy) limit(r *http.Request, q queryValues) int {
+	p = p.resolve()
+	if !q.Has("limit") {
+		return p.Default
+	}
+	limit := q.Int("limit")
+	if p.Max > 0 && limit > p.Max {
+		addWarning(r, "limit_clamped", fmt.Sprintf("limit %d exceeds the maximum page size; %d used instead", limit, p.Max))
+		return p.Max
+	}
+	return limit
+}
+
+// paginationParams declares the limit and offset query parameters of
+// paginated listings. A limit of zero means no limit.
+var paginationParams = []queryParam{
+	{name: "limit", kind: intParam},
+	{name: "offset", kind: intParam},
+}
+
+// trustProxy makes page links use the scheme and host of the
+// X-Forwarded-Proto and X-Forwarded-Host headers set by a reverse proxy. It
+// is configured by a flag in Main.
+var trustProxy bool
+
+// paginateQuery returns the page of items selected by the limit and offset
+// query parameters under policy, linking to the neighbouring pages in a
+// Link header and echoing the limit in pageLimitHeader. A limit above the
+// maximum page size is reduced to it, and listing more items than it
+// without a limit is allowed; both are reported to the client with a
+// warning.
+func paginateQuery[T any](w http.ResponseWriter, r *http.Request, q queryValues, policy PagingPolicy, items []T) []T {
+	limit, offset := policy.limit(r, q), q.Int("offset")
+	page := paginate(items, limit, offset)
+	setPageLinks(w, r, len(items), limit, offset)
+	setPageLimit(w, limit)
+	warnUnpaginated(r, policy, limit, len(page))
+	return page
+}
+
+// setPageLimit echoes the limit of a paginated listing in pageLimitHeader.
+func setPageLimit(w http.ResponseWriter, limit int) {
+	if limit > 0 {
+		w.Header().Set(pageLimitHeader, strconv.Itoa(limit))
+	}
+}
+
+// setPageLinks sets an RFC 8288 Link header on the response to a listing of
+// total items paged by limit and offset, linking to its first, last,
+// previous, and next pages. The links keep the request's other query
+// parameters. There are no links without a limit; prev is left out on the
+// first page and next on the last.
+func setPageLinks(w http.ResponseWriter, r *http.Request, total, limit, offset int) {
+	if limit <= 0 {
+		return
+	}
+	last := 0
+	if total > 0 {
+		last = (total - 1) / limit * limit
+	}
+
+	var links []string
+	add := func(rel string, offset int) {
+		query := r.URL.Query()
+		query.Set("limit", strconv.Itoa(limit))
+		query.Set("offset", strconv.Itoa(offset))
+		links = append(links, pageLink(r, query, rel))
+	}
+	if offset+limit < total {
+		add("next", offset+limit)
+	}
+	if offset > 0 {
+		add("prev", max(offset-limit, 0))
+	}
+	add("first", 0)
+	add("last", last)
+	w.Header().Set("Link", strings.Join(links, ", "))
+}
+
+// setCursorLinks sets the Link header on the response to a page of a
+// listing paged by cursor, linking to the next page through the cursor
+// next, unless it is empty, and to the first page.
+func setCursorLinks(w http.ResponseWriter, r *http.Request, next string) {
+	var links []string
+	query := r.URL.Query()
+	if next != "" {
+		query.Set("cursor", next)
+		links = append(links, pageLink(r, query, "next"))
+	}
+	query.Del("cursor")
+	links = append(links, pageLink(r, query, "first"))
+	w.Header().Set("Link", strings.Join(links, ", "))
+}
+
+// pageLink returns a link with relation rel to the request's path with the
+// given query.
+func pageLink(r *http.Request, query url.Values, rel string) string {
+	scheme, host := "http", r.Host
+	if r.TLS != nil {
+		scheme = "https"
+	}
+	if trustProxy {
+		if proto := forwardedValue(r, "X-Forwarded-Proto"); proto != "" {
+			scheme = proto
+		}
+		if fwdHost := forwardedValue(r, "X-Forwarded-Host"); fwdHost != "" {
+			host = fwdHost
+		}
+	}
+	u := url.URL{Scheme: scheme, Host: host, Path: apiPath(r.URL.Path), RawQuery: query.Encode()}
+	return fmt.Sprintf("<%s>; rel=%q", u.String(), rel)
+}
+
+// forwardedValue returns the first value of a proxy header, which lists one
+// value per proxy starting with the one the client connected to.
+func forwardedValue(r *http.Request, header string) string {
+	value, _, _ := strings.Cut(r.Header.Get(header), ",")
+	return strings.TrimSpace(value)
+}
+
+// warnUnpaginated warns the client when a listing without a limit returned
+// more items than the maximum page size of policy.
+func warnUnpaginated(r *http.Request, policy PagingPolicy, limit, n int) {
+	if maxPageSize := policy.resolve().Max; maxPageSize > 0 && limit == 0 && n > maxPageSize {
+		addWarning(r, "unpaginated", fmt.Sprintf("listing %d items without a limit; pass limit and offset to page through them", n))
+	}
+}
+
+// paginate returns the page of items selected by limit and offset. The page
+// is never nil, so an empty listing encodes as [] rather than null.
+func paginate[T any](items []T, limit, offset int) []T {
+	if items == nil {
+		items = []T{}
+	}
+	if offset >= len(items) {
+		return items[:0]
+	}
+	items = items[offset:]
+	if limit > 0 && limit < len(items) {
+		items = items[:limit]
+	}
+	return items
+}
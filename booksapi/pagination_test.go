@@ -0,0 +1,267 @@
+package booksapi
+
+import (
+	"expvar"
+	"fmt"
+	"net/http"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// warningCount returns the number of warnings of kind counted so far.
+func warningCount(kind string) int64 {
+	if v, ok := warningsIssued.Get(kind).(*expvar.Int); ok {
+		return v.Value()
+	}
+	return 0
+}
+
+// TestSoftLimits checks that a limit above the maximum page size is
+// clamped with a warning, as is a listing of more items than it without a
+// limit, and that other listings get no warning.
+func TestSoftLimits(t *testing.T) {
+	h := newTestServer(t)
+	setForTest(t, &defaultPaging, PagingPolicy{Max: 3})
+	liveConfig.Store(flagConfig())
+	for _, title := range []string{"Dune", "Emma", "Beloved", "Jazz", "Kokoro"} {
+		mustCreateBook(t, h, map[string]any{"title": title})
+	}
+
+	clamped := warningCount("limit_clamped")
+	rec := serve(t, h, http.MethodGet, "/books?limit=10", nil)
+	wantCode(t, rec, http.StatusOK)
+	if books := decode[[]Book](t, rec); len(books) != 3 {
+		t.Errorf("%d books with limit=10, want the maximum of 3", len(books))
+	}
+	if got := rec.Header().Get(pageLimitHeader); got != "3" {
+		t.Errorf("%s %q, want 3", pageLimitHeader, got)
+	}
+	want := `299 - "limit 10 exceeds the maximum page size; 3 used instead"`
+	if got := rec.Header().Values("Warning"); len(got) != 1 || got[0] != want {
+		t.Errorf("Warning %q, want %q", got, want)
+	}
+	if got := warningCount("limit_clamped"); got != clamped+1 {
+		t.Errorf("limit_clamped counted %d times, want once", got-clamped)
+	}
+
+	// A response served from the cache keeps its warning, but is not
+	// counted again.
+	rec = serve(t, h, http.MethodGet, "/books?limit=10", nil)
+	if got := rec.Header().Values("Warning"); len(got) != 1 || got[0] != want {
+		t.Errorf("cached response: Warning %q, want %q", got, want)
+	}
+	if got := warningCount("limit_clamped"); got != clamped+1 {
+		t.Errorf("limit_clamped counted %d times after a cache hit, want once", got-clamped)
+	}
+
+	rec = serve(t, h, http.MethodGet, "/books?limit=0", nil)
+	wantCode(t, rec, http.StatusOK)
+	if books := decode[[]Book](t, rec); len(books) != 5 {
+		t.Errorf("%d books with limit=0, want all 5", len(books))
+	}
+	if got := rec.Header().Get("Warning"); !strings.Contains(got, "listing 5 items without a limit") {
+		t.Errorf("Warning %q, want one about the missing limit", got)
+	}
+
+	for _, path := range []string{"/books?limit=3", "/books?limit=2&offset=3", "/books/1", "/shelves?limit=3"} {
+		if got := serve(t, h, http.MethodGet, path, nil).Header().Values("Warning"); len(got) != 0 {
+			t.Errorf("GET %s: Warning %q", path, got)
+		}
+	}
+	if got := serve(t, h, http.MethodGet, "/shelves?limit=4", nil).Header().Get("Warning"); !strings.Contains(got, "limit 4 exceeds") {
+		t.Errorf("GET /shelves?limit=4: Warning %q, want the limit clamped", got)
+	}
+}
+
+// pageLinks parses a Link header into the URL of each relation.
+func pageLinks(t *testing.T, header string) map[string]*url.URL {
+	t.Helper()
+	links := make(map[string]*url.URL)
+	if header == "" {
+		return links
+	}
+	for _, link := range strings.Split(header, ", ") {
+		target, params, ok := strings.Cut(link, ">; ")
+		rel, found := strings.CutPrefix(params, "rel=")
+		if !ok || !found || !strings.HasPrefix(target, "<") {
+			t.Fatalf("malformed link %q in %q", link, header)
+		}
+		u, err := url.Parse(strings.TrimPrefix(target, "<"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		links[strings.Trim(rel, `"`)] = u
+	}
+	return links
+}
+
+// TestPageLinks checks the links of the first, a middle, and the last page
+// of a filtered and sorted listing.
+func TestPageLinks(t *testing.T) {
+	h := newTestServer(t)
+	for i := range 7 {
+		mustCreateBook(t, h, map[string]any{"title": fmt.Sprint("Volume ", i), "author": "Herbert"})
+	}
+	mustCreateBook(t, h, map[string]any{"title": "Emma", "author": "Austen"})
+
+	tests := []struct {
+		offset int
+		want   map[string]int // relation -> offset it links to
+	}{
+		{0, map[string]int{"next": 3, "first": 0, "last": 6}},
+		{3, map[string]int{"next": 6, "prev": 0, "first": 0, "last": 6}},
+		{4, map[string]int{"prev": 1, "first": 0, "last": 6}}, // ends on the last book
+		{6, map[string]int{"prev": 3, "first": 0, "last": 6}},
+	}
+	for _, tt := range tests {
+		path := fmt.Sprintf("/books?author=Herbert&sort=title&order=desc&limit=3&offset=%d", tt.offset)
+		rec := serve(t, h, http.MethodGet, path, nil)
+		wantCode(t, rec, http.StatusOK)
+		links := pageLinks(t, rec.Header().Get("Link"))
+		if len(links) != len(tt.want) {
+			t.Errorf("offset %d: links %q, want %v", tt.offset, rec.Header().Get("Link"), tt.want)
+		}
+		for rel, offset := range tt.want {
+			u, ok := links[rel]
+			if !ok {
+				t.Errorf("offset %d: no %s link", tt.offset, rel)
+				continue
+			}
+			q := u.Query()
+			if u.Scheme != "http" || u.Host != "example.com" || u.Path != "/books" {
+				t.Errorf("offset %d: %s link %s, want http://example.com/books", tt.offset, rel, u)
+			}
+			if q.Get("offset") != strconv.Itoa(offset) || q.Get("limit") != "3" || q.Get("author") != "Herbert" || q.Get("sort") != "title" || q.Get("order") != "desc" {
+				t.Errorf("offset %d: %s link %s, want offset %d keeping the filter and sort", tt.offset, rel, u, offset)
+			}
+		}
+	}
+
+	// Following next pages through every matching book once.
+	var seen []string
+	for next := "/books?author=Herbert&limit=3"; next != ""; {
+		rec := serve(t, h, http.MethodGet, next, nil)
+		for _, book := range decode[[]Book](t, rec) {
+			seen = append(seen, book.Title)
+		}
+		next = ""
+		if u, ok := pageLinks(t, rec.Header().Get("Link"))["next"]; ok {
+			next = u.RequestURI()
+		}
+	}
+	if len(seen) != 7 || slices.Contains(seen, "Emma") {
+		t.Errorf("pages held %q, want the 7 volumes", seen)
+	}
+
+	if got := serve(t, h, http.MethodGet, "/books?author=Herbert", nil).Header().Get("Link"); got != "" {
+		t.Errorf("Link %q without a limit", got)
+	}
+}
+
+// TestPageLinksBehindProxy checks that the links take their scheme and
+// host from the proxy headers only with -trust-proxy.
+func TestPageLinksBehindProxy(t *testing.T) {
+	h := newTestServer(t)
+	mustCreateBook(t, h, map[string]any{"title": "Dune"})
+	proxied := []string{"X-Forwarded-Proto", "https", "X-Forwarded-Host", "books.example.org, proxy.internal"}
+
+	first := func() *url.URL {
+		t.Helper()
+		rec := serve(t, h, http.MethodGet, "/books?limit=1", nil, proxied...)
+		wantCode(t, rec, http.StatusOK)
+		return pageLinks(t, rec.Header().Get("Link"))["first"]
+	}
+	if u := first(); u.Scheme != "http" || u.Host != "example.com" {
+		t.Errorf("untrusted proxy: first link %s, want http://example.com", u)
+	}
+	setForTest(t, &trustProxy, true)
+	if u := first(); u.Scheme != "https" || u.Host != "books.example.org" {
+		t.Errorf("trusted proxy: first link %s, want https://books.example.org", u)
+	}
+}
+
+// setPaging makes policy the global paging policy, as flags would.
+func setPaging(t *testing.T, policy PagingPolicy) {
+	t.Helper()
+	setForTest(t, &defaultPaging, policy)
+	liveConfig.Store(flagConfig())
+	invalidateResponseCache()
+}
+
+// TestPagingPolicyResolve checks how an endpoint's policy is completed by
+// the global one.
+func TestPagingPolicyResolve(t *testing.T) {
+	newTestServer(t)
+	tests := []struct {
+		global, endpoint, want PagingPolicy
+	}{
+		{PagingPolicy{Default: 20, Max: 1000}, PagingPolicy{}, PagingPolicy{Default: 20, Max: 1000}},
+		{PagingPolicy{Default: 20, Max: 1000}, PagingPolicy{Max: 100}, PagingPolicy{Default: 20, Max: 100}},
+		{PagingPolicy{Default: 20, Max: 1000}, PagingPolicy{Default: 50, Max: 30}, PagingPolicy{Default: 30, Max: 30}},
+		{PagingPolicy{Default: 20, Max: 50}, PagingPolicy{Max: 100}, PagingPolicy{Default: 20, Max: 50}},
+		{PagingPolicy{Default: 200, Max: 50}, PagingPolicy{}, PagingPolicy{Default: 50, Max: 50}},
+		{PagingPolicy{}, PagingPolicy{Default: 10}, PagingPolicy{Default: 10}},
+		{PagingPolicy{}, PagingPolicy{}, PagingPolicy{}},
+	}
+	for _, tt := range tests {
+		setPaging(t, tt.global)
+		if got := tt.endpoint.resolve(); got != tt.want {
+			t.Errorf("%+v under %+v resolves to %+v, want %+v", tt.endpoint, tt.global, got, tt.want)
+		}
+	}
+}
+
+// TestPagingPolicy checks through the API that the global policy sets the
+// default and maximum limits of every listing, that an endpoint overriding
+// it keeps its own within the global maximum, and that the limit served is
+// echoed in pageLimitHeader.
+func TestPagingPolicy(t *testing.T) {
+	h := newTestServer(t)
+	for i := range 5 {
+		mustCreateBook(t, h, map[string]any{"title": fmt.Sprint("Book ", i)})
+	}
+	list := func(path string, wantBooks int, wantLimit string, clamped bool) {
+		t.Helper()
+		rec := serve(t, h, http.MethodGet, path, nil)
+		wantCode(t, rec, http.StatusOK)
+		if got := len(decode[[]Book](t, rec)); got != wantBooks {
+			t.Errorf("GET %s: %d books, want %d", path, got, wantBooks)
+		}
+		if got := rec.Header().Get(pageLimitHeader); got != wantLimit {
+			t.Errorf("GET %s: %s %q, want %q", path, pageLimitHeader, got, wantLimit)
+		}
+		if got := strings.Contains(rec.Header().Get("Warning"), "exceeds the maximum page size"); got != clamped {
+			t.Errorf("GET %s: Warning %q", path, rec.Header().Get("Warning"))
+		}
+	}
+
+	// The global policy applies to the listing and to search alike.
+	setPaging(t, PagingPolicy{Default: 2, Max: 4})
+	list("/books", 2, "2", false)
+	list("/books/search?q=book", 2, "2", false)
+	list("/books?limit=3", 3, "3", false)
+	list("/books?limit=10", 4, "4", true)
+	list("/books/search?q=book&limit=10", 4, "4", true)
+
+	// Search overrides the policy; the listing keeps the global one.
+	setForTest(t, &searchPaging, PagingPolicy{Default: 3, Max: 4})
+	setPaging(t, PagingPolicy{Default: 1, Max: 1000})
+	list("/books", 1, "1", false)
+	list("/books?limit=10", 5, "10", false)
+	list("/books/search?q=book", 3, "3", false)
+	list("/books/search?q=book&limit=10", 4, "4", true)
+
+	// Lowering the global maximum lowers the endpoint's too.
+	setPaging(t, PagingPolicy{Max: 2})
+	list("/books/search?q=book&limit=10", 2, "2", true)
+	list("/books/search?q=book", 2, "2", false)
+
+	// Without a default there is no limit to echo.
+	setForTest(t, &searchPaging, PagingPolicy{})
+	setPaging(t, PagingPolicy{})
+	list("/books", 5, "", false)
+	list("/books/search?q=book", 5, "", false)
+}
@@ -0,0 +1,188 @@
+package booksapi
+
+import (
+	"bytes"
+	"encoding/json"
+	"mime"
+	"net/http"
+	"reflect"
+	"strings"
+)
+
+// Media types accepted by PATCH /books/{id}.
+const (
+	mergePatchType = "application/merge-patch+json"
+	jsonPatchType  = "application/json-patch+json"
+)
+
+// requiredFields lists the book fields a patch may not remove.
+var requiredFields = []string{"title"}
+
+// patchBook applies a partial update to a book, given either as a JSON
+// Merge Patch or a JSON Patch document. The patch is applied to a copy of the
+// book, which is only stored once it passes validation. An If-Match header
+// makes the patch conditional on the book's current version.
+func patchBook(w http.ResponseWriter, r *http.Request, id int) {
+	var apply func(*Book) error
+
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	switch mediaType {
+	case mergePatchType, "application/json", "":
+		var patch map[string]any
+		if err := decodeJSON(w, r, &patch); err != nil {
+			writeAPIError(w, r, err)
+			return
+		}
+		if err := resolveAliases(r, patch, reflect.TypeFor[Book]()); err != nil {
+			writeAPIError(w, r, err)
+			return
+		}
+		apply = func(book *Book) error { return applyMergePatch(book, patch) }
+	case jsonPatchType:
+		var ops []patchOperation
+		if err := decodeJSON(w, r, &ops); err != nil {
+			writeAPIError(w, r, err)
+			return
+		}
+		for i := range ops {
+			ops[i].Path = resolvePathAlias(r, ops[i].Path, reflect.TypeFor[Book]())
+		}
+		apply = func(book *Book) error { return applyJSONPatch(book, ops) }
+	default:
+		writeError(w, r, http.StatusUnsupportedMediaType, "unsupported_patch_format")
+		return
+	}
+
+	defer lockBook(id)()
+
+	book, err := findBook(id)
+	if err != nil {
+		writeAPIError(w, r, err)
+		return
+	}
+	if !checkIfMatch(w, r, book) {
+		return
+	}
+	if err := checkBookLock(r, id); err != nil {
+		writeAPIError(w, r, err)
+		return
+	}
+
+	prev := book
+	book = cloneBook(prev)
+	if err := apply(&book); err != nil {
+		writeAPIError(w, r, err)
+		return
+	}
+	if err := checkFieldWrites(callerToken(r), prev, book); err != nil {
+		writeAPIError(w, r, err)
+		return
+	}
+	book.ID = id
+	touchBook(&book, prev.CreatedAt)
+	if err := validateBook(&book, ValidateUpdate); err != nil {
+		writeAPIError(w, r, err)
+		return
+	}
+	if err := checkReferences(book); err != nil {
+		writeAPIError(w, r, err)
+		return
+	}
+	defer lockCrossBook(crossBookChecks(book))()
+	if err := checkSeriesIndex(book); err != nil {
+		writeAPIError(w, r, err)
+		return
+	}
+	if err := checkEditionISBNs(book); err != nil {
+		writeAPIError(w, r, err)
+		return
+	}
+
+	if err := store.Put(book); err != nil {
+		writeAPIError(w, r, err)
+		return
+	}
+	w.Header().Set("ETag", bookETag(book))
+	writeJSON(w, http.StatusOK, renderBook(r, book))
+}
+
+// applyMergePatch applies an RFC 7386 merge patch to book. Fields present in
+// the patch are set, fields set to null are cleared, and absent fields are
+// left untouched. On failure book is left unchanged.
+func applyMergePatch(book *Book, patch map[string]any) error {
+	if _, ok := patch["id"]; ok {
+		return newAPIError(http.StatusUnprocessableEntity, "id_read_only")
+	}
+
+	current, err := toJSONObject(*book)
+	if err != nil {
+		return err
+	}
+	merged, ok := mergePatch(current, patch).(map[string]any)
+	if !ok {
+		return newAPIError(http.StatusBadRequest, "invalid_patch")
+	}
+	return fromJSONObject(merged, book)
+}
+
+// mergePatch merges patch into target following RFC 7386 and returns the
+// result.
+func mergePatch(target any, patch any) any {
+	patchObj, ok := patch.(map[string]any)
+	if !ok {
+		return patch
+	}
+	targetObj, ok := target.(map[string]any)
+	if !ok {
+		targetObj = make(map[string]any)
+	}
+	for key, value := range patchObj {
+		if value == nil {
+			delete(targetObj, key)
+			continue
+		}
+		targetObj[key] = mergePatch(targetObj[key], value)
+	}
+	return targetObj
+}
+
+// toJSONObject converts v to its generic JSON object representation.
+func toJSONObject(v any) (map[string]any, error) {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	var obj map[string]any
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	if err := dec.Decode(&obj); err != nil {
+		return nil, err
+	}
+	return obj, nil
+}
+
+// fromJSONObject decodes a patched JSON object into book, rejecting unknown
+// fields and missing required fields. On failure book is left unchanged.
+func fromJSONObject(obj map[string]any, book *Book) error {
+	for _, field := range requiredFields {
+		if obj[field] == nil {
+			return newAPIError(http.StatusUnprocessableEntity, "field_required", "field", field)
+		}
+	}
+
+	data, err := json.Marshal(obj)
+	if err != nil {
+		return newAPIError(http.StatusBadRequest, "invalid_patch")
+	}
+	var patched Book
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&patched); err != nil {
+		if field, ok := strings.CutPrefix(err.Error(), "json: unknown field "); ok {
+			return newAPIError(http.StatusUnprocessableEntity, "unknown_field", "field", strings.Trim(field, `"`))
+		}
+		return newAPIError(http.StatusUnprocessableEntity, "invalid_patch")
+	}
+	*book = patched
+	return nil
+}
@@ -0,0 +1,149 @@
+package booksapi
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+)
+
+// sendMergePatch sends patch to book 1 as a JSON Merge Patch.
+func sendMergePatch(t *testing.T, h http.Handler, patch string) *httptest.ResponseRecorder {
+	t.Helper()
+	return serve(t, h, http.MethodPatch, "/books/1", patch, "Content-Type", mergePatchType)
+}
+
+// storedBook returns book 1 as the store holds it.
+func storedBook(t *testing.T) Book {
+	t.Helper()
+	book, ok := store.Get(1)
+	if !ok {
+		t.Fatal("book 1 is not stored")
+	}
+	return book
+}
+
+// TestMergePatchNull checks that null clears an optional field and leaves
+// the others, and that null on a required field is refused with 422.
+func TestMergePatchNull(t *testing.T) {
+	h := newTestServer(t)
+	mustCreateBook(t, h, map[string]any{"title": "Dune", "author": "Frank Herbert", "description": "Spice.", "language": "en", "price": 7})
+
+	rec := sendMergePatch(t, h, `{"description": null, "language": null}`)
+	wantCode(t, rec, http.StatusOK)
+	got := storedBook(t)
+	if got.Description != "" || got.Language != "" {
+		t.Errorf("after clearing: description %q, language %q, want both empty", got.Description, got.Language)
+	}
+	if got.Title != "Dune" || got.Author != "Frank Herbert" || got.Price != 7 {
+		t.Errorf("fields the patch leaves out changed: %+v", got)
+	}
+
+	before := storedBook(t)
+	rec = sendMergePatch(t, h, `{"title": null, "author": "Someone Else"}`)
+	wantCode(t, rec, http.StatusUnprocessableEntity)
+	if got := decode[errorBody](t, rec).Error; got.Code != "field_required" || got.Params["field"] != "title" {
+		t.Errorf("error %s %v, want field_required naming title", got.Code, got.Params)
+	}
+	if got := storedBook(t); !reflect.DeepEqual(got, before) {
+		t.Errorf("book after a refused patch %+v, want %+v", got, before)
+	}
+}
+
+// TestMergePatchInvalid checks that a patch giving the book an invalid
+// value is refused and leaves the stored book as it was, fields the patch
+// set validly included.
+func TestMergePatchInvalid(t *testing.T) {
+	h := newTestServer(t)
+	mustCreateBook(t, h, map[string]any{"title": "Dune", "author": "Frank Herbert", "price": 7})
+	before := storedBook(t)
+
+	for _, patch := range []string{
+		`{"author": "Brian Herbert", "price": -1}`,
+		`{"author": "Brian Herbert", "language": "not a language"}`,
+		`{"author": "Brian Herbert", "pages": 412}`,
+		`{"id": 2}`,
+	} {
+		rec := sendMergePatch(t, h, patch)
+		wantCode(t, rec, http.StatusUnprocessableEntity)
+		if got := storedBook(t); !reflect.DeepEqual(got, before) {
+			t.Errorf("book after refused patch %s: %+v, want %+v", patch, got, before)
+		}
+	}
+}
+
+// sendJSONPatch sends ops to book 1 as a JSON Patch document.
+func sendJSONPatch(t *testing.T, h http.Handler, ops string) *httptest.ResponseRecorder {
+	t.Helper()
+	return serve(t, h, http.MethodPatch, "/books/1", ops, "Content-Type", jsonPatchType)
+}
+
+// TestJSONPatchAtomic checks that the operations of a JSON Patch document
+// are applied in order, each seeing the ones before it.
+func TestJSONPatchAtomic(t *testing.T) {
+	h := newTestServer(t)
+	mustCreateBook(t, h, map[string]any{"title": "Dune", "author": "Frank Herbert", "price": 7,
+		"editions": []any{map[string]any{"format": "paperback", "price": 9}}})
+
+	rec := sendJSONPatch(t, h, `[
+		{"op": "test", "path": "/title", "value": "Dune"},
+		{"op": "replace", "path": "/title", "value": "Dune Messiah"},
+		{"op": "add", "path": "/description", "value": "The sequel."},
+		{"op": "add", "path": "/editions/-", "value": {"format": "ebook", "price": 5}},
+		{"op": "remove", "path": "/editions/0"},
+		{"op": "test", "path": "/editions/0/format", "value": "ebook"}
+	]`)
+	wantCode(t, rec, http.StatusOK)
+	got := storedBook(t)
+	if got.Title != "Dune Messiah" || got.Description != "The sequel." || len(got.Editions) != 1 || got.Editions[0].Format != "ebook" {
+		t.Errorf("patched book %+v", got)
+	}
+}
+
+// TestJSONPatchRefused checks that a document with a failing operation is
+// refused naming the operation, and that none of the operations before it
+// is applied.
+func TestJSONPatchRefused(t *testing.T) {
+	h := newTestServer(t)
+	mustCreateBook(t, h, map[string]any{"title": "Dune", "author": "Frank Herbert", "price": 7,
+		"editions": []any{map[string]any{"format": "paperback", "price": 9}}})
+	before := storedBook(t)
+
+	tests := []struct {
+		name   string
+		ops    string
+		status int
+		code   string
+		index  string
+	}{
+		{"failing test", `[
+			{"op": "replace", "path": "/title", "value": "Dune Messiah"},
+			{"op": "add", "path": "/description", "value": "The sequel."},
+			{"op": "test", "path": "/author", "value": "Brian Herbert"},
+			{"op": "replace", "path": "/price", "value": 8}
+		]`, http.StatusConflict, "patch_test_failed", "2"},
+		{"index out of bounds", `[
+			{"op": "replace", "path": "/title", "value": "Dune Messiah"},
+			{"op": "replace", "path": "/editions/1/price", "value": 4}
+		]`, http.StatusUnprocessableEntity, "patch_index_out_of_range", "1"},
+		{"removal past the end", `[{"op": "remove", "path": "/editions/1"}]`, http.StatusUnprocessableEntity, "patch_index_out_of_range", "0"},
+		{"missing path", `[{"op": "replace", "path": "/series_index", "value": 2}]`, http.StatusUnprocessableEntity, "patch_path_not_found", "0"},
+		{"write to id", `[
+			{"op": "replace", "path": "/title", "value": "Dune Messiah"},
+			{"op": "replace", "path": "/id", "value": 2}
+		]`, http.StatusUnprocessableEntity, "patch_id_read_only", "1"},
+		{"removal of id", `[{"op": "remove", "path": "/id"}]`, http.StatusUnprocessableEntity, "patch_id_read_only", "0"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := sendJSONPatch(t, h, tt.ops)
+			wantCode(t, rec, tt.status)
+			if got := decode[errorBody](t, rec).Error; got.Code != tt.code || got.Params["index"] != tt.index {
+				t.Errorf("error %s %v, want %s at index %s", got.Code, got.Params, tt.code, tt.index)
+			}
+			if got := storedBook(t); !reflect.DeepEqual(got, before) {
+				t.Errorf("book after a refused patch %+v, want %+v", got, before)
+			}
+		})
+	}
+}
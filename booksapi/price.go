@@ -0,0 +1,104 @@
+package booksapi
+
+import (
+	"math"
+	"net/http"
+	"strconv"
+
+	"golang.org/x/text/currency"
+	"golang.org/x/text/language"
+	"golang.org/x/text/message"
+)
+
+// defaultCurrency is the ISO 4217 code of prices of books without a
+// currency. It is configured by a flag in Main.
+var defaultCurrency = "USD"
+
+// The range of valid prices, configured by flags in Main.
+var (
+	minPrice = 0.0
+	maxPrice = 1_000_000.0
+)
+
+// priceDecimals is the number of decimal places a price may have.
+const priceDecimals = 2
+
+// Price is the price of a book in its currency. It is always encoded with
+// at most priceDecimals decimal places, whatever float formatting would
+// produce, and values JSON cannot represent are encoded as 0, so prices
+// stored before they were validated still encode safely.
+type Price float64
+
+// MarshalJSON encodes the price rounded to priceDecimals decimal places.
+func (p Price) MarshalJSON() ([]byte, error) {
+	f := float64(p)
+	if math.IsNaN(f) || math.IsInf(f, 0) {
+		f = 0
+	}
+	if rounded := roundPrice(f); !math.IsInf(rounded, 0) {
+		f = rounded
+	}
+	if f == 0 {
+		f = 0 // drops the sign of negative zero
+	}
+	return strconv.AppendFloat(nil, f, 'f', -1, 64), nil
+}
+
+// roundPrice rounds f to priceDecimals decimal places.
+func roundPrice(f float64) float64 {
+	scale := math.Pow10(priceDecimals)
+	return math.Round(f*scale) / scale
+}
+
+// normalizeCurrency validates an ISO 4217 currency code and returns its
+// canonical form. An empty code is left empty.
+func normalizeCurrency(code string) (string, error) {
+	if code == "" {
+		return "", nil
+	}
+	unit, err := currency.ParseISO(code)
+	if err != nil {
+		return "", newAPIError(http.StatusUnprocessableEntity, "invalid_currency", "code", code)
+	}
+	return unit.String(), nil
+}
+
+// wantsDisplayPrice reports whether book responses to r include a formatted
+// price: when ?format_price=true is given, or when the request names a
+// locale in Accept-Language and does not opt out with ?format_price=false.
+func wantsDisplayPrice(r *http.Request) bool {
+	if on, err := strconv.ParseBool(r.URL.Query().Get("format_price")); err == nil {
+		return on
+	}
+	return r.Header.Get("Accept-Language") != ""
+}
+
+// displayPrice formats a book's price in its currency for the request's
+// preferred locale, e.g. "€ 12,99" for German. Requests without a usable
+// Accept-Language header get English formatting.
+func displayPrice(r *http.Request, book Book) string {
+	code := book.Currency
+	if code == "" {
+		code = defaultCurrency
+	}
+	unit, err := currency.ParseISO(code)
+	if err != nil {
+		return ""
+	}
+	return message.NewPrinter(priceLocale(r)).Sprint(currency.NarrowSymbol(unit.Amount(float64(book.Price))))
+}
+
+// priceLocale returns the most preferred locale of the request's
+// Accept-Language header, or English.
+func priceLocale(r *http.Request) language.Tag {
+	tags, _, err := language.ParseAcceptLanguage(r.Header.Get("Accept-Language"))
+	if err != nil {
+		return language.English
+	}
+	for _, tag := range tags {
+		if base, conf := tag.Base(); conf != language.No && base.String() != "und" {
+			return tag
+		}
+	}
+	return language.English
+}
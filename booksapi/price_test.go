@@ -0,0 +1,148 @@
+package booksapi
+
+import (
+	"encoding/json"
+	"math"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+// TestDisplayPrice checks the formatted price of books for a few locales
+// and currencies, and that it is only sent when asked for.
+func TestDisplayPrice(t *testing.T) {
+	h := newTestServer(t)
+	mustCreateBook(t, h, map[string]any{"title": "Dune", "price": 1234.5, "currency": "eur"})
+	mustCreateBook(t, h, map[string]any{"title": "Emma", "price": 12.99})
+	mustCreateBook(t, h, map[string]any{"title": "Kokoro", "price": 1500, "currency": "JPY"})
+
+	tests := []struct {
+		name   string
+		path   string
+		header []string
+		want   string // none if empty
+	}{
+		{name: "by default", path: "/books/1"},
+		{name: "format_price", path: "/books/1?format_price=true", want: "€ 1,234.50"},
+		{name: "de", path: "/books/1", header: []string{"Accept-Language", "de-DE"}, want: "€ 1.234,50"},
+		{name: "fr", path: "/books/1", header: []string{"Accept-Language", "fr;q=0.9, de;q=0.5"}, want: "€ 1\u00a0234,50"},
+		{name: "opted out", path: "/books/1?format_price=false", header: []string{"Accept-Language", "de"}},
+		{name: "unknown locale", path: "/books/1", header: []string{"Accept-Language", "x-klingon"}, want: "€ 1,234.50"},
+		{name: "malformed Accept-Language", path: "/books/1?format_price=1", header: []string{"Accept-Language", ";;;"}, want: "€ 1,234.50"},
+		{name: "default currency", path: "/books/2", header: []string{"Accept-Language", "en-US"}, want: "$ 12.99"},
+		{name: "default currency, de", path: "/books/2", header: []string{"Accept-Language", "de"}, want: "$ 12,99"},
+		{name: "no decimals", path: "/books/3", header: []string{"Accept-Language", "ja"}, want: "\uffe5 1,500"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := serve(t, h, http.MethodGet, tt.path, nil, tt.header...)
+			wantCode(t, rec, http.StatusOK)
+			got := decode[map[string]any](t, rec)
+			display, ok := got["display_price"]
+			switch {
+			case tt.want == "" && ok:
+				t.Errorf("display_price %q sent", display)
+			case tt.want != "" && display != tt.want:
+				t.Errorf("display_price %q, want %q", display, tt.want)
+			}
+		})
+	}
+
+	book := decode[Book](t, serve(t, h, http.MethodGet, "/books/1", nil, "Accept-Language", "de"))
+	if book.Price != 1234.5 || book.Currency != "EUR" {
+		t.Errorf("price %v %s, want 1234.5 EUR", book.Price, book.Currency)
+	}
+	rec := serve(t, h, http.MethodPost, "/books", map[string]any{"title": "Beloved", "currency": "EURO"})
+	wantCode(t, rec, http.StatusUnprocessableEntity)
+	if got := errorCode(t, rec); got != "invalid_currency" {
+		t.Errorf("error code %q, want invalid_currency", got)
+	}
+}
+
+// TestPriceJSON checks that prices encode with at most two decimal places,
+// and that prices no longer valid still encode.
+func TestPriceJSON(t *testing.T) {
+	tests := []struct {
+		price Price
+		want  string
+	}{
+		{12.99, "12.99"},
+		{10, "10"},
+		{0.1 + 0.2, "0.3"},
+		{19.999999999999, "20"},
+		{1.234, "1.23"},
+		{Price(math.Copysign(0, -1)), "0"},
+		{-0.001, "0"},
+		{1e308, "1" + strings.Repeat("0", 308)},
+		{Price(math.NaN()), "0"},
+		{Price(math.Inf(1)), "0"},
+		{Price(math.Inf(-1)), "0"},
+	}
+	for _, tt := range tests {
+		data, err := json.Marshal(Book{Price: tt.price})
+		if err != nil {
+			t.Errorf("price %v: %v", float64(tt.price), err)
+			continue
+		}
+		var fields map[string]json.RawMessage
+		if err := json.Unmarshal(data, &fields); err != nil {
+			t.Fatal(err)
+		}
+		if got := fields["price"]; string(got) != tt.want {
+			t.Errorf("price %v encoded as %s, want %s", float64(tt.price), got, tt.want)
+		}
+	}
+}
+
+// TestPriceRules sends prices that break the rules, and some that only
+// look like they do.
+func TestPriceRules(t *testing.T) {
+	h := newTestServer(t)
+	tests := []struct {
+		name   string
+		price  string // as sent
+		header []string
+		status int
+		code   string // for a rejected price
+		want   Price  // for an accepted one
+	}{
+		{name: "huge", price: "1e308", status: http.StatusUnprocessableEntity, code: "price_out_of_range"},
+		{name: "beyond float64", price: "1e400", status: http.StatusBadRequest, code: "invalid_field_type"},
+		{name: "above the maximum", price: "1000000.01", status: http.StatusUnprocessableEntity, code: "price_out_of_range"},
+		{name: "negative", price: "-1", status: http.StatusUnprocessableEntity, code: "price_out_of_range"},
+		{name: "too precise", price: "19.999999999999", status: http.StatusUnprocessableEntity, code: "too_many_decimals"},
+		{name: "scientific with decimals", price: "1.2345e1", status: http.StatusUnprocessableEntity, code: "too_many_decimals"},
+		{name: "scientific", price: "1.5e2", status: http.StatusCreated, want: 150},
+		{name: "negative zero", price: "-0", status: http.StatusCreated, want: 0},
+		{name: "maximum", price: "1000000", status: http.StatusCreated, want: 1_000_000},
+		{name: "string strict", price: `"9.99"`, header: []string{"Prefer", "handling=strict"}, status: http.StatusBadRequest, code: "invalid_field_type"},
+		{name: "string lenient", price: `"9.99"`, header: []string{"Prefer", "handling=lenient"}, status: http.StatusCreated, want: 9.99},
+		{name: "precise string lenient", price: `"9.999"`, header: []string{"Prefer", "handling=lenient"}, status: http.StatusUnprocessableEntity, code: "too_many_decimals"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := serve(t, h, http.MethodPost, "/books", `{"title": "Dune", "price": `+tt.price+`}`, tt.header...)
+			wantCode(t, rec, tt.status)
+			if tt.code != "" {
+				if got := errorCode(t, rec); got != tt.code {
+					t.Errorf("error code %q, want %q", got, tt.code)
+				}
+				return
+			}
+			created := decode[Book](t, rec)
+			stored, _ := store.Get(created.ID)
+			if created.Price != tt.want || stored.Price != tt.want || math.Signbit(float64(stored.Price)) {
+				t.Errorf("price %v, stored %v; want %v", float64(created.Price), float64(stored.Price), float64(tt.want))
+			}
+		})
+	}
+
+	// The range is configurable.
+	setForTest(t, &minPrice, 1.0)
+	setForTest(t, &maxPrice, 100.0)
+	for price, status := range map[string]int{"0.99": http.StatusUnprocessableEntity, "1": http.StatusCreated, "100": http.StatusCreated, "100.01": http.StatusUnprocessableEntity} {
+		if rec := serve(t, h, http.MethodPost, "/books", `{"title": "Dune", "price": `+price+`}`); rec.Code != status {
+			t.Errorf("price %s with a range of 1 to 100: status %d, want %d", price, rec.Code, status)
+		}
+	}
+}
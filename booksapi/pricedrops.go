@@ -0,0 +1,299 @@
+package booksapi
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// priceDropWindow is how long a price drop stays in
+// GET /feeds/price-drops.atom. It is configured by a flag in Main.
+var priceDropWindow = 7 * 24 * time.Hour
+
+// priceDropFeedTitle is the title of the price drops feed.
+const priceDropFeedTitle = "Price drops"
+
+// priceDrops indexes the recent price drops of the books in store.
+var priceDrops = newPriceDropIndex()
+
+// priceDrop is the fall in price of a book within priceDropWindow: from
+// the price it had before it started falling to its current price.
+type priceDrop struct {
+	id       int
+	oldPrice Price
+	newPrice Price
+	at       time.Time // of the latest fall
+}
+
+// percent returns the drop as a percentage of the old price.
+func (d priceDrop) percent() float64 {
+	return float64(d.oldPrice-d.newPrice) / float64(d.oldPrice) * 100
+}
+
+// priceDropIndex keeps the current price drop of each book, so the feed
+// is built from the few books with one rather than from the catalog. Drops
+// are forgotten once their latest fall is older than priceDropWindow.
+type priceDropIndex struct {
+	mu    sync.Mutex
+	now   func() time.Time
+	drops map[int]priceDrop
+}
+
+func newPriceDropIndex() *priceDropIndex {
+	return &priceDropIndex{now: time.Now, drops: make(map[int]priceDrop)}
+}
+
+// observe records the write of book, which was prev before it, unless it
+// was new. A lower price starts a drop, or extends the book's current one;
+// a price back up to the old price, or in another currency, ends it.
+func (x *priceDropIndex) observe(prev *Book, book Book) {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+
+	x.pruneLocked()
+	drop, dropping := x.drops[book.ID]
+	switch {
+	case prev == nil || prev.Currency != book.Currency:
+		delete(x.drops, book.ID)
+	case book.Price < prev.Price:
+		if !dropping {
+			drop = priceDrop{id: book.ID, oldPrice: prev.Price}
+		}
+		drop.newPrice, drop.at = book.Price, x.now()
+		x.drops[book.ID] = drop
+	case dropping && book.Price >= drop.oldPrice:
+		delete(x.drops, book.ID)
+	case dropping:
+		drop.newPrice = book.Price
+		x.drops[book.ID] = drop
+	}
+}
+
+// forget drops the price drop of a deleted book.
+func (x *priceDropIndex) forget(id int) {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	delete(x.drops, id)
+}
+
+// list returns the price drops within the window, largest first as a
+// percentage of the old price, then newest first.
+func (x *priceDropIndex) list() []priceDrop {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+
+	x.pruneLocked()
+	list := make([]priceDrop, 0, len(x.drops))
+	for _, drop := range x.drops {
+		list = append(list, drop)
+	}
+	sort.Slice(list, func(i, j int) bool {
+		a, b := list[i], list[j]
+		switch {
+		case a.percent() != b.percent():
+			return a.percent() > b.percent()
+		case !a.at.Equal(b.at):
+			return a.at.After(b.at)
+		default:
+			return a.id < b.id
+		}
+	})
+	return list
+}
+
+// pruneLocked forgets the drops outside the window. Callers must hold x.mu.
+func (x *priceDropIndex) pruneLocked() {
+	cutoff := x.now().Add(-priceDropWindow)
+	for id, drop := range x.drops {
+		if drop.at.Before(cutoff) {
+			delete(x.drops, id)
+		}
+	}
+}
+
+// priceDropStore records the price changes made through it in priceDrops.
+type priceDropStore struct {
+	BookStore
+}
+
+// trackPriceDrops wraps inner so that its price drops are recorded in
+// priceDrops.
+func trackPriceDrops(inner BookStore) BookStore {
+	return priceDropStore{inner}
+}
+
+// Unwrap returns the wrapped store.
+func (s priceDropStore) Unwrap() BookStore { return s.BookStore }
+
+// Close closes the wrapped store if it needs closing.
+func (s priceDropStore) Close() error {
+	if closer, ok := s.BookStore.(io.Closer); ok {
+		return closer.Close()
+	}
+	return nil
+}
+
+func (s priceDropStore) Put(book Book) error {
+	prev, found := s.BookStore.Get(book.ID)
+	err := s.BookStore.Put(book)
+	if err == nil {
+		priceDrops.observe(foundBook(prev, found), book)
+	}
+	return err
+}
+
+func (s priceDropStore) Delete(id int) error {
+	err := s.BookStore.Delete(id)
+	if err == nil {
+		priceDrops.forget(id)
+	}
+	return err
+}
+
+// Transact runs fn as a transaction of the wrapped store, recording its
+// price changes once it has succeeded.
+func (s priceDropStore) Transact(ctx context.Context, fn func(tx BookStore) error) error {
+	var changed []priceChange
+	err := s.BookStore.Transact(ctx, func(tx BookStore) error {
+		return fn(priceDropTx{tx, &changed})
+	})
+	if err == nil {
+		for _, c := range changed {
+			if c.book == nil {
+				priceDrops.forget(c.id)
+			} else {
+				priceDrops.observe(c.prev, *c.book)
+			}
+		}
+	}
+	return err
+}
+
+// priceChange is a write made within a transaction, to be recorded in
+// priceDrops once it succeeds: of book over prev, or a delete for a nil
+// book.
+type priceChange struct {
+	id   int
+	prev *Book
+	book *Book
+}
+
+// priceDropTx is the view of a transaction that priceDropStore hands to
+// fn, collecting its writes.
+type priceDropTx struct {
+	BookStore
+	changed *[]priceChange
+}
+
+// Unwrap returns the wrapped transaction.
+func (tx priceDropTx) Unwrap() BookStore { return tx.BookStore }
+
+func (tx priceDropTx) Put(book Book) error {
+	prev, found := tx.BookStore.Get(book.ID)
+	err := tx.BookStore.Put(book)
+	if err == nil {
+		*tx.changed = append(*tx.changed, priceChange{book.ID, foundBook(prev, found), &book})
+	}
+	return err
+}
+
+func (tx priceDropTx) Delete(id int) error {
+	err := tx.BookStore.Delete(id)
+	if err == nil {
+		*tx.changed = append(*tx.changed, priceChange{id: id})
+	}
+	return err
+}
+
+// Transact runs a nested transaction, whose writes are only kept if it
+// succeeds.
+func (tx priceDropTx) Transact(ctx context.Context, fn func(tx BookStore) error) error {
+	mark := len(*tx.changed)
+	err := tx.BookStore.Transact(ctx, func(inner BookStore) error {
+		return fn(priceDropTx{inner, tx.changed})
+	})
+	if err != nil {
+		*tx.changed = (*tx.changed)[:mark]
+	}
+	return err
+}
+
+// foundBook returns a pointer to book if it was found, or nil.
+func foundBook(book Book, found bool) *Book {
+	if !found {
+		return nil
+	}
+	return &book
+}
+
+// priceDropFeedParams declares the query parameters of
+// GET /feeds/price-drops.atom.
+var priceDropFeedParams = []queryParam{
+	{name: "author", kind: stringParam, repeatable: true},
+	{name: "match", kind: enumParam, values: matchModes},
+}
+
+// priceDropFeedHandler serves the books whose price fell within
+// priceDropWindow as an Atom feed, largest drop first, each entry giving
+// the old and new price and the drop in percent
+// (GET /feeds/price-drops.atom). ?author= keeps the books of an author,
+// or of any of several when repeated, matched as ?match= says, exactly by
+// default.
+func priceDropFeedHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		writeError(w, r, http.StatusMethodNotAllowed, "method_not_allowed")
+		return
+	}
+	q, ok := checkQuery(w, r, priceDropFeedParams...)
+	if !ok {
+		return
+	}
+	mode, err := singleValue(q, priceDropFeedParams, "match")
+	if err != nil {
+		writeAPIError(w, r, err)
+		return
+	}
+	authors := newTextMatchers(mode, q.Strings("author"))
+
+	feed := atomFeed{
+		Title:  priceDropFeedTitle,
+		ID:     absoluteURL(r, r.URL.Path),
+		Link:   atomLink{Href: absoluteURL(r, r.URL.RequestURI()), Rel: "self", Type: "application/atom+xml"},
+		Author: atomAuthor{Name: "Books API"},
+	}
+	var updated time.Time
+	for _, drop := range priceDrops.list() {
+		book, found := store.Get(drop.id)
+		if !found || !authors.match(book.Author) {
+			continue
+		}
+		was := book
+		was.Price = drop.oldPrice
+		book.Price = drop.newPrice
+		url := absoluteURL(r, "/books/"+strconv.Itoa(book.ID))
+		entry := atomEntry{
+			Title:   book.Title,
+			ID:      fmt.Sprintf("%s#price-drop-%d", url, drop.at.Unix()),
+			Link:    atomLink{Href: url},
+			Updated: drop.at.UTC().Format(time.RFC3339),
+			Summary: fmt.Sprintf("Price dropped from %s to %s (-%.1f%%)", displayPrice(r, was), displayPrice(r, book), drop.percent()),
+		}
+		if book.Author != "" {
+			entry.Author = &atomAuthor{Name: book.Author}
+		}
+		feed.Entries = append(feed.Entries, entry)
+		if drop.at.After(updated) {
+			updated = drop.at
+		}
+	}
+	if updated.IsZero() {
+		updated = priceDrops.now()
+	}
+	feed.Updated = updated.UTC().Format(time.RFC3339)
+	writeXML(w, "application/atom+xml; charset=utf-8", feed)
+}
@@ -0,0 +1,139 @@
+package booksapi
+
+import (
+	"encoding/xml"
+	"net/http"
+	"slices"
+	"testing"
+	"time"
+)
+
+// newPriceDropServer returns a server whose store records price drops, on
+// the clock it returns.
+func newPriceDropServer(t *testing.T) (http.Handler, *testClock) {
+	t.Helper()
+	h := newTestServer(t, WithStore(trackPriceDrops(newMemoryStore(newSequentialIDs()))))
+	clock := &testClock{now: time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)}
+	priceDrops.now = clock.Now
+	return h, clock
+}
+
+// priceDropFeed returns the feed served at path.
+func priceDropFeed(t *testing.T, h http.Handler, path string) atomFeed {
+	t.Helper()
+	rec := serve(t, h, http.MethodGet, path, nil)
+	wantCode(t, rec, http.StatusOK)
+	if got := rec.Header().Get("Content-Type"); got != "application/atom+xml; charset=utf-8" {
+		t.Errorf("Content-Type %q", got)
+	}
+	var feed atomFeed
+	if err := xml.Unmarshal(rec.Body.Bytes(), &feed); err != nil {
+		t.Fatal(err)
+	}
+	return feed
+}
+
+// entryTitles returns the titles of the entries of feed.
+func entryTitles(feed atomFeed) []string {
+	var titles []string
+	for _, entry := range feed.Entries {
+		titles = append(titles, entry.Title)
+	}
+	return titles
+}
+
+// TestPriceDropFeed checks that books appear in the feed when their price
+// falls, ordered by the size of the drop, and leave it when the price goes
+// back up, the book is deleted, or the drop leaves the window.
+func TestPriceDropFeed(t *testing.T) {
+	h, clock := newPriceDropServer(t)
+	setPrice := func(id string, title, author string, price float64) {
+		t.Helper()
+		wantCode(t, serve(t, h, http.MethodPut, "/books/"+id, map[string]any{"title": title, "author": author, "price": price}), http.StatusOK)
+	}
+	mustCreateBook(t, h, map[string]any{"title": "Dune", "author": "Frank Herbert", "price": 20})
+	mustCreateBook(t, h, map[string]any{"title": "Emma", "author": "Jane Austen", "price": 10})
+	mustCreateBook(t, h, map[string]any{"title": "Persuasion", "author": "Jane Austen", "price": 8})
+	mustCreateBook(t, h, map[string]any{"title": "Hyperion", "author": "Dan Simmons", "price": 15})
+
+	if feed := priceDropFeed(t, h, "/feeds/price-drops.atom"); len(feed.Entries) != 0 || feed.Title != "Price drops" {
+		t.Fatalf("feed of new books %+v, want no entries", feed)
+	}
+
+	setPrice("1", "Dune", "Frank Herbert", 15) // -25%
+	clock.Advance(time.Hour)
+	setPrice("2", "Emma", "Jane Austen", 5) // -50%
+	clock.Advance(time.Hour)
+	setPrice("3", "Persuasion", "Jane Austen", 7.2) // -10%
+	setPrice("4", "Hyperion", "Dan Simmons", 18)    // a rise is no drop
+
+	feed := priceDropFeed(t, h, "/feeds/price-drops.atom")
+	if got, want := entryTitles(feed), []string{"Emma", "Dune", "Persuasion"}; !slices.Equal(got, want) {
+		t.Fatalf("entries %q, want %q", got, want)
+	}
+	emma := feed.Entries[0]
+	if emma.Summary != "Price dropped from $ 10.00 to $ 5.00 (-50.0%)" || emma.Updated != "2024-05-01T13:00:00Z" || emma.Author == nil || emma.Author.Name != "Jane Austen" {
+		t.Errorf("entry %+v", emma)
+	}
+	if feed.Updated != "2024-05-01T14:00:00Z" {
+		t.Errorf("feed updated %s, want at the latest drop", feed.Updated)
+	}
+	if got := entryTitles(priceDropFeed(t, h, "/feeds/price-drops.atom?author=jane+austen")); !slices.Equal(got, []string{"Emma", "Persuasion"}) {
+		t.Errorf("entries by Jane Austen %q", got)
+	}
+
+	// A further fall extends the drop from the old price, and restarts its
+	// time in the window; a rise that stays below the old price keeps it.
+	clock.Advance(6 * 24 * time.Hour)
+	setPrice("1", "Dune", "Frank Herbert", 5) // -75% of 20
+	setPrice("2", "Emma", "Jane Austen", 6)   // -40% of 10
+	feed = priceDropFeed(t, h, "/feeds/price-drops.atom")
+	if got, want := entryTitles(feed), []string{"Dune", "Emma", "Persuasion"}; !slices.Equal(got, want) {
+		t.Fatalf("entries %q, want %q", got, want)
+	}
+	if got := feed.Entries[0].Summary; got != "Price dropped from $ 20.00 to $ 5.00 (-75.0%)" {
+		t.Errorf("extended drop %q", got)
+	}
+
+	// Emma's and Persuasion's last falls leave the window.
+	clock.Advance(24*time.Hour + time.Minute)
+	if got, want := entryTitles(priceDropFeed(t, h, "/feeds/price-drops.atom")), []string{"Dune"}; !slices.Equal(got, want) {
+		t.Fatalf("entries a week after the first drops %q, want %q", got, want)
+	}
+
+	setPrice("1", "Dune", "Frank Herbert", 20)
+	if feed := priceDropFeed(t, h, "/feeds/price-drops.atom"); len(feed.Entries) != 0 {
+		t.Errorf("entries %q after the price went back up", entryTitles(feed))
+	}
+	setPrice("4", "Hyperion", "Dan Simmons", 9)
+	wantCode(t, serve(t, h, http.MethodDelete, "/books/4", nil), http.StatusNoContent)
+	if feed := priceDropFeed(t, h, "/feeds/price-drops.atom"); len(feed.Entries) != 0 {
+		t.Errorf("entries %q after the book was deleted", entryTitles(feed))
+	}
+}
+
+// TestPriceDropTransactions checks that the price drops of a transaction
+// are recorded once it commits, and not at all when it fails.
+func TestPriceDropTransactions(t *testing.T) {
+	h, _ := newPriceDropServer(t)
+	mustCreateBook(t, h, map[string]any{"title": "Dune", "price": 20})
+	mustCreateBook(t, h, map[string]any{"title": "Emma", "price": 10})
+
+	rec := serve(t, h, http.MethodPost, "/books/transactions", map[string]any{"operations": []map[string]any{
+		{"op": "update", "id": 1, "book": map[string]any{"title": "Dune", "price": 10}},
+		{"op": "delete", "id": 99},
+	}})
+	wantCode(t, rec, http.StatusNotFound)
+	if feed := priceDropFeed(t, h, "/feeds/price-drops.atom"); len(feed.Entries) != 0 {
+		t.Errorf("entries %q after a failed transaction", entryTitles(feed))
+	}
+
+	rec = serve(t, h, http.MethodPost, "/books/transactions", map[string]any{"operations": []map[string]any{
+		{"op": "update", "id": 1, "book": map[string]any{"title": "Dune", "price": 10}},
+		{"op": "update", "id": 2, "book": map[string]any{"title": "Emma", "price": 9}},
+	}})
+	wantCode(t, rec, http.StatusOK)
+	if got, want := entryTitles(priceDropFeed(t, h, "/feeds/price-drops.atom")), []string{"Dune", "Emma"}; !slices.Equal(got, want) {
+		t.Errorf("entries %q, want %q", got, want)
+	}
+}
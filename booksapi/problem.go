@@ -0,0 +1,98 @@
+package booksapi
+
+import (
+	"mime"
+	"net/http"
+	"runtime/debug"
+	"strconv"
+	"strings"
+)
+
+// problemJSON makes every error response an RFC 7807 problem document. It
+// is configured by a flag in Main; otherwise errors use the errorBody
+// envelope unless the request accepts application/problem+json.
+var problemJSON bool
+
+// problemType is the media type of problem documents.
+const problemType = "application/problem+json"
+
+// problemDetails is an RFC 7807 problem document. The standard members are
+// joined by the extension members code, errors for validation errors, and
+// the error's parameters. It is
+// encoded as a map so the parameters appear as members of their own.
+type problemDetails map[string]any
+
+// problemMembers are the members of a problem document that error
+// parameters may not replace.
+var problemMembers = map[string]bool{
+	"type": true, "title": true, "status": true, "detail": true, "instance": true, "code": true, "errors": true,
+}
+
+// newProblemDetails returns the problem document reporting e, with its
+// messages translated for locale.
+func newProblemDetails(r *http.Request, e *apiError, locale string) problemDetails {
+	p := problemDetails{
+		"type":   "about:blank",
+		"title":  http.StatusText(e.Status),
+		"status": e.Status,
+		"detail": translate(locale, e.Code, e.Params),
+		"code":   e.Code,
+	}
+	if r != nil {
+		p["instance"] = apiPath(r.URL.Path)
+	}
+	if len(e.Fields) > 0 {
+		p["errors"] = translateFieldErrors(locale, e.Fields)
+	}
+	for name, value := range e.Params {
+		if !problemMembers[name] {
+			p[name] = value
+		}
+	}
+	return p
+}
+
+// wantsProblem reports whether the error response to r should be a problem
+// document: when -problem-json is set, or when the request's Accept header
+// lists application/problem+json.
+func wantsProblem(r *http.Request) bool {
+	if problemJSON {
+		return true
+	}
+	if r == nil {
+		return false
+	}
+	for _, part := range strings.Split(r.Header.Get("Accept"), ",") {
+		mediaType, params, err := mime.ParseMediaType(strings.TrimSpace(part))
+		if err != nil || mediaType != problemType {
+			continue
+		}
+		if q, err := strconv.ParseFloat(params["q"], 64); err == nil && q == 0 {
+			continue
+		}
+		return true
+	}
+	return false
+}
+
+// recoverPanics turns a panicking handler into a 500 internal_error
+// response, logging the panic and its stack.
+func recoverPanics(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		defer func() {
+			if v := recover(); v != nil {
+				if v == http.ErrAbortHandler {
+					panic(v)
+				}
+				logger.Printf("panic serving request %s %s %s: %v\n%s", requestID(r), r.Method, r.URL.Path, v, debug.Stack())
+				writeError(w, r, http.StatusInternalServerError, "internal_error")
+			}
+		}()
+		next.ServeHTTP(w, r)
+	})
+}
+
+// notFoundHandler answers requests for paths no other handler serves.
+func notFoundHandler(w http.ResponseWriter, r *http.Request) {
+	writeError(w, r, http.StatusNotFound, "not_found")
+}
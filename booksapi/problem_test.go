@@ -0,0 +1,95 @@
+package booksapi
+
+import (
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// TestProblemDetails pins the problem documents of a 404 and of a 422 with
+// validation errors.
+func TestProblemDetails(t *testing.T) {
+	h := newTestServer(t)
+	accept := []string{"Accept", problemType}
+
+	rec := serve(t, h, http.MethodGet, "/books/99", nil, accept...)
+	wantCode(t, rec, http.StatusNotFound)
+	if got := rec.Header().Get("Content-Type"); got != problemType {
+		t.Errorf("Content-Type %q, want %q", got, problemType)
+	}
+	checkGolden(t, "problem/not_found.json", rec.Body.Bytes())
+
+	rec = serve(t, h, http.MethodPost, "/books", map[string]any{"title": " ", "price": -1}, accept...)
+	wantCode(t, rec, http.StatusUnprocessableEntity)
+	checkGolden(t, "problem/validation.json", rec.Body.Bytes())
+}
+
+// TestWantsProblem checks when errors are sent as problem documents rather
+// than in the error envelope.
+func TestWantsProblem(t *testing.T) {
+	h := newTestServer(t)
+	tests := []struct {
+		name   string
+		flag   bool
+		accept string
+		want   bool
+	}{
+		{"default", false, "", false},
+		{"JSON accepted", false, "application/json", false},
+		{"problem accepted", false, problemType, true},
+		{"problem among others", false, "text/html, application/problem+json;q=0.5", true},
+		{"problem refused", false, "application/problem+json;q=0", false},
+		{"flag", true, "", true},
+		{"flag with JSON accepted", true, "application/json", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			setForTest(t, &problemJSON, tt.flag)
+			var header []string
+			if tt.accept != "" {
+				header = []string{"Accept", tt.accept}
+			}
+			// Every kind of error goes through the same writer.
+			for _, req := range []struct{ method, path, instance string }{
+				{http.MethodGet, "/books/99", "/books/99"},
+				{http.MethodGet, "/nothing", "/nothing"},
+				{http.MethodPatch, "/version", "/version"},
+				{http.MethodGet, "/books?limit=x", "/books"},
+			} {
+				rec := serve(t, h, req.method, req.path, nil, header...)
+				if got := rec.Header().Get("Content-Type") == problemType; got != tt.want {
+					t.Errorf("%s %s: problem document %v, want %v", req.method, req.path, got, tt.want)
+				}
+				if tt.want {
+					p := decode[map[string]any](t, rec)
+					if p["status"] != float64(rec.Code) || p["instance"] != req.instance || p["code"] == nil {
+						t.Errorf("%s %s: problem %v", req.method, req.path, p)
+					}
+				} else if decode[errorBody](t, rec).Error.Code == "" {
+					t.Errorf("%s %s: no error envelope in %s", req.method, req.path, rec.Body)
+				}
+			}
+		})
+	}
+}
+
+// TestPanicProblem checks that a panicking handler gets a problem document
+// naming the request ID.
+func TestPanicProblem(t *testing.T) {
+	setForTest(t, &logger, log.New(io.Discard, "", 0))
+	h := assignRequestID(recoverPanics(http.HandlerFunc(func(http.ResponseWriter, *http.Request) {
+		panic("boom")
+	})))
+	req := httptest.NewRequest(http.MethodGet, "/books", nil)
+	req.Header.Set("Accept", problemType)
+	req.Header.Set("X-Request-ID", "req-1")
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	wantCode(t, rec, http.StatusInternalServerError)
+	p := decode[map[string]any](t, rec)
+	if p["code"] != "internal_error" || p["request_id"] != "req-1" || p["title"] != "Internal Server Error" {
+		t.Errorf("problem %v, want internal_error naming req-1", p)
+	}
+}
@@ -0,0 +1,254 @@
+package booksapi
+
+import (
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Publisher represents the publisher of a book.
+type Publisher struct {
+	ID      int    `json:"id"`
+	Name    string `json:"name"`
+	Country string `json:"country"`
+}
+
+// Global variables to store publishers. When both locks are needed, mu must
+// be acquired before publishersMu.
+var (
+	publishers      = make(map[int]Publisher)
+	nextPublisherID = 1
+	publishersMu    sync.Mutex
+)
+
+// publishersHandler handles general publisher collection operations (GET, POST).
+func publishersHandler(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		getPublishers(w, r)
+	case http.MethodPost:
+		createPublisher(w, r)
+	default:
+		writeError(w, r, http.StatusMethodNotAllowed, "method_not_allowed")
+	}
+}
+
+// publisherHandler handles operations on a specific publisher and its books.
+func publisherHandler(w http.ResponseWriter, r *http.Request) {
+	segments := pathSegments(r.URL.Path)
+	if len(segments) < 2 || len(segments) > 3 || (len(segments) == 3 && segments[2] != "books") {
+		writeError(w, r, http.StatusNotFound, "not_found")
+		return
+	}
+
+	id, err := parseResourceID(segments[1], "invalid_publisher_id")
+	if err != nil {
+		writeAPIError(w, r, err)
+		return
+	}
+
+	if len(segments) == 3 {
+		if r.Method != http.MethodGet {
+			writeError(w, r, http.StatusMethodNotAllowed, "method_not_allowed")
+			return
+		}
+		getPublisherBooks(w, r, id)
+		return
+	}
+
+	q, ok := checkQuery(w, r, queryParam{name: "detach", kind: boolParam})
+	if !ok {
+		return
+	}
+	switch r.Method {
+	case http.MethodGet:
+		getPublisher(w, r, id)
+	case http.MethodPut:
+		updatePublisher(w, r, id)
+	case http.MethodDelete:
+		deletePublisher(w, r, id, q.Bool("detach"))
+	default:
+		writeError(w, r, http.StatusMethodNotAllowed, "method_not_allowed")
+	}
+}
+
+// getPublishers retrieves the list of all publishers, ordered by ID and paginated.
+func getPublishers(w http.ResponseWriter, r *http.Request) {
+	q, ok := checkQuery(w, r, paginationParams...)
+	if !ok {
+		return
+	}
+
+	publishersMu.Lock()
+	defer publishersMu.Unlock()
+
+	publisherList := make([]Publisher, 0, len(publishers))
+	for _, publisher := range publishers {
+		publisherList = append(publisherList, publisher)
+	}
+	sort.Slice(publisherList, func(i, j int) bool { return publisherList[i].ID < publisherList[j].ID })
+
+	writeJSON(w, http.StatusOK, paginateQuery(w, r, q, PagingPolicy{}, publisherList))
+}
+
+// createPublisher creates a new publisher.
+func createPublisher(w http.ResponseWriter, r *http.Request) {
+	if _, ok := checkQuery(w, r); !ok {
+		return
+	}
+
+	var publisher Publisher
+	if err := decodeJSON(w, r, &publisher); err != nil {
+		writeAPIError(w, r, err)
+		return
+	}
+	if strings.TrimSpace(publisher.Name) == "" {
+		writeError(w, r, http.StatusUnprocessableEntity, "field_required", "field", "name")
+		return
+	}
+
+	publishersMu.Lock()
+	publisher = addPublisher(publisher)
+	publishersMu.Unlock()
+
+	setLocation(w, "/publishers/"+strconv.Itoa(publisher.ID))
+	writeJSON(w, http.StatusCreated, publisher)
+}
+
+// getPublisher retrieves a specific publisher by its ID.
+func getPublisher(w http.ResponseWriter, r *http.Request, id int) {
+	publishersMu.Lock()
+	defer publishersMu.Unlock()
+
+	publisher, found := publishers[id]
+	if !found {
+		writeError(w, r, http.StatusNotFound, "publisher_not_found")
+		return
+	}
+
+	writeJSON(w, http.StatusOK, publisher)
+}
+
+// updatePublisher updates an existing publisher's details.
+func updatePublisher(w http.ResponseWriter, r *http.Request, id int) {
+	publishersMu.Lock()
+	defer publishersMu.Unlock()
+
+	publisher, found := publishers[id]
+	if !found {
+		writeError(w, r, http.StatusNotFound, "publisher_not_found")
+		return
+	}
+
+	if err := decodeJSON(w, r, &publisher); err != nil {
+		writeAPIError(w, r, err)
+		return
+	}
+	if strings.TrimSpace(publisher.Name) == "" {
+		writeError(w, r, http.StatusUnprocessableEntity, "field_required", "field", "name")
+		return
+	}
+
+	publisher.ID = id
+	publishers[id] = publisher
+	writeJSON(w, http.StatusOK, publisher)
+}
+
+// deletePublisher removes a publisher. A publisher that still has books is
+// only removed when ?detach=true, which clears the reference on those books.
+func deletePublisher(w http.ResponseWriter, r *http.Request, id int, detach bool) {
+	mu.Lock()
+	defer mu.Unlock()
+	publishersMu.Lock()
+	defer publishersMu.Unlock()
+
+	if _, found := publishers[id]; !found {
+		writeError(w, r, http.StatusNotFound, "publisher_not_found")
+		return
+	}
+
+	var linked []Book
+	for _, book := range store.List(ListOptions{}) {
+		if book.PublisherID != nil && *book.PublisherID == id {
+			linked = append(linked, book)
+		}
+	}
+	if len(linked) > 0 && !detach {
+		writeError(w, r, http.StatusConflict, "publisher_has_books")
+		return
+	}
+
+	for _, book := range linked {
+		book.PublisherID = nil
+		if err := store.Put(book); err != nil {
+			writeAPIError(w, r, err)
+			return
+		}
+	}
+	delete(publishers, id)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// getPublisherBooks lists a publisher's books, ordered by ID and paginated.
+func getPublisherBooks(w http.ResponseWriter, r *http.Request, id int) {
+	q, ok := checkQuery(w, r, bookListParams...)
+	if !ok {
+		return
+	}
+
+	if !publisherExists(id) {
+		writeError(w, r, http.StatusNotFound, "publisher_not_found")
+		return
+	}
+
+	var bookList []Book
+	for _, book := range store.List(ListOptions{}) {
+		if book.PublisherID != nil && *book.PublisherID == id {
+			bookList = append(bookList, book)
+		}
+	}
+
+	writeJSON(w, http.StatusOK, renderBooks(r, paginateQuery(w, r, q, PagingPolicy{}, bookList)))
+}
+
+// addPublisher stores a new publisher and assigns its ID.
+// Callers must hold publishersMu.
+func addPublisher(publisher Publisher) Publisher {
+	publisher.ID = nextPublisherID
+	nextPublisherID++
+	publishers[publisher.ID] = publisher
+	return publisher
+}
+
+// findOrCreatePublisher returns the ID of the publisher with the given name,
+// matched case-insensitively, creating the publisher if none exists.
+func findOrCreatePublisher(name string) int {
+	publishersMu.Lock()
+	defer publishersMu.Unlock()
+
+	for _, publisher := range publishers {
+		if strings.EqualFold(publisher.Name, name) {
+			return publisher.ID
+		}
+	}
+	return addPublisher(Publisher{Name: name}).ID
+}
+
+// publisherExists reports whether a publisher with the given ID exists.
+func publisherExists(id int) bool {
+	publishersMu.Lock()
+	defer publishersMu.Unlock()
+
+	_, found := publishers[id]
+	return found
+}
+
+// publisherName returns the name of the publisher with the given ID.
+func publisherName(id int) string {
+	publishersMu.Lock()
+	defer publishersMu.Unlock()
+
+	return publishers[id].Name
+}
@@ -0,0 +1,200 @@
+package booksapi
+
+import (
+	"net/http"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// Data quality report settings. A report lists up to defaultQualitySamples
+// sample book IDs per rule unless ?samples= asks for more, up to
+// maxQualitySamples.
+const (
+	defaultQualitySamples = 10
+	maxQualitySamples     = 100
+)
+
+// suspiciousFieldLength is the number of characters beyond which a title or
+// author is reported as suspiciously long, e.g. a description pasted into
+// the wrong field.
+const suspiciousFieldLength = 300
+
+// qualityRule is a data quality rule checked by GET /admin/data-quality.
+type qualityRule struct {
+	name string
+	// check returns a function reporting whether each book of a scan, in
+	// turn, breaks the rule. It is called once per scan, so rules that
+	// compare books with one another keep their state in the function.
+	check func() func(Book) bool
+	// fix repairs a book breaking the rule, or is nil if the rule cannot
+	// be fixed safely without a person deciding.
+	fix func(*Book)
+}
+
+// qualityRules are the rules of GET /admin/data-quality, in the order they
+// are reported.
+var qualityRules = []qualityRule{
+	{name: "missing_author", check: eachBook(func(b Book) bool { return strings.TrimSpace(b.Author) == "" })},
+	{name: "nonpositive_price", check: eachBook(func(b Book) bool { return b.Price <= 0 })},
+	{name: "duplicate_isbn", check: duplicates(func(b Book) string { return b.ISBN })},
+	{name: "duplicate_title_author", check: duplicates(titleAuthorKey)},
+	{
+		name:  "title_whitespace",
+		check: eachBook(func(b Book) bool { return b.Title != strings.TrimSpace(b.Title) }),
+		fix: func(b *Book) {
+			// A title of nothing but spaces is left for a person to name.
+			if title := strings.TrimSpace(b.Title); title != "" {
+				b.Title = title
+			}
+		},
+	},
+	{
+		name:  "author_whitespace",
+		check: eachBook(func(b Book) bool { return b.Author != strings.TrimSpace(b.Author) }),
+		fix:   func(b *Book) { b.Author = strings.TrimSpace(b.Author) },
+	},
+	{name: "long_field", check: eachBook(func(b Book) bool {
+		return utf8.RuneCountInString(b.Title) > suspiciousFieldLength || utf8.RuneCountInString(b.Author) > suspiciousFieldLength
+	})},
+}
+
+// eachBook returns the check of a rule that looks at one book at a time.
+func eachBook(broken func(Book) bool) func() func(Book) bool {
+	return func() func(Book) bool { return broken }
+}
+
+// duplicates returns the check of a rule reporting the books whose key was
+// already seen in the scan; books with an empty key are not compared.
+func duplicates(key func(Book) string) func() func(Book) bool {
+	return func() func(Book) bool {
+		seen := make(map[string]bool)
+		return func(b Book) bool {
+			k := key(b)
+			if k == "" || k == "\x00" {
+				return false
+			}
+			if seen[k] {
+				return true
+			}
+			seen[k] = true
+			return false
+		}
+	}
+}
+
+// qualityReport is the response body of GET /admin/data-quality.
+type qualityReport struct {
+	Books      int                `json:"books"`
+	Rules      []qualityRuleCount `json:"rules"`
+	Fixed      []qualityFix       `json:"fixed,omitempty"`
+	DurationMS float64            `json:"duration_ms"`
+}
+
+// qualityRuleCount reports the books breaking a rule.
+type qualityRuleCount struct {
+	Rule      string `json:"rule"`
+	Count     int    `json:"count"`
+	SampleIDs []int  `json:"sample_ids"`
+	Fixable   bool   `json:"fixable"`
+}
+
+// qualityFix reports the changes ?fix=true made to a book.
+type qualityFix struct {
+	ID      int                    `json:"id"`
+	Rules   []string               `json:"rules"`
+	Changes map[string]fieldChange `json:"changes"`
+}
+
+// qualityParams declares the query parameters of /admin/data-quality.
+var qualityParams = []queryParam{
+	{name: "samples", kind: intParam},
+	{name: "fix", kind: boolParam},
+}
+
+// adminDataQualityHandler scans the catalog for data quality problems and
+// reports the books breaking each rule (GET /admin/data-quality). With
+// ?fix=true, which needs POST, the books breaking rules that can be fixed
+// safely are fixed, and the changes reported along with the counts found
+// before fixing.
+func adminDataQualityHandler(w http.ResponseWriter, r *http.Request) {
+	q, ok := checkQuery(w, r, qualityParams...)
+	if !ok {
+		return
+	}
+	fix := q.Bool("fix")
+	if r.Method != http.MethodGet && r.Method != http.MethodPost || fix && r.Method != http.MethodPost {
+		writeError(w, r, http.StatusMethodNotAllowed, "method_not_allowed")
+		return
+	}
+	samples := defaultQualitySamples
+	if q.Has("samples") {
+		samples = min(max(q.Int("samples"), 0), maxQualitySamples)
+	}
+
+	start := time.Now()
+	if fix {
+		mu.Lock()
+		defer mu.Unlock()
+	}
+	// The listing is a copy, so the scan sees the catalog as it was when
+	// it started even while other requests change it.
+	list := store.List(ListOptions{})
+	report := qualityReport{Books: len(list), Rules: make([]qualityRuleCount, len(qualityRules))}
+	checks := make([]func(Book) bool, len(qualityRules))
+	for i, rule := range qualityRules {
+		checks[i] = rule.check()
+		report.Rules[i] = qualityRuleCount{Rule: rule.name, SampleIDs: []int{}, Fixable: rule.fix != nil}
+	}
+	var fixes []Book
+	for _, book := range list {
+		fixed, fixedBy := book, []string(nil)
+		for i, rule := range qualityRules {
+			if !checks[i](book) {
+				continue
+			}
+			count := &report.Rules[i]
+			count.Count++
+			if len(count.SampleIDs) < samples {
+				count.SampleIDs = append(count.SampleIDs, book.ID)
+			}
+			if fix && rule.fix != nil {
+				rule.fix(&fixed)
+				fixedBy = append(fixedBy, rule.name)
+			}
+		}
+		if fixedBy == nil {
+			continue
+		}
+		changes, err := bookChanges(book, fixed)
+		if err != nil {
+			writeAPIError(w, r, err)
+			return
+		}
+		if len(changes) == 0 {
+			continue
+		}
+		touchBook(&fixed, book.CreatedAt)
+		fixes = append(fixes, fixed)
+		report.Fixed = append(report.Fixed, qualityFix{ID: book.ID, Rules: fixedBy, Changes: changes})
+	}
+	if len(fixes) > 0 {
+		// The fixes are made together, so a failure leaves every book as
+		// it was.
+		err := store.Transact(r.Context(), func(tx BookStore) error {
+			for _, book := range fixes {
+				if err := tx.Put(book); err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+		if err != nil {
+			writeAPIError(w, r, err)
+			return
+		}
+	}
+	report.DurationMS = millisecondsSince(start)
+
+	writeJSON(w, http.StatusOK, report)
+}
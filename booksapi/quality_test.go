@@ -0,0 +1,103 @@
+package booksapi
+
+import (
+	"encoding/json"
+	"net/http"
+	"slices"
+	"strings"
+	"testing"
+)
+
+// putDirtyCatalog stores, bypassing validation as old imports did, a
+// catalog breaking every data quality rule.
+func putDirtyCatalog(t *testing.T) {
+	t.Helper()
+	for _, book := range []Book{
+		{ID: 1, Title: "Dune", Author: "Frank Herbert", ISBN: "9780441013593", Price: 9.99},
+		{ID: 2, Title: "  Emma ", Author: "Jane Austen", Price: 0},
+		{ID: 3, Title: "Dune", Author: "frank herbert ", ISBN: "9780441013593", Price: 5},
+		{ID: 4, Title: "Persuasion", Author: "", Price: -1},
+		{ID: 5, Title: "   ", Author: "Anonymous", Price: 3},
+		{ID: 6, Title: strings.Repeat("x", suspiciousFieldLength+1), Author: "Someone", Price: 1},
+		{ID: 7, Title: "Emma", Author: "Jane Austen", Price: 4},
+	} {
+		if err := store.Put(book); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+// qualityJSON returns a data quality report as JSON without its duration,
+// which differs between runs.
+func qualityJSON(t *testing.T, body []byte) []byte {
+	t.Helper()
+	var report qualityReport
+	if err := json.Unmarshal(body, &report); err != nil {
+		t.Fatal(err)
+	}
+	report.DurationMS = 0
+	data, err := json.Marshal(report)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
+
+// TestDataQuality scans a dirty catalog, pins the report and the catalog
+// after ?fix=true, and checks that only the whitespace left by the fix is
+// reported afterwards.
+func TestDataQuality(t *testing.T) {
+	h := newTestServer(t)
+	putDirtyCatalog(t)
+
+	rec := serve(t, h, http.MethodGet, "/admin/data-quality", nil)
+	wantCode(t, rec, http.StatusOK)
+	checkGolden(t, "quality/report.json", qualityJSON(t, rec.Body.Bytes()))
+
+	rec = serve(t, h, http.MethodGet, "/admin/data-quality?samples=1", nil)
+	wantCode(t, rec, http.StatusOK)
+	for _, rule := range decode[qualityReport](t, rec).Rules {
+		if len(rule.SampleIDs) > 1 {
+			t.Errorf("rule %s: samples %v with samples=1", rule.Rule, rule.SampleIDs)
+		}
+	}
+
+	before := catalogJSON(t, store)
+	wantCode(t, serve(t, h, http.MethodGet, "/admin/data-quality?fix=true", nil), http.StatusMethodNotAllowed)
+	wantCode(t, serve(t, h, http.MethodDelete, "/admin/data-quality", nil), http.StatusMethodNotAllowed)
+	if after := catalogJSON(t, store); after != before {
+		t.Errorf("refused requests changed the catalog to %s", after)
+	}
+
+	rec = serve(t, h, http.MethodPost, "/admin/data-quality?fix=true", nil)
+	wantCode(t, rec, http.StatusOK)
+	checkGolden(t, "quality/fixed.json", qualityJSON(t, rec.Body.Bytes()))
+	checkGolden(t, "quality/catalog.json", serve(t, h, http.MethodGet, "/books", nil).Body.Bytes())
+
+	// Book 5's title is nothing but spaces, which the fix leaves for a
+	// person to name.
+	rec = serve(t, h, http.MethodGet, "/admin/data-quality", nil)
+	for _, rule := range decode[qualityReport](t, rec).Rules {
+		switch rule.Rule {
+		case "title_whitespace":
+			if !slices.Equal(rule.SampleIDs, []int{5}) {
+				t.Errorf("after the fix, %s samples %v, want [5]", rule.Rule, rule.SampleIDs)
+			}
+		case "author_whitespace":
+			if rule.Count != 0 {
+				t.Errorf("after the fix, %s counts %d", rule.Rule, rule.Count)
+			}
+		}
+	}
+}
+
+// TestDataQualityNeedsAdmin checks that only admin tokens get the report.
+func TestDataQualityNeedsAdmin(t *testing.T) {
+	h := newTestServer(t)
+	withTokensFile(t)
+	for token, want := range map[string]int{"r1": http.StatusForbidden, "w1": http.StatusForbidden, "a1": http.StatusOK} {
+		if got := serve(t, h, http.MethodGet, "/admin/data-quality", nil, "Authorization", "Bearer "+token).Code; got != want {
+			t.Errorf("token %s: status %d, want %d", token, got, want)
+		}
+	}
+}
@@ -0,0 +1,198 @@
+package booksapi
+
+import (
+	"math"
+	"net/http"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// strictQuery rejects query parameters an endpoint does not declare. It is
+// configured by a flag in Main; otherwise unknown parameters are ignored.
+var strictQuery bool
+
+// paramKind is the type of a query parameter's value.
+type paramKind int
+
+const (
+	intParam    paramKind = iota // non-negative integer
+	floatParam                   // decimal number
+	boolParam                    // true or false, as accepted by strconv.ParseBool
+	stringParam                  // any text
+	enumParam                    // comma-separated list of allowed values
+)
+
+// expected describes the values of a parameter kind in error messages.
+func (k paramKind) expected(values []string) string {
+	switch k {
+	case intParam:
+		return "non-negative integer"
+	case floatParam:
+		return "number"
+	case boolParam:
+		return "boolean"
+	case enumParam:
+		return "one or more of " + strings.Join(values, ", ")
+	default:
+		return "string"
+	}
+}
+
+// queryParam declares a query parameter accepted by an endpoint. Giving a
+// parameter that is not repeatable more than once, such as ?limit=1&limit=2,
+// fails with 400 rather than using one of the values. The values of a
+// repeated enumParam are joined as if given comma-separated; those of a
+// repeated stringParam are read with Strings.
+type queryParam struct {
+	name       string
+	kind       paramKind
+	values     []string // allowed values of an enumParam
+	repeatable bool     // whether the parameter may be given more than once
+}
+
+// queryValues holds the typed values of a request's query parameters, keyed
+// by name. Parameters absent from the request have no entry.
+type queryValues map[string]any
+
+// Has reports whether the parameter was given.
+func (q queryValues) Has(name string) bool {
+	_, ok := q[name]
+	return ok
+}
+
+// Int returns the value of an intParam, or zero.
+func (q queryValues) Int(name string) int {
+	v, _ := q[name].(int)
+	return v
+}
+
+// Float returns the value of a floatParam, or zero.
+func (q queryValues) Float(name string) float64 {
+	v, _ := q[name].(float64)
+	return v
+}
+
+// Bool returns the value of a boolParam, or false.
+func (q queryValues) Bool(name string) bool {
+	v, _ := q[name].(bool)
+	return v
+}
+
+// String returns the value of a stringParam, or "". For a repeatable one,
+// it returns the first value.
+func (q queryValues) String(name string) string {
+	if v, ok := q[name].([]string); ok && len(v) > 0 {
+		return v[0]
+	}
+	v, _ := q[name].(string)
+	return v
+}
+
+// Strings returns every value of a repeatable stringParam, in the order
+// given, or the single value of another one.
+func (q queryValues) Strings(name string) []string {
+	switch v := q[name].(type) {
+	case []string:
+		return v
+	case string:
+		return []string{v}
+	}
+	return nil
+}
+
+// List returns the values of an enumParam.
+func (q queryValues) List(name string) []string {
+	v, _ := q[name].([]string)
+	return v
+}
+
+// Contains reports whether an enumParam includes value.
+func (q queryValues) Contains(name, value string) bool {
+	return slices.Contains(q.List(name), value)
+}
+
+// parseQuery checks the request's query parameters against those declared
+// by the endpoint and returns their typed values. The _method parameter of
+// the method override middleware is accepted whenever the override is
+// enabled.
+func parseQuery(r *http.Request, params ...queryParam) (queryValues, error) {
+	return parseValues(r.URL.Query(), params...)
+}
+
+// parseValues is parseQuery for query parameters taken from elsewhere than
+// the request URL, such as a saved filter.
+func parseValues(raw url.Values, params ...queryParam) (queryValues, error) {
+	q := make(queryValues)
+	for _, p := range params {
+		values, ok := raw[p.name]
+		if !ok {
+			continue
+		}
+		if len(values) > 1 && !p.repeatable {
+			return nil, newAPIError(http.StatusBadRequest, "repeated_query_parameter", "name", p.name)
+		}
+		value, ok := p.parse(values)
+		if !ok {
+			return nil, newAPIError(http.StatusBadRequest, "invalid_query_parameter", "name", p.name, "expected", p.kind.expected(p.values))
+		}
+		q[p.name] = value
+	}
+
+	if strictQuery {
+		for name := range raw {
+			if !q.Has(name) && !(allowMethodOverride && name == "_method") {
+				return nil, newAPIError(http.StatusBadRequest, "unknown_query_parameter", "name", name)
+			}
+		}
+	}
+	return q, nil
+}
+
+// parse converts the raw values of the parameter to its kind.
+func (p queryParam) parse(values []string) (any, bool) {
+	value := values[0]
+	switch p.kind {
+	case intParam:
+		n, err := strconv.Atoi(value)
+		return n, err == nil && n >= 0
+	case floatParam:
+		f, err := strconv.ParseFloat(value, 64)
+		return f, err == nil && !math.IsNaN(f) && !math.IsInf(f, 0)
+	case boolParam:
+		b, err := strconv.ParseBool(value)
+		return b, err == nil
+	case enumParam:
+		var list []string
+		for _, value := range values {
+			for _, part := range strings.Split(value, ",") {
+				part = strings.TrimSpace(part)
+				if part == "" {
+					continue
+				}
+				if !slices.Contains(p.values, part) {
+					return nil, false
+				}
+				list = append(list, part)
+			}
+		}
+		return list, true
+	default:
+		if p.repeatable {
+			return slices.Clone(values), true
+		}
+		return value, true
+	}
+}
+
+// checkQuery parses the request's query parameters like parseQuery and
+// writes an error response if they are invalid.
+func checkQuery(w http.ResponseWriter, r *http.Request, params ...queryParam) (queryValues, bool) {
+	q, err := parseQuery(r, params...)
+	if err != nil {
+		writeAPIError(w, r, err)
+		return nil, false
+	}
+	return q, true
+}
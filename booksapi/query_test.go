@@ -0,0 +1,139 @@
+package booksapi
+
+import (
+	"errors"
+	"net/http"
+	"net/url"
+	"reflect"
+	"testing"
+)
+
+// TestParseValues checks the typed values parseValues returns and the
+// errors it refuses parameters with.
+func TestParseValues(t *testing.T) {
+	params := []queryParam{
+		{name: "limit", kind: intParam},
+		{name: "min", kind: floatParam},
+		{name: "all", kind: boolParam},
+		{name: "q", kind: stringParam},
+		{name: "tag", kind: stringParam, repeatable: true},
+		{name: "fields", kind: enumParam, values: []string{"id", "title", "price"}, repeatable: true},
+	}
+	tests := []struct {
+		name   string
+		query  string
+		strict bool
+		want   queryValues
+		code   string // of the error; none if empty
+		params map[string]string
+	}{
+		{name: "none", query: "", want: queryValues{}},
+		{
+			name:  "every kind",
+			query: "limit=5&min=2.5&all=true&q=dune&tag=a&tag=b&fields=id,%20title&fields=price",
+			want: queryValues{
+				"limit": 5, "min": 2.5, "all": true, "q": "dune",
+				"tag": []string{"a", "b"}, "fields": []string{"id", "title", "price"},
+			},
+		},
+		{name: "empty enum items", query: "fields=id,,title,", want: queryValues{"fields": []string{"id", "title"}}},
+		{
+			name: "int not a number", query: "limit=ten", code: "invalid_query_parameter",
+			params: map[string]string{"name": "limit", "expected": "non-negative integer"},
+		},
+		{
+			name: "negative int", query: "limit=-1", code: "invalid_query_parameter",
+			params: map[string]string{"name": "limit", "expected": "non-negative integer"},
+		},
+		{
+			name: "float not a number", query: "min=NaN", code: "invalid_query_parameter",
+			params: map[string]string{"name": "min", "expected": "number"},
+		},
+		{
+			name: "infinite float", query: "min=Inf", code: "invalid_query_parameter",
+			params: map[string]string{"name": "min", "expected": "number"},
+		},
+		{
+			name: "bool", query: "all=yes", code: "invalid_query_parameter",
+			params: map[string]string{"name": "all", "expected": "boolean"},
+		},
+		{
+			name: "enum", query: "fields=id,isbn", code: "invalid_query_parameter",
+			params: map[string]string{"name": "fields", "expected": "one or more of id, title, price"},
+		},
+		{
+			name: "repeated", query: "limit=1&limit=2", code: "repeated_query_parameter",
+			params: map[string]string{"name": "limit"},
+		},
+		{
+			name: "repeated string", query: "q=a&q=b", code: "repeated_query_parameter",
+			params: map[string]string{"name": "q"},
+		},
+		{name: "unknown, lenient", query: "limit=1&colour=red", want: queryValues{"limit": 1}},
+		{
+			name: "unknown, strict", query: "limit=1&colour=red", strict: true, code: "unknown_query_parameter",
+			params: map[string]string{"name": "colour"},
+		},
+		{name: "declared, strict", query: "limit=1&q=x", strict: true, want: queryValues{"limit": 1, "q": "x"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			setForTest(t, &strictQuery, tt.strict)
+			raw, err := url.ParseQuery(tt.query)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got, err := parseValues(raw, params...)
+			if tt.code == "" {
+				if err != nil {
+					t.Fatalf("parseValues(%q): %v", tt.query, err)
+				}
+				if !reflect.DeepEqual(got, tt.want) {
+					t.Errorf("parseValues(%q) = %#v, want %#v", tt.query, got, tt.want)
+				}
+				return
+			}
+			var apiErr *apiError
+			if !errors.As(err, &apiErr) {
+				t.Fatalf("parseValues(%q) = %v, %v; want error %s", tt.query, got, err, tt.code)
+			}
+			if apiErr.Status != http.StatusBadRequest || apiErr.Code != tt.code || !reflect.DeepEqual(apiErr.Params, tt.params) {
+				t.Errorf("parseValues(%q): %d %s %v, want 400 %s %v", tt.query, apiErr.Status, apiErr.Code, apiErr.Params, tt.code, tt.params)
+			}
+		})
+	}
+}
+
+// TestQueryValidation checks the responses to invalid query parameters on
+// an endpoint, and that _method passes strict mode when overrides are on.
+func TestQueryValidation(t *testing.T) {
+	h := newTestServer(t)
+	mustCreateBook(t, h, map[string]any{"title": "Dune"})
+
+	rec := serve(t, h, http.MethodGet, "/books?limit=many", nil)
+	wantCode(t, rec, http.StatusBadRequest)
+	if got := decode[errorBody](t, rec).Error; got.Code != "invalid_query_parameter" || got.Params["name"] != "limit" || got.Params["expected"] == "" {
+		t.Errorf("error %+v, want invalid_query_parameter naming limit and its type", got)
+	}
+
+	rec = serve(t, h, http.MethodGet, "/books?limit=1&limit=2", nil)
+	wantCode(t, rec, http.StatusBadRequest)
+	if got := errorCode(t, rec); got != "repeated_query_parameter" {
+		t.Errorf("error code %q, want repeated_query_parameter", got)
+	}
+
+	wantCode(t, serve(t, h, http.MethodGet, "/books?colour=red", nil), http.StatusOK)
+	// The modes are set at startup, before anything is cached.
+	setForTest(t, &strictQuery, true)
+	invalidateResponseCache()
+	rec = serve(t, h, http.MethodGet, "/books?colour=red", nil)
+	wantCode(t, rec, http.StatusBadRequest)
+	if got := decode[errorBody](t, rec).Error; got.Code != "unknown_query_parameter" || got.Params["name"] != "colour" {
+		t.Errorf("error %+v, want unknown_query_parameter naming colour", got)
+	}
+
+	wantCode(t, serve(t, h, http.MethodGet, "/books?_method=GET", nil), http.StatusBadRequest)
+	setForTest(t, &allowMethodOverride, true)
+	invalidateResponseCache()
+	wantCode(t, serve(t, h, http.MethodGet, "/books?_method=GET", nil), http.StatusOK)
+}
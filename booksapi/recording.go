@@ -0,0 +1,396 @@
+package booksapi
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+)
+
+// Recording settings, configured by flags in Main. An empty directory
+// disables recording.
+var (
+	recordDir      string
+	maxRecordings  = 1000
+	maxRecordBody  = 64 << 10
+	recordIncludes string
+	recordExcludes = "/healthz,/readyz,/debug/vars"
+)
+
+var (
+	recordPatterns []*routePolicy // from recordIncludes; none records every route
+	recordSkipped  []*routePolicy // from recordExcludes
+
+	recordingsMu   sync.Mutex
+	firstRecording int // number of the oldest recording kept
+	lastRecording  int // number of the last recording written
+)
+
+// redactedHeaders are the headers carrying credentials, which recordings
+// leave out.
+var redactedHeaders = []string{"Authorization", "Proxy-Authorization", "Cookie", "Set-Cookie"}
+
+// redactedFields matches the string values of the JSON fields carrying
+// credentials, such as the api_key of a merge source, which recordings
+// replace with redactedValue. A value cut short by maxRecordBody matches
+// too.
+var redactedFields = regexp.MustCompile(`("api_key"\s*:\s*)"(?:[^"\\]|\\.)*"?`)
+
+// redactedValue stands for a credential left out of a recording.
+const redactedValue = `"[redacted]"`
+
+// recording is one request and its response, as written to a numbered file
+// of -record-dir. Bodies longer than maxRecordBody are cut there, with
+// Truncated set and Size giving their full length.
+type recording struct {
+	Time      time.Time        `json:"time"`
+	RequestID string           `json:"request_id,omitempty"`
+	Request   recordedRequest  `json:"request"`
+	Response  recordedResponse `json:"response"`
+	Duration  float64          `json:"duration_ms"`
+}
+
+// recordedRequest is the request of a recording, without its credentials.
+type recordedRequest struct {
+	Method string      `json:"method"`
+	Path   string      `json:"path"` // with the query, below -base-path
+	Header http.Header `json:"header"`
+	recordedBody
+}
+
+// recordedResponse is the response of a recording, before compression.
+type recordedResponse struct {
+	Status int         `json:"status"`
+	Header http.Header `json:"header"`
+	recordedBody
+}
+
+// recordedBody is a recorded body, as text if it is valid UTF-8 and in
+// base64 otherwise.
+type recordedBody struct {
+	Body      string `json:"body,omitempty"`
+	Encoding  string `json:"body_encoding,omitempty"` // "base64", or empty for text
+	Size      int    `json:"body_size"`
+	Truncated bool   `json:"body_truncated,omitempty"`
+}
+
+// newRecordedBody records data, the first bytes of a body of size bytes,
+// without the values of redactedFields.
+func newRecordedBody(data []byte, size int) recordedBody {
+	b := recordedBody{Size: size, Truncated: len(data) < size}
+	if utf8.Valid(data) {
+		b.Body = redactedFields.ReplaceAllString(string(data), "${1}"+redactedValue)
+	} else {
+		b.Body = base64.StdEncoding.EncodeToString(data)
+		b.Encoding = "base64"
+	}
+	return b
+}
+
+// bytes returns the recorded bytes of the body.
+func (b recordedBody) bytes() ([]byte, error) {
+	if b.Encoding == "base64" {
+		return base64.StdEncoding.DecodeString(b.Body)
+	}
+	return []byte(b.Body), nil
+}
+
+// openRecordings prepares -record-dir: it parses the route patterns,
+// creates the directory, and continues the numbering of the recordings
+// already in it.
+func openRecordings() error {
+	var err error
+	if recordPatterns, err = parseRoutePatterns(recordIncludes); err != nil {
+		return fmt.Errorf("-record-include: %w", err)
+	}
+	if recordSkipped, err = parseRoutePatterns(recordExcludes); err != nil {
+		return fmt.Errorf("-record-exclude: %w", err)
+	}
+	if err := os.MkdirAll(recordDir, 0o755); err != nil {
+		return err
+	}
+	numbers, err := recordingNumbers()
+	if err != nil {
+		return err
+	}
+	firstRecording, lastRecording = 1, 0
+	if len(numbers) > 0 {
+		firstRecording, lastRecording = numbers[0], numbers[len(numbers)-1]
+	}
+	return nil
+}
+
+// parseRoutePatterns parses a comma-separated list of route patterns, each
+// an optional method and a path as in -route-policies, such as
+// "GET /books/*,/healthz".
+func parseRoutePatterns(list string) ([]*routePolicy, error) {
+	var patterns []*routePolicy
+	for _, item := range strings.Split(list, ",") {
+		fields := strings.Fields(item)
+		if len(fields) == 0 {
+			continue
+		}
+		p := &routePolicy{}
+		if len(fields) == 2 {
+			p.method = strings.ToUpper(fields[0])
+			fields = fields[1:]
+		}
+		if len(fields) != 1 || !strings.HasPrefix(fields[0], "/") {
+			return nil, fmt.Errorf("invalid route %q: want [method] /path", strings.TrimSpace(item))
+		}
+		p.pattern = fields[0]
+		patterns = append(patterns, p)
+	}
+	return patterns, nil
+}
+
+// recordingNumbers returns the numbers of the recordings in -record-dir,
+// in ascending order.
+func recordingNumbers() ([]int, error) {
+	entries, err := os.ReadDir(recordDir)
+	if err != nil {
+		return nil, err
+	}
+	var numbers []int
+	for _, entry := range entries {
+		name, ok := strings.CutSuffix(entry.Name(), ".json")
+		if n, err := strconv.Atoi(name); ok && err == nil && n > 0 {
+			numbers = append(numbers, n)
+		}
+	}
+	slices.Sort(numbers)
+	return numbers, nil
+}
+
+// shouldRecord reports whether the exchange of r is recorded: its route
+// matches an include pattern, if any are given, and no exclude pattern.
+func shouldRecord(r *http.Request) bool {
+	if len(recordPatterns) > 0 && !slices.ContainsFunc(recordPatterns, func(p *routePolicy) bool { return p.matches(r) }) {
+		return false
+	}
+	return !slices.ContainsFunc(recordSkipped, func(p *routePolicy) bool { return p.matches(r) })
+}
+
+// recordExchanges writes every request of a recorded route and its
+// response to -record-dir, for replay. The request body is kept up to
+// maxRecordBody as the handler reads it, and the response body as it is
+// written, so neither is held in full. The part of the request body the
+// handler left unread is read once it returns, as far as the recording
+// keeps it. Credentials are left out.
+func recordExchanges(next http.Handler) http.Handler {
+	if recordDir == "" {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !shouldRecord(r) {
+			next.ServeHTTP(w, r)
+			return
+		}
+		start := time.Now()
+		reqBody := &recordingReader{ReadCloser: r.Body}
+		if r.Body != nil && r.Body != http.NoBody {
+			r.Body = reqBody
+		}
+		rw := &recordingWriter{ResponseWriter: w, status: http.StatusOK}
+		next.ServeHTTP(rw, r)
+		if r.Body == reqBody {
+			// One byte past what is kept tells a truncated body from one
+			// of exactly maxRecordBody bytes.
+			io.CopyN(io.Discard, reqBody, int64(maxRecordBody+1-reqBody.size))
+		}
+		if rw.header == nil {
+			rw.header = w.Header().Clone()
+		}
+
+		rec := recording{
+			Time:      start.UTC(),
+			RequestID: requestID(r),
+			Request: recordedRequest{
+				Method:       r.Method,
+				Path:         r.URL.RequestURI(),
+				Header:       redactHeader(r.Header),
+				recordedBody: newRecordedBody(reqBody.data.Bytes(), reqBody.size),
+			},
+			Response: recordedResponse{
+				Status:       rw.status,
+				Header:       redactHeader(rw.header),
+				recordedBody: newRecordedBody(rw.data.Bytes(), rw.size),
+			},
+			Duration: millisecondsSince(start),
+		}
+		if err := saveRecording(rec); err != nil {
+			logger.Printf("ERROR recording request %s: %v", rec.RequestID, err)
+		}
+	})
+}
+
+// redactHeader returns a copy of h without the headers carrying
+// credentials.
+func redactHeader(h http.Header) http.Header {
+	h = h.Clone()
+	for _, name := range redactedHeaders {
+		h.Del(name)
+	}
+	return h
+}
+
+// saveRecording writes rec under the next number, then deletes the
+// recordings that fall out of the last maxRecordings: usually just the one
+// numbered maxRecordings before it, or more after -record-max was lowered.
+func saveRecording(rec recording) error {
+	data, err := json.MarshalIndent(rec, "", "  ")
+	if err != nil {
+		return err
+	}
+	recordingsMu.Lock()
+	defer recordingsMu.Unlock()
+	lastRecording++
+	if err := writeFileAtomic(recordingPath(lastRecording), data); err != nil {
+		return err
+	}
+	for ; firstRecording <= lastRecording-maxRecordings; firstRecording++ {
+		if err := os.Remove(recordingPath(firstRecording)); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	return nil
+}
+
+// recordingPath returns the path of the recording numbered n.
+func recordingPath(n int) string {
+	return filepath.Join(recordDir, fmt.Sprintf("%08d.json", n))
+}
+
+// recordingReader passes a request body through to the handler, keeping
+// its first maxRecordBody bytes.
+type recordingReader struct {
+	io.ReadCloser
+	data bytes.Buffer
+	size int
+}
+
+func (rr *recordingReader) Read(p []byte) (int, error) {
+	n, err := rr.ReadCloser.Read(p)
+	rr.size += n
+	if room := maxRecordBody - rr.data.Len(); room > 0 {
+		rr.data.Write(p[:min(n, room)])
+	}
+	return n, err
+}
+
+// recordingWriter passes a response through, keeping its status, its
+// headers as sent, and the first maxRecordBody bytes of its body.
+type recordingWriter struct {
+	http.ResponseWriter
+	status int
+	header http.Header
+	data   bytes.Buffer
+	size   int
+}
+
+// Unwrap returns the wrapped writer, for http.ResponseController.
+func (rw *recordingWriter) Unwrap() http.ResponseWriter { return rw.ResponseWriter }
+
+func (rw *recordingWriter) WriteHeader(status int) {
+	if rw.header == nil && status >= http.StatusOK {
+		rw.status = status
+		rw.header = rw.Header().Clone()
+	}
+	rw.ResponseWriter.WriteHeader(status)
+}
+
+func (rw *recordingWriter) Write(p []byte) (int, error) {
+	if rw.header == nil {
+		rw.header = rw.Header().Clone()
+	}
+	rw.size += len(p)
+	if room := maxRecordBody - rw.data.Len(); room > 0 {
+		rw.data.Write(p[:min(len(p), room)])
+	}
+	return rw.ResponseWriter.Write(p)
+}
+
+// runReplay implements the replay subcommand, which sends recorded requests
+// again: "week05_Assignment replay [-token TOKEN] URL FILE...", where URL
+// is the server to send them to, including any base path. Each request is
+// sent as recorded, with the token if one is given, and its status printed
+// next to the recorded one. Requests whose body was truncated are not
+// sent. It exits with status 1 if any request could not be sent.
+func runReplay(args []string) int {
+	flags := flag.NewFlagSet("replay", flag.ContinueOnError)
+	token := flags.String("token", "", "bearer token sent with every request, as recordings hold none")
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+	if flags.NArg() < 2 {
+		fmt.Fprintln(os.Stderr, "usage: replay [-token TOKEN] URL FILE...")
+		return 2
+	}
+	target := strings.TrimSuffix(flags.Arg(0), "/")
+	client := &http.Client{Timeout: time.Minute}
+	status := 0
+	for _, path := range flags.Args()[1:] {
+		got, want, err := replayRecording(client, target, *token, path)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%s: %v\n", path, err)
+			status = 1
+			continue
+		}
+		result := "same status"
+		if got != want {
+			result = fmt.Sprintf("recorded %d", want)
+		}
+		fmt.Printf("%s: %d (%s)\n", path, got, result)
+	}
+	return status
+}
+
+// replayRecording sends the request of the recording at path to target,
+// returning the status of the response and that of the recorded one.
+func replayRecording(client *http.Client, target, token, path string) (got, want int, err error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return 0, 0, err
+	}
+	var rec recording
+	if err := json.Unmarshal(data, &rec); err != nil {
+		return 0, 0, err
+	}
+	if rec.Request.Truncated {
+		return 0, 0, fmt.Errorf("request body of %d bytes was truncated when recorded", rec.Request.Size)
+	}
+	body, err := rec.Request.bytes()
+	if err != nil {
+		return 0, 0, err
+	}
+	req, err := http.NewRequest(rec.Request.Method, target+rec.Request.Path, bytes.NewReader(body))
+	if err != nil {
+		return 0, 0, err
+	}
+	for name, values := range rec.Request.Header {
+		req.Header[name] = values
+	}
+	req.Header.Del("Content-Length")
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return 0, 0, err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, resp.Body)
+	return resp.StatusCode, rec.Response.Status, nil
+}
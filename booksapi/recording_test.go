@@ -0,0 +1,142 @@
+package booksapi
+
+import (
+	"encoding/json"
+	"net/http"
+	"os"
+	"slices"
+	"strings"
+	"testing"
+)
+
+// newRecordingServer returns a server recording its exchanges to a
+// directory of the test's.
+func newRecordingServer(t *testing.T) http.Handler {
+	t.Helper()
+	resetState(t)
+	setForTest(t, &recordDir, t.TempDir())
+	if err := openRecordings(); err != nil {
+		t.Fatal(err)
+	}
+	return New()
+}
+
+// readRecording reads the recording numbered n.
+func readRecording(t *testing.T, n int) recording {
+	t.Helper()
+	data, err := os.ReadFile(recordingPath(n))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var rec recording
+	if err := json.Unmarshal(data, &rec); err != nil {
+		t.Fatal(err)
+	}
+	return rec
+}
+
+func TestRecordPost(t *testing.T) {
+	h := newRecordingServer(t)
+	body := `{"title": "Dune", "author": "Frank Herbert"}`
+	resp := serve(t, h, http.MethodPost, "/books?enrich=false", body, "Authorization", "Bearer secret", "X-Request-ID", "req-1")
+	wantCode(t, resp, http.StatusCreated)
+
+	rec := readRecording(t, 1)
+	if rec.RequestID != "req-1" {
+		t.Errorf("request ID %q, want req-1", rec.RequestID)
+	}
+	req := rec.Request
+	if req.Method != http.MethodPost || req.Path != "/books?enrich=false" {
+		t.Errorf("recorded request %s %s", req.Method, req.Path)
+	}
+	if req.Body != body || req.Size != len(body) || req.Truncated || req.Encoding != "" {
+		t.Errorf("recorded request body %+v, want %q", req.recordedBody, body)
+	}
+	if req.Header.Get("Authorization") != "" {
+		t.Error("recording holds the Authorization header")
+	}
+	if req.Header.Get("Content-Type") != "application/json" {
+		t.Errorf("recorded Content-Type %q", req.Header.Get("Content-Type"))
+	}
+	if rec.Response.Status != http.StatusCreated || rec.Response.Body != resp.Body.String() || rec.Response.Size != resp.Body.Len() {
+		t.Errorf("recorded response %d %q, want 201 %q", rec.Response.Status, rec.Response.Body, resp.Body.String())
+	}
+	if rec.Response.Header.Get("Location") != "/books/1" {
+		t.Errorf("recorded Location %q", rec.Response.Header.Get("Location"))
+	}
+}
+
+// TestRecordUnreadBody checks that the request body is recorded when the
+// handler does not read it, up to maxRecordBody.
+func TestRecordUnreadBody(t *testing.T) {
+	h := newRecordingServer(t)
+	setForTest(t, &maxRecordBody, 8)
+
+	tests := []struct {
+		body      string
+		recorded  string
+		truncated bool
+	}{
+		{"short", "short", false},
+		{"12345678", "12345678", false},
+		{"123456789", "12345678", true},
+		{strings.Repeat("x", 1000), "xxxxxxxx", true},
+	}
+	for i, tt := range tests {
+		wantCode(t, serve(t, h, http.MethodDelete, "/books", tt.body), http.StatusMethodNotAllowed)
+		got := readRecording(t, i+1).Request
+		if got.Body != tt.recorded || got.Truncated != tt.truncated {
+			t.Errorf("body %q recorded as %q, truncated %v; want %q, truncated %v", tt.body, got.Body, got.Truncated, tt.recorded, tt.truncated)
+		}
+	}
+}
+
+func TestRecordingRing(t *testing.T) {
+	h := newRecordingServer(t)
+	setForTest(t, &maxRecordings, 3)
+
+	for range 5 {
+		serve(t, h, http.MethodGet, "/books", nil)
+	}
+	if got := mustRecordingNumbers(t); !slices.Equal(got, []int{3, 4, 5}) {
+		t.Errorf("recordings %v after 5 requests, want [3 4 5]", got)
+	}
+
+	// Reopened with a lower maximum, the numbering continues and the
+	// recordings past the maximum are dropped at the next write.
+	if err := openRecordings(); err != nil {
+		t.Fatal(err)
+	}
+	maxRecordings = 1
+	serve(t, h, http.MethodGet, "/books", nil)
+	if got := mustRecordingNumbers(t); !slices.Equal(got, []int{6}) {
+		t.Errorf("recordings %v, want [6]", got)
+	}
+}
+
+func mustRecordingNumbers(t *testing.T) []int {
+	t.Helper()
+	numbers, err := recordingNumbers()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return numbers
+}
+
+// TestRecordRedactsAPIKey checks that the API key of a merge source is
+// left out of the recorded request body, even one cut short.
+func TestRecordRedactsAPIKey(t *testing.T) {
+	h := newRecordingServer(t)
+	body := `{"url": "http://127.0.0.1:1", "api_key": "s3cr\"et"}`
+	serve(t, h, http.MethodPost, "/admin/merge", body)
+
+	got := readRecording(t, 1).Request
+	if want := `{"url": "http://127.0.0.1:1", "api_key": "[redacted]"}`; got.Body != want || got.Size != len(body) {
+		t.Errorf("recorded request body %q of %d bytes, want %q of %d", got.Body, got.Size, want, len(body))
+	}
+
+	cut := newRecordedBody([]byte(`{"api_key":"s3cr`), 40)
+	if cut.Body != `{"api_key":"[redacted]"` || !cut.Truncated {
+		t.Errorf("recorded truncated body %+v", cut)
+	}
+}
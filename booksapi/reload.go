@@ -0,0 +1,318 @@
+package booksapi
+
+import (
+	"bufio"
+	"context"
+	"flag"
+	"fmt"
+	"net/http"
+	"os"
+	"os/signal"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+)
+
+// configFile names a file of flag settings, one "name=value" line per flag,
+// read at startup for the flags the command line does not set and again on
+// every reload. It is configured by a flag in Main.
+var configFile string
+
+// runtimeConfig holds the settings that can change while the server runs.
+// A reload replaces it as a whole, so a request sees either the old
+// settings or the new ones, never a mix.
+type runtimeConfig struct {
+	debug          bool
+	paging         PagingPolicy
+	requestTimeout time.Duration
+	tokens         []apiToken
+	routePolicies  []*routePolicy
+}
+
+// liveConfig is the runtime configuration in effect, replaced by
+// reloadConfig.
+var liveConfig atomic.Pointer[runtimeConfig]
+
+// Reload state, set up by Main and New. configBase holds the reloadable
+// settings as the defaults and the command line give them, before the
+// config file, and fixedTokens the tokens that do not come from the tokens
+// file, such as -admin-token.
+var (
+	configBase       runtimeConfig
+	fixedTokens      []apiToken
+	commandLineFlags = make(map[string]bool)
+	reloadMu         sync.Mutex
+)
+
+// reloadableFlags are the flags a reload applies from the config file, each
+// with the function setting it in a runtime configuration. Other flags,
+// such as -storage or the listen address, only take effect at startup.
+var reloadableFlags = map[string]func(c *runtimeConfig, value string) error{
+	"debug": func(c *runtimeConfig, value string) (err error) {
+		c.debug, err = strconv.ParseBool(value)
+		return err
+	},
+	"default-page-size": func(c *runtimeConfig, value string) (err error) {
+		c.paging.Default, err = strconv.Atoi(value)
+		return err
+	},
+	"max-page-size": func(c *runtimeConfig, value string) (err error) {
+		c.paging.Max, err = strconv.Atoi(value)
+		return err
+	},
+	"request-timeout": func(c *runtimeConfig, value string) (err error) {
+		c.requestTimeout, err = time.ParseDuration(value)
+		return err
+	},
+}
+
+// currentConfig returns the runtime configuration in effect, or the one
+// the flags give before Main or New has set it up.
+func currentConfig() *runtimeConfig {
+	if c := liveConfig.Load(); c != nil {
+		return c
+	}
+	return flagConfig()
+}
+
+// flagConfig returns the runtime configuration the flags and the files
+// they name were loaded into.
+func flagConfig() *runtimeConfig {
+	return &runtimeConfig{
+		debug:          debugLog,
+		paging:         defaultPaging,
+		requestTimeout: requestTimeout,
+		tokens:         apiTokens,
+		routePolicies:  routePolicies,
+	}
+}
+
+// configSetting is a line of a config file.
+type configSetting struct {
+	line  int
+	name  string
+	value string
+}
+
+// readConfigFile reads a config file: "name=value" lines naming flags
+// without their dash, e.g. "max-page-size=200". Blank lines and lines
+// starting with # are ignored.
+func readConfigFile(path string) ([]configSetting, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var settings []configSetting
+	scanner := bufio.NewScanner(f)
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+		name, value, ok := strings.Cut(text, "=")
+		name, value = strings.TrimSpace(name), strings.TrimSpace(value)
+		if !ok || name == "" {
+			return nil, fmt.Errorf("%s:%d: want name=value", path, line)
+		}
+		if flag.Lookup(name) == nil {
+			return nil, fmt.Errorf("%s:%d: unknown flag %q", path, line, name)
+		}
+		settings = append(settings, configSetting{line, name, value})
+	}
+	return settings, scanner.Err()
+}
+
+// applyConfigFile sets the flags of the config file the command line left
+// unset, after recording which ones it set and the reloadable settings
+// they give. Main calls it right after parsing the command line.
+func applyConfigFile() error {
+	flag.Visit(func(f *flag.Flag) { commandLineFlags[f.Name] = true })
+	configBase = *flagConfig()
+	if configFile == "" {
+		return nil
+	}
+	settings, err := readConfigFile(configFile)
+	if err != nil {
+		return err
+	}
+	for _, s := range settings {
+		if commandLineFlags[s.name] {
+			continue
+		}
+		if err := flag.Set(s.name, s.value); err != nil {
+			return fmt.Errorf("%s:%d: %v", configFile, s.line, err)
+		}
+	}
+	return nil
+}
+
+// reloadReport is the response body of POST /admin/reload and what a
+// reload logs.
+type reloadReport struct {
+	Changed []configChange `json:"changed"`
+	Ignored []configChange `json:"ignored"`
+}
+
+// configChange is a setting a reload changed, with its old and new values,
+// or ignored, with the reason.
+type configChange struct {
+	Setting string `json:"setting"`
+	Before  string `json:"before,omitempty"`
+	After   string `json:"after,omitempty"`
+	Reason  string `json:"reason,omitempty"`
+}
+
+// reloadConfig re-reads the config file, the tokens file, and the route
+// policies file, and puts the settings that can change at runtime into
+// effect at once. Settings the config file changes for flags that are not
+// reloadable, or that the command line sets, are reported as ignored. On
+// any error the configuration in effect is kept.
+func reloadConfig() (reloadReport, error) {
+	reloadMu.Lock()
+	defer reloadMu.Unlock()
+
+	next := configBase
+	report := reloadReport{Changed: []configChange{}, Ignored: []configChange{}}
+	if configFile != "" {
+		settings, err := readConfigFile(configFile)
+		if err != nil {
+			return reloadReport{}, err
+		}
+		for _, s := range settings {
+			apply, reloadable := reloadableFlags[s.name]
+			switch {
+			case commandLineFlags[s.name]:
+				report.Ignored = append(report.Ignored, configChange{Setting: s.name, Reason: "set on the command line"})
+			case reloadable:
+				if err := apply(&next, s.value); err != nil {
+					return reloadReport{}, fmt.Errorf("%s:%d: invalid value %q for %s: %v", configFile, s.line, s.value, s.name, err)
+				}
+			case s.value != flag.Lookup(s.name).Value.String():
+				report.Ignored = append(report.Ignored, configChange{Setting: s.name, Reason: "only takes effect at startup"})
+			}
+		}
+	}
+
+	next.tokens = fixedTokens
+	if tokensFile != "" {
+		tokens, err := loadTokens(tokensFile)
+		if err != nil {
+			return reloadReport{}, err
+		}
+		next.tokens = append(tokens, fixedTokens...)
+	}
+	next.routePolicies = nil
+	if routePoliciesFile != "" {
+		policies, err := loadRoutePolicies(routePoliciesFile)
+		if err != nil {
+			return reloadReport{}, err
+		}
+		next.routePolicies = policies
+	}
+
+	prev := currentConfig()
+	if describePolicies(prev.routePolicies) == describePolicies(next.routePolicies) {
+		// Unchanged policies are kept, with the requests they are counting.
+		next.routePolicies = prev.routePolicies
+	}
+	report.Changed = configChanges(prev, &next)
+	liveConfig.Store(&next)
+	// Cached responses were paged and authorized under the old settings.
+	invalidateResponseCache()
+	for _, c := range report.Changed {
+		logger.Printf("config reload: %s changed from %s to %s", c.Setting, c.Before, c.After)
+	}
+	for _, c := range report.Ignored {
+		logger.Printf("config reload: %s ignored: %s", c.Setting, c.Reason)
+	}
+	if len(report.Changed) == 0 {
+		logger.Printf("config reload: nothing changed")
+	}
+	return report, nil
+}
+
+// configChanges lists the settings that differ between prev and next.
+// Tokens are summarized by their number, so secrets never reach the log.
+func configChanges(prev, next *runtimeConfig) []configChange {
+	changes := []configChange{}
+	add := func(setting string, before, after any) {
+		b, a := fmt.Sprint(before), fmt.Sprint(after)
+		if b != a {
+			changes = append(changes, configChange{Setting: setting, Before: b, After: a})
+		}
+	}
+	add("debug", prev.debug, next.debug)
+	add("default-page-size", prev.paging.Default, next.paging.Default)
+	add("max-page-size", prev.paging.Max, next.paging.Max)
+	add("request-timeout", prev.requestTimeout, next.requestTimeout)
+	if !slices.EqualFunc(prev.tokens, next.tokens, func(a, b apiToken) bool {
+		return a.secret == b.secret && slices.Equal(a.scopes, b.scopes)
+	}) {
+		changes = append(changes, configChange{
+			Setting: "tokens",
+			Before:  fmt.Sprintf("%d tokens", len(prev.tokens)),
+			After:   fmt.Sprintf("%d tokens (changed)", len(next.tokens)),
+		})
+	}
+	add("route-policies", describePolicies(prev.routePolicies), describePolicies(next.routePolicies))
+	return changes
+}
+
+// describePolicies describes route policies in reload reports.
+func describePolicies(policies []*routePolicy) string {
+	descriptions := make([]string, len(policies))
+	for i, p := range policies {
+		descriptions[i] = p.String()
+	}
+	return "[" + strings.Join(descriptions, "; ") + "]"
+}
+
+// adminReloadHandler reloads the configuration, as SIGHUP does, and
+// reports what changed (POST /admin/reload). A reload failing, e.g. on a
+// malformed config file, keeps the configuration in effect and answers
+// 422.
+func adminReloadHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeError(w, r, http.StatusMethodNotAllowed, "method_not_allowed")
+		return
+	}
+	if _, ok := checkQuery(w, r); !ok {
+		return
+	}
+	report, err := reloadConfig()
+	if err != nil {
+		logger.Printf("config reload failed: %v", err)
+		writeError(w, r, http.StatusUnprocessableEntity, "reload_failed", "reason", err.Error())
+		return
+	}
+	writeJSON(w, http.StatusOK, report)
+}
+
+// reloader is the Component reloading the configuration on SIGHUP.
+type reloader struct{}
+
+func (reloader) Start(ctx context.Context) error {
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	defer signal.Stop(hup)
+	for {
+		select {
+		case <-hup:
+			if _, err := reloadConfig(); err != nil {
+				logger.Printf("config reload failed: %v", err)
+			}
+		case <-ctx.Done():
+			return nil
+		}
+	}
+}
+
+func (reloader) Shutdown(context.Context) error {
+	return nil
+}
@@ -0,0 +1,67 @@
+package booksapi
+
+import (
+	"flag"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// TestReload changes the config file, reloads it, and checks the new
+// setting takes effect at once, the cached responses included.
+func TestReload(t *testing.T) {
+	h := newTestServer(t)
+	path := filepath.Join(t.TempDir(), "books.conf")
+	setForTest(t, &configFile, path)
+	// Main defines the flags a config file may name.
+	if flag.Lookup("default-page-size") == nil {
+		flag.Int("default-page-size", defaultPaging.Default, "")
+	}
+	for _, title := range []string{"Dune", "Emma", "Beloved"} {
+		mustCreateBook(t, h, map[string]any{"title": title})
+	}
+	writeConfig := func(config string) {
+		t.Helper()
+		if err := os.WriteFile(path, []byte(config), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	listed := func() int {
+		t.Helper()
+		rec := serve(t, h, http.MethodGet, "/books", nil)
+		wantCode(t, rec, http.StatusOK)
+		return len(decode[[]Book](t, rec))
+	}
+
+	writeConfig("")
+	wantCode(t, serve(t, h, http.MethodPost, "/admin/reload", nil), http.StatusOK)
+	if n := listed(); n != 3 {
+		t.Fatalf("listed %d books, want 3", n)
+	}
+	listed() // cached
+
+	// Reloaded on SIGHUP, outside any request.
+	writeConfig("# fewer books per page\ndefault-page-size=2\n")
+	report, err := reloadConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(report.Changed) != 1 || report.Changed[0].Setting != "default-page-size" {
+		t.Errorf("reload changed %+v, want default-page-size", report.Changed)
+	}
+	if n := listed(); n != 2 {
+		t.Errorf("listed %d books after the reload, want 2", n)
+	}
+
+	// A malformed config file keeps the settings in effect.
+	writeConfig("default-page-size=many\n")
+	rec := serve(t, h, http.MethodPost, "/admin/reload", nil)
+	wantCode(t, rec, http.StatusUnprocessableEntity)
+	if got := errorCode(t, rec); got != "reload_failed" {
+		t.Errorf("error code %q, want reload_failed", got)
+	}
+	if n := listed(); n != 2 {
+		t.Errorf("listed %d books after a failed reload, want 2", n)
+	}
+}
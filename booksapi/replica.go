@@ -0,0 +1,271 @@
+package booksapi
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"expvar"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Replica settings, configured by flags in Main. A server started with
+// -replica-of is a read-only replica of the primary at that URL.
+var (
+	replicaOf    string
+	replicaToken string // bearer token sent to the primary, if any
+	replicaPoll  = time.Second
+)
+
+// primaryHeader carries the primary's URL in the responses refusing writes
+// to a replica.
+const primaryHeader = "X-Primary-URL"
+
+// replicaMetrics counts the work of the replica, published under
+// /debug/vars with its lag.
+var replicaMetrics = expvar.NewMap("replica")
+
+// replicaClient sends the requests pulling changes from the primary; tests
+// can replace it.
+var replicaClient httpDoer = &http.Client{Timeout: 30 * time.Second}
+
+// replica follows the primary when this server is a replica; it is nil
+// otherwise.
+var replica *replicaFollower
+
+// errChangesExpired reports that the primary no longer knows the changes
+// since the replica's sequence number, so it must fetch the whole catalog.
+var errChangesExpired = errors.New("changes expired on the primary")
+
+// replicaFollower keeps the store a copy of the primary's catalog by
+// polling its GET /books/changes.
+type replicaFollower struct {
+	primary string    // base URL, without a trailing slash
+	started time.Time // when following began, the lag before the first sync
+
+	mu       sync.Mutex
+	seq      int64     // the primary's sequence number caught up to; 0 before the first sync
+	syncedAt time.Time // when the replica last caught up with the primary
+	resyncs  int
+	lastErr  string
+}
+
+// replicaStatus reports a replica's progress in GET /healthz and
+// GET /readyz. The lag is the time since the replica last caught up with
+// the primary, or since it started before it first has.
+type replicaStatus struct {
+	Primary    string     `json:"primary"`
+	Seq        int64      `json:"seq"`
+	SyncedAt   *time.Time `json:"synced_at,omitempty"`
+	LagSeconds float64    `json:"lag_seconds"`
+	Resyncs    int        `json:"resyncs"`
+	Error      string     `json:"error,omitempty"` // of the last poll, if it failed
+}
+
+// replicaBook is a book as the primary's change feed sends it, which may
+// carry the title under its new name only.
+type replicaBook struct {
+	Book
+	Name string `json:"name"`
+}
+
+// replicaChanges is the response body of the primary's GET /books/changes.
+type replicaChanges struct {
+	Changed []replicaBook `json:"changed"`
+	Deleted []int         `json:"deleted"`
+	Seq     int64         `json:"seq"`
+}
+
+// startReplica checks the primary's URL and starts following it until ctx
+// is done. The first poll fetches the whole catalog.
+func startReplica(ctx context.Context, primary string) (*replicaFollower, error) {
+	base, err := url.Parse(primary)
+	if err != nil || (base.Scheme != "http" && base.Scheme != "https") || base.Host == "" {
+		return nil, fmt.Errorf("invalid primary URL %q", primary)
+	}
+	f := &replicaFollower{primary: strings.TrimSuffix(base.String(), "/"), started: time.Now()}
+	expvar.Publish("replica_lag_seconds", expvar.Func(func() any { return f.Status().LagSeconds }))
+	go f.run(ctx)
+	return f, nil
+}
+
+// run polls the primary every replicaPoll until ctx is done.
+func (f *replicaFollower) run(ctx context.Context) {
+	for {
+		err := f.poll(ctx)
+		f.mu.Lock()
+		f.lastErr = ""
+		if err != nil {
+			f.lastErr = err.Error()
+		}
+		f.mu.Unlock()
+		if err != nil && ctx.Err() == nil {
+			replicaMetrics.Add("errors", 1)
+			logger.Printf("replica: %v", err)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(replicaPoll):
+		}
+	}
+}
+
+// poll applies the changes made on the primary since the last poll, or
+// the whole catalog on the first poll and when the primary answers 410.
+func (f *replicaFollower) poll(ctx context.Context) error {
+	f.mu.Lock()
+	seq := f.seq
+	f.mu.Unlock()
+
+	full := seq == 0
+	changes, err := f.fetch(ctx, seq)
+	if errors.Is(err, errChangesExpired) {
+		replicaMetrics.Add("resyncs", 1)
+		f.mu.Lock()
+		f.resyncs++
+		f.mu.Unlock()
+		full = true
+		changes, err = f.fetch(ctx, 0)
+	}
+	if err != nil {
+		return err
+	}
+	if err := applyReplicaChanges(ctx, changes, full); err != nil {
+		return fmt.Errorf("applying changes: %w", err)
+	}
+	replicaMetrics.Add("polls", 1)
+
+	f.mu.Lock()
+	f.seq = changes.Seq
+	f.syncedAt = time.Now().UTC()
+	f.mu.Unlock()
+	return nil
+}
+
+// fetch requests the changes since seq from the primary, or the whole
+// catalog for seq 0. Descriptions are asked for, as listings leave them
+// out by default.
+func (f *replicaFollower) fetch(ctx context.Context, seq int64) (replicaChanges, error) {
+	query := url.Values{"include": {"description"}}
+	if seq != 0 {
+		query.Set("since", strconv.FormatInt(seq, 10))
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, f.primary+"/books/changes?"+query.Encode(), nil)
+	if err != nil {
+		return replicaChanges{}, err
+	}
+	req.Header.Set("Accept", "application/json")
+	req.Header.Set("User-Agent", "books-api/"+buildVersion().Version)
+	if replicaToken != "" {
+		req.Header.Set("Authorization", "Bearer "+replicaToken)
+	}
+
+	resp, err := replicaClient.Do(req)
+	if err != nil {
+		return replicaChanges{}, fmt.Errorf("primary unreachable: %w", err)
+	}
+	defer resp.Body.Close()
+	switch resp.StatusCode {
+	case http.StatusOK:
+	case http.StatusGone:
+		return replicaChanges{}, errChangesExpired
+	default:
+		return replicaChanges{}, fmt.Errorf("primary answered %s", resp.Status)
+	}
+
+	var changes replicaChanges
+	if err := json.NewDecoder(io.LimitReader(resp.Body, maxImportBytes)).Decode(&changes); err != nil {
+		return replicaChanges{}, fmt.Errorf("invalid response from the primary: %w", err)
+	}
+	return changes, nil
+}
+
+// applyReplicaChanges writes the changes pulled from the primary to the
+// store in a single transaction. A full copy of the catalog also deletes
+// the books the primary no longer has. Books are stored as the primary
+// sent them, since it validated them already.
+func applyReplicaChanges(ctx context.Context, changes replicaChanges, full bool) error {
+	mu.Lock()
+	defer mu.Unlock()
+
+	err := store.Transact(ctx, func(tx BookStore) error {
+		if full {
+			keep := make(map[int]bool, len(changes.Changed))
+			for _, book := range changes.Changed {
+				keep[book.ID] = true
+			}
+			for _, book := range tx.List(ListOptions{}) {
+				if !keep[book.ID] {
+					if err := tx.Delete(book.ID); err != nil {
+						return err
+					}
+				}
+			}
+		}
+		for _, changed := range changes.Changed {
+			book := changed.Book
+			if book.Title == "" {
+				book.Title = changed.Name
+			}
+			if err := tx.Put(book); err != nil {
+				return err
+			}
+		}
+		for _, id := range changes.Deleted {
+			if err := tx.Delete(id); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err == nil && (full || len(changes.Changed) > 0 || len(changes.Deleted) > 0) {
+		invalidateResponseCache()
+	}
+	return err
+}
+
+// Status reports the replica's progress.
+func (f *replicaFollower) Status() replicaStatus {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	status := replicaStatus{Primary: f.primary, Seq: f.seq, Resyncs: f.resyncs, Error: f.lastErr}
+	since := f.started
+	if !f.syncedAt.IsZero() {
+		synced := f.syncedAt
+		status.SyncedAt = &synced
+		since = synced
+	}
+	status.LagSeconds = time.Since(since).Seconds()
+	return status
+}
+
+// synced reports whether the replica has copied the primary's catalog at
+// least once.
+func (f *replicaFollower) synced() bool {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return !f.syncedAt.IsZero()
+}
+
+// refuseReplicaWrites answers every request but reads with 421 on a
+// replica, naming the primary that takes them in the X-Primary-URL header.
+func refuseReplicaWrites(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet, http.MethodHead, http.MethodOptions:
+			next.ServeHTTP(w, r)
+			return
+		}
+		w.Header().Set(primaryHeader, replica.primary)
+		writeError(w, r, http.StatusMisdirectedRequest, "read_only_replica", "primary", replica.primary)
+	})
+}
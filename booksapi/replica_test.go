@@ -0,0 +1,198 @@
+package booksapi
+
+import (
+	"context"
+	"expvar"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+)
+
+// recordedPrimary serves the responses of a primary's GET /books/changes
+// recorded earlier, keyed by ?since=, and 410 for any other since, as a
+// primary does once it no longer remembers the changes.
+type recordedPrimary struct {
+	mu    sync.Mutex
+	feeds map[string][]byte
+}
+
+func (p *recordedPrimary) record(t *testing.T, h http.Handler, since string) {
+	t.Helper()
+	path := "/books/changes?include=description"
+	if since != "" {
+		path += "&since=" + since
+	}
+	rec := serve(t, h, http.MethodGet, path, nil)
+	wantCode(t, rec, http.StatusOK)
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.feeds[since] = rec.Body.Bytes()
+}
+
+func (p *recordedPrimary) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path != "/books/changes" || r.Header.Get("Authorization") != "Bearer k1" || r.URL.Query().Get("include") != "description" {
+		http.NotFound(w, r)
+		return
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	feed, ok := p.feeds[r.URL.Query().Get("since")]
+	if !ok {
+		http.Error(w, "expired", http.StatusGone)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(feed)
+}
+
+// replicaCount returns the count of kind in replicaMetrics.
+func replicaCount(kind string) int64 {
+	if v, ok := replicaMetrics.Get(kind).(*expvar.Int); ok {
+		return v.Value()
+	}
+	return 0
+}
+
+// TestReplica records the change feed of a primary through its history,
+// then has a replica follow it: the replica copies the catalog, applies
+// the later changes, refuses writes naming the primary, and copies the
+// whole catalog again when the primary answers 410.
+func TestReplica(t *testing.T) {
+	primary := &recordedPrimary{feeds: make(map[string][]byte)}
+	h := newTestServer(t)
+	mustCreateBook(t, h, map[string]any{"name": "Dune", "price": 9.99, "description": "Spice"})
+	mustCreateBook(t, h, map[string]any{"name": "Emma", "price": 7.99})
+	primary.record(t, h, "")
+	first := decode[replicaChanges](t, serve(t, h, http.MethodGet, "/books/changes", nil)).Seq
+	wantCode(t, serve(t, h, http.MethodPut, "/books/1", map[string]any{"name": "Dune", "price": 12.5, "description": "Spice"}), http.StatusOK)
+	wantCode(t, serve(t, h, http.MethodDelete, "/books/2", nil), http.StatusNoContent)
+	mustCreateBook(t, h, map[string]any{"name": "Hyperion"})
+	primary.record(t, h, strconv.FormatInt(first, 10))
+	latest := catalogJSON(t, store)
+	full := serve(t, h, http.MethodGet, "/books/changes?include=description", nil).Body.Bytes()
+
+	server := httptest.NewServer(primary)
+	t.Cleanup(server.Close)
+	resetState(t)
+	setForTest(t, &replicaClient, httpDoer(server.Client()))
+	setForTest(t, &replicaToken, "k1")
+	f := &replicaFollower{primary: server.URL, started: time.Now()}
+	replica = f
+	h = New()
+	ctx := context.Background()
+
+	rec := serve(t, h, http.MethodGet, "/readyz", nil)
+	wantCode(t, rec, http.StatusServiceUnavailable)
+	if got := decode[readiness](t, rec); got.Status != "syncing" || got.Replica == nil || got.Replica.SyncedAt != nil {
+		t.Errorf("readiness before the first copy %+v", got)
+	}
+
+	if err := f.poll(ctx); err != nil {
+		t.Fatal(err)
+	}
+	wantCode(t, serve(t, h, http.MethodGet, "/readyz", nil), http.StatusOK)
+	book := decode[Book](t, serve(t, h, http.MethodGet, "/books/1?include=description", nil))
+	if book.Title != "Dune" || book.Price != 9.99 || book.Description != "Spice" {
+		t.Errorf("copied book %+v", book)
+	}
+	wantCode(t, serve(t, h, http.MethodGet, "/books/2", nil), http.StatusOK)
+
+	if err := f.poll(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if got := catalogJSON(t, store); got != latest {
+		t.Errorf("replica holds %s, want the primary's %s", got, latest)
+	}
+	wantCode(t, serve(t, h, http.MethodGet, "/books/2", nil), http.StatusNotFound)
+	invalidateResponseCache()
+	if got := decode[Book](t, serve(t, h, http.MethodGet, "/books/1", nil)).Price; got != 12.5 {
+		t.Errorf("price %v after the update, want 12.5", got)
+	}
+
+	health := decode[healthReport](t, serve(t, h, http.MethodGet, "/healthz", nil))
+	if s := health.Replica; s == nil || s.Primary != server.URL || s.Seq == 0 || s.SyncedAt == nil || s.LagSeconds > 5 || s.Error != "" {
+		t.Errorf("replica health %+v", s)
+	}
+
+	for _, req := range []struct{ method, path string }{
+		{http.MethodPost, "/books"},
+		{http.MethodPut, "/books/1"},
+		{http.MethodPatch, "/books/1"},
+		{http.MethodDelete, "/books/1"},
+		{http.MethodPost, "/books/import"},
+		{http.MethodPost, "/admin/snapshot"},
+	} {
+		rec := serve(t, h, req.method, req.path, map[string]any{"name": "Jazz"})
+		wantCode(t, rec, http.StatusMisdirectedRequest)
+		if got := rec.Header().Get(primaryHeader); got != server.URL {
+			t.Errorf("%s %s: %s %q, want the primary", req.method, req.path, primaryHeader, got)
+		}
+		if got := errorCode(t, rec); got != "read_only_replica" {
+			t.Errorf("%s %s: error code %q", req.method, req.path, got)
+		}
+	}
+	if got := store.Count(); got != 2 {
+		t.Errorf("%d books after refused writes, want 2", got)
+	}
+
+	// The primary forgot the changes since the replica's sequence number:
+	// the replica copies the whole catalog again, dropping what it no
+	// longer has.
+	resyncs := replicaCount("resyncs")
+	if err := store.Put(Book{ID: 99, Title: "Stray"}); err != nil {
+		t.Fatal(err)
+	}
+	primary.mu.Lock()
+	primary.feeds[""] = full
+	primary.mu.Unlock()
+	if err := f.poll(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if got := replicaCount("resyncs") - resyncs; got != 1 || f.Status().Resyncs != 1 {
+		t.Errorf("%d resyncs counted, status %+v; want 1", got, f.Status())
+	}
+	if got := catalogJSON(t, store); got != latest {
+		t.Errorf("replica holds %s after the resync, want the primary's %s", got, latest)
+	}
+	if _, found := store.Get(99); found {
+		t.Error("book the primary does not have kept after the resync")
+	}
+}
+
+// TestReplicaPrimaryDown checks that a failed poll is reported in the
+// status, and leaves the replica's copy as it was.
+func TestReplicaPrimaryDown(t *testing.T) {
+	server := httptest.NewServer(http.NotFoundHandler())
+	server.Close()
+	resetState(t)
+	setForTest(t, &replicaClient, httpDoer(server.Client()))
+	f := &replicaFollower{primary: server.URL, started: time.Now().Add(-time.Minute)}
+	replica = f
+	h := New()
+	if err := store.Put(Book{ID: 1, Title: "Dune"}); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		f.run(ctx)
+		close(done)
+	}()
+	deadline := time.Now().Add(5 * time.Second)
+	for f.Status().Error == "" && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+	cancel()
+	<-done
+
+	status := decode[healthReport](t, serve(t, h, http.MethodGet, "/healthz", nil)).Replica
+	if status == nil || status.Error == "" || status.Seq != 0 || status.SyncedAt != nil || status.LagSeconds < 60 {
+		t.Errorf("replica status %+v, want the error and the lag since it started", status)
+	}
+	wantCode(t, serve(t, h, http.MethodGet, "/readyz", nil), http.StatusServiceUnavailable)
+	wantCode(t, serve(t, h, http.MethodGet, "/books/1", nil), http.StatusOK)
+}
@@ -0,0 +1,157 @@
+package booksapi
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"slices"
+	"time"
+)
+
+// ID reservation settings, configured by flags in Main.
+var (
+	idReservationTTL = 24 * time.Hour
+	maxReservedIDs   = 1000 // IDs a single POST /books/ids/reserve may reserve
+)
+
+// idReservation is a block of IDs handed out to a client by
+// POST /books/ids/reserve, for books it creates before it can reach the
+// server. Owner identifies the caller without keeping its token.
+type idReservation struct {
+	First     int       `json:"first"`
+	Last      int       `json:"last"`
+	Owner     string    `json:"owner"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+// reservationResult is the response body of POST /books/ids/reserve.
+type reservationResult struct {
+	First     int       `json:"first"`
+	Last      int       `json:"last"`
+	Count     int       `json:"count"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+// idReservations are the blocks handed out, guarded by mu. Expired blocks
+// are kept for another idReservationTTL so late claims are told they
+// expired rather than that the ID was never reserved. With a journal or
+// snapshot they are saved to reservationsPath, so they survive a restart
+// as the store's ID counter does.
+var (
+	idReservations   []idReservation
+	reservationsPath string
+)
+
+// reserveParams declares the query parameters of POST /books/ids/reserve.
+var reserveParams = []queryParam{{name: "count", kind: intParam}}
+
+// openReservations loads the reservations saved at path, if it is not
+// empty, and saves later changes there.
+func openReservations(path string) error {
+	reservationsPath = path
+	if path == "" {
+		return nil
+	}
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if err := json.Unmarshal(data, &idReservations); err != nil {
+		return fmt.Errorf("reservations %s: %w", path, err)
+	}
+	return nil
+}
+
+// saveReservations writes the reservations to reservationsPath, if set.
+// Callers must hold mu.
+func saveReservations() error {
+	if reservationsPath == "" {
+		return nil
+	}
+	data, err := json.Marshal(idReservations)
+	if err != nil {
+		return err
+	}
+	if err := writeFileAtomic(reservationsPath, data); err != nil {
+		return fmt.Errorf("%w: reservations %s: %w", ErrUnavailable, reservationsPath, err)
+	}
+	return nil
+}
+
+// reservationOwner returns the owner of the reservations made by r: a hash
+// of its token, or "" for callers without one.
+func reservationOwner(r *http.Request) string {
+	secret := callerToken(r).secret
+	if secret == "" {
+		return ""
+	}
+	sum := sha256.Sum256([]byte(secret))
+	return hex.EncodeToString(sum[:])
+}
+
+// reserveIDs hands out a contiguous block of ?count= IDs (default 1), which
+// Create never assigns, for the caller to give the books it creates with
+// POST /books before the block expires (POST /books/ids/reserve). IDs a
+// block leaves unused are never handed out again.
+func reserveIDs(w http.ResponseWriter, r *http.Request) {
+	q, ok := checkQuery(w, r, reserveParams...)
+	if !ok {
+		return
+	}
+	count := 1
+	if q.Has("count") {
+		count = q.Int("count")
+	}
+	if count < 1 || count > maxReservedIDs {
+		writeError(w, r, http.StatusBadRequest, "invalid_query_parameter", "name", "count", "expected", fmt.Sprintf("integer from 1 to %d", maxReservedIDs))
+		return
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+
+	now := time.Now().UTC()
+	res := idReservation{First: store.NextID(), Owner: reservationOwner(r), ExpiresAt: now.Add(idReservationTTL)}
+	res.Last = res.First + count - 1
+	if err := store.Reserve(res.Last); err != nil {
+		writeAPIError(w, r, err)
+		return
+	}
+	idReservations = slices.DeleteFunc(idReservations, func(old idReservation) bool {
+		return now.After(old.ExpiresAt.Add(idReservationTTL))
+	})
+	idReservations = append(idReservations, res)
+	if err := saveReservations(); err != nil {
+		writeAPIError(w, r, err)
+		return
+	}
+	writeJSON(w, http.StatusCreated, reservationResult{First: res.First, Last: res.Last, Count: count, ExpiresAt: res.ExpiresAt})
+}
+
+// checkReservedID verifies that the caller of r may create a book with the
+// given ID: one of its reserved IDs, before the block expired, not used by
+// a book yet. It fails with 409 otherwise, or 410 if the block expired.
+// Callers must hold mu.
+func checkReservedID(r *http.Request, id int) error {
+	owner := reservationOwner(r)
+	for _, res := range idReservations {
+		if id < res.First || id > res.Last || res.Owner != owner {
+			continue
+		}
+		if time.Now().After(res.ExpiresAt) {
+			return newAPIError(http.StatusGone, "id_reservation_expired", "id", id, "expired_at", res.ExpiresAt.Format(time.RFC3339))
+		}
+		if _, found := store.Get(id); found || isArchived(id) {
+			return newAPIError(http.StatusConflict, "id_not_reserved", "id", id)
+		}
+		return nil
+	}
+	return newAPIError(http.StatusConflict, "id_not_reserved", "id", id)
+}
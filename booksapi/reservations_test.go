@@ -0,0 +1,185 @@
+package booksapi
+
+import (
+	"net/http"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+)
+
+// reserve reserves count IDs for the caller of header and returns the
+// block.
+func reserve(t *testing.T, h http.Handler, count string, header ...string) reservationResult {
+	t.Helper()
+	rec := serve(t, h, http.MethodPost, "/books/ids/reserve?count="+count, nil, header...)
+	wantCode(t, rec, http.StatusCreated)
+	return decode[reservationResult](t, rec)
+}
+
+// TestReserveIDs checks that reserved IDs are skipped by the store, can be
+// given to new books by the caller that reserved them until they expire,
+// and only once.
+func TestReserveIDs(t *testing.T) {
+	h := newTestServer(t)
+	mustCreateBook(t, h, map[string]any{"title": "Dune"})
+	withTokensFile(t)
+	w1 := []string{"Authorization", "Bearer w1"}
+	a1 := []string{"Authorization", "Bearer a1"}
+	create := func(title string, header []string) int {
+		t.Helper()
+		rec := serve(t, h, http.MethodPost, "/books", map[string]any{"title": title}, header...)
+		wantCode(t, rec, http.StatusCreated)
+		return decode[Book](t, rec).ID
+	}
+
+	before := time.Now()
+	block := reserve(t, h, "10", w1...)
+	if block.First != 2 || block.Last != 11 || block.Count != 10 {
+		t.Fatalf("reserved %+v, want IDs 2 to 11", block)
+	}
+	if want := before.Add(idReservationTTL); block.ExpiresAt.Before(want) || block.ExpiresAt.After(want.Add(time.Minute)) {
+		t.Errorf("block expires at %v, want %v from now", block.ExpiresAt, idReservationTTL)
+	}
+	if got := create("Emma", a1); got != 12 {
+		t.Errorf("Create assigned ID %d, want 12 after the block", got)
+	}
+
+	rec := serve(t, h, http.MethodPost, "/books", map[string]any{"id": 5, "title": "Hyperion"}, w1...)
+	wantCode(t, rec, http.StatusCreated)
+	if got := decode[Book](t, rec).ID; got != 5 {
+		t.Errorf("created book %d, want the reserved 5", got)
+	}
+	wantCode(t, serve(t, h, http.MethodGet, "/books/5", nil, w1...), http.StatusOK)
+
+	tests := []struct {
+		name   string
+		id     int
+		header []string
+		status int
+		code   string
+	}{
+		{"used", 5, w1, http.StatusConflict, "id_not_reserved"},
+		{"never reserved", 4711, w1, http.StatusConflict, "id_not_reserved"},
+		{"assigned by the store", 1, w1, http.StatusConflict, "id_not_reserved"},
+		{"reserved by another caller", 6, a1, http.StatusConflict, "id_not_reserved"},
+	}
+	for _, tt := range tests {
+		rec := serve(t, h, http.MethodPost, "/books", map[string]any{"id": tt.id, "title": "Jazz"}, tt.header...)
+		wantCode(t, rec, tt.status)
+		if got := decode[errorBody](t, rec).Error; got.Code != tt.code || got.Params["id"] != strconv.Itoa(tt.id) {
+			t.Errorf("%s: error %s %v, want %s", tt.name, got.Code, got.Params, tt.code)
+		}
+	}
+
+	// The block expires; its IDs are refused, and not handed out again.
+	mu.Lock()
+	idReservations[0].ExpiresAt = time.Now().Add(-time.Minute).UTC()
+	mu.Unlock()
+	rec = serve(t, h, http.MethodPost, "/books", map[string]any{"id": 6, "title": "Jazz"}, w1...)
+	wantCode(t, rec, http.StatusGone)
+	if got := decode[errorBody](t, rec).Error; got.Code != "id_reservation_expired" || got.Params["expired_at"] == "" {
+		t.Errorf("expired: error %s %v", got.Code, got.Params)
+	}
+	if got := create("Jazz", w1); got != 13 {
+		t.Errorf("Create assigned ID %d, want 13", got)
+	}
+	if got := reserve(t, h, "1", w1...); got.First != 14 {
+		t.Errorf("next block %+v, want it to start at 14", got)
+	}
+
+	for _, count := range []string{"0", "-1", "1001", "many"} {
+		rec := serve(t, h, http.MethodPost, "/books/ids/reserve?count="+count, nil, w1...)
+		wantCode(t, rec, http.StatusBadRequest)
+		if got := errorCode(t, rec); got != "invalid_query_parameter" {
+			t.Errorf("count=%s: error code %q", count, got)
+		}
+	}
+}
+
+// TestConcurrentReservations checks that reservations made at once, and
+// books created meanwhile, get disjoint IDs.
+func TestConcurrentReservations(t *testing.T) {
+	h := newTestServer(t)
+	const workers, count = 20, 5
+
+	blocks := make([]reservationResult, workers)
+	created := make([]int, workers)
+	var wg sync.WaitGroup
+	for i := range workers {
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			rec := serve(t, h, http.MethodPost, "/books/ids/reserve?count=5", nil)
+			if rec.Code == http.StatusCreated {
+				blocks[i] = decode[reservationResult](t, rec)
+			}
+		}()
+		go func() {
+			defer wg.Done()
+			rec := serve(t, h, http.MethodPost, "/books", map[string]any{"title": "Dune"})
+			if rec.Code == http.StatusCreated {
+				created[i] = decode[Book](t, rec).ID
+			}
+		}()
+	}
+	wg.Wait()
+
+	owner := make(map[int]string)
+	claim := func(id int, by string) {
+		if other, taken := owner[id]; taken {
+			t.Errorf("ID %d given to %s and %s", id, other, by)
+		}
+		owner[id] = by
+	}
+	for i, block := range blocks {
+		if block.Count != count || block.Last-block.First != count-1 {
+			t.Fatalf("block %d: %+v", i, block)
+		}
+		for id := block.First; id <= block.Last; id++ {
+			claim(id, "a reservation")
+		}
+	}
+	for _, id := range created {
+		if id == 0 {
+			t.Fatal("a create failed")
+		}
+		claim(id, "a created book")
+	}
+	if want := workers*count + workers; len(owner) != want || store.NextID() != want+1 {
+		t.Errorf("%d IDs handed out, next %d; want %d", len(owner), store.NextID(), want)
+	}
+}
+
+// TestReservationsRestart checks that reservations made with a journal
+// survive a restart, with the IDs they hold.
+func TestReservationsRestart(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "journal")
+	open := func() http.Handler {
+		j, err := openJournal(newMemoryStore(newSequentialIDs()), path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { j.Close() })
+		h := newTestServer(t, WithStore(j))
+		if err := openReservations(path + ".reservations"); err != nil {
+			t.Fatal(err)
+		}
+		return h
+	}
+
+	h := open()
+	block := reserve(t, h, "3")
+	mustCreateBook(t, h, map[string]any{"title": "Dune"})
+
+	h = open()
+	if len(idReservations) != 1 {
+		t.Fatalf("%d reservations after the restart, want 1", len(idReservations))
+	}
+	rec := serve(t, h, http.MethodPost, "/books", map[string]any{"id": block.Last, "title": "Emma"})
+	wantCode(t, rec, http.StatusCreated)
+	if got := mustCreateBook(t, h, map[string]any{"title": "Hyperion"}).ID; got != block.Last+2 {
+		t.Errorf("Create assigned ID %d after the restart, want %d", got, block.Last+2)
+	}
+}
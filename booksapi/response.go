@@ -0,0 +1,97 @@
+package booksapi
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"reflect"
+	"strconv"
+	"sync"
+)
+
+// maxPooledBufferSize is the largest buffer returned to encoderPool, so a
+// single huge response does not pin its memory forever.
+const maxPooledBufferSize = 1 << 20
+
+// pooledEncoder is a JSON encoder writing into its own reusable buffer.
+type pooledEncoder struct {
+	buf bytes.Buffer
+	enc *json.Encoder
+}
+
+// encoderPool recycles the buffers and encoders used to build responses.
+var encoderPool = sync.Pool{
+	New: func() any {
+		pe := &pooledEncoder{}
+		pe.enc = json.NewEncoder(&pe.buf)
+		return pe
+	},
+}
+
+// writeJSON writes v as a JSON response with the given status code. The body
+// is encoded into a pooled buffer before anything is sent, so an encoding
+// failure produces a clean 500 rather than a truncated 200, and the
+// response carries an exact Content-Length.
+//
+// Collections in response bodies are never null, which strict clients
+// cannot handle: handlers start their slices empty rather than nil, and a
+// nil slice passed as v itself is sent as [].
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	writeJSONAs(w, status, "application/json", v)
+}
+
+// writeJSONAs is writeJSON for a JSON-based media type such as
+// application/problem+json.
+func writeJSONAs(w http.ResponseWriter, status int, contentType string, v any) {
+	writeJSONBody(w, nil, status, contentType, v)
+}
+
+// writeJSONTagged is writeJSON for the 200 response of a read whose result
+// has no version of its own, such as a page of books. The response carries
+// a weak entity tag of its body, and a request whose If-None-Match names
+// that tag gets 304 instead of the body.
+func writeJSONTagged(w http.ResponseWriter, r *http.Request, v any) {
+	writeJSONBody(w, r, http.StatusOK, "application/json", v)
+}
+
+// writeJSONBody is writeJSONAs, tagging the body as writeJSONTagged does
+// if r is not nil.
+func writeJSONBody(w http.ResponseWriter, r *http.Request, status int, contentType string, v any) {
+	pe := encoderPool.Get().(*pooledEncoder)
+	defer func() {
+		if pe.buf.Cap() <= maxPooledBufferSize {
+			pe.buf.Reset()
+			encoderPool.Put(pe)
+		}
+	}()
+
+	pe.buf.Reset()
+	if rv := reflect.ValueOf(v); rv.Kind() == reflect.Slice && rv.IsNil() {
+		v = []struct{}{}
+	}
+	if err := pe.enc.Encode(v); err != nil {
+		switch v.(type) {
+		case errorBody, problemDetails:
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+		default:
+			writeAPIError(w, r, err)
+		}
+		return
+	}
+
+	if r != nil {
+		sum := sha256.Sum256(pe.buf.Bytes())
+		etag := `W/"` + hex.EncodeToString(sum[:8]) + `"`
+		w.Header().Set("ETag", etag)
+		if inm := r.Header.Get("If-None-Match"); inm != "" && etagListMatches(inm, etag, true) {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+	}
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("Content-Length", strconv.Itoa(pe.buf.Len()))
+	w.WriteHeader(status)
+	w.Write(pe.buf.Bytes())
+}
@@ -0,0 +1,174 @@
+package booksapi
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync"
+	"testing"
+)
+
+// failingJSON fails to encode.
+type failingJSON struct{}
+
+func (failingJSON) MarshalJSON() ([]byte, error) { return nil, errors.New("cannot encode") }
+
+// TestWriteJSONEncodeError checks that a value failing to encode gets a 500
+// error response instead of a 200 cut short.
+func TestWriteJSONEncodeError(t *testing.T) {
+	resetState(t)
+	rec := httptest.NewRecorder()
+	writeJSON(rec, http.StatusOK, map[string]any{"book": failingJSON{}})
+	wantCode(t, rec, http.StatusInternalServerError)
+	if got := errorCode(t, rec); got != "internal_error" {
+		t.Errorf("error code %q, want internal_error", got)
+	}
+	if got, want := rec.Header().Get("Content-Length"), strconv.Itoa(rec.Body.Len()); got != want {
+		t.Errorf("Content-Length %s, want %s", got, want)
+	}
+}
+
+// TestWriteJSONPooledBuffers writes different responses at the same time,
+// checking that no response gets the bytes of another through the pooled
+// buffers. Run with -race.
+func TestWriteJSONPooledBuffers(t *testing.T) {
+	var wg sync.WaitGroup
+	for i := range 50 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			want := Book{ID: i + 1, Title: fmt.Sprint("Book ", i)}
+			for range 20 {
+				rec := httptest.NewRecorder()
+				writeJSON(rec, http.StatusOK, want)
+				if got := decode[Book](t, rec); got.ID != want.ID || got.Title != want.Title {
+					t.Errorf("wrote %+v, got %+v", want, got)
+					return
+				}
+				if got := rec.Header().Get("Content-Length"); got != strconv.Itoa(rec.Body.Len()) {
+					t.Errorf("Content-Length %s for a body of %d bytes", got, rec.Body.Len())
+				}
+			}
+		}()
+	}
+	wg.Wait()
+}
+
+// BenchmarkListBooks measures GET /books listing every book of a catalog
+// of 1k and of 100k books, without the response cache; -benchmem shows the
+// allocations of the encode path.
+func BenchmarkListBooks(b *testing.B) {
+	for _, n := range []int{1_000, 100_000} {
+		b.Run(fmt.Sprintf("books=%d", n), func(b *testing.B) {
+			resetState(b)
+			setForTest(b, &defaultPaging, PagingPolicy{})
+			h := New(WithResponseCache(0))
+			for i := range n {
+				book := Book{ID: i + 1, Title: fmt.Sprint("Book ", i), Author: "Author", Price: Price(i % 100)}
+				if err := store.Put(book); err != nil {
+					b.Fatal(err)
+				}
+			}
+			req := httptest.NewRequest(http.MethodGet, "/books", nil)
+
+			b.ReportAllocs()
+			b.ResetTimer()
+			for range b.N {
+				rec := httptest.NewRecorder()
+				h.ServeHTTP(rec, req)
+				if rec.Code != http.StatusOK {
+					b.Fatalf("status %d", rec.Code)
+				}
+			}
+		})
+	}
+}
+
+// jsonNulls returns the paths of the nulls in a decoded JSON value.
+func jsonNulls(v any, path string) []string {
+	switch v := v.(type) {
+	case nil:
+		return []string{path}
+	case map[string]any:
+		var nulls []string
+		for key, value := range v {
+			nulls = append(nulls, jsonNulls(value, path+"."+key)...)
+		}
+		return nulls
+	case []any:
+		var nulls []string
+		for i, value := range v {
+			nulls = append(nulls, jsonNulls(value, fmt.Sprintf("%s[%d]", path, i))...)
+		}
+		return nulls
+	}
+	return nil
+}
+
+// TestNoNullCollections sends the requests of a client starting out, on an
+// empty catalog and then with a book given nothing but a title, and checks
+// that no response holds a null where the client expects a collection.
+func TestNoNullCollections(t *testing.T) {
+	h := newTestServer(t)
+	nilSlice := httptest.NewRecorder()
+	writeJSON(nilSlice, http.StatusOK, []Book(nil))
+	if got := nilSlice.Body.String(); got != "[]\n" {
+		t.Errorf("nil slice sent as %q, want []", got)
+	}
+
+	type request struct {
+		method, path string
+		body         any
+	}
+	requests := []request{
+		{http.MethodGet, "/books", nil},
+		{http.MethodGet, "/books?limit=5", nil},
+		{http.MethodGet, "/books?limit=5&after=1", nil},
+		{http.MethodGet, "/books?include_archived=true", nil},
+		{http.MethodGet, "/books?snapshot=true", nil},
+		{http.MethodGet, "/books/search?q=dune", nil},
+		{http.MethodGet, "/books/stats", nil},
+		{http.MethodGet, "/books/index?by=author", nil},
+		{http.MethodGet, "/books/export", nil},
+		{http.MethodGet, "/books/changes", nil},
+		{http.MethodPost, "/books/diff", []any{}},
+		{http.MethodPost, "/books/import", []any{}},
+		{http.MethodGet, "/shelves", nil},
+		{http.MethodGet, "/publishers", nil},
+		{http.MethodGet, "/series", nil},
+		{http.MethodGet, "/filters", nil},
+		{http.MethodGet, "/admin/data-quality", nil},
+		{http.MethodGet, "/admin/verify", nil},
+		{http.MethodPost, "/admin/merge", []any{}},
+	}
+	check := func() {
+		t.Helper()
+		for _, req := range requests {
+			invalidateResponseCache()
+			rec := serve(t, h, req.method, req.path, req.body)
+			if rec.Code >= 300 {
+				t.Errorf("%s %s: status %d: %s", req.method, req.path, rec.Code, rec.Body)
+				continue
+			}
+			if nulls := jsonNulls(decode[any](t, rec), "$"); len(nulls) > 0 {
+				t.Errorf("%s %s: null at %v: %s", req.method, req.path, nulls, rec.Body)
+			}
+		}
+	}
+	check()
+
+	rec := serve(t, h, http.MethodPost, "/books", map[string]any{"title": "Dune"})
+	wantCode(t, rec, http.StatusCreated)
+	if nulls := jsonNulls(decode[any](t, rec), "$"); len(nulls) > 0 {
+		t.Errorf("created book: null at %v: %s", nulls, rec.Body)
+	}
+	requests = append(requests,
+		request{http.MethodGet, "/books/1", nil},
+		request{http.MethodGet, "/books/1?embed=shelves,publisher,series", nil},
+		request{http.MethodGet, "/books?fields=id,title", nil},
+		request{http.MethodPost, "/books/transactions", map[string]any{"operations": []any{map[string]any{"op": "update", "id": 1, "book": map[string]any{"title": "Dune"}}}}},
+	)
+	check()
+}
@@ -0,0 +1,383 @@
+package booksapi
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Route policy settings, configured by flags in Main. requestTimeout is the
+// timeout of requests no policy gives one; zero means none.
+var (
+	routePoliciesFile string
+	requestTimeout    time.Duration
+	debugLog          bool
+)
+
+// routePolicy limits the requests to the routes matching its pattern. Zero
+// limits are not enforced; a zero body limit leaves the handler's own
+// limit, such as -max-body-bytes or -max-import-bytes, in place.
+type routePolicy struct {
+	method        string // empty matches every method
+	pattern       string // path; "*" matches one segment, a trailing "/" the subtree
+	maxBodyBytes  int64
+	timeout       time.Duration
+	maxConcurrent int
+	slots         chan struct{} // one per request in progress, if maxConcurrent is set
+}
+
+// routePolicies are the policies of the -route-policies file, in file
+// order. The first matching policy applies.
+var routePolicies []*routePolicy
+
+// Request deadline headers. A client may ask for a shorter timeout than its
+// route's with requestTimeoutHeader, which the response echoes with the
+// timeout applied; requestDeadlineHeader tells it the route's own timeout,
+// the longest it may be given.
+const (
+	requestTimeoutHeader  = "X-Request-Timeout"
+	requestDeadlineHeader = "X-Request-Deadline"
+)
+
+// routePolicyKey is the context key of the policy applied to a request.
+type routePolicyKey struct{}
+
+// deadlineAnswerKey is the context key of the flag a handler sets with
+// answerDeadline.
+type deadlineAnswerKey struct{}
+
+// deadlineGrace is how long serveWithTimeout waits past the timeout for a
+// handler that answers it itself before sending 504 anyway.
+const deadlineGrace = 5 * time.Second
+
+// answerDeadline tells serveWithTimeout that the handler of r stops soon
+// after its context is done and answers the timeout itself, as batch
+// handlers do to report how far they got, so its response is sent instead
+// of the 504.
+func answerDeadline(r *http.Request) {
+	if answers, ok := r.Context().Value(deadlineAnswerKey{}).(*atomic.Bool); ok {
+		answers.Store(true)
+	}
+}
+
+// String describes the policy in debug logs.
+func (p *routePolicy) String() string {
+	route := p.pattern
+	if p.method != "" {
+		route = p.method + " " + route
+	}
+	return fmt.Sprintf("route=%q max_body=%d timeout=%s max_concurrent=%d", route, p.maxBodyBytes, p.timeout, p.maxConcurrent)
+}
+
+// matches reports whether the policy applies to r. HEAD requests match GET
+// policies.
+func (p *routePolicy) matches(r *http.Request) bool {
+	if p.method != "" && p.method != r.Method && !(p.method == http.MethodGet && r.Method == http.MethodHead) {
+		return false
+	}
+	subtree := strings.HasSuffix(p.pattern, "/")
+	want, got := pathSegments(p.pattern), pathSegments(r.URL.Path)
+	if len(got) < len(want) || (!subtree && len(got) != len(want)) {
+		return false
+	}
+	for i, segment := range want {
+		if segment != "*" && segment != got[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// loadRoutePolicies reads a route policies file. Each line holds an
+// optional method, a path pattern, and the limits to set, e.g.
+// "POST /books/import body=100MB timeout=5m concurrent=2"; blank lines and
+// lines starting with # are ignored.
+func loadRoutePolicies(path string) ([]*routePolicy, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var policies []*routePolicy
+	scanner := bufio.NewScanner(f)
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+		fields := strings.Fields(text)
+		p := &routePolicy{}
+		if !strings.HasPrefix(fields[0], "/") {
+			p.method = strings.ToUpper(fields[0])
+			fields = fields[1:]
+		}
+		if len(fields) == 0 || !strings.HasPrefix(fields[0], "/") {
+			return nil, fmt.Errorf("%s:%d: want [method] /path key=value...", path, line)
+		}
+		p.pattern = fields[0]
+		for _, field := range fields[1:] {
+			key, value, _ := strings.Cut(field, "=")
+			switch key {
+			case "body":
+				p.maxBodyBytes, err = parseByteSize(value)
+			case "timeout":
+				p.timeout, err = time.ParseDuration(value)
+			case "concurrent":
+				p.maxConcurrent, err = strconv.Atoi(value)
+			default:
+				err = fmt.Errorf("unknown limit %q", key)
+			}
+			if err == nil && (p.maxBodyBytes < 0 || p.timeout < 0 || p.maxConcurrent < 0) {
+				err = errors.New("negative limit")
+			}
+			if err != nil {
+				return nil, fmt.Errorf("%s:%d: %s: %v", path, line, field, err)
+			}
+		}
+		if p.maxConcurrent > 0 {
+			p.slots = make(chan struct{}, p.maxConcurrent)
+		}
+		policies = append(policies, p)
+	}
+	return policies, scanner.Err()
+}
+
+// parseByteSize parses a size in bytes, optionally with a KB, MB, or GB
+// suffix (powers of 1024).
+func parseByteSize(s string) (int64, error) {
+	multiplier := int64(1)
+	upper := strings.ToUpper(s)
+	for _, unit := range []struct {
+		suffix string
+		size   int64
+	}{{"GB", 1 << 30}, {"MB", 1 << 20}, {"KB", 1 << 10}, {"B", 1}} {
+		if strings.HasSuffix(upper, unit.suffix) {
+			upper, multiplier = strings.TrimSuffix(upper, unit.suffix), unit.size
+			break
+		}
+	}
+	n, err := strconv.ParseInt(upper, 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid size %q", s)
+	}
+	return n * multiplier, nil
+}
+
+// routePolicyFor returns the first policy matching r, or the default
+// policy, which only sets the -request-timeout.
+func routePolicyFor(r *http.Request) *routePolicy {
+	config := currentConfig()
+	for _, p := range config.routePolicies {
+		if p.matches(r) {
+			return p
+		}
+	}
+	return &routePolicy{pattern: "default", timeout: config.requestTimeout}
+}
+
+// bodyLimit returns the maximum body size of r: the limit of its route
+// policy, or fallback if the policy sets none.
+func bodyLimit(r *http.Request, fallback int64) int64 {
+	if p, ok := r.Context().Value(routePolicyKey{}).(*routePolicy); ok && p.maxBodyBytes > 0 {
+		return p.maxBodyBytes
+	}
+	return fallback
+}
+
+// applyRoutePolicies enforces the route policy of each request before
+// dispatching it: more concurrent requests than the policy allows get 429,
+// a body declared larger than its limit 413, and a request still running
+// at its timeout 504. A client may shorten the timeout of its request with
+// requestTimeoutHeader. Probes and metrics are exempt.
+func applyRoutePolicies(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if isExemptFromLimits(r.URL.Path) {
+			next.ServeHTTP(w, r)
+			return
+		}
+		p := routePolicyFor(r)
+		if currentConfig().debug {
+			logger.Printf("DEBUG %s %s %s", r.Method, r.URL.Path, p)
+		}
+
+		if p.slots != nil {
+			select {
+			case p.slots <- struct{}{}:
+				var lease *slotLease
+				r, lease = leaseSlots(r)
+				defer lease.release(func() { <-p.slots })
+			default:
+				w.Header().Set("Retry-After", busyRetryAfter)
+				writeError(w, r, http.StatusTooManyRequests, "route_busy", "max", p.maxConcurrent)
+				return
+			}
+		}
+		if p.maxBodyBytes > 0 {
+			if r.ContentLength > p.maxBodyBytes {
+				writeError(w, r, http.StatusRequestEntityTooLarge, "request_too_large", "max", p.maxBodyBytes)
+				return
+			}
+			r.Body = http.MaxBytesReader(w, r.Body, p.maxBodyBytes)
+		}
+		r = r.WithContext(context.WithValue(r.Context(), routePolicyKey{}, p))
+
+		timeout, source, err := requestDeadline(w, r, p)
+		if err != nil {
+			writeAPIError(w, r, err)
+			return
+		}
+		if timeout > 0 {
+			serveWithTimeout(w, r, timeout, source, next)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// requestDeadline returns the timeout of r and the side that set it,
+// "route" or "client", and sets the deadline headers of the response. A
+// client asking for a longer timeout than the route's gets the route's; one
+// asking for any timeout on a route without one gets it.
+func requestDeadline(w http.ResponseWriter, r *http.Request, p *routePolicy) (time.Duration, string, error) {
+	if p.timeout > 0 {
+		w.Header().Set(requestDeadlineHeader, p.timeout.String())
+	}
+	value := strings.TrimSpace(r.Header.Get(requestTimeoutHeader))
+	if value == "" {
+		return p.timeout, "route", nil
+	}
+	asked, err := parseRequestTimeout(value)
+	if err != nil {
+		return 0, "", err
+	}
+	if p.timeout > 0 && asked >= p.timeout {
+		w.Header().Set(requestTimeoutHeader, p.timeout.String())
+		return p.timeout, "route", nil
+	}
+	w.Header().Set(requestTimeoutHeader, asked.String())
+	return asked, "client", nil
+}
+
+// parseRequestTimeout parses the value of requestTimeoutHeader: a Go
+// duration, such as "1.5s" or "500ms", or a number of seconds.
+func parseRequestTimeout(value string) (time.Duration, error) {
+	d, err := time.ParseDuration(value)
+	if err != nil {
+		seconds, ferr := strconv.ParseFloat(value, 64)
+		if ferr != nil || math.IsNaN(seconds) || seconds > math.MaxInt64/float64(time.Second) {
+			return 0, newAPIError(http.StatusBadRequest, "invalid_request_timeout", "header", requestTimeoutHeader)
+		}
+		d = time.Duration(seconds * float64(time.Second))
+	}
+	if d <= 0 {
+		return 0, newAPIError(http.StatusBadRequest, "invalid_request_timeout", "header", requestTimeoutHeader)
+	}
+	return d, nil
+}
+
+// serveWithTimeout runs next with a buffered response, which is sent if it
+// finishes within timeout. Otherwise the client gets 504, naming the side
+// whose deadline passed, and whatever next writes afterwards is discarded.
+// The request's context is cancelled at the timeout, so handlers watching
+// it, and the store operations and outbound calls they make with it, can
+// stop early; those that called answerDeadline get deadlineGrace more to
+// send their own response. The concurrency slots of a request answered
+// with 504 are held until next returns.
+func serveWithTimeout(w http.ResponseWriter, r *http.Request, timeout time.Duration, source string, next http.Handler) {
+	ctx, cancel := context.WithTimeout(r.Context(), timeout)
+	defer cancel()
+	answers := new(atomic.Bool)
+	r = r.WithContext(context.WithValue(ctx, deadlineAnswerKey{}, answers))
+
+	tw := &timeoutWriter{header: make(http.Header), status: http.StatusOK}
+	done, returned := make(chan struct{}), make(chan struct{})
+	panicked := make(chan any, 1)
+	go func() {
+		defer close(returned)
+		defer func() {
+			if v := recover(); v != nil {
+				panicked <- v
+			}
+		}()
+		next.ServeHTTP(tw, r)
+		close(done)
+	}()
+
+	select {
+	case v := <-panicked:
+		panic(v)
+	case <-done:
+		tw.flush(w)
+	case <-ctx.Done():
+		if answers.Load() {
+			select {
+			case <-done:
+				tw.flush(w)
+				return
+			case v := <-panicked:
+				panic(v)
+			case <-time.After(deadlineGrace):
+			}
+		}
+		tw.mu.Lock()
+		tw.timedOut = true
+		tw.mu.Unlock()
+		detachSlots(r, returned)
+		if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+			writeError(w, r, http.StatusGatewayTimeout, "request_timeout", "timeout", timeout, "deadline", source)
+		}
+	}
+}
+
+// timeoutWriter buffers the response of a handler run by serveWithTimeout.
+type timeoutWriter struct {
+	mu          sync.Mutex
+	header      http.Header
+	buf         bytes.Buffer
+	status      int
+	wroteHeader bool
+	timedOut    bool
+}
+
+func (tw *timeoutWriter) Header() http.Header { return tw.header }
+
+// flush sends the buffered response to w.
+func (tw *timeoutWriter) flush(w http.ResponseWriter) {
+	tw.mu.Lock()
+	defer tw.mu.Unlock()
+	for name, values := range tw.header {
+		w.Header()[name] = values
+	}
+	w.WriteHeader(tw.status)
+	w.Write(tw.buf.Bytes())
+}
+
+func (tw *timeoutWriter) WriteHeader(status int) {
+	tw.mu.Lock()
+	defer tw.mu.Unlock()
+	if tw.timedOut || tw.wroteHeader {
+		return
+	}
+	tw.status, tw.wroteHeader = status, true
+}
+
+func (tw *timeoutWriter) Write(p []byte) (int, error) {
+	tw.mu.Lock()
+	defer tw.mu.Unlock()
+	if tw.timedOut {
+		return 0, http.ErrHandlerTimeout
+	}
+	tw.wroteHeader = true
+	return tw.buf.Write(p)
+}
@@ -0,0 +1,384 @@
+package booksapi
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// sleepyStore delays every Create and transaction, so that every write
+// takes at least its delay.
+type sleepyStore struct {
+	BookStore
+	delay time.Duration
+}
+
+func (s sleepyStore) Create(book Book) (Book, error) {
+	time.Sleep(s.delay)
+	return s.BookStore.Create(book)
+}
+
+func (s sleepyStore) Transact(ctx context.Context, fn func(tx BookStore) error) error {
+	time.Sleep(s.delay)
+	return s.BookStore.Transact(ctx, fn)
+}
+
+// setRoutePolicies loads policies, given as the lines of a policies file,
+// and puts them in effect until the end of the test.
+func setRoutePolicies(t *testing.T, lines ...string) {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "policies")
+	if err := os.WriteFile(path, []byte(strings.Join(lines, "\n")), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	policies, err := loadRoutePolicies(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	setForTest(t, &routePolicies, policies)
+	liveConfig.Store(flagConfig())
+}
+
+// TestLoadRoutePolicies checks the parsing of policies files.
+func TestLoadRoutePolicies(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "policies")
+	write := func(text string) {
+		t.Helper()
+		if err := os.WriteFile(path, []byte(text), 0o600); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	write("# imports are big and slow\npost /books/import body=100MB timeout=5m concurrent=2\n\n/books/ body=1KB timeout=10s\n/healthz\n")
+	policies, err := loadRoutePolicies(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for _, p := range policies {
+		got = append(got, p.String())
+	}
+	want := []string{
+		`route="POST /books/import" max_body=104857600 timeout=5m0s max_concurrent=2`,
+		`route="/books/" max_body=1024 timeout=10s max_concurrent=0`,
+		`route="/healthz" max_body=0 timeout=0s max_concurrent=0`,
+	}
+	if strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Errorf("policies\n%s\nwant\n%s", strings.Join(got, "\n"), strings.Join(want, "\n"))
+	}
+	if cap(policies[0].slots) != 2 || policies[1].slots != nil {
+		t.Error("concurrency slots not made for the policies limiting it only")
+	}
+
+	for _, text := range []string{
+		"POST",
+		"POST books",
+		"/books size=1MB",
+		"/books body=1XB",
+		"/books timeout=soon",
+		"/books concurrent=-1",
+		"/books timeout=-1s",
+	} {
+		write("# first line\n" + text)
+		if _, err := loadRoutePolicies(path); err == nil || !strings.Contains(err.Error(), path+":2:") {
+			t.Errorf("%q: error %v, want one naming line 2", text, err)
+		}
+	}
+	if _, err := loadRoutePolicies(filepath.Join(t.TempDir(), "missing")); err == nil {
+		t.Error("missing file: no error")
+	}
+}
+
+// TestRoutePolicyMatches checks which requests a policy pattern matches.
+func TestRoutePolicyMatches(t *testing.T) {
+	tests := []struct {
+		method, pattern string
+		reqMethod, path string
+		want            bool
+	}{
+		{"", "/books", http.MethodGet, "/books", true},
+		{"", "/books", http.MethodGet, "/books/1", false},
+		{"", "/books/", http.MethodGet, "/books", true},
+		{"", "/books/", http.MethodDelete, "/books/1/cover", true},
+		{"", "/books/*", http.MethodGet, "/books/1", true},
+		{"", "/books/*", http.MethodGet, "/books/1/cover", false},
+		{"", "/books/*/cover", http.MethodPut, "/books/7/cover", true},
+		{"POST", "/books/import", http.MethodPost, "/books/import", true},
+		{"POST", "/books/import", http.MethodPut, "/books/import", false},
+		{"GET", "/books", http.MethodHead, "/books", true},
+		{"HEAD", "/books", http.MethodGet, "/books", false},
+	}
+	for _, tt := range tests {
+		p := &routePolicy{method: tt.method, pattern: tt.pattern}
+		if got := p.matches(httptest.NewRequest(tt.reqMethod, tt.path, nil)); got != tt.want {
+			t.Errorf("%s %s matches %s %s: %v, want %v", tt.method, tt.pattern, tt.reqMethod, tt.path, got, tt.want)
+		}
+	}
+}
+
+// TestRouteBodyLimits checks that the import route takes a body the CRUD
+// routes reject, whether its size is declared or only found while reading.
+func TestRouteBodyLimits(t *testing.T) {
+	h := newTestServer(t)
+	setRoutePolicies(t, "POST /books/import body=4KB", "/books/ body=1KB")
+	book := map[string]any{"title": "Dune", "description": strings.Repeat("spice ", 300)}
+
+	rec := serve(t, h, http.MethodPost, "/books/import", []any{book})
+	wantCode(t, rec, http.StatusOK)
+	rec = serve(t, h, http.MethodPost, "/books", book)
+	wantCode(t, rec, http.StatusRequestEntityTooLarge)
+	if got := decode[errorBody](t, rec).Error; got.Code != "request_too_large" || got.Params["max"] != "1024" {
+		t.Errorf("error %s %v, want request_too_large with max 1024", got.Code, got.Params)
+	}
+	wantCode(t, serve(t, h, http.MethodPut, "/books/1", book), http.StatusRequestEntityTooLarge)
+
+	// A body of undeclared size is cut off at the limit.
+	body := fmt.Sprintf(`{"title": "Emma", "description": %q}`, strings.Repeat("x", 2000))
+	for _, path := range []string{"/books", "/books/import"} {
+		req := httptest.NewRequest(http.MethodPost, path, strings.NewReader(body))
+		if path == "/books/import" {
+			req = httptest.NewRequest(http.MethodPost, path, strings.NewReader("["+body+"]"))
+		}
+		req.ContentLength = -1
+		req.Header.Set("Content-Type", "application/json")
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		want := http.StatusRequestEntityTooLarge
+		if path == "/books/import" {
+			want = http.StatusOK
+		}
+		if rec.Code != want {
+			t.Errorf("POST %s of undeclared size: status %d, want %d: %s", path, rec.Code, want, rec.Body)
+		}
+	}
+
+	// Without a policy of their own, requests keep the handler's limits.
+	setRoutePolicies(t, "POST /books/import body=4KB")
+	wantCode(t, serve(t, h, http.MethodPost, "/books", book), http.StatusCreated)
+}
+
+// TestRouteTimeouts checks that a slow write times out on a CRUD route but
+// not on the import route, and that the policy applied is logged with
+// -debug.
+func TestRouteTimeouts(t *testing.T) {
+	var logs bytes.Buffer
+	h := newTestServer(t, WithStore(sleepyStore{newMemoryStore(newSequentialIDs()), 50 * time.Millisecond}), WithLogger(log.New(&logs, "", 0)))
+	setForTest(t, &debugLog, true)
+	setRoutePolicies(t, "POST /books/import timeout=5s", "/books/ timeout=10ms")
+
+	rec := serve(t, h, http.MethodPost, "/books", map[string]any{"title": "Dune"})
+	wantCode(t, rec, http.StatusGatewayTimeout)
+	if got := decode[errorBody](t, rec).Error; got.Code != "request_timeout" || got.Params["deadline"] != "route" {
+		t.Errorf("error %s %v, want request_timeout of the route", got.Code, got.Params)
+	}
+	if got := rec.Header().Get(requestDeadlineHeader); got != "10ms" {
+		t.Errorf("%s %q, want 10ms", requestDeadlineHeader, got)
+	}
+
+	rec = serve(t, h, http.MethodPost, "/books/import", []any{map[string]any{"title": "Emma"}})
+	wantCode(t, rec, http.StatusOK)
+	if got := decode[importResult](t, rec).Imported; got != 1 {
+		t.Errorf("imported %d books, want 1", got)
+	}
+
+	// Probes are exempt from every policy.
+	setRoutePolicies(t, "/ timeout=1ns")
+	wantCode(t, serve(t, h, http.MethodGet, "/healthz", nil), http.StatusOK)
+
+	for _, line := range []string{
+		`DEBUG POST /books route="/books/" max_body=0 timeout=10ms max_concurrent=0`,
+		`DEBUG POST /books/import route="POST /books/import" max_body=0 timeout=5s max_concurrent=0`,
+	} {
+		if !strings.Contains(logs.String(), line) {
+			t.Errorf("logs lack %q:\n%s", line, logs.String())
+		}
+	}
+	if strings.Contains(logs.String(), "/healthz") {
+		t.Errorf("probe logged:\n%s", logs.String())
+	}
+}
+
+// deadlineStore records the deadline of the context of each transaction.
+type deadlineStore struct {
+	BookStore
+	deadlines chan time.Time
+}
+
+func (s deadlineStore) Transact(ctx context.Context, fn func(tx BookStore) error) error {
+	deadline, _ := ctx.Deadline()
+	s.deadlines <- deadline
+	return s.BookStore.Transact(ctx, fn)
+}
+
+// TestRequestDeadlines checks that clients may shorten the timeout of
+// their requests but not lengthen it past the route's, that the timeouts
+// are echoed in the response headers, and that a 504 names the side whose
+// deadline passed.
+func TestRequestDeadlines(t *testing.T) {
+	h := newTestServer(t, WithStore(sleepyStore{newMemoryStore(newSequentialIDs()), 100 * time.Millisecond}))
+	tests := []struct {
+		name     string
+		policy   string
+		asked    string // X-Request-Timeout of the request
+		status   int
+		deadline string // of the response
+		timeout  string // echoed
+		side     string // named by the 504
+	}{
+		{"route", "/books/ timeout=20ms", "", http.StatusGatewayTimeout, "20ms", "", "route"},
+		{"client shorter", "/books/ timeout=5s", "20ms", http.StatusGatewayTimeout, "5s", "20ms", "client"},
+		{"client in seconds", "/books/ timeout=5s", "0.02", http.StatusGatewayTimeout, "5s", "20ms", "client"},
+		{"client longer", "/books/ timeout=5s", "1m", http.StatusCreated, "5s", "5s", ""},
+		{"client longer, clamped", "/books/ timeout=20ms", "10s", http.StatusGatewayTimeout, "20ms", "20ms", "route"},
+		{"client only", "/books/ concurrent=10", "20ms", http.StatusGatewayTimeout, "", "20ms", "client"},
+		{"neither", "/books/ concurrent=10", "", http.StatusCreated, "", "", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			setRoutePolicies(t, tt.policy)
+			var header []string
+			if tt.asked != "" {
+				header = []string{requestTimeoutHeader, tt.asked}
+			}
+			rec := serve(t, h, http.MethodPost, "/books", map[string]any{"title": "Dune"}, header...)
+			wantCode(t, rec, tt.status)
+			if got := rec.Header().Get(requestDeadlineHeader); got != tt.deadline {
+				t.Errorf("%s %q, want %q", requestDeadlineHeader, got, tt.deadline)
+			}
+			if got := rec.Header().Get(requestTimeoutHeader); got != tt.timeout {
+				t.Errorf("%s %q, want %q", requestTimeoutHeader, got, tt.timeout)
+			}
+			if tt.side != "" {
+				if got := decode[errorBody](t, rec).Error; got.Code != "request_timeout" || got.Params["deadline"] != tt.side {
+					t.Errorf("error %s %v, want request_timeout of the %s", got.Code, got.Params, tt.side)
+				}
+			}
+		})
+	}
+
+	setRoutePolicies(t, "/books/ timeout=5s")
+	for _, value := range []string{"soon", "0", "-1s", "NaN", "1e300"} {
+		rec := serve(t, h, http.MethodGet, "/books", nil, requestTimeoutHeader, value)
+		wantCode(t, rec, http.StatusBadRequest)
+		if got := errorCode(t, rec); got != "invalid_request_timeout" {
+			t.Errorf("%s %q: error code %q", requestTimeoutHeader, value, got)
+		}
+	}
+}
+
+// TestRequestDeadlineContext checks that the deadline negotiated reaches
+// the store through the request context.
+func TestRequestDeadlineContext(t *testing.T) {
+	backend := deadlineStore{newMemoryStore(newSequentialIDs()), make(chan time.Time, 1)}
+	h := newTestServer(t, WithStore(backend))
+	setRoutePolicies(t, "/books/ timeout=5s")
+	for _, tt := range []struct {
+		asked string
+		want  time.Duration
+	}{
+		{"", 5 * time.Second},
+		{"2s", 2 * time.Second},
+		{"1h", 5 * time.Second},
+	} {
+		start := time.Now()
+		rec := serve(t, h, http.MethodPost, "/books/transactions", map[string]any{"operations": []map[string]any{
+			{"op": "create", "book": map[string]any{"title": "Dune"}},
+		}}, requestTimeoutHeader, tt.asked)
+		wantCode(t, rec, http.StatusOK)
+		deadline := <-backend.deadlines
+		if deadline.Before(start.Add(tt.want)) || deadline.After(time.Now().Add(tt.want)) {
+			t.Errorf("%s %q: the store's deadline is %v away, want %v", requestTimeoutHeader, tt.asked, deadline.Sub(start), tt.want)
+		}
+	}
+}
+
+// TestRouteConcurrency checks that requests beyond a route's concurrency
+// limit get 429 while the others are served.
+func TestRouteConcurrency(t *testing.T) {
+	resetState(t)
+	setRoutePolicies(t, "POST /books/import concurrent=1")
+	started, release := make(chan struct{}), make(chan struct{})
+	h := applyRoutePolicies(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/books/import" {
+			started <- struct{}{}
+			<-release
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}))
+
+	done := make(chan int)
+	go func() {
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/books/import", nil))
+		done <- rec.Code
+	}()
+	<-started
+
+	rec := serve(t, h, http.MethodPost, "/books/import", nil)
+	wantCode(t, rec, http.StatusTooManyRequests)
+	if got := decode[errorBody](t, rec).Error; got.Code != "route_busy" || got.Params["max"] != "1" || rec.Header().Get("Retry-After") == "" {
+		t.Errorf("error %s %v with Retry-After %q, want route_busy with max 1", got.Code, got.Params, rec.Header().Get("Retry-After"))
+	}
+	wantCode(t, serve(t, h, http.MethodPost, "/books", nil), http.StatusNoContent)
+
+	close(release)
+	if code := <-done; code != http.StatusNoContent {
+		t.Errorf("first import: status %d", code)
+	}
+	go func() { <-started }()
+	wantCode(t, serve(t, h, http.MethodPost, "/books/import", nil), http.StatusNoContent)
+}
+
+// TestTimeoutHoldsSlots checks that a request answered with 504 at its
+// client's deadline keeps its concurrency slots, of the route and of the
+// server, until its handler returns.
+func TestTimeoutHoldsSlots(t *testing.T) {
+	resetState(t)
+	setForTest(t, &maxConcurrent, 1)
+	setRoutePolicies(t, "/books/ concurrent=1")
+	var release chan struct{}
+	handler := applyRoutePolicies(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get(requestTimeoutHeader) != "" {
+			<-release
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}))
+
+	for _, tt := range []struct {
+		name string
+		h    http.Handler
+		busy int
+	}{
+		{"route", handler, http.StatusTooManyRequests},
+		{"server", limitConcurrency(handler), http.StatusServiceUnavailable},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			release = make(chan struct{})
+			rec := serve(t, tt.h, http.MethodGet, "/books", nil, requestTimeoutHeader, "20ms")
+			wantCode(t, rec, http.StatusGatewayTimeout)
+			wantCode(t, serve(t, tt.h, http.MethodGet, "/books", nil), tt.busy)
+
+			// The slots are released once the handler returns.
+			close(release)
+			deadline := time.Now().Add(5 * time.Second)
+			for {
+				rec := serve(t, tt.h, http.MethodGet, "/books", nil)
+				if rec.Code == http.StatusNoContent {
+					break
+				}
+				if time.Now().After(deadline) {
+					t.Fatalf("status %d after the handler returned, want 204", rec.Code)
+				}
+				time.Sleep(time.Millisecond)
+			}
+		})
+	}
+}
@@ -0,0 +1,204 @@
+package booksapi
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strings"
+	"time"
+)
+
+// exportS3Endpoint is the base URL of the S3-compatible service exports are
+// written to when -export-path is an s3:// URL. It is configured by a flag
+// in Main.
+var exportS3Endpoint = "https://s3.amazonaws.com"
+
+// s3Client sends the requests of S3 export targets; tests can replace it
+// with a fake.
+var s3Client httpDoer = http.DefaultClient
+
+// s3Target stores exports as objects in an S3-compatible bucket, addressed
+// path-style and signed with AWS Signature Version 4. The credentials and
+// region come from the standard AWS_ACCESS_KEY_ID, AWS_SECRET_ACCESS_KEY,
+// and AWS_REGION environment variables.
+type s3Target struct {
+	endpoint  *url.URL
+	bucket    string
+	prefix    string // prepended to object names, e.g. "backups/"
+	region    string
+	accessKey string
+	secretKey string
+}
+
+// newS3Target returns the target for an s3://bucket/prefix URL.
+func newS3Target(raw string) (*s3Target, error) {
+	u, err := url.Parse(raw)
+	if err != nil || u.Host == "" {
+		return nil, fmt.Errorf("export path %q: want s3://bucket/prefix", raw)
+	}
+	endpoint, err := url.Parse(exportS3Endpoint)
+	if err != nil || endpoint.Host == "" {
+		return nil, fmt.Errorf("-export-s3-endpoint %q: not a URL", exportS3Endpoint)
+	}
+	t := &s3Target{
+		endpoint:  endpoint,
+		bucket:    u.Host,
+		prefix:    strings.TrimPrefix(u.Path, "/"),
+		region:    os.Getenv("AWS_REGION"),
+		accessKey: os.Getenv("AWS_ACCESS_KEY_ID"),
+		secretKey: os.Getenv("AWS_SECRET_ACCESS_KEY"),
+	}
+	if t.prefix != "" && !strings.HasSuffix(t.prefix, "/") {
+		t.prefix += "/"
+	}
+	if t.region == "" {
+		t.region = "us-east-1"
+	}
+	if t.accessKey == "" || t.secretKey == "" {
+		return nil, fmt.Errorf("export path %q: AWS_ACCESS_KEY_ID and AWS_SECRET_ACCESS_KEY must be set", raw)
+	}
+	return t, nil
+}
+
+func (t *s3Target) Put(ctx context.Context, name string, data []byte) error {
+	_, err := t.do(ctx, http.MethodPut, t.prefix+name, nil, data)
+	return err
+}
+
+func (t *s3Target) List(ctx context.Context) ([]string, error) {
+	var names []string
+	query := url.Values{"list-type": {"2"}, "prefix": {t.prefix}}
+	for {
+		body, err := t.do(ctx, http.MethodGet, "", query, nil)
+		if err != nil {
+			return nil, err
+		}
+		var result struct {
+			Contents []struct {
+				Key string `xml:"Key"`
+			} `xml:"Contents"`
+			IsTruncated           bool   `xml:"IsTruncated"`
+			NextContinuationToken string `xml:"NextContinuationToken"`
+		}
+		if err := xml.Unmarshal(body, &result); err != nil {
+			return nil, fmt.Errorf("s3 list: %w", err)
+		}
+		for _, object := range result.Contents {
+			if name := strings.TrimPrefix(object.Key, t.prefix); !strings.Contains(name, "/") {
+				names = append(names, name)
+			}
+		}
+		if !result.IsTruncated || result.NextContinuationToken == "" {
+			return names, nil
+		}
+		query.Set("continuation-token", result.NextContinuationToken)
+	}
+}
+
+func (t *s3Target) Delete(ctx context.Context, name string) error {
+	_, err := t.do(ctx, http.MethodDelete, t.prefix+name, nil, nil)
+	return err
+}
+
+func (t *s3Target) String() string { return "s3://" + t.bucket + "/" + t.prefix }
+
+// do sends a signed request for the object key, or the bucket if key is
+// empty, and returns the response body. Statuses outside 2xx are errors.
+func (t *s3Target) do(ctx context.Context, method, key string, query url.Values, body []byte) ([]byte, error) {
+	u := *t.endpoint
+	u.Path = strings.TrimSuffix(u.Path, "/") + "/" + t.bucket
+	if key != "" {
+		u.Path += "/" + key
+	}
+	u.RawQuery = canonicalS3Query(query)
+
+	req, err := http.NewRequestWithContext(ctx, method, u.String(), bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	t.sign(req, body, time.Now().UTC())
+
+	resp, err := s3Client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	data, err := io.ReadAll(io.LimitReader(resp.Body, 16<<20))
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return nil, fmt.Errorf("s3 %s %s: %s", method, u.Path, resp.Status)
+	}
+	return data, nil
+}
+
+// sign adds the AWS Signature Version 4 headers to req.
+func (t *s3Target) sign(req *http.Request, body []byte, now time.Time) {
+	amzDate := now.Format("20060102T150405Z")
+	day := now.Format("20060102")
+	payloadHash := sha256Hex(body)
+	req.Header.Set("X-Amz-Date", amzDate)
+	req.Header.Set("X-Amz-Content-Sha256", payloadHash)
+
+	const signedHeaders = "host;x-amz-content-sha256;x-amz-date"
+	canonicalRequest := strings.Join([]string{
+		req.Method,
+		req.URL.EscapedPath(),
+		req.URL.RawQuery,
+		"host:" + req.URL.Host,
+		"x-amz-content-sha256:" + payloadHash,
+		"x-amz-date:" + amzDate,
+		"",
+		signedHeaders,
+		payloadHash,
+	}, "\n")
+	scope := day + "/" + t.region + "/s3/aws4_request"
+	stringToSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" + sha256Hex([]byte(canonicalRequest))
+
+	key := hmacSHA256([]byte("AWS4"+t.secretKey), day)
+	key = hmacSHA256(key, t.region)
+	key = hmacSHA256(key, "s3")
+	key = hmacSHA256(key, "aws4_request")
+	signature := hex.EncodeToString(hmacSHA256(key, stringToSign))
+
+	req.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		t.accessKey, scope, signedHeaders, signature))
+}
+
+// canonicalS3Query encodes query as Signature Version 4 requires: sorted by
+// name, with spaces as %20.
+func canonicalS3Query(query url.Values) string {
+	names := make([]string, 0, len(query))
+	for name := range query {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var parts []string
+	for _, name := range names {
+		for _, value := range query[name] {
+			parts = append(parts, url.QueryEscape(name)+"="+strings.ReplaceAll(url.QueryEscape(value), "+", "%20"))
+		}
+	}
+	return strings.Join(parts, "&")
+}
+
+func sha256Hex(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
@@ -0,0 +1,152 @@
+package booksapi
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// schemaVersion is the version of the snapshot and journal formats this
+// server writes. Snapshots carry it in their schema_version field, and so
+// does every journal record; files written before versions existed, which
+// have none, are version 1.
+//
+// Files of an older version are migrated when they are loaded, through
+// schemaMigrations, and then rewritten at schemaVersion, keeping the
+// original as a .bak file. Files of a newer version are refused, as a
+// server that does not know their fields would drop them on the next
+// write.
+const schemaVersion = 2
+
+// schemaMigration turns the books of a file of version to-1 into those of
+// version to.
+type schemaMigration struct {
+	to   int
+	name string // what the migration does, for the startup log
+	// book rewrites a book as decoded from the file, its numbers kept as
+	// json.Number.
+	book func(book map[string]any) error
+}
+
+// schemaMigrations lists every migration, in version order. A new version
+// of the formats adds one, and bumps schemaVersion.
+var schemaMigrations = []schemaMigration{
+	{to: 2, name: "prices rounded to two decimal places", book: roundStoredPrices},
+}
+
+// roundStoredPrices rounds the prices of a book to priceDecimals places.
+// Version 1 stored prices as clients sent them, before their precision was
+// validated, while responses always showed them rounded.
+func roundStoredPrices(book map[string]any) error {
+	for _, field := range []string{"price", "cost_price"} {
+		n, ok := book[field].(json.Number)
+		if !ok {
+			continue
+		}
+		f, err := n.Float64()
+		if err != nil {
+			return fmt.Errorf("%s: %w", field, err)
+		}
+		book[field] = json.Number(strconv.FormatFloat(roundPrice(f), 'f', -1, 64))
+	}
+	return nil
+}
+
+// errSchemaTooNew wraps the error of a file written by a newer server.
+var errSchemaTooNew = errors.New("written by a newer server")
+
+// decodeVersioned decodes data, a snapshot or a journal record of any
+// version up to schemaVersion, into v, migrating it first if it is older.
+// It returns the version data was written at.
+func decodeVersioned(data []byte, v any) (int, error) {
+	var header struct {
+		SchemaVersion int `json:"schema_version"`
+	}
+	if err := json.Unmarshal(data, &header); err != nil {
+		return 0, err
+	}
+	version := max(header.SchemaVersion, 1)
+	switch {
+	case version > schemaVersion:
+		return version, fmt.Errorf("schema version %d is %w; this server reads up to version %d", version, errSchemaTooNew, schemaVersion)
+	case version == schemaVersion:
+		return version, json.Unmarshal(data, v)
+	}
+
+	var doc map[string]any
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	if err := dec.Decode(&doc); err != nil {
+		return version, err
+	}
+	for _, m := range schemaMigrations[version-1:] {
+		for _, book := range storedBooks(doc) {
+			if err := m.book(book); err != nil {
+				return version, fmt.Errorf("migrating to schema version %d: %w", m.to, err)
+			}
+		}
+	}
+	doc["schema_version"] = schemaVersion
+	migrated, err := json.Marshal(doc)
+	if err != nil {
+		return version, err
+	}
+	return version, json.Unmarshal(migrated, v)
+}
+
+// storedBooks returns the books of a decoded snapshot, or the book of a
+// decoded journal record.
+func storedBooks(doc map[string]any) []map[string]any {
+	var books []map[string]any
+	if list, ok := doc["books"].([]any); ok {
+		for _, item := range list {
+			if book, ok := item.(map[string]any); ok {
+				books = append(books, book)
+			}
+		}
+	}
+	if book, ok := doc["book"].(map[string]any); ok {
+		books = append(books, book)
+	}
+	return books
+}
+
+// describeMigrations describes the migrations from version to
+// schemaVersion, for the startup log.
+func describeMigrations(version int) string {
+	var steps []string
+	for _, m := range schemaMigrations[version-1:] {
+		steps = append(steps, fmt.Sprintf("v%d→v%d: %s", m.to-1, m.to, m.name))
+	}
+	return strings.Join(steps, "; ")
+}
+
+// backupOnce copies the file at path to path.bak before a migration
+// rewrites it, unless a .bak is already there: the first backup is that of
+// the original file, which later migrations must not replace.
+func backupOnce(path string) error {
+	bak := path + ".bak"
+	if _, err := os.Stat(bak); err == nil {
+		return nil
+	} else if !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(bak, data)
+}
+
+// logMigration logs the migrations a file of the given version went
+// through when it was loaded.
+func logMigration(path string, version int) {
+	logger.Printf("%s: migrated from schema version %d to %d (%s); the original is kept as %s.bak", path, version, schemaVersion, describeMigrations(version), path)
+}
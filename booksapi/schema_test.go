@@ -0,0 +1,289 @@
+package booksapi
+
+import (
+	"bufio"
+	"bytes"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+// migratedCostPrice is the cost price of the first of migratedBooks.
+var migratedCostPrice = Price(2.51)
+
+// migratedBooks are the books of every fixture in testdata/schema, as
+// loaded at schemaVersion.
+var migratedBooks = []Book{
+	{ID: 1, Title: "Dune", Author: "Frank Herbert", Price: 10, CostPrice: &migratedCostPrice},
+	{ID: 3, Title: "Emma", Author: "Jane Austen", Price: 4.49, Editions: []Edition{{Format: "ebook", Price: 4.49}}},
+}
+
+// schemaFixture copies the fixture of testdata/schema called name into a
+// new directory, as file, and returns its path there.
+func schemaFixture(t *testing.T, name, file string) string {
+	t.Helper()
+	data, err := os.ReadFile(filepath.Join("testdata", "schema", name))
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(t.TempDir(), file)
+	if err := os.WriteFile(path, data, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+// sqliteFixture writes the books of the snapshot fixture called name to a
+// new database, as rows of the given schema version, and returns its path.
+func sqliteFixture(t *testing.T, name string, version int) string {
+	t.Helper()
+	data, err := os.ReadFile(filepath.Join("testdata", "schema", name))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var snap struct {
+		LastID int               `json:"last_id"`
+		Books  []json.RawMessage `json:"books"`
+	}
+	if err := json.Unmarshal(data, &snap); err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(t.TempDir(), "books.db")
+	db, err := sql.Open("sqlite", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	if _, err := db.Exec(sqliteSchema); err != nil {
+		t.Fatal(err)
+	}
+	for _, book := range snap.Books {
+		var header struct {
+			ID int `json:"id"`
+		}
+		if err := json.Unmarshal(book, &header); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := db.Exec(`INSERT INTO books (id, schema_version, book) VALUES (?, ?, ?)`, header.ID, version, string(book)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := db.Exec(`INSERT INTO meta (key, value) VALUES ('last_id', ?)`, snap.LastID); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+// fileVersions returns the schema version of every JSON document, one per
+// line, in the file at path; none if there is no such file.
+func fileVersions(t *testing.T, path string) []int {
+	t.Helper()
+	file, err := os.Open(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	var versions []int
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(nil, 1<<20)
+	for scanner.Scan() {
+		var header struct {
+			SchemaVersion int `json:"schema_version"`
+		}
+		if err := json.Unmarshal(scanner.Bytes(), &header); err != nil {
+			t.Fatalf("%s: %v", path, err)
+		}
+		versions = append(versions, header.SchemaVersion)
+	}
+	if err := scanner.Err(); err != nil {
+		t.Fatal(err)
+	}
+	return versions
+}
+
+// journalVersions returns the schema version of the snapshot and of every
+// record of the journal at path.
+func journalVersions(t *testing.T, path string) []int {
+	return append(fileVersions(t, path+".snapshot"), fileVersions(t, path)...)
+}
+
+// sqliteVersions returns the schema version of every row of the database
+// at path.
+func sqliteVersions(t *testing.T, path string) []int {
+	t.Helper()
+	db, err := sql.Open("sqlite", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	rows, err := db.Query(`SELECT schema_version FROM books ORDER BY id`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rows.Close()
+	var versions []int
+	for rows.Next() {
+		var version int
+		if err := rows.Scan(&version); err != nil {
+			t.Fatal(err)
+		}
+		versions = append(versions, version)
+	}
+	if err := rows.Err(); err != nil {
+		t.Fatal(err)
+	}
+	return versions
+}
+
+// TestSchemaVersions loads a snapshot, a journal, and a database written
+// at every schema version, and checks their books are migrated, the files
+// rewritten at schemaVersion with the originals kept as .bak files, and
+// that opening them again migrates nothing.
+func TestSchemaVersions(t *testing.T) {
+	tests := []struct {
+		name    string
+		version int
+		setup   func(t *testing.T) string // returns the path to open
+		store   string                    // the key of persistentStores
+		// versions returns the schema version of every document the store
+		// wrote.
+		versions func(t *testing.T, path string) []int
+	}{
+		{
+			name: "snapshot/v1", version: 1, store: "snapshot",
+			setup:    func(t *testing.T) string { return schemaFixture(t, "snapshot_v1.json", "books.json") },
+			versions: fileVersions,
+		},
+		{
+			name: "snapshot/v2", version: 2, store: "snapshot",
+			setup:    func(t *testing.T) string { return schemaFixture(t, "snapshot_v2.json", "books.json") },
+			versions: fileVersions,
+		},
+		{
+			name: "journal/v1", version: 1, store: "journal",
+			setup:    func(t *testing.T) string { return schemaFixture(t, "journal_v1.jsonl", "books.jsonl") },
+			versions: journalVersions,
+		},
+		{
+			name: "journal/v2", version: 2, store: "journal",
+			setup:    func(t *testing.T) string { return schemaFixture(t, "journal_v2.jsonl", "books.jsonl") },
+			versions: journalVersions,
+		},
+		{
+			name: "sqlite/v1", version: 1, store: "sqlite",
+			setup:    func(t *testing.T) string { return sqliteFixture(t, "snapshot_v1.json", 1) },
+			versions: sqliteVersions,
+		},
+		{
+			name: "sqlite/v2", version: 2, store: "sqlite",
+			setup:    func(t *testing.T) string { return sqliteFixture(t, "snapshot_v2.json", 2) },
+			versions: sqliteVersions,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := tt.setup(t)
+			original, err := os.ReadFile(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			open := persistentStores[tt.store]
+
+			s, err := open(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			checkMigratedBooks(t, s)
+			if err := s.(io.Closer).Close(); err != nil {
+				t.Fatal(err)
+			}
+
+			for i, version := range tt.versions(t, path) {
+				if version != schemaVersion {
+					t.Errorf("document %d written at schema version %d, want %d", i, version, schemaVersion)
+				}
+			}
+
+			bak, err := os.ReadFile(path + ".bak")
+			switch {
+			case tt.version == schemaVersion:
+				if !errors.Is(err, os.ErrNotExist) {
+					t.Errorf("a file at the current version was backed up: %v", err)
+				}
+			case err != nil:
+				t.Errorf("the original was not backed up: %v", err)
+			case !bytes.Equal(bak, original):
+				t.Errorf("the backup differs from the original:\n%s\nwant\n%s", bak, original)
+			}
+
+			// A migrated file is not migrated, nor backed up, again.
+			os.Remove(path + ".bak")
+			s, err = open(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer s.(io.Closer).Close()
+			checkMigratedBooks(t, s)
+			if _, err := os.Stat(path + ".bak"); !errors.Is(err, os.ErrNotExist) {
+				t.Errorf("reopening backed the file up again: %v", err)
+			}
+		})
+	}
+}
+
+// checkMigratedBooks checks that s holds migratedBooks, and hands out IDs
+// after those of the fixtures.
+func checkMigratedBooks(t *testing.T, s BookStore) {
+	t.Helper()
+	if got := s.List(ListOptions{}); !reflect.DeepEqual(got, migratedBooks) {
+		t.Errorf("books %+v, want %+v", got, migratedBooks)
+	}
+	if got := s.NextID(); got != 5 {
+		t.Errorf("next ID %d, want 5", got)
+	}
+}
+
+// TestSchemaTooNew checks that files written at a newer schema version
+// are refused, and left as they are.
+func TestSchemaTooNew(t *testing.T) {
+	tests := []struct {
+		name  string
+		store string
+		setup func(t *testing.T) string
+	}{
+		{"snapshot", "snapshot", func(t *testing.T) string { return schemaFixture(t, "snapshot_v9.json", "books.json") }},
+		{"journal", "journal", func(t *testing.T) string { return schemaFixture(t, "journal_v3.jsonl", "books.jsonl") }},
+		{"sqlite", "sqlite", func(t *testing.T) string { return sqliteFixture(t, "snapshot_v2.json", schemaVersion+1) }},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := tt.setup(t)
+			original, err := os.ReadFile(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			s, err := persistentStores[tt.store](path)
+			if err == nil {
+				s.(io.Closer).Close()
+				t.Fatal("opened a file of a newer schema version")
+			}
+			if !errors.Is(err, errSchemaTooNew) {
+				t.Errorf("open: %v, want %v", err, errSchemaTooNew)
+			}
+			if data, err := os.ReadFile(path); err != nil || !bytes.Equal(data, original) {
+				t.Errorf("the file was changed (%v)", err)
+			}
+			if _, err := os.Stat(path + ".bak"); !errors.Is(err, os.ErrNotExist) {
+				t.Errorf("the file was backed up: %v", err)
+			}
+		})
+	}
+}
@@ -0,0 +1,90 @@
+package booksapi
+
+import (
+	"net/http"
+	"slices"
+	"sort"
+	"strings"
+)
+
+// Ranking weights for the fields a search term can match.
+const (
+	titleWeight       = 3
+	authorWeight      = 3
+	descriptionWeight = 1
+)
+
+// searchParams declares the query parameters of GET /books/search.
+var searchParams = slices.Concat(bookListParams, []queryParam{
+	{name: "q", kind: stringParam},
+	{name: "in", kind: enumParam, values: []string{"description"}, repeatable: true},
+})
+
+// searchBooks handles GET /books/search?q=term, matching the term
+// case-insensitively against titles and authors, and also descriptions when
+// ?in=description is given. Results are ordered by score, then by ID. Stores
+// with a full-text index answer from it, matching every word of the term as
+// the start of a word, so "dun" finds Dune and "herb" Frank Herbert; others
+// are scanned for the term as a substring. Pages follow searchPaging.
+func searchBooks(w http.ResponseWriter, r *http.Request) {
+	q, ok := checkQuery(w, r, searchParams...)
+	if !ok {
+		return
+	}
+	term := strings.ToLower(strings.TrimSpace(q.String("q")))
+	if term == "" {
+		writeError(w, r, http.StatusBadRequest, "missing_search_query")
+		return
+	}
+	inDescription := q.Contains("in", "description")
+
+	var bookList []Book
+	if searcher, ok := findStore[Searcher](store); ok {
+		// Books are read through the outermost store, which may hide some.
+		for _, hit := range searcher.Search(term, inDescription) {
+			if book, found := store.Get(hit.ID); found {
+				bookList = append(bookList, book)
+			}
+		}
+	} else {
+		bookList = scanBooks(term, inDescription)
+	}
+	writeJSON(w, http.StatusOK, renderBooks(r, paginateQuery(w, r, q, searchPaging, bookList)))
+}
+
+// scanBooks searches every book for term as a substring, ranking the hits
+// by the weights of the fields it appears in.
+func scanBooks(term string, inDescription bool) []Book {
+	type hit struct {
+		book  Book
+		score int
+	}
+	var hits []hit
+	for _, book := range store.List(ListOptions{}) {
+		score := 0
+		if strings.Contains(strings.ToLower(book.Title), term) {
+			score += titleWeight
+		}
+		if strings.Contains(strings.ToLower(book.Author), term) {
+			score += authorWeight
+		}
+		if inDescription && strings.Contains(strings.ToLower(book.Description), term) {
+			score += descriptionWeight
+		}
+		if score > 0 {
+			hits = append(hits, hit{book, score})
+		}
+	}
+	sort.Slice(hits, func(i, j int) bool {
+		if hits[i].score != hits[j].score {
+			return hits[i].score > hits[j].score
+		}
+		return hits[i].book.ID < hits[j].book.ID
+	})
+
+	list := make([]Book, 0, len(hits))
+	for _, h := range hits {
+		list = append(list, h.book)
+	}
+	return list
+}
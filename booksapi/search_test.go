@@ -0,0 +1,137 @@
+package booksapi
+
+import (
+	"fmt"
+	"math/rand/v2"
+	"net/http"
+	"slices"
+	"testing"
+)
+
+// searchTitles returns the titles GET /books/search finds for query.
+func searchTitles(t *testing.T, h http.Handler, query string) []string {
+	t.Helper()
+	rec := serve(t, h, http.MethodGet, "/books/search?q="+query, nil)
+	wantCode(t, rec, http.StatusOK)
+	titles := []string{}
+	for _, book := range decode[[]Book](t, rec) {
+		titles = append(titles, book.Title)
+	}
+	return titles
+}
+
+// TestSearchPrefix checks that the full-text index matches the words of a
+// query as the start of words, as the scan matches them as substrings.
+func TestSearchPrefix(t *testing.T) {
+	h := newTestServer(t)
+	mustCreateBook(t, h, map[string]any{"title": "Dune", "author": "Frank Herbert"})
+	mustCreateBook(t, h, map[string]any{"title": "Dune Messiah", "author": "Frank Herbert"})
+	mustCreateBook(t, h, map[string]any{"title": "Frankenstein", "author": "Mary Shelley"})
+	mustCreateBook(t, h, map[string]any{"title": "Emma", "author": "Jane Austen", "description": "A dunce of a matchmaker."})
+
+	for _, tt := range []struct {
+		query string
+		want  []string
+	}{
+		{"dune", []string{"Dune", "Dune Messiah"}},
+		{"dun", []string{"Dune", "Dune Messiah"}},
+		{"herb", []string{"Dune", "Dune Messiah"}},
+		{"DUN", []string{"Dune", "Dune Messiah"}},
+		{"frank", []string{"Dune", "Dune Messiah", "Frankenstein"}},
+		{"frank+herb", []string{"Dune", "Dune Messiah"}},
+		{"dune+mess", []string{"Dune Messiah"}},
+		{"dunes", []string{}},
+		{"dun&in=description", []string{"Dune", "Dune Messiah", "Emma"}},
+	} {
+		if got := searchTitles(t, h, tt.query); !slices.Equal(got, tt.want) {
+			t.Errorf("q=%s: %q, want %q", tt.query, got, tt.want)
+		}
+	}
+}
+
+// TestSearchIndexAfterUpdates checks, for each store keeping an index,
+// that updates and deletes leave the index holding exactly the words of
+// the books, and searches finding the books by their words of the time.
+func TestSearchIndexAfterUpdates(t *testing.T) {
+	for _, name := range []string{"memory", "memory-sharded"} {
+		t.Run(name, func(t *testing.T) {
+			s := storeFactories[name](t)
+			searcher, _ := findStore[Searcher](s)
+			verifier, _ := findStore[VerifiableStore](s)
+			found := func(query string) []int {
+				t.Helper()
+				if violations := verifier.Verify(); len(violations) > 0 {
+					t.Fatalf("Verify: %v", violations)
+				}
+				ids := []int{}
+				for _, hit := range searcher.Search(query, true) {
+					ids = append(ids, hit.ID)
+				}
+				slices.Sort(ids)
+				return ids
+			}
+
+			dune, _ := s.Create(Book{Title: "Dune", Author: "Frank Herbert"})
+			emma, _ := s.Create(Book{Title: "Emma", Author: "Jane Austen"})
+			if got := found("herb"); !slices.Equal(got, []int{dune.ID}) {
+				t.Errorf("herb found %v, want Dune", got)
+			}
+
+			dune.Title, dune.Description = "Children of Dune", "Leto and Ghanima."
+			if err := s.Put(dune); err != nil {
+				t.Fatal(err)
+			}
+			if got := found("ghan"); !slices.Equal(got, []int{dune.ID}) {
+				t.Errorf("ghan found %v after the update, want Dune", got)
+			}
+			emma.Title = "Persuasion"
+			if err := s.Put(emma); err != nil {
+				t.Fatal(err)
+			}
+			if got := found("emma"); len(got) != 0 {
+				t.Errorf("emma found %v after the rename", got)
+			}
+			if got := found("pers"); !slices.Equal(got, []int{emma.ID}) {
+				t.Errorf("pers found %v, want the renamed book", got)
+			}
+
+			if err := s.Delete(dune.ID); err != nil {
+				t.Fatal(err)
+			}
+			for _, query := range []string{"dune", "herb", "ghan", "children"} {
+				if got := found(query); len(got) != 0 {
+					t.Errorf("%s found %v after the delete", query, got)
+				}
+			}
+			if got := searcher.IndexStats(); got.Terms != 3 || got.Postings != 3 {
+				t.Errorf("index stats %+v, want the 3 words of the book left", got)
+			}
+		})
+	}
+}
+
+// BenchmarkSearch measures a search of a catalog of 10k books answered by
+// the full-text index and by a scan, for a whole word and for a prefix.
+func BenchmarkSearch(b *testing.B) {
+	resetState(b)
+	rng := rand.New(rand.NewPCG(1, 0))
+	for i := range 10_000 {
+		book := Book{ID: i + 1, Title: stressWords(rng, 3), Author: fmt.Sprint("Author ", i%100), Description: stressWords(rng, 12)}
+		if err := store.Put(book); err != nil {
+			b.Fatal(err)
+		}
+	}
+	searcher, _ := findStore[Searcher](store)
+	for _, query := range []string{"lantern", "lant"} {
+		b.Run("index/q="+query, func(b *testing.B) {
+			for range b.N {
+				searcher.Search(query, true)
+			}
+		})
+		b.Run("scan/q="+query, func(b *testing.B) {
+			for range b.N {
+				scanBooks(query, true)
+			}
+		})
+	}
+}
@@ -0,0 +1,269 @@
+package booksapi
+
+import (
+	"fmt"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"unicode"
+)
+
+// Searcher is implemented by stores keeping a full-text index of their
+// books. GET /books/search uses it, when the store has one, instead of
+// scanning every book.
+type Searcher interface {
+	// Search returns the books with a word starting with every term of
+	// query in their title or author, or also their description if
+	// inDescription is set, best match first.
+	Search(query string, inDescription bool) []SearchHit
+	// IndexStats reports the size of the index.
+	IndexStats() IndexStats
+}
+
+// SearchHit is a book matching a search, with its score.
+type SearchHit struct {
+	ID    int
+	Score int
+}
+
+// IndexStats reports the size of a full-text index in GET /books/stats.
+type IndexStats struct {
+	Terms    int `json:"terms"`
+	Postings int `json:"postings"`
+	Bytes    int `json:"approx_bytes"` // estimated memory held by the index
+}
+
+// termCounts counts the occurrences of a term in each field of a book.
+type termCounts struct {
+	title, author, description uint32
+}
+
+// score returns the ranking score of the counts, using the field weights of
+// the scanning search.
+func (c termCounts) score(inDescription bool) int {
+	score := int(c.title)*titleWeight + int(c.author)*authorWeight
+	if inDescription {
+		score += int(c.description) * descriptionWeight
+	}
+	return score
+}
+
+// textIndex is an inverted index of the titles, authors, and descriptions
+// of books, kept up to date by the store on every write.
+type textIndex struct {
+	mu       sync.RWMutex
+	postings map[string]map[int]termCounts // term -> book ID -> counts
+	terms    map[int][]string              // book ID -> its terms, for removal
+	sorted   []string                      // the terms of postings, in order, for prefixes
+}
+
+func newTextIndex() *textIndex {
+	return &textIndex{postings: make(map[string]map[int]termCounts), terms: make(map[int][]string)}
+}
+
+// add indexes book, replacing what was indexed for it before.
+func (x *textIndex) add(book Book) {
+	counts := bookTermCounts(book)
+
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	x.removeLocked(book.ID)
+	terms := make([]string, 0, len(counts))
+	for term, c := range counts {
+		postings, ok := x.postings[term]
+		if !ok {
+			postings = make(map[int]termCounts)
+			x.postings[term] = postings
+			i, _ := slices.BinarySearch(x.sorted, term)
+			x.sorted = slices.Insert(x.sorted, i, term)
+		}
+		postings[book.ID] = c
+		terms = append(terms, term)
+	}
+	x.terms[book.ID] = terms
+}
+
+// bookTermCounts counts the terms of the indexed fields of book.
+func bookTermCounts(book Book) map[string]termCounts {
+	counts := make(map[string]termCounts)
+	for _, term := range tokenize(book.Title) {
+		c := counts[term]
+		c.title++
+		counts[term] = c
+	}
+	for _, term := range tokenize(book.Author) {
+		c := counts[term]
+		c.author++
+		counts[term] = c
+	}
+	for _, term := range tokenize(book.Description) {
+		c := counts[term]
+		c.description++
+		counts[term] = c
+	}
+	return counts
+}
+
+// remove drops the book with the given ID from the index.
+func (x *textIndex) remove(id int) {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	x.removeLocked(id)
+}
+
+// removeLocked is remove for callers holding x.mu.
+func (x *textIndex) removeLocked(id int) {
+	for _, term := range x.terms[id] {
+		postings := x.postings[term]
+		delete(postings, id)
+		if len(postings) == 0 {
+			delete(x.postings, term)
+			if i, found := slices.BinarySearch(x.sorted, term); found {
+				x.sorted = slices.Delete(x.sorted, i, i+1)
+			}
+		}
+	}
+	delete(x.terms, id)
+}
+
+// verify checks that the index holds exactly the terms of books, keyed by
+// book ID, returning a violation for each book indexed differently.
+func (x *textIndex) verify(books map[int]Book) []string {
+	x.mu.RLock()
+	defer x.mu.RUnlock()
+
+	var violations []string
+	for id, book := range books {
+		counts := bookTermCounts(book)
+		if len(x.terms[id]) != len(counts) {
+			violations = append(violations, fmt.Sprintf("book %d has %d indexed terms, want %d", id, len(x.terms[id]), len(counts)))
+			continue
+		}
+		for term, c := range counts {
+			if x.postings[term][id] != c {
+				violations = append(violations, fmt.Sprintf("book %d is indexed under %q with counts %v, want %v", id, term, x.postings[term][id], c))
+			}
+		}
+	}
+	for id := range x.terms {
+		if _, found := books[id]; !found {
+			violations = append(violations, fmt.Sprintf("missing book %d is indexed", id))
+		}
+	}
+	for term, postings := range x.postings {
+		for id := range postings {
+			if !slices.Contains(x.terms[id], term) {
+				violations = append(violations, fmt.Sprintf("posting of %q for book %d is not in its term list", term, id))
+			}
+		}
+		if _, found := slices.BinarySearch(x.sorted, term); !found {
+			violations = append(violations, fmt.Sprintf("term %q is missing from the sorted terms", term))
+		}
+	}
+	if len(x.sorted) != len(x.postings) || !slices.IsSorted(x.sorted) {
+		violations = append(violations, fmt.Sprintf("sorted terms hold %d terms, want the %d indexed in order", len(x.sorted), len(x.postings)))
+	}
+	return violations
+}
+
+// Search intersects the postings of the terms of query, each matching the
+// indexed terms it is a prefix of, starting with the rarest, and ranks the
+// books left by term frequency and field weight.
+func (x *textIndex) Search(query string, inDescription bool) []SearchHit {
+	terms := tokenize(query)
+	if len(terms) == 0 {
+		return nil
+	}
+
+	x.mu.RLock()
+	defer x.mu.RUnlock()
+
+	lists := make([]map[int]termCounts, 0, len(terms))
+	for _, term := range terms {
+		postings := x.prefixPostingsLocked(term)
+		if len(postings) == 0 {
+			return nil
+		}
+		lists = append(lists, postings)
+	}
+	sort.Slice(lists, func(i, j int) bool { return len(lists[i]) < len(lists[j]) })
+
+	var hits []SearchHit
+	for id := range lists[0] {
+		score := 0
+		for _, postings := range lists {
+			c, ok := postings[id]
+			if !ok {
+				score = 0
+				break
+			}
+			s := c.score(inDescription)
+			if s == 0 {
+				// The term only appears in a field not searched.
+				score = 0
+				break
+			}
+			score += s
+		}
+		if score > 0 {
+			hits = append(hits, SearchHit{ID: id, Score: score})
+		}
+	}
+	sort.Slice(hits, func(i, j int) bool {
+		if hits[i].Score != hits[j].Score {
+			return hits[i].Score > hits[j].Score
+		}
+		return hits[i].ID < hits[j].ID
+	})
+	return hits
+}
+
+// prefixPostingsLocked returns the postings of the terms starting with
+// prefix, the counts of a book summed over them. Callers must hold x.mu.
+func (x *textIndex) prefixPostingsLocked(prefix string) map[int]termCounts {
+	i, _ := slices.BinarySearch(x.sorted, prefix)
+	j := i
+	for j < len(x.sorted) && strings.HasPrefix(x.sorted[j], prefix) {
+		j++
+	}
+	if j-i == 1 {
+		return x.postings[x.sorted[i]]
+	}
+	merged := make(map[int]termCounts)
+	for _, term := range x.sorted[i:j] {
+		for id, c := range x.postings[term] {
+			m := merged[id]
+			m.title += c.title
+			m.author += c.author
+			m.description += c.description
+			merged[id] = m
+		}
+	}
+	return merged
+}
+
+// IndexStats reports the number of terms and postings, and an estimate of
+// the memory they hold: the term strings and map entries of both maps.
+func (x *textIndex) IndexStats() IndexStats {
+	x.mu.RLock()
+	defer x.mu.RUnlock()
+
+	const termEntry, postingEntry = 48, 32 // approximate map entry sizes
+	stats := IndexStats{Terms: len(x.postings)}
+	for term, postings := range x.postings {
+		stats.Postings += len(postings)
+		stats.Bytes += len(term) + termEntry
+	}
+	stats.Bytes += 2 * stats.Postings * postingEntry // postings and per-book term lists
+	stats.Bytes += len(x.sorted) * 16                // string headers of the sorted terms
+	return stats
+}
+
+// tokenize splits text into lowercase terms at every character that is
+// not a letter or digit.
+func tokenize(text string) []string {
+	return strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+}
@@ -0,0 +1,253 @@
+package booksapi
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"time"
+)
+
+// selfCheck is the client of a self-check, talking to the server under test
+// over HTTP as any client would.
+type selfCheck struct {
+	base    string // URL of the server, without a trailing slash
+	token   string // bearer token sent with every request, if any
+	client  *http.Client
+	created []int // IDs of the books the check created, deleted at the end
+}
+
+// selfCheckBook is the part of a book response the self-check looks at.
+type selfCheckBook struct {
+	ID     int    `json:"id"`
+	Name   string `json:"name"`
+	Author string `json:"author"`
+}
+
+// selfCheckStep is one step of the self-check scenario.
+type selfCheckStep struct {
+	name string
+	run  func(c *selfCheck) error
+}
+
+// runSelfCheck serves handler on an ephemeral local port and runs a scripted
+// scenario against it through the whole stack: create a book, read it, find
+// it in a filtered listing, update it, read it conditionally, delete it,
+// and check it is gone. Each step is reported with its timing on stdout.
+// Books the check created are deleted even when a step fails, so it can run
+// against a persistent store. It returns the exit status: 0 if every step
+// passed, 1 naming the first that failed otherwise.
+func runSelfCheck(handler http.Handler) int {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "selfcheck: %v\n", err)
+		return 1
+	}
+	server := &http.Server{Handler: handler}
+	go server.Serve(ln)
+	defer func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		server.Shutdown(ctx)
+	}()
+
+	c := &selfCheck{base: "http://" + ln.Addr().String() + basePath, client: &http.Client{Timeout: 10 * time.Second}}
+	for _, token := range currentConfig().tokens {
+		if token.grants(scopeWrite) {
+			c.token = token.secret
+			break
+		}
+	}
+	defer c.cleanUp()
+
+	// A random marker tells the check's book from any other with a similar
+	// name on a persistent store.
+	marker := make([]byte, 8)
+	rand.Read(marker)
+	author := "selfcheck-" + hex.EncodeToString(marker)
+
+	var id int
+	var etag string
+	steps := []selfCheckStep{
+		{"create", func(c *selfCheck) error {
+			var book selfCheckBook
+			resp, err := c.do(http.MethodPost, "/books", nil, map[string]any{"name": "Self-check", "author": author}, &book)
+			if err != nil {
+				return err
+			}
+			if err := wantStatus(resp, http.StatusCreated); err != nil {
+				return err
+			}
+			if book.ID == 0 {
+				return fmt.Errorf("no ID in the created book")
+			}
+			id = book.ID
+			c.created = append(c.created, id)
+			return nil
+		}},
+		{"get", func(c *selfCheck) error {
+			var book selfCheckBook
+			resp, err := c.do(http.MethodGet, "/books/"+strconv.Itoa(id), nil, nil, &book)
+			if err != nil {
+				return err
+			}
+			if err := wantStatus(resp, http.StatusOK); err != nil {
+				return err
+			}
+			if book.ID != id || book.Author != author {
+				return fmt.Errorf("got book %d by %q, want book %d by %q", book.ID, book.Author, id, author)
+			}
+			return nil
+		}},
+		{"list", func(c *selfCheck) error {
+			var list []selfCheckBook
+			query := url.Values{"author": {author}, "match": {matchExact}}
+			resp, err := c.do(http.MethodGet, "/books?"+query.Encode(), nil, nil, &list)
+			if err != nil {
+				return err
+			}
+			if err := wantStatus(resp, http.StatusOK); err != nil {
+				return err
+			}
+			if len(list) != 1 || list[0].ID != id {
+				return fmt.Errorf("listing by author returned %d books, want only book %d", len(list), id)
+			}
+			return nil
+		}},
+		{"update", func(c *selfCheck) error {
+			var book selfCheckBook
+			resp, err := c.do(http.MethodPut, "/books/"+strconv.Itoa(id), nil, map[string]any{"name": "Self-check, updated", "author": author}, &book)
+			if err != nil {
+				return err
+			}
+			if err := wantStatus(resp, http.StatusOK); err != nil {
+				return err
+			}
+			if book.Name != "Self-check, updated" {
+				return fmt.Errorf("got name %q after the update", book.Name)
+			}
+			if etag = resp.Header.Get("ETag"); etag == "" {
+				return fmt.Errorf("no ETag in the response")
+			}
+			return nil
+		}},
+		{"conditional get", func(c *selfCheck) error {
+			resp, err := c.do(http.MethodGet, "/books/"+strconv.Itoa(id), http.Header{"If-None-Match": {etag}}, nil, nil)
+			if err != nil {
+				return err
+			}
+			return wantStatus(resp, http.StatusNotModified)
+		}},
+		{"delete", func(c *selfCheck) error {
+			resp, err := c.do(http.MethodDelete, "/books/"+strconv.Itoa(id), http.Header{"If-Match": {etag}}, nil, nil)
+			if err != nil {
+				return err
+			}
+			if err := wantStatus(resp, http.StatusNoContent); err != nil {
+				return err
+			}
+			c.created = nil
+			return nil
+		}},
+		{"get deleted", func(c *selfCheck) error {
+			resp, err := c.do(http.MethodGet, "/books/"+strconv.Itoa(id), nil, nil, nil)
+			if err != nil {
+				return err
+			}
+			return wantStatus(resp, http.StatusNotFound)
+		}},
+	}
+
+	started := time.Now()
+	for _, step := range steps {
+		t := time.Now()
+		if err := step.run(c); err != nil {
+			fmt.Printf("selfcheck: %-16s FAIL %v (%v)\n", step.name, err, time.Since(t).Round(time.Microsecond))
+			fmt.Printf("selfcheck: failed at step %q\n", step.name)
+			return 1
+		}
+		fmt.Printf("selfcheck: %-16s ok   (%v)\n", step.name, time.Since(t).Round(time.Microsecond))
+	}
+	fmt.Printf("selfcheck: passed %d steps in %v\n", len(steps), time.Since(started).Round(time.Microsecond))
+	return 0
+}
+
+// do sends a request with the given extra headers and JSON body, if not
+// nil, and decodes a successful JSON response body into out, if not nil.
+// The body is read in full, and left in resp for wantStatus to read again.
+func (c *selfCheck) do(method, path string, header http.Header, body, out any) (*http.Response, error) {
+	var reqBody io.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return nil, err
+		}
+		reqBody = bytes.NewReader(data)
+	}
+	req, err := http.NewRequest(method, c.base+path, reqBody)
+	if err != nil {
+		return nil, err
+	}
+	for name, values := range header {
+		req.Header[name] = values
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if c.token != "" {
+		req.Header.Set("Authorization", "Bearer "+c.token)
+	}
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	resp.Body = io.NopCloser(bytes.NewReader(data))
+	if out != nil && resp.StatusCode < 300 {
+		if err := json.Unmarshal(data, out); err != nil {
+			return nil, fmt.Errorf("decoding the response: %w", err)
+		}
+	}
+	return resp, nil
+}
+
+// cleanUp deletes the books the check created and did not delete itself,
+// whatever their version, reporting the ones it could not.
+func (c *selfCheck) cleanUp() {
+	for _, id := range c.created {
+		resp, err := c.do(http.MethodDelete, "/books/"+strconv.Itoa(id), http.Header{"If-Match": {"*"}}, nil, nil)
+		if err == nil && resp.StatusCode != http.StatusNoContent && resp.StatusCode != http.StatusNotFound {
+			err = fmt.Errorf("status %d", resp.StatusCode)
+		}
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "selfcheck: could not delete book %d it created: %v\n", id, err)
+		}
+	}
+	c.created = nil
+}
+
+// wantStatus returns an error unless resp has the status code want, with
+// the error body of the response if there is one.
+func wantStatus(resp *http.Response, want int) error {
+	if resp.StatusCode == want {
+		return nil
+	}
+	var body errorBody
+	data, _ := io.ReadAll(resp.Body)
+	if json.Unmarshal(data, &body) == nil && body.Error.Code != "" {
+		return fmt.Errorf("status %d (%s), want %d", resp.StatusCode, body.Error.Code, want)
+	}
+	return fmt.Errorf("status %d, want %d", resp.StatusCode, want)
+}
@@ -0,0 +1,125 @@
+package booksapi
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+// captureStdout returns what fn prints to standard output.
+func captureStdout(t *testing.T, fn func()) string {
+	t.Helper()
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	stdout := os.Stdout
+	os.Stdout = w
+	defer func() { os.Stdout = stdout }()
+	out := make(chan string)
+	go func() {
+		data, _ := io.ReadAll(r)
+		out <- string(data)
+	}()
+	fn()
+	w.Close()
+	return <-out
+}
+
+// selfCheckSteps are the steps of the self-check scenario, in order.
+var selfCheckSteps = []string{"create", "get", "list", "update", "conditional get", "delete", "get deleted"}
+
+// TestSelfCheck runs the self-check against the memory store and a
+// journal, with and without tokens, and checks that it passes every step
+// and leaves no book behind.
+func TestSelfCheck(t *testing.T) {
+	stores := map[string]func(t *testing.T) (BookStore, func() BookStore){
+		"memory": func(t *testing.T) (BookStore, func() BookStore) {
+			s := newMemoryStore(newSequentialIDs())
+			return s, func() BookStore { return s }
+		},
+		"journal": func(t *testing.T) (BookStore, func() BookStore) {
+			path := filepath.Join(t.TempDir(), "journal")
+			open := func() BookStore {
+				j, err := openJournal(newMemoryStore(newSequentialIDs()), path)
+				if err != nil {
+					t.Fatal(err)
+				}
+				t.Cleanup(func() { j.Close() })
+				return j
+			}
+			return open(), open
+		},
+	}
+	for name, newStore := range stores {
+		for _, tokens := range []bool{false, true} {
+			t.Run(fmt.Sprintf("%s/tokens=%v", name, tokens), func(t *testing.T) {
+				s, reopen := newStore(t)
+				h := newTestServer(t, WithStore(s))
+				mustCreateBook(t, h, map[string]any{"title": "Dune"})
+				if tokens {
+					withTokensFile(t)
+				}
+
+				var status int
+				out := captureStdout(t, func() { status = runSelfCheck(h) })
+				if status != 0 {
+					t.Fatalf("status %d, want 0:\n%s", status, out)
+				}
+				for _, step := range selfCheckSteps {
+					if !regexp.MustCompile(`(?m)^selfcheck: ` + regexp.QuoteMeta(step) + ` +ok +\(.+\)$`).MatchString(out) {
+						t.Errorf("no timing reported for step %q:\n%s", step, out)
+					}
+				}
+				if !strings.Contains(out, "selfcheck: passed 7 steps in ") {
+					t.Errorf("no summary:\n%s", out)
+				}
+
+				// Only the book there before is left, after a restart too.
+				for _, s := range []BookStore{store, reopen()} {
+					if got := s.Count(); got != 1 {
+						t.Errorf("%d books left, want 1", got)
+					}
+				}
+			})
+		}
+	}
+}
+
+// putFailingStore fails every Put, so a self-check fails at its update.
+type putFailingStore struct {
+	BookStore
+}
+
+func (s putFailingStore) Put(book Book) error {
+	return fmt.Errorf("%w: disk I/O error", ErrUnavailable)
+}
+
+// TestSelfCheckFailure checks that a self-check against a store breaking
+// one step exits with status 1 naming the step, after deleting the book
+// it created.
+func TestSelfCheckFailure(t *testing.T) {
+	h := newTestServer(t, WithStore(putFailingStore{newMemoryStore(newSequentialIDs())}))
+
+	var status int
+	out := captureStdout(t, func() { status = runSelfCheck(h) })
+	if status != 1 {
+		t.Fatalf("status %d, want 1:\n%s", status, out)
+	}
+	if !strings.Contains(out, `selfcheck: failed at step "update"`) || !strings.Contains(out, "FAIL status 503 (store_unavailable), want 200") {
+		t.Errorf("failure not reported at the update:\n%s", out)
+	}
+	if strings.Contains(out, "conditional get") {
+		t.Errorf("steps run after the failure:\n%s", out)
+	}
+	if got := store.Count(); got != 0 {
+		t.Errorf("%d books left after the failure, want 0", got)
+	}
+	if _, ok := store.Get(1); ok {
+		t.Error("the created book is still there")
+	}
+}
@@ -0,0 +1,238 @@
+package booksapi
+
+import (
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Series represents a series of books published as ordered volumes.
+type Series struct {
+	ID   int    `json:"id"`
+	Name string `json:"name"`
+}
+
+// Global variables to store series. When both locks are needed, mu must be
+// acquired before seriesMu.
+var (
+	seriesList   = make(map[int]Series)
+	nextSeriesID = 1
+	seriesMu     sync.Mutex
+)
+
+// seriesCollectionHandler handles general series collection operations (GET, POST).
+func seriesCollectionHandler(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		getAllSeries(w, r)
+	case http.MethodPost:
+		createSeries(w, r)
+	default:
+		writeError(w, r, http.StatusMethodNotAllowed, "method_not_allowed")
+	}
+}
+
+// seriesHandler handles operations on a specific series and its volumes.
+func seriesHandler(w http.ResponseWriter, r *http.Request) {
+	segments := pathSegments(r.URL.Path)
+	if len(segments) < 2 || len(segments) > 3 || (len(segments) == 3 && segments[2] != "books") {
+		writeError(w, r, http.StatusNotFound, "not_found")
+		return
+	}
+
+	id, err := parseResourceID(segments[1], "invalid_series_id")
+	if err != nil {
+		writeAPIError(w, r, err)
+		return
+	}
+
+	if len(segments) == 3 {
+		if r.Method != http.MethodGet {
+			writeError(w, r, http.StatusMethodNotAllowed, "method_not_allowed")
+			return
+		}
+		getSeriesBooks(w, r, id)
+		return
+	}
+
+	if _, ok := checkQuery(w, r); !ok {
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		getSeries(w, r, id)
+	case http.MethodPut:
+		updateSeries(w, r, id)
+	case http.MethodDelete:
+		deleteSeries(w, r, id)
+	default:
+		writeError(w, r, http.StatusMethodNotAllowed, "method_not_allowed")
+	}
+}
+
+// getAllSeries retrieves the list of all series, ordered by ID and paginated.
+func getAllSeries(w http.ResponseWriter, r *http.Request) {
+	q, ok := checkQuery(w, r, paginationParams...)
+	if !ok {
+		return
+	}
+
+	seriesMu.Lock()
+	defer seriesMu.Unlock()
+
+	list := make([]Series, 0, len(seriesList))
+	for _, series := range seriesList {
+		list = append(list, series)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].ID < list[j].ID })
+
+	writeJSON(w, http.StatusOK, paginateQuery(w, r, q, PagingPolicy{}, list))
+}
+
+// createSeries creates a new series.
+func createSeries(w http.ResponseWriter, r *http.Request) {
+	if _, ok := checkQuery(w, r); !ok {
+		return
+	}
+
+	var series Series
+	if err := decodeJSON(w, r, &series); err != nil {
+		writeAPIError(w, r, err)
+		return
+	}
+	if strings.TrimSpace(series.Name) == "" {
+		writeError(w, r, http.StatusUnprocessableEntity, "field_required", "field", "name")
+		return
+	}
+
+	seriesMu.Lock()
+	series.ID = nextSeriesID
+	nextSeriesID++
+	seriesList[series.ID] = series
+	seriesMu.Unlock()
+
+	setLocation(w, "/series/"+strconv.Itoa(series.ID))
+	writeJSON(w, http.StatusCreated, series)
+}
+
+// getSeries retrieves a specific series by its ID.
+func getSeries(w http.ResponseWriter, r *http.Request, id int) {
+	seriesMu.Lock()
+	defer seriesMu.Unlock()
+
+	series, found := seriesList[id]
+	if !found {
+		writeError(w, r, http.StatusNotFound, "series_not_found")
+		return
+	}
+
+	writeJSON(w, http.StatusOK, series)
+}
+
+// updateSeries updates an existing series' name.
+func updateSeries(w http.ResponseWriter, r *http.Request, id int) {
+	seriesMu.Lock()
+	defer seriesMu.Unlock()
+
+	series, found := seriesList[id]
+	if !found {
+		writeError(w, r, http.StatusNotFound, "series_not_found")
+		return
+	}
+
+	if err := decodeJSON(w, r, &series); err != nil {
+		writeAPIError(w, r, err)
+		return
+	}
+	if strings.TrimSpace(series.Name) == "" {
+		writeError(w, r, http.StatusUnprocessableEntity, "field_required", "field", "name")
+		return
+	}
+
+	series.ID = id
+	seriesList[id] = series
+	writeJSON(w, http.StatusOK, series)
+}
+
+// deleteSeries removes a series. Its volumes are kept and detached from it.
+func deleteSeries(w http.ResponseWriter, r *http.Request, id int) {
+	mu.Lock()
+	defer mu.Unlock()
+	seriesMu.Lock()
+	defer seriesMu.Unlock()
+
+	if _, found := seriesList[id]; !found {
+		writeError(w, r, http.StatusNotFound, "series_not_found")
+		return
+	}
+
+	for _, book := range store.List(ListOptions{}) {
+		if book.SeriesID != nil && *book.SeriesID == id {
+			book.SeriesID = nil
+			book.SeriesIndex = 0
+			if err := store.Put(book); err != nil {
+				writeAPIError(w, r, err)
+				return
+			}
+		}
+	}
+	delete(seriesList, id)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// getSeriesBooks lists the volumes of a series ordered by their index.
+func getSeriesBooks(w http.ResponseWriter, r *http.Request, id int) {
+	q, ok := checkQuery(w, r, bookListParams...)
+	if !ok {
+		return
+	}
+
+	if !seriesExists(id) {
+		writeError(w, r, http.StatusNotFound, "series_not_found")
+		return
+	}
+
+	var bookList []Book
+	for _, book := range store.List(ListOptions{}) {
+		if book.SeriesID != nil && *book.SeriesID == id {
+			bookList = append(bookList, book)
+		}
+	}
+	sort.Slice(bookList, func(i, j int) bool { return bookList[i].SeriesIndex < bookList[j].SeriesIndex })
+
+	writeJSON(w, http.StatusOK, renderBooks(r, paginateQuery(w, r, q, PagingPolicy{}, bookList)))
+}
+
+// checkSeriesIndex verifies that no other book already holds the volume
+// number a book claims within its series. Callers must hold mu.
+func checkSeriesIndex(book Book) error {
+	if book.SeriesID == nil {
+		return nil
+	}
+	for _, other := range store.List(ListOptions{}) {
+		if other.ID != book.ID && other.SeriesID != nil && *other.SeriesID == *book.SeriesID && other.SeriesIndex == book.SeriesIndex {
+			return newAPIError(http.StatusConflict, "series_index_taken", "series", *book.SeriesID, "index", book.SeriesIndex)
+		}
+	}
+	return nil
+}
+
+// seriesExists reports whether a series with the given ID exists.
+func seriesExists(id int) bool {
+	seriesMu.Lock()
+	defer seriesMu.Unlock()
+
+	_, found := seriesList[id]
+	return found
+}
+
+// seriesName returns the name of the series with the given ID.
+func seriesName(id int) string {
+	seriesMu.Lock()
+	defer seriesMu.Unlock()
+
+	return seriesList[id].Name
+}
@@ -0,0 +1,201 @@
+package booksapi
+
+import (
+	"net/http"
+	"strconv"
+	"sync"
+	"testing"
+)
+
+// TestBookLifecycle walks a book through the API: created, read, listed,
+// updated, deleted, and gone.
+func TestBookLifecycle(t *testing.T) {
+	h := newTestServer(t)
+
+	created := serve(t, h, http.MethodPost, "/books", map[string]any{"title": "Dune", "author": "Frank Herbert", "price": 9.99})
+	wantCode(t, created, http.StatusCreated)
+	if got := created.Header().Get("Location"); got != "/books/1" {
+		t.Errorf("Location %q, want /books/1", got)
+	}
+	checkGolden(t, "lifecycle/create.json", created.Body.Bytes())
+	mustCreateBook(t, h, map[string]any{"title": "Emma", "author": "Jane Austen", "price": 5.5})
+
+	got := serve(t, h, http.MethodGet, "/books/1", nil)
+	wantCode(t, got, http.StatusOK)
+	checkGolden(t, "lifecycle/get.json", got.Body.Bytes())
+
+	list := serve(t, h, http.MethodGet, "/books", nil)
+	wantCode(t, list, http.StatusOK)
+	checkGolden(t, "lifecycle/list.json", list.Body.Bytes())
+
+	updated := serve(t, h, http.MethodPut, "/books/1", map[string]any{"title": "Dune Messiah", "author": "Frank Herbert", "price": 12})
+	wantCode(t, updated, http.StatusOK)
+	checkGolden(t, "lifecycle/update.json", updated.Body.Bytes())
+
+	wantCode(t, serve(t, h, http.MethodDelete, "/books/1", nil), http.StatusNoContent)
+	gone := serve(t, h, http.MethodGet, "/books/1", nil)
+	wantCode(t, gone, http.StatusNotFound)
+	checkGolden(t, "lifecycle/get_deleted.json", gone.Body.Bytes())
+
+	books := decode[[]Book](t, serve(t, h, http.MethodGet, "/books", nil))
+	if len(books) != 1 || books[0].Title != "Emma" {
+		t.Errorf("listing after the delete holds %+v, want only Emma", books)
+	}
+}
+
+// TestErrorStatuses checks the status and error code of requests the API
+// refuses.
+func TestErrorStatuses(t *testing.T) {
+	h := newTestServer(t)
+	mustCreateBook(t, h, map[string]any{"title": "Dune", "author": "Frank Herbert"})
+
+	tests := []struct {
+		name   string
+		method string
+		path   string
+		body   any
+		status int
+		code   string
+	}{
+		{"get missing book", http.MethodGet, "/books/99", nil, http.StatusNotFound, "book_not_found"},
+		{"update missing book", http.MethodPut, "/books/99", map[string]any{"title": "X"}, http.StatusNotFound, "book_not_found"},
+		{"delete missing book", http.MethodDelete, "/books/99", nil, http.StatusNotFound, "book_not_found"},
+		{"unknown route", http.MethodGet, "/nothing", nil, http.StatusNotFound, "not_found"},
+		{"unknown book subresource", http.MethodGet, "/books/1/nothing", nil, http.StatusNotFound, "not_found"},
+		{"invalid ID", http.MethodGet, "/books/abc", nil, http.StatusBadRequest, "invalid_book_id"},
+		{"zero ID", http.MethodGet, "/books/0", nil, http.StatusBadRequest, "invalid_book_id"},
+		{"leading zero ID", http.MethodGet, "/books/01", nil, http.StatusBadRequest, "invalid_book_id"},
+		{"delete collection", http.MethodDelete, "/books", nil, http.StatusMethodNotAllowed, "method_not_allowed"},
+		{"put collection", http.MethodPut, "/books", map[string]any{"title": "X"}, http.StatusMethodNotAllowed, "method_not_allowed"},
+		{"post to book", http.MethodPost, "/books/1", map[string]any{"title": "X"}, http.StatusMethodNotAllowed, "method_not_allowed"},
+		{"post to search", http.MethodPost, "/books/search", nil, http.StatusMethodNotAllowed, "method_not_allowed"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := serve(t, h, tt.method, tt.path, tt.body)
+			wantCode(t, rec, tt.status)
+			if got := errorCode(t, rec); got != tt.code {
+				t.Errorf("error code %q, want %q", got, tt.code)
+			}
+		})
+	}
+}
+
+// TestMalformedBodies checks that bodies that are not a valid book get a
+// 4xx and leave the catalog as it was.
+func TestMalformedBodies(t *testing.T) {
+	h := newTestServer(t)
+	mustCreateBook(t, h, map[string]any{"title": "Dune", "author": "Frank Herbert"})
+
+	tests := []struct {
+		name   string
+		body   string
+		header []string
+		status int
+		code   string
+	}{
+		{"empty", "", nil, http.StatusBadRequest, "empty_body"},
+		{"truncated", `{"title": "X"`, nil, http.StatusBadRequest, "invalid_json"},
+		{"not an object", `["title"]`, nil, http.StatusBadRequest, "invalid_body_type"},
+		{"trailing data", `{"title": "X"} {}`, []string{"Prefer", "handling=strict"}, http.StatusBadRequest, "trailing_data"},
+		{"unknown field", `{"title": "X", "colour": "red"}`, []string{"Prefer", "handling=strict"}, http.StatusBadRequest, "unknown_field"},
+		{"wrong type", `{"title": 12}`, nil, http.StatusBadRequest, "invalid_field_type"},
+		{"blank title", `{"title": "", "author": "Nobody"}`, nil, http.StatusUnprocessableEntity, "field_required"},
+		{"negative price", `{"title": "X", "price": -1}`, nil, http.StatusUnprocessableEntity, "price_out_of_range"},
+		{"invalid UTF-8", "{\"title\": \"\xff\"}", nil, http.StatusUnprocessableEntity, "invalid_utf8"},
+	}
+	for _, tt := range tests {
+		for _, method := range []string{http.MethodPost, http.MethodPut} {
+			t.Run(method+" "+tt.name, func(t *testing.T) {
+				path := "/books"
+				if method == http.MethodPut {
+					path = "/books/1"
+				}
+				rec := serve(t, h, method, path, tt.body, tt.header...)
+				wantCode(t, rec, tt.status)
+				if got := errorCode(t, rec); got != tt.code {
+					t.Errorf("error code %q, want %q", got, tt.code)
+				}
+				books := decode[[]Book](t, serve(t, h, http.MethodGet, "/books", nil))
+				if len(books) != 1 || books[0].Title != "Dune" {
+					t.Errorf("catalog changed to %+v", books)
+				}
+			})
+		}
+	}
+}
+
+// TestConcurrentCreates creates books from many goroutines at once and
+// checks that each got an ID of its own. Run with -race.
+func TestConcurrentCreates(t *testing.T) {
+	h := newTestServer(t)
+
+	const n = 50
+	ids := make(chan int, n)
+	var wg sync.WaitGroup
+	for i := range n {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			rec := serve(t, h, http.MethodPost, "/books", map[string]any{"title": "Book " + strconv.Itoa(i)})
+			if rec.Code != http.StatusCreated {
+				t.Errorf("create %d: status %d: %s", i, rec.Code, rec.Body.String())
+				return
+			}
+			ids <- decode[Book](t, rec).ID
+		}()
+	}
+	wg.Wait()
+	close(ids)
+
+	seen := make(map[int]bool)
+	for id := range ids {
+		if seen[id] {
+			t.Errorf("ID %d handed out twice", id)
+		}
+		seen[id] = true
+	}
+	if len(seen) != n {
+		t.Errorf("%d distinct IDs, want %d", len(seen), n)
+	}
+	if count := len(decode[[]Book](t, serve(t, h, http.MethodGet, "/books?limit=1000", nil))); count != n {
+		t.Errorf("listing holds %d books, want %d", count, n)
+	}
+}
+
+// TestRejectedWrites checks that writes rejected after their body was
+// decoded leave the stored book as it was, down to the fields held by
+// reference.
+func TestRejectedWrites(t *testing.T) {
+	h := newTestServer(t)
+	setForTest(t, &customAttributes, map[string]int{"shelf": 10})
+	mustCreateBook(t, h, map[string]any{
+		"title": "Dune", "price": 9.99, "cost_price": 4.5,
+		"editions":   []map[string]any{{"format": "ebook", "price": 3}},
+		"attributes": map[string]any{"shelf": "A1"},
+	})
+	before := catalogJSON(t, store)
+
+	// Each is valid but for the blank title.
+	change := map[string]any{
+		"title": " ", "cost_price": 7, "created_at": "2000-01-01T00:00:00Z",
+		"editions":   []map[string]any{{"format": "ebook", "price": 9}},
+		"attributes": map[string]any{"shelf": "B2"},
+	}
+	writes := []struct {
+		name, method, path string
+		body               any
+		header             []string
+	}{
+		{"replace", http.MethodPut, "/books/1", change, nil},
+		{"merge patch", http.MethodPatch, "/books/1", change, []string{"Content-Type", mergePatchType}},
+		{"transaction", http.MethodPost, "/books/transactions", map[string]any{"operations": []map[string]any{{"op": "update", "id": 1, "book": change}}}, nil},
+	}
+	for _, tt := range writes {
+		rec := serve(t, h, tt.method, tt.path, tt.body, tt.header...)
+		wantCode(t, rec, http.StatusUnprocessableEntity)
+		if after := catalogJSON(t, store); after != before {
+			t.Errorf("%s: rejected write changed the book from %s to %s", tt.name, before, after)
+		}
+	}
+}
@@ -0,0 +1,366 @@
+package booksapi
+
+import (
+	"context"
+	"encoding/json"
+	"expvar"
+	"fmt"
+	"io"
+	"math/rand/v2"
+	"net/http"
+	"slices"
+	"sync"
+	"time"
+)
+
+// Shadow mode settings, configured by flags in Main. An empty
+// shadowStorageKind disables shadow mode; shadowSamplePercent is the share
+// of reads compared with the shadow store.
+var (
+	shadowStorageKind   string
+	shadowSamplePercent = 1.0
+)
+
+// Shadow mode limits.
+const (
+	shadowQueueSize         = 1024 // writes and comparisons waiting for the shadow store
+	maxReportedDivergences  = 100  // most recent divergences kept for the report
+	maxShadowComparedValues = 4096 // bytes of each result kept in a divergence
+)
+
+// Shadow mode counters, published under /debug/vars as store_shadow.
+var shadowMetrics = expvar.NewMap("store_shadow")
+
+// storeShadow is the shadow store wrapper, or nil without shadow mode.
+var storeShadow *shadowStore
+
+// shadowStore sends every successful write of the store it wraps, the
+// primary, to a second store, the shadow, and compares a sample of reads
+// with it, so a new backend can be tried against live traffic. Reads are
+// answered by the primary alone. A single goroutine writes to and reads
+// from the shadow in the order the primary's writes returned, which keeps
+// the writes to each book in order, as they are made under mu, and lets a
+// comparison see the writes made before its read. Shadow failures are
+// logged and counted but never returned. A write only waits when
+// shadowQueueSize operations are already queued, and a sampled read never
+// does. A read racing a write of the same book may be reported as a
+// divergence that is not one.
+type shadowStore struct {
+	BookStore
+	shadow BookStore
+	queue  chan func()
+	done   chan struct{}
+
+	mu          sync.Mutex
+	comparisons int64
+	divergences []shadowDivergence // the most recent maxReportedDivergences
+	diverged    int64
+}
+
+// shadowDivergence is a read whose result differed between the primary and
+// the shadow store.
+type shadowDivergence struct {
+	Op      string          `json:"op"`
+	At      time.Time       `json:"at"`
+	Primary json.RawMessage `json:"primary"`
+	Shadow  json.RawMessage `json:"shadow"`
+}
+
+// shadowReport is the response body of GET /admin/shadow/report.
+type shadowReport struct {
+	ShadowStorage string             `json:"shadow_storage"`
+	SamplePercent float64            `json:"sample_percent"`
+	Queued        int                `json:"queued"`
+	WriteFailures int64              `json:"write_failures"`
+	Comparisons   int64              `json:"comparisons"`
+	Divergences   int64              `json:"divergences"`
+	Recent        []shadowDivergence `json:"recent"`
+}
+
+// newShadowStore wraps primary, shadowing it with shadow.
+func newShadowStore(primary, shadow BookStore) *shadowStore {
+	s := &shadowStore{
+		BookStore: primary,
+		shadow:    shadow,
+		queue:     make(chan func(), shadowQueueSize),
+		done:      make(chan struct{}),
+	}
+	go s.run()
+	return s
+}
+
+// run carries out the queued shadow operations in order.
+func (s *shadowStore) run() {
+	defer close(s.done)
+	for op := range s.queue {
+		op()
+	}
+}
+
+// Unwrap returns the primary store.
+func (s *shadowStore) Unwrap() BookStore { return s.BookStore }
+
+// shadowWrite queues a write to the shadow store.
+func (s *shadowStore) shadowWrite(method string, id int, write func() error) {
+	s.queue <- func() {
+		if err := write(); err != nil {
+			shadowMetrics.Add("write_failures", 1)
+			logger.Printf("WARN shadow store write failed: method=%s id=%d: %v", method, id, err)
+		}
+	}
+}
+
+// sampled reports whether a read is to be compared with the shadow store.
+func (s *shadowStore) sampled() bool {
+	return shadowSamplePercent > 0 && rand.Float64()*100 < shadowSamplePercent
+}
+
+// compare queues a comparison of a primary read result with the result of
+// the same read on the shadow store.
+func (s *shadowStore) compare(op string, primary any, read func() any) {
+	select {
+	case s.queue <- func() { s.record(op, primary, read()) }:
+	default:
+		shadowMetrics.Add("comparisons_dropped", 1) // reads never wait for the shadow
+	}
+}
+
+// record counts a comparison, keeping it if the results differ.
+func (s *shadowStore) record(op string, primary, shadow any) {
+	shadowMetrics.Add("comparisons", 1)
+	p, _ := json.Marshal(primary)
+	sh, _ := json.Marshal(shadow)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.comparisons++
+	if string(p) == string(sh) {
+		return
+	}
+	s.diverged++
+	shadowMetrics.Add("divergences", 1)
+	logger.Printf("WARN shadow store divergence: op=%s", op)
+	d := shadowDivergence{Op: op, At: time.Now().UTC(), Primary: truncateJSON(p), Shadow: truncateJSON(sh)}
+	s.divergences = append(s.divergences, d)
+	if len(s.divergences) > maxReportedDivergences {
+		s.divergences = slices.Delete(s.divergences, 0, len(s.divergences)-maxReportedDivergences)
+	}
+}
+
+// truncateJSON keeps results short enough to report, replacing longer ones
+// with a JSON string saying how long they were.
+func truncateJSON(data []byte) json.RawMessage {
+	if len(data) <= maxShadowComparedValues {
+		return data
+	}
+	note, _ := json.Marshal(fmt.Sprintf("%d bytes, too long to report", len(data)))
+	return note
+}
+
+func (s *shadowStore) Get(id int) (Book, bool) {
+	book, found := s.BookStore.Get(id)
+	if s.sampled() {
+		s.compare(fmt.Sprintf("Get(%d)", id), shadowGetResult{book, found}, func() any {
+			book, found := s.shadow.Get(id)
+			return shadowGetResult{book, found}
+		})
+	}
+	return book, found
+}
+
+// shadowGetResult is the result of Get, as compared.
+type shadowGetResult struct {
+	Book  Book `json:"book"`
+	Found bool `json:"found"`
+}
+
+func (s *shadowStore) List(opts ListOptions) []Book {
+	list := s.BookStore.List(opts)
+	if s.sampled() {
+		s.compare(fmt.Sprintf("List(%+v)", opts), list, func() any { return s.shadow.List(opts) })
+	}
+	return list
+}
+
+func (s *shadowStore) Count() int {
+	n := s.BookStore.Count()
+	if s.sampled() {
+		s.compare("Count()", n, func() any { return s.shadow.Count() })
+	}
+	return n
+}
+
+func (s *shadowStore) Create(book Book) (Book, error) {
+	book, err := s.BookStore.Create(book)
+	if err == nil {
+		s.mirrorCreate(book)
+	}
+	return book, err
+}
+
+func (s *shadowStore) Put(book Book) error {
+	err := s.BookStore.Put(book)
+	if err == nil {
+		s.mirrorPut(book)
+	}
+	return err
+}
+
+func (s *shadowStore) Delete(id int) error {
+	err := s.BookStore.Delete(id)
+	if err == nil {
+		s.mirrorDelete(id)
+	}
+	return err
+}
+
+func (s *shadowStore) Reserve(id int) error {
+	err := s.BookStore.Reserve(id)
+	if err == nil {
+		s.mirrorReserve(id)
+	}
+	return err
+}
+
+// mirrorCreate stores the book the primary created under the same ID in the
+// shadow, since the shadow's own counter may have drifted.
+func (s *shadowStore) mirrorCreate(book Book) {
+	s.shadowWrite("Create", book.ID, func() error {
+		if err := s.shadow.Put(book); err != nil {
+			return err
+		}
+		return s.shadow.Reserve(book.ID)
+	})
+}
+
+func (s *shadowStore) mirrorPut(book Book) {
+	s.shadowWrite("Put", book.ID, func() error { return s.shadow.Put(book) })
+}
+
+func (s *shadowStore) mirrorDelete(id int) {
+	s.shadowWrite("Delete", id, func() error { return s.shadow.Delete(id) })
+}
+
+func (s *shadowStore) mirrorReserve(id int) {
+	s.shadowWrite("Reserve", id, func() error { return s.shadow.Reserve(id) })
+}
+
+// Transact runs fn as a transaction of the primary, sending its writes to
+// the shadow once it has succeeded.
+func (s *shadowStore) Transact(ctx context.Context, fn func(tx BookStore) error) error {
+	var mirrored []func()
+	err := s.BookStore.Transact(ctx, func(tx BookStore) error {
+		return fn(shadowTx{tx, s, &mirrored})
+	})
+	if err == nil {
+		for _, mirror := range mirrored {
+			mirror()
+		}
+	}
+	return err
+}
+
+// shadowTx is the view of a transaction that shadowStore hands to fn,
+// collecting the shadow writes of its writes.
+type shadowTx struct {
+	BookStore
+	s        *shadowStore
+	mirrored *[]func()
+}
+
+// Unwrap returns the wrapped transaction.
+func (tx shadowTx) Unwrap() BookStore { return tx.BookStore }
+
+func (tx shadowTx) Create(book Book) (Book, error) {
+	book, err := tx.BookStore.Create(book)
+	if err == nil {
+		*tx.mirrored = append(*tx.mirrored, func() { tx.s.mirrorCreate(book) })
+	}
+	return book, err
+}
+
+func (tx shadowTx) Put(book Book) error {
+	err := tx.BookStore.Put(book)
+	if err == nil {
+		*tx.mirrored = append(*tx.mirrored, func() { tx.s.mirrorPut(book) })
+	}
+	return err
+}
+
+func (tx shadowTx) Delete(id int) error {
+	err := tx.BookStore.Delete(id)
+	if err == nil {
+		*tx.mirrored = append(*tx.mirrored, func() { tx.s.mirrorDelete(id) })
+	}
+	return err
+}
+
+func (tx shadowTx) Reserve(id int) error {
+	err := tx.BookStore.Reserve(id)
+	if err == nil {
+		*tx.mirrored = append(*tx.mirrored, func() { tx.s.mirrorReserve(id) })
+	}
+	return err
+}
+
+// Transact runs a nested transaction, whose writes and their undoing are
+// both sent to the shadow.
+func (tx shadowTx) Transact(ctx context.Context, fn func(tx BookStore) error) error {
+	return transactWithUndo(ctx, tx, fn)
+}
+
+// Close waits for the queued shadow operations, then closes both stores if
+// they need closing.
+func (s *shadowStore) Close() error {
+	close(s.queue)
+	<-s.done
+	if closer, ok := s.shadow.(io.Closer); ok {
+		if err := closer.Close(); err != nil {
+			return err
+		}
+	}
+	if closer, ok := s.BookStore.(io.Closer); ok {
+		return closer.Close()
+	}
+	return nil
+}
+
+// Report summarizes the divergences seen so far.
+func (s *shadowStore) Report() shadowReport {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	report := shadowReport{
+		ShadowStorage: shadowStorageKind,
+		SamplePercent: shadowSamplePercent,
+		Queued:        len(s.queue),
+		Comparisons:   s.comparisons,
+		Divergences:   s.diverged,
+		Recent:        slices.Clone(s.divergences),
+	}
+	if failures, ok := shadowMetrics.Get("write_failures").(*expvar.Int); ok {
+		report.WriteFailures = failures.Value()
+	}
+	if report.Recent == nil {
+		report.Recent = []shadowDivergence{}
+	}
+	return report
+}
+
+// adminShadowReportHandler reports the divergences between the primary and
+// the shadow store (GET /admin/shadow/report). It is 404 without shadow
+// mode.
+func adminShadowReportHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		writeError(w, r, http.StatusMethodNotAllowed, "method_not_allowed")
+		return
+	}
+	if _, ok := checkQuery(w, r); !ok {
+		return
+	}
+	if storeShadow == nil {
+		writeError(w, r, http.StatusNotFound, "shadow_disabled")
+		return
+	}
+	writeJSON(w, http.StatusOK, storeShadow.Report())
+}
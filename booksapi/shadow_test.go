@@ -0,0 +1,128 @@
+package booksapi
+
+import (
+	"bytes"
+	"errors"
+	"log"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+// newTestShadowStore wraps primary with shadow, comparing every read, and
+// returns a server using it.
+func newTestShadowStore(t *testing.T, primary, shadow BookStore, opts ...Option) (*shadowStore, http.Handler) {
+	t.Helper()
+	s := newShadowStore(primary, shadow)
+	t.Cleanup(func() { s.Close() })
+	h := newTestServer(t, append(opts, WithStore(s))...)
+	setForTest(t, &storeShadow, s)
+	setForTest(t, &shadowStorageKind, "memory")
+	setForTest(t, &shadowSamplePercent, 100.0)
+	return s, h
+}
+
+// drainShadow waits for the shadow operations queued so far to be done.
+func drainShadow(s *shadowStore) {
+	done := make(chan struct{})
+	s.queue <- func() { close(done) }
+	<-done
+}
+
+// TestShadowStore checks that the writes made through the API reach the
+// shadow store, and that a divergence injected into it is detected and
+// reported.
+func TestShadowStore(t *testing.T) {
+	primary, shadow := newMemoryStore(newSequentialIDs()), newMemoryStore(newSequentialIDs())
+	s, h := newTestShadowStore(t, primary, shadow)
+
+	for _, title := range []string{"Dune", "Emma", "Hyperion"} {
+		mustCreateBook(t, h, map[string]any{"title": title, "price": 9.99})
+	}
+	wantCode(t, serve(t, h, http.MethodPut, "/books/1", map[string]any{"title": "Dune", "price": 12.5}), http.StatusOK)
+	wantCode(t, serve(t, h, http.MethodDelete, "/books/2", nil), http.StatusNoContent)
+	wantCode(t, serve(t, h, http.MethodPost, "/books/import", []any{map[string]any{"title": "Persuasion"}}), http.StatusOK)
+	drainShadow(s)
+	if got, want := catalogJSON(t, shadow), catalogJSON(t, primary); got != want {
+		t.Fatalf("shadow holds %s, want the primary's %s", got, want)
+	}
+	if got := mustCreateBook(t, h, map[string]any{"title": "Jazz"}); got.ID != 5 {
+		t.Errorf("created book %d, want 5", got.ID)
+	}
+
+	for _, path := range []string{"/books", "/books/1", "/books/2", "/books/stats"} {
+		invalidateResponseCache()
+		serve(t, h, http.MethodGet, path, nil)
+	}
+	drainShadow(s)
+	report := decode[shadowReport](t, serve(t, h, http.MethodGet, "/admin/shadow/report", nil))
+	if report.Comparisons == 0 || report.Divergences != 0 || len(report.Recent) != 0 {
+		t.Fatalf("report %+v, want comparisons and no divergences", report)
+	}
+
+	// A shadow that lost a write is found out by the next read of the book.
+	book, _ := shadow.Get(3)
+	book.Price = 1
+	if err := shadow.Put(book); err != nil {
+		t.Fatal(err)
+	}
+	invalidateResponseCache()
+	wantCode(t, serve(t, h, http.MethodGet, "/books/3", nil), http.StatusOK)
+	drainShadow(s)
+
+	rec := serve(t, h, http.MethodGet, "/admin/shadow/report", nil)
+	wantCode(t, rec, http.StatusOK)
+	report = decode[shadowReport](t, rec)
+	if report.Divergences != 1 || len(report.Recent) != 1 || report.ShadowStorage != "memory" || report.SamplePercent != 100 {
+		t.Fatalf("report %+v, want the one divergence", report)
+	}
+	d := report.Recent[0]
+	if d.Op != "Get(3)" || !strings.Contains(string(d.Primary), `"price":9.99`) || !strings.Contains(string(d.Shadow), `"price":1`) {
+		t.Errorf("divergence %s: primary %s, shadow %s; want Get(3) with both prices", d.Op, d.Primary, d.Shadow)
+	}
+}
+
+// TestShadowWriteFailures checks that writes the shadow store refuses are
+// logged and counted, and do not fail the requests making them.
+func TestShadowWriteFailures(t *testing.T) {
+	var logs bytes.Buffer
+	shadow := &failingStore{BookStore: newMemoryStore(newSequentialIDs()), err: errors.New("disk I/O error")}
+	s, h := newTestShadowStore(t, newMemoryStore(newSequentialIDs()), shadow, WithLogger(log.New(&logs, "", 0)))
+	before := decode[shadowReport](t, serve(t, h, http.MethodGet, "/admin/shadow/report", nil)).WriteFailures
+
+	mustCreateBook(t, h, map[string]any{"title": "Dune"})
+	wantCode(t, serve(t, h, http.MethodPut, "/books/1", map[string]any{"title": "Dune", "price": 5}), http.StatusOK)
+	drainShadow(s)
+
+	if got := decode[shadowReport](t, serve(t, h, http.MethodGet, "/admin/shadow/report", nil)).WriteFailures - before; got != 2 {
+		t.Errorf("%d shadow write failures counted, want 2", got)
+	}
+	for _, line := range []string{"method=Create id=1: disk I/O error", "method=Put id=1: disk I/O error"} {
+		if !strings.Contains(logs.String(), "WARN shadow store write failed: "+line) {
+			t.Errorf("logs lack the failed write %q:\n%s", line, logs.String())
+		}
+	}
+}
+
+// TestShadowSampling checks that no reads are compared at a sample of 0%,
+// and that the report is 404 without shadow mode.
+func TestShadowSampling(t *testing.T) {
+	s, h := newTestShadowStore(t, newMemoryStore(newSequentialIDs()), newMemoryStore(newSequentialIDs()))
+	setForTest(t, &shadowSamplePercent, 0.0)
+	mustCreateBook(t, h, map[string]any{"title": "Dune"})
+	for range 10 {
+		invalidateResponseCache()
+		serve(t, h, http.MethodGet, "/books/1", nil)
+	}
+	drainShadow(s)
+	if got := s.Report().Comparisons; got != 0 {
+		t.Errorf("%d comparisons at a sample of 0%%", got)
+	}
+
+	storeShadow = nil
+	rec := serve(t, h, http.MethodGet, "/admin/shadow/report", nil)
+	wantCode(t, rec, http.StatusNotFound)
+	if got := errorCode(t, rec); got != "shadow_disabled" {
+		t.Errorf("error code %q, want shadow_disabled", got)
+	}
+}
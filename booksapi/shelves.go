@@ -0,0 +1,293 @@
+package booksapi
+
+import (
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Shelf represents a named collection of books.
+type Shelf struct {
+	ID          int    `json:"id"`
+	Name        string `json:"name"`
+	Description string `json:"description"`
+}
+
+// Global variables to store shelves and their members. When both locks are
+// needed, mu must be acquired before shelvesMu.
+var (
+	shelves     = make(map[int]Shelf)
+	shelfBooks  = make(map[int]map[int]struct{}) // shelf ID -> set of book IDs
+	nextShelfID = 1
+	shelvesMu   sync.Mutex
+)
+
+// shelvesHandler handles general shelf collection operations (GET, POST).
+func shelvesHandler(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		getShelves(w, r)
+	case http.MethodPost:
+		createShelf(w, r)
+	default:
+		writeError(w, r, http.StatusMethodNotAllowed, "method_not_allowed")
+	}
+}
+
+// shelfHandler handles operations on a specific shelf and its books.
+func shelfHandler(w http.ResponseWriter, r *http.Request) {
+	segments := pathSegments(r.URL.Path)
+	if len(segments) < 2 || len(segments) > 4 {
+		writeError(w, r, http.StatusNotFound, "not_found")
+		return
+	}
+
+	id, err := parseResourceID(segments[1], "invalid_shelf_id")
+	if err != nil {
+		writeAPIError(w, r, err)
+		return
+	}
+
+	if len(segments) != 3 {
+		if _, ok := checkQuery(w, r); !ok {
+			return
+		}
+	}
+
+	if len(segments) == 2 {
+		switch r.Method {
+		case http.MethodGet:
+			getShelf(w, r, id)
+		case http.MethodPut:
+			updateShelf(w, r, id)
+		case http.MethodDelete:
+			deleteShelf(w, r, id)
+		default:
+			writeError(w, r, http.StatusMethodNotAllowed, "method_not_allowed")
+		}
+		return
+	}
+
+	if segments[2] != "books" {
+		writeError(w, r, http.StatusNotFound, "not_found")
+		return
+	}
+
+	if len(segments) == 3 {
+		if r.Method != http.MethodGet {
+			writeError(w, r, http.StatusMethodNotAllowed, "method_not_allowed")
+			return
+		}
+		getShelfBooks(w, r, id)
+		return
+	}
+
+	bookID, err := parseResourceID(segments[3], "invalid_book_id")
+	if err != nil {
+		writeAPIError(w, r, err)
+		return
+	}
+
+	switch r.Method {
+	case http.MethodPut:
+		addBookToShelf(w, r, id, bookID)
+	case http.MethodDelete:
+		removeBookFromShelf(w, r, id, bookID)
+	default:
+		writeError(w, r, http.StatusMethodNotAllowed, "method_not_allowed")
+	}
+}
+
+// getShelves retrieves the list of all shelves, ordered by ID and paginated.
+func getShelves(w http.ResponseWriter, r *http.Request) {
+	q, ok := checkQuery(w, r, paginationParams...)
+	if !ok {
+		return
+	}
+
+	shelvesMu.Lock()
+	defer shelvesMu.Unlock()
+
+	shelfList := make([]Shelf, 0, len(shelves))
+	for _, shelf := range shelves {
+		shelfList = append(shelfList, shelf)
+	}
+	sort.Slice(shelfList, func(i, j int) bool { return shelfList[i].ID < shelfList[j].ID })
+
+	writeJSON(w, http.StatusOK, paginateQuery(w, r, q, PagingPolicy{}, shelfList))
+}
+
+// createShelf creates a new shelf.
+func createShelf(w http.ResponseWriter, r *http.Request) {
+	if _, ok := checkQuery(w, r); !ok {
+		return
+	}
+
+	var shelf Shelf
+	if err := decodeJSON(w, r, &shelf); err != nil {
+		writeAPIError(w, r, err)
+		return
+	}
+	if strings.TrimSpace(shelf.Name) == "" {
+		writeError(w, r, http.StatusUnprocessableEntity, "field_required", "field", "name")
+		return
+	}
+
+	shelvesMu.Lock()
+	shelf.ID = nextShelfID
+	nextShelfID++
+	shelves[shelf.ID] = shelf
+	shelfBooks[shelf.ID] = make(map[int]struct{})
+	shelvesMu.Unlock()
+
+	setLocation(w, "/shelves/"+strconv.Itoa(shelf.ID))
+	writeJSON(w, http.StatusCreated, shelf)
+}
+
+// getShelf retrieves a specific shelf by its ID.
+func getShelf(w http.ResponseWriter, r *http.Request, id int) {
+	shelvesMu.Lock()
+	defer shelvesMu.Unlock()
+
+	shelf, found := shelves[id]
+	if !found {
+		writeError(w, r, http.StatusNotFound, "shelf_not_found")
+		return
+	}
+
+	writeJSON(w, http.StatusOK, shelf)
+}
+
+// updateShelf updates an existing shelf's name and description.
+func updateShelf(w http.ResponseWriter, r *http.Request, id int) {
+	shelvesMu.Lock()
+	defer shelvesMu.Unlock()
+
+	shelf, found := shelves[id]
+	if !found {
+		writeError(w, r, http.StatusNotFound, "shelf_not_found")
+		return
+	}
+
+	if err := decodeJSON(w, r, &shelf); err != nil {
+		writeAPIError(w, r, err)
+		return
+	}
+	if strings.TrimSpace(shelf.Name) == "" {
+		writeError(w, r, http.StatusUnprocessableEntity, "field_required", "field", "name")
+		return
+	}
+
+	shelf.ID = id
+	shelves[id] = shelf
+	writeJSON(w, http.StatusOK, shelf)
+}
+
+// deleteShelf removes a shelf. The books on it are left untouched.
+func deleteShelf(w http.ResponseWriter, r *http.Request, id int) {
+	shelvesMu.Lock()
+	defer shelvesMu.Unlock()
+
+	if _, found := shelves[id]; !found {
+		writeError(w, r, http.StatusNotFound, "shelf_not_found")
+		return
+	}
+
+	delete(shelves, id)
+	delete(shelfBooks, id)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// getShelfBooks lists the books on a shelf, ordered by ID and paginated.
+func getShelfBooks(w http.ResponseWriter, r *http.Request, id int) {
+	q, ok := checkQuery(w, r, bookListParams...)
+	if !ok {
+		return
+	}
+
+	shelvesMu.Lock()
+	members, found := shelfBooks[id]
+	if !found {
+		shelvesMu.Unlock()
+		writeError(w, r, http.StatusNotFound, "shelf_not_found")
+		return
+	}
+	bookList := make([]Book, 0, len(members))
+	for bookID := range members {
+		if book, ok := store.Get(bookID); ok {
+			bookList = append(bookList, book)
+		}
+	}
+	shelvesMu.Unlock()
+
+	sortBooksByID(bookList)
+	writeJSON(w, http.StatusOK, renderBooks(r, paginateQuery(w, r, q, PagingPolicy{}, bookList)))
+}
+
+// addBookToShelf puts a book on a shelf. Adding a book that is already on
+// the shelf succeeds without changing anything.
+func addBookToShelf(w http.ResponseWriter, r *http.Request, id, bookID int) {
+	mu.Lock()
+	defer mu.Unlock()
+	shelvesMu.Lock()
+	defer shelvesMu.Unlock()
+
+	members, found := shelfBooks[id]
+	if !found {
+		writeError(w, r, http.StatusNotFound, "shelf_not_found")
+		return
+	}
+	if _, err := findBook(bookID); err != nil {
+		writeAPIError(w, r, err)
+		return
+	}
+
+	members[bookID] = struct{}{}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// removeBookFromShelf takes a book off a shelf.
+func removeBookFromShelf(w http.ResponseWriter, r *http.Request, id, bookID int) {
+	shelvesMu.Lock()
+	defer shelvesMu.Unlock()
+
+	members, found := shelfBooks[id]
+	if !found {
+		writeError(w, r, http.StatusNotFound, "shelf_not_found")
+		return
+	}
+	if _, found := members[bookID]; !found {
+		writeError(w, r, http.StatusNotFound, "book_not_on_shelf")
+		return
+	}
+
+	delete(members, bookID)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// removeBookFromShelves takes a deleted book off every shelf.
+func removeBookFromShelves(bookID int) {
+	shelvesMu.Lock()
+	defer shelvesMu.Unlock()
+
+	for _, members := range shelfBooks {
+		delete(members, bookID)
+	}
+}
+
+// shelfNamesForBook returns the sorted names of the shelves holding a book.
+func shelfNamesForBook(bookID int) []string {
+	shelvesMu.Lock()
+	defer shelvesMu.Unlock()
+
+	var names []string
+	for shelfID, members := range shelfBooks {
+		if _, ok := members[bookID]; ok {
+			names = append(names, shelves[shelfID].Name)
+		}
+	}
+	sort.Strings(names)
+	return names
+}
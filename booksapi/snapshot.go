@@ -0,0 +1,281 @@
+package booksapi
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Snapshot settings, configured by flags in Main. An empty path disables
+// periodic snapshots.
+var (
+	snapshotPath     string
+	snapshotInterval = 10 * time.Second
+)
+
+// storeSnapshot is the content of a snapshot file.
+type storeSnapshot struct {
+	SchemaVersion int       `json:"schema_version"` // see schemaVersion
+	LastID        int       `json:"last_id"`
+	Books         []Book    `json:"books"`
+	TakenAt       time.Time `json:"taken_at"` // zero in snapshots written before it was recorded
+}
+
+// snapshotResult is the response body of POST /admin/snapshot.
+type snapshotResult struct {
+	Path  string `json:"path"`
+	Books int    `json:"books"`
+}
+
+// snapshotStore keeps books in an underlying store and periodically writes
+// them all to a snapshot file. Changes made since the last snapshot are lost
+// on a crash, in exchange for writes that never touch the disk.
+type snapshotStore struct {
+	BookStore
+
+	path       string
+	generation atomic.Uint64 // advanced by every change
+	lastID     atomic.Int64
+
+	mu    sync.Mutex // serializes snapshots
+	saved uint64     // generation of the last snapshot
+
+	stop chan struct{}
+	done chan struct{}
+}
+
+// openSnapshotStore restores inner from the snapshot at path, if one
+// exists, and returns a store that snapshots it every interval.
+func openSnapshotStore(inner BookStore, path string, interval time.Duration) (*snapshotStore, error) {
+	s := &snapshotStore{BookStore: inner, path: path, stop: make(chan struct{}), done: make(chan struct{})}
+	lastID, version, err := loadSnapshot(inner, path)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.reserve(lastID); err != nil {
+		return nil, err
+	}
+	if version < schemaVersion {
+		if err := backupOnce(path); err != nil {
+			return nil, err
+		}
+		if _, err := saveSnapshot(inner, lastID, path); err != nil {
+			return nil, err
+		}
+		logMigration(path, version)
+	}
+
+	go s.run(interval)
+	return s, nil
+}
+
+// run takes a snapshot every interval until the store is closed.
+func (s *snapshotStore) run(interval time.Duration) {
+	defer close(s.done)
+	if interval <= 0 {
+		<-s.stop
+		return
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			if _, _, err := s.Snapshot(false); err != nil {
+				logger.Printf("snapshot: %v", err)
+			}
+		case <-s.stop:
+			return
+		}
+	}
+}
+
+// Snapshot writes the store to its snapshot file and returns the number of
+// books written. Unless force is set, nothing is written when there has
+// been no change since the last snapshot; written reports which happened.
+func (s *snapshotStore) Snapshot(force bool) (count int, written bool, err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	generation := s.generation.Load()
+	if !force && generation == s.saved {
+		return 0, false, nil
+	}
+	count, err = saveSnapshot(s.BookStore, int(s.lastID.Load()), s.path)
+	if err != nil {
+		return 0, false, err
+	}
+	s.saved = generation
+	return count, true, nil
+}
+
+// Close stops the periodic snapshots and takes a final one.
+func (s *snapshotStore) Close() error {
+	close(s.stop)
+	<-s.done
+	_, _, err := s.Snapshot(false)
+	return err
+}
+
+// reserve records that id has been handed out.
+func (s *snapshotStore) reserve(id int) error {
+	for {
+		last := s.lastID.Load()
+		if int64(id) <= last || s.lastID.CompareAndSwap(last, int64(id)) {
+			break
+		}
+	}
+	return s.BookStore.Reserve(id)
+}
+
+// Unwrap returns the wrapped store.
+func (s *snapshotStore) Unwrap() BookStore { return s.BookStore }
+
+func (s *snapshotStore) Create(book Book) (Book, error) {
+	book, err := s.BookStore.Create(book)
+	if err != nil {
+		return book, err
+	}
+	if err := s.reserve(book.ID); err != nil {
+		return book, err
+	}
+	s.generation.Add(1)
+	return book, nil
+}
+
+func (s *snapshotStore) Put(book Book) error {
+	if err := s.BookStore.Put(book); err != nil {
+		return err
+	}
+	s.generation.Add(1)
+	return nil
+}
+
+func (s *snapshotStore) Delete(id int) error {
+	if err := s.BookStore.Delete(id); err != nil {
+		return err
+	}
+	s.generation.Add(1)
+	return nil
+}
+
+// Transact runs fn as a transaction of the wrapped store, counting it as a
+// change for the next snapshot, and the IDs it handed out as used whether
+// it succeeded or not.
+func (s *snapshotStore) Transact(ctx context.Context, fn func(tx BookStore) error) error {
+	err := s.BookStore.Transact(ctx, fn)
+	if reserveErr := s.reserve(s.BookStore.NextID() - 1); reserveErr != nil && err == nil {
+		err = reserveErr
+	}
+	s.generation.Add(1)
+	return err
+}
+
+func (s *snapshotStore) Reserve(id int) error {
+	if err := s.reserve(id); err != nil {
+		return err
+	}
+	s.generation.Add(1)
+	return nil
+}
+
+// adminSnapshotHandler forces a snapshot (POST /admin/snapshot).
+func adminSnapshotHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeError(w, r, http.StatusMethodNotAllowed, "method_not_allowed")
+		return
+	}
+	if _, ok := checkQuery(w, r); !ok {
+		return
+	}
+
+	snapshotter, ok := findStore[*snapshotStore](store)
+	if !ok {
+		writeError(w, r, http.StatusConflict, "snapshots_disabled")
+		return
+	}
+
+	count, _, err := snapshotter.Snapshot(true)
+	if err != nil {
+		writeAPIError(w, r, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, snapshotResult{Path: snapshotter.path, Books: count})
+}
+
+// loadSnapshot puts the books of the snapshot file at path into store, in a
+// single transaction so a failure leaves it empty, and returns the last ID
+// handed out when it was taken and the schema version it was written at,
+// having migrated it if older. A missing file is an empty snapshot, of the
+// current version.
+func loadSnapshot(store BookStore, path string) (lastID, version int, err error) {
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return 0, schemaVersion, nil
+	}
+	if err != nil {
+		return 0, 0, err
+	}
+	var snap storeSnapshot
+	version, err = decodeVersioned(data, &snap)
+	if err != nil {
+		return 0, 0, fmt.Errorf("snapshot %s: %w", path, err)
+	}
+	err = store.Transact(context.Background(), func(tx BookStore) error {
+		for _, book := range snap.Books {
+			if err := tx.Put(book); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, 0, err
+	}
+	return snap.LastID, version, nil
+}
+
+// saveSnapshot atomically writes every book of store to the snapshot file at
+// path and returns the number of books written.
+func saveSnapshot(store BookStore, lastID int, path string) (int, error) {
+	list := store.List(ListOptions{})
+	data, err := json.Marshal(storeSnapshot{SchemaVersion: schemaVersion, LastID: lastID, Books: list, TakenAt: time.Now().UTC()})
+	if err != nil {
+		return 0, err
+	}
+	if err := writeFileAtomic(path, data); err != nil {
+		return 0, fmt.Errorf("snapshot %s: %w", path, err)
+	}
+	return len(list), nil
+}
+
+// writeFileAtomic replaces the file at path with data, going through a
+// synced temporary file and a rename so readers never see a partial file.
+func writeFileAtomic(path string, data []byte) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".tmp-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
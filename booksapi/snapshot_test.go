@@ -0,0 +1,151 @@
+package booksapi
+
+import (
+	"encoding/json"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// openTestSnapshotStore opens the snapshot store at path over a new memory
+// store, closing it at the end of the test. Stores opened again on the same
+// path without closing the last one stand for a process that crashed.
+func openTestSnapshotStore(t *testing.T, path string, interval time.Duration) *snapshotStore {
+	t.Helper()
+	s, err := openSnapshotStore(newMemoryStore(newSequentialIDs()), path, interval)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { s.Close() })
+	return s
+}
+
+// readSnapshot returns the snapshot file at path, or a zero snapshot if
+// there is none yet.
+func readSnapshot(t *testing.T, path string) storeSnapshot {
+	t.Helper()
+	var snap storeSnapshot
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return snap
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := json.Unmarshal(data, &snap); err != nil {
+		t.Fatal(err)
+	}
+	return snap
+}
+
+// TestSnapshotInterval checks that changes are written at the next
+// interval, and that an interval without changes writes nothing.
+func TestSnapshotInterval(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "snapshot.json")
+	s := openTestSnapshotStore(t, path, 10*time.Millisecond)
+	waitForBooks := func(n int) storeSnapshot {
+		t.Helper()
+		deadline := time.Now().Add(5 * time.Second)
+		for time.Now().Before(deadline) {
+			if snap := readSnapshot(t, path); len(snap.Books) == n && !snap.TakenAt.IsZero() {
+				return snap
+			}
+			time.Sleep(time.Millisecond)
+		}
+		t.Fatalf("no snapshot of %d books written", n)
+		return storeSnapshot{}
+	}
+
+	if _, err := s.Create(Book{Title: "Dune"}); err != nil {
+		t.Fatal(err)
+	}
+	taken := waitForBooks(1).TakenAt
+	time.Sleep(50 * time.Millisecond)
+	if got := readSnapshot(t, path).TakenAt; !got.Equal(taken) {
+		t.Errorf("snapshot rewritten at %v without a change since %v", got, taken)
+	}
+
+	if _, err := s.Create(Book{Title: "Emma"}); err != nil {
+		t.Fatal(err)
+	}
+	if snap := waitForBooks(2); snap.LastID != 2 {
+		t.Errorf("snapshot records last ID %d, want 2", snap.LastID)
+	}
+	entries, err := os.ReadDir(filepath.Dir(path))
+	if err != nil || len(entries) != 1 {
+		t.Errorf("snapshot directory holds %v (%v), want the snapshot alone", entries, err)
+	}
+}
+
+// TestSnapshotRecovery checks that a store closed gracefully comes back
+// whole, and one that crashed comes back as its last snapshot, with the
+// IDs it handed out by then reserved.
+func TestSnapshotRecovery(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "snapshot.json")
+	s, err := openSnapshotStore(newMemoryStore(newSequentialIDs()), path, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, title := range []string{"Dune", "Emma", "Jazz"} {
+		if _, err := s.Create(Book{Title: title}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := s.Delete(3); err != nil {
+		t.Fatal(err)
+	}
+	want := catalogJSON(t, s)
+	if err := s.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	restarted := openTestSnapshotStore(t, path, 0)
+	if got := catalogJSON(t, restarted); got != want {
+		t.Errorf("catalog %s after a graceful restart, want %s", got, want)
+	}
+	if got := restarted.NextID(); got != 4 {
+		t.Errorf("next ID %d after a restart, want 4", got)
+	}
+
+	// Changes after the last snapshot are lost in a crash.
+	if _, _, err := restarted.Snapshot(true); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := restarted.Create(Book{Title: "Kokoro"}); err != nil {
+		t.Fatal(err)
+	}
+	crashed := openTestSnapshotStore(t, path, 0)
+	if got := catalogJSON(t, crashed); got != want {
+		t.Errorf("catalog %s after a crash, want the snapshot's %s", got, want)
+	}
+}
+
+// TestAdminSnapshot checks that POST /admin/snapshot writes a snapshot on
+// demand, even without changes, and is refused without a snapshot store.
+func TestAdminSnapshot(t *testing.T) {
+	h := newTestServer(t)
+	mustCreateBook(t, h, map[string]any{"title": "Dune"})
+	rec := serve(t, h, http.MethodPost, "/admin/snapshot", nil)
+	wantCode(t, rec, http.StatusConflict)
+	if got := errorCode(t, rec); got != "snapshots_disabled" {
+		t.Errorf("error code %q, want snapshots_disabled", got)
+	}
+
+	path := filepath.Join(t.TempDir(), "snapshot.json")
+	h = newTestServer(t, WithStore(openTestSnapshotStore(t, path, 0)))
+	mustCreateBook(t, h, map[string]any{"title": "Dune"})
+	mustCreateBook(t, h, map[string]any{"title": "Emma"})
+	for range 2 {
+		rec := serve(t, h, http.MethodPost, "/admin/snapshot", nil)
+		wantCode(t, rec, http.StatusOK)
+		if got := decode[snapshotResult](t, rec); got.Path != path || got.Books != 2 {
+			t.Errorf("snapshot result %+v", got)
+		}
+		if got := len(readSnapshot(t, path).Books); got != 2 {
+			t.Errorf("snapshot holds %d books, want 2", got)
+		}
+	}
+	wantCode(t, serve(t, h, http.MethodGet, "/admin/snapshot", nil), http.StatusMethodNotAllowed)
+}
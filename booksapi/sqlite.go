@@ -0,0 +1,308 @@
+package booksapi
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sync"
+
+	_ "modernc.org/sqlite" // registers the "sqlite" driver
+)
+
+// sqlitePath is the SQLite database file books are stored in, configured
+// by a flag in Main. Empty disables it.
+var sqlitePath string
+
+// sqliteSchema creates the tables of a new database. A book's row holds it
+// as JSON, at the schema version of its schema_version column; meta holds
+// the highest ID handed out, under "last_id".
+const sqliteSchema = `
+CREATE TABLE IF NOT EXISTS books (
+	id             INTEGER PRIMARY KEY,
+	schema_version INTEGER NOT NULL,
+	book           TEXT NOT NULL
+);
+CREATE TABLE IF NOT EXISTS meta (
+	key   TEXT PRIMARY KEY,
+	value INTEGER NOT NULL
+);`
+
+// sqliteStore writes every change to an underlying store through to a
+// SQLite database, which it loads the underlying store from when opened, so
+// the catalog survives a restart. Reads are answered by the underlying
+// store.
+type sqliteStore struct {
+	BookStore
+
+	mu     sync.Mutex
+	path   string
+	db     *sql.DB
+	lastID int
+}
+
+// sqliteWrite is a change to the database: a book to store, or the ID of
+// one to delete.
+type sqliteWrite struct {
+	book *Book
+	id   int
+}
+
+// openSQLiteStore creates the tables of the database at path, if they are
+// not there yet, loads its books into inner, and returns a store that
+// writes further changes to it. Books of an older schema version are
+// migrated and rewritten, keeping the original database as a .bak file;
+// those of a newer one are refused.
+func openSQLiteStore(inner BookStore, path string) (*sqliteStore, error) {
+	db, err := sql.Open("sqlite", path)
+	if err != nil {
+		return nil, fmt.Errorf("sqlite %s: %w", path, err)
+	}
+	// A single connection serializes the writes, which SQLite does anyway.
+	db.SetMaxOpenConns(1)
+	s := &sqliteStore{BookStore: inner, path: path, db: db}
+	if err := s.load(); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("sqlite %s: %w", path, err)
+	}
+	return s, nil
+}
+
+// load creates the tables if needed and restores the books and the last
+// ID, migrating the books of an older schema version.
+func (s *sqliteStore) load() error {
+	if _, err := s.db.Exec(sqliteSchema); err != nil {
+		return err
+	}
+	if err := s.db.QueryRow(`SELECT value FROM meta WHERE key = 'last_id'`).Scan(&s.lastID); err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return err
+	}
+	if err := s.BookStore.Reserve(s.lastID); err != nil {
+		return err
+	}
+
+	rows, err := s.db.Query(`SELECT id, schema_version, book FROM books ORDER BY id`)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	var migrated []sqliteWrite
+	oldestVersion := schemaVersion
+	for rows.Next() {
+		var id, version int
+		var data []byte
+		if err := rows.Scan(&id, &version, &data); err != nil {
+			return err
+		}
+		// The row is decoded as a journal record would be, so it goes
+		// through the same migrations.
+		var rec journalRecord
+		doc, err := json.Marshal(map[string]any{"schema_version": version, "book": json.RawMessage(data)})
+		if err != nil {
+			return err
+		}
+		if _, err := decodeVersioned(doc, &rec); err != nil {
+			return fmt.Errorf("book %d: %w", id, err)
+		}
+		if rec.Book == nil || rec.Book.ID != id {
+			return fmt.Errorf("book %d: row holds no book of that ID", id)
+		}
+		if err := s.BookStore.Put(*rec.Book); err != nil {
+			return err
+		}
+		s.lastID = max(s.lastID, id)
+		if version < schemaVersion {
+			oldestVersion = min(oldestVersion, version)
+			migrated = append(migrated, sqliteWrite{book: rec.Book})
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	rows.Close()
+
+	if len(migrated) > 0 {
+		if err := backupOnce(s.path); err != nil {
+			return err
+		}
+		if err := s.write(migrated); err != nil {
+			return err
+		}
+		logMigration(s.path, oldestVersion)
+	}
+	return nil
+}
+
+// write makes writes in a single database transaction, and records
+// s.lastID with them. Callers must hold s.mu, but for load.
+func (s *sqliteStore) write(writes []sqliteWrite) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return fmt.Errorf("%w: sqlite %s: %w", ErrUnavailable, s.path, err)
+	}
+	defer tx.Rollback()
+	for _, w := range writes {
+		if w.book == nil {
+			_, err = tx.Exec(`DELETE FROM books WHERE id = ?`, w.id)
+		} else {
+			var data []byte
+			if data, err = json.Marshal(w.book); err != nil {
+				return err
+			}
+			_, err = tx.Exec(`INSERT OR REPLACE INTO books (id, schema_version, book) VALUES (?, ?, ?)`, w.book.ID, schemaVersion, data)
+		}
+		if err != nil {
+			return fmt.Errorf("%w: sqlite %s: %w", ErrUnavailable, s.path, err)
+		}
+	}
+	if _, err := tx.Exec(`INSERT OR REPLACE INTO meta (key, value) VALUES ('last_id', ?)`, s.lastID); err != nil {
+		return fmt.Errorf("%w: sqlite %s: %w", ErrUnavailable, s.path, err)
+	}
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("%w: sqlite %s: %w", ErrUnavailable, s.path, err)
+	}
+	return nil
+}
+
+// reserve records that id has been handed out.
+func (s *sqliteStore) reserve(id int) error {
+	if id > s.lastID {
+		s.lastID = id
+	}
+	return s.BookStore.Reserve(id)
+}
+
+// Unwrap returns the wrapped store.
+func (s *sqliteStore) Unwrap() BookStore { return s.BookStore }
+
+func (s *sqliteStore) Create(book Book) (Book, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	tx := &undoStore{BookStore: s.BookStore}
+	book, err := tx.Create(book)
+	if err != nil {
+		return book, err
+	}
+	if err := s.reserve(book.ID); err != nil {
+		return book, err
+	}
+	return book, s.commit(tx, sqliteWrite{book: &book})
+}
+
+func (s *sqliteStore) Put(book Book) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	tx := &undoStore{BookStore: s.BookStore}
+	if err := tx.Put(book); err != nil {
+		return err
+	}
+	if err := s.reserve(book.ID); err != nil {
+		return err
+	}
+	return s.commit(tx, sqliteWrite{book: &book})
+}
+
+func (s *sqliteStore) Delete(id int) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	tx := &undoStore{BookStore: s.BookStore}
+	if err := tx.Delete(id); err != nil {
+		return err
+	}
+	return s.commit(tx, sqliteWrite{id: id})
+}
+
+// commit writes w, the change made through tx, to the database, undoing
+// the change if the database refuses it: the store never holds a change a
+// restart would lose. Callers must hold s.mu.
+func (s *sqliteStore) commit(tx *undoStore, w sqliteWrite) error {
+	if err := s.write([]sqliteWrite{w}); err != nil {
+		if undoErr := tx.rollback(); undoErr != nil {
+			return fmt.Errorf("undoing a write the database lost (%v): %w", err, undoErr)
+		}
+		return err
+	}
+	return nil
+}
+
+func (s *sqliteStore) Reserve(id int) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.reserve(id); err != nil {
+		return err
+	}
+	return s.write(nil)
+}
+
+// Transact runs fn as a transaction of the wrapped store and writes its
+// changes in one database transaction once it has succeeded, so the
+// database never holds part of a transaction. The IDs it handed out are
+// recorded either way, so they are not reused after a restart.
+func (s *sqliteStore) Transact(ctx context.Context, fn func(tx BookStore) error) error {
+	var writes []sqliteWrite
+	err := s.BookStore.Transact(ctx, func(tx BookStore) error {
+		return fn(sqliteTx{tx, &writes})
+	})
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err != nil {
+		writes = nil
+	}
+	s.lastID = max(s.lastID, s.BookStore.NextID()-1)
+	if writeErr := s.write(writes); writeErr != nil {
+		return writeErr
+	}
+	return err
+}
+
+// sqliteTx is the view of a transaction that sqliteStore hands to fn,
+// collecting its writes.
+type sqliteTx struct {
+	BookStore
+	writes *[]sqliteWrite
+}
+
+// Unwrap returns the wrapped transaction.
+func (tx sqliteTx) Unwrap() BookStore { return tx.BookStore }
+
+func (tx sqliteTx) Create(book Book) (Book, error) {
+	book, err := tx.BookStore.Create(book)
+	if err == nil {
+		*tx.writes = append(*tx.writes, sqliteWrite{book: &book})
+	}
+	return book, err
+}
+
+func (tx sqliteTx) Put(book Book) error {
+	err := tx.BookStore.Put(book)
+	if err == nil {
+		*tx.writes = append(*tx.writes, sqliteWrite{book: &book})
+	}
+	return err
+}
+
+func (tx sqliteTx) Delete(id int) error {
+	err := tx.BookStore.Delete(id)
+	if err == nil {
+		*tx.writes = append(*tx.writes, sqliteWrite{id: id})
+	}
+	return err
+}
+
+// Transact runs a nested transaction, whose writes and their undoing are
+// both collected.
+func (tx sqliteTx) Transact(ctx context.Context, fn func(tx BookStore) error) error {
+	return transactWithUndo(ctx, tx, fn)
+}
+
+// Close closes the database.
+func (s *sqliteStore) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.db.Close()
+}
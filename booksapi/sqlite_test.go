@@ -0,0 +1,95 @@
+package booksapi
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"slices"
+	"testing"
+)
+
+// openTestSQLite opens the SQLite store at path over an empty memory store.
+func openTestSQLite(t *testing.T, path string) *sqliteStore {
+	t.Helper()
+	s, err := openSQLiteStore(newMemoryStore(newSequentialIDs()), path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
+
+// TestSQLiteRestart checks that the books, and the IDs handed out, survive
+// closing the database and opening it again.
+func TestSQLiteRestart(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "books.db")
+	s := openTestSQLite(t, path)
+	for _, title := range []string{"Dune", "Emma", "Beloved"} {
+		if _, err := s.Create(Book{Title: title, Price: 10}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := s.Put(Book{ID: 1, Title: "Dune Messiah", Price: 12}); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Delete(3); err != nil {
+		t.Fatal(err)
+	}
+	// A failed transaction writes none of its books but hands out their IDs.
+	errAbort := errors.New("abort")
+	err := s.Transact(context.Background(), func(tx BookStore) error {
+		if _, err := tx.Create(Book{Title: "Rolled back"}); err != nil {
+			return err
+		}
+		return errAbort
+	})
+	if !errors.Is(err, errAbort) {
+		t.Fatalf("Transact: %v, want %v", err, errAbort)
+	}
+	want := s.List(ListOptions{})
+	nextID := s.NextID()
+	if err := s.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	s = openTestSQLite(t, path)
+	defer s.Close()
+	if got := s.List(ListOptions{}); !slices.EqualFunc(got, want, func(a, b Book) bool {
+		return a.ID == b.ID && a.Title == b.Title && a.Price == b.Price
+	}) {
+		t.Errorf("reopened with %+v, want %+v", got, want)
+	}
+	if got := s.NextID(); got != nextID {
+		t.Errorf("reopened with next ID %d, want %d", got, nextID)
+	}
+	book, err := s.Create(Book{Title: "Emma"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if book.ID != nextID {
+		t.Errorf("created book %d after reopening, want %d", book.ID, nextID)
+	}
+}
+
+// TestSQLiteWriteFails checks that a write the database refuses fails as
+// unavailable and leaves the store as it was.
+func TestSQLiteWriteFails(t *testing.T) {
+	s := openTestSQLite(t, filepath.Join(t.TempDir(), "books.db"))
+	if _, err := s.Create(Book{Title: "Dune"}); err != nil {
+		t.Fatal(err)
+	}
+	want := catalogJSON(t, s)
+	s.db.Close()
+
+	if _, err := s.Create(Book{Title: "Emma"}); !errors.Is(err, ErrUnavailable) {
+		t.Errorf("Create: %v, want ErrUnavailable", err)
+	}
+	if err := s.Put(Book{ID: 1, Title: "Dune Messiah"}); !errors.Is(err, ErrUnavailable) {
+		t.Errorf("Put: %v, want ErrUnavailable", err)
+	}
+	if err := s.Delete(1); !errors.Is(err, ErrUnavailable) {
+		t.Errorf("Delete: %v, want ErrUnavailable", err)
+	}
+	if got := catalogJSON(t, s); got != want {
+		t.Errorf("catalog after failed writes:\n%s\nwant:\n%s", got, want)
+	}
+}
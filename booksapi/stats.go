@@ -0,0 +1,59 @@
+package booksapi
+
+import (
+	"expvar"
+	"net/http"
+)
+
+// maxBooks caps the number of books in the catalog. Zero means unlimited.
+var maxBooks int
+
+// bookStats is the response body of GET /books/stats.
+type bookStats struct {
+	Count       int         `json:"count"`    // books in the store
+	Archived    int         `json:"archived"` // books in cold storage
+	MaxBooks    int         `json:"max_books,omitempty"`
+	Bytes       int64       `json:"approx_bytes"` // estimated memory held by the books, as bookSize counts it
+	MaxBytes    int64       `json:"max_bytes,omitempty"`
+	SearchIndex *IndexStats `json:"search_index,omitempty"` // set when the store has a full-text index
+}
+
+func init() {
+	expvar.Publish("books_count", expvar.Func(func() any {
+		return store.Count()
+	}))
+	expvar.Publish("books_max", expvar.Func(func() any { return maxBooks }))
+	expvar.Publish("store_bytes", expvar.Func(func() any {
+		used, _ := storeBytes()
+		return used
+	}))
+	expvar.Publish("store_max_bytes", expvar.Func(func() any {
+		_, limit := storeBytes()
+		return limit
+	}))
+}
+
+// getStats reports catalog usage.
+func getStats(w http.ResponseWriter, r *http.Request) {
+	if _, ok := checkQuery(w, r); !ok {
+		return
+	}
+
+	stats := bookStats{Count: store.Count(), Archived: archivedCount(), MaxBooks: maxBooks}
+	stats.Bytes, stats.MaxBytes = storeBytes()
+	if searcher, ok := findStore[Searcher](store); ok {
+		index := searcher.IndexStats()
+		stats.SearchIndex = &index
+	}
+
+	writeJSON(w, http.StatusOK, stats)
+}
+
+// checkQuota verifies that n more books fit within the catalog limit.
+// Callers must hold mu.
+func checkQuota(n int) error {
+	if count := store.Count(); maxBooks > 0 && count+n > maxBooks {
+		return newAPIError(http.StatusInsufficientStorage, "quota_exceeded", "count", count, "limit", maxBooks)
+	}
+	return nil
+}
@@ -0,0 +1,506 @@
+package booksapi
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"sync/atomic"
+)
+
+// BookStore holds the books of the catalog. Implementations are safe for
+// concurrent use and every method is atomic on its own; handlers that run a
+// sequence of calls which must see a consistent catalog hold mu, and run
+// sequences that must be made in full or not at all through Transact.
+// Errors from the write methods mean the change could not be made durable.
+type BookStore interface {
+	// Get returns the book with the given ID.
+	Get(id int) (Book, bool)
+	// List returns the books selected by opts, in the order and page it
+	// asks for. The zero ListOptions selects every book, ordered by ID.
+	List(opts ListOptions) []Book
+	// Count returns the number of books.
+	Count() int
+	// Create stores a new book under the next free ID and returns it.
+	Create(book Book) (Book, error)
+	// Put stores book under book.ID, replacing any book already there. It
+	// fails with a ValidationError if book.ID is not positive.
+	Put(book Book) error
+	// Delete removes the book with the given ID, if any.
+	Delete(id int) error
+	// NextID returns the ID the next Create will assign.
+	NextID() int
+	// Reserve makes sure Create never hands out id or a lower ID, so that
+	// restoring saved books does not lead to reused IDs.
+	Reserve(id int) error
+	// Transact runs fn as a unit, leaving the store as it was if fn fails
+	// or ctx is done before it returns: every write fn made through tx is
+	// undone, and the error returned. Reads through tx see the writes made
+	// through it; fn must not write through the store itself. IDs handed
+	// out by undone creates are not reused. Callers hold mu, as for any
+	// sequence of calls.
+	Transact(ctx context.Context, fn func(tx BookStore) error) error
+}
+
+// Storage settings, configured by flags in Main.
+var (
+	storageKind = "memory"
+	storeShards = 16
+)
+
+// store is the book store used by the handlers. Changes are tracked below
+// any journal or snapshot wrapper, so books restored at startup are part of
+// the change sequence.
+var store BookStore = trackChanges(newMemoryStore(newSequentialIDs()))
+
+// newBookStore returns the store selected by kind.
+func newBookStore(kind string) (BookStore, error) {
+	switch kind {
+	case "memory":
+		return newMemoryStore(newSequentialIDs()), nil
+	case "memory-sharded":
+		if storeShards < 1 {
+			return nil, fmt.Errorf("shard count must be positive, got %d", storeShards)
+		}
+		return newShardedStore(storeShards, newSequentialIDs()), nil
+	default:
+		return nil, fmt.Errorf("unknown storage %q", kind)
+	}
+}
+
+// memoryStore keeps all books in a single map behind one lock, with a
+// full-text index of them. It accounts for the bytes its books hold.
+type memoryStore struct {
+	mu       sync.RWMutex
+	books    map[int]Book
+	ids      IDGenerator
+	index    *textIndex
+	bytes    int64 // sum of bookSize over books
+	maxBytes int64 // zero when unlimited
+}
+
+func newMemoryStore(ids IDGenerator) *memoryStore {
+	return &memoryStore{books: make(map[int]Book), ids: ids, index: newTextIndex()}
+}
+
+func (s *memoryStore) Get(id int) (Book, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	book, ok := s.books[id]
+	return book, ok
+}
+
+func (s *memoryStore) List(opts ListOptions) []Book {
+	s.mu.RLock()
+	list := make([]Book, 0, len(s.books))
+	for _, book := range s.books {
+		list = append(list, book)
+	}
+	s.mu.RUnlock()
+
+	sortBooksByID(list)
+	return opts.apply(list)
+}
+
+func (s *memoryStore) Count() int {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return len(s.books)
+}
+
+func (s *memoryStore) Create(book Book) (Book, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	id, err := s.ids.Next(context.Background())
+	if err != nil {
+		return Book{}, err
+	}
+	book.ID = id
+	if err := s.checkPutLocked(book); err != nil {
+		return Book{}, err
+	}
+	s.putLocked(book)
+	return book, nil
+}
+
+func (s *memoryStore) Put(book Book) error {
+	if err := checkStoredID(book.ID); err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.checkPutLocked(book); err != nil {
+		return err
+	}
+	s.putLocked(book)
+	return nil
+}
+
+func (s *memoryStore) Delete(id int) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.deleteLocked(id)
+	return nil
+}
+
+// checkPutLocked fails with a StoreFullError if storing book would grow
+// the store past its byte limit. Callers must hold s.mu.
+func (s *memoryStore) checkPutLocked(book Book) error {
+	delta := bookSize(book)
+	if old, found := s.books[book.ID]; found {
+		delta -= bookSize(old)
+	}
+	return checkGrowth(s.bytes, delta, s.maxBytes)
+}
+
+// putLocked stores book, whose ID the ID generator then knows is in use.
+// Callers must hold s.mu.
+func (s *memoryStore) putLocked(book Book) {
+	if old, found := s.books[book.ID]; found {
+		s.bytes -= bookSize(old)
+	}
+	s.books[book.ID] = book
+	s.bytes += bookSize(book)
+	s.index.add(book)
+	s.ids.Observe(book.ID)
+}
+
+// deleteLocked removes the book with the given ID. Callers must hold s.mu.
+func (s *memoryStore) deleteLocked(id int) {
+	if old, found := s.books[id]; found {
+		s.bytes -= bookSize(old)
+	}
+	delete(s.books, id)
+	s.index.remove(id)
+}
+
+// Verify checks that every book is kept under its own ID, and that the
+// byte count and the full-text index match the books.
+func (s *memoryStore) Verify() []string {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	violations, bytes := verifyBookMap(s.books)
+	if bytes != s.bytes {
+		violations = append(violations, fmt.Sprintf("store counts %d bytes, its books hold %d", s.bytes, bytes))
+	}
+	return append(violations, s.index.verify(s.books)...)
+}
+
+// verifyBookMap checks that every book of books is kept under its own ID,
+// returning the violations and the bytes the books hold.
+func verifyBookMap(books map[int]Book) ([]string, int64) {
+	var violations []string
+	var bytes int64
+	for id, book := range books {
+		if book.ID != id {
+			violations = append(violations, fmt.Sprintf("book %d is stored under ID %d", book.ID, id))
+		}
+		bytes += bookSize(book)
+	}
+	return violations, bytes
+}
+
+func (s *memoryStore) Bytes() int64 {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.bytes
+}
+
+func (s *memoryStore) MaxBytes() int64 {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.maxBytes
+}
+
+func (s *memoryStore) SetMaxBytes(n int64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.maxBytes = n
+}
+
+// Transact runs fn as a transaction. The writes made through tx save the
+// version of each book from before its first write, and if fn fails the
+// saved versions are put back at once under the store's lock, so readers
+// never see a transaction half undone.
+func (s *memoryStore) Transact(ctx context.Context, fn func(tx BookStore) error) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	tx := &memoryTx{memoryStore: s, saved: make(map[int]savedBook)}
+	err := fn(tx)
+	if err == nil {
+		err = ctx.Err()
+	}
+	if err != nil {
+		tx.rollback()
+	}
+	return err
+}
+
+// memoryTx is the view of a memoryStore that Transact hands to a
+// transaction.
+type memoryTx struct {
+	*memoryStore
+	saved map[int]savedBook // book ID -> its version before the transaction
+}
+
+// savedBook is a book as it was before a transaction, or its absence.
+type savedBook struct {
+	book  Book
+	found bool
+}
+
+// saveLocked saves the book with the given ID unless it was saved already.
+// Callers must hold tx.mu.
+func (tx *memoryTx) saveLocked(id int) {
+	if _, saved := tx.saved[id]; !saved {
+		book, found := tx.books[id]
+		tx.saved[id] = savedBook{book, found}
+	}
+}
+
+func (tx *memoryTx) Create(book Book) (Book, error) {
+	tx.mu.Lock()
+	defer tx.mu.Unlock()
+	id, err := tx.ids.Next(context.Background())
+	if err != nil {
+		return Book{}, err
+	}
+	book.ID = id
+	if err := tx.checkPutLocked(book); err != nil {
+		return Book{}, err
+	}
+	tx.saveLocked(book.ID)
+	tx.putLocked(book)
+	return book, nil
+}
+
+func (tx *memoryTx) Put(book Book) error {
+	if err := checkStoredID(book.ID); err != nil {
+		return err
+	}
+	tx.mu.Lock()
+	defer tx.mu.Unlock()
+	if err := tx.checkPutLocked(book); err != nil {
+		return err
+	}
+	tx.saveLocked(book.ID)
+	tx.putLocked(book)
+	return nil
+}
+
+func (tx *memoryTx) Delete(id int) error {
+	tx.mu.Lock()
+	defer tx.mu.Unlock()
+	tx.saveLocked(id)
+	tx.deleteLocked(id)
+	return nil
+}
+
+// Transact runs a nested transaction, whose writes are undone on its own
+// failure as well as on the failure of the enclosing one.
+func (tx *memoryTx) Transact(ctx context.Context, fn func(tx BookStore) error) error {
+	return transactWithUndo(ctx, tx, fn)
+}
+
+// rollback puts back the saved books. The ID generator is left as it is,
+// so IDs handed out by the transaction are not reused.
+func (tx *memoryTx) rollback() {
+	tx.mu.Lock()
+	defer tx.mu.Unlock()
+	for id, saved := range tx.saved {
+		if saved.found {
+			tx.putLocked(saved.book)
+		} else {
+			tx.deleteLocked(id)
+		}
+	}
+	tx.saved = make(map[int]savedBook)
+}
+
+func (s *memoryStore) Search(query string, inDescription bool) []SearchHit {
+	return s.index.Search(query, inDescription)
+}
+
+func (s *memoryStore) IndexStats() IndexStats { return s.index.IndexStats() }
+
+func (s *memoryStore) NextID() int { return s.ids.Peek() }
+
+func (s *memoryStore) Reserve(id int) error {
+	s.ids.Observe(id)
+	return nil
+}
+
+// shardedStore spreads books over several maps, each with its own lock, so
+// operations on different books rarely contend. IDs come from the ID
+// generator, which needs no lock of the store's. The full-text index is
+// shared by the shards and updated under the lock of the book's shard. The
+// bytes the books hold are counted across the shards, and a write claims
+// the bytes it adds in the same atomic step as it checks them against the
+// limit, so writes to different shards cannot overshoot it together.
+type shardedStore struct {
+	shards   []bookShard
+	ids      IDGenerator
+	index    *textIndex
+	bytes    atomic.Int64 // sum of bookSize over the books of every shard
+	maxBytes atomic.Int64 // zero when unlimited
+}
+
+// bookShard is one bucket of a shardedStore.
+type bookShard struct {
+	mu    sync.RWMutex
+	books map[int]Book
+}
+
+func newShardedStore(n int, ids IDGenerator) *shardedStore {
+	s := &shardedStore{shards: make([]bookShard, n), ids: ids, index: newTextIndex()}
+	for i := range s.shards {
+		s.shards[i].books = make(map[int]Book)
+	}
+	return s
+}
+
+// shard returns the bucket holding the book with the given ID. Sequential
+// IDs taken modulo the shard count spread books evenly.
+func (s *shardedStore) shard(id int) *bookShard {
+	return &s.shards[uint(id)%uint(len(s.shards))]
+}
+
+func (s *shardedStore) Get(id int) (Book, bool) {
+	sh := s.shard(id)
+	sh.mu.RLock()
+	defer sh.mu.RUnlock()
+	book, ok := sh.books[id]
+	return book, ok
+}
+
+func (s *shardedStore) List(opts ListOptions) []Book {
+	list := make([]Book, 0, s.Count())
+	for i := range s.shards {
+		sh := &s.shards[i]
+		sh.mu.RLock()
+		for _, book := range sh.books {
+			list = append(list, book)
+		}
+		sh.mu.RUnlock()
+	}
+
+	sortBooksByID(list)
+	return opts.apply(list)
+}
+
+func (s *shardedStore) Count() int {
+	n := 0
+	for i := range s.shards {
+		sh := &s.shards[i]
+		sh.mu.RLock()
+		n += len(sh.books)
+		sh.mu.RUnlock()
+	}
+	return n
+}
+
+func (s *shardedStore) Create(book Book) (Book, error) {
+	id, err := s.ids.Next(context.Background())
+	if err != nil {
+		return Book{}, err
+	}
+	book.ID = id
+	return book, s.Put(book)
+}
+
+func (s *shardedStore) Put(book Book) error {
+	if err := checkStoredID(book.ID); err != nil {
+		return err
+	}
+	sh := s.shard(book.ID)
+	sh.mu.Lock()
+	defer sh.mu.Unlock()
+	delta := bookSize(book)
+	if old, found := sh.books[book.ID]; found {
+		delta -= bookSize(old)
+	}
+	if err := s.claimBytes(delta); err != nil {
+		return err
+	}
+	sh.books[book.ID] = book
+	s.index.add(book)
+	s.ids.Observe(book.ID)
+	return nil
+}
+
+// claimBytes adds delta to the bytes counted unless that grows them past
+// the limit, failing with a StoreFullError then.
+func (s *shardedStore) claimBytes(delta int64) error {
+	for {
+		used := s.bytes.Load()
+		if err := checkGrowth(used, delta, s.maxBytes.Load()); err != nil {
+			return err
+		}
+		if s.bytes.CompareAndSwap(used, used+delta) {
+			return nil
+		}
+	}
+}
+
+func (s *shardedStore) Delete(id int) error {
+	sh := s.shard(id)
+	sh.mu.Lock()
+	defer sh.mu.Unlock()
+	if old, found := sh.books[id]; found {
+		s.bytes.Add(-bookSize(old))
+	}
+	delete(sh.books, id)
+	s.index.remove(id)
+	return nil
+}
+
+// Verify checks that every book is kept under its own ID in the shard
+// of that ID, and that the byte count and the full-text index match the
+// books. The shards are locked one at a time, so the check is only exact
+// while no writes are made.
+func (s *shardedStore) Verify() []string {
+	var violations []string
+	var bytes int64
+	books := make(map[int]Book)
+	for i := range s.shards {
+		sh := &s.shards[i]
+		sh.mu.RLock()
+		v, n := verifyBookMap(sh.books)
+		violations = append(violations, v...)
+		bytes += n
+		for id, book := range sh.books {
+			if s.shard(id) != sh {
+				violations = append(violations, fmt.Sprintf("book %d is stored in shard %d", id, i))
+			}
+			books[id] = book
+		}
+		sh.mu.RUnlock()
+	}
+	if stored := s.bytes.Load(); bytes != stored {
+		violations = append(violations, fmt.Sprintf("store counts %d bytes, its books hold %d", stored, bytes))
+	}
+	return append(violations, s.index.verify(books)...)
+}
+
+func (s *shardedStore) Bytes() int64        { return s.bytes.Load() }
+func (s *shardedStore) MaxBytes() int64     { return s.maxBytes.Load() }
+func (s *shardedStore) SetMaxBytes(n int64) { s.maxBytes.Store(n) }
+
+func (s *shardedStore) Search(query string, inDescription bool) []SearchHit {
+	return s.index.Search(query, inDescription)
+}
+
+func (s *shardedStore) IndexStats() IndexStats { return s.index.IndexStats() }
+
+// Transact runs fn as a transaction. No lock spans the shards, so the
+// writes are undone one by one if fn fails.
+func (s *shardedStore) Transact(ctx context.Context, fn func(tx BookStore) error) error {
+	return transactWithUndo(ctx, s, fn)
+}
+
+func (s *shardedStore) NextID() int { return s.ids.Peek() }
+
+func (s *shardedStore) Reserve(id int) error {
+	s.ids.Observe(id)
+	return nil
+}
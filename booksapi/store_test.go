@@ -0,0 +1,367 @@
+package booksapi
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"slices"
+	"sync"
+	"testing"
+
+	"golang.org/x/text/language"
+)
+
+// storeFactory returns a new, empty store, closed at the end of the test if
+// it needs closing.
+type storeFactory func(t *testing.T) BookStore
+
+// storeFactories are the stores every BookStore implementation is checked
+// against testBookStore with.
+var storeFactories = map[string]storeFactory{
+	"memory": func(t *testing.T) BookStore {
+		return newMemoryStore(newSequentialIDs())
+	},
+	"memory-sharded": func(t *testing.T) BookStore {
+		return newShardedStore(4, newSequentialIDs())
+	},
+	"journal": func(t *testing.T) BookStore {
+		s, err := openJournal(newMemoryStore(newSequentialIDs()), filepath.Join(t.TempDir(), "journal"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { s.Close() })
+		return s
+	},
+	"snapshot": func(t *testing.T) BookStore {
+		s, err := openSnapshotStore(newMemoryStore(newSequentialIDs()), filepath.Join(t.TempDir(), "snapshot.json"), 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { s.Close() })
+		return s
+	},
+	"sqlite": func(t *testing.T) BookStore {
+		s, err := openSQLiteStore(newMemoryStore(newSequentialIDs()), filepath.Join(t.TempDir(), "books.db"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { s.Close() })
+		return s
+	},
+}
+
+func TestStoreConformance(t *testing.T) {
+	for name, newStore := range storeFactories {
+		t.Run(name, func(t *testing.T) { testBookStore(t, newStore) })
+	}
+}
+
+// testBookStore checks that the stores newStore returns behave as the
+// BookStore interface describes.
+func testBookStore(t *testing.T, newStore storeFactory) {
+	t.Run("CRUD", func(t *testing.T) {
+		s := newStore(t)
+		created, err := s.Create(Book{Title: "Dune", Author: "Frank Herbert", Price: 9.99})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if created.ID != 1 {
+			t.Errorf("first book got ID %d, want 1", created.ID)
+		}
+		if got, ok := s.Get(created.ID); !ok || got.Title != "Dune" || got.Price != 9.99 {
+			t.Errorf("Get(%d) = %+v, %v", created.ID, got, ok)
+		}
+
+		created.Title = "Dune Messiah"
+		if err := s.Put(created); err != nil {
+			t.Fatal(err)
+		}
+		if got, _ := s.Get(created.ID); got.Title != "Dune Messiah" {
+			t.Errorf("after Put, title %q", got.Title)
+		}
+		if n := s.Count(); n != 1 {
+			t.Errorf("Count() = %d after replacing a book, want 1", n)
+		}
+
+		if err := s.Put(Book{ID: 7, Title: "Emma"}); err != nil {
+			t.Fatal(err)
+		}
+		if got, ok := s.Get(7); !ok || got.Title != "Emma" {
+			t.Errorf("Get(7) = %+v, %v after Put under a new ID", got, ok)
+		}
+
+		if err := s.Delete(created.ID); err != nil {
+			t.Fatal(err)
+		}
+		if _, ok := s.Get(created.ID); ok {
+			t.Errorf("book %d still there after Delete", created.ID)
+		}
+		if n := s.Count(); n != 1 {
+			t.Errorf("Count() = %d, want 1", n)
+		}
+	})
+
+	t.Run("not found", func(t *testing.T) {
+		s := newStore(t)
+		if _, ok := s.Get(1); ok {
+			t.Error("Get found a book in an empty store")
+		}
+		if err := s.Delete(1); err != nil {
+			t.Errorf("Delete of a missing book: %v", err)
+		}
+		for _, id := range []int{0, -1} {
+			var verr *ValidationError
+			if err := s.Put(Book{ID: id, Title: "X"}); !errors.As(err, &verr) {
+				t.Errorf("Put under ID %d: got %v, want a ValidationError", id, err)
+			}
+		}
+		if n := s.Count(); n != 0 {
+			t.Errorf("Count() = %d, want 0", n)
+		}
+	})
+
+	t.Run("ID monotonicity", func(t *testing.T) {
+		s := newStore(t)
+		last := 0
+		for i := range 5 {
+			next := s.NextID()
+			book, err := s.Create(Book{Title: fmt.Sprint("Book ", i)})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if book.ID != next {
+				t.Errorf("Create assigned %d, NextID said %d", book.ID, next)
+			}
+			if book.ID <= last {
+				t.Errorf("Create assigned %d after %d", book.ID, last)
+			}
+			last = book.ID
+		}
+
+		// Deleted IDs are not handed out again.
+		if err := s.Delete(last); err != nil {
+			t.Fatal(err)
+		}
+		if book, _ := s.Create(Book{Title: "After delete"}); book.ID <= last {
+			t.Errorf("Create reused ID %d after deleting %d", book.ID, last)
+		}
+
+		if err := s.Reserve(100); err != nil {
+			t.Fatal(err)
+		}
+		if book, _ := s.Create(Book{Title: "After reserve"}); book.ID <= 100 {
+			t.Errorf("Create assigned %d after Reserve(100)", book.ID)
+		}
+		if err := s.Put(Book{ID: 500, Title: "Put"}); err != nil {
+			t.Fatal(err)
+		}
+		if book, _ := s.Create(Book{Title: "After put"}); book.ID <= 500 {
+			t.Errorf("Create assigned %d after Put under 500", book.ID)
+		}
+	})
+
+	t.Run("concurrency", func(t *testing.T) {
+		s := newStore(t)
+		const workers, perWorker = 8, 25
+		ids := make([][]int, workers)
+		var wg sync.WaitGroup
+		for w := range workers {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				for i := range perWorker {
+					book, err := s.Create(Book{Title: fmt.Sprintf("Book %d-%d", w, i)})
+					if err != nil {
+						t.Error(err)
+						return
+					}
+					ids[w] = append(ids[w], book.ID)
+					book.Price = Price(i)
+					if err := s.Put(book); err != nil {
+						t.Error(err)
+					}
+					s.Get(book.ID)
+					s.List(ListOptions{Limit: 5})
+				}
+			}()
+		}
+		wg.Wait()
+
+		all := slices.Concat(ids...)
+		slices.Sort(all)
+		if len(slices.Compact(all)) != workers*perWorker {
+			t.Errorf("%d distinct IDs for %d creates", len(slices.Compact(all)), workers*perWorker)
+		}
+		if n := s.Count(); n != workers*perWorker {
+			t.Errorf("Count() = %d, want %d", n, workers*perWorker)
+		}
+		if v, ok := findStore[VerifiableStore](s); ok {
+			if violations := v.Verify(); len(violations) > 0 {
+				t.Errorf("Verify: %v", violations)
+			}
+		}
+	})
+
+	t.Run("ordering", func(t *testing.T) {
+		s := newStore(t)
+		for _, book := range []Book{
+			{ID: 3, Title: "Emma", Author: "Jane Austen", Price: 5},
+			{ID: 1, Title: "Dune", Author: "Frank Herbert", Price: 10},
+			{ID: 2, Title: "Beloved", Author: "Toni Morrison", Price: 5},
+		} {
+			if err := s.Put(book); err != nil {
+				t.Fatal(err)
+			}
+		}
+		tests := []struct {
+			opts ListOptions
+			want []int
+		}{
+			{ListOptions{}, []int{1, 2, 3}},
+			{ListOptions{Descending: true}, []int{3, 2, 1}},
+			{ListOptions{Sort: []string{"title"}}, []int{2, 1, 3}},
+			{ListOptions{Sort: []string{"-author"}}, []int{2, 3, 1}},
+			{ListOptions{Sort: []string{"price"}}, []int{2, 3, 1}},
+			{ListOptions{Sort: []string{"price", "-id"}}, []int{3, 2, 1}},
+		}
+		for _, tt := range tests {
+			if got := bookIDs(s.List(tt.opts)); !slices.Equal(got, tt.want) {
+				t.Errorf("List(%+v) = %v, want %v", tt.opts, got, tt.want)
+			}
+		}
+	})
+
+	t.Run("pagination", func(t *testing.T) {
+		s := newStore(t)
+		for i := range 10 {
+			if _, err := s.Create(Book{Title: fmt.Sprint("Book ", i)}); err != nil {
+				t.Fatal(err)
+			}
+		}
+		tests := []struct {
+			opts ListOptions
+			want []int
+		}{
+			{ListOptions{Limit: 3}, []int{1, 2, 3}},
+			{ListOptions{Limit: 3, Offset: 3}, []int{4, 5, 6}},
+			{ListOptions{Limit: 3, Offset: 9}, []int{10}},
+			{ListOptions{Offset: 10}, []int{}},
+			{ListOptions{Limit: 2, Offset: 1, Descending: true}, []int{9, 8}},
+			{ListOptions{Limit: 3, After: &bookCursor{ID: 4}}, []int{5, 6, 7}},
+			{ListOptions{After: &bookCursor{ID: 10}}, []int{}},
+			{ListOptions{Limit: 2, Descending: true, After: &bookCursor{Desc: true, ID: 4}}, []int{3, 2}},
+		}
+		for _, tt := range tests {
+			if got := bookIDs(s.List(tt.opts)); !slices.Equal(got, tt.want) {
+				t.Errorf("List(%+v) = %v, want %v", tt.opts, got, tt.want)
+			}
+		}
+	})
+
+	t.Run("list options", func(t *testing.T) {
+		s := newStore(t)
+		for _, book := range []Book{
+			{ID: 1, Title: "Dune", Author: "Frank Herbert", Price: 10, Language: "en", Editions: []Edition{{Format: "paperback", Price: 10}}},
+			{ID: 2, Title: "Emma", Author: "Jane Austen", Price: 5, Language: "en-GB", Attributes: map[string]string{"shelf": "A3"}},
+			{ID: 3, Title: "Dom Casmurro", Author: "Machado de Assis", Price: 7, Language: "pt-BR", Editions: []Edition{{Format: "ebook", Price: 7}}},
+			{ID: 4, Title: "Émile", Author: "Jean-Jacques Rousseau", Price: 12, Language: "fr", Attributes: map[string]string{"shelf": "B1"}},
+			{ID: 5, Title: "Persuasion", Author: "Jane Austen", Price: 6, Language: "en", Editions: []Edition{{Format: "ebook", Price: 6}, {Format: "hardcover", Price: 20}}},
+		} {
+			if err := s.Put(book); err != nil {
+				t.Fatal(err)
+			}
+		}
+		price := func(p float64) *float64 { return &p }
+		byTitle := ListOptions{Sort: []string{"title"}}
+		tests := []struct {
+			opts ListOptions
+			want []int
+		}{
+			{ListOptions{Authors: []string{"jane austen"}}, []int{2, 5}},
+			{ListOptions{Authors: []string{"Jane"}}, []int{}},
+			{ListOptions{Authors: []string{"Jane"}, Match: matchPrefix}, []int{2, 5}},
+			{ListOptions{Titles: []string{"m"}, Match: matchContains}, []int{2, 3, 4}},
+			{ListOptions{Titles: []string{"Dune", "Emma"}}, []int{1, 2}},
+			{ListOptions{Authors: []string{"Jane Austen"}, Titles: []string{"Emma"}}, []int{2}},
+			{ListOptions{Languages: []language.Tag{language.English}}, []int{1, 2, 5}},
+			{ListOptions{Languages: []language.Tag{language.BritishEnglish, language.French}}, []int{2, 4}},
+			{ListOptions{MinPrice: price(6)}, []int{1, 3, 4, 5}},
+			{ListOptions{MaxPrice: price(7)}, []int{2, 3, 5}},
+			{ListOptions{MinPrice: price(6), MaxPrice: price(10)}, []int{1, 3, 5}},
+			{ListOptions{Formats: []string{"ebook"}}, []int{3, 5}},
+			{ListOptions{Formats: []string{"paperback", "hardcover"}}, []int{1, 5}},
+			{ListOptions{Attributes: map[string][]string{"shelf": {"a3"}}}, []int{2}},
+			{ListOptions{Attributes: map[string][]string{"shelf": {"A", "B"}}, Match: matchPrefix}, []int{2, 4}},
+			{ListOptions{StartsWith: "E"}, []int{2, 4}},
+			{ListOptions{StartsWith: "J", By: "author"}, []int{2, 4, 5}},
+			{ListOptions{Authors: []string{"Jane Austen"}, Sort: []string{"-price"}, Limit: 1}, []int{5}},
+			{ListOptions{Formats: []string{"ebook"}, Descending: true}, []int{5, 3}},
+			{ListOptions{Sort: byTitle.Sort, After: newBookCursor(Book{ID: 1, Title: "Dune"}, byTitle)}, []int{4, 2, 5}},
+			{ListOptions{Sort: byTitle.Sort, After: newBookCursor(Book{ID: 1, Title: "Dune"}, byTitle), Limit: 2}, []int{4, 2}},
+		}
+		for _, tt := range tests {
+			if got := bookIDs(s.List(tt.opts)); !slices.Equal(got, tt.want) {
+				t.Errorf("List(%+v) = %v, want %v", tt.opts, got, tt.want)
+			}
+		}
+		if n := s.Count(); n != 5 {
+			t.Errorf("Count() = %d after filtered listings, want 5", n)
+		}
+	})
+
+	t.Run("transactions", func(t *testing.T) {
+		s := newStore(t)
+		kept, err := s.Create(Book{Title: "Kept"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		failure := errors.New("abort")
+		err = s.Transact(context.Background(), func(tx BookStore) error {
+			if _, err := tx.Create(Book{Title: "Undone"}); err != nil {
+				return err
+			}
+			changed := kept
+			changed.Title = "Changed"
+			if err := tx.Put(changed); err != nil {
+				return err
+			}
+			if got, _ := tx.Get(kept.ID); got.Title != "Changed" {
+				t.Errorf("transaction reads %q, not its own write", got.Title)
+			}
+			return failure
+		})
+		if !errors.Is(err, failure) {
+			t.Fatalf("Transact returned %v, want %v", err, failure)
+		}
+		if got := bookIDs(s.List(ListOptions{})); !slices.Equal(got, []int{kept.ID}) {
+			t.Errorf("after a failed transaction the store holds %v", got)
+		}
+		if got, _ := s.Get(kept.ID); got.Title != "Kept" {
+			t.Errorf("failed transaction left title %q", got.Title)
+		}
+		if next := s.NextID(); next <= kept.ID+1 {
+			t.Errorf("NextID() = %d reuses an ID of the failed transaction", next)
+		}
+
+		err = s.Transact(context.Background(), func(tx BookStore) error {
+			_, err := tx.Create(Book{Title: "Committed"})
+			return err
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if n := s.Count(); n != 2 {
+			t.Errorf("Count() = %d after a committed transaction, want 2", n)
+		}
+	})
+}
+
+// bookIDs returns the IDs of books, in order.
+func bookIDs(books []Book) []int {
+	ids := make([]int, len(books))
+	for i, book := range books {
+		ids[i] = book.ID
+	}
+	return ids
+}
@@ -0,0 +1,126 @@
+package booksapi
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// Errors of the book store and the operations built on it. Handlers do not
+// pick statuses for them; writeAPIError maps them with mapError.
+var (
+	// ErrNotFound is wrapped by the errors of operations on books that do
+	// not exist.
+	ErrNotFound = errors.New("book not found")
+	// ErrConflict is wrapped by the errors of writes that clash with the
+	// current state of a book.
+	ErrConflict = errors.New("book conflict")
+	// ErrValidation matches every ValidationError.
+	ErrValidation = errors.New("invalid book")
+	// ErrUnavailable is wrapped by the errors of stores whose backend cannot
+	// be reached or written to, such as a journal on a full disk. Requests
+	// failing with it get 503 and Retry-After rather than 500.
+	ErrUnavailable = errors.New("book store unavailable")
+)
+
+// ValidationError reports the fields of a book a store refused to write.
+type ValidationError struct {
+	Fields []FieldError
+}
+
+func (e *ValidationError) Error() string {
+	messages := make([]string, len(e.Fields))
+	for i, fe := range e.Fields {
+		messages[i] = fe.Message
+	}
+	return "invalid book: " + strings.Join(messages, "; ")
+}
+
+// Is makes every ValidationError match ErrValidation.
+func (e *ValidationError) Is(target error) bool { return target == ErrValidation }
+
+// checkStoredID fails with a ValidationError if id cannot be stored.
+func checkStoredID(id int) error {
+	if id < 1 {
+		return &ValidationError{Fields: []FieldError{newFieldError("id", "min", "min", 1)}}
+	}
+	return nil
+}
+
+// errorMappings maps the store errors to the status and code reporting
+// them, checked in order with errors.Is.
+var errorMappings = []struct {
+	err    error
+	status int
+	code   string
+}{
+	{ErrNotFound, http.StatusNotFound, "book_not_found"},
+	{ErrConflict, http.StatusConflict, "conflict"},
+	{ErrValidation, http.StatusUnprocessableEntity, "validation_failed"},
+	{ErrUnavailable, http.StatusServiceUnavailable, "store_unavailable"},
+	{ErrStoreFull, http.StatusInsufficientStorage, "store_full"},
+}
+
+// mapError returns the status and code reporting err: those of an apiError
+// it wraps, those of the store error it wraps, or 500 internal_error. A
+// ValidationError is reported with the code of its first field error, as
+// validationError does.
+func mapError(err error) (int, string) {
+	var e *apiError
+	if errors.As(err, &e) {
+		return e.Status, e.Code
+	}
+	var verr *ValidationError
+	if errors.As(err, &verr) && len(verr.Fields) > 0 {
+		return http.StatusUnprocessableEntity, ruleCodes[verr.Fields[0].Rule]
+	}
+	for _, m := range errorMappings {
+		if errors.Is(err, m.err) {
+			return m.status, m.code
+		}
+	}
+	return http.StatusInternalServerError, "internal_error"
+}
+
+// asAPIError returns the apiError reporting err, built with mapError unless
+// err wraps one. A StoreFullError carries the store's usage and limit.
+func asAPIError(err error) *apiError {
+	var e *apiError
+	if errors.As(err, &e) {
+		return e
+	}
+	var full *StoreFullError
+	if errors.As(err, &full) {
+		return newAPIError(http.StatusInsufficientStorage, "store_full", "used_bytes", full.Used, "needed_bytes", full.Need, "limit_bytes", full.Limit)
+	}
+	status, code := mapError(err)
+	e = newAPIError(status, code)
+	var verr *ValidationError
+	if errors.As(err, &verr) && len(verr.Fields) > 0 {
+		e.Fields = verr.Fields
+		e.Params = make(map[string]string, len(verr.Fields[0].Params))
+		for k, v := range verr.Fields[0].Params {
+			e.Params[k] = v
+		}
+	}
+	return e
+}
+
+// findBook returns the book with the given ID, for a change to it. It fails
+// with ErrNotFound if there is none, and with 409 if the book is archived.
+func findBook(id int) (Book, error) {
+	return findBookIn(store, id)
+}
+
+// findBookIn is findBook reading from s, such as a transaction.
+func findBookIn(s BookStore, id int) (Book, error) {
+	book, found := s.Get(id)
+	if !found {
+		if isArchived(id) {
+			return Book{}, archivedError(id)
+		}
+		return Book{}, fmt.Errorf("book %d: %w", id, ErrNotFound)
+	}
+	return book, nil
+}
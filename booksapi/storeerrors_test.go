@@ -0,0 +1,136 @@
+package booksapi
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// failingStore fails every write with err, if set, as a backend returning
+// it would.
+type failingStore struct {
+	BookStore
+	err error
+}
+
+func (s *failingStore) Create(book Book) (Book, error) {
+	if s.err != nil {
+		return book, s.err
+	}
+	return s.BookStore.Create(book)
+}
+
+func (s *failingStore) Put(book Book) error {
+	if s.err != nil {
+		return s.err
+	}
+	return s.BookStore.Put(book)
+}
+
+func (s *failingStore) Delete(id int) error {
+	if s.err != nil {
+		return s.err
+	}
+	return s.BookStore.Delete(id)
+}
+
+func (s *failingStore) Transact(ctx context.Context, fn func(tx BookStore) error) error {
+	if s.err != nil {
+		return s.err
+	}
+	return s.BookStore.Transact(ctx, fn)
+}
+
+// TestMapError checks the status and code of each store error, wrapped as
+// backends wrap them.
+func TestMapError(t *testing.T) {
+	tests := []struct {
+		err    error
+		status int
+		code   string
+	}{
+		{fmt.Errorf("book 7: %w", ErrNotFound), http.StatusNotFound, "book_not_found"},
+		{fmt.Errorf("book 7: version 3: %w", ErrConflict), http.StatusConflict, "conflict"},
+		{fmt.Errorf("sqlite: %w", &ValidationError{Fields: []FieldError{newFieldError("title", "required")}}), http.StatusUnprocessableEntity, "field_required"},
+		{&ValidationError{}, http.StatusUnprocessableEntity, "validation_failed"},
+		{fmt.Errorf("dial tcp: %w", ErrUnavailable), http.StatusServiceUnavailable, "store_unavailable"},
+		{&StoreFullError{Used: 90, Need: 20, Limit: 100}, http.StatusInsufficientStorage, "store_full"},
+		{newAPIError(http.StatusGone, "snapshot_expired"), http.StatusGone, "snapshot_expired"},
+		{errors.New("disk I/O error"), http.StatusInternalServerError, "internal_error"},
+	}
+	for _, tt := range tests {
+		if status, code := mapError(tt.err); status != tt.status || code != tt.code {
+			t.Errorf("mapError(%v) = %d %s, want %d %s", tt.err, status, code, tt.status, tt.code)
+		}
+	}
+}
+
+// TestStoreErrors feeds each store error to the write handlers through a
+// store failing with it, and checks the responses.
+func TestStoreErrors(t *testing.T) {
+	tests := []struct {
+		name   string
+		err    error
+		status int
+		code   string
+		params map[string]string
+	}{
+		{"not found", fmt.Errorf("book 1: %w", ErrNotFound), http.StatusNotFound, "book_not_found", nil},
+		{"conflict", fmt.Errorf("book 1: %w", ErrConflict), http.StatusConflict, "conflict", nil},
+		{"validation", &ValidationError{Fields: []FieldError{newFieldError("isbn", "isbn")}}, http.StatusUnprocessableEntity, "invalid_isbn", map[string]string{"field": "isbn"}},
+		{"unavailable", fmt.Errorf("connect: %w", ErrUnavailable), http.StatusServiceUnavailable, "store_unavailable", nil},
+		{"full", &StoreFullError{Used: 90, Need: 20, Limit: 100}, http.StatusInsufficientStorage, "store_full", map[string]string{"used_bytes": "90", "needed_bytes": "20", "limit_bytes": "100"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			backend := &failingStore{BookStore: newMemoryStore(newSequentialIDs())}
+			h := newTestServer(t, WithStore(backend))
+			mustCreateBook(t, h, map[string]any{"title": "Dune"})
+			backend.err = tt.err
+
+			for _, req := range []struct{ method, path string }{{http.MethodPost, "/books"}, {http.MethodPut, "/books/1"}, {http.MethodDelete, "/books/1"}} {
+				rec := serve(t, h, req.method, req.path, map[string]any{"title": "Emma"})
+				wantCode(t, rec, tt.status)
+				got := decode[errorBody](t, rec).Error
+				if got.Code != tt.code || (tt.params != nil && !reflect.DeepEqual(got.Params, tt.params)) {
+					t.Errorf("%s %s: error %s %v, want %s %v", req.method, req.path, got.Code, got.Params, tt.code, tt.params)
+				}
+				if retry := rec.Header().Get("Retry-After"); (retry != "") != (tt.status == http.StatusServiceUnavailable) {
+					t.Errorf("%s %s: Retry-After %q", req.method, req.path, retry)
+				}
+			}
+		})
+	}
+}
+
+// TestInternalStoreError checks that an unknown store error gets 500
+// naming the request ID, and that its message is logged but not sent.
+func TestInternalStoreError(t *testing.T) {
+	var logs bytes.Buffer
+	secret := "pq: password authentication failed for user \"books\" at 10.0.0.7"
+	backend := &failingStore{BookStore: newMemoryStore(newSequentialIDs()), err: errors.New(secret)}
+	h := newTestServer(t, WithStore(backend), WithLogger(log.New(&logs, "", 0)))
+
+	for _, accept := range []string{"application/json", problemType} {
+		rec := serve(t, h, http.MethodPost, "/books", map[string]any{"title": "Emma"}, "X-Request-ID", "req-42", "Accept", accept)
+		wantCode(t, rec, http.StatusInternalServerError)
+		if strings.Contains(rec.Body.String(), "password") || strings.Contains(rec.Body.String(), "10.0.0.7") {
+			t.Errorf("%s response leaks the internal error: %s", accept, rec.Body)
+		}
+		if !strings.Contains(rec.Body.String(), `"req-42"`) {
+			t.Errorf("%s response does not name the request ID: %s", accept, rec.Body)
+		}
+	}
+	if got := decode[errorBody](t, serve(t, h, http.MethodPost, "/books", map[string]any{"title": "Emma"}, "X-Request-ID", "req-43")).Error; got.Code != "internal_error" || got.Params["request_id"] != "req-43" {
+		t.Errorf("error %s %v, want internal_error naming req-43", got.Code, got.Params)
+	}
+	if !strings.Contains(logs.String(), "req-42") || !strings.Contains(logs.String(), secret) {
+		t.Errorf("internal error not logged with its request ID:\n%s", logs.String())
+	}
+}
@@ -0,0 +1,182 @@
+package booksapi
+
+import (
+	"context"
+	"expvar"
+	"io"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// slowStoreOp is the duration above which a store operation is logged. It is
+// configured by a flag in Main; zero disables the log.
+var slowStoreOp = 100 * time.Millisecond
+
+// storeOpBuckets are the upper bounds, in milliseconds, of the buckets of
+// the store operation duration histograms. Each bucket counts the
+// operations at most as slow as its bound, as in a Prometheus histogram.
+var storeOpBuckets = []float64{0.1, 0.5, 1, 5, 10, 50, 100, 500, 1000}
+
+// storeOpStats holds the metrics of one store method.
+type storeOpStats struct {
+	Count   int64            `json:"count"`
+	Errors  int64            `json:"errors"`
+	SumMS   float64          `json:"sum_ms"`
+	Buckets map[string]int64 `json:"buckets"` // keyed by upper bound in ms, plus "+Inf"
+}
+
+// storeMetrics collects the metrics of every store method, published under
+// /debug/vars as store_ops.
+var storeMetrics = struct {
+	mu  sync.Mutex
+	ops map[string]*storeOpStats
+}{ops: make(map[string]*storeOpStats)}
+
+func init() {
+	expvar.Publish("store_ops", expvar.Func(func() any {
+		storeMetrics.mu.Lock()
+		defer storeMetrics.mu.Unlock()
+
+		ops := make(map[string]storeOpStats, len(storeMetrics.ops))
+		for method, stats := range storeMetrics.ops {
+			copied := *stats
+			copied.Buckets = make(map[string]int64, len(stats.Buckets))
+			for bound, n := range stats.Buckets {
+				copied.Buckets[bound] = n
+			}
+			ops[method] = copied
+		}
+		return ops
+	}))
+}
+
+// observeStoreOp records a store operation that started at start and
+// failed if err is not nil, logging it if it was slow.
+func observeStoreOp(method string, start time.Time, err error) {
+	elapsed := time.Since(start)
+	ms := float64(elapsed.Microseconds()) / 1000
+
+	storeMetrics.mu.Lock()
+	stats, ok := storeMetrics.ops[method]
+	if !ok {
+		stats = &storeOpStats{Buckets: make(map[string]int64, len(storeOpBuckets)+1)}
+		storeMetrics.ops[method] = stats
+	}
+	stats.Count++
+	stats.SumMS += ms
+	if err != nil {
+		stats.Errors++
+	}
+	for _, bound := range storeOpBuckets {
+		if ms <= bound {
+			stats.Buckets[strconv.FormatFloat(bound, 'f', -1, 64)]++
+		}
+	}
+	stats.Buckets["+Inf"]++
+	storeMetrics.mu.Unlock()
+
+	if slowStoreOp > 0 && elapsed > slowStoreOp {
+		logger.Printf("WARN slow store operation: method=%s duration=%s", method, elapsed)
+	}
+}
+
+// instrumentedStore records the duration and errors of every call to the
+// store it wraps.
+type instrumentedStore struct {
+	inner BookStore
+}
+
+// instrumentStore wraps inner with metrics, unless it already has them.
+func instrumentStore(inner BookStore) BookStore {
+	if _, ok := inner.(*instrumentedStore); ok {
+		return inner
+	}
+	return &instrumentedStore{inner: inner}
+}
+
+// Unwrap returns the wrapped store.
+func (s *instrumentedStore) Unwrap() BookStore { return s.inner }
+
+func (s *instrumentedStore) Get(id int) (Book, bool) {
+	defer observeStoreOp("Get", time.Now(), nil)
+	return s.inner.Get(id)
+}
+
+func (s *instrumentedStore) List(opts ListOptions) []Book {
+	defer observeStoreOp("List", time.Now(), nil)
+	return s.inner.List(opts)
+}
+
+func (s *instrumentedStore) Count() int {
+	defer observeStoreOp("Count", time.Now(), nil)
+	return s.inner.Count()
+}
+
+func (s *instrumentedStore) Create(book Book) (Book, error) {
+	start := time.Now()
+	book, err := s.inner.Create(book)
+	observeStoreOp("Create", start, err)
+	return book, err
+}
+
+func (s *instrumentedStore) Put(book Book) error {
+	start := time.Now()
+	err := s.inner.Put(book)
+	observeStoreOp("Put", start, err)
+	return err
+}
+
+func (s *instrumentedStore) Delete(id int) error {
+	start := time.Now()
+	err := s.inner.Delete(id)
+	observeStoreOp("Delete", start, err)
+	return err
+}
+
+func (s *instrumentedStore) NextID() int {
+	defer observeStoreOp("NextID", time.Now(), nil)
+	return s.inner.NextID()
+}
+
+func (s *instrumentedStore) Reserve(id int) error {
+	start := time.Now()
+	err := s.inner.Reserve(id)
+	observeStoreOp("Reserve", start, err)
+	return err
+}
+
+// Transact records the duration of the whole transaction, and of each call
+// made within it.
+func (s *instrumentedStore) Transact(ctx context.Context, fn func(tx BookStore) error) error {
+	start := time.Now()
+	err := s.inner.Transact(ctx, func(tx BookStore) error {
+		return fn(&instrumentedStore{inner: tx})
+	})
+	observeStoreOp("Transact", start, err)
+	return err
+}
+
+// Close closes the wrapped store if it needs closing.
+func (s *instrumentedStore) Close() error {
+	if closer, ok := s.inner.(io.Closer); ok {
+		return closer.Close()
+	}
+	return nil
+}
+
+// findStore returns the first store of type T among s and the stores it
+// wraps through Unwrap.
+func findStore[T any](s BookStore) (T, bool) {
+	for {
+		if found, ok := s.(T); ok {
+			return found, true
+		}
+		unwrapper, ok := s.(interface{ Unwrap() BookStore })
+		if !ok {
+			var zero T
+			return zero, false
+		}
+		s = unwrapper.Unwrap()
+	}
+}
@@ -0,0 +1,98 @@
+package booksapi
+
+import (
+	"bytes"
+	"errors"
+	"log"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+// slowStore delays every Create, and fails every Put.
+type slowStore struct {
+	BookStore
+	delay time.Duration
+}
+
+var errPutFailed = errors.New("put failed")
+
+func (s slowStore) Create(book Book) (Book, error) {
+	time.Sleep(s.delay)
+	return s.BookStore.Create(book)
+}
+
+func (s slowStore) Put(Book) error { return errPutFailed }
+
+// storeOp returns a copy of the metrics of a store method.
+func storeOp(method string) storeOpStats {
+	storeMetrics.mu.Lock()
+	defer storeMetrics.mu.Unlock()
+	stats := storeOpStats{Buckets: make(map[string]int64)}
+	if s, ok := storeMetrics.ops[method]; ok {
+		stats = *s
+		stats.Buckets = make(map[string]int64)
+		for bound, n := range s.Buckets {
+			stats.Buckets[bound] = n
+		}
+	}
+	return stats
+}
+
+// TestStoreMetrics checks that the store of the server is instrumented:
+// a slow operation is logged and counted in the buckets its duration
+// falls in, and a failed one counted as an error.
+func TestStoreMetrics(t *testing.T) {
+	var logs bytes.Buffer
+	setForTest(t, &slowStoreOp, 20*time.Millisecond)
+	h := newTestServer(t, WithStore(slowStore{newMemoryStore(newSequentialIDs()), 30 * time.Millisecond}), WithLogger(log.New(&logs, "", 0)))
+	if _, ok := store.(*instrumentedStore); !ok {
+		t.Fatalf("store %T is not instrumented", store)
+	}
+
+	creates := storeOp("Create")
+	mustCreateBook(t, h, map[string]any{"title": "Dune"})
+	got := storeOp("Create")
+	if got.Count != creates.Count+1 || got.Errors != creates.Errors {
+		t.Errorf("Create counted %d times with %d errors, want once without", got.Count-creates.Count, got.Errors-creates.Errors)
+	}
+	if got.SumMS-creates.SumMS < 30 {
+		t.Errorf("Create took %vms, want at least 30", got.SumMS-creates.SumMS)
+	}
+	for bound, want := range map[string]int64{"10": 0, "1000": 1, "+Inf": 1} {
+		if n := got.Buckets[bound] - creates.Buckets[bound]; n != want {
+			t.Errorf("bucket %s counted the create %d times, want %d", bound, n, want)
+		}
+	}
+	line := "WARN slow store operation: method=Create duration="
+	if !strings.Contains(logs.String(), line) {
+		t.Errorf("log %q lacks %q", logs.String(), line)
+	}
+
+	logs.Reset()
+	puts := storeOp("Put")
+	wantCode(t, serve(t, h, http.MethodPut, "/books/1", map[string]any{"title": "Emma"}), http.StatusInternalServerError)
+	if got := storeOp("Put"); got.Count != puts.Count+1 || got.Errors != puts.Errors+1 {
+		t.Errorf("Put counted %d times with %d errors, want once with one", got.Count-puts.Count, got.Errors-puts.Errors)
+	}
+	if strings.Contains(logs.String(), "slow store operation") {
+		t.Errorf("fast operation logged: %q", logs.String())
+	}
+}
+
+// TestInstrumentStoreOnce checks that a store is instrumented once however
+// often it is wrapped, and that the stores below can still be found.
+func TestInstrumentStoreOnce(t *testing.T) {
+	inner := newMemoryStore(newSequentialIDs())
+	s := instrumentStore(instrumentStore(inner))
+	if _, ok := s.(*instrumentedStore).inner.(*instrumentedStore); ok {
+		t.Error("store instrumented twice")
+	}
+	if found, ok := findStore[*memoryStore](s); !ok || found != inner {
+		t.Errorf("findStore = %v, %v; want the memory store", found, ok)
+	}
+	if _, ok := findStore[*snapshotStore](s); ok {
+		t.Error("findStore found a snapshot store that is not there")
+	}
+}
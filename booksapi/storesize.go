@@ -0,0 +1,102 @@
+package booksapi
+
+import (
+	"errors"
+	"fmt"
+	"time"
+	"unsafe"
+)
+
+// maxStoreBytes caps the approximate memory held by the books of the store,
+// as bookSize counts it. Zero means unlimited. It is configured by a flag in
+// main and applied once the store is loaded, so a journal or snapshot larger
+// than the limit still loads; writes growing the store past it then fail.
+var maxStoreBytes int64
+
+// SizedStore is implemented by stores that account for the memory held by
+// their books. GET /books/stats and /debug/vars report it.
+type SizedStore interface {
+	// Bytes returns the approximate number of bytes held by the books.
+	Bytes() int64
+	// MaxBytes returns the limit on Bytes, or zero if there is none.
+	MaxBytes() int64
+	// SetMaxBytes sets the limit on Bytes. Writes that would grow the
+	// store past it fail with a StoreFullError; those shrinking it or
+	// keeping its size do not, so deleting books always makes room.
+	SetMaxBytes(n int64)
+}
+
+// ErrStoreFull matches every StoreFullError.
+var ErrStoreFull = errors.New("book store full")
+
+// StoreFullError reports a write refused because it would grow a store
+// past its byte limit.
+type StoreFullError struct {
+	Used  int64 // bytes held before the write
+	Need  int64 // bytes the write would add
+	Limit int64
+}
+
+func (e *StoreFullError) Error() string {
+	return fmt.Sprintf("book store full: %d of %d bytes used, %d more needed", e.Used, e.Limit, e.Need)
+}
+
+// Is makes every StoreFullError match ErrStoreFull.
+func (e *StoreFullError) Is(target error) bool { return target == ErrStoreFull }
+
+// checkGrowth fails with a StoreFullError if adding delta bytes to used
+// would pass limit.
+func checkGrowth(used, delta, limit int64) error {
+	if limit > 0 && delta > 0 && used+delta > limit {
+		return &StoreFullError{Used: used, Need: delta, Limit: limit}
+	}
+	return nil
+}
+
+// bookEntry is the approximate size of a map entry holding a book, besides
+// the book itself: its key and the map's bookkeeping.
+const bookEntry = 16
+
+// bookSize returns the approximate number of bytes a stored book holds: the
+// struct and its map entry, its strings, and the values its pointers and
+// attributes refer to. Memory shared with other books, and the allocator's
+// rounding, are not counted.
+func bookSize(b Book) int64 {
+	n := int64(unsafe.Sizeof(b)) + bookEntry
+	n += int64(len(b.Title) + len(b.Author) + len(b.Currency) + len(b.ISBN) + len(b.Description) + len(b.Language))
+	if b.CostPrice != nil {
+		n += int64(unsafe.Sizeof(*b.CostPrice))
+	}
+	for _, e := range b.Editions {
+		n += int64(unsafe.Sizeof(e)) + int64(len(e.Format)+len(e.ISBN))
+	}
+	if b.PublisherID != nil {
+		n += int64(unsafe.Sizeof(*b.PublisherID))
+	}
+	if b.SeriesID != nil {
+		n += int64(unsafe.Sizeof(*b.SeriesID))
+	}
+	if b.CreatedAt != nil {
+		n += int64(unsafe.Sizeof(time.Time{}))
+	}
+	if b.UpdatedAt != nil {
+		n += int64(unsafe.Sizeof(time.Time{}))
+	}
+	if b.Attributes != nil {
+		const attributeEntry = 2*int64(unsafe.Sizeof("")) + 8 // key and value headers, plus bookkeeping
+		n += 48                                               // the map header
+		for k, v := range b.Attributes {
+			n += attributeEntry + int64(len(k)+len(v))
+		}
+	}
+	return n
+}
+
+// storeBytes returns the bytes held by the books of store and its limit,
+// both zero if it does not account for them.
+func storeBytes() (used, limit int64) {
+	if sized, ok := findStore[SizedStore](store); ok {
+		return sized.Bytes(), sized.MaxBytes()
+	}
+	return 0, 0
+}
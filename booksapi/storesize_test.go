@@ -0,0 +1,194 @@
+package booksapi
+
+import (
+	"context"
+	"errors"
+	"expvar"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+)
+
+// TestBookSize checks that bookSize counts the bytes of a book's strings
+// and attributes on top of the struct.
+func TestBookSize(t *testing.T) {
+	base := bookSize(Book{})
+	if base < 100 || base > 1000 {
+		t.Fatalf("empty book counts %d bytes", base)
+	}
+	tests := []struct {
+		book Book
+		want int64
+	}{
+		{Book{Title: strings.Repeat("a", 1000)}, base + 1000},
+		{Book{Title: "Dune", Author: "Frank Herbert", ISBN: "9780441013593"}, base + 4 + 13 + 13},
+		{Book{Description: strings.Repeat("é", 500)}, base + 1000},
+	}
+	for _, tt := range tests {
+		if got := bookSize(tt.book); got != tt.want {
+			t.Errorf("bookSize(%.20q) = %d, want %d", tt.book.Title+tt.book.Description, got, tt.want)
+		}
+	}
+
+	one := bookSize(Book{Attributes: map[string]string{"shelf": "A3"}})
+	two := bookSize(Book{Attributes: map[string]string{"shelf": "A3", "edition": strings.Repeat("x", 100)}})
+	if one <= base+7 || two-one <= 107 || two-one > 200 {
+		t.Errorf("attributes count %d and %d bytes over an empty book of %d", one, two, base)
+	}
+}
+
+// TestStoreBytes checks that the in-memory stores keep count of the bytes
+// of their books through creates, updates, deletes, and transactions that
+// fail.
+func TestStoreBytes(t *testing.T) {
+	for _, name := range []string{"memory", "memory-sharded"} {
+		t.Run(name, func(t *testing.T) {
+			s := storeFactories[name](t)
+			sized := s.(SizedStore)
+			if got := sized.Bytes(); got != 0 {
+				t.Fatalf("empty store holds %d bytes", got)
+			}
+			small, err := s.Create(Book{Title: strings.Repeat("a", 100)})
+			if err != nil {
+				t.Fatal(err)
+			}
+			big, err := s.Create(Book{Title: strings.Repeat("b", 10000)})
+			if err != nil {
+				t.Fatal(err)
+			}
+			base := bookSize(Book{})
+			if got, want := sized.Bytes(), 2*base+10100; got != want {
+				t.Errorf("after two creates: %d bytes, want %d", got, want)
+			}
+
+			big.Title = strings.Repeat("b", 4000)
+			if err := s.Put(big); err != nil {
+				t.Fatal(err)
+			}
+			if got, want := sized.Bytes(), 2*base+4100; got != want {
+				t.Errorf("after shrinking a title: %d bytes, want %d", got, want)
+			}
+
+			err = s.Transact(context.Background(), func(tx BookStore) error {
+				if _, err := tx.Create(Book{Title: strings.Repeat("c", 5000)}); err != nil {
+					return err
+				}
+				if err := tx.Delete(small.ID); err != nil {
+					return err
+				}
+				return errors.New("abandoned")
+			})
+			if err == nil {
+				t.Fatal("transaction succeeded")
+			}
+			if got, want := sized.Bytes(), 2*base+4100; got != want {
+				t.Errorf("after a failed transaction: %d bytes, want %d", got, want)
+			}
+
+			if err := s.Delete(big.ID); err != nil {
+				t.Fatal(err)
+			}
+			if err := s.Delete(small.ID); err != nil {
+				t.Fatal(err)
+			}
+			if got := sized.Bytes(); got != 0 {
+				t.Errorf("after deleting every book: %d bytes, want 0", got)
+			}
+		})
+	}
+}
+
+// TestStoreByteLimit checks through the API that writes growing the store
+// past -max-store-bytes get 507 with its usage, that writes shrinking it
+// still succeed, and that deleting books makes room again. The usage is
+// reported in GET /books/stats and /debug/vars.
+func TestStoreByteLimit(t *testing.T) {
+	h := newTestServer(t)
+	sized, ok := findStore[SizedStore](store)
+	if !ok {
+		t.Fatal("the store does not account for its bytes")
+	}
+	first := mustCreateBook(t, h, map[string]any{"title": strings.Repeat("a", 1000)})
+	perBook := sized.Bytes()
+	limit := 3*perBook + 500
+	sized.SetMaxBytes(limit)
+	mustCreateBook(t, h, map[string]any{"title": strings.Repeat("b", 1000)})
+	mustCreateBook(t, h, map[string]any{"title": strings.Repeat("c", 1000)})
+	used := sized.Bytes()
+
+	stats := decode[bookStats](t, serve(t, h, http.MethodGet, "/books/stats", nil))
+	if stats.Bytes != used || stats.MaxBytes != limit {
+		t.Errorf("stats %d of %d bytes, want %d of %d", stats.Bytes, stats.MaxBytes, used, limit)
+	}
+	for name, want := range map[string]int64{"store_bytes": used, "store_max_bytes": limit} {
+		if got := expvar.Get(name).String(); got != strconv.FormatInt(want, 10) {
+			t.Errorf("%s %s, want %d", name, got, want)
+		}
+	}
+
+	for _, req := range []struct{ method, path string }{
+		{http.MethodPost, "/books"},
+		{http.MethodPut, "/books/1"},
+	} {
+		rec := serve(t, h, req.method, req.path, map[string]any{"title": strings.Repeat("d", 2000)})
+		wantCode(t, rec, http.StatusInsufficientStorage)
+		got := decode[errorBody](t, rec).Error
+		if got.Code != "store_full" || got.Params["used_bytes"] != strconv.FormatInt(used, 10) || got.Params["limit_bytes"] != strconv.FormatInt(limit, 10) || got.Params["needed_bytes"] == "" {
+			t.Errorf("%s %s: error %s %v", req.method, req.path, got.Code, got.Params)
+		}
+	}
+	if got := sized.Bytes(); got != used {
+		t.Errorf("refused writes changed the usage to %d, want %d", got, used)
+	}
+
+	// A shrinking update succeeds at the limit, as does a delete,
+	// after which a new book fits again.
+	wantCode(t, serve(t, h, http.MethodPut, "/books/1", map[string]any{"title": "Dune"}), http.StatusOK)
+	if got := sized.Bytes(); got != used-996 {
+		t.Errorf("after shrinking book %d: %d bytes, want %d", first.ID, got, used-996)
+	}
+	wantCode(t, serve(t, h, http.MethodDelete, "/books/2", nil), http.StatusNoContent)
+	mustCreateBook(t, h, map[string]any{"title": strings.Repeat("d", 2000)})
+}
+
+// TestShardedStoreByteLimitConcurrent has writers to every shard of a
+// sharded store race to fill it, and checks that together they never grow
+// it past its limit.
+func TestShardedStoreByteLimitConcurrent(t *testing.T) {
+	s := newShardedStore(8, newSequentialIDs())
+	book := Book{Title: strings.Repeat("a", 1000)}
+	limit := 20*bookSize(book) + 100
+	s.SetMaxBytes(limit)
+
+	var wg sync.WaitGroup
+	var stored atomic.Int64
+	for range 16 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for range 10 {
+				_, err := s.Create(book)
+				switch {
+				case err == nil:
+					stored.Add(1)
+				case !errors.Is(err, ErrStoreFull):
+					t.Error(err)
+				}
+			}
+		}()
+	}
+	wg.Wait()
+
+	if got := s.Bytes(); got > limit {
+		t.Errorf("store holds %d bytes, past its limit of %d", got, limit)
+	}
+	if stored.Load() != 20 || s.Count() != 20 {
+		t.Errorf("%d books stored, %d counted, want the 20 that fit", stored.Load(), s.Count())
+	}
+	if violations := s.Verify(); len(violations) > 0 {
+		t.Errorf("Verify: %v", violations)
+	}
+}
@@ -0,0 +1,95 @@
+package booksapi
+
+import (
+	"context"
+	"fmt"
+)
+
+// undoStore is the view of a store that transactWithUndo hands to a
+// transaction, recording how to undo each write made through it.
+type undoStore struct {
+	BookStore
+	undo []func() error
+}
+
+// transactWithUndo implements BookStore.Transact for s by running fn on a
+// view of s that records how to undo each write, and undoing the writes
+// newest first if fn fails. The writes and their undoing go through s as
+// single writes do, so wrappers that cannot defer their own effects, such
+// as a journal, use it to see both. Nothing isolates the transaction from
+// readers, which may see it half done; callers hold mu against writers.
+func transactWithUndo(ctx context.Context, s BookStore, fn func(tx BookStore) error) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	tx := &undoStore{BookStore: s}
+	err := fn(tx)
+	if err == nil {
+		err = ctx.Err()
+	}
+	if err != nil {
+		if undoErr := tx.rollback(); undoErr != nil {
+			return fmt.Errorf("undoing a failed transaction (%v): %w", err, undoErr)
+		}
+	}
+	return err
+}
+
+// Unwrap returns the store the transaction writes to.
+func (s *undoStore) Unwrap() BookStore { return s.BookStore }
+
+func (s *undoStore) Create(book Book) (Book, error) {
+	book, err := s.BookStore.Create(book)
+	if err == nil {
+		id := book.ID
+		s.undo = append(s.undo, func() error { return s.BookStore.Delete(id) })
+	}
+	return book, err
+}
+
+func (s *undoStore) Put(book Book) error {
+	prev, found := s.BookStore.Get(book.ID)
+	err := s.BookStore.Put(book)
+	if err == nil {
+		s.undo = append(s.undo, s.restorer(book.ID, prev, found))
+	}
+	return err
+}
+
+func (s *undoStore) Delete(id int) error {
+	prev, found := s.BookStore.Get(id)
+	err := s.BookStore.Delete(id)
+	if err == nil && found {
+		s.undo = append(s.undo, s.restorer(id, prev, found))
+	}
+	return err
+}
+
+// Transact runs a nested transaction, whose writes are undone on its own
+// failure as well as on the failure of the enclosing one.
+func (s *undoStore) Transact(ctx context.Context, fn func(tx BookStore) error) error {
+	return transactWithUndo(ctx, s, fn)
+}
+
+// restorer returns the undoing of a write to the book with the given ID,
+// which was prev before it, or absent unless found.
+func (s *undoStore) restorer(id int, prev Book, found bool) func() error {
+	if !found {
+		return func() error { return s.BookStore.Delete(id) }
+	}
+	return func() error { return s.BookStore.Put(prev) }
+}
+
+// rollback undoes the recorded writes, newest first, carrying on past
+// failures and returning the first. IDs handed out by undone creates are
+// not reused, as Reserve is never undone.
+func (s *undoStore) rollback() error {
+	var firstErr error
+	for i := len(s.undo) - 1; i >= 0; i-- {
+		if err := s.undo[i](); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	s.undo = nil
+	return firstErr
+}
@@ -0,0 +1,190 @@
+package booksapi
+
+import (
+	"math/rand/v2"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// stressWorkers is the number of goroutines sending TestStress requests.
+const stressWorkers = 8
+
+// stressOp is one kind of request a stress worker sends.
+type stressOp struct {
+	name string
+	run  func(c *selfCheck, rng *rand.Rand) (*http.Response, error)
+}
+
+// stressOps are the requests of a stress run, one picked at random each
+// time, weighted by how often each appears.
+var stressOps = []stressOp{
+	{"create", stressCreate},
+	{"create", stressCreate},
+	{"get", func(c *selfCheck, rng *rand.Rand) (*http.Response, error) {
+		return c.do(http.MethodGet, "/books/"+strconv.Itoa(stressID(rng)), nil, nil, nil)
+	}},
+	{"update", func(c *selfCheck, rng *rand.Rand) (*http.Response, error) {
+		// The ID in the body differs from the one in the path at times, which
+		// must not move the book.
+		body := stressBook(rng)
+		body["id"] = stressID(rng)
+		return c.do(http.MethodPut, "/books/"+strconv.Itoa(stressID(rng)), http.Header{"If-Match": {"*"}}, body, nil)
+	}},
+	{"patch", func(c *selfCheck, rng *rand.Rand) (*http.Response, error) {
+		body := map[string]any{"price": float64(rng.IntN(5000)) / 100, "description": stressWords(rng, 8)}
+		return c.do(http.MethodPatch, "/books/"+strconv.Itoa(stressID(rng)), http.Header{"If-Match": {"*"}}, body, nil)
+	}},
+	{"delete", func(c *selfCheck, rng *rand.Rand) (*http.Response, error) {
+		return c.do(http.MethodDelete, "/books/"+strconv.Itoa(stressID(rng)), http.Header{"If-Match": {"*"}}, nil, nil)
+	}},
+	{"list", func(c *selfCheck, rng *rand.Rand) (*http.Response, error) {
+		query := url.Values{"sort": {"price"}, "max_price": {strconv.Itoa(rng.IntN(50))}}
+		return c.do(http.MethodGet, "/books?"+query.Encode(), nil, nil, nil)
+	}},
+	{"search", func(c *selfCheck, rng *rand.Rand) (*http.Response, error) {
+		query := url.Values{"q": {stressWords(rng, 1)}, "in": {"description"}}
+		return c.do(http.MethodGet, "/books/search?"+query.Encode(), nil, nil, nil)
+	}},
+	{"transaction", func(c *selfCheck, rng *rand.Rand) (*http.Response, error) {
+		ops := []map[string]any{
+			{"op": "create", "book": stressBook(rng)},
+			{"op": "update", "ref": 0, "book": stressBook(rng)},
+			{"op": "delete", "id": stressID(rng)},
+		}
+		if rng.IntN(2) == 0 {
+			// Fails, rolling back the operations before it.
+			ops = append(ops, map[string]any{"op": "update", "id": stressID(rng), "book": map[string]any{}})
+		}
+		return c.do(http.MethodPost, "/books/transactions", nil, map[string]any{"operations": ops}, nil)
+	}},
+	{"export", func(c *selfCheck, rng *rand.Rand) (*http.Response, error) {
+		return c.do(http.MethodGet, "/books/export", nil, nil, nil)
+	}},
+}
+
+// stressIDs is the highest ID known to be handed out, bounding the IDs
+// stress requests name so that the workers often act on the same books.
+var stressIDs atomic.Int64
+
+// stressCreate creates a random book, raising stressIDs to its ID.
+func stressCreate(c *selfCheck, rng *rand.Rand) (*http.Response, error) {
+	var book selfCheckBook
+	resp, err := c.do(http.MethodPost, "/books", nil, stressBook(rng), &book)
+	if err == nil && resp.StatusCode == http.StatusCreated {
+		for {
+			top := stressIDs.Load()
+			if int64(book.ID) <= top || stressIDs.CompareAndSwap(top, int64(book.ID)) {
+				break
+			}
+		}
+	}
+	return resp, err
+}
+
+// stressID returns a random ID up to one past stressIDs, of a book that may
+// or may not exist.
+func stressID(rng *rand.Rand) int {
+	return 1 + rng.IntN(int(stressIDs.Load())+1)
+}
+
+// stressBook returns the body of a random valid book.
+func stressBook(rng *rand.Rand) map[string]any {
+	return map[string]any{
+		"title":  stressWords(rng, 3),
+		"author": stressWords(rng, 2),
+		"price":  float64(rng.IntN(5000)) / 100,
+	}
+}
+
+// stressWords returns n words drawn from a small vocabulary, so searches
+// find some of the books.
+func stressWords(rng *rand.Rand, n int) string {
+	vocabulary := []string{"river", "night", "glass", "garden", "winter", "iron", "song", "map", "salt", "lantern"}
+	s := vocabulary[rng.IntN(len(vocabulary))]
+	for range n - 1 {
+		s += " " + vocabulary[rng.IntN(len(vocabulary))]
+	}
+	return s
+}
+
+// TestStress has stressWorkers goroutines send random creates, reads,
+// updates, patches, deletes, listings, searches, transactions, and exports
+// to a test server for $BOOKS_STRESS_DURATION (2s by default), then checks
+// the invariants GET /admin/verify does. Worker i draws its requests from a
+// generator seeded with the logged seed and i; setting $BOOKS_STRESS_SEED
+// to it replays the same requests, if not their interleaving. Run with
+// -race to have data races fail it too:
+//
+//	BOOKS_STRESS_DURATION=1m go test ./booksapi -race -run Stress -v
+func TestStress(t *testing.T) {
+	d := 2 * time.Second
+	if s := os.Getenv("BOOKS_STRESS_DURATION"); s != "" {
+		var err error
+		if d, err = time.ParseDuration(s); err != nil {
+			t.Fatalf("BOOKS_STRESS_DURATION: %v", err)
+		}
+	}
+	if testing.Short() {
+		d = 200 * time.Millisecond
+	}
+	seed := rand.Uint64()
+	if s := os.Getenv("BOOKS_STRESS_SEED"); s != "" {
+		var err error
+		if seed, err = strconv.ParseUint(s, 10, 64); err != nil {
+			t.Fatalf("BOOKS_STRESS_SEED: %v", err)
+		}
+	}
+	t.Logf("%d workers for %v, seed %d", stressWorkers, d, seed)
+
+	server := httptest.NewServer(newTestServer(t))
+	defer server.Close()
+	stressIDs.Store(int64(store.NextID()))
+	var token string
+	for _, t := range currentConfig().tokens {
+		if t.grants(scopeWrite) {
+			token = t.secret
+			break
+		}
+	}
+
+	deadline := time.Now().Add(d)
+	var requests, failures atomic.Int64
+	var wg sync.WaitGroup
+	for i := range stressWorkers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			c := &selfCheck{base: server.URL + basePath, token: token, client: &http.Client{Timeout: 10 * time.Second}}
+			rng := rand.New(rand.NewPCG(seed, uint64(i)))
+			for time.Now().Before(deadline) {
+				op := stressOps[rng.IntN(len(stressOps))]
+				resp, err := op.run(c, rng)
+				requests.Add(1)
+				if err == nil && resp.StatusCode >= 500 {
+					err = wantStatus(resp, http.StatusOK)
+				}
+				if err != nil {
+					failures.Add(1)
+					t.Errorf("worker %d: %s: %v (seed %d)", i, op.name, err, seed)
+				}
+			}
+		}()
+	}
+	wg.Wait()
+
+	mu.Lock()
+	violations := verifyCatalog()
+	books := store.Count()
+	mu.Unlock()
+	for _, v := range violations {
+		t.Errorf("violation: %s (seed %d)", v, seed)
+	}
+	t.Logf("%d requests, %d failed, %d books, %d violations", requests.Load(), failures.Load(), books, len(violations))
+}
@@ -0,0 +1,373 @@
+package booksapi
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"time"
+)
+
+// maxTransactionOps caps the number of operations in a transaction. It is
+// configured by a flag in Main.
+var maxTransactionOps = 100
+
+// txOperation is one operation of POST /books/transactions. Update and
+// delete operations name their book by ID, or by Ref, the index of an
+// earlier create operation whose new book they act on.
+type txOperation struct {
+	Op   string          `json:"op"` // create, update, or delete
+	ID   int             `json:"id,omitempty"`
+	Ref  *int            `json:"ref,omitempty"`
+	Book json.RawMessage `json:"book,omitempty"`
+}
+
+// txResult reports the outcome of one operation.
+type txResult struct {
+	Op     string `json:"op"`
+	ID     int    `json:"id"`
+	Status int    `json:"status"`
+}
+
+// Outcomes of POST /books/transactions.
+const (
+	txCompleted = "completed" // every operation was applied
+	txPartial   = "partial"   // the operations before stopped_at were applied and kept
+)
+
+// Reasons a non-atomic transaction stopped early.
+const (
+	txStoppedTimeout  = "timeout"  // the request ran out of time
+	txStoppedCanceled = "canceled" // the client went away
+	txStoppedFailed   = "failed"   // the operation at stopped_at failed
+)
+
+// transactionResult is the response body of POST /books/transactions.
+// Results are in operation order, from the one a continuation token resumed
+// at, if any.
+type transactionResult struct {
+	Outcome   string       `json:"outcome"`
+	ResumedAt *int         `json:"resumed_at,omitempty"` // index of the operation of the first result
+	Results   []txResult   `json:"results"`
+	StoppedAt *int         `json:"stopped_at,omitempty"` // index of the first operation not applied
+	Reason    string       `json:"reason,omitempty"`
+	Error     *errorDetail `json:"error,omitempty"`    // of the failed operation
+	Continue  string       `json:"continue,omitempty"` // token resuming at stopped_at
+}
+
+// transactionParams declares the query parameters of
+// POST /books/transactions.
+var transactionParams = []queryParam{
+	{name: "atomic", kind: boolParam},
+	{name: "continue", kind: stringParam},
+}
+
+// txContinuation is the state of a transaction that stopped early, handed
+// to the client as an opaque token to resume it with. Digest identifies the
+// operations, which must be sent again unchanged. Only the IDs of the
+// created books that the remaining operations refer to are kept, so the
+// token stays small however far the transaction got.
+type txContinuation struct {
+	Digest  string      `json:"h"`
+	Next    int         `json:"n"`           // index of the first operation not applied
+	Created map[int]int `json:"c,omitempty"` // index of a create operation -> ID of its book
+}
+
+// applyTransaction applies a list of book operations as a unit
+// (POST /books/transactions). Operations run in order, each seeing the
+// effects of the ones before it, within a store transaction: if any fails,
+// the ones already applied are undone and the error is reported with the
+// index of the failing operation. The batch holds mu throughout, so other
+// writers never see it half done.
+//
+// The request's context is checked before each operation. When it runs out
+// of time the transaction is rolled back and answered with 504
+// batch_timeout. With ?atomic=false each operation is kept as soon as it is
+// applied instead, and a transaction stopping at its deadline, or at a
+// failing operation, answers 207 with the results so far, where it stopped
+// and why, and a token that ?continue= takes, with the same operations, to
+// resume there.
+func applyTransaction(w http.ResponseWriter, r *http.Request) {
+	q, ok := checkQuery(w, r, transactionParams...)
+	if !ok {
+		return
+	}
+	atomic := !q.Has("atomic") || q.Bool("atomic")
+
+	var req struct {
+		Operations []txOperation `json:"operations"`
+	}
+	if err := decodeJSON(w, r, &req); err != nil {
+		writeAPIError(w, r, err)
+		return
+	}
+	if len(req.Operations) == 0 {
+		writeError(w, r, http.StatusBadRequest, "invalid_request")
+		return
+	}
+	if len(req.Operations) > maxTransactionOps {
+		writeError(w, r, http.StatusRequestEntityTooLarge, "too_many_operations", "max", maxTransactionOps)
+		return
+	}
+	strict := requestHandling(r) == handlingStrict
+	digest := operationsDigest(req.Operations)
+	// results holds an entry for every operation applied, as Ref counts
+	// them; those of the requests a token resumes from are left empty,
+	// but for the creates that later operations refer to.
+	results := make([]txResult, 0, len(req.Operations))
+	resumedAt := 0
+	if q.Has("continue") {
+		c, err := decodeTxContinuation(q.String("continue"), digest, len(req.Operations))
+		if err != nil {
+			writeAPIError(w, r, err)
+			return
+		}
+		resumedAt = c.Next
+		results = results[:resumedAt]
+		for i, id := range c.Created {
+			results[i] = txResult{Op: "create", ID: id, Status: http.StatusCreated}
+		}
+	}
+	answerDeadline(r)
+
+	mu.Lock()
+	defer mu.Unlock()
+
+	ctx := r.Context()
+	tx := &bookTx{request: r, lockToken: r.Header.Get(lockTokenHeader), caller: callerToken(r)}
+	outcome := transactionResult{Outcome: txCompleted}
+	if atomic {
+		applied := results
+		err := store.Transact(ctx, func(storeTx BookStore) error {
+			tx.store, tx.deleted = storeTx, nil
+			for i := len(applied); i < len(req.Operations); i++ {
+				if ctx.Err() != nil {
+					return batchRolledBack(ctx, i, len(req.Operations))
+				}
+				result, err := tx.apply(req.Operations[i], applied, strict)
+				if err != nil {
+					return withIndex(err, i)
+				}
+				applied = append(applied, result)
+			}
+			return nil
+		})
+		if err != nil && ctx.Err() != nil && errors.Is(err, ctx.Err()) {
+			err = batchRolledBack(ctx, len(applied), len(req.Operations))
+		}
+		if err != nil {
+			writeAPIError(w, r, err)
+			return
+		}
+		results = applied
+	} else {
+		tx.store = store
+		for i := len(results); i < len(req.Operations); i++ {
+			if err := ctx.Err(); err != nil {
+				outcome.Reason = txStoppedCanceled
+				if errors.Is(err, context.DeadlineExceeded) {
+					outcome.Reason = txStoppedTimeout
+				}
+				break
+			}
+			result, err := tx.apply(req.Operations[i], results, strict)
+			if err != nil {
+				detail := newErrorDetail(asAPIError(withIndex(err, i)), requestLocale(r))
+				outcome.Reason, outcome.Error = txStoppedFailed, &detail
+				break
+			}
+			results = append(results, result)
+		}
+	}
+
+	// Related data of deleted books is only dropped once the batch can no
+	// longer be undone.
+	for _, id := range tx.deleted {
+		removeBookFromShelves(id)
+		removeBookFromFavorites(id)
+		removeCover(id)
+		removeDescription(id)
+		removeBookLock(id)
+	}
+	outcome.Results = results[resumedAt:]
+	if resumedAt > 0 {
+		outcome.ResumedAt = &resumedAt
+	}
+	if outcome.Reason == "" {
+		writeJSON(w, http.StatusOK, outcome)
+		return
+	}
+	stoppedAt := len(results)
+	outcome.Outcome, outcome.StoppedAt = txPartial, &stoppedAt
+	outcome.Continue = newTxContinuation(digest, req.Operations, results).encode()
+	writeJSON(w, http.StatusMultiStatus, outcome)
+}
+
+// batchRolledBack returns the error reporting a batch whose context was
+// done after completed of its total items, and whose changes were rolled
+// back: 504 batch_timeout at its deadline, or the context's error if the
+// client went away.
+func batchRolledBack(ctx context.Context, completed, total int) error {
+	if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+		return newAPIError(http.StatusGatewayTimeout, "batch_timeout", "completed", completed, "total", total)
+	}
+	return ctx.Err()
+}
+
+// operationsDigest identifies the operations of a transaction in its
+// continuation tokens.
+func operationsDigest(ops []txOperation) string {
+	data, _ := json.Marshal(ops)
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:16])
+}
+
+// newTxContinuation returns the continuation of the transaction of ops
+// with the given digest, stopped after the operations of results.
+func newTxContinuation(digest string, ops []txOperation, results []txResult) txContinuation {
+	c := txContinuation{Digest: digest, Next: len(results)}
+	for _, op := range ops[c.Next:] {
+		if op.Ref != nil && *op.Ref >= 0 && *op.Ref < c.Next && results[*op.Ref].Op == "create" {
+			if c.Created == nil {
+				c.Created = make(map[int]int)
+			}
+			c.Created[*op.Ref] = results[*op.Ref].ID
+		}
+	}
+	return c
+}
+
+// encode returns the opaque form of the continuation sent to clients.
+func (c txContinuation) encode() string {
+	data, _ := json.Marshal(c)
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+// decodeTxContinuation parses a continuation token sent by a client, which
+// must have been handed out for the n operations with the given digest.
+func decodeTxContinuation(s, digest string, n int) (txContinuation, error) {
+	var c txContinuation
+	data, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil || json.Unmarshal(data, &c) != nil || c.Digest != digest || c.Next < 0 || c.Next > n {
+		return txContinuation{}, newAPIError(http.StatusBadRequest, "invalid_continuation")
+	}
+	for i := range c.Created {
+		if i < 0 || i >= c.Next {
+			return txContinuation{}, newAPIError(http.StatusBadRequest, "invalid_continuation")
+		}
+	}
+	return c, nil
+}
+
+// bookTx applies the operations of a transaction through a store
+// transaction.
+type bookTx struct {
+	store     BookStore     // the store transaction
+	request   *http.Request // for warnings about the books sent
+	lockToken string        // sent with the request, for books with edit locks
+	caller    apiToken      // of the request, for fields hidden from it
+	deleted   []int
+}
+
+// apply performs one operation. results holds the outcomes of the earlier
+// operations, which Ref refers to. Callers must hold mu.
+func (tx *bookTx) apply(op txOperation, results []txResult, strict bool) (txResult, error) {
+	id := op.ID
+	if op.Ref != nil {
+		if *op.Ref < 0 || *op.Ref >= len(results) || results[*op.Ref].Op != "create" {
+			return txResult{}, newAPIError(http.StatusBadRequest, "invalid_operation_ref", "ref", *op.Ref)
+		}
+		id = results[*op.Ref].ID
+	}
+
+	switch op.Op {
+	case "create":
+		var book Book
+		if err := decodeTxBook(tx.request, op.Book, &book, strict); err != nil {
+			return txResult{}, err
+		}
+		if err := checkFieldWrites(tx.caller, Book{}, book); err != nil {
+			return txResult{}, err
+		}
+		book.ID = 0 // assigned by the store
+		now := time.Now().UTC()
+		book.CreatedAt, book.UpdatedAt = &now, &now
+		if err := checkTxBook(&book, ValidateCreate); err != nil {
+			return txResult{}, err
+		}
+		if err := checkQuota(1); err != nil {
+			return txResult{}, err
+		}
+		created, err := tx.store.Create(book)
+		if err != nil {
+			return txResult{}, err
+		}
+		return txResult{Op: op.Op, ID: created.ID, Status: http.StatusCreated}, nil
+
+	case "update":
+		prev, err := findBookIn(tx.store, id)
+		if err != nil {
+			return txResult{}, err
+		}
+		if err := checkBookLockToken(tx.lockToken, id); err != nil {
+			return txResult{}, err
+		}
+		book := cloneBook(prev)
+		if err := decodeTxBook(tx.request, op.Book, &book, strict); err != nil {
+			return txResult{}, err
+		}
+		if err := checkFieldWrites(tx.caller, prev, book); err != nil {
+			return txResult{}, err
+		}
+		book.ID = id
+		touchBook(&book, prev.CreatedAt)
+		if err := checkTxBook(&book, ValidateUpdate); err != nil {
+			return txResult{}, err
+		}
+		if err := tx.store.Put(book); err != nil {
+			return txResult{}, err
+		}
+		return txResult{Op: op.Op, ID: id, Status: http.StatusOK}, nil
+
+	case "delete":
+		if _, err := findBookIn(tx.store, id); err != nil {
+			return txResult{}, err
+		}
+		if err := checkBookLockToken(tx.lockToken, id); err != nil {
+			return txResult{}, err
+		}
+		if err := tx.store.Delete(id); err != nil {
+			return txResult{}, err
+		}
+		tx.deleted = append(tx.deleted, id)
+		return txResult{Op: op.Op, ID: id, Status: http.StatusNoContent}, nil
+	}
+	return txResult{}, newAPIError(http.StatusBadRequest, "invalid_operation", "op", op.Op)
+}
+
+// decodeTxBook decodes the book of an operation of r over book, leaving
+// fields absent from it unchanged.
+func decodeTxBook(r *http.Request, data json.RawMessage, book *Book, strict bool) error {
+	if len(data) == 0 {
+		return newAPIError(http.StatusBadRequest, "invalid_request")
+	}
+	return decodeBody(r, data, book, strict)
+}
+
+// checkTxBook validates a book written by an operation against the catalog
+// as the transaction has left it so far. Callers must hold mu.
+func checkTxBook(book *Book, mode ValidationMode) error {
+	if err := validateBook(book, mode); err != nil {
+		return err
+	}
+	if err := checkReferences(*book); err != nil {
+		return err
+	}
+	if err := checkSeriesIndex(*book); err != nil {
+		return err
+	}
+	return checkEditionISBNs(*book)
+}
@@ -0,0 +1,174 @@
+package booksapi
+
+import (
+	"encoding/json"
+	"net/http"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+// failingTransaction creates, updates, and deletes books, then fails on an
+// update of a missing book.
+var failingTransaction = map[string]any{"operations": []map[string]any{
+	{"op": "create", "book": map[string]any{"title": "Persuasion", "author": "Jane Austen"}},
+	{"op": "update", "id": 1, "book": map[string]any{"title": "Dune Messiah", "price": 12}},
+	{"op": "delete", "id": 2},
+	{"op": "update", "ref": 0, "book": map[string]any{"price": 3}},
+	{"op": "update", "id": 999, "book": map[string]any{"title": "Missing"}},
+}}
+
+// catalogJSON returns the books of the store as JSON, to compare the
+// catalog before and after a change.
+func catalogJSON(t *testing.T, s BookStore) string {
+	t.Helper()
+	data, err := json.Marshal(s.List(ListOptions{}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(data)
+}
+
+// TestTransactionRollback checks that a transaction failing part way
+// leaves every store as it was.
+func TestTransactionRollback(t *testing.T) {
+	for name, newStore := range storeFactories {
+		t.Run(name, func(t *testing.T) {
+			h := newTestServer(t, WithStore(newStore(t)))
+			mustCreateBook(t, h, map[string]any{"title": "Dune", "price": 10})
+			mustCreateBook(t, h, map[string]any{"title": "Emma", "price": 5})
+			before := catalogJSON(t, store)
+			nextID := store.NextID()
+
+			rec := serve(t, h, http.MethodPost, "/books/transactions", failingTransaction)
+			wantCode(t, rec, http.StatusNotFound)
+			if got := decode[errorBody](t, rec).Error.Params["index"]; got != "4" {
+				t.Errorf("transaction failed at operation %q, want the last, 4", got)
+			}
+			if got := catalogJSON(t, store); got != before {
+				t.Errorf("catalog after a failed transaction:\n%s\nwant\n%s", got, before)
+			}
+			if got := serve(t, h, http.MethodGet, "/books/2", nil); got.Code != http.StatusOK {
+				t.Errorf("GET /books/2 after a failed transaction deleting it: status %d", got.Code)
+			}
+			if book := mustCreateBook(t, h, map[string]any{"title": "Beloved"}); book.ID < nextID {
+				t.Errorf("created book %d after a failed transaction, want at least %d", book.ID, nextID)
+			}
+		})
+	}
+}
+
+// TestSQLiteTransactionRollback checks that a transaction failing part way
+// writes nothing to the database.
+func TestSQLiteTransactionRollback(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "books.db")
+	db := openTestSQLite(t, path)
+	h := newTestServer(t, WithStore(db))
+	mustCreateBook(t, h, map[string]any{"title": "Dune", "price": 10})
+	mustCreateBook(t, h, map[string]any{"title": "Emma", "price": 5})
+	before := catalogJSON(t, store)
+
+	wantCode(t, serve(t, h, http.MethodPost, "/books/transactions", failingTransaction), http.StatusNotFound)
+	if err := db.Close(); err != nil {
+		t.Fatal(err)
+	}
+	reopened := openTestSQLite(t, path)
+	defer reopened.Close()
+	if got := catalogJSON(t, reopened); got != before {
+		t.Errorf("database after a failed transaction:\n%s\nwant\n%s", got, before)
+	}
+}
+
+// TestTransaction applies a mixed batch, whose operations refer to a book
+// created earlier in it, and checks the results and the catalog.
+func TestTransaction(t *testing.T) {
+	h := newTestServer(t)
+	mustCreateBook(t, h, map[string]any{"title": "Dune", "price": 10})
+	mustCreateBook(t, h, map[string]any{"title": "Emma", "price": 5})
+	shelf := serve(t, h, http.MethodPost, "/shelves", map[string]any{"name": "Classics"})
+	wantCode(t, shelf, http.StatusCreated)
+	wantCode(t, serve(t, h, http.MethodPut, "/shelves/1/books/2", nil), http.StatusNoContent)
+
+	rec := serve(t, h, http.MethodPost, "/books/transactions", map[string]any{"operations": []map[string]any{
+		{"op": "create", "book": map[string]any{"title": "Persuasion", "author": "Jane Austen"}},
+		{"op": "create", "book": map[string]any{"title": "Beloved"}},
+		{"op": "update", "ref": 0, "book": map[string]any{"price": 3}},
+		{"op": "update", "id": 1, "book": map[string]any{"title": "Dune Messiah"}},
+		{"op": "delete", "id": 2},
+		{"op": "delete", "ref": 1},
+	}})
+	wantCode(t, rec, http.StatusOK)
+	got := decode[transactionResult](t, rec)
+	want := []txResult{
+		{Op: "create", ID: 3, Status: http.StatusCreated},
+		{Op: "create", ID: 4, Status: http.StatusCreated},
+		{Op: "update", ID: 3, Status: http.StatusOK},
+		{Op: "update", ID: 1, Status: http.StatusOK},
+		{Op: "delete", ID: 2, Status: http.StatusNoContent},
+		{Op: "delete", ID: 4, Status: http.StatusNoContent},
+	}
+	if got.Outcome != txCompleted || !reflect.DeepEqual(got.Results, want) {
+		t.Errorf("transaction %+v, want completed with %+v", got, want)
+	}
+
+	books := decode[[]Book](t, serve(t, h, http.MethodGet, "/books", nil))
+	if len(books) != 2 || books[0].Title != "Dune Messiah" || books[0].Price != 10 ||
+		books[1].Title != "Persuasion" || books[1].Author != "Jane Austen" || books[1].Price != 3 {
+		t.Errorf("catalog after the transaction: %+v", books)
+	}
+	if members := decode[[]Book](t, serve(t, h, http.MethodGet, "/shelves/1/books", nil)); len(members) != 0 {
+		t.Errorf("shelf still holds %+v after its book was deleted", members)
+	}
+}
+
+// TestTransactionErrors checks the transactions refused as a whole, and
+// that a failing operation is named by its index and undoes the others.
+func TestTransactionErrors(t *testing.T) {
+	create := map[string]any{"op": "create", "book": map[string]any{"title": "Persuasion"}}
+	tests := []struct {
+		name       string
+		operations []map[string]any
+		status     int
+		code       string
+		index      string // of the failing operation, if any
+	}{
+		{"no operations", nil, http.StatusBadRequest, "invalid_request", ""},
+		{"too many", []map[string]any{create, create, create, create}, http.StatusRequestEntityTooLarge, "too_many_operations", ""},
+		{"unknown op", []map[string]any{create, {"op": "upsert", "id": 1}}, http.StatusBadRequest, "invalid_operation", "1"},
+		{"ref to a later op", []map[string]any{{"op": "update", "ref": 1, "book": map[string]any{"price": 1}}, create}, http.StatusBadRequest, "invalid_operation_ref", "0"},
+		{"ref to an update", []map[string]any{
+			{"op": "update", "id": 1, "book": map[string]any{"price": 1}},
+			{"op": "delete", "ref": 0},
+		}, http.StatusBadRequest, "invalid_operation_ref", "1"},
+		{"invalid book on the third", []map[string]any{
+			create,
+			{"op": "delete", "id": 1},
+			{"op": "update", "id": 2, "book": map[string]any{"title": ""}},
+		}, http.StatusUnprocessableEntity, "field_required", "2"},
+		{"book deleted before the third", []map[string]any{
+			create,
+			{"op": "delete", "id": 2},
+			{"op": "update", "id": 2, "book": map[string]any{"price": 1}},
+		}, http.StatusNotFound, "book_not_found", "2"},
+		{"create without a book", []map[string]any{{"op": "create"}}, http.StatusBadRequest, "invalid_request", "0"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := newTestServer(t)
+			setForTest(t, &maxTransactionOps, 3)
+			mustCreateBook(t, h, map[string]any{"title": "Dune", "price": 10})
+			mustCreateBook(t, h, map[string]any{"title": "Emma", "price": 5})
+			before := catalogJSON(t, store)
+
+			rec := serve(t, h, http.MethodPost, "/books/transactions", map[string]any{"operations": tt.operations})
+			wantCode(t, rec, tt.status)
+			got := decode[errorBody](t, rec).Error
+			if got.Code != tt.code || got.Params["index"] != tt.index {
+				t.Errorf("error %s at index %q, want %s at %q", got.Code, got.Params["index"], tt.code, tt.index)
+			}
+			if after := catalogJSON(t, store); after != before {
+				t.Errorf("catalog after a failed transaction:\n%s\nwant\n%s", after, before)
+			}
+		})
+	}
+}
@@ -0,0 +1,225 @@
+package booksapi
+
+import (
+	"context"
+	"io"
+	"sync"
+	"time"
+)
+
+// TTL mode settings, configured by flags in Main. A bookTTL of zero keeps
+// books forever.
+var (
+	bookTTL       time.Duration
+	ttlSweepEvery = time.Minute
+)
+
+// ttlStore expires books a fixed time after they were last written. Expired
+// books are hidden from every read at once and removed, together with the
+// data kept about them, by a sweeper running every sweep interval.
+type ttlStore struct {
+	inner BookStore
+	ttl   time.Duration
+	now   func() time.Time // the clock, replaceable for tests
+
+	mu      sync.Mutex
+	written map[int]time.Time // book ID -> time of its last write
+
+	stop chan struct{}
+	done chan struct{}
+}
+
+// openTTLStore wraps inner so its books expire after ttl, sweeping expired
+// books every sweep interval until it is closed. Books already in inner
+// count as written now.
+func openTTLStore(inner BookStore, ttl, sweep time.Duration) *ttlStore {
+	s := &ttlStore{
+		inner:   inner,
+		ttl:     ttl,
+		now:     time.Now,
+		written: make(map[int]time.Time),
+		stop:    make(chan struct{}),
+		done:    make(chan struct{}),
+	}
+	now := s.now()
+	for _, book := range inner.List(ListOptions{}) {
+		s.written[book.ID] = now
+	}
+	go s.run(sweep)
+	return s
+}
+
+// run sweeps expired books every interval until the store is closed.
+func (s *ttlStore) run(interval time.Duration) {
+	defer close(s.done)
+	if interval <= 0 {
+		<-s.stop
+		return
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			s.sweep()
+		case <-s.stop:
+			return
+		}
+	}
+}
+
+// sweep removes the expired books and the data kept about them, returning
+// the number removed.
+func (s *ttlStore) sweep() int {
+	mu.Lock()
+	defer mu.Unlock()
+
+	s.mu.Lock()
+	var expired []int
+	for id := range s.written {
+		if s.expired(id) {
+			expired = append(expired, id)
+		}
+	}
+	s.mu.Unlock()
+
+	for _, id := range expired {
+		if err := s.Delete(id); err != nil {
+			continue
+		}
+		removeBookFromShelves(id)
+		removeBookFromFavorites(id)
+		removeCover(id)
+		removeDescription(id)
+		removeBookLock(id)
+	}
+	if len(expired) > 0 {
+		invalidateResponseCache()
+	}
+	return len(expired)
+}
+
+// expired reports whether the book with the given ID has outlived the TTL.
+// Books the store has not seen written never expire. Callers must hold
+// s.mu.
+func (s *ttlStore) expired(id int) bool {
+	written, ok := s.written[id]
+	return ok && !s.now().Before(written.Add(s.ttl))
+}
+
+// untilNextExpiry returns how long until the first of the books not yet
+// expired expires, or false if there are none.
+func (s *ttlStore) untilNextExpiry() (time.Duration, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := s.now()
+	var next time.Time
+	for id, written := range s.written {
+		if expires := written.Add(s.ttl); !s.expired(id) && (next.IsZero() || expires.Before(next)) {
+			next = expires
+		}
+	}
+	if next.IsZero() {
+		return 0, false
+	}
+	return next.Sub(now), true
+}
+
+// touch records a write of the book with the given ID.
+func (s *ttlStore) touch(id int) {
+	s.mu.Lock()
+	s.written[id] = s.now()
+	s.mu.Unlock()
+}
+
+// Unwrap returns the wrapped store.
+func (s *ttlStore) Unwrap() BookStore { return s.inner }
+
+func (s *ttlStore) Get(id int) (Book, bool) {
+	book, ok := s.inner.Get(id)
+	if !ok {
+		return Book{}, false
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.expired(id) {
+		return Book{}, false
+	}
+	return book, true
+}
+
+// List selects from the books that have not expired, so options are
+// applied here rather than by the wrapped store.
+func (s *ttlStore) List(opts ListOptions) []Book {
+	list := s.inner.List(ListOptions{})
+	s.mu.Lock()
+	live := list[:0]
+	for _, book := range list {
+		if !s.expired(book.ID) {
+			live = append(live, book)
+		}
+	}
+	s.mu.Unlock()
+	return opts.apply(live)
+}
+
+func (s *ttlStore) Count() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	expired := 0
+	for id := range s.written {
+		if s.expired(id) {
+			expired++
+		}
+	}
+	return s.inner.Count() - expired
+}
+
+func (s *ttlStore) Create(book Book) (Book, error) {
+	book, err := s.inner.Create(book)
+	if err == nil {
+		s.touch(book.ID)
+	}
+	return book, err
+}
+
+func (s *ttlStore) Put(book Book) error {
+	err := s.inner.Put(book)
+	if err == nil {
+		s.touch(book.ID)
+	}
+	return err
+}
+
+func (s *ttlStore) Delete(id int) error {
+	err := s.inner.Delete(id)
+	if err == nil {
+		s.mu.Lock()
+		delete(s.written, id)
+		s.mu.Unlock()
+	}
+	return err
+}
+
+func (s *ttlStore) NextID() int { return s.inner.NextID() }
+
+func (s *ttlStore) Reserve(id int) error { return s.inner.Reserve(id) }
+
+// Transact runs fn as a transaction whose writes, and their undoing if fn
+// fails, go through the store one by one, so the write times stay in step.
+func (s *ttlStore) Transact(ctx context.Context, fn func(tx BookStore) error) error {
+	return transactWithUndo(ctx, s, fn)
+}
+
+// Close stops the sweeper and closes the wrapped store if it needs closing.
+func (s *ttlStore) Close() error {
+	close(s.stop)
+	<-s.done
+	if closer, ok := s.inner.(io.Closer); ok {
+		return closer.Close()
+	}
+	return nil
+}
@@ -0,0 +1,154 @@
+package booksapi
+
+import (
+	"net/http"
+	"slices"
+	"sync"
+	"testing"
+	"time"
+)
+
+// testClock is a clock that moves only when told to.
+type testClock struct {
+	mu  sync.Mutex
+	now time.Time
+}
+
+func (c *testClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+func (c *testClock) Advance(d time.Duration) {
+	c.mu.Lock()
+	c.now = c.now.Add(d)
+	c.mu.Unlock()
+}
+
+// openTestTTLStore opens a TTL store over a memory store, sweeping every
+// sweep interval, with its clock replaced by the returned one.
+func openTestTTLStore(t *testing.T, ttl, sweep time.Duration) (*ttlStore, *testClock) {
+	t.Helper()
+	clock := &testClock{now: time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)}
+	s := openTTLStore(newMemoryStore(newSequentialIDs()), ttl, sweep)
+	// The sweeper reads the clock holding s.mu.
+	s.mu.Lock()
+	s.now = clock.Now
+	s.mu.Unlock()
+	t.Cleanup(func() { s.Close() })
+	return s, clock
+}
+
+// TestTTLExpiry checks that a book is hidden from every read exactly when
+// it expires, and that an update starts its TTL again.
+func TestTTLExpiry(t *testing.T) {
+	s, clock := openTestTTLStore(t, time.Hour, 0)
+	dune, err := s.Create(Book{Title: "Dune"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	clock.Advance(30 * time.Minute)
+	emma, err := s.Create(Book{Title: "Emma"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	live := func(want ...int) {
+		t.Helper()
+		var ids []int
+		for _, book := range s.List(ListOptions{}) {
+			ids = append(ids, book.ID)
+		}
+		if !slices.Equal(ids, want) || s.Count() != len(want) {
+			t.Fatalf("listed %v, counted %d; want %v", ids, s.Count(), want)
+		}
+		for _, id := range []int{dune.ID, emma.ID} {
+			if _, found := s.Get(id); found != slices.Contains(want, id) {
+				t.Fatalf("Get(%d) found %v, want %v", id, found, !found)
+			}
+		}
+	}
+
+	clock.Advance(30*time.Minute - time.Nanosecond)
+	live(dune.ID, emma.ID)
+	clock.Advance(time.Nanosecond)
+	live(emma.ID)
+
+	// Updating Emma a minute before it expires gives it another hour.
+	clock.Advance(29 * time.Minute)
+	emma.Price = 5
+	if err := s.Put(emma); err != nil {
+		t.Fatal(err)
+	}
+	clock.Advance(time.Hour - time.Nanosecond)
+	live(emma.ID)
+	clock.Advance(time.Nanosecond)
+	live()
+
+	// The expired books are hidden, not removed, until they are swept.
+	if n := s.inner.Count(); n != 2 {
+		t.Errorf("%d books in the wrapped store before the sweep, want 2", n)
+	}
+}
+
+// TestTTLSweep checks that the sweeper removes expired books and what is
+// kept about them, and leaves the others.
+func TestTTLSweep(t *testing.T) {
+	s, clock := openTestTTLStore(t, time.Hour, 5*time.Millisecond)
+	h := newTestServer(t, WithStore(s))
+	mustCreateBook(t, h, map[string]any{"title": "Dune"})
+	wantCode(t, serve(t, h, http.MethodPost, "/shelves", map[string]any{"name": "Sci-fi"}), http.StatusCreated)
+	wantCode(t, serve(t, h, http.MethodPut, "/shelves/1/books/1", nil), http.StatusNoContent)
+	wantCode(t, serve(t, h, http.MethodPost, "/books/1/lock", map[string]any{"holder": "alice"}), http.StatusCreated)
+	clock.Advance(30 * time.Minute)
+	mustCreateBook(t, h, map[string]any{"title": "Emma"})
+
+	clock.Advance(30 * time.Minute)
+	deadline := time.Now().Add(5 * time.Second)
+	for s.inner.Count() != 1 {
+		if time.Now().After(deadline) {
+			t.Fatalf("%d books in the wrapped store, want the expired one swept", s.inner.Count())
+		}
+		time.Sleep(time.Millisecond)
+	}
+	if _, found := s.inner.Get(2); !found {
+		t.Error("sweeper removed a book that has not expired")
+	}
+	rec := serve(t, h, http.MethodGet, "/shelves/1/books", nil)
+	wantCode(t, rec, http.StatusOK)
+	if books := decode[[]Book](t, rec); len(books) != 0 {
+		t.Errorf("shelf still holds %v", books)
+	}
+	locksMu.Lock()
+	_, locked := bookLocks[1]
+	locksMu.Unlock()
+	if locked {
+		t.Error("the lock of a swept book was kept")
+	}
+}
+
+// TestTTLResponseCache checks that responses are cached no longer than
+// until the next book expires, so an expired book is not served from the
+// cache.
+func TestTTLResponseCache(t *testing.T) {
+	s, clock := openTestTTLStore(t, time.Hour, 0)
+	h := newTestServer(t, WithStore(s))
+	mustCreateBook(t, h, map[string]any{"title": "Dune"})
+	clock.Advance(time.Hour - 50*time.Millisecond)
+
+	for _, path := range []string{"/books", "/books/1"} {
+		wantCode(t, serve(t, h, http.MethodGet, path, nil), http.StatusOK)
+		if got := serve(t, h, http.MethodGet, path, nil).Header().Get("X-Cache"); got != "HIT" {
+			t.Errorf("second GET %s: X-Cache %q, want HIT", path, got)
+		}
+	}
+
+	clock.Advance(50 * time.Millisecond)
+	time.Sleep(60 * time.Millisecond)
+	rec := serve(t, h, http.MethodGet, "/books", nil)
+	if got := decode[[]Book](t, rec); len(got) != 0 || rec.Header().Get("X-Cache") != "MISS" {
+		t.Errorf("GET /books after the expiry: X-Cache %q, books %+v; want a miss without the book", rec.Header().Get("X-Cache"), got)
+	}
+	wantCode(t, serve(t, h, http.MethodGet, "/books/1", nil), http.StatusNotFound)
+}
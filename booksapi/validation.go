@@ -0,0 +1,211 @@
+package booksapi
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"golang.org/x/text/currency"
+	"golang.org/x/text/language"
+)
+
+// ValidationMode tells ValidateBook whether a book is being created or
+// written over an existing one.
+type ValidationMode int
+
+const (
+	ValidateCreate ValidationMode = iota // the store assigns the ID
+	ValidateUpdate                       // the book keeps its ID
+)
+
+// FieldError describes a field of a book breaking a validation rule. Rule is
+// a stable identifier; Message is the English message, which entry points
+// may translate from Rule and Params instead.
+type FieldError struct {
+	Field   string            `json:"field"`
+	Rule    string            `json:"rule"`
+	Message string            `json:"message"`
+	Params  map[string]string `json:"params,omitempty"`
+}
+
+// ruleCodes maps each validation rule to the message catalog code reporting
+// it.
+var ruleCodes = map[string]string{
+	"required":       "field_required",
+	"max_length":     "field_too_long",
+	"min":            "field_too_small",
+	"language_tag":   "invalid_language",
+	"currency_code":  "invalid_currency",
+	"isbn":           "invalid_isbn",
+	"price_range":    "price_out_of_range",
+	"precision":      "too_many_decimals",
+	"attribute":      "unknown_field",
+	"edition_format": "invalid_edition_format",
+	"unique":         "field_not_unique",
+}
+
+// bookRule checks one rule on a book, returning the errors it finds.
+type bookRule func(b Book, mode ValidationMode) []FieldError
+
+// bookRules are the rules ValidateBook checks, in the order their errors
+// are reported.
+var bookRules = []bookRule{
+	checkBookID,
+	checkTitle,
+	checkDescription,
+	checkPrice,
+	checkCostPrice,
+	checkLanguageTag,
+	checkCurrencyCode,
+	checkISBN,
+	checkEditions,
+	checkAttributes,
+	checkRequiredFields,
+}
+
+// ValidateBook checks b against every book rule, returning the errors found
+// or nil if it is valid. It does not change b; validateBook normalizes the
+// fields once they are valid.
+func ValidateBook(b Book, mode ValidationMode) []FieldError {
+	var errs []FieldError
+	for _, rule := range bookRules {
+		errs = append(errs, rule(b, mode)...)
+	}
+	return errs
+}
+
+// newFieldError returns a FieldError. params holds alternating names and
+// values, as for newAPIError, and always includes the field.
+func newFieldError(field, rule string, params ...any) FieldError {
+	e := FieldError{Field: field, Rule: rule, Params: map[string]string{"field": field}}
+	for i := 0; i+1 < len(params); i += 2 {
+		e.Params[fmt.Sprint(params[i])] = fmt.Sprint(params[i+1])
+	}
+	e.Message = translate(defaultLocale, ruleCodes[rule], e.Params)
+	return e
+}
+
+// checkBookID requires updated books to have an ID.
+func checkBookID(b Book, mode ValidationMode) []FieldError {
+	if mode == ValidateUpdate && b.ID < 1 {
+		return []FieldError{newFieldError("id", "min", "min", 1)}
+	}
+	return nil
+}
+
+// checkTitle requires a title that is not blank.
+func checkTitle(b Book, _ ValidationMode) []FieldError {
+	if strings.TrimSpace(b.Title) == "" {
+		return []FieldError{newFieldError("title", "required")}
+	}
+	return nil
+}
+
+// checkDescription limits the length of the description.
+func checkDescription(b Book, _ ValidationMode) []FieldError {
+	if utf8.RuneCountInString(b.Description) > maxDescriptionLength {
+		return []FieldError{newFieldError("description", "max_length", "max", maxDescriptionLength)}
+	}
+	return nil
+}
+
+// checkPrice requires the price to lie within minPrice and maxPrice and to
+// have at most priceDecimals decimal places.
+func checkPrice(b Book, _ ValidationMode) []FieldError {
+	return checkPriceField("price", float64(b.Price))
+}
+
+// checkCostPrice holds the cost price, if set, to the rules of the price.
+func checkCostPrice(b Book, _ ValidationMode) []FieldError {
+	if b.CostPrice == nil {
+		return nil
+	}
+	return checkPriceField("cost_price", float64(*b.CostPrice))
+}
+
+// checkPriceField requires a price field to be between minPrice and
+// maxPrice, in whole cents.
+func checkPriceField(field string, price float64) []FieldError {
+	if math.IsNaN(price) || price < minPrice || price > maxPrice {
+		return []FieldError{newFieldError(field, "price_range", "min", strconv.FormatFloat(minPrice, 'f', -1, 64), "max", strconv.FormatFloat(maxPrice, 'f', -1, 64))}
+	}
+	if roundPrice(price) != price {
+		return []FieldError{newFieldError(field, "precision", "decimals", priceDecimals)}
+	}
+	return nil
+}
+
+// checkLanguageTag requires the language to be a BCP 47 tag, if set.
+func checkLanguageTag(b Book, _ ValidationMode) []FieldError {
+	if b.Language == "" {
+		return nil
+	}
+	if _, err := language.Parse(b.Language); err != nil {
+		return []FieldError{newFieldError("language", "language_tag", "tag", b.Language)}
+	}
+	return nil
+}
+
+// checkCurrencyCode requires the currency to be an ISO 4217 code, if set.
+func checkCurrencyCode(b Book, _ ValidationMode) []FieldError {
+	if b.Currency == "" {
+		return nil
+	}
+	if _, err := currency.ParseISO(b.Currency); err != nil {
+		return []FieldError{newFieldError("currency", "currency_code", "code", b.Currency)}
+	}
+	return nil
+}
+
+// checkISBN requires the ISBN to have a correct check digit, if set.
+func checkISBN(b Book, _ ValidationMode) []FieldError {
+	if _, err := normalizeISBN(b.ISBN); err != nil {
+		return []FieldError{newFieldError("isbn", "isbn", "isbn", b.ISBN)}
+	}
+	return nil
+}
+
+// validationError returns the 422 error reporting errs. Its code and
+// parameters are those of the first error, so clients reading only the
+// code see the same error as before several could be reported.
+func validationError(errs []FieldError) error {
+	return asAPIError(&ValidationError{Fields: errs})
+}
+
+// validateBook checks a book with ValidateBook, reporting any errors as a
+// 422 apiError, and normalizes its fields in place.
+func validateBook(book *Book, mode ValidationMode) error {
+	if errs := ValidateBook(*book, mode); len(errs) > 0 {
+		return validationError(errs)
+	}
+	if book.Price == 0 {
+		book.Price = 0 // drops the sign of negative zero
+	}
+	if book.CostPrice != nil && *book.CostPrice == 0 {
+		*book.CostPrice = 0
+	}
+	lang, err := normalizeLanguage(book.Language)
+	if err != nil {
+		return err
+	}
+	book.Language = lang
+	code, err := normalizeCurrency(book.Currency)
+	if err != nil {
+		return err
+	}
+	book.Currency = code
+	isbn, err := normalizeISBN(book.ISBN)
+	if err != nil {
+		return err
+	}
+	book.ISBN = isbn
+	if err := normalizeEditions(book); err != nil {
+		return err
+	}
+	if len(book.Attributes) == 0 {
+		book.Attributes = nil
+	}
+	return nil
+}
@@ -0,0 +1,149 @@
+package booksapi
+
+import (
+	"math"
+	"net/http"
+	"reflect"
+	"slices"
+	"strings"
+	"testing"
+)
+
+// TestBookRules checks each rule on its own, with a book that passes it and
+// books that break it.
+func TestBookRules(t *testing.T) {
+	setForTest(t, &customAttributes, map[string]int{"shelf_mark": 4})
+	setForTest(t, &requiredOnCreate, []string{"author"})
+	negative := Price(-1)
+	tests := []struct {
+		name string
+		rule bookRule
+		book Book
+		mode ValidationMode
+		want []string // field:rule of each error
+	}{
+		{"ID on create", checkBookID, Book{}, ValidateCreate, nil},
+		{"ID on update", checkBookID, Book{ID: 1}, ValidateUpdate, nil},
+		{"no ID on update", checkBookID, Book{}, ValidateUpdate, []string{"id:min"}},
+		{"title", checkTitle, Book{Title: "Dune"}, ValidateCreate, nil},
+		{"blank title", checkTitle, Book{Title: " \t"}, ValidateCreate, []string{"title:required"}},
+		{"description", checkDescription, Book{Description: strings.Repeat("é", maxDescriptionLength)}, ValidateCreate, nil},
+		{"long description", checkDescription, Book{Description: strings.Repeat("é", maxDescriptionLength+1)}, ValidateCreate, []string{"description:max_length"}},
+		{"price", checkPrice, Book{Price: 12.99}, ValidateCreate, nil},
+		{"maximum price", checkPrice, Book{Price: Price(maxPrice)}, ValidateCreate, nil},
+		{"negative price", checkPrice, Book{Price: -0.01}, ValidateCreate, []string{"price:price_range"}},
+		{"price too high", checkPrice, Book{Price: Price(maxPrice + 1)}, ValidateCreate, []string{"price:price_range"}},
+		{"NaN price", checkPrice, Book{Price: Price(math.NaN())}, ValidateCreate, []string{"price:price_range"}},
+		{"fractional cents", checkPrice, Book{Price: 1.005}, ValidateCreate, []string{"price:precision"}},
+		{"no cost price", checkCostPrice, Book{}, ValidateCreate, nil},
+		{"negative cost price", checkCostPrice, Book{CostPrice: &negative}, ValidateCreate, []string{"cost_price:price_range"}},
+		{"language", checkLanguageTag, Book{Language: "pt-BR"}, ValidateCreate, nil},
+		{"bad language", checkLanguageTag, Book{Language: "not a tag"}, ValidateCreate, []string{"language:language_tag"}},
+		{"currency", checkCurrencyCode, Book{Currency: "eur"}, ValidateCreate, nil},
+		{"bad currency", checkCurrencyCode, Book{Currency: "EURO"}, ValidateCreate, []string{"currency:currency_code"}},
+		{"ISBN", checkISBN, Book{ISBN: "978-0-441-17271-9"}, ValidateCreate, nil},
+		{"bad check digit", checkISBN, Book{ISBN: "978-0-441-17271-8"}, ValidateCreate, []string{"isbn:isbn"}},
+		{"attribute", checkAttributes, Book{Attributes: map[string]string{"shelf_mark": "A12"}}, ValidateCreate, nil},
+		{"unknown and long attributes", checkAttributes, Book{Attributes: map[string]string{"shelf_mark": "A1234", "colour": "red"}}, ValidateCreate, []string{"attributes.colour:attribute", "attributes.shelf_mark:max_length"}},
+		{"required field", checkRequiredFields, Book{Author: "Herbert"}, ValidateCreate, nil},
+		{"missing required field", checkRequiredFields, Book{Author: " "}, ValidateCreate, []string{"author:required"}},
+		{"required field on update", checkRequiredFields, Book{}, ValidateUpdate, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got []string
+			for _, e := range tt.rule(tt.book, tt.mode) {
+				got = append(got, e.Field+":"+e.Rule)
+				if e.Params["field"] != e.Field || e.Message == "" {
+					t.Errorf("error %+v lacks its field or message", e)
+				}
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("errors %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+// TestValidateBook checks that every failing field is reported, in rule
+// order, and that the book is left as it was.
+func TestValidateBook(t *testing.T) {
+	book := Book{Title: "", Price: 1.234, Language: "not a tag", Currency: "eur"}
+	before := book
+	errs := ValidateBook(book, ValidateUpdate)
+	want := []FieldError{
+		{Field: "id", Rule: "min", Message: "id must be at least 1", Params: map[string]string{"field": "id", "min": "1"}},
+		{Field: "title", Rule: "required", Message: "title is required", Params: map[string]string{"field": "title"}},
+		{Field: "price", Rule: "precision", Message: "price may have at most 2 decimal places", Params: map[string]string{"field": "price", "decimals": "2"}},
+		{Field: "language", Rule: "language_tag", Message: "Invalid language tag not a tag", Params: map[string]string{"field": "language", "tag": "not a tag"}},
+	}
+	if !reflect.DeepEqual(errs, want) {
+		t.Errorf("errors %+v, want %+v", errs, want)
+	}
+	if !reflect.DeepEqual(book, before) {
+		t.Errorf("book changed to %+v", book)
+	}
+	if errs := ValidateBook(Book{Title: "Dune", Price: 9.99}, ValidateCreate); errs != nil {
+		t.Errorf("valid book: errors %+v", errs)
+	}
+}
+
+// entryPoint is a request sending a book to an endpoint that validates it.
+type entryPoint struct {
+	name, method, path string
+	body               any
+	header             []string
+}
+
+// bookEntryPoints returns a request sending book to each endpoint that
+// validates books. Book 1 must exist.
+func bookEntryPoints(book map[string]any) []entryPoint {
+	return []entryPoint{
+		{"create", http.MethodPost, "/books", book, nil},
+		{"replace", http.MethodPut, "/books/1", book, nil},
+		{"merge patch", http.MethodPatch, "/books/1", book, []string{"Content-Type", mergePatchType}},
+		{"import", http.MethodPost, "/books/import", []any{map[string]any{"title": "Emma"}, book}, nil},
+		{"transaction", http.MethodPost, "/books/transactions", map[string]any{"operations": []map[string]any{{"op": "create", "book": book}}}, nil},
+	}
+}
+
+// TestValidationEverywhere sends the same invalid book to each entry point
+// that takes books and checks that all of them reject it with the same
+// errors, and that a rule added once is checked by all of them.
+func TestValidationEverywhere(t *testing.T) {
+	h := newTestServer(t)
+	mustCreateBook(t, h, map[string]any{"title": "Dune"})
+	book := map[string]any{"title": " ", "price": 1.234, "language": "not a tag"}
+	want := ValidateBook(Book{Title: " ", Price: 1.234, Language: "not a tag"}, ValidateCreate)
+
+	for _, req := range bookEntryPoints(book) {
+		t.Run(req.name, func(t *testing.T) {
+			rec := serve(t, h, req.method, req.path, req.body, req.header...)
+			wantCode(t, rec, http.StatusUnprocessableEntity)
+			got := decode[errorBody](t, rec).Error
+			if got.Code != "field_required" || got.Params["field"] != "title" {
+				t.Errorf("error %s %v, want field_required for the title", got.Code, got.Params)
+			}
+			if !reflect.DeepEqual(got.Errors, want) {
+				t.Errorf("errors %+v, want %+v", got.Errors, want)
+			}
+		})
+	}
+	if got := decode[Book](t, serve(t, h, http.MethodGet, "/books/1", nil)); got.Title != "Dune" {
+		t.Errorf("book 1 is now %+v", got)
+	}
+
+	setForTest(t, &bookRules, append(slices.Clone(bookRules), func(b Book, _ ValidationMode) []FieldError {
+		if b.Author == "Anonymous" {
+			return []FieldError{newFieldError("author", "required")}
+		}
+		return nil
+	}))
+	for _, req := range bookEntryPoints(map[string]any{"title": "Emma", "author": "Anonymous"}) {
+		rec := serve(t, h, req.method, req.path, req.body, req.header...)
+		wantCode(t, rec, http.StatusUnprocessableEntity)
+		if got := decode[errorBody](t, rec).Error; got.Params["field"] != "author" {
+			t.Errorf("%s: error %s %v, want the new rule's", req.name, got.Code, got.Params)
+		}
+	}
+}
@@ -0,0 +1,96 @@
+package booksapi
+
+import (
+	"fmt"
+	"net/http"
+	"runtime"
+	"runtime/debug"
+	"time"
+)
+
+// Build information, set at link time with
+//
+//	pkg=github.com/MittalPethani/week05_Assignment/booksapi
+//	go build -ldflags "-X $pkg.version=v1.2.0 -X $pkg.commit=$(git rev-parse HEAD) -X $pkg.buildDate=$(date -u +%Y-%m-%dT%H:%M:%SZ)"
+//
+// Values left empty are taken from the build information embedded by the
+// Go toolchain, when it has them.
+var (
+	version   string
+	commit    string
+	buildDate string
+)
+
+// startTime is when the server started, for the uptime in GET /version.
+var startTime = time.Now()
+
+// versionInfo is the response body of GET /version.
+type versionInfo struct {
+	Version       string  `json:"version"`
+	Commit        string  `json:"commit"`
+	BuildDate     string  `json:"build_date"`
+	GoVersion     string  `json:"go_version"`
+	UptimeSeconds float64 `json:"uptime_seconds"`
+}
+
+// buildVersion returns the build information, filling in what -ldflags did
+// not set from debug.ReadBuildInfo. There, the time of the commit stands in
+// for the build date.
+func buildVersion() versionInfo {
+	info := versionInfo{Version: version, Commit: commit, BuildDate: buildDate, GoVersion: runtime.Version()}
+	if bi, ok := debug.ReadBuildInfo(); ok {
+		if info.Version == "" && bi.Main.Version != "" {
+			info.Version = bi.Main.Version
+		}
+		for _, setting := range bi.Settings {
+			switch {
+			case setting.Key == "vcs.revision" && info.Commit == "":
+				info.Commit = setting.Value
+			case setting.Key == "vcs.time" && info.BuildDate == "":
+				info.BuildDate = setting.Value
+			}
+		}
+	}
+	if info.Version == "" {
+		info.Version = "(devel)"
+	}
+	return info
+}
+
+// String returns the build information on one line.
+func (v versionInfo) String() string {
+	s := "books-api " + v.Version
+	if v.Commit != "" {
+		s += " commit " + v.Commit
+	}
+	if v.BuildDate != "" {
+		s += " built " + v.BuildDate
+	}
+	return fmt.Sprintf("%s (%s)", s, v.GoVersion)
+}
+
+// versionHandler reports the build information of the running server and
+// how long it has been up (GET /version).
+func versionHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeError(w, r, http.StatusMethodNotAllowed, "method_not_allowed")
+		return
+	}
+	if _, ok := checkQuery(w, r); !ok {
+		return
+	}
+
+	info := buildVersion()
+	info.UptimeSeconds = time.Since(startTime).Round(time.Millisecond).Seconds()
+	writeJSON(w, http.StatusOK, info)
+}
+
+// serverHeader sets the Server header of every response to the server's
+// name and version.
+func serverHeader(next http.Handler) http.Handler {
+	value := "books-api/" + buildVersion().Version
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Server", value)
+		next.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,69 @@
+package booksapi
+
+import (
+	"net/http"
+	"reflect"
+	"runtime"
+	"slices"
+	"testing"
+)
+
+// TestVersion checks the shape of GET /version and the Server header of
+// every response.
+func TestVersion(t *testing.T) {
+	setForTest(t, &version, "v1.2.3")
+	setForTest(t, &commit, "abc123")
+	setForTest(t, &buildDate, "2024-05-01T12:00:00Z")
+	h := newTestServer(t)
+
+	rec := serve(t, h, http.MethodGet, "/version", nil)
+	wantCode(t, rec, http.StatusOK)
+	fields := decode[map[string]any](t, rec)
+	var keys []string
+	for key := range fields {
+		keys = append(keys, key)
+	}
+	slices.Sort(keys)
+	if want := []string{"build_date", "commit", "go_version", "uptime_seconds", "version"}; !slices.Equal(keys, want) {
+		t.Errorf("fields %q, want %q", keys, want)
+	}
+	info := decode[versionInfo](t, rec)
+	uptime := info.UptimeSeconds
+	info.UptimeSeconds = 0
+	if want := (versionInfo{Version: "v1.2.3", Commit: "abc123", BuildDate: "2024-05-01T12:00:00Z", GoVersion: runtime.Version()}); !reflect.DeepEqual(info, want) {
+		t.Errorf("version %+v, want %+v", info, want)
+	}
+	if uptime < 0 {
+		t.Errorf("uptime %v", uptime)
+	}
+
+	for _, req := range []struct{ method, path string }{
+		{http.MethodGet, "/version"},
+		{http.MethodGet, "/books"},
+		{http.MethodGet, "/books/99"},
+		{http.MethodPost, "/version"},
+		{http.MethodGet, "/nothing"},
+	} {
+		if got := serve(t, h, req.method, req.path, nil).Header().Get("Server"); got != "books-api/v1.2.3" {
+			t.Errorf("%s %s: Server %q, want books-api/v1.2.3", req.method, req.path, got)
+		}
+	}
+}
+
+// TestBuildVersionFallback checks the build information without values set
+// at link time.
+func TestBuildVersionFallback(t *testing.T) {
+	setForTest(t, &version, "")
+	info := buildVersion()
+	if info.Version == "" || info.GoVersion != runtime.Version() {
+		t.Errorf("build information %+v, want a version and %s", info, runtime.Version())
+	}
+
+	want := "books-api v1.2.3 commit abc123 built 2024-05-01T12:00:00Z (go1.0)"
+	if got := (versionInfo{Version: "v1.2.3", Commit: "abc123", BuildDate: "2024-05-01T12:00:00Z", GoVersion: "go1.0"}).String(); got != want {
+		t.Errorf("String() = %q, want %q", got, want)
+	}
+	if got := (versionInfo{Version: "(devel)", GoVersion: "go1.0"}).String(); got != "books-api (devel) (go1.0)" {
+		t.Errorf("String() = %q without a commit or date", got)
+	}
+}
@@ -0,0 +1,99 @@
+package booksapi
+
+import (
+	"net/http"
+	"strings"
+)
+
+// sensitiveField is a book field that only callers granted its scope may
+// read or write. Responses to other callers leave it out.
+type sensitiveField struct {
+	name  string // JSON name, reported in errors
+	scope string
+	clear func(book *Book)
+	equal func(a, b Book) bool
+}
+
+// sensitiveFields are the book fields hidden from callers without their
+// scope.
+var sensitiveFields = []sensitiveField{
+	{
+		name:  "cost_price",
+		scope: scopeWrite,
+		clear: func(book *Book) { book.CostPrice = nil },
+		equal: func(a, b Book) bool {
+			if a.CostPrice == nil || b.CostPrice == nil {
+				return a.CostPrice == b.CostPrice
+			}
+			return *a.CostPrice == *b.CostPrice
+		},
+	},
+}
+
+// callerToken returns the caller's token, or for callers without one the
+// scopes they are granted anyway: every scope without any tokens, and all
+// but admin unless requireTokens is set. It decides what the caller may
+// write; readerToken decides what it sees.
+func callerToken(r *http.Request) apiToken {
+	token, ok := lookupToken(r)
+	switch {
+	case ok:
+	case len(currentConfig().tokens) == 0:
+		token = apiToken{scopes: []string{scopeAdmin}}
+	case !requireTokens:
+		token = apiToken{scopes: []string{scopeWrite}}
+	}
+	return token
+}
+
+// readerToken returns the token whose scopes decide which fields the caller
+// of r sees: its own, or none for callers without one, however open the
+// routes are to them. Sensitive fields are never shown to callers who did
+// not authenticate.
+func readerToken(r *http.Request) apiToken {
+	token, _ := lookupToken(r)
+	return token
+}
+
+// hiddenFields returns the sensitive fields token does not grant.
+func hiddenFields(token apiToken) []sensitiveField {
+	var hidden []sensitiveField
+	for _, field := range sensitiveFields {
+		if !token.grants(field.scope) {
+			hidden = append(hidden, field)
+		}
+	}
+	return hidden
+}
+
+// hiddenFieldNames returns the names of the sensitive fields hidden from
+// the caller, comma-separated, so cached responses are kept apart per
+// visibility.
+func hiddenFieldNames(r *http.Request) string {
+	var names []string
+	for _, field := range hiddenFields(readerToken(r)) {
+		names = append(names, field.name)
+	}
+	return strings.Join(names, ",")
+}
+
+// redactBook returns book without the fields hidden from token. Every
+// response carrying books passes them through it.
+func redactBook(token apiToken, book Book) Book {
+	for _, field := range hiddenFields(token) {
+		field.clear(&book)
+	}
+	return book
+}
+
+// checkFieldWrites rejects with 403 a write by token changing a field
+// hidden from it, prev being the book before the write. Fields left as
+// they were may be sent back unchanged.
+func checkFieldWrites(token apiToken, prev, book Book) error {
+	for _, field := range hiddenFields(token) {
+		if !field.equal(prev, book) {
+			return newAPIError(http.StatusForbidden, "field_forbidden", "field", field.name, "scope", field.scope)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,114 @@
+package booksapi
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+)
+
+// withTokensFile loads a tokens file with a read, a write, and an admin
+// token: r1, w1, and a1.
+func withTokensFile(t *testing.T) {
+	t.Helper()
+	path := writeTokensFile(t, "r1 read\nw1 read,write\na1 admin\n")
+	tokens, err := loadTokens(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	setForTest(t, &tokensFile, path)
+	setForTest(t, &requireTokens, true)
+	setForTest(t, &apiTokens, tokens)
+	liveConfig.Store(flagConfig())
+}
+
+// TestFieldVisibility fetches the same book with read, write, and admin
+// tokens and pins each shape, and checks that every response carrying
+// books hides the cost price from the read token alike.
+func TestFieldVisibility(t *testing.T) {
+	h := newTestServer(t)
+	mustCreateBook(t, h, map[string]any{"title": "Dune", "author": "Frank Herbert", "price": 9.99, "cost_price": 4.5})
+	withTokensFile(t)
+
+	for _, token := range []string{"r1", "w1", "a1"} {
+		rec := serve(t, h, http.MethodGet, "/books/1", nil, "Authorization", "Bearer "+token)
+		wantCode(t, rec, http.StatusOK)
+		checkGolden(t, "visibility/"+token+".json", rec.Body.Bytes())
+	}
+
+	for _, path := range []string{"/books", "/books/1", "/books?fields=id,cost_price", "/books/search?q=dune", "/books/changes", "/books/export"} {
+		for token, visible := range map[string]bool{"r1": false, "w1": true, "a1": true} {
+			rec := serve(t, h, http.MethodGet, path, nil, "Authorization", "Bearer "+token)
+			wantCode(t, rec, http.StatusOK)
+			if got := strings.Contains(rec.Body.String(), `"cost_price"`); got != visible {
+				t.Errorf("GET %s with %s: cost_price shown %v, want %v", path, token, got, visible)
+			}
+		}
+	}
+}
+
+// TestFieldVisibilityAnonymous pins the book fetched without a token in
+// the default deployment, which has no tokens and leaves every route open:
+// the cost price is hidden all the same, everywhere books are shown, while
+// the caller may still set it.
+func TestFieldVisibilityAnonymous(t *testing.T) {
+	h := newTestServer(t)
+	mustCreateBook(t, h, map[string]any{"title": "Dune", "author": "Frank Herbert", "price": 9.99, "cost_price": 4.5})
+
+	rec := serve(t, h, http.MethodGet, "/books/1", nil)
+	wantCode(t, rec, http.StatusOK)
+	checkGolden(t, "visibility/anonymous.json", rec.Body.Bytes())
+	for _, path := range []string{"/books", "/books?fields=id,cost_price", "/books/search?q=dune", "/books/changes", "/books/export"} {
+		rec := serve(t, h, http.MethodGet, path, nil)
+		wantCode(t, rec, http.StatusOK)
+		if strings.Contains(rec.Body.String(), `"cost_price"`) {
+			t.Errorf("GET %s without a token shows the cost price:\n%s", path, rec.Body)
+		}
+	}
+	if book, _ := store.Get(1); book.CostPrice == nil || *book.CostPrice != 4.5 {
+		t.Errorf("stored cost price %v, want 4.5", book.CostPrice)
+	}
+}
+
+// TestFieldWrites checks that a caller may not write a field hidden from
+// it, but may send it back unchanged.
+func TestFieldWrites(t *testing.T) {
+	h := newTestServer(t)
+	mustCreateBook(t, h, map[string]any{"title": "Dune", "price": 9.99, "cost_price": 4.5})
+	withTokensFile(t)
+	// A field only admins may see.
+	adminOnly := sensitiveFields[0]
+	adminOnly.scope = scopeAdmin
+	setForTest(t, &sensitiveFields, []sensitiveField{adminOnly})
+	write := []string{"Authorization", "Bearer w1"}
+
+	forbidden := []struct {
+		name, method, path string
+		body               any
+		header             []string
+	}{
+		{"create", http.MethodPost, "/books", map[string]any{"title": "Emma", "cost_price": 2}, write},
+		{"replace", http.MethodPut, "/books/1", map[string]any{"title": "Dune", "price": 9.99, "cost_price": 3}, write},
+		{"merge patch", http.MethodPatch, "/books/1", map[string]any{"cost_price": 3}, append([]string{"Content-Type", mergePatchType}, write...)},
+		{"import", http.MethodPost, "/books/import", []map[string]any{{"title": "Emma", "cost_price": 2}}, write},
+		{"transaction", http.MethodPost, "/books/transactions", map[string]any{"operations": []map[string]any{{"op": "update", "id": 1, "book": map[string]any{"cost_price": 3}}}}, write},
+	}
+	for _, tt := range forbidden {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := serve(t, h, tt.method, tt.path, tt.body, tt.header...)
+			wantCode(t, rec, http.StatusForbidden)
+			got := decode[errorBody](t, rec).Error
+			if got.Code != "field_forbidden" || got.Params["field"] != "cost_price" || got.Params["scope"] != scopeAdmin {
+				t.Errorf("error %s %v, want field_forbidden naming cost_price", got.Code, got.Params)
+			}
+		})
+	}
+
+	// Fields left alone may be written, keeping the hidden field.
+	wantCode(t, serve(t, h, http.MethodPut, "/books/1", map[string]any{"title": "Dune", "price": 9.99}, write...), http.StatusOK)
+	wantCode(t, serve(t, h, http.MethodPatch, "/books/1", map[string]any{"price": 12}, append([]string{"Content-Type", mergePatchType}, write...)...), http.StatusOK)
+	wantCode(t, serve(t, h, http.MethodPost, "/books", map[string]any{"title": "Emma"}, write...), http.StatusCreated)
+	if dune, _ := store.Get(1); dune.CostPrice == nil || *dune.CostPrice != 4.5 || dune.Price != 12 {
+		t.Errorf("book %+v, want the cost price kept", dune)
+	}
+	wantCode(t, serve(t, h, http.MethodPatch, "/books/1", map[string]any{"cost_price": 3}, "Content-Type", mergePatchType, "Authorization", "Bearer a1"), http.StatusOK)
+}
@@ -0,0 +1,77 @@
+package booksapi
+
+import (
+	"context"
+	"expvar"
+	"net/http"
+	"slices"
+	"strconv"
+)
+
+// warningsIssued counts the warnings sent to clients by kind, published
+// under /debug/vars.
+var warningsIssued = expvar.NewMap("api_warnings")
+
+// warningsKey is the context key of a request's pending warnings.
+type warningsKey struct{}
+
+// apiWarning is a non-fatal problem with a request, reported to the client
+// in a Warning response header.
+type apiWarning struct {
+	kind string
+	text string
+}
+
+// addWarning records a warning for the response to r. kind is a stable
+// identifier used for the metric; text is sent to the client. A warning
+// already recorded is not repeated. It does nothing for requests not served
+// through collectWarnings.
+func addWarning(r *http.Request, kind, text string) {
+	if pending, ok := r.Context().Value(warningsKey{}).(*[]apiWarning); ok {
+		if slices.Contains(*pending, apiWarning{kind, text}) {
+			return
+		}
+		*pending = append(*pending, apiWarning{kind, text})
+		warningsIssued.Add(kind, 1)
+	}
+}
+
+// collectWarnings lets handlers add warnings to a request with addWarning
+// and sends them as Warning headers (RFC 7234, section 5.5) with the
+// miscellaneous persistent warn-code 299 once the response is written.
+func collectWarnings(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		pending := new([]apiWarning)
+		ww := &warningWriter{ResponseWriter: w, pending: pending}
+		next.ServeHTTP(ww, r.WithContext(context.WithValue(r.Context(), warningsKey{}, pending)))
+		ww.flushWarnings()
+	})
+}
+
+// warningWriter adds the pending warnings to the response headers before
+// they are sent.
+type warningWriter struct {
+	http.ResponseWriter
+	pending *[]apiWarning
+	flushed bool
+}
+
+func (w *warningWriter) WriteHeader(status int) {
+	w.flushWarnings()
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *warningWriter) Write(p []byte) (int, error) {
+	w.flushWarnings()
+	return w.ResponseWriter.Write(p)
+}
+
+func (w *warningWriter) flushWarnings() {
+	if w.flushed {
+		return
+	}
+	w.flushed = true
+	for _, warning := range *w.pending {
+		w.Header().Add("Warning", "299 - "+strconv.Quote(warning.text))
+	}
+}